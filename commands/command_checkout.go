@@ -1,9 +1,9 @@
 package commands
 
 import (
-	"io"
-	"os"
-	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/github/git-lfs/git"
@@ -37,69 +37,33 @@ func checkoutCommand(cmd *cobra.Command, args []string) {
 	}
 	close(inchan)
 	checkoutWithIncludeExclude(rootedpaths, nil)
+	checkouts.exitIfFailed("check out")
 }
 
 func init() {
+	checkoutCmd.Flags().BoolVar(&lfs.Config.NoProgress, "no-progress", false, "Don't show the progress meter")
+	checkoutCmd.Flags().BoolVar(&lfs.Config.SkipSpaceCheck, "skip-space-check", false, "Don't check for free disk space first")
 	RootCmd.AddCommand(checkoutCmd)
 }
 
-// Checkout from items reported from the fetch process (in parallel)
-func checkoutAllFromFetchChan(c chan *lfs.WrappedPointer) {
-	tracerx.Printf("starting fetch/parallel checkout")
-	checkoutFromFetchChan(nil, nil, c)
-}
-
-func checkoutFromFetchChan(include []string, exclude []string, in chan *lfs.WrappedPointer) {
-	ref, err := git.CurrentRef()
-	if err != nil {
-		Panic(err, "Could not checkout")
-	}
-	// Need to ScanTree to identify multiple files with the same content (fetch will only report oids once)
-	pointers, err := lfs.ScanTree(ref.Sha)
+// checkoutWithIncludeExclude checks out the Git LFS files staged in the index,
+// so it works before the first commit and writes what git would, with the
+// mode the index has for each.
+func checkoutWithIncludeExclude(include []string, exclude []string) {
+	pointers, err := lfs.ScanIndexTree()
 	if err != nil {
 		Panic(err, "Could not scan for Git LFS files")
 	}
 
-	// Map oid to multiple pointers
-	mapping := make(map[string][]*lfs.WrappedPointer)
+	pointers = skipCaseCollisions(pointers)
+
+	var included []*lfs.WrappedPointer
 	for _, pointer := range pointers {
 		if lfs.FilenamePassesIncludeExcludeFilter(pointer.Name, include, exclude) {
-			mapping[pointer.Oid] = append(mapping[pointer.Oid], pointer)
-		}
-	}
-
-	// Launch git update-index
-	c := make(chan *lfs.WrappedPointer)
-
-	var wait sync.WaitGroup
-	wait.Add(1)
-
-	go func() {
-		checkoutWithChan(c)
-		wait.Done()
-	}()
-
-	// Feed it from in, which comes from fetch
-	for p := range in {
-		// Add all of the files for this oid
-		for _, fp := range mapping[p.Oid] {
-			c <- fp
+			included = append(included, pointer)
 		}
 	}
-	close(c)
-	wait.Wait()
-}
-
-func checkoutWithIncludeExclude(include []string, exclude []string) {
-	ref, err := git.CurrentRef()
-	if err != nil {
-		Panic(err, "Could not checkout")
-	}
-
-	pointers, err := lfs.ScanTree(ref.Sha)
-	if err != nil {
-		Panic(err, "Could not scan for Git LFS files")
-	}
+	checkWorkingTreeSpace(included)
 
 	var wait sync.WaitGroup
 	wait.Add(1)
@@ -118,15 +82,13 @@ func checkoutWithIncludeExclude(include []string, exclude []string) {
 	}
 	progress := lfs.NewProgressMeter(len(pointers), totalBytes, false)
 	progress.Start()
-	totalBytes = 0
 	for _, pointer := range pointers {
-		totalBytes += pointer.Size
 		if lfs.FilenamePassesIncludeExcludeFilter(pointer.Name, include, exclude) {
 			progress.Add(pointer.Name)
 			c <- pointer
 			// not strictly correct (parallel) but we don't have a callback & it's just local
 			// plus only 1 slot in channel so it'll block & be close
-			progress.TransferBytes("checkout", pointer.Name, pointer.Size, totalBytes, int(pointer.Size))
+			progress.TransferBytes("checkout", pointer.Name, pointer.Size, pointer.Size, int(pointer.Size))
 			progress.FinishTransfer(pointer.Name)
 		} else {
 			progress.Skip(pointer.Size)
@@ -142,9 +104,64 @@ func checkoutAll() {
 	checkoutWithIncludeExclude(nil, nil)
 }
 
+// skipCaseCollisions drops pointers whose paths differ only in case from an
+// earlier one when the filesystem ignores case, since they'd be written over
+// each other and the loser would then show as modified. A warning lists them.
+func skipCaseCollisions(pointers []*lfs.WrappedPointer) []*lfs.WrappedPointer {
+	if !lfs.CaseInsensitiveWorkingDir() {
+		return pointers
+	}
+
+	names := make([]string, 0, len(pointers))
+	for _, p := range pointers {
+		names = append(names, p.Name)
+	}
+	collisions := lfs.FindCaseCollisions(names)
+	if len(collisions) == 0 {
+		return pointers
+	}
+
+	Error("Warning: these files differ only in case, and this filesystem can only hold one of each. Checking out the first:")
+	skip := make(map[string]bool)
+	for _, group := range collisions {
+		Error("    %s", strings.Join(group, ", "))
+		for _, name := range group[1:] {
+			skip[name] = true
+		}
+	}
+
+	kept := make([]*lfs.WrappedPointer, 0, len(pointers))
+	for _, p := range pointers {
+		if !skip[p.Name] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// checkWorkingTreeSpace exits if the working tree's filesystem doesn't have
+// room for the files that checking out pointers would write. Files that are
+// already checked out at the right size don't need any more space.
+func checkWorkingTreeSpace(pointers []*lfs.WrappedPointer) {
+	var needed int64
+	for _, p := range pointers {
+		if !lfs.FileExistsOfSize(filepath.Join(lfs.LocalWorkingDir, p.Name), p.Size) {
+			needed += p.Size
+		}
+	}
+
+	if err := lfs.CheckFreeSpace(lfs.LocalWorkingDir, needed); err != nil {
+		Exit("Could not checkout: %s", err)
+	}
+}
+
 // Populate the working copy with the real content of objects where the file is
 // either missing, or contains a matching pointer placeholder, from a list of pointers.
 // If the file exists but has other content it is left alone
+// Files are written by a pool of lfs.checkoutworkers workers, then the index
+// is updated for all of them at once, so git's index lock is never contended
+// and git status doesn't have to check them again. Failures are reported in
+// path order once all files are done.
 // Callers of this function MUST NOT Panic or otherwise exit the process
 // without waiting for this function to shut down.  If the process exits while
 // update-index is in the middle of processing a file the git index can be left
@@ -158,75 +175,68 @@ func checkoutWithChan(in <-chan *lfs.WrappedPointer) {
 		Panic(err, "Could not convert file paths")
 	}
 
-	// Don't fire up the update-index command until we have at least one file to
-	// give it. Otherwise git interprets the lack of arguments to mean param-less update-index
-	// which can trigger entire working copy to be re-examined, which triggers clean filters
-	// and which has unexpected side effects (e.g. downloading filtered-out files)
-	var cmd *exec.Cmd
-	var updateIdxStdin io.WriteCloser
-
-	// From this point on, git update-index is running. Code in this loop MUST
-	// NOT Panic() or otherwise cause the process to exit. If the process exits
-	// while update-index is in the middle of updating, the index can remain in a
-	// locked state.
-
-	// As files come in, write them to the wd and update the index
-	for pointer := range in {
-
-		// Check the content - either missing or still this pointer (not exist is ok)
-		filepointer, err := lfs.DecodePointerFromFile(pointer.Name)
-		if err != nil && !os.IsNotExist(err) {
-			if lfs.IsNotAPointerError(err) {
-				// File has non-pointer content, leave it alone
-				continue
-			}
-			LoggedError(err, "Problem accessing %v", pointer.Name)
-			continue
+	files := make(chan *lfs.CheckoutFile, 1)
+	go func() {
+		for pointer := range in {
+			repopathchan <- pointer.Name
+			files <- &lfs.CheckoutFile{WrappedPointer: pointer, Path: <-cwdpathchan}
 		}
+		close(repopathchan)
+		close(files)
+	}()
 
-		if filepointer != nil && filepointer.Oid != pointer.Oid {
-			// User has probably manually reset a file to another commit
-			// while leaving it a pointer; don't mess with this
+	// As files are written, note them for updating the index
+	var written []string
+	var failed []*lfs.CheckoutResult
+	for result := range lfs.CheckoutFiles(files, lfs.Config.CheckoutWorkers()) {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+		if !result.Written {
 			continue
 		}
-
-		repopathchan <- pointer.Name
-		cwdfilepath := <-cwdpathchan
-
-		err = lfs.PointerSmudgeToFile(cwdfilepath, pointer.Pointer, false, nil)
-		if err != nil {
-			if lfs.IsDownloadDeclinedError(err) {
-				// acceptable error, data not local (fetch not run or include/exclude)
-				LoggedError(err, "Skipped checkout for %v, content not local. Use fetch to download.", pointer.Name)
-			} else {
-				LoggedError(err, "Could not checkout file")
-				continue
-			}
+		if result.Err == nil {
+			checkouts.add(1, 0)
 		}
+		written = append(written, result.Path)
+	}
 
-		if cmd == nil {
-			// Fire up the update-index command
-			cmd = exec.Command("git", "update-index", "-q", "--refresh", "--stdin")
-			updateIdxStdin, err = cmd.StdinPipe()
-			if err != nil {
-				Panic(err, "Could not update the index")
-			}
-
-			if err := cmd.Start(); err != nil {
-				Panic(err, "Could not update the index")
-			}
-
+	// Only update-index the files written, as an update-index without any
+	// paths would re-examine the whole working copy, which triggers clean
+	// filters and has unexpected side effects (e.g. downloading filtered-out
+	// files)
+	// From this point on, git update-index is running. Code here MUST NOT
+	// Panic() or otherwise cause the process to exit. If the process exits
+	// while update-index is in the middle of updating, the index can remain in
+	// a locked state.
+	if len(written) > 0 {
+		if err := git.UpdateIndexBatch(written); err != nil {
+			LoggedError(err, "Error updating the git index")
 		}
-
-		updateIdxStdin.Write([]byte(cwdfilepath + "\n"))
 	}
-	close(repopathchan)
 
-	if cmd != nil && updateIdxStdin != nil {
-		updateIdxStdin.Close()
-		if err := cmd.Wait(); err != nil {
-			outp, _ := cmd.CombinedOutput()
-			LoggedError(err, "Error updating the git index\n%v", string(outp))
+	// Files lfs.fetchinclude and lfs.fetchexclude leave out are meant to stay
+	// as pointers, so it's no error that their content isn't local
+	fetchInclude, fetchExclude := lfs.Config.FetchIncludePaths(), lfs.Config.FetchExcludePaths()
+
+	sort.Sort(checkoutResultsByName(failed))
+	for _, result := range failed {
+		if lfs.IsDownloadDeclinedError(result.Err) {
+			if !lfs.FilenamePassesIncludeExcludeFilter(result.Name, fetchInclude, fetchExclude) {
+				tracerx.Printf("Skipped checkout for %v, excluded from fetching", result.Name)
+				continue
+			}
+			// acceptable error, data not local (fetch not run or include/exclude)
+			LoggedError(result.Err, "Skipped checkout for %v, content not local. Use fetch to download.", result.Name)
+		} else {
+			LoggedError(result.Err, "%s", result.Err)
+			checkouts.add(0, 1)
 		}
 	}
 }
+
+type checkoutResultsByName []*lfs.CheckoutResult
+
+func (r checkoutResultsByName) Len() int           { return len(r) }
+func (r checkoutResultsByName) Less(i, j int) bool { return r[i].Name < r[j].Name }
+func (r checkoutResultsByName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
@@ -39,7 +39,7 @@ func cleanCommand(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	cleaned, err := lfs.PointerClean(os.Stdin, fileName, fileSize, cb)
+	cleaned, err := lfs.PointerClean(lfs.NamedReader(os.Stdin, fileName), fileSize, cb)
 	if file != nil {
 		file.Close()
 	}
@@ -69,7 +69,7 @@ func cleanCommand(cmd *cobra.Command, args []string) {
 		}
 		Debug("%s exists", mediafile)
 	} else {
-		if err := os.Rename(tmpfile, mediafile); err != nil {
+		if err := lfs.IngestObject(tmpfile, cleaned.Oid, cleaned.Size, true); err != nil {
 			Panic(err, "Unable to move %s to %s\n", tmpfile, mediafile)
 		}
 
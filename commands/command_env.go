@@ -1,6 +1,9 @@
 package commands
 
 import (
+	"net/url"
+	"strings"
+
 	"github.com/github/git-lfs/git"
 	"github.com/github/git-lfs/lfs"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
@@ -11,12 +14,15 @@ var (
 		Use: "env",
 		Run: envCommand,
 	}
+
+	envDiskUsageArg bool
 )
 
 func envCommand(cmd *cobra.Command, args []string) {
 	lfs.ShowConfigWarnings = true
 	config := lfs.Config
-	endpoint := config.Endpoint("download")
+	endpoint, source := config.EndpointSource("download")
+	pushEndpoint, pushSource := config.EndpointSource("upload")
 
 	gitV, err := git.Config.Version()
 	if err != nil {
@@ -28,15 +34,28 @@ func envCommand(cmd *cobra.Command, args []string) {
 	Print("")
 
 	if len(endpoint.Url) > 0 {
-		Print("Endpoint=%s (auth=%s)", endpoint.Url, config.EndpointAccess(endpoint))
+		Print("Endpoint=%s (auth=%s)", lfs.RedactURLPassword(endpoint.Url), config.EndpointAccess(endpoint))
+		Print("  Source=%s", describeEndpointSource(source))
 		if len(endpoint.SshUserAndHost) > 0 {
 			Print("  SSH=%s:%s", endpoint.SshUserAndHost, endpoint.SshPath)
 		}
+		printProxy(endpoint.Url)
+	}
+
+	if len(pushEndpoint.Url) > 0 {
+		Print("PushEndpoint=%s (auth=%s)", lfs.RedactURLPassword(pushEndpoint.Url), config.EndpointAccess(pushEndpoint))
+		Print("  Source=%s", describeEndpointSource(pushSource))
+		if len(pushEndpoint.SshUserAndHost) > 0 {
+			Print("  SSH=%s:%s", pushEndpoint.SshUserAndHost, pushEndpoint.SshPath)
+		}
+		if pushEndpoint.Url != endpoint.Url {
+			printProxy(pushEndpoint.Url)
+		}
 	}
 
 	for _, remote := range config.Remotes() {
 		remoteEndpoint := config.RemoteEndpoint(remote, "download")
-		Print("Endpoint (%s)=%s (auth=%s)", remote, remoteEndpoint.Url, config.EndpointAccess(remoteEndpoint))
+		Print("Endpoint (%s)=%s (auth=%s)", remote, lfs.RedactURLPassword(remoteEndpoint.Url), config.EndpointAccess(remoteEndpoint))
 		if len(remoteEndpoint.SshUserAndHost) > 0 {
 			Print("  SSH=%s:%s", remoteEndpoint.SshUserAndHost, remoteEndpoint.SshPath)
 		}
@@ -50,8 +69,56 @@ func envCommand(cmd *cobra.Command, args []string) {
 		value, _ := lfs.Config.GitConfig(key)
 		Print("git config %s = %q", key, value)
 	}
+	if lfs.FiltersInstalled() {
+		Print("FiltersInstalled=true")
+	} else {
+		Print("FiltersInstalled=false (run git lfs install)")
+	}
+
+	if envDiskUsageArg && lfs.InRepo() {
+		count, size, err := lfs.LocalStorageUsage()
+		if err != nil {
+			Exit("Error reading local storage: %s", err)
+		}
+		Print("LocalMediaUsage=%d objects, %s", count, lfs.FormatSize(size))
+	}
+}
+
+// describeEndpointSource says where an endpoint came from, noting when it was
+// guessed from a git remote's url rather than set for Git LFS.
+func describeEndpointSource(source string) string {
+	if strings.HasPrefix(source, "remote.") && (strings.HasSuffix(source, ".url") || strings.HasSuffix(source, ".pushurl")) {
+		return source + " (guessed)"
+	}
+	return source
+}
+
+// printProxy shows the proxy for requests to rawurl, if there is one, without
+// its password.
+func printProxy(rawurl string) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return
+	}
+
+	proxy, source, err := lfs.ProxyForUrl(u)
+	if err != nil {
+		Print("  Proxy=%s", err)
+		return
+	}
+	if proxy == nil {
+		return
+	}
+
+	if proxy.User != nil {
+		if _, ok := proxy.User.Password(); ok {
+			proxy.User = url.UserPassword(proxy.User.Username(), "xxxxx")
+		}
+	}
+	Print("  Proxy=%s (from %s)", proxy, source)
 }
 
 func init() {
+	envCmd.Flags().BoolVarP(&envDiskUsageArg, "du", "", false, "Show the total size of locally stored objects")
 	RootCmd.AddCommand(envCmd)
 }
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/github/git-lfs/lfs"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
@@ -18,6 +19,12 @@ var (
 		Short: "View details for specified extensions",
 		Run:   extListCommand,
 	}
+
+	extTestCmd = &cobra.Command{
+		Use:   "test",
+		Short: "Run a file through the extensions without storing it",
+		Run:   extTestCommand,
+	}
 )
 
 func extCommand(cmd *cobra.Command, args []string) {
@@ -38,6 +45,30 @@ func extListCommand(cmd *cobra.Command, args []string) {
 	}
 }
 
+func extTestCommand(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		ExitUsage("Usage: git lfs ext test <file>")
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		Exit("Unable to open %q: %s", args[0], err)
+	}
+	defer file.Close()
+
+	stages, err := lfs.DryRunExtensions(lfs.Config.Extensions(), file, args[0])
+	if err != nil {
+		Exit(err.Error())
+	}
+
+	for _, stage := range stages {
+		Print("Extension: %s", stage.Name)
+		Print("    priority = %d", stage.Priority)
+		Print("    in = %s (%d bytes)", stage.OidIn, stage.SizeIn)
+		Print("    out = %s (%d bytes)", stage.OidOut, stage.SizeOut)
+	}
+}
+
 func printAllExts() {
 	config := lfs.Config
 
@@ -59,6 +90,6 @@ func printExt(ext lfs.Extension) {
 }
 
 func init() {
-	extCmd.AddCommand(extListCmd)
+	extCmd.AddCommand(extListCmd, extTestCmd)
 	RootCmd.AddCommand(extCmd)
 }
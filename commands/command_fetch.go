@@ -2,6 +2,7 @@ package commands
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/github/git-lfs/git"
@@ -24,13 +25,15 @@ var (
 
 func fetchCommand(cmd *cobra.Command, args []string) {
 	requireInRepo()
+	clearTempObjects()
+	useTransfersJSON()
 
 	var refs []*git.Ref
 
 	if len(args) > 0 {
 		// Remote is first arg
 		if err := git.ValidateRemote(args[0]); err != nil {
-			Exit("Invalid remote name %q", args[0])
+			ExitUsage("Invalid remote name %q", args[0])
 		}
 		lfs.Config.CurrentRemote = args[0]
 	} else {
@@ -45,8 +48,11 @@ func fetchCommand(cmd *cobra.Command, args []string) {
 	if len(args) > 1 {
 		for _, r := range args[1:] {
 			ref, err := git.ResolveRef(r)
+			if git.IsUnknownRevisionError(err) {
+				ExitUsage("Invalid ref argument %q: no such branch, tag or commit", r)
+			}
 			if err != nil {
-				Panic(err, "Invalid ref argument")
+				ExitUsage("Invalid ref argument %q: %s", r, err)
 			}
 			refs = append(refs, ref)
 		}
@@ -61,10 +67,10 @@ func fetchCommand(cmd *cobra.Command, args []string) {
 	success := true
 	if fetchAllArg {
 		if fetchRecentArg || len(args) > 1 {
-			Exit("Cannot combine --all with ref arguments or --recent")
+			ExitUsage("Cannot combine --all with ref arguments or --recent")
 		}
 		if fetchIncludeArg != "" || fetchExcludeArg != "" {
-			Exit("Cannot combine --all with --include or --exclude")
+			ExitUsage("Cannot combine --all with --include or --exclude")
 		}
 		if len(lfs.Config.FetchIncludePaths()) > 0 || len(lfs.Config.FetchExcludePaths()) > 0 {
 			Print("Ignoring global include / exclude paths to fulfil --all")
@@ -74,26 +80,37 @@ func fetchCommand(cmd *cobra.Command, args []string) {
 	} else { // !all
 		includePaths, excludePaths := determineIncludeExcludePaths(fetchIncludeArg, fetchExcludeArg)
 
-		// Fetch refs sequentially per arg order; duplicates in later refs will be ignored
+		// Scan all the refs before fetching, so an object referenced by more
+		// than one of them is only downloaded once
+		var pointers []*lfs.WrappedPointer
 		for _, ref := range refs {
 			Print("Fetching %v", ref.Name)
-			s := fetchRef(ref.Sha, includePaths, excludePaths)
-			success = success && s
+			pointers = append(pointers, scanRefForFetch(ref.Sha)...)
 		}
 
 		if fetchRecentArg || lfs.Config.FetchPruneConfig().FetchRecentAlways {
-			s := fetchRecent(refs, includePaths, excludePaths)
-			success = success && s
+			pointers = append(pointers, scanRecentForFetch(refs)...)
 		}
+
+		success = fetchPointers(pointers, includePaths, excludePaths)
 	}
 
+	fetched.print()
+
 	if fetchPruneArg {
-		verify := lfs.Config.FetchPruneConfig().PruneVerifyRemoteAlways
-		// no dry-run or verbose options in fetch, assume false
-		prune(verify, false, false)
+		if lfs.LocalStorageShared {
+			Print("Not pruning, LFS storage is shared with other repositories (see git lfs prune --force-shared)")
+		} else {
+			verify := lfs.Config.FetchPruneConfig().PruneVerifyRemoteAlways
+			// no dry-run or verbose options in fetch, assume false
+			prune(verify, false, false, false)
+		}
 	}
 
+	printTransfersJSON()
+
 	if !success {
+		transfers.exitIfFailed("download")
 		Exit("Warning: errors occurred")
 	}
 }
@@ -104,6 +121,9 @@ func init() {
 	fetchCmd.Flags().BoolVarP(&fetchRecentArg, "recent", "r", false, "Fetch recent refs & commits")
 	fetchCmd.Flags().BoolVarP(&fetchAllArg, "all", "a", false, "Fetch all LFS files ever referenced")
 	fetchCmd.Flags().BoolVarP(&fetchPruneArg, "prune", "p", false, "After fetching, prune old data")
+	fetchCmd.Flags().BoolVar(&lfs.Config.NoProgress, "no-progress", false, "Don't show the progress meter")
+	fetchCmd.Flags().BoolVar(&lfs.Config.SkipSpaceCheck, "skip-space-check", false, "Don't check for free disk space first")
+	fetchCmd.Flags().BoolVar(&transfersJSON, "json", false, "Print the results as JSON")
 	RootCmd.AddCommand(fetchCmd)
 }
 
@@ -115,46 +135,34 @@ func pointersToFetchForRef(ref string) ([]*lfs.WrappedPointer, error) {
 	return lfs.ScanRefs(ref, "", opts)
 }
 
-func fetchRefToChan(ref string, include, exclude []string) chan *lfs.WrappedPointer {
-	c := make(chan *lfs.WrappedPointer)
-	pointers, err := pointersToFetchForRef(ref)
-	if err != nil {
-		Panic(err, "Could not scan for Git LFS files")
-	}
-
-	go fetchAndReportToChan(pointers, include, exclude, c)
-
-	return c
-}
-
-// Fetch all binaries for a given ref (that we don't have already)
-func fetchRef(ref string, include, exclude []string) bool {
+// Scan for all binaries for a given ref
+func scanRefForFetch(ref string) []*lfs.WrappedPointer {
 	pointers, err := pointersToFetchForRef(ref)
 	if err != nil {
 		Panic(err, "Could not scan for Git LFS files")
 	}
-	return fetchPointers(pointers, include, exclude)
+	return pointers
 }
 
-// Fetch all previous versions of objects from since to ref (not including final state at ref)
-// So this will fetch all the '-' sides of the diff from since to ref
-func fetchPreviousVersions(ref string, since time.Time, include, exclude []string) bool {
+// Scan for all previous versions of objects from since to ref (not including final state at ref)
+// So this will find all the '-' sides of the diff from since to ref
+func scanPreviousVersionsForFetch(ref string, since time.Time) []*lfs.WrappedPointer {
 	pointers, err := lfs.ScanPreviousVersions(ref, since)
 	if err != nil {
 		Panic(err, "Could not scan for Git LFS previous versions")
 	}
-	return fetchPointers(pointers, include, exclude)
+	return pointers
 }
 
-// Fetch recent objects based on config
-func fetchRecent(alreadyFetchedRefs []*git.Ref, include, exclude []string) bool {
+// Scan for recent objects based on config
+func scanRecentForFetch(alreadyFetchedRefs []*git.Ref) []*lfs.WrappedPointer {
 	fetchconf := lfs.Config.FetchPruneConfig()
 
 	if fetchconf.FetchRecentRefsDays == 0 && fetchconf.FetchRecentCommitsDays == 0 {
-		return true
+		return nil
 	}
 
-	ok := true
+	var pointers []*lfs.WrappedPointer
 	// Make a list of what unique commits we've already fetched for to avoid duplicating work
 	uniqueRefShas := make(map[string]string, len(alreadyFetchedRefs))
 	for _, ref := range alreadyFetchedRefs {
@@ -177,8 +185,7 @@ func fetchRecent(alreadyFetchedRefs []*git.Ref, include, exclude []string) bool
 			} else {
 				uniqueRefShas[ref.Sha] = ref.Name
 				Print("Fetching %v", ref.Name)
-				k := fetchRef(ref.Sha, include, exclude)
-				ok = ok && k
+				pointers = append(pointers, scanRefForFetch(ref.Sha)...)
 			}
 		}
 	}
@@ -193,18 +200,53 @@ func fetchRecent(alreadyFetchedRefs []*git.Ref, include, exclude []string) bool
 			}
 			Print("Fetching changes within %v days of %v", fetchconf.FetchRecentCommitsDays, refName)
 			commitsSince := summ.CommitDate.AddDate(0, 0, -fetchconf.FetchRecentCommitsDays)
-			k := fetchPreviousVersions(commit, commitsSince, include, exclude)
-			ok = ok && k
+			pointers = append(pointers, scanPreviousVersionsForFetch(commit, commitsSince)...)
 		}
 
 	}
-	return ok
+	return pointers
 }
 
+// fetchAll downloads every object ever referenced. There may be millions, so
+// they're queued as they're found rather than listed first: downloads start
+// straight away, and the queue only holds a bounded number at once.
 func fetchAll() bool {
-	pointers := scanAll()
-	Print("Fetching objects...")
-	return fetchPointers(pointers, nil, nil)
+	// converts to `git rev-list --all`
+	// We only pick up objects in real commits and not the reflog
+	opts := lfs.NewScanRefsOptions()
+	opts.ScanMode = lfs.ScanAllMode
+	opts.SkipDeletedBlobs = false
+
+	Print("Fetching all objects ever referenced...")
+	pointerchan, err := lfs.ScanRefsToChan("", "", opts)
+	if err != nil {
+		Panic(err, "Could not scan for Git LFS files")
+	}
+
+	q := lfs.NewDownloadQueue(0, 0, false)
+	for p := range pointerchan.Results {
+		if lfs.ObjectExistsOfSize(p.Oid, p.Size) {
+			tracerx.Printf("Skipping %v [%v], already exists", p.Name, p.Oid)
+			transfers.skip(p, lfs.SkippedAlreadyPresent)
+			fetched.addPresent(p)
+			continue
+		}
+
+		tracerx.Printf("fetch %v [%v]", p.Name, p.Oid)
+		q.Grow(1, p.Size)
+		q.Add(lfs.NewDownloadable(p))
+	}
+	scanErr := pointerchan.Wait()
+
+	q.Wait()
+	transfers.addQueue(q)
+	fetched.addQueue(q)
+	printTransferErrors(q.Errors())
+
+	if scanErr != nil {
+		Panic(scanErr, "Could not scan for Git LFS files")
+	}
+	return len(q.Errors()) == 0
 }
 
 func scanAll() []*lfs.WrappedPointer {
@@ -245,13 +287,20 @@ func fetchPointers(pointers []*lfs.WrappedPointer, include, exclude []string) bo
 
 // Fetch and report completion of each OID to a channel (optional, pass nil to skip)
 // Returns true if all completed with no errors, false if errors were written to stderr/log
+// An object referenced at more than one path is only downloaded once, and
+// reported for each of them.
 func fetchAndReportToChan(pointers []*lfs.WrappedPointer, include, exclude []string, out chan<- *lfs.WrappedPointer) bool {
+	oids := make(map[string]bool, len(pointers))
 	totalSize := int64(0)
 	for _, p := range pointers {
-		totalSize += p.Size
+		if !oids[p.Oid] {
+			oids[p.Oid] = true
+			totalSize += p.Size
+		}
 	}
-	q := lfs.NewDownloadQueue(len(pointers), totalSize, false)
+	q := lfs.NewDownloadQueue(len(oids), totalSize, false)
 
+	watched := make(chan struct{})
 	if out != nil {
 		dlwatch := q.Watch()
 
@@ -273,23 +322,37 @@ func fetchAndReportToChan(pointers []*lfs.WrappedPointer, include, exclude []str
 					out <- p
 				}
 			}
-			close(out)
+			close(watched)
 		}()
 	}
 
+	queued := make(map[string]bool, len(pointers))
+	present := make(map[string]bool)
 	for _, p := range pointers {
+		if queued[p.Oid] {
+			// reported with the others once it's downloaded
+			continue
+		}
+
 		// Only add to download queue if local file is not the right size already
 		// This avoids previous case of over-reporting a requirement for files we already have
 		// which would only be skipped by PointerSmudgeObject later
 		passFilter := lfs.FilenamePassesIncludeExcludeFilter(p.Name, include, exclude)
 		if !lfs.ObjectExistsOfSize(p.Oid, p.Size) && passFilter {
 			tracerx.Printf("fetch %v [%v]", p.Name, p.Oid)
+			queued[p.Oid] = true
 			q.Add(lfs.NewDownloadable(p))
 		} else {
 			if !passFilter {
 				tracerx.Printf("Skipping %v [%v], include/exclude filters applied", p.Name, p.Oid)
+				transfers.skip(p, lfs.SkippedExcluded)
 			} else {
 				tracerx.Printf("Skipping %v [%v], already exists", p.Name, p.Oid)
+				transfers.skip(p, lfs.SkippedAlreadyPresent)
+				if !present[p.Oid] {
+					present[p.Oid] = true
+					fetched.addPresent(p)
+				}
 			}
 
 			// If we already have it, or it won't be fetched
@@ -304,11 +367,49 @@ func fetchAndReportToChan(pointers []*lfs.WrappedPointer, include, exclude []str
 	processQueue := time.Now()
 	q.Wait()
 	tracerx.PerformanceSince("process queue", processQueue)
+	transfers.addQueue(q)
+	fetched.addQueue(q)
 
-	ok := true
-	for _, err := range q.Errors() {
-		ok = false
-		ExitWithError(err)
+	// out is closed once the results are in, so readers can rely on them
+	if out != nil {
+		<-watched
+		close(out)
 	}
-	return ok
+
+	printTransferErrors(q.Errors())
+	return len(q.Errors()) == 0
+}
+
+// fetchSummary counts the objects fetch downloaded, and those it didn't need
+// to as they were already present, for the summary it prints at the end.
+type fetchSummary struct {
+	mutex        sync.Mutex
+	fetched      int
+	fetchedBytes int64
+	present      int
+	presentBytes int64
+}
+
+var fetched = &fetchSummary{}
+
+func (s *fetchSummary) addQueue(q *lfs.TransferQueue) {
+	s.mutex.Lock()
+	s.fetched += q.Transferred()
+	s.fetchedBytes += q.TransferredBytes()
+	s.mutex.Unlock()
+}
+
+func (s *fetchSummary) addPresent(p *lfs.WrappedPointer) {
+	s.mutex.Lock()
+	s.present++
+	s.presentBytes += p.Size
+	s.mutex.Unlock()
+}
+
+// print prints the summary, eg:
+// Fetched 12 objects (1.5 MiB), 40 already present (9.2 MiB)
+func (s *fetchSummary) print() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	Print("Fetched %d objects (%s), %d already present (%s)", s.fetched, lfs.FormatSize(s.fetchedBytes), s.present, lfs.FormatSize(s.presentBytes))
 }
@@ -1,19 +1,20 @@
 package commands
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"io"
+	"encoding/json"
 	"os"
-	"path/filepath"
+	"sort"
 
 	"github.com/github/git-lfs/git"
 	"github.com/github/git-lfs/lfs"
+	"github.com/github/git-lfs/localstorage"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
 )
 
 var (
-	fsckDryRun bool
+	fsckDryRun     bool
+	fsckQuarantine bool
+	fsckJSON       bool
 
 	fsckCmd = &cobra.Command{
 		Use: "fsck",
@@ -21,12 +22,38 @@ var (
 	}
 )
 
-func doFsck() (bool, error) {
+// An fsckProblem is an object which is missing from the local storage, whose
+// content doesn't match its OID, or which can't be read.
+type fsckProblem struct {
+	Oid  string `json:"oid"`
+	Name string `json:"name,omitempty"`
+	// Problem is "missing", "corrupt", "empty" or "unreadable".
+	Problem   string `json:"problem"`
+	ActualOid string `json:"actual_oid,omitempty"`
+	// Error is why an unreadable object couldn't be read.
+	Error string `json:"error,omitempty"`
+	// HardLinked is set for a corrupt object which is hard linked to other
+	// files, which may have changed it.
+	HardLinked bool   `json:"hard_linked,omitempty"`
+	MovedTo    string `json:"moved_to,omitempty"`
+	Deleted    bool   `json:"deleted,omitempty"`
+}
+
+type fsckResult struct {
+	Checked  int            `json:"checked"`
+	Problems []*fsckProblem `json:"problems"`
+}
+
+// doFsck checks that the objects referenced by the current commit and the
+// index are present, and that every object in the local storage hashes to its
+// OID. Bad objects are quarantined or deleted unless fsckDryRun is set, except
+// for unreadable ones, which can't be known to be bad.
+func doFsck() (*fsckResult, error) {
 	requireInRepo()
 
 	ref, err := git.CurrentRef()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	// The LFS scanner methods return unexported *lfs.wrappedPointer objects.
@@ -35,7 +62,7 @@ func doFsck() (bool, error) {
 
 	pointers, err := lfs.ScanRefs(ref.Sha, "", nil)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	for _, p := range pointers {
@@ -45,60 +72,74 @@ func doFsck() (bool, error) {
 	// TODO(zeroshirts): do we want to look for LFS stuff in past commits?
 	p2, err := lfs.ScanIndex()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	for _, p := range p2 {
 		pointerIndex[p.Oid] = p.Name
 	}
 
-	ok := true
+	result := &fsckResult{Problems: make([]*fsckProblem, 0)}
 
-	for oid, name := range pointerIndex {
-		path := filepath.Join(lfs.LocalMediaDir, oid[0:2], oid[2:4], oid)
+	oids := make([]string, 0, len(pointerIndex))
+	for oid := range pointerIndex {
+		oids = append(oids, oid)
+	}
+	sort.Strings(oids)
 
-		Debug("Examining %v (%v)", name, path)
+	for _, oid := range oids {
+		path := lfs.LocalMediaPathReadOnly(oid)
+		Debug("Examining %v (%v)", pointerIndex[oid], path)
 
-		f, err := os.Open(path)
-		if pErr, pOk := err.(*os.PathError); pOk {
-			Print("Object %s (%s) could not be checked: %s", name, oid, pErr.Err)
-			ok = false
-			continue
-		}
-		if err != nil {
-			return false, err
+		if _, err := os.Stat(path); err != nil {
+			result.Checked++
+			result.Problems = append(result.Problems, &fsckProblem{Oid: oid, Name: pointerIndex[oid], Problem: "missing"})
 		}
+	}
 
-		oidHash := sha256.New()
-		_, err = io.Copy(oidHash, f)
-		f.Close()
-		if err != nil {
-			return false, err
+	checked, bad, err := lfs.VerifyLocalObjects()
+	if err != nil {
+		return nil, err
+	}
+	result.Checked += checked
+
+	sort.Sort(badObjectsByOid(bad))
+	for _, o := range bad {
+		problem := &fsckProblem{Oid: o.Oid, Name: pointerIndex[o.Oid], Problem: "corrupt", ActualOid: o.ActualOid, HardLinked: o.HardLinked}
+		if o.Err != nil {
+			problem.Problem = "unreadable"
+			problem.Error = o.Err.Error()
+		} else if o.Empty() {
+			problem.Problem = "empty"
 		}
+		result.Problems = append(result.Problems, problem)
 
-		recalculatedOid := hex.EncodeToString(oidHash.Sum(nil))
-		if recalculatedOid != oid {
-			ok = false
-			Print("Object %s (%s) is corrupt", name, oid)
-			if fsckDryRun {
-				continue
-			}
+		if fsckDryRun || o.Err != nil {
+			continue
+		}
 
-			badDir := filepath.Join(lfs.LocalGitStorageDir, "lfs", "bad")
-			if err := os.MkdirAll(badDir, 0755); err != nil {
-				return false, err
+		if fsckQuarantine {
+			if problem.MovedTo, err = lfs.QuarantineObject(o.Oid); err != nil {
+				return result, err
 			}
+			continue
+		}
 
-			badFile := filepath.Join(badDir, oid)
-			if err := os.Rename(path, badFile); err != nil {
-				return false, err
-			}
-			Print("  moved to %s", badFile)
+		if err := os.Remove(o.Path); err != nil {
+			return result, err
 		}
+		problem.Deleted = true
 	}
-	return ok, nil
+
+	return result, nil
 }
 
+type badObjectsByOid []*localstorage.BadObject
+
+func (b badObjectsByOid) Len() int           { return len(b) }
+func (b badObjectsByOid) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b badObjectsByOid) Less(i, j int) bool { return b[i].Oid < b[j].Oid }
+
 // TODO(zeroshirts): 'git fsck' reports status (percentage, current#/total) as
 // it checks... we should do the same, as we are rehashing potentially gigs and
 // gigs of content.
@@ -108,17 +149,54 @@ func doFsck() (bool, error) {
 func fsckCommand(cmd *cobra.Command, args []string) {
 	lfs.InstallHooks(false)
 
-	ok, err := doFsck()
+	result, err := doFsck()
 	if err != nil {
 		Panic(err, "Error checking Git LFS files")
 	}
 
-	if ok {
+	if fsckJSON {
+		if err := json.NewEncoder(OutputWriter).Encode(result); err != nil {
+			Panic(err, "Error writing fsck results")
+		}
+	} else {
+		printFsckProblems(result.Problems)
+	}
+
+	if bad := len(result.Problems); bad > 0 {
+		Exit("Git LFS fsck failed: %d of %d objects are missing, corrupt or unreadable", bad, result.Checked)
+	}
+	if !fsckJSON {
 		Print("Git LFS fsck OK")
 	}
 }
 
+func printFsckProblems(problems []*fsckProblem) {
+	for _, p := range problems {
+		if len(p.Name) > 0 {
+			Print("Object %s (%s) is %s", p.Name, p.Oid, p.Problem)
+		} else {
+			Print("Object %s is %s", p.Oid, p.Problem)
+		}
+
+		if len(p.Error) > 0 {
+			Print("  %s", p.Error)
+		}
+
+		if p.HardLinked {
+			Print("  hard linked to other files, which may have changed it (see lfs.checkoutmode)")
+		}
+
+		if len(p.MovedTo) > 0 {
+			Print("  moved to %s", p.MovedTo)
+		} else if p.Deleted {
+			Print("  deleted")
+		}
+	}
+}
+
 func init() {
 	fsckCmd.Flags().BoolVarP(&fsckDryRun, "dry-run", "d", false, "List corrupt objects without deleting them.")
+	fsckCmd.Flags().BoolVar(&fsckQuarantine, "quarantine", true, "Move corrupt objects to .git/lfs/bad rather than deleting them.")
+	fsckCmd.Flags().BoolVar(&fsckJSON, "json", false, "Print the results as JSON.")
 	RootCmd.AddCommand(fsckCmd)
 }
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/github/git-lfs/git"
+	"github.com/github/git-lfs/lfs"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
+)
+
+var (
+	lockCmd = &cobra.Command{
+		Use: "lock",
+		Run: lockCommand,
+	}
+	lockRemote = ""
+)
+
+func lockCommand(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		Print("Usage: git lfs lock <path>")
+		return
+	}
+
+	setLockRemote(lockRemote)
+	path := lockPath(args[0])
+
+	lock, err := lfs.LockFile(path)
+	if err != nil {
+		exitLockError("Lock failed", err)
+	}
+
+	if err := lfs.CacheLock(lock); err != nil {
+		Error("warning: unable to record lock: %s", err)
+	}
+	Print("Locked %s", path)
+}
+
+// setLockRemote sets the remote whose server holds the locks, validating it if
+// it was given.
+func setLockRemote(remote string) {
+	if len(remote) == 0 {
+		return
+	}
+	if err := git.ValidateRemote(remote); err != nil {
+		Exit("Invalid remote name %q", remote)
+	}
+	lfs.Config.CurrentRemote = remote
+}
+
+// lockPath returns path, relative to the current dir, as the server knows it:
+// relative to the root of the repo, with forward slashes.
+func lockPath(path string) string {
+	if lfs.LocalWorkingDir == "" {
+		Exit("This operation must be run in a work tree.")
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		Exit("Unable to resolve %q: %s", path, err)
+	}
+
+	rel, err := filepath.Rel(lfs.LocalWorkingDir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		Exit("%q is outside of the git working directory %q.", path, lfs.LocalWorkingDir)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// exitLockError exits with a message for an error from the locks API.
+func exitLockError(what string, err error) {
+	if lfs.IsNotImplementedError(err) {
+		Exit("%s: the Git LFS server at %s does not support locking", what, lfs.Config.Endpoint("upload").Url)
+	}
+	Exit("%s: %s", what, err)
+}
+
+func init() {
+	lockCmd.Flags().StringVarP(&lockRemote, "remote", "r", "", "The remote whose server holds the lock")
+	RootCmd.AddCommand(lockCmd)
+}
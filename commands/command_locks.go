@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"github.com/github/git-lfs/git"
+	"github.com/github/git-lfs/lfs"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
+)
+
+var (
+	locksCmd = &cobra.Command{
+		Use: "locks",
+		Run: locksCommand,
+	}
+	locksRemote = ""
+	locksPath   = ""
+	locksId     = ""
+	locksLimit  = 0
+	locksLocal  = false
+)
+
+func locksCommand(cmd *cobra.Command, args []string) {
+	setLockRemote(locksRemote)
+
+	path := locksPath
+	if len(path) > 0 {
+		path = lockPath(path)
+	}
+
+	var locks []*lfs.Lock
+	if locksLocal {
+		cached, err := lfs.CachedLocks()
+		if err != nil {
+			Exit("Unable to read cached locks: %s", err)
+		}
+		locks = filterLocks(cached, path, locksId, locksLimit)
+	} else {
+		found, err := lfs.SearchLocks(path, locksId, locksLimit)
+		if err != nil {
+			exitLockError("Unable to list locks", err)
+		}
+		locks = found
+		refreshLockCache()
+	}
+
+	for _, lock := range locks {
+		Print("%s\t%s\tID:%s", lock.Path, lock.OwnerName(), lock.Id)
+	}
+}
+
+// refreshLockCache replaces the cached locks with those the server says the
+// current user holds. The cache is left alone if the server can't say.
+func refreshLockCache() {
+	ref, err := git.CurrentRef()
+	if err != nil || ref.Type != git.RefTypeLocalBranch {
+		tracerx.Printf("locks: not refreshing cache, not on a branch")
+		return
+	}
+
+	_, branch, err := git.RemoteBranchForLocalBranch(ref.Name)
+	if err != nil {
+		branch = ref.Name
+	}
+
+	ours, _, err := lfs.VerifyLocks("refs/heads/" + branch)
+	if err != nil {
+		tracerx.Printf("locks: not refreshing cache: %s", err)
+		return
+	}
+
+	if err := lfs.ReplaceCachedLocks(ours); err != nil {
+		Error("warning: unable to record locks: %s", err)
+	}
+}
+
+// filterLocks returns the locks matching path and id, where either may be
+// empty to match any, up to limit locks if it's more than 0.
+func filterLocks(locks []*lfs.Lock, path, id string, limit int) []*lfs.Lock {
+	var matched []*lfs.Lock
+	for _, lock := range locks {
+		if limit > 0 && len(matched) >= limit {
+			break
+		}
+		if (len(path) == 0 || lock.Path == path) && (len(id) == 0 || lock.Id == id) {
+			matched = append(matched, lock)
+		}
+	}
+	return matched
+}
+
+func init() {
+	locksCmd.Flags().StringVarP(&locksRemote, "remote", "r", "", "The remote whose server holds the locks")
+	locksCmd.Flags().StringVarP(&locksPath, "path", "p", "", "Only list the lock on this path")
+	locksCmd.Flags().StringVarP(&locksId, "id", "i", "", "Only list the lock with this id")
+	locksCmd.Flags().IntVarP(&locksLimit, "limit", "l", 0, "List at most this many locks")
+	locksCmd.Flags().BoolVarP(&locksLocal, "local", "", false, "List the locks you hold from the local cache, without contacting the server")
+	RootCmd.AddCommand(locksCmd)
+}
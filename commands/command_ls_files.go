@@ -1,16 +1,15 @@
 package commands
 
 import (
-	"os"
-
 	"github.com/github/git-lfs/git"
 	"github.com/github/git-lfs/lfs"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
 )
 
 var (
-	longOIDs   = false
-	lsFilesCmd = &cobra.Command{
+	longOIDs    = false
+	lsFilesSize = false
+	lsFilesCmd  = &cobra.Command{
 		Use: "ls-files",
 		Run: lsFilesCommand,
 	}
@@ -19,17 +18,19 @@ var (
 func lsFilesCommand(cmd *cobra.Command, args []string) {
 	requireInRepo()
 
-	var ref string
+	var files []*lfs.WrappedPointer
 	var err error
-
 	if len(args) == 1 {
-		ref = args[0]
-	} else {
-		fullref, err := git.CurrentRef()
-		if err != nil {
-			Exit(err.Error())
+		var ref *git.Ref
+		if ref, err = git.ResolveRef(args[0]); err != nil {
+			Exit("%s", err)
 		}
-		ref = fullref.Sha
+		files, err = lfs.ScanTree(ref.Sha)
+	} else {
+		files, err = lfs.ScanIndexTree()
+	}
+	if err != nil {
+		Panic(err, "Could not scan for Git LFS tree: %s", err)
 	}
 
 	showOidLen := 10
@@ -37,19 +38,31 @@ func lsFilesCommand(cmd *cobra.Command, args []string) {
 		showOidLen = 64
 	}
 
-	files, err := lfs.ScanTree(ref)
+	// paths are shown relative to the current dir, like git does
+	repoPaths := make(chan string, len(files))
+	for _, p := range files {
+		repoPaths <- p.Name
+	}
+	close(repoPaths)
+	cwdPaths, err := lfs.ConvertRepoFilesRelativeToCwd(repoPaths)
 	if err != nil {
-		Panic(err, "Could not scan for Git LFS tree: %s", err)
+		Panic(err, "Could not convert file paths")
 	}
 
 	for _, p := range files {
-		Print("%s %s %s", p.Oid[0:showOidLen], lsFilesMarker(p), p.Name)
+		name := <-cwdPaths
+		if lsFilesSize {
+			Print("%s %s %s (%s)", p.Oid[0:showOidLen], lsFilesMarker(p), name, lfs.FormatSize(p.Size))
+		} else {
+			Print("%s %s %s", p.Oid[0:showOidLen], lsFilesMarker(p), name)
+		}
 	}
 }
 
+// lsFilesMarker returns "*" if the object for p is in the local storage, or
+// "-" if it isn't.
 func lsFilesMarker(p *lfs.WrappedPointer) string {
-	info, err := os.Stat(p.Name)
-	if err == nil && info.Size() == p.Size {
+	if lfs.ObjectExistsOfSize(p.Oid, p.Size) {
 		return "*"
 	}
 
@@ -58,5 +71,6 @@ func lsFilesMarker(p *lfs.WrappedPointer) string {
 
 func init() {
 	lsFilesCmd.Flags().BoolVarP(&longOIDs, "long", "l", false, "")
+	lsFilesCmd.Flags().BoolVarP(&lsFilesSize, "size", "s", false, "")
 	RootCmd.AddCommand(lsFilesCmd)
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/github/git-lfs/git"
 	"github.com/github/git-lfs/lfs"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
 )
 
@@ -45,8 +46,7 @@ var (
 func prePushCommand(cmd *cobra.Command, args []string) {
 
 	if len(args) == 0 {
-		Print("This should be run through Git's pre-push hook.  Run `git lfs update` to install it.")
-		os.Exit(1)
+		ExitUsage("This should be run through Git's pre-push hook.  Run `git lfs update` to install it.")
 	}
 
 	// Remote is first arg
@@ -56,6 +56,7 @@ func prePushCommand(cmd *cobra.Command, args []string) {
 	lfs.Config.CurrentRemote = args[0]
 
 	// We can be passed multiple lines of refs
+	var lines []string
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -64,6 +65,13 @@ func prePushCommand(cmd *cobra.Command, args []string) {
 			continue
 		}
 
+		lines = append(lines, line)
+	}
+
+	// Check all the refs before pushing any objects
+	prePushVerifyLocks(lines)
+
+	for _, line := range lines {
 		left, right := decodeRefs(line)
 		if left == prePushDeleteBranch {
 			continue
@@ -74,17 +82,79 @@ func prePushCommand(cmd *cobra.Command, args []string) {
 	}
 }
 
+// prePushVerifyLocks refuses the push if it changes files that someone else has
+// locked on the server, or only warns if lfs.forcelockverify is false. A server
+// that doesn't support locking is remembered in lfs.<url>.locksverify, so it's
+// not asked again.
+func prePushVerifyLocks(lines []string) {
+	endpoint := lfs.Config.Endpoint("upload")
+	verify, verifySet := lfs.Config.EndpointLocksVerify(endpoint)
+	if !verify {
+		return
+	}
+
+	var conflicts []*lfs.Lock
+	for _, line := range lines {
+		refs := strings.Split(line, " ")
+		if len(refs) < 3 || refs[1] == prePushDeleteBranch {
+			continue
+		}
+
+		_, theirs, err := lfs.VerifyLocks(refs[2])
+		if err != nil {
+			switch {
+			case lfs.IsNotImplementedError(err) && !verifySet:
+				lfs.Config.SetEndpointLocksVerify(endpoint, false)
+			case lfs.IsNotImplementedError(err):
+				Error("warning: %s does not support locking, unable to verify locks", endpoint.Url)
+			case verifySet:
+				Exit("Unable to verify locks: %s", err)
+			default:
+				Error("warning: unable to verify locks: %s", err)
+			}
+			return
+		}
+		if len(theirs) == 0 {
+			continue
+		}
+
+		paths, err := git.ChangedPathsNotOnRemote(refs[1], lfs.Config.CurrentRemote)
+		if err != nil {
+			Panic(err, "Error finding the files changed by %s", refs[2])
+		}
+		conflicts = append(conflicts, lfs.LockConflicts(theirs, paths)...)
+	}
+
+	if len(conflicts) == 0 {
+		return
+	}
+
+	if !lfs.Config.ForceLockVerify() {
+		Error("warning: pushing %d file(s) locked by others:", len(conflicts))
+		for _, lock := range conflicts {
+			Error("* %s - %s", lock.Path, lock.OwnerName())
+		}
+		return
+	}
+
+	Error("Unable to push %d file(s) locked by others:", len(conflicts))
+	for _, lock := range conflicts {
+		Error("* %s - %s", lock.Path, lock.OwnerName())
+	}
+	Exit("Cannot update locked files. Set lfs.forcelockverify to false to push anyway.")
+}
+
 func prePushRef(left, right string) {
 	// Just use scanner here
-	scanOpt := lfs.NewScanRefsOptions()
-	scanOpt.ScanMode = lfs.ScanLeftToRemoteMode
-	scanOpt.RemoteName = lfs.Config.CurrentRemote
-
-	pointers, err := lfs.ScanRefs(left, right, scanOpt)
+	pointers, err := lfs.ScanLeftToRemote(left, lfs.Config.CurrentRemote)
 	if err != nil {
 		Panic(err, "Error scanning for Git LFS files")
 	}
 
+	if !prePushDryRun && lfs.Config.VerifyPush() {
+		pointers = append(pointers, prePushMissingOnServer(left, right, pointers)...)
+	}
+
 	totalSize := int64(0)
 	for _, p := range pointers {
 		totalSize += p.Size
@@ -125,42 +195,84 @@ func prePushRef(left, right string) {
 
 	if !prePushDryRun {
 		uploadQueue.Wait()
-		for _, err := range uploadQueue.Errors() {
-			if Debugging || lfs.IsFatalError(err) {
-				LoggedError(err, err.Error())
-			} else {
-				if inner := lfs.GetInnerError(err); inner != nil {
-					Error(inner.Error())
-				}
-				Error(err.Error())
-			}
-		}
+		transfers.addQueue(uploadQueue)
+		printTransferErrors(uploadQueue.Errors())
+		transfers.exitIfFailed("upload")
+	}
+
+}
+
+// prePushMissingOnServer returns the pointers in the commits being pushed,
+// from left to the remote's right, that weren't scanned as remote tracking refs
+// already have them, but that the server doesn't have. Tracking refs can be
+// out of date, eg if a branch was force pushed away and its objects garbage
+// collected on the server, so it's asked about each of them with a download
+// check. Nothing is checked for a new branch, or if the remote's commit isn't
+// local.
+func prePushMissingOnServer(left, right string, scanned []*lfs.WrappedPointer) []*lfs.WrappedPointer {
+	remoteSha := strings.TrimPrefix(right, "^")
+	if len(remoteSha) == 0 || remoteSha == prePushDeleteBranch || git.ObjectType(remoteSha) != "commit" {
+		return nil
+	}
+
+	pointers, err := lfs.ScanRefs(left, right, nil)
+	if err != nil {
+		Panic(err, "Error scanning for Git LFS files")
+	}
 
-		if len(uploadQueue.Errors()) > 0 {
-			os.Exit(2)
+	seen := lfs.NewStringSetWithCapacity(len(scanned))
+	for _, p := range scanned {
+		seen.Add(p.Oid)
+	}
+
+	var unscanned []*lfs.WrappedPointer
+	for _, p := range pointers {
+		if !seen.Contains(p.Oid) {
+			unscanned = append(unscanned, p)
 		}
 	}
+	if len(unscanned) == 0 {
+		return nil
+	}
 
+	onServer := prePushObjectsOnServer(unscanned)
+	var missing []*lfs.WrappedPointer
+	for _, p := range unscanned {
+		if !onServer.Contains(p.Oid) {
+			tracerx.Printf("%s [%s] is not on the server, uploading it", p.Name, p.Oid)
+			missing = append(missing, p)
+		}
+	}
+	return missing
 }
 
 func prePushCheckForMissingObjects(pointers []*lfs.WrappedPointer) (objectsOnServer lfs.StringSet) {
 	var missingLocalObjects []*lfs.WrappedPointer
-	var missingSize int64
-	var skipObjects = lfs.NewStringSetWithCapacity(len(pointers))
 	for _, pointer := range pointers {
 		if !lfs.ObjectExistsOfSize(pointer.Oid, pointer.Size) {
 			// We think we need to push this but we don't have it
 			// Store for server checking later
 			missingLocalObjects = append(missingLocalObjects, pointer)
-			missingSize += pointer.Size
 		}
 	}
 	if len(missingLocalObjects) == 0 {
 		return nil
 	}
 
-	checkQueue := lfs.NewDownloadCheckQueue(len(missingLocalObjects), missingSize, true)
-	for _, p := range missingLocalObjects {
+	return prePushObjectsOnServer(missingLocalObjects)
+}
+
+// prePushObjectsOnServer returns the oids of the pointers the server has, asking it
+// with a batch download check.
+func prePushObjectsOnServer(pointers []*lfs.WrappedPointer) lfs.StringSet {
+	var size int64
+	for _, p := range pointers {
+		size += p.Size
+	}
+
+	onServer := lfs.NewStringSetWithCapacity(len(pointers))
+	checkQueue := lfs.NewDownloadCheckQueue(len(pointers), size, true)
+	for _, p := range pointers {
 		checkQueue.Add(lfs.NewDownloadCheckable(p))
 	}
 	// this channel is filled with oids for which Check() succeeded & Transfer() was called
@@ -168,14 +280,14 @@ func prePushCheckForMissingObjects(pointers []*lfs.WrappedPointer) (objectsOnSer
 	done := make(chan int)
 	go func() {
 		for oid := range transferc {
-			skipObjects.Add(oid)
+			onServer.Add(oid)
 		}
 		done <- 1
 	}()
 	// Currently this is needed to flush the batch but is not enough to sync transferc completely
 	checkQueue.Wait()
 	<-done
-	return skipObjects
+	return onServer
 }
 
 // decodeRefs pulls the sha1s out of the line read from the pre-push
@@ -197,5 +309,6 @@ func decodeRefs(input string) (string, string) {
 
 func init() {
 	prePushCmd.Flags().BoolVarP(&prePushDryRun, "dry-run", "d", false, "Do everything except actually send the updates")
+	prePushCmd.Flags().BoolVar(&lfs.Config.SkipPushVerify, "no-verify", false, "Don't check the server has the objects remote tracking refs say it does")
 	RootCmd.AddCommand(prePushCmd)
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,20 +26,58 @@ var (
 	pruneVerboseArg     bool
 	pruneVerifyArg      bool
 	pruneDoNotVerifyArg bool
+	pruneForceSharedArg bool
+	pruneForceArg       bool
 )
 
 func pruneCommand(cmd *cobra.Command, args []string) {
 
 	// Guts of this must be re-usable from fetch --prune so just parse & dispatch
 	if pruneVerifyArg && pruneDoNotVerifyArg {
-		Exit("Cannot specify both --verify-remote and --no-verify-remote")
+		ExitUsage("Cannot specify both --verify-remote and --no-verify-remote")
 	}
 
 	verify := !pruneDoNotVerifyArg &&
 		(lfs.Config.FetchPruneConfig().PruneVerifyRemoteAlways || pruneVerifyArg)
 
-	prune(verify, pruneDryRunArg, pruneVerboseArg)
+	if lfs.LocalStorageShared && !pruneForceSharedArg {
+		Exit("%s\nObjects they need may be deleted; use --force-shared to prune anyway.", describeSharedStorage())
+	}
+
+	prune(verify, pruneDryRunArg, pruneVerboseArg, pruneForceArg)
+
+}
+
+// describeSharedStorage says which other repositories are known to use the
+// shared LFS storage. Repositories which haven't run Git LFS since sharing it
+// aren't known, so the storage is treated as shared even when none are listed.
+func describeSharedStorage() string {
+	others := otherSharedStorageRepos()
+	if len(others) == 0 {
+		return fmt.Sprintf("LFS storage %s is shared, and may be used by other repositories\n"+
+			"(set lfs.sharedstorage=false if only this one uses it).", lfs.LocalStorageDir)
+	}
+	return fmt.Sprintf("LFS storage %s is shared with other repositories:\n  %s",
+		lfs.LocalStorageDir, strings.Join(others, "\n  "))
+}
 
+// otherSharedStorageRepos returns any repositories other than this one which
+// are registered as using the same (shared) LFS storage. Prune only considers
+// refs in the current repo, so could delete objects these still need.
+func otherSharedStorageRepos() []string {
+	repos, err := lfs.SharedStorageRepos()
+	if err != nil {
+		LoggedError(err, "Unable to read shared storage repositories")
+		return nil
+	}
+
+	var others []string
+	for _, repo := range repos {
+		if repo != lfs.LocalGitStorageDir {
+			others = append(others, repo)
+		}
+	}
+	return others
 }
 
 type PruneProgressType int
@@ -56,19 +95,20 @@ type PruneProgress struct {
 }
 type PruneProgressChan chan PruneProgress
 
-func prune(verifyRemote, dryRun, verbose bool) {
+// prune deletes the local objects which aren't retained. Objects which aren't
+// reachable from any ref, and haven't been verified on the remote, may never
+// have been pushed; they're only deleted if force is set.
+func prune(verifyRemote, dryRun, verbose, force bool) {
 	localObjects := make([]localstorage.Object, 0, 100)
 	retainedObjects := lfs.NewStringSetWithCapacity(100)
-	var reachableObjects lfs.StringSet
+	retainedSizes := make(map[string]int64, 100)
+	reachableObjects := lfs.NewStringSetWithCapacity(100)
 	var taskwait sync.WaitGroup
 
 	// Add all the base funcs to the waitgroup before starting them, in case
 	// one completes really fast & hits 0 unexpectedly
 	// each main process can Add() to the wg itself if it subdivides the task
-	taskwait.Add(4) // 1..4: localObjects, current & recent refs, unpushed, worktree
-	if verifyRemote {
-		taskwait.Add(1) // 5
-	}
+	taskwait.Add(5) // 1..5: localObjects, current & recent refs, unpushed, worktree, reachable
 
 	progressChan := make(PruneProgressChan, 100)
 
@@ -83,20 +123,17 @@ func prune(verifyRemote, dryRun, verbose bool) {
 	go pruneTaskGetLocalObjects(&localObjects, progressChan, &taskwait)
 
 	// Now find files to be retained from many sources
-	retainChan := make(chan string, 100)
+	retainChan := make(chan *lfs.Pointer, 100)
 
 	go pruneTaskGetRetainedCurrentAndRecentRefs(retainChan, errorChan, &taskwait)
 	go pruneTaskGetRetainedUnpushed(retainChan, errorChan, &taskwait)
 	go pruneTaskGetRetainedWorktree(retainChan, errorChan, &taskwait)
-	if verifyRemote {
-		reachableObjects = lfs.NewStringSetWithCapacity(100)
-		go pruneTaskGetReachableObjects(&reachableObjects, errorChan, &taskwait)
-	}
+	go pruneTaskGetReachableObjects(&reachableObjects, errorChan, &taskwait)
 
 	// Now collect all the retained objects, on separate wait
 	var retainwait sync.WaitGroup
 	retainwait.Add(1)
-	go pruneTaskCollectRetained(&retainedObjects, retainChan, progressChan, &retainwait)
+	go pruneTaskCollectRetained(&retainedObjects, retainedSizes, retainChan, progressChan, &retainwait)
 
 	// Report progress
 	var progresswait sync.WaitGroup
@@ -112,26 +149,26 @@ func prune(verifyRemote, dryRun, verbose bool) {
 	pruneCheckErrors(taskErrors)
 
 	prunableObjects := make([]string, 0, len(localObjects)/2)
+	localSizes := make(map[string]int64, len(localObjects))
 
 	// Build list of prunables (also queue for verify at same time if applicable)
 	var verifyQueue *lfs.TransferQueue
 	var verifiedObjects lfs.StringSet
-	var totalSize int64
-	var verboseOutput bytes.Buffer
 	if verifyRemote {
 		lfs.Config.CurrentRemote = lfs.Config.FetchPruneConfig().PruneRemoteName
 		// build queue now, no estimates or progress output
 		verifyQueue = lfs.NewDownloadCheckQueue(0, 0, true)
 		verifiedObjects = lfs.NewStringSetWithCapacity(len(localObjects) / 2)
 	}
+	recentlyUsed, recentCutoff := pruneGetRecentlyUsed()
+	var damagedObjects []localstorage.Object
 	for _, file := range localObjects {
-		if !retainedObjects.Contains(file.Oid) {
+		if size, ok := retainedSizes[file.Oid]; ok && size != file.Size {
+			damagedObjects = append(damagedObjects, file)
+		}
+		if !retainedObjects.Contains(file.Oid) && !recentlyUsed.Contains(file.Oid) {
 			prunableObjects = append(prunableObjects, file.Oid)
-			totalSize += file.Size
-			if verbose {
-				// Save up verbose output for the end, spinner still going
-				verboseOutput.WriteString(fmt.Sprintf(" * %v (%v)\n", file.Oid, humanizeBytes(file.Size)))
-			}
+			localSizes[file.Oid] = file.Size
 			if verifyRemote {
 				tracerx.Printf("VERIFYING: %v", file.Oid)
 				pointer := lfs.NewPointer(file.Oid, file.Size, nil)
@@ -163,23 +200,163 @@ func prune(verifyRemote, dryRun, verbose bool) {
 		progresswait.Wait()
 	}
 
+	prunableObjects = pruneUnknownObjects(prunableObjects, localSizes, reachableObjects, verifiedObjects, force, verbose)
+
+	var totalSize int64
+	var verboseOutput bytes.Buffer
+	for _, oid := range prunableObjects {
+		totalSize += localSizes[oid]
+		if verbose {
+			verboseOutput.WriteString(fmt.Sprintf(" * %v (%v)\n", oid, lfs.FormatSize(localSizes[oid])))
+		}
+	}
+
+	pruneTempFiles(dryRun, verbose)
+	pruneDamagedObjects(damagedObjects, retainedSizes, dryRun, verbose)
+
 	if len(prunableObjects) == 0 {
 		Print("Nothing to prune")
 		return
 	}
 	if dryRun {
-		Print("%d files would be pruned (%v)", len(prunableObjects), humanizeBytes(totalSize))
+		Print("%d files would be pruned (%v)", len(prunableObjects), lfs.FormatSize(totalSize))
 		if verbose {
 			Print(verboseOutput.String())
 		}
 	} else {
-		Print("Pruning %d files, (%v)", len(prunableObjects), humanizeBytes(totalSize))
+		Print("Pruning %d files, (%v)", len(prunableObjects), lfs.FormatSize(totalSize))
 		if verbose {
 			Print(verboseOutput.String())
 		}
 		pruneDeleteFiles(prunableObjects)
 	}
 
+	if !dryRun && recentlyUsed != nil {
+		if err := lfs.CompactObjectAccessLog(recentCutoff); err != nil {
+			tracerx.Printf("Unable to compact object access log: %s", err)
+		}
+	}
+}
+
+// pruneUnknownObjects returns the prunable objects whose push status is known:
+// those reachable from a ref, which are pushed since they aren't retained as
+// unpushed, and those verified on the remote. The others, such as objects
+// only referenced by orphaned commits or which were added to the index but
+// never committed, may be the only copy and are kept unless force is set.
+func pruneUnknownObjects(prunableObjects []string, sizes map[string]int64, reachableObjects, verifiedObjects lfs.StringSet, force, verbose bool) []string {
+	known := make([]string, 0, len(prunableObjects))
+	var unknown []string
+	for _, oid := range prunableObjects {
+		if reachableObjects.Contains(oid) || (verifiedObjects != nil && verifiedObjects.Contains(oid)) {
+			known = append(known, oid)
+			continue
+		}
+		unknown = append(unknown, oid)
+	}
+
+	if len(unknown) == 0 || force {
+		return prunableObjects
+	}
+
+	var size int64
+	for _, oid := range unknown {
+		size += sizes[oid]
+		tracerx.Printf("RETAIN: %v push status unknown", oid)
+	}
+	Print("Keeping %d files not known to be pushed (%v), use --force to prune them", len(unknown), lfs.FormatSize(size))
+	if verbose {
+		for _, oid := range unknown {
+			Print(" * %v (%v)", oid, lfs.FormatSize(sizes[oid]))
+		}
+	}
+	return known
+}
+
+// pruneGetRecentlyUsed returns the objects read for checkout within the last
+// lfs.prunerecentlyused days, which are kept, and the start of that window.
+// Returns nil if the setting is off. Objects with no recorded access are
+// treated as not recently used.
+func pruneGetRecentlyUsed() (lfs.StringSet, time.Time) {
+	days := lfs.Config.FetchPruneConfig().PruneRecentlyUsedDays
+	if days == 0 {
+		return nil, time.Time{}
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	times, err := lfs.ObjectAccessTimes()
+	if err != nil {
+		tracerx.Printf("Unable to read object access log: %s", err)
+	}
+
+	recent := lfs.NewStringSetWithCapacity(len(times))
+	for oid, t := range times {
+		if t.After(cutoff) {
+			recent.Add(oid)
+			tracerx.Printf("RETAIN: %v used at %v", oid, t)
+		}
+	}
+	return recent, cutoff
+}
+
+// pruneTempFiles removes temp files left behind by interrupted transfers,
+// which would otherwise never be cleaned up.
+func pruneTempFiles(dryRun, verbose bool) {
+	if dryRun {
+		return
+	}
+
+	removed, err := lfs.ClearTempObjects()
+	if err != nil {
+		LoggedError(err, "Unable to clear temp files: %s", err)
+	}
+	if len(removed) > 0 {
+		Print("Removed %d stale temp files", len(removed))
+		if verbose {
+			for _, path := range removed {
+				Print(" * %s", path)
+			}
+		}
+	}
+}
+
+// pruneDamagedObjects deals with objects which are still needed but whose size
+// doesn't match their pointers, usually because a transfer was interrupted.
+// Objects smaller than expected must be incomplete and are deleted so they can
+// be downloaded again; anything else is quarantined for inspection.
+func pruneDamagedObjects(damagedObjects []localstorage.Object, expectedSizes map[string]int64, dryRun, verbose bool) {
+	if len(damagedObjects) == 0 {
+		return
+	}
+
+	if dryRun {
+		Print("%d damaged files would be removed", len(damagedObjects))
+		return
+	}
+
+	var removedFiles int
+	for _, file := range damagedObjects {
+		expected := expectedSizes[file.Oid]
+		if file.Size < expected {
+			if err := os.Remove(lfs.LocalMediaPathReadOnly(file.Oid)); err != nil {
+				LoggedError(err, "Unable to remove incomplete object %s: %s", file.Oid, err)
+				continue
+			}
+			if verbose {
+				Print(" * %s is incomplete (%d of %d bytes), removed", file.Oid, file.Size, expected)
+			}
+		} else {
+			badFile, err := lfs.QuarantineObject(file.Oid)
+			if err != nil {
+				LoggedError(err, "Unable to quarantine object %s: %s", file.Oid, err)
+				continue
+			}
+			if verbose {
+				Print(" * %s is %d bytes, expected %d, moved to %s", file.Oid, file.Size, expected, badFile)
+			}
+		}
+		removedFiles++
+	}
+	Print("Removed %d damaged files", removedFiles)
 }
 
 func pruneCheckVerified(prunableObjects []string, reachableObjects, verifiedObjects lfs.StringSet) {
@@ -240,13 +417,14 @@ func pruneTaskDisplayProgress(progressChan PruneProgressChan, waitg *sync.WaitGr
 	spinner.Finish(OutputWriter, msg)
 }
 
-func pruneTaskCollectRetained(outRetainedObjects *lfs.StringSet, retainChan chan string,
-	progressChan PruneProgressChan, retainwait *sync.WaitGroup) {
+func pruneTaskCollectRetained(outRetainedObjects *lfs.StringSet, outRetainedSizes map[string]int64,
+	retainChan chan *lfs.Pointer, progressChan PruneProgressChan, retainwait *sync.WaitGroup) {
 
 	defer retainwait.Done()
 
-	for oid := range retainChan {
-		if outRetainedObjects.Add(oid) {
+	for p := range retainChan {
+		outRetainedSizes[p.Oid] = p.Size
+		if outRetainedObjects.Add(p.Oid) {
 			progressChan <- PruneProgress{PruneProgressTypeRetain, 1}
 		}
 	}
@@ -283,7 +461,9 @@ func pruneDeleteFiles(prunableObjects []string) {
 	spinner.Finish(OutputWriter, fmt.Sprintf("Deleted %d files", deletedFiles))
 	if problems.Len() > 0 {
 		LoggedError(fmt.Errorf("Failed to delete some files"), problems.String())
-		Exit("Prune failed, see errors above")
+		deleted := &objectResults{}
+		deleted.add(deletedFiles, len(prunableObjects)-deletedFiles)
+		deleted.exitIfFailed("delete")
 	}
 }
 
@@ -299,7 +479,7 @@ func pruneTaskGetLocalObjects(outLocalObjects *[]localstorage.Object, progChan P
 }
 
 // Background task, must call waitg.Done() once at end
-func pruneTaskGetRetainedAtRef(ref string, retainChan chan string, errorChan chan error, waitg *sync.WaitGroup) {
+func pruneTaskGetRetainedAtRef(ref string, retainChan chan *lfs.Pointer, errorChan chan error, waitg *sync.WaitGroup) {
 	defer waitg.Done()
 
 	// Only files AT ref, recent is checked in pruneTaskGetRetainedRecentRefs
@@ -312,7 +492,7 @@ func pruneTaskGetRetainedAtRef(ref string, retainChan chan string, errorChan cha
 		return
 	}
 	for wp := range refchan.Results {
-		retainChan <- wp.Pointer.Oid
+		retainChan <- wp.Pointer
 		tracerx.Printf("RETAIN: %v via ref %v", wp.Pointer.Oid, ref)
 	}
 	err = refchan.Wait()
@@ -322,7 +502,7 @@ func pruneTaskGetRetainedAtRef(ref string, retainChan chan string, errorChan cha
 }
 
 // Background task, must call waitg.Done() once at end
-func pruneTaskGetPreviousVersionsOfRef(ref string, since time.Time, retainChan chan string, errorChan chan error, waitg *sync.WaitGroup) {
+func pruneTaskGetPreviousVersionsOfRef(ref string, since time.Time, retainChan chan *lfs.Pointer, errorChan chan error, waitg *sync.WaitGroup) {
 	defer waitg.Done()
 
 	refchan, err := lfs.ScanPreviousVersionsToChan(ref, since)
@@ -331,7 +511,7 @@ func pruneTaskGetPreviousVersionsOfRef(ref string, since time.Time, retainChan c
 		return
 	}
 	for wp := range refchan.Results {
-		retainChan <- wp.Pointer.Oid
+		retainChan <- wp.Pointer
 		tracerx.Printf("RETAIN: %v via ref %v >= %v", wp.Pointer.Oid, ref, since)
 	}
 	err = refchan.Wait()
@@ -341,7 +521,7 @@ func pruneTaskGetPreviousVersionsOfRef(ref string, since time.Time, retainChan c
 }
 
 // Background task, must call waitg.Done() once at end
-func pruneTaskGetRetainedCurrentAndRecentRefs(retainChan chan string, errorChan chan error, waitg *sync.WaitGroup) {
+func pruneTaskGetRetainedCurrentAndRecentRefs(retainChan chan *lfs.Pointer, errorChan chan error, waitg *sync.WaitGroup) {
 	defer waitg.Done()
 
 	// We actually increment the waitg in this func since we kick off sub-goroutines
@@ -396,7 +576,7 @@ func pruneTaskGetRetainedCurrentAndRecentRefs(retainChan chan string, errorChan
 }
 
 // Background task, must call waitg.Done() once at end
-func pruneTaskGetRetainedUnpushed(retainChan chan string, errorChan chan error, waitg *sync.WaitGroup) {
+func pruneTaskGetRetainedUnpushed(retainChan chan *lfs.Pointer, errorChan chan error, waitg *sync.WaitGroup) {
 	defer waitg.Done()
 
 	remoteName := lfs.Config.FetchPruneConfig().PruneRemoteName
@@ -407,7 +587,7 @@ func pruneTaskGetRetainedUnpushed(retainChan chan string, errorChan chan error,
 		return
 	}
 	for wp := range refchan.Results {
-		retainChan <- wp.Pointer.Oid
+		retainChan <- wp.Pointer
 		tracerx.Printf("RETAIN: %v unpushed", wp.Pointer.Oid)
 	}
 	err = refchan.Wait()
@@ -417,7 +597,7 @@ func pruneTaskGetRetainedUnpushed(retainChan chan string, errorChan chan error,
 }
 
 // Background task, must call waitg.Done() once at end
-func pruneTaskGetRetainedWorktree(retainChan chan string, errorChan chan error, waitg *sync.WaitGroup) {
+func pruneTaskGetRetainedWorktree(retainChan chan *lfs.Pointer, errorChan chan error, waitg *sync.WaitGroup) {
 	defer waitg.Done()
 
 	// Retain other worktree HEADs too
@@ -479,5 +659,7 @@ func init() {
 	pruneCmd.Flags().BoolVarP(&pruneVerboseArg, "verbose", "v", false, "Print full details of what is/would be deleted")
 	pruneCmd.Flags().BoolVarP(&pruneVerifyArg, "verify-remote", "c", false, "Verify that remote has LFS files before deleting")
 	pruneCmd.Flags().BoolVar(&pruneDoNotVerifyArg, "no-verify-remote", false, "Override lfs.pruneverifyremotealways and don't verify")
+	pruneCmd.Flags().BoolVar(&pruneForceSharedArg, "force-shared", false, "Prune even if the LFS storage is shared with other repositories")
+	pruneCmd.Flags().BoolVarP(&pruneForceArg, "force", "f", false, "Prune objects which aren't known to be pushed")
 	RootCmd.AddCommand(pruneCmd)
 }
@@ -1,10 +1,9 @@
 package commands
 
 import (
-	"fmt"
-
 	"github.com/github/git-lfs/git"
 	"github.com/github/git-lfs/lfs"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
 )
 
@@ -19,18 +18,20 @@ var (
 
 func pullCommand(cmd *cobra.Command, args []string) {
 	requireInRepo()
+	clearTempObjects()
+	useTransfersJSON()
 
 	if len(args) > 0 {
 		// Remote is first arg
 		if err := git.ValidateRemote(args[0]); err != nil {
-			Panic(err, fmt.Sprintf("Invalid remote name '%v'", args[0]))
+			ExitUsage("Invalid remote name %q", args[0])
 		}
 		lfs.Config.CurrentRemote = args[0]
 	} else {
 		// Actively find the default remote, don't just assume origin
 		defaultRemote, err := git.DefaultRemote()
 		if err != nil {
-			Panic(err, "No default remote")
+			Exit("No default remote")
 		}
 		lfs.Config.CurrentRemote = defaultRemote
 	}
@@ -39,20 +40,69 @@ func pullCommand(cmd *cobra.Command, args []string) {
 
 }
 
+// pull downloads the objects for the Git LFS files in the index that aren't
+// local yet, and checks out every file that needs it. The index is scanned
+// once for both: files whose objects are present are checked out straight
+// away, and the others as soon as their download finishes. Files the include
+// and exclude paths leave out are neither downloaded nor checked out.
 func pull(includePaths, excludePaths []string) {
-
-	ref, err := git.CurrentRef()
+	pointers, err := lfs.ScanIndexTree()
 	if err != nil {
-		Panic(err, "Could not pull")
+		Panic(err, "Could not scan for Git LFS files")
+	}
+
+	pointers = skipCaseCollisions(pointers)
+
+	var included []*lfs.WrappedPointer
+	excluded := 0
+	for _, p := range pointers {
+		if lfs.FilenamePassesIncludeExcludeFilter(p.Name, includePaths, excludePaths) {
+			included = append(included, p)
+			continue
+		}
+		tracerx.Printf("Skipping %v [%v], include/exclude filters applied", p.Name, p.Oid)
+		transfers.skip(p, lfs.SkippedExcluded)
+		excluded++
 	}
 
-	c := fetchRefToChan(ref.Sha, includePaths, excludePaths)
-	checkoutFromFetchChan(includePaths, excludePaths, c)
+	checkWorkingTreeSpace(included)
+
+	c := make(chan *lfs.WrappedPointer)
+	fetchDone := make(chan struct{})
+	go func() {
+		fetchAndReportToChan(included, nil, nil, c)
+		close(fetchDone)
+	}()
+	checkoutWithChan(c)
+	// c is closed before fetch prints its errors
+	<-fetchDone
+
+	printPullSummary(excluded)
+	printTransfersJSON()
+
+	transfers.exitIfFailed("download")
+	checkouts.exitIfFailed("check out")
+}
+
+// printPullSummary prints what pull did, eg:
+// Checked out 52 files, downloaded 12 objects (1.5 MiB), 3 files excluded
+func printPullSummary(excluded int) {
+	checkouts.mutex.Lock()
+	checkedOut := checkouts.succeeded
+	checkouts.mutex.Unlock()
+
+	fetched.mutex.Lock()
+	downloaded, downloadedBytes := fetched.fetched, fetched.fetchedBytes
+	fetched.mutex.Unlock()
 
+	Print("Checked out %d files, downloaded %d objects (%s), %d files excluded", checkedOut, downloaded, lfs.FormatSize(downloadedBytes), excluded)
 }
 
 func init() {
 	pullCmd.Flags().StringVarP(&pullIncludeArg, "include", "I", "", "Include a list of paths")
 	pullCmd.Flags().StringVarP(&pullExcludeArg, "exclude", "X", "", "Exclude a list of paths")
+	pullCmd.Flags().BoolVar(&lfs.Config.NoProgress, "no-progress", false, "Don't show the progress meter")
+	pullCmd.Flags().BoolVar(&lfs.Config.SkipSpaceCheck, "skip-space-check", false, "Don't check for free disk space first")
+	pullCmd.Flags().BoolVar(&transfersJSON, "json", false, "Print the results as JSON")
 	RootCmd.AddCommand(pullCmd)
 }
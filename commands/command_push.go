@@ -1,8 +1,11 @@
 package commands
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/github/git-lfs/git"
 	"github.com/github/git-lfs/lfs"
@@ -16,6 +19,7 @@ var (
 		Run: pushCommand,
 	}
 	pushDryRun    = false
+	pushDryRunAt  = "" // "server" or "local", from --dry-run
 	pushObjectIDs = false
 	pushAll       = false
 	useStdin      = false
@@ -37,25 +41,24 @@ func uploadsBetweenRefs(left string, right string) *lfs.TransferQueue {
 func uploadsBetweenRefAndRemote(remote string, refs []string) *lfs.TransferQueue {
 	tracerx.Printf("Upload refs %v to remote %v", refs, remote)
 
-	scanOpt := lfs.NewScanRefsOptions()
-	scanOpt.ScanMode = lfs.ScanLeftToRemoteMode
-	scanOpt.RemoteName = remote
-
-	if pushAll {
-		if len(refs) == 0 {
-			pointers := scanAll()
-			Print("Pushing objects...")
-			return uploadPointers(pointers)
-		} else {
-			scanOpt.ScanMode = lfs.ScanRefsMode
-		}
+	if pushAll && len(refs) == 0 {
+		pointers := scanAll()
+		Print("Pushing objects...")
+		return uploadPointers(pointers)
 	}
 
 	// keep a unique set of pointers
 	oidPointerMap := make(map[string]*lfs.WrappedPointer)
 
 	for _, ref := range refs {
-		pointers, err := lfs.ScanRefs(ref, "", scanOpt)
+		var pointers []*lfs.WrappedPointer
+		var err error
+		if pushAll {
+			pointers, err = lfs.ScanRefs(ref, "", nil)
+		} else {
+			// by default, only what isn't already on the remote
+			pointers, err = lfs.ScanLeftToRemote(ref, remote)
+		}
 		if err != nil {
 			Panic(err, "Error scanning for Git LFS files in the %q ref", ref)
 		}
@@ -81,17 +84,18 @@ func uploadPointers(pointers []*lfs.WrappedPointer) *lfs.TransferQueue {
 		totalSize += p.Size
 	}
 
+	if pushDryRun {
+		printPushDryRun(pointers)
+		return nil
+	}
+
 	skipObjects := prePushCheckForMissingObjects(pointers)
 
 	uploadQueue := lfs.NewUploadQueue(len(pointers), totalSize, pushDryRun)
 	for i, pointer := range pointers {
-		if pushDryRun {
-			Print("push %s => %s", pointer.Oid, pointer.Name)
-			continue
-		}
-
 		if _, skip := skipObjects[pointer.Oid]; skip {
 			// object missing locally but on server, don't bother
+			transfers.skip(pointer, lfs.SkippedAlreadyPresent)
 			continue
 		}
 
@@ -108,32 +112,72 @@ func uploadPointers(pointers []*lfs.WrappedPointer) *lfs.TransferQueue {
 }
 
 func uploadsWithObjectIDs(oids []string) *lfs.TransferQueue {
-	uploads := []*lfs.Uploadable{}
-	totalSize := int64(0)
+	// check every object is here before pushing any of them
+	uploads, err := lfs.NewUploadablesForOids(oids)
+	if err != nil {
+		Exit("Unable to push objects by ID.\n%s", err)
+	}
 
-	for i, oid := range oids {
-		if pushDryRun {
-			Print("push object ID %s", oid)
-			continue
-		}
-		tracerx.Printf("prepare upload: %s %d/%d", oid, i+1, len(oids))
+	totalSize := int64(0)
+	for _, u := range uploads {
+		totalSize += u.Size()
+	}
 
-		u, err := lfs.NewUploadable(oid, "")
-		if err != nil {
-			ExitWithError(err)
+	if pushDryRun {
+		pointers := make([]*lfs.WrappedPointer, 0, len(uploads))
+		for _, u := range uploads {
+			pointers = append(pointers, &lfs.WrappedPointer{Size: u.Size(), Pointer: lfs.NewPointer(u.Oid(), u.Size(), nil)})
 		}
-		uploads = append(uploads, u)
+		printPushDryRun(pointers)
+		return nil
 	}
 
-	uploadQueue := lfs.NewUploadQueue(len(oids), totalSize, pushDryRun)
+	uploadQueue := lfs.NewUploadQueue(len(uploads), totalSize, pushDryRun)
+	for i, u := range uploads {
+		tracerx.Printf("prepare upload: %s %d/%d", u.Oid(), i+1, len(uploads))
 
-	for _, u := range uploads {
 		uploadQueue.Add(u)
 	}
 
 	return uploadQueue
 }
 
+// printPushDryRun lists the objects a push would upload, and their total size,
+// without uploading them. Unless --dry-run=local was given, the server is asked
+// which it has already, and those are listed as such, eg:
+//
+//	would upload 3428719b76 (6 B) added.dat
+//	already on server ee31ef2274 (8 B) deleted.dat
+//	Would upload 1 objects (6 B), 1 already on server (8 B)
+func printPushDryRun(pointers []*lfs.WrappedPointer) {
+	var onServer lfs.StringSet
+	if pushDryRunAt != "local" && len(pointers) > 0 {
+		onServer = prePushObjectsOnServer(pointers)
+	}
+
+	var upload, present int
+	var uploadBytes, presentBytes int64
+	for _, p := range pointers {
+		// objects pushed by ID have no path
+		object := strings.TrimSpace(fmt.Sprintf("%s (%s) %s", p.Oid[0:10], lfs.FormatSize(p.Size), p.Name))
+		if onServer.Contains(p.Oid) {
+			Print("already on server %s", object)
+			present++
+			presentBytes += p.Size
+		} else {
+			Print("would upload %s", object)
+			upload++
+			uploadBytes += p.Size
+		}
+	}
+
+	if pushDryRunAt == "local" {
+		Print("Would upload %d objects (%s), without checking the server", upload, lfs.FormatSize(uploadBytes))
+		return
+	}
+	Print("Would upload %d objects (%s), %d already on server (%s)", upload, lfs.FormatSize(uploadBytes), present, lfs.FormatSize(presentBytes))
+}
+
 // pushCommand pushes local objects to a Git LFS server.  It takes two
 // arguments:
 //
@@ -147,15 +191,32 @@ func pushCommand(cmd *cobra.Command, args []string) {
 	var uploadQueue *lfs.TransferQueue
 
 	if len(args) == 0 {
-		Print("Specify a remote and a remote branch name (`git lfs push origin master`)")
-		os.Exit(1)
+		ExitUsage("Specify a remote and a remote branch name (`git lfs push origin master`)")
 	}
 
 	// Remote is first arg
 	if err := git.ValidateRemote(args[0]); err != nil {
-		Exit("Invalid remote name %q", args[0])
+		ExitUsage("Invalid remote name %q", args[0])
 	}
 	lfs.Config.CurrentRemote = args[0]
+	useTransfersJSON()
+
+	// --dry-run used to be a bool flag, so its values still work
+	if b, err := strconv.ParseBool(pushDryRunAt); err == nil {
+		pushDryRunAt = "off"
+		if b {
+			pushDryRunAt = "server"
+		}
+	}
+
+	switch pushDryRunAt {
+	case "", "off":
+		pushDryRunAt = ""
+	case "server", "local":
+		pushDryRun = true
+	default:
+		ExitUsage("Invalid --dry-run mode %q, use --dry-run or --dry-run=local", pushDryRunAt)
+	}
 
 	if useStdin {
 		requireStdin("Run this command from the Git pre-push hook, or leave the --stdin flag off.")
@@ -170,19 +231,20 @@ func pushCommand(cmd *cobra.Command, args []string) {
 		}
 
 		if len(refsData) == 0 {
+			printTransfersJSON()
 			return
 		}
 
 		left, right := decodeRefs(string(refsData))
 		if left == prePushDeleteBranch {
+			printTransfersJSON()
 			return
 		}
 
 		uploadQueue = uploadsBetweenRefs(left, right)
 	} else if pushObjectIDs {
 		if len(args) < 2 {
-			Print("Usage: git lfs push --object-id <remote> <lfs-object-id> [lfs-object-id] ...")
-			return
+			ExitUsage("Usage: git lfs push --object-id <remote> <lfs-object-id> [lfs-object-id] ...")
 		}
 
 		uploadQueue = uploadsWithObjectIDs(args[1:])
@@ -197,28 +259,21 @@ func pushCommand(cmd *cobra.Command, args []string) {
 
 	if !pushDryRun {
 		uploadQueue.Wait()
-		for _, err := range uploadQueue.Errors() {
-			if Debugging || lfs.IsFatalError(err) {
-				LoggedError(err, err.Error())
-			} else {
-				if inner := lfs.GetInnerError(err); inner != nil {
-					Error(inner.Error())
-				}
-				Error(err.Error())
-			}
-		}
-
-		if len(uploadQueue.Errors()) > 0 {
-			os.Exit(2)
-		}
+		transfers.addQueue(uploadQueue)
+		printTransferErrors(uploadQueue.Errors())
+		printTransfersJSON()
+		transfers.exitIfFailed("upload")
 	}
 }
 
 func init() {
-	pushCmd.Flags().BoolVarP(&pushDryRun, "dry-run", "d", false, "Do everything except actually send the updates")
+	pushCmd.Flags().StringVarP(&pushDryRunAt, "dry-run", "d", "", "List the objects to push and their size, without pushing them. With =local, don't ask the server which it has")
+	pushCmd.Flags().Lookup("dry-run").NoOptDefVal = "server"
 	pushCmd.Flags().BoolVarP(&useStdin, "stdin", "s", false, "Take refs on stdin (for pre-push hook)")
 	pushCmd.Flags().BoolVarP(&pushObjectIDs, "object-id", "o", false, "Push LFS object ID(s)")
 	pushCmd.Flags().BoolVarP(&pushAll, "all", "a", false, "Push all objects for the current ref to the remote.")
 
+	pushCmd.Flags().BoolVar(&lfs.Config.NoProgress, "no-progress", false, "Don't show the progress meter")
+	pushCmd.Flags().BoolVar(&transfersJSON, "json", false, "Print the results as JSON")
 	RootCmd.AddCommand(pushCmd)
 }
@@ -1,7 +1,6 @@
 package commands
 
 import (
-	"bytes"
 	"io"
 	"os"
 	"path/filepath"
@@ -23,14 +22,9 @@ func smudgeCommand(cmd *cobra.Command, args []string) {
 	requireStdin("This command should be run by the Git 'smudge' filter")
 	lfs.InstallHooks(false)
 
-	// keeps the initial buffer from lfs.DecodePointer
-	b := &bytes.Buffer{}
-	r := io.TeeReader(os.Stdin, b)
-
-	ptr, err := lfs.DecodePointer(r)
+	ptr, r, err := lfs.DecodePointerFromReader(os.Stdin)
 	if err != nil {
-		mr := io.MultiReader(b, os.Stdin)
-		_, err := io.Copy(os.Stdout, mr)
+		_, err := io.Copy(os.Stdout, r)
 		if err != nil {
 			Panic(err, "Error writing data to stdout:")
 		}
@@ -59,7 +53,10 @@ func smudgeCommand(cmd *cobra.Command, args []string) {
 	}
 
 	cfg := lfs.Config
-	download := lfs.FilenamePassesIncludeExcludeFilter(filename, cfg.FetchIncludePaths(), cfg.FetchExcludePaths())
+	includePaths, excludePaths := cfg.FetchIncludePaths(), cfg.FetchExcludePaths()
+	requireValidFilterPatterns("lfs.fetchinclude", includePaths)
+	requireValidFilterPatterns("lfs.fetchexclude", excludePaths)
+	download := lfs.FilenamePassesIncludeExcludeFilter(filename, includePaths, excludePaths)
 
 	if smudgeSkip || lfs.Config.GetenvBool("GIT_LFS_SKIP_SMUDGE", false) {
 		download = false
@@ -1,7 +1,13 @@
 package commands
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
 
 	"github.com/github/git-lfs/git"
 	"github.com/github/git-lfs/lfs"
@@ -16,6 +22,17 @@ var (
 	porcelain = false
 )
 
+// statusEntry is a Git LFS file that's staged, changed in the working tree, or
+// not pushed yet. Sizes are -1 when there's no object on that side, like for
+// a file that was added or deleted.
+type statusEntry struct {
+	Status  string
+	Name    string // from the root of the repository
+	SrcName string // for renames and copies
+	OldSize int64
+	NewSize int64
+}
+
 func statusCommand(cmd *cobra.Command, args []string) {
 	requireInRepo()
 
@@ -24,20 +41,50 @@ func statusCommand(cmd *cobra.Command, args []string) {
 		Panic(err, "Could not get the current ref")
 	}
 
-	stagedPointers, err := lfs.ScanIndex()
+	unpushed, remote := statusUnpushed(ref)
+
+	changes, err := lfs.ScanIndexChanges()
 	if err != nil {
 		Panic(err, "Could not scan staging for Git LFS objects")
 	}
+	staged := make([]*statusEntry, 0, len(changes))
+	for _, c := range changes {
+		e := &statusEntry{Status: c.Status, Name: c.Name, SrcName: c.SrcName, OldSize: -1, NewSize: -1}
+		if c.Old != nil {
+			e.OldSize = c.Old.Size
+		}
+		if c.New != nil {
+			e.NewSize = c.New.Size
+		}
+		staged = append(staged, e)
+	}
+
+	unstaged := statusUnstaged()
+
+	// paths are shown relative to the current dir, like git does
+	var names []string
+	for _, entries := range [][]*statusEntry{unpushed, staged, unstaged} {
+		for _, e := range entries {
+			names = append(names, e.Name)
+			if len(e.SrcName) > 0 {
+				names = append(names, e.SrcName)
+			}
+		}
+	}
+	cwdNames := statusRelativePaths(names)
 
 	if porcelain {
-		for _, p := range stagedPointers {
-			switch p.Status {
-			case "R", "C":
-				Print("%s  %s -> %s %d", p.Status, p.SrcName, p.Name, p.Size)
-			case "M":
-				Print(" %s %s %d", p.Status, p.Name, p.Size)
-			default:
-				Print("%s  %s %d", p.Status, p.Name, p.Size)
+		for _, section := range []struct {
+			where   string
+			entries []*statusEntry
+		}{{"unpushed", unpushed}, {"staged", staged}, {"unstaged", unstaged}} {
+			for _, e := range section.entries {
+				line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", section.where, e.Status,
+					porcelainSize(e.OldSize), porcelainSize(e.NewSize), cwdNames[e.Name])
+				if len(e.SrcName) > 0 {
+					line += "\t" + cwdNames[e.SrcName]
+				}
+				Print("%s", line)
 			}
 		}
 		return
@@ -45,59 +92,209 @@ func statusCommand(cmd *cobra.Command, args []string) {
 
 	Print("On branch %s", ref.Name)
 
-	remoteRef, err := git.CurrentRemoteRef()
-	if err == nil {
-
-		pointers, err := lfs.ScanRefs(ref.Sha, "^"+remoteRef.Sha, nil)
-		if err != nil {
-			Panic(err, "Could not scan for Git LFS objects")
+	if len(remote) > 0 {
+		Print("Git LFS objects to be pushed to %s:\n", remote)
+		for _, e := range unpushed {
+			Print("\t%s (%s)", cwdNames[e.Name], lfs.FormatSize(e.NewSize))
 		}
+	}
 
-		Print("Git LFS objects to be pushed to %s:\n", remoteRef.Name)
-		for _, p := range pointers {
-			Print("\t%s (%s)", p.Name, humanizeBytes(p.Size))
+	Print("\nGit LFS objects to be committed:\n")
+	for _, e := range staged {
+		name := cwdNames[e.Name]
+		if len(e.SrcName) > 0 {
+			name = fmt.Sprintf("%s -> %s", cwdNames[e.SrcName], name)
 		}
+		Print("\t%s (%s)", name, stagedSizes(e))
 	}
 
-	Print("\nGit LFS objects to be committed:\n")
-	for _, p := range stagedPointers {
-		switch p.Status {
-		case "R", "C":
-			Print("\t%s -> %s (%s)", p.SrcName, p.Name, humanizeBytes(p.Size))
-		case "M":
+	collided := caseCollidedPaths(ref.Sha, unstaged)
+
+	var caseOnly []string
+
+	Print("\nGit LFS objects not staged for commit:\n")
+	for _, e := range unstaged {
+		name := cwdNames[e.Name]
+		switch {
+		case collided[e.Name]:
+			caseOnly = append(caseOnly, name)
+		case e.Status == "D":
+			Print("\t%s (deleted)", name)
 		default:
-			Print("\t%s (%s)", p.Name, humanizeBytes(p.Size))
+			Print("\t%s", name)
 		}
 	}
 
-	Print("\nGit LFS objects not staged for commit:\n")
-	for _, p := range stagedPointers {
-		if p.Status == "M" {
-			Print("\t%s", p.Name)
+	if len(caseOnly) > 0 {
+		Print("\nGit LFS objects differing only in case from another, which this filesystem can't hold at once:\n")
+		for _, name := range caseOnly {
+			Print("\t%s", name)
 		}
 	}
 
 	Print("")
 }
 
-var byteUnits = []string{"B", "KB", "MB", "GB", "TB"}
+// statusUnpushed returns the Git LFS objects in commits on ref that a push to
+// the current remote would upload, scanning the same way push does, and the
+// remote's name. Neither is returned if the remote isn't configured.
+func statusUnpushed(ref *git.Ref) ([]*statusEntry, string) {
+	remote := lfs.Config.CurrentRemote
+	if err := git.ValidateRemote(remote); err != nil {
+		return nil, ""
+	}
+
+	pointers, err := lfs.ScanLeftToRemote(ref.Sha, remote)
+	if err != nil {
+		Panic(err, "Could not scan for Git LFS objects")
+	}
+
+	entries := make([]*statusEntry, 0, len(pointers))
+	for _, p := range pointers {
+		entries = append(entries, &statusEntry{Status: "-", Name: p.Name, OldSize: -1, NewSize: p.Size})
+	}
+	sort.Sort(statusEntriesByName(entries))
+	return entries, remote
+}
+
+// statusUnstaged returns the Git LFS files in the index whose content in the
+// working tree isn't what their pointer points to, as "M", or that are gone
+// from it, as "D".
+func statusUnstaged() []*statusEntry {
+	changed, err := git.UnstagedFiles()
+	if err != nil {
+		Panic(err, "Could not scan the working tree for changes")
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	indexed, err := lfs.ScanIndexTree()
+	if err != nil {
+		Panic(err, "Could not scan staging for Git LFS objects")
+	}
+	pointers := make(map[string]*lfs.WrappedPointer, len(indexed))
+	for _, p := range indexed {
+		pointers[p.Name] = p
+	}
+
+	var entries []*statusEntry
+	for _, name := range changed {
+		p, ok := pointers[name]
+		if !ok {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(lfs.LocalWorkingDir, name))
+		if err != nil {
+			entries = append(entries, &statusEntry{Status: "D", Name: name, OldSize: p.Size, NewSize: -1})
+			continue
+		}
+		if !workingFileMatchesPointer(filepath.Join(lfs.LocalWorkingDir, name), info, p.Pointer) {
+			entries = append(entries, &statusEntry{Status: "M", Name: name, OldSize: p.Size, NewSize: info.Size()})
+		}
+	}
+	return entries
+}
+
+// workingFileMatchesPointer returns whether the file at path has the content p
+// points to, or is p itself, as it is when it wasn't smudged.
+func workingFileMatchesPointer(path string, info os.FileInfo, p *lfs.Pointer) bool {
+	if info.Size() != p.Size {
+		wp, err := lfs.DecodePointerFromFile(path)
+		return err == nil && wp.Oid == p.Oid
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return false
+	}
+	return hex.EncodeToString(hash.Sum(nil)) == p.Oid
+}
+
+// statusRelativePaths maps names from the root of the repository to paths
+// relative to the current directory.
+func statusRelativePaths(names []string) map[string]string {
+	repoPaths := make(chan string, len(names))
+	for _, name := range names {
+		repoPaths <- name
+	}
+	close(repoPaths)
+
+	cwdPaths, err := lfs.ConvertRepoFilesRelativeToCwd(repoPaths)
+	if err != nil {
+		Panic(err, "Could not convert file paths")
+	}
+
+	paths := make(map[string]string, len(names))
+	for _, name := range names {
+		paths[name] = <-cwdPaths
+	}
+	return paths
+}
+
+// stagedSizes describes a staged file's size, or its old and new sizes when
+// it replaced another Git LFS object.
+func stagedSizes(e *statusEntry) string {
+	switch {
+	case e.NewSize < 0 && e.Status == "D":
+		return "deleted"
+	case e.NewSize < 0:
+		return fmt.Sprintf("%s -> not a Git LFS file", lfs.FormatSize(e.OldSize))
+	case e.OldSize < 0 || e.OldSize == e.NewSize && e.Status != "M":
+		return lfs.FormatSize(e.NewSize)
+	default:
+		return fmt.Sprintf("%s -> %s", lfs.FormatSize(e.OldSize), lfs.FormatSize(e.NewSize))
+	}
+}
+
+func porcelainSize(size int64) string {
+	if size < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", size)
+}
 
-func humanizeBytes(bytes int64) string {
-	var output string
-	size := float64(bytes)
+type statusEntriesByName []*statusEntry
 
-	if bytes < 1024 {
-		return fmt.Sprintf("%d B", bytes)
+func (s statusEntriesByName) Len() int           { return len(s) }
+func (s statusEntriesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s statusEntriesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// caseCollidedPaths returns the unstaged paths that differ only in case from
+// another path in ref or the index, when the filesystem ignores case. Only one
+// of each can be in the working tree, so git sees the others as modified even
+// though nothing was changed.
+func caseCollidedPaths(ref string, unstaged []*statusEntry) map[string]bool {
+	collided := make(map[string]bool)
+	if !lfs.CaseInsensitiveWorkingDir() {
+		return collided
 	}
 
-	for _, unit := range byteUnits {
-		if size < 1024.0 {
-			output = fmt.Sprintf("%3.1f %s", size, unit)
-			break
+	committed, err := lfs.ScanTree(ref)
+	if err != nil {
+		Panic(err, "Could not scan for Git LFS files")
+	}
+
+	names := make([]string, 0, len(committed)+len(unstaged))
+	for _, p := range committed {
+		names = append(names, p.Name)
+	}
+	for _, e := range unstaged {
+		names = append(names, e.Name)
+	}
+	for _, group := range lfs.FindCaseCollisions(names) {
+		for _, name := range group {
+			collided[name] = true
 		}
-		size /= 1024.0
 	}
-	return output
+	return collided
 }
 
 func init() {
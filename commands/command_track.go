@@ -1,9 +1,6 @@
 package commands
 
 import (
-	"bufio"
-	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,6 +17,9 @@ var (
 		Use: "track",
 		Run: trackCommand,
 	}
+
+	trackLockable = false
+	trackFilename = false
 )
 
 func trackCommand(cmd *cobra.Command, args []string) {
@@ -44,18 +44,9 @@ func trackCommand(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	addTrailingLinebreak := needsTrailingLinebreak(".gitattributes")
-	attributesFile, err := os.OpenFile(".gitattributes", os.O_RDWR|os.O_APPEND|os.O_CREATE, 0660)
+	attributes, err := lfs.ReadAttributesFile(".gitattributes")
 	if err != nil {
-		Print("Error opening .gitattributes file")
-		return
-	}
-	defer attributesFile.Close()
-
-	if addTrailingLinebreak {
-		if _, err := attributesFile.WriteString("\n"); err != nil {
-			Print("Error writing to .gitattributes")
-		}
+		Exit("Error reading .gitattributes file: %s", err)
 	}
 
 	wd, _ := os.Getwd()
@@ -64,42 +55,84 @@ func trackCommand(cmd *cobra.Command, args []string) {
 		Exit("Current directory %q outside of git working directory %q.", wd, lfs.LocalWorkingDir)
 	}
 
+	changed := false
+	var tracked []string
+
 ArgsLoop:
-	for _, pattern := range args {
+	for _, arg := range args {
+		dir := isTrackDirectory(arg)
+		pattern := lfs.TrackPattern(arg, trackFilename, dir)
+
 		for _, known := range knownPaths {
 			if known.Path == filepath.Join(relpath, pattern) {
-				Print("%s already supported", pattern)
+				if trackLockable && attributes.Track(pattern, true) {
+					Print("Marking %s lockable", pattern)
+					changed = true
+				} else {
+					Print("%s already supported", pattern)
+				}
 				continue ArgsLoop
 			}
 		}
 
-		encodedArg := strings.Replace(pattern, " ", "[[:space:]]", -1)
-		_, err := attributesFile.WriteString(fmt.Sprintf("%s filter=lfs diff=lfs merge=lfs -text\n", encodedArg))
-		if err != nil {
-			Print("Error adding path %s", pattern)
+		if !attributes.Track(pattern, trackLockable) {
+			Print("%s already supported", pattern)
 			continue
 		}
-		Print("Tracking %s", pattern)
+		if dir {
+			Print("Tracking %s, as %s is a directory", pattern, arg)
+		} else {
+			Print("Tracking %s", pattern)
+		}
+		changed = true
+		tracked = append(tracked, pattern)
+	}
 
-		// Make sure any existing git tracked files have their timestamp updated
-		// so they will now show as modifed
-		// note this is relative to current dir which is how we write .gitattributes
-		// deliberately not done in parallel as a chan because we'll be marking modified
-		gittracked, err := git.GetTrackedFiles(pattern)
-		if err != nil {
-			LoggedError(err, "Error getting git tracked files")
+	if !changed {
+		return
+	}
+	if err := attributes.Write(); err != nil {
+		Exit("Error writing .gitattributes: %s", err)
+	}
+
+	// Make sure any existing git tracked files have their timestamp updated
+	// so they will now show as modifed
+	// note this is relative to current dir which is how we write .gitattributes
+	// deliberately not done in parallel as a chan because we'll be marking modified
+	gittracked, err := git.GetTrackedFiles(".")
+	if err != nil {
+		LoggedError(err, "Error getting git tracked files")
+		return
+	}
+	now := time.Now()
+	for _, f := range gittracked {
+		if !matchesAnyPattern(tracked, f) {
 			continue
 		}
-		now := time.Now()
-		for _, f := range gittracked {
-			err := os.Chtimes(f, now, now)
-			if err != nil {
-				LoggedError(err, "Error marking %q modified", f)
-				continue
-			}
+		if err := os.Chtimes(f, now, now); err != nil {
+			LoggedError(err, "Error marking %q modified", f)
 		}
+	}
+}
+
+// isTrackDirectory returns whether a path given to track names a directory,
+// either one that exists, or with a trailing slash. A leading slash anchors
+// the path to the current directory, as it does in .gitattributes.
+func isTrackDirectory(arg string) bool {
+	if strings.HasSuffix(filepath.ToSlash(arg), "/") {
+		return true
+	}
+	info, err := os.Stat(strings.TrimPrefix(filepath.ToSlash(arg), "/"))
+	return err == nil && info.IsDir()
+}
 
+func matchesAnyPattern(patterns []string, filename string) bool {
+	for _, pattern := range patterns {
+		if lfs.AttributesPatternMatches(pattern, filename) {
+			return true
+		}
 	}
+	return false
 }
 
 type mediaPath struct {
@@ -111,25 +144,18 @@ func findPaths() []mediaPath {
 	paths := make([]mediaPath, 0)
 
 	for _, path := range findAttributeFiles() {
-		attributes, err := os.Open(path)
+		attributes, err := lfs.ReadAttributesFile(path)
 		if err != nil {
 			continue
 		}
 
-		scanner := bufio.NewScanner(attributes)
-
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.Contains(line, "filter=lfs") {
-				fields := strings.Fields(line)
-				relfile, _ := filepath.Rel(lfs.LocalWorkingDir, path)
-				pattern := fields[0]
-				if reldir := filepath.Dir(relfile); len(reldir) > 0 {
-					pattern = filepath.Join(reldir, pattern)
-				}
-
-				paths = append(paths, mediaPath{Path: pattern, Source: relfile})
+		relfile, _ := filepath.Rel(lfs.LocalWorkingDir, path)
+		for _, pattern := range attributes.LFSPatterns() {
+			if reldir := filepath.Dir(relfile); len(reldir) > 0 {
+				pattern = filepath.Join(reldir, pattern)
 			}
+
+			paths = append(paths, mediaPath{Path: pattern, Source: relfile})
 		}
 	}
 
@@ -144,42 +170,16 @@ func findAttributeFiles() []string {
 		paths = append(paths, repoAttributes)
 	}
 
-	filepath.Walk(lfs.LocalWorkingDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() && (filepath.Base(path) == ".gitattributes") {
-			paths = append(paths, path)
-		}
-		return nil
+	// Unreadable directories are skipped, as there's nothing useful to track there
+	found, _ := lfs.FastWalk(lfs.LocalWorkingDir, lfs.Config.WalkConcurrency(), func(path string, info os.FileInfo) bool {
+		return info.Name() == ".gitattributes"
 	})
 
-	return paths
-}
-
-func needsTrailingLinebreak(filename string) bool {
-	file, err := os.Open(filename)
-	if err != nil {
-		return false
-	}
-	defer file.Close()
-
-	buf := make([]byte, 16384)
-	bytesRead := 0
-	for {
-		n, err := file.Read(buf)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return false
-		}
-		bytesRead = n
-	}
-
-	return !strings.HasSuffix(string(buf[0:bytesRead]), "\n")
+	return append(paths, found...)
 }
 
 func init() {
+	trackCmd.Flags().BoolVarP(&trackFilename, "filename", "", false, "Treat the paths as literal filenames, not patterns.")
+	trackCmd.Flags().BoolVarP(&trackLockable, "lockable", "l", false, "Mark the paths as lockable.")
 	RootCmd.AddCommand(trackCmd)
 }
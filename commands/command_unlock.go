@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"github.com/github/git-lfs/lfs"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
+)
+
+var (
+	unlockCmd = &cobra.Command{
+		Use: "unlock",
+		Run: unlockCommand,
+	}
+	unlockRemote = ""
+	unlockId     = ""
+	unlockForce  = false
+)
+
+func unlockCommand(cmd *cobra.Command, args []string) {
+	if len(args) == 0 && len(unlockId) == 0 {
+		Print("Usage: git lfs unlock (<path> | --id=<id>) [--force]")
+		return
+	}
+
+	setLockRemote(unlockRemote)
+
+	id := unlockId
+	name := unlockId
+	if len(args) > 0 {
+		name = lockPath(args[0])
+		id = lockIdForPath(name)
+	}
+
+	lock, err := lfs.UnlockFile(id, unlockForce)
+	if err != nil {
+		exitLockError("Unlock failed", err)
+	}
+
+	if err := lfs.UncacheLock(lock.Id); err != nil {
+		Error("warning: unable to record unlock: %s", err)
+	}
+	Print("Unlocked %s", name)
+}
+
+// lockIdForPath returns the id of the lock on path, from the cache if it's one
+// of ours, or else from the server.
+func lockIdForPath(path string) string {
+	cached, err := lfs.CachedLocks()
+	if err != nil {
+		Error("warning: unable to read cached locks: %s", err)
+	}
+	for _, lock := range cached {
+		if lock.Path == path {
+			return lock.Id
+		}
+	}
+
+	locks, err := lfs.SearchLocks(path, "", 1)
+	if err != nil {
+		exitLockError("Unlock failed", err)
+	}
+	if len(locks) == 0 {
+		Exit("Unlock failed: %s is not locked", path)
+	}
+	return locks[0].Id
+}
+
+func init() {
+	unlockCmd.Flags().StringVarP(&unlockRemote, "remote", "r", "", "The remote whose server holds the lock")
+	unlockCmd.Flags().StringVarP(&unlockId, "id", "i", "", "The id of the lock to release, instead of a path")
+	unlockCmd.Flags().BoolVarP(&unlockForce, "force", "f", false, "Release the lock even if someone else holds it")
+	RootCmd.AddCommand(unlockCmd)
+}
@@ -1,10 +1,7 @@
 package commands
 
 import (
-	"bufio"
-	"io/ioutil"
 	"os"
-	"strings"
 
 	"github.com/github/git-lfs/lfs"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
@@ -36,48 +33,26 @@ func untrackCommand(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	data, err := ioutil.ReadFile(".gitattributes")
+	attributes, err := lfs.ReadAttributesFile(".gitattributes")
 	if err != nil {
-		return
-	}
-
-	attributes := strings.NewReader(string(data))
-
-	attributesFile, err := os.Create(".gitattributes")
-	if err != nil {
-		Print("Error opening .gitattributes for writing")
-		return
+		Exit("Error reading .gitattributes file: %s", err)
 	}
-	defer attributesFile.Close()
 
-	scanner := bufio.NewScanner(attributes)
-
-	// Iterate through each line of the attributes file and rewrite it,
-	// if the path was meant to be untracked, omit it, and print a message instead.
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.Contains(line, "filter=lfs") {
-			attributesFile.WriteString(line + "\n")
-			continue
-		}
-
-		path := strings.Fields(line)[0]
-		if removePath(path, args) {
+	// Only the lines for the given paths are removed, the rest of the file
+	// is written back as it was.
+	changed := false
+	for _, path := range args {
+		if attributes.Untrack(path) {
 			Print("Untracking %s", path)
-		} else {
-			attributesFile.WriteString(line + "\n")
+			changed = true
 		}
 	}
-}
 
-func removePath(path string, args []string) bool {
-	for _, t := range args {
-		if path == t {
-			return true
+	if changed {
+		if err := attributes.Write(); err != nil {
+			Exit("Error writing .gitattributes: %s", err)
 		}
 	}
-
-	return false
 }
 
 func init() {
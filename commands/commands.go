@@ -7,11 +7,9 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
-	"time"
+	"sync"
 
-	"github.com/github/git-lfs/git"
 	"github.com/github/git-lfs/lfs"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/spf13/cobra"
 )
@@ -34,6 +32,15 @@ var (
 	ManPages = make(map[string]string, 20)
 )
 
+// Exit codes that scripts can rely on, see git-lfs(1). Commands that need a
+// repository exit with 128 outside of one, as git does, and being interrupted
+// exits with 128 + the signal number, eg 130 for SIGINT.
+const (
+	ExitCodeFailure        = 1 // the command failed
+	ExitCodeUsage          = 2 // invalid arguments or flags
+	ExitCodePartialFailure = 3 // some objects succeeded and others failed
+)
+
 // Error prints a formatted message to Stderr.  It also gets printed to the
 // panic log if one is created for this command.
 func Error(format string, args ...interface{}) {
@@ -51,10 +58,17 @@ func Print(format string, args ...interface{}) {
 	fmt.Fprintln(OutputWriter, line)
 }
 
-// Exit prints a formatted message and exits.
+// Exit prints a formatted message and exits with ExitCodeFailure.
 func Exit(format string, args ...interface{}) {
 	Error(format, args...)
-	os.Exit(2)
+	os.Exit(ExitCodeFailure)
+}
+
+// ExitUsage prints a formatted message about invalid arguments and exits with
+// ExitCodeUsage.
+func ExitUsage(format string, args ...interface{}) {
+	Error(format, args...)
+	os.Exit(ExitCodeUsage)
 }
 
 func ExitWithError(err error) {
@@ -68,6 +82,92 @@ func ExitWithError(err error) {
 	}
 }
 
+// printTransferErrors prints the errors from a transfer queue. Those from a
+// transfer already name the object and include the underlying error. They're
+// logged together, fatal or not, as the cause is easily lost among parallel
+// transfers, and a failed batch fails every object in it.
+func printTransferErrors(errs []error) {
+	for _, err := range errs {
+		if !Debugging && !lfs.IsFatalError(err) {
+			if inner := lfs.GetInnerError(err); inner != nil && lfs.TransferFailureOf(err) == nil && inner.Error() != err.Error() {
+				Error(inner.Error())
+			}
+		}
+		Error(err.Error())
+	}
+
+	if len(errs) > 0 {
+		printLogFile(logPanic(errs...))
+	}
+}
+
+// objectResults counts the objects a command transferred or checked out, and
+// those it failed to, so it can say how many failed and exit with a code that
+// tells complete and partial failure apart. With lfs.Config.RecordTransfers,
+// it also keeps each object's result, see printTransfersJSON.
+type objectResults struct {
+	mutex     sync.Mutex
+	succeeded int
+	failed    int
+	results   []lfs.TransferResult
+	errors    []error
+}
+
+var (
+	transfers = &objectResults{} // across all of a command's transfer queues
+	checkouts = &objectResults{} // files written to the working tree
+)
+
+func (r *objectResults) add(succeeded, failed int) {
+	r.mutex.Lock()
+	r.succeeded += succeeded
+	r.failed += failed
+	r.mutex.Unlock()
+}
+
+// addQueue counts the results of a finished transfer queue.
+func (r *objectResults) addQueue(q *lfs.TransferQueue) {
+	r.add(q.Transferred(), len(q.Errors()))
+
+	if lfs.Config.RecordTransfers {
+		r.mutex.Lock()
+		r.results = append(r.results, q.Results()...)
+		r.errors = append(r.errors, q.Errors()...)
+		r.mutex.Unlock()
+	}
+}
+
+// skip records that p wasn't queued for transfer, and why, eg
+// lfs.SkippedExcluded.
+func (r *objectResults) skip(p *lfs.WrappedPointer, reason string) {
+	if !lfs.Config.RecordTransfers {
+		return
+	}
+
+	r.mutex.Lock()
+	r.results = append(r.results, lfs.TransferResult{Oid: p.Oid, Size: p.Size, Path: p.Name, SkipReason: reason})
+	r.mutex.Unlock()
+}
+
+// exitIfFailed exits if any objects failed, after printing how many: with
+// ExitCodePartialFailure if others succeeded, or else ExitCodeFailure. what
+// describes the operation, eg "download".
+func (r *objectResults) exitIfFailed(what string) {
+	r.mutex.Lock()
+	succeeded, failed := r.succeeded, r.failed
+	r.mutex.Unlock()
+
+	if failed == 0 {
+		return
+	}
+
+	Error("%d of %d objects failed to %s", failed, succeeded+failed, what)
+	if succeeded > 0 {
+		os.Exit(ExitCodePartialFailure)
+	}
+	os.Exit(ExitCodeFailure)
+}
+
 // Debug prints a formatted message if debugging is enabled.  The formatted
 // message also shows up in the panic log, if created.
 func Debug(format string, args ...interface{}) {
@@ -81,8 +181,10 @@ func Debug(format string, args ...interface{}) {
 // the error to a log file without exiting.
 func LoggedError(err error, format string, args ...interface{}) {
 	Error(format, args...)
-	file := handlePanic(err)
+	printLogFile(handlePanic(err))
+}
 
+func printLogFile(file string) {
 	if len(file) > 0 {
 		fmt.Fprintf(os.Stderr, "\nErrors logged to %s\nUse `git lfs logs last` to view the log.\n", file)
 	}
@@ -92,11 +194,29 @@ func LoggedError(err error, format string, args ...interface{}) {
 // a log file before exiting.
 func Panic(err error, format string, args ...interface{}) {
 	LoggedError(err, format, args...)
-	os.Exit(2)
+	os.Exit(ExitCodeFailure)
 }
 
 func Run() {
-	RootCmd.Execute()
+	// log any panic in a command, rather than only printing its stack
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(error)
+			if !ok {
+				err = fmt.Errorf("%v", r)
+			}
+			Panic(lfs.Errorf(err, "panic: %v", r), "Unexpected error: %v", r)
+		}
+	}()
+
+	if len(os.Args) > 1 {
+		runExternalCommand(os.Args[1], os.Args[2:])
+	}
+
+	if err := RootCmd.Execute(); err != nil {
+		// cobra has already printed the error and usage
+		os.Exit(ExitCodeUsage)
+	}
 }
 
 func PipeMediaCommand(name string, args ...string) error {
@@ -115,7 +235,7 @@ func requireStdin(msg string) {
 	stat, _ := os.Stdin.Stat()
 	if (stat.Mode() & os.ModeCharDevice) != 0 {
 		Error("Cannot read from STDIN. %s", msg)
-		os.Exit(1)
+		os.Exit(ExitCodeUsage)
 	}
 }
 
@@ -126,6 +246,18 @@ func requireInRepo() {
 	}
 }
 
+// clearTempObjects opportunistically removes temp files left behind by
+// interrupted transfers before starting new ones.
+func clearTempObjects() {
+	removed, err := lfs.ClearTempObjects()
+	if err != nil {
+		Debug("Unable to clear temp files: %s", err)
+	}
+	for _, path := range removed {
+		Debug("Removed stale temp file %s", path)
+	}
+}
+
 func handlePanic(err error) string {
 	if err == nil {
 		return ""
@@ -134,78 +266,19 @@ func handlePanic(err error) string {
 	return logPanic(err)
 }
 
-func logPanic(loggedError error) string {
-	var fmtWriter io.Writer = os.Stderr
-
-	now := time.Now()
-	name := now.Format("20060102T150405.999999999")
-	full := filepath.Join(lfs.LocalLogDir, name+".log")
-
-	if err := os.MkdirAll(lfs.LocalLogDir, 0755); err != nil {
-		full = ""
-		fmt.Fprintf(fmtWriter, "Unable to log panic to %s: %s\n\n", lfs.LocalLogDir, err.Error())
-	} else if file, err := os.Create(full); err != nil {
-		filename := full
-		full = ""
-		defer func() {
-			fmt.Fprintf(fmtWriter, "Unable to log panic to %s\n\n", filename)
-			logPanicToWriter(fmtWriter, err)
-		}()
-	} else {
-		fmtWriter = file
-		defer file.Close()
-	}
-
-	logPanicToWriter(fmtWriter, loggedError)
-
-	return full
-}
-
-func logPanicToWriter(w io.Writer, loggedError error) {
-	// log the version
-	gitV, err := git.Config.Version()
+// logPanic writes the errors to a new log file and returns its path. If it
+// can't, the report goes to stderr instead, so that it's never lost.
+func logPanic(loggedErrors ...error) string {
+	full, err := lfs.LogErrors(loggedErrors, ErrorBuffer.Bytes())
 	if err != nil {
-		gitV = "Error getting git version: " + err.Error()
-	}
-
-	fmt.Fprintln(w, lfs.UserAgent)
-	fmt.Fprintln(w, gitV)
-
-	// log the command that was run
-	fmt.Fprintln(w)
-	fmt.Fprintf(w, "$ %s", filepath.Base(os.Args[0]))
-	if len(os.Args) > 0 {
-		fmt.Fprintf(w, " %s", strings.Join(os.Args[1:], " "))
-	}
-	fmt.Fprintln(w)
-
-	// log the error message and stack trace
-	w.Write(ErrorBuffer.Bytes())
-	fmt.Fprintln(w)
-
-	fmt.Fprintln(w, loggedError.Error())
-
-	if err, ok := loggedError.(ErrorWithStack); ok {
-		fmt.Fprintln(w, err.InnerError())
-		for key, value := range err.Context() {
-			fmt.Fprintf(w, "%s=%s\n", key, value)
+		fmt.Fprintf(os.Stderr, "Unable to log panic to %s: %s\n\n", lfs.LocalLogDir, err.Error())
+		for _, loggedError := range loggedErrors {
+			lfs.WriteErrorReport(os.Stderr, loggedError, ErrorBuffer.Bytes())
 		}
-		w.Write(err.Stack())
-	} else {
-		w.Write(lfs.Stack())
-	}
-	fmt.Fprintln(w, "\nENV:")
-
-	// log the environment
-	for _, env := range lfs.Environ() {
-		fmt.Fprintln(w, env)
+		return ""
 	}
-}
 
-type ErrorWithStack interface {
-	Context() map[string]string
-	InnerError() string
-	Stack() []byte
+	return full
 }
 
 // determineIncludeExcludePaths is a common function to take the string arguments
@@ -218,26 +291,46 @@ func determineIncludeExcludePaths(includeArg, excludeArg string) (include, exclu
 			inc = strings.TrimSpace(inc)
 			includePaths = append(includePaths, inc)
 		}
+		if err := lfs.ValidateFilterPatterns(includePaths); err != nil {
+			ExitUsage("Invalid --include: %s", err)
+		}
 	} else {
 		includePaths = lfs.Config.FetchIncludePaths()
+		requireValidFilterPatterns("lfs.fetchinclude", includePaths)
 	}
 	if len(excludeArg) > 0 {
 		for _, ex := range strings.Split(excludeArg, ",") {
 			ex = strings.TrimSpace(ex)
 			excludePaths = append(excludePaths, ex)
 		}
+		if err := lfs.ValidateFilterPatterns(excludePaths); err != nil {
+			ExitUsage("Invalid --exclude: %s", err)
+		}
 	} else {
 		excludePaths = lfs.Config.FetchExcludePaths()
+		requireValidFilterPatterns("lfs.fetchexclude", excludePaths)
 	}
 	return includePaths, excludePaths
 }
 
+// requireValidFilterPatterns exits if the patterns from the config key can't
+// be used, see lfs.ValidateFilterPatterns.
+func requireValidFilterPatterns(key string, patterns []string) {
+	if err := lfs.ValidateFilterPatterns(patterns); err != nil {
+		Exit("Invalid %s: %s", key, err)
+	}
+}
+
 func printHelp(commandName string) {
 	if txt, ok := ManPages[commandName]; ok {
 		fmt.Fprintf(os.Stderr, "%s\n", strings.TrimSpace(txt))
 	} else {
 		fmt.Fprintf(os.Stderr, "Sorry, no usage text found for %q\n", commandName)
 	}
+
+	if commandName == "git-lfs" {
+		printExternalCommands()
+	}
 }
 
 // help is used for 'git-lfs help <command>'
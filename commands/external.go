@@ -0,0 +1,140 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// externalPrefix starts the name of an executable in PATH that runs as an
+// external command, so "git lfs audit" runs git-lfs-audit, the way git finds
+// git-<name>.
+const externalPrefix = "git-lfs-"
+
+// windowsExecutableExts are the suffixes external commands can have on
+// Windows, which are dropped from their command names.
+var windowsExecutableExts = []string{".exe", ".bat", ".cmd"}
+
+// runExternalCommand runs git-lfs-<name> from PATH with args, if name isn't a
+// built in command and there is one, and exits with its exit code. Otherwise
+// it returns, and the command is left to cobra.
+func runExternalCommand(name string, args []string) {
+	if len(name) == 0 || strings.HasPrefix(name, "-") || isBuiltinCommand(name) {
+		return
+	}
+
+	path, err := exec.LookPath(externalPrefix + name)
+	if err != nil {
+		return
+	}
+
+	Debug("Running external command %s", path)
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			os.Exit(externalExitCode(status))
+		}
+		os.Exit(ExitCodeFailure)
+	}
+	if err != nil {
+		Exit("Could not run %s: %s", path, err)
+	}
+	os.Exit(0)
+}
+
+// externalExitCode returns the code to exit with for an external command that
+// exited with status, using 128 + the signal number if it was killed by one.
+func externalExitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+func isBuiltinCommand(name string) bool {
+	// cobra adds the help command when it runs
+	if name == "help" {
+		return true
+	}
+
+	for _, cmd := range RootCmd.Commands() {
+		if cmd.Name() == name || cmd.HasAlias(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// externalCommands returns the sorted names of the external commands in PATH.
+func externalCommands() []string {
+	seen := make(map[string]bool)
+	var names []string
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if len(dir) == 0 {
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			name, ok := externalCommandName(entry)
+			if ok && !seen[name] && !isBuiltinCommand(name) {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// externalCommandName returns the command that the file runs, if it's an
+// external command.
+func externalCommandName(info os.FileInfo) (string, bool) {
+	name := info.Name()
+	if info.IsDir() || !strings.HasPrefix(name, externalPrefix) {
+		return "", false
+	}
+	name = strings.TrimPrefix(name, externalPrefix)
+
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(name))
+		for _, e := range windowsExecutableExts {
+			if ext == e {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+				return name, len(name) > 0
+			}
+		}
+		return "", false
+	}
+
+	return name, len(name) > 0 && info.Mode()&0111 != 0
+}
+
+func printExternalCommands() {
+	names := externalCommands()
+	if len(names) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "\nExternal commands found in PATH:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "    %s\n", name)
+	}
+}
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/github/git-lfs/lfs"
+)
+
+// transfersJSON is set by --json for fetch, pull and push, see
+// useTransfersJSON.
+var transfersJSON bool
+
+type transferredJSON struct {
+	Oid      string  `json:"oid"`
+	Size     int64   `json:"size"`
+	Path     string  `json:"path,omitempty"`
+	Duration float64 `json:"duration"` // in seconds
+	Retries  int     `json:"retries"`
+}
+
+type skippedJSON struct {
+	Oid    string `json:"oid"`
+	Size   int64  `json:"size"`
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type transferErrorJSON struct {
+	Oid   string `json:"oid,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error"`
+}
+
+type transfersResultJSON struct {
+	Transferred []*transferredJSON   `json:"transferred"`
+	Skipped     []*skippedJSON       `json:"skipped"`
+	Errors      []*transferErrorJSON `json:"errors"`
+}
+
+// useTransfersJSON sets up for --json: the result of each object is recorded,
+// and stdout is kept for the JSON, so the progress meter is turned off and
+// other output goes to stderr.
+func useTransfersJSON() {
+	if !transfersJSON {
+		return
+	}
+
+	lfs.Config.RecordTransfers = true
+	lfs.Config.NoProgress = true
+	OutputWriter = io.MultiWriter(os.Stderr, ErrorBuffer)
+}
+
+// printTransfersJSON prints the results of the command's transfers to stdout
+// as JSON, if --json was given.
+func printTransfersJSON() {
+	if !transfersJSON {
+		return
+	}
+
+	transfers.mutex.Lock()
+	defer transfers.mutex.Unlock()
+
+	result := &transfersResultJSON{
+		Transferred: make([]*transferredJSON, 0),
+		Skipped:     make([]*skippedJSON, 0),
+		Errors:      make([]*transferErrorJSON, 0, len(transfers.errors)),
+	}
+
+	for _, r := range transfers.results {
+		if len(r.SkipReason) > 0 {
+			result.Skipped = append(result.Skipped, &skippedJSON{r.Oid, r.Size, r.Path, r.SkipReason})
+		} else {
+			result.Transferred = append(result.Transferred, &transferredJSON{r.Oid, r.Size, r.Path, r.Duration.Seconds(), r.Retries})
+		}
+	}
+
+	for _, err := range transfers.errors {
+		e := &transferErrorJSON{Error: err.Error()}
+		if f := lfs.TransferFailureOf(err); f != nil {
+			e.Oid, e.Path, e.Error = f.Oid, f.Path, f.Err.Error()
+		}
+		result.Errors = append(result.Errors, e)
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+		Panic(err, "Error writing transfer results")
+	}
+}
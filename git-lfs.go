@@ -6,29 +6,41 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/github/git-lfs/commands"
 	"github.com/github/git-lfs/lfs"
 )
 
+// How long in flight transfers get to notice they've been interrupted
+const interruptTimeout = 2 * time.Second
+
 func main() {
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	var once sync.Once
 
 	go func() {
-		for {
+		sig := <-c
+
+		go func() {
+			// Don't wait around to clean up if signalled again
 			sig := <-c
-			once.Do(clearTempObjects)
-			fmt.Fprintf(os.Stderr, "\nExiting because of %q signal.\n", sig)
+			fmt.Fprintf(os.Stderr, "\nExiting immediately because of %q signal.\n", sig)
+			os.Exit(signalExitCode(sig))
+		}()
+
+		lfs.Interrupt()
+		transferred, total := lfs.AbortRun(interruptTimeout)
+		once.Do(clearTempObjects)
 
-			exitCode := 1
-			if sysSig, ok := sig.(syscall.Signal); ok {
-				exitCode = int(sysSig)
-			}
-			os.Exit(exitCode + 128)
+		if total > 0 {
+			fmt.Fprintf(os.Stderr, "\nInterrupted: %d of %d objects transferred\n", transferred, total)
+		} else {
+			fmt.Fprintf(os.Stderr, "\nExiting because of %q signal.\n", sig)
 		}
+		os.Exit(signalExitCode(sig))
 	}()
 
 	commands.Run()
@@ -36,8 +48,18 @@ func main() {
 	once.Do(clearTempObjects)
 }
 
+// signalExitCode returns the exit code conventional for being killed by sig,
+// eg 130 for SIGINT and 143 for SIGTERM.
+func signalExitCode(sig os.Signal) int {
+	exitCode := 1
+	if sysSig, ok := sig.(syscall.Signal); ok {
+		exitCode = int(sysSig)
+	}
+	return exitCode + 128
+}
+
 func clearTempObjects() {
-	if err := lfs.ClearTempObjects(); err != nil {
+	if _, err := lfs.ClearTempObjects(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening %q to clear old temp files: %s\n", lfs.LocalObjectTempDir, err)
 	}
 }
@@ -8,12 +8,15 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/github/git-lfs/subprocess"
@@ -63,19 +66,80 @@ func LsRemote(remote, remoteRef string) (string, error) {
 	return subprocess.SimpleExec("git", "ls-remote", remote, remoteRef)
 }
 
-func ResolveRef(ref string) (*Ref, error) {
-	outp, err := subprocess.SimpleExec("git", "rev-parse", ref, "--symbolic-full-name", ref)
+// UnknownRevisionError is returned by ResolveRef for a revision git can't find.
+type UnknownRevisionError struct {
+	Rev string
+}
+
+func (e *UnknownRevisionError) Error() string {
+	return fmt.Sprintf("Git can't resolve ref: %q", e.Rev)
+}
+
+// IsUnknownRevisionError returns whether err is an UnknownRevisionError.
+func IsUnknownRevisionError(err error) bool {
+	_, ok := err.(*UnknownRevisionError)
+	return ok
+}
+
+var shaRegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ResolveRef returns the Ref for spec, which can be anything git rev-parse
+// accepts, eg "master~3", "v1.2^{}" or an abbreviated SHA. Branches and tags
+// get their own type and short name, HEAD is RefTypeHEAD when it's detached,
+// and anything else is RefTypeOther named spec, or a local tag if it's a tag
+// object. If git can't find spec the error is an UnknownRevisionError.
+func ResolveRef(spec string) (*Ref, error) {
+	sha, err := revParseVerify(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	fullname, err := revParseVerify("--symbolic-full-name", spec)
 	if err != nil {
 		return nil, err
 	}
-	lines := strings.Split(outp, "\n")
-	if len(lines) <= 1 {
-		return nil, fmt.Errorf("Git can't resolve ref: %q", ref)
+	if len(fullname) > 0 {
+		ref := &Ref{Sha: sha}
+		ref.Type, ref.Name = ParseRefToTypeAndName(fullname)
+		return ref, nil
+	}
+
+	switch objtype := ObjectType(sha); objtype {
+	case "commit":
+		return &Ref{Name: spec, Type: RefTypeOther, Sha: sha}, nil
+	case "tag":
+		return &Ref{Name: spec, Type: RefTypeLocalTag, Sha: sha}, nil
+	default:
+		return nil, fmt.Errorf("%q is a %s, not a commit", spec, objtype)
+	}
+}
+
+// revParseVerify runs git rev-parse --verify with args, returning its output,
+// which must be a full SHA unless it's asked for something else.
+func revParseVerify(args ...string) (string, error) {
+	rev := args[len(args)-1]
+	cmd := subprocess.ExecCommand("git", append([]string{"rev-parse", "--verify", "--quiet"}, args...)...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	outp, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		// --quiet makes git exit 1 with no message for an unknown revision,
+		// but not for other problems, eg not being in a repository
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() == 1 && stderr.Len() == 0 {
+			return "", &UnknownRevisionError{Rev: rev}
+		}
+		return "", fmt.Errorf("Git can't resolve ref: %q: %v", rev, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to call git rev-parse: %v", err)
 	}
 
-	fullref := &Ref{Sha: lines[0]}
-	fullref.Type, fullref.Name = ParseRefToTypeAndName(lines[1])
-	return fullref, nil
+	out := strings.TrimSpace(string(outp))
+	if len(args) == 1 && !shaRegexp.MatchString(out) {
+		return "", fmt.Errorf("Unexpected output from git rev-parse %s: %q", rev, out)
+	}
+	return out, nil
 }
 
 func CurrentRef() (*Ref, error) {
@@ -115,12 +179,13 @@ func RemoteRefNameForCurrentBranch() (string, error) {
 		return "", errors.New("not on a branch")
 	}
 
-	remote := RemoteForBranch(ref.Name)
-	if remote == "" {
+	remote, remotebranch, err := RemoteBranchForLocalBranch(ref.Name)
+	if err == ErrNoUpstream {
 		return "", fmt.Errorf("remote not found for branch %q", ref.Name)
 	}
-
-	remotebranch := RemoteBranchForLocalBranch(ref.Name)
+	if err != nil {
+		return "", err
+	}
 
 	return remote + "/" + remotebranch, nil
 }
@@ -130,19 +195,108 @@ func RemoteForBranch(localBranch string) string {
 	return Config.Find(fmt.Sprintf("branch.%s.remote", localBranch))
 }
 
-// RemoteBranchForLocalBranch returns the name (only) of the remote branch that the local branch is tracking
-// If no specific branch is configured, returns local branch name
-func RemoteBranchForLocalBranch(localBranch string) string {
+// ErrNoUpstream is returned by RemoteBranchForLocalBranch for a branch that
+// isn't tracking a remote branch.
+var ErrNoUpstream = errors.New("no upstream branch configured")
+
+// upstream is the branch.<name>.remote and branch.<name>.merge config of a
+// local branch.
+type upstream struct {
+	remote string
+	merge  string
+}
+
+var (
+	upstreamsMutex sync.Mutex
+	// upstreams caches the tracking config of every local branch, by the
+	// working directory it was read in, so each repo is only read once
+	upstreams = make(map[string]map[string]*upstream)
+)
+
+// RemoteBranchForLocalBranch returns the remote, and the name (only) of the
+// remote branch, that the local branch is tracking, or ErrNoUpstream if it
+// isn't. If a remote but no specific branch is configured, the remote branch
+// has the local branch's name. The tracking config of all local branches is
+// read at once and cached, so resolving many branches is cheap.
+func RemoteBranchForLocalBranch(localBranch string) (remote, remoteBranch string, err error) {
+	branches, err := branchUpstreams()
+	if err != nil {
+		return "", "", err
+	}
+
+	u, ok := branches[localBranch]
+	if !ok || len(u.remote) == 0 {
+		return "", "", ErrNoUpstream
+	}
+
 	// get remote ref to track, may not be same name
-	merge := Config.Find(fmt.Sprintf("branch.%s.merge", localBranch))
-	if strings.HasPrefix(merge, "refs/heads/") {
-		return merge[11:]
-	} else {
-		return localBranch
+	if strings.HasPrefix(u.merge, "refs/heads/") {
+		return u.remote, u.merge[11:], nil
+	}
+	return u.remote, localBranch, nil
+}
+
+// branchUpstreams returns the tracking config of the local branches in the
+// current repo, reading it with one git config call the first time.
+func branchUpstreams() (map[string]*upstream, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	upstreamsMutex.Lock()
+	defer upstreamsMutex.Unlock()
+
+	if branches, ok := upstreams[wd]; ok {
+		return branches, nil
+	}
+
+	// exits 1 with no output if no branch has any
+	out, err := subprocess.SimpleExec("git", "config", "--get-regexp", `^branch\..*\.(remote|merge)$`)
+	if err != nil {
+		return nil, err
 	}
 
+	branches := parseBranchUpstreams(out)
+	upstreams[wd] = branches
+	return branches, nil
+}
+
+// parseBranchUpstreams parses "git config --get-regexp" output for
+// branch.<name>.remote and branch.<name>.merge keys. Branch names can contain
+// dots, but the variable names can't.
+func parseBranchUpstreams(out string) map[string]*upstream {
+	branches := make(map[string]*upstream)
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) < 2 || !strings.HasPrefix(parts[0], "branch.") {
+			continue
+		}
+
+		key := parts[0][len("branch."):]
+		dot := strings.LastIndex(key, ".")
+		if dot < 1 {
+			continue
+		}
+		name := key[:dot]
+
+		u, ok := branches[name]
+		if !ok {
+			u = &upstream{}
+			branches[name] = u
+		}
+
+		switch key[dot+1:] {
+		case "remote":
+			u.remote = parts[1]
+		case "merge":
+			u.merge = parts[1]
+		}
+	}
+	return branches
 }
 
+// RemoteList returns the names of the configured remotes.
 func RemoteList() ([]string, error) {
 	cmd := subprocess.ExecCommand("git", "remote")
 
@@ -150,8 +304,9 @@ func RemoteList() ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to call git remote: %v", err)
 	}
-	cmd.Start()
-	defer cmd.Wait()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to call git remote: %v", err)
+	}
 
 	scanner := bufio.NewScanner(outp)
 
@@ -160,9 +315,114 @@ func RemoteList() ([]string, error) {
 		ret = append(ret, strings.TrimSpace(scanner.Text()))
 	}
 
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("Failed to call git remote: %v", err)
+	}
 	return ret, nil
 }
 
+// RemoteURLs returns the URLs of each remote, with any url.<base>.insteadOf
+// rewrites applied. A remote can have several. With pushOnly, they're the URLs
+// pushed to, which are the remote.<name>.pushurl ones if there are any, else
+// the remote.<name>.url ones with url.<base>.pushInsteadOf rewrites too.
+func RemoteURLs(pushOnly bool) (map[string][]string, error) {
+	remotes, err := RemoteList()
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make(map[string][]string, len(remotes))
+	if !Config.IsGitVersionAtLeast("2.7.0") {
+		// no git remote get-url, so work them out from the config
+		values := Config.repo().all
+		for _, remote := range remotes {
+			urls[remote] = remoteURLsFromConfig(values, remote, pushOnly)
+		}
+		return urls, nil
+	}
+
+	for _, remote := range remotes {
+		args := []string{"remote", "get-url", "--all"}
+		if pushOnly {
+			args = append(args, "--push")
+		}
+		args = append(args, remote)
+
+		outp, err := subprocess.ExecCommand("git", args...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to call git remote get-url: %v", err)
+		}
+		for _, url := range strings.Split(string(outp), "\n") {
+			if url = strings.TrimSpace(url); len(url) > 0 {
+				urls[remote] = append(urls[remote], url)
+			}
+		}
+	}
+	return urls, nil
+}
+
+// remoteURLsFromConfig returns the URLs of remote as RemoteURLs does, the way
+// git remote get-url would from the config values.
+func remoteURLsFromConfig(values *configValues, remote string, pushOnly bool) []string {
+	var urls, pushurls []string
+	insteadOf := make(map[string]string)
+	pushInsteadOf := make(map[string]string)
+
+	for _, e := range values.entries {
+		switch {
+		case e.key == "remote."+remote+".url":
+			urls = append(urls, e.value)
+		case e.key == "remote."+remote+".pushurl":
+			pushurls = append(pushurls, e.value)
+		case strings.HasPrefix(e.key, "url.") && strings.HasSuffix(e.key, ".insteadof"):
+			insteadOf[e.value] = e.key[len("url.") : len(e.key)-len(".insteadof")]
+		case strings.HasPrefix(e.key, "url.") && strings.HasSuffix(e.key, ".pushinsteadof"):
+			pushInsteadOf[e.value] = e.key[len("url.") : len(e.key)-len(".pushinsteadof")]
+		}
+	}
+
+	// like git, a remote without a URL uses its name as one
+	if len(urls) == 0 {
+		urls = []string{remote}
+	}
+
+	var ret []string
+	switch {
+	case !pushOnly:
+		for _, url := range urls {
+			ret = append(ret, RewriteURL(url, insteadOf))
+		}
+	case len(pushurls) > 0:
+		for _, url := range pushurls {
+			ret = append(ret, RewriteURL(url, insteadOf))
+		}
+	default:
+		for _, url := range urls {
+			if rewritten := RewriteURL(url, pushInsteadOf); rewritten != url {
+				ret = append(ret, rewritten)
+			} else {
+				ret = append(ret, RewriteURL(url, insteadOf))
+			}
+		}
+	}
+	return ret
+}
+
+// RewriteURL replaces the longest prefix of url that's a key of rewrites with
+// its value, as git does for url.<base>.insteadOf.
+func RewriteURL(url string, rewrites map[string]string) string {
+	longest := ""
+	for prefix := range rewrites {
+		if strings.HasPrefix(url, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if len(longest) == 0 {
+		return url
+	}
+	return rewrites[longest] + url[len(longest):]
+}
+
 // ValidateRemote checks that a named remote is valid for use
 // Mainly to check user-supplied remotes & fail more nicely
 func ValidateRemote(remote string) error {
@@ -216,41 +476,285 @@ func UpdateIndex(file string) error {
 	return err
 }
 
+// updateIndexBatchSize is the most paths UpdateIndexBatch gives to one git
+// update-index process, so a huge checkout doesn't hold the index lock for the
+// whole of it.
+const updateIndexBatchSize = 10000
+
+// UpdateIndexBatch refreshes the index entries of paths, which are relative to
+// the current directory, after their files have been rewritten, eg by checkout
+// replacing pointers with content, so git doesn't think they're modified. Paths
+// are given to git update-index over stdin, so any number can be done with
+// one process per updateIndexBatchSize.
+func UpdateIndexBatch(paths []string) error {
+	for len(paths) > 0 {
+		n := len(paths)
+		if n > updateIndexBatchSize {
+			n = updateIndexBatchSize
+		}
+		if err := updateIndex(paths[:n]); err != nil {
+			return err
+		}
+		paths = paths[n:]
+	}
+	return nil
+}
+
+func updateIndex(paths []string) error {
+	cmd := subprocess.ExecCommand("git", "update-index", "-q", "--refresh", "-z", "--stdin")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("Failed to call git update-index: %v", err)
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	tracerx.Printf("run_command: git update-index -q --refresh -z --stdin (%d paths)", len(paths))
+	if err := subprocess.Start(cmd); err != nil {
+		return fmt.Errorf("Failed to call git update-index: %v", err)
+	}
+
+	w := bufio.NewWriter(stdin)
+	for _, path := range paths {
+		w.WriteString(path)
+		w.WriteByte(0)
+	}
+	// a write error means git has exited, which Wait reports
+	w.Flush()
+	stdin.Close()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("Error in git update-index: %v %v", err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// gitConfig reads and writes git config. Values are read with one git config
+// call per repo, and the git version once, then served from memory until
+// ClearCache is called or the config is changed through it.
 type gitConfig struct {
+	mu sync.Mutex
+	// version is the output of git version, once it's been read
+	version string
+	// repos has the config read in each working directory
+	repos map[string]*repoConfig
+	// global is the global config, once it's been read
+	global *configValues
+	// system is the system config, once it's been read
+	system *configValues
+}
+
+// repoConfig has the config of a repo.
+type repoConfig struct {
+	// all is every value git sees, from the system, global, local and any
+	// included config files, in the order git reads them
+	all *configValues
+	// local is the repo's own config file, once it's been read
+	local *configValues
+}
+
+// configValues is the config from one source, in the order it's listed.
+type configValues struct {
+	entries []configEntry
+	// values has every value of each key, in order
+	values map[string][]string
+}
+
+type configEntry struct {
+	key   string
+	value string
+	// hasValue is false for a key with no "=", which git treats as true
+	hasValue bool
 }
 
 var Config = &gitConfig{}
 
 // Find returns the git config value for the key
+// As with git, the last value of a key wins, which is the value from the
+// highest precedence config.
 func (c *gitConfig) Find(val string) string {
-	output, _ := subprocess.SimpleExec("git", "config", val)
-	return output
+	return c.repo().all.find(val)
 }
 
 // Find returns the git config value for the key
 func (c *gitConfig) FindGlobal(val string) string {
-	output, _ := subprocess.SimpleExec("git", "config", "--global", val)
-	return output
+	c.mu.Lock()
+	if c.global == nil {
+		c.global = readConfigValues("--global")
+	}
+	global := c.global
+	c.mu.Unlock()
+	return global.find(val)
+}
+
+// IsSetInRepo returns whether key is set anywhere but the global and system
+// config: in the repository's own config, or with git -c. The key is matched
+// case insensitively.
+func (c *gitConfig) IsSetInRepo(key string) bool {
+	all := c.repo().all
+
+	c.mu.Lock()
+	if c.global == nil {
+		c.global = readConfigValues("--global")
+	}
+	if c.system == nil {
+		c.system = readConfigValues("--system")
+	}
+	global, system := c.global, c.system
+	c.mu.Unlock()
+
+	return all.count(key) > global.count(key)+system.count(key)
 }
 
 // Find returns the git config value for the key
 func (c *gitConfig) FindLocal(val string) string {
-	output, _ := subprocess.SimpleExec("git", "config", "--local", val)
-	return output
+	repo := c.repo()
+	c.mu.Lock()
+	if repo.local == nil {
+		repo.local = readConfigValues("--local")
+	}
+	local := repo.local
+	c.mu.Unlock()
+	return local.find(val)
+}
+
+// ClearCache forgets all the config and git version read so far, so they're
+// read again from git when next needed.
+func (c *gitConfig) ClearCache() {
+	c.mu.Lock()
+	c.version = ""
+	c.mu.Unlock()
+
+	c.clearConfigCache()
+}
+
+// clearConfigCache forgets the config read so far, after it's been changed.
+func (c *gitConfig) clearConfigCache() {
+	c.mu.Lock()
+	c.repos = nil
+	c.global = nil
+	c.system = nil
+	c.mu.Unlock()
+
+	upstreamsMutex.Lock()
+	upstreams = make(map[string]map[string]*upstream)
+	upstreamsMutex.Unlock()
+}
+
+// repo returns the config of the repo in the current directory, reading it
+// the first time.
+func (c *gitConfig) repo() *repoConfig {
+	// a failure just means the config isn't shared with other directories
+	wd, _ := os.Getwd()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.repos == nil {
+		c.repos = make(map[string]*repoConfig)
+	}
+	repo, ok := c.repos[wd]
+	if !ok {
+		repo = &repoConfig{all: readConfigValues()}
+		c.repos[wd] = repo
+	}
+	return repo
+}
+
+// readConfigValues lists the config with git config -l and any args, eg
+// "--global". It's empty if there isn't any, or git can't read it.
+func readConfigValues(args ...string) *configValues {
+	args = append([]string{"config", "-l", "-z"}, args...)
+	tracerx.Printf("run_command: git %s", strings.Join(args, " "))
+	out, err := subprocess.ExecCommand("git", args...).Output()
+	if err != nil {
+		tracerx.Printf("Error listing git config: %v", err)
+		return parseConfigValues(nil)
+	}
+	return parseConfigValues(out)
+}
+
+// parseConfigValues parses the output of git config -l -z, where each entry is
+// the key, then a LF and the value if it has one, then a NUL.
+func parseConfigValues(out []byte) *configValues {
+	values := &configValues{values: make(map[string][]string)}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		if len(entry) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "\n", 2)
+		e := configEntry{key: parts[0]}
+		if len(parts) > 1 {
+			e.value = parts[1]
+			e.hasValue = true
+		}
+		values.entries = append(values.entries, e)
+		values.values[e.key] = append(values.values[e.key], e.value)
+	}
+	return values
+}
+
+// find returns the last value of key, or "" if it isn't set.
+func (v *configValues) find(key string) string {
+	values := v.values[normalizeConfigKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.Trim(values[len(values)-1], " \n")
+}
+
+// count returns how many values key has, matching it case insensitively.
+func (v *configValues) count(key string) int {
+	n := 0
+	for _, e := range v.entries {
+		if strings.EqualFold(e.key, key) {
+			n++
+		}
+	}
+	return n
+}
+
+// list returns the entries as git config -l does, one "key=value" per line.
+func (v *configValues) list() string {
+	lines := make([]string, 0, len(v.entries))
+	for _, e := range v.entries {
+		if e.hasValue {
+			lines = append(lines, e.key+"="+e.value)
+		} else {
+			lines = append(lines, e.key)
+		}
+	}
+	return strings.Trim(strings.Join(lines, "\n"), " \n")
+}
+
+// normalizeConfigKey returns key as git lists it, with the section and variable
+// names lower case. A subsection, between them, is case sensitive.
+func normalizeConfigKey(key string) string {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first < 0 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
 }
 
 // SetGlobal sets the git config value for the key in the global config
 func (c *gitConfig) SetGlobal(key, val string) {
 	subprocess.SimpleExec("git", "config", "--global", key, val)
+	c.clearConfigCache()
 }
 
 // UnsetGlobal removes the git config value for the key from the global config
 func (c *gitConfig) UnsetGlobal(key string) {
 	subprocess.SimpleExec("git", "config", "--global", "--unset", key)
+	c.clearConfigCache()
 }
 
 func (c *gitConfig) UnsetGlobalSection(key string) {
 	subprocess.SimpleExec("git", "config", "--global", "--remove-section", key)
+	c.clearConfigCache()
 }
 
 // SetLocal sets the git config value for the key in the specified config file
@@ -262,6 +766,7 @@ func (c *gitConfig) SetLocal(file, key, val string) {
 	}
 	args = append(args, key, val)
 	subprocess.SimpleExec("git", args...)
+	c.clearConfigCache()
 }
 
 // UnsetLocalKey removes the git config value for the key from the specified config file
@@ -273,11 +778,12 @@ func (c *gitConfig) UnsetLocalKey(file, key string) {
 	}
 	args = append(args, "--unset", key)
 	subprocess.SimpleExec("git", args...)
+	c.clearConfigCache()
 }
 
 // List lists all of the git config values
 func (c *gitConfig) List() (string, error) {
-	return subprocess.SimpleExec("git", "config", "-l")
+	return c.repo().all.list(), nil
 }
 
 // ListFromFile lists all of the git config values in the given config file
@@ -285,13 +791,32 @@ func (c *gitConfig) ListFromFile(f string) (string, error) {
 	return subprocess.SimpleExec("git", "config", "-l", "-f", f)
 }
 
+// ListFromBlob lists all of the git config values in the config file stored in
+// the given blob, eg "HEAD:.lfsconfig"
+func (c *gitConfig) ListFromBlob(blob string) (string, error) {
+	return subprocess.SimpleExec("git", "config", "-l", "--blob", blob)
+}
+
 // Version returns the git version
 func (c *gitConfig) Version() (string, error) {
-	return subprocess.SimpleExec("git", "version")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.version) > 0 {
+		return c.version, nil
+	}
+
+	version, err := subprocess.SimpleExec("git", "version")
+	if err != nil {
+		return version, err
+	}
+	c.version = version
+	return version, nil
 }
 
 // IsVersionAtLeast returns whether the git version is the one specified or higher
 // argument is plain version string separated by '.' e.g. "2.3.1" but can omit minor/patch
+// Vendor suffixes on the git version, eg "2.8.1.windows.1", are ignored
 func (c *gitConfig) IsGitVersionAtLeast(ver string) bool {
 	gitver, err := c.Version()
 	if err != nil {
@@ -307,6 +832,36 @@ func (c *gitConfig) IsGitVersionAtLeast(ver string) bool {
 // includeRemoteBranches: true to include refs on remote branches
 // onlyRemote: set to non-blank to only include remote branches on a single remote
 func RecentBranches(since time.Time, includeRemoteBranches bool, onlyRemote string) ([]*Ref, error) {
+	opts := &RecentBranchesOpts{
+		Since:          since,
+		IncludeRemotes: includeRemoteBranches,
+	}
+	if onlyRemote != "" {
+		opts.OnlyRemotes = []string{onlyRemote}
+	}
+	return RecentBranchesWithOpts(opts)
+}
+
+// RecentBranchesOpts are the options for RecentBranchesWithOpts.
+type RecentBranchesOpts struct {
+	// Since: refs with commits on or after this date will be included
+	Since time.Time
+	// MaxCount: if > 0, only this many of the most recently committed to refs
+	// are included, counting local and remote refs together
+	MaxCount int
+	// IncludeRemotes: true to include refs on remote branches
+	IncludeRemotes bool
+	// OnlyRemotes: set to only include remote branches on these remotes, or
+	// nil for all of them
+	OnlyRemotes []string
+}
+
+// RecentBranchesWithOpts returns branches with commit dates on or after
+// opts.Since, the most recently committed to first. Remote refs that are left
+// out by opts don't count towards opts.MaxCount.
+func RecentBranchesWithOpts(opts *RecentBranchesOpts) ([]*Ref, error) {
+	since, includeRemoteBranches := opts.Since, opts.IncludeRemotes
+
 	cmd := subprocess.ExecCommand("git", "for-each-ref",
 		`--sort=-committerdate`,
 		`--format=%(refname) %(objectname) %(committerdate:iso)`,
@@ -317,6 +872,8 @@ func RecentBranches(since time.Time, includeRemoteBranches bool, onlyRemote stri
 	}
 	cmd.Start()
 	defer cmd.Wait()
+	// git can't exit until it's written all of the refs, if we stop early
+	defer io.Copy(ioutil.Discard, outp)
 
 	scanner := bufio.NewScanner(outp)
 
@@ -338,7 +895,7 @@ func RecentBranches(since time.Time, includeRemoteBranches bool, onlyRemote stri
 				if !includeRemoteBranches {
 					continue
 				}
-				if onlyRemote != "" && !strings.HasPrefix(ref, onlyRemote+"/") {
+				if opts.OnlyRemotes != nil && !refOnRemotes(ref, opts.OnlyRemotes) {
 					continue
 				}
 			}
@@ -354,6 +911,10 @@ func RecentBranches(since time.Time, includeRemoteBranches bool, onlyRemote stri
 			}
 			tracerx.Printf("RECENT: %v (%v)", ref, commitDate)
 			ret = append(ret, &Ref{ref, reftype, sha})
+			if opts.MaxCount > 0 && len(ret) >= opts.MaxCount {
+				tracerx.Printf("RECENT: stopping at %d refs", opts.MaxCount)
+				break
+			}
 		}
 	}
 
@@ -361,6 +922,17 @@ func RecentBranches(since time.Time, includeRemoteBranches bool, onlyRemote stri
 
 }
 
+// refOnRemotes returns whether the remote ref name, eg "origin/master", is on
+// one of remotes.
+func refOnRemotes(ref string, remotes []string) bool {
+	for _, remote := range remotes {
+		if strings.HasPrefix(ref, remote+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // Get the type & name of a git reference
 func ParseRefToTypeAndName(fullref string) (t RefType, name string) {
 	const localPrefix = "refs/heads/"
@@ -444,6 +1016,16 @@ func GetCommitSummary(commit string) (*CommitSummary, error) {
 	}
 }
 
+// ObjectType returns the type of the object named by rev, eg "commit" for
+// "HEAD" or "blob" for "HEAD:.lfsconfig", or "" if there's no such object.
+func ObjectType(rev string) string {
+	out, err := subprocess.SimpleExec("git", "cat-file", "-t", rev)
+	if err != nil {
+		return ""
+	}
+	return out
+}
+
 func GitAndRootDirs() (string, string, error) {
 	cmd := subprocess.ExecCommand("git", "rev-parse", "--git-dir", "--show-toplevel")
 	buf := &bytes.Buffer{}
@@ -452,6 +1034,14 @@ func GitAndRootDirs() (string, string, error) {
 	out, err := cmd.Output()
 	output := string(out)
 	if err != nil {
+		// In a bare repo, or inside the git dir, git prints the git dir and
+		// then fails because there's no top level dir.
+		if gitDir := strings.TrimSpace(output); len(gitDir) > 0 && !strings.Contains(gitDir, "\n") {
+			absGitDir, absErr := filepath.Abs(gitDir)
+			if absErr == nil {
+				return absGitDir, "", nil
+			}
+		}
 		return "", "", fmt.Errorf("Failed to call git rev-parse --git-dir --show-toplevel: %q", buf.String())
 	}
 
@@ -528,10 +1118,17 @@ func GetAllWorkTreeHEADs(storageDir string) ([]*Ref, error) {
 		}
 		for _, dirfi := range direntries {
 			if dirfi.IsDir() {
+				dir := filepath.Join(worktreesdir, dirfi.Name())
+				if worktreePrunable(dir) {
+					tracerx.Printf("Worktree %v has been deleted, skipping", dir)
+					continue
+				}
+
 				// to avoid having to chdir and run git commands to identify the commit
 				// just read the HEAD file & git rev-parse if necessary
 				// Since the git repo is shared the same rev-parse will work from this location
-				headfile := filepath.Join(worktreesdir, dirfi.Name(), "HEAD")
+				// A detached HEAD is a RefTypeOther named by its SHA
+				headfile := filepath.Join(dir, "HEAD")
 				ref, err := parseRefFile(headfile)
 				if err != nil {
 					tracerx.Printf("Error reading %v for worktree, skipping: %v", headfile, err)
@@ -557,6 +1154,29 @@ func GetAllWorkTreeHEADs(storageDir string) ([]*Ref, error) {
 	return worktrees, nil
 }
 
+// worktreePrunable returns whether the worktree with the admin dir
+// $GIT_DIR/worktrees/<name> has had its working directory deleted, so that git
+// worktree prune would remove it. Locked worktrees are never prunable, as they
+// may be on a drive that isn't mounted right now.
+func worktreePrunable(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "locked")); err == nil {
+		return false
+	}
+
+	// gitdir has the path of the .git file in the working directory
+	by, err := ioutil.ReadFile(filepath.Join(dir, "gitdir"))
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	gitfile := strings.TrimSpace(string(by))
+	if !filepath.IsAbs(gitfile) {
+		gitfile = filepath.Join(dir, gitfile)
+	}
+
+	_, err = os.Stat(gitfile)
+	return os.IsNotExist(err)
+}
+
 // Manually parse a reference file like HEAD and return the Ref it resolves to
 func parseRefFile(filename string) (*Ref, error) {
 	bytes, err := ioutil.ReadFile(filename)
@@ -570,46 +1190,66 @@ func parseRefFile(filename string) (*Ref, error) {
 	return ResolveRef(contents)
 }
 
-// IsVersionAtLeast compares 2 version strings (ok to be prefixed with 'git version', ignores)
-func IsVersionAtLeast(actualVersion, desiredVersion string) bool {
-	// Capture 1-3 version digits, optionally prefixed with 'git version' and possibly
-	// with suffixes which we'll ignore (e.g. unstable builds, MinGW versions)
-	verregex := regexp.MustCompile(`(?:git version\s+)?(\d+)(?:.(\d+))?(?:.(\d+))?.*`)
+// gitVersionRegexp captures 1-3 version digits and a release candidate number,
+// optionally prefixed with 'git version' and possibly with vendor suffixes which
+// we ignore, eg "2.8.1.windows.1", "2.7.4 (Apple Git-66)" or "2.9.0-rc1"
+var gitVersionRegexp = regexp.MustCompile(`^(?:git version\s+)?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:[.-]rc(\d+))?`)
 
-	var atleast uint64
-	// Support up to 1000 in major/minor/patch digits
-	const majorscale = 1000 * 1000
-	const minorscale = 1000
+// ParseGitVersion returns the major, minor and patch numbers from raw, which is
+// a version string or the output of git version. Missing numbers are 0.
+func ParseGitVersion(raw string) (major, minor, patch int, err error) {
+	major, minor, patch, _, err = parseGitVersion(raw)
+	return
+}
 
-	if match := verregex.FindStringSubmatch(desiredVersion); match != nil {
-		// Ignore errors as regex won't match anything other than digits
-		major, _ := strconv.Atoi(match[1])
-		atleast += uint64(major * majorscale)
-		if len(match) > 2 {
-			minor, _ := strconv.Atoi(match[2])
-			atleast += uint64(minor * minorscale)
-		}
-		if len(match) > 3 {
-			patch, _ := strconv.Atoi(match[3])
-			atleast += uint64(patch)
-		}
+// parseGitVersion is ParseGitVersion, plus the release candidate number, or 0
+// for a final release.
+func parseGitVersion(raw string) (major, minor, patch, rc int, err error) {
+	match := gitVersionRegexp.FindStringSubmatch(strings.TrimSpace(raw))
+	if match == nil {
+		return 0, 0, 0, 0, fmt.Errorf("Unable to parse git version %q", raw)
 	}
 
-	var actual uint64
-	if match := verregex.FindStringSubmatch(actualVersion); match != nil {
-		major, _ := strconv.Atoi(match[1])
-		actual += uint64(major * majorscale)
-		if len(match) > 2 {
-			minor, _ := strconv.Atoi(match[2])
-			actual += uint64(minor * minorscale)
-		}
-		if len(match) > 3 {
-			patch, _ := strconv.Atoi(match[3])
-			actual += uint64(patch)
+	// Ignore errors as regex won't match anything other than digits, and
+	// missing parts are empty
+	major, _ = strconv.Atoi(match[1])
+	minor, _ = strconv.Atoi(match[2])
+	patch, _ = strconv.Atoi(match[3])
+	rc, _ = strconv.Atoi(match[4])
+	return major, minor, patch, rc, nil
+}
+
+// IsVersionAtLeast compares 2 version strings (ok to be prefixed with 'git version', ignores)
+// A release candidate is lower than its final release, so "2.9.0-rc1" isn't
+// at least "2.9.0". It's false if either can't be parsed.
+func IsVersionAtLeast(actualVersion, desiredVersion string) bool {
+	actual, err := versionParts(actualVersion)
+	if err != nil {
+		tracerx.Printf("%v", err)
+		return false
+	}
+	desired, err := versionParts(desiredVersion)
+	if err != nil {
+		tracerx.Printf("%v", err)
+		return false
+	}
+
+	for i := range actual {
+		if actual[i] != desired[i] {
+			return actual[i] > desired[i]
 		}
 	}
+	return true
+}
 
-	return actual >= atleast
+// versionParts returns the parts of a version to compare in order, with a final
+// release after all of its release candidates.
+func versionParts(version string) ([4]int, error) {
+	major, minor, patch, rc, err := parseGitVersion(version)
+	if rc == 0 {
+		rc = math.MaxInt32
+	}
+	return [4]int{major, minor, patch, rc}, err
 }
 
 // CloneWithoutFilters clones a git repo but without the smudge filter enabled
@@ -678,7 +1318,7 @@ func CloneWithoutFilters(args []string) error {
 		outputWait.Done()
 	}()
 
-	err = cmd.Start()
+	err = subprocess.Start(cmd)
 	if err != nil {
 		return fmt.Errorf("Failed to start git clone: %v", err)
 	}
@@ -735,7 +1375,7 @@ func RemoteRefs(remoteName string) ([]*Ref, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to call git ls-remote: %v", err)
 	}
-	cmd.Start()
+	subprocess.Start(cmd)
 	scanner := bufio.NewScanner(outp)
 
 	r := regexp.MustCompile(`([0-9a-fA-F]{40})\s+refs/(heads|tags)/(.*)`)
@@ -764,23 +1404,465 @@ func RemoteRefs(remoteName string) ([]*Ref, error) {
 // the root of the repository
 func GetTrackedFiles(pattern string) ([]string, error) {
 	var ret []string
-	cmd := subprocess.ExecCommand("git",
-		"-c", "core.quotepath=false", // handle special chars in filenames
-		"ls-files",
-		"--cached", // include things which are staged but not committed right now
-		"--",       // no ambiguous patterns
-		pattern)
+	files, errc := GetTrackedFilesChan(pattern)
+	for file := range files {
+		ret = append(ret, file)
+	}
+	return ret, <-errc
+}
+
+// GetTrackedFilesChan is like GetTrackedFiles, but sends each file to the
+// returned channel as git lists it rather than collecting them all, for
+// repositories with too many files to hold at once. The error channel receives
+// at most one error and is closed after the files channel. The files channel
+// must be read until it's closed.
+func GetTrackedFilesChan(pattern string) (<-chan string, <-chan error) {
+	files := make(chan string, 100)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		defer close(files)
+
+		cmd := subprocess.ExecCommand("git",
+			"-c", "core.quotepath=false", // handle special chars in filenames
+			"ls-files",
+			"--cached", // include things which are staged but not committed right now
+			"-z",       // NUL terminated, so names can contain newlines
+			"--",       // no ambiguous patterns
+			pattern)
+
+		outp, err := cmd.StdoutPipe()
+		if err != nil {
+			errc <- fmt.Errorf("Failed to call git ls-files: %v", err)
+			return
+		}
+		if err := cmd.Start(); err != nil {
+			errc <- fmt.Errorf("Failed to call git ls-files: %v", err)
+			return
+		}
+
+		scanner := bufio.NewScanner(outp)
+		scanner.Split(scanNullTerminated)
+		for scanner.Scan() {
+			files <- scanner.Text()
+		}
+
+		if err := cmd.Wait(); err != nil {
+			errc <- err
+		}
+	}()
+
+	return files, errc
+}
+
+// TreeBlob is a blob in a tree, with the path it's at from the root of the
+// repository.
+type TreeBlob struct {
+	Sha1     string
+	Filename string
+	Size     int64
+}
+
+// LsTreeBlobs returns a channel of the blobs in the tree at ref, recursively,
+// along with their sizes so callers can skip those too big to be pointers. Only
+// blobs are sent, so submodules are left out. The channel is closed once they
+// have all been sent, and must be read until then. An invalid ref is reported
+// as an error, but git failing part way through only ends the list early.
+func LsTreeBlobs(ref string) (<-chan TreeBlob, error) {
+	cmd := subprocess.ExecCommand("git", "ls-tree",
+		"-r",          // recurse
+		"-l",          // report object size
+		"-z",          // null line termination
+		"--full-tree", // start at the root regardless of where we are in it
+		ref)
 
 	outp, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, fmt.Errorf("Failed to call git ls-files: %v", err)
+		return nil, fmt.Errorf("Failed to call git ls-tree: %v", err)
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	tracerx.Printf("run_command: git ls-tree -r -l -z --full-tree %s", ref)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to call git ls-tree: %v", err)
+	}
+
+	blobs := make(chan TreeBlob, 100)
+
+	// An invalid ref makes git exit without any output, so wait for some to
+	// report that here rather than sending nothing
+	stdout := bufio.NewReader(outp)
+	if _, err := stdout.Peek(1); err != nil {
+		if err := cmd.Wait(); err != nil {
+			return nil, fmt.Errorf("Error in git ls-tree: %v %v", err, strings.TrimSpace(stderr.String()))
+		}
+		// an empty tree
+		close(blobs)
+		return blobs, nil
+	}
+
+	go func() {
+		parseLsTreeBlobs(stdout, blobs)
+		close(blobs)
+		if err := cmd.Wait(); err != nil {
+			tracerx.Printf("Error in git ls-tree: %v %v", err, strings.TrimSpace(stderr.String()))
+		}
+	}()
+
+	return blobs, nil
+}
+
+// parseLsTreeBlobs sends the blobs in the output of git ls-tree -l -z to blobs.
+func parseLsTreeBlobs(r io.Reader, blobs chan<- TreeBlob) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanNullTerminated)
+	for scanner.Scan() {
+		// Format is:
+		// <mode> SP <type> SP <sha1> SP+ <size> TAB <path>
+		// where size is "-" for anything but a blob
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(parts) < 2 {
+			continue
+		}
+
+		attrs := strings.Fields(parts[0])
+		if len(attrs) < 4 || attrs[1] != "blob" {
+			continue
+		}
+
+		size, err := strconv.ParseInt(attrs[3], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		blobs <- TreeBlob{Sha1: attrs[2], Filename: parts[1], Size: size}
+	}
+}
+
+// scanNullTerminated is a bufio.SplitFunc for NUL terminated output, such as
+// from the -z option of many git commands.
+func scanNullTerminated(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[0:i], nil
+	}
+
+	// a final entry without a terminator
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// UnstagedFiles returns the files in the working tree that git sees as changed
+// from the index, with paths from the root of the repository. Git compares
+// the files' stat data with what's in the index, so a file is listed if it
+// was touched but its content is unchanged.
+func UnstagedFiles() ([]string, error) {
+	cmd := subprocess.ExecCommand("git", "diff-files", "--name-only", "-z")
+	outp, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to call git diff-files: %v", err)
+	}
+
+	var files []string
+	scanner := bufio.NewScanner(bytes.NewReader(outp))
+	scanner.Split(scanNullTerminated)
+	for scanner.Scan() {
+		files = append(files, scanner.Text())
+	}
+	return files, nil
+}
+
+// GetTrackedFilesAt returns a list of files in the tree of ref which match the
+// pattern specified, with the same wildcard semantics as GetTrackedFiles.
+// Both pattern and the results are relative to the current working directory,
+// not the root of the repository
+func GetTrackedFilesAt(ref, pattern string) ([]string, error) {
+	match, err := pathspecRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	cmd := subprocess.ExecCommand("git", "ls-tree", "-r", "--name-only", ref)
+	outp, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to call git ls-tree: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to call git ls-tree: %v", err)
 	}
-	cmd.Start()
 	scanner := bufio.NewScanner(outp)
 	for scanner.Scan() {
-		line := scanner.Text()
-		ret = append(ret, strings.TrimSpace(line))
+		name, err := unquotePath(scanner.Text())
+		if err != nil {
+			io.Copy(ioutil.Discard, outp)
+			cmd.Wait()
+			return nil, err
+		}
+		if match.MatchString(name) {
+			ret = append(ret, name)
+		}
 	}
 	return ret, cmd.Wait()
+}
+
+// pathspecRegexp returns a regexp matching the paths that git matches with
+// pattern as a pathspec: "*" and "?" match "/" as well, and a directory also
+// matches everything in it. An empty pattern matches everything.
+func pathspecRegexp(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) == 0 {
+		return regexp.MustCompile(`^`), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`(?s)^`)
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			buf.WriteString(`.*`)
+		case '?':
+			buf.WriteString(`.`)
+		case '[':
+			end := -1
+			for j := i + 1; j < len(runes); j++ {
+				if runes[j] == ']' {
+					end = j
+					break
+				}
+			}
+			if end < 0 {
+				buf.WriteString(`\[`)
+				continue
+			}
+			class := string(runes[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i = end
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+				buf.WriteString(regexp.QuoteMeta(string(runes[i])))
+			}
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString(`(/.*)?$`)
+
+	return regexp.Compile(buf.String())
+}
+
+// unquotePath returns a path as git prints it, which is in double quotes with
+// C-style escapes if it has unusual characters, as it is in the repository.
+func unquotePath(path string) (string, error) {
+	if len(path) < 2 || path[0] != '"' || path[len(path)-1] != '"' {
+		return path, nil
+	}
+
+	// git uses the same escapes as Go, with octal for bytes over 0x7f
+	unquoted, err := strconv.Unquote(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to unquote path %s: %v", path, err)
+	}
+	return unquoted, nil
+}
 
+// ChangedPathsNotOnRemote returns the paths changed by the commits reachable
+// from ref that aren't on any of remoteName's remote tracking branches, ie
+// those a push of ref to remoteName would change.
+func ChangedPathsNotOnRemote(ref, remoteName string) ([]string, error) {
+	outp, err := subprocess.ExecCommand("git", "log", "--format=", "--name-only", "--no-renames", "-z",
+		ref, "--not", "--remotes="+remoteName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to call git log: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	var paths []string
+	for _, path := range strings.Split(string(outp), "\x00") {
+		path = strings.Trim(path, "\n")
+		if len(path) == 0 || seen[path] {
+			continue
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// CatFileBatch reads the contents of objects from a single long lived
+// git cat-file --batch process, rather than starting one for each object. It's
+// safe for concurrent use; only one object is read at a time, so Contents
+// blocks until the reader from the previous call is closed.
+type CatFileBatch struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *bytes.Buffer
+
+	// mu is held from a call to Contents until its reader is closed
+	mu sync.Mutex
+	// err is set once the output can't be followed anymore
+	err error
+}
+
+// MissingObjectError is returned by CatFileBatch.Contents for an object that
+// isn't in the repository.
+type MissingObjectError struct {
+	Sha string
+}
+
+func (e *MissingObjectError) Error() string {
+	return fmt.Sprintf("object %s is missing", e.Sha)
+}
+
+// IsMissingObjectError returns whether err is a MissingObjectError.
+func IsMissingObjectError(err error) bool {
+	_, ok := err.(*MissingObjectError)
+	return ok
+}
+
+var errCatFileBatchClosed = errors.New("git cat-file --batch is closed")
+
+// NewCatFileBatch starts git cat-file --batch in the current repository. It
+// must be closed with Close.
+func NewCatFileBatch() (*CatFileBatch, error) {
+	cmd := subprocess.ExecCommand("git", "cat-file", "--batch")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to call git cat-file --batch: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to call git cat-file --batch: %v", err)
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	tracerx.Printf("run_command: git cat-file --batch")
+	if err := subprocess.Start(cmd); err != nil {
+		return nil, fmt.Errorf("Failed to call git cat-file --batch: %v", err)
+	}
+
+	return &CatFileBatch{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReaderSize(stdout, 16384),
+		stderr: stderr,
+	}, nil
+}
+
+// Contents returns a reader of the contents of the object sha, which can be
+// anything git rev-parse accepts, and the object's size. The reader must be
+// closed before the next object can be read. If there's no such object the
+// error is a MissingObjectError, and the batch can still be used.
+func (b *CatFileBatch) Contents(sha string) (io.ReadCloser, int64, error) {
+	b.mu.Lock()
+	if b.err != nil {
+		b.mu.Unlock()
+		return nil, 0, b.err
+	}
+	if strings.ContainsAny(sha, "\r\n") {
+		b.mu.Unlock()
+		return nil, 0, fmt.Errorf("Invalid object name %q", sha)
+	}
+
+	if _, err := io.WriteString(b.stdin, sha+"\n"); err != nil {
+		return nil, 0, b.fail(err)
+	}
+
+	// The header is "<sha> <type> <size>", or "<name> missing"
+	header, err := b.stdout.ReadString('\n')
+	if err != nil {
+		return nil, 0, b.fail(err)
+	}
+	fields := strings.Fields(header)
+	if len(fields) == 2 && fields[1] == "missing" {
+		b.mu.Unlock()
+		return nil, 0, &MissingObjectError{Sha: sha}
+	}
+	if len(fields) != 3 {
+		return nil, 0, b.fail(fmt.Errorf("unexpected header %q", strings.TrimSpace(header)))
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, 0, b.fail(fmt.Errorf("unexpected header %q", strings.TrimSpace(header)))
+	}
+
+	return &catFileReader{batch: b, r: io.LimitReader(b.stdout, size)}, size, nil
+}
+
+// fail records that the output can't be followed after err, and releases the
+// lock taken by Contents.
+func (b *CatFileBatch) fail(err error) error {
+	if b.err == nil {
+		b.err = fmt.Errorf("Error in git cat-file --batch: %v %v", err, strings.TrimSpace(b.stderr.String()))
+	}
+	err = b.err
+	b.mu.Unlock()
+	return err
+}
+
+// Close stops the git cat-file process. It mustn't be called while another
+// goroutine is reading an object, though any reader left open by this one is
+// fine.
+func (b *CatFileBatch) Close() error {
+	if b.err == errCatFileBatchClosed {
+		return nil
+	}
+	b.err = errCatFileBatchClosed
+
+	b.stdin.Close()
+	// git exits once it's written whatever is left
+	io.Copy(ioutil.Discard, b.stdout)
+	if err := b.cmd.Wait(); err != nil {
+		return fmt.Errorf("Error in git cat-file --batch: %v %v", err, strings.TrimSpace(b.stderr.String()))
+	}
+	return nil
+}
+
+// catFileReader reads the contents of one object from a CatFileBatch, and
+// releases it for the next object when closed.
+type catFileReader struct {
+	batch  *CatFileBatch
+	r      io.Reader
+	closed bool
+}
+
+func (r *catFileReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, errors.New("read of closed object contents")
+	}
+	return r.r.Read(p)
+}
+
+// Close skips past whatever is left of the object, so the next one can be
+// read.
+func (r *catFileReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	b := r.batch
+	if _, err := io.Copy(ioutil.Discard, r.r); err != nil {
+		return b.fail(err)
+	}
+	// the contents are followed by a LF
+	if _, err := b.stdout.ReadByte(); err != nil {
+		return b.fail(err)
+	}
+	b.mu.Unlock()
+	return nil
 }
@@ -1,10 +1,14 @@
 package git_test // to avoid import cycles
 
 import (
+	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -73,6 +77,50 @@ func TestCurrentRefAndCurrentRemoteRef(t *testing.T) {
 	assert.Equal(t, "origin", remote)
 }
 
+func TestRemoteBranchForLocalBranch(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	repo.AddCommits([]*test.CommitInput{
+		{
+			Files: []*test.FileInput{
+				{Filename: "file1.txt", Size: 20},
+			},
+		},
+	})
+
+	test.RunGitCommand(t, true, "config", "branch.master.remote", "origin")
+	test.RunGitCommand(t, true, "config", "branch.master.merge", "refs/heads/master")
+	test.RunGitCommand(t, true, "config", "branch.feature.with.dots.remote", "upstream")
+	test.RunGitCommand(t, true, "config", "branch.feature.with.dots.merge", "refs/heads/other")
+	test.RunGitCommand(t, true, "config", "branch.nomerge.remote", "origin")
+	test.RunGitCommand(t, true, "config", "branch.noremote.merge", "refs/heads/noremote")
+
+	cases := []struct {
+		branch       string
+		remote       string
+		remoteBranch string
+		err          error
+	}{
+		{"master", "origin", "master", nil},
+		{"feature.with.dots", "upstream", "other", nil},
+		{"nomerge", "origin", "nomerge", nil},
+		{"noremote", "", "", ErrNoUpstream},
+		{"untracked", "", "", ErrNoUpstream},
+	}
+
+	for _, c := range cases {
+		remote, remoteBranch, err := RemoteBranchForLocalBranch(c.branch)
+		assert.Equal(t, c.err, err, c.branch)
+		assert.Equal(t, c.remote, remote, c.branch)
+		assert.Equal(t, c.remoteBranch, remoteBranch, c.branch)
+	}
+}
+
 func TestRecentBranches(t *testing.T) {
 	repo := test.NewRepo(t)
 	repo.Pushd()
@@ -181,6 +229,110 @@ func TestRecentBranches(t *testing.T) {
 	sort.Sort(test.RefsByName(expectedRefs))
 	sort.Sort(test.RefsByName(refs))
 	assert.Equal(t, expectedRefs, refs, "Refs should be correct")
+
+	// Capped, local only: the most recent first
+	refs, err = RecentBranchesWithOpts(&RecentBranchesOpts{Since: now.AddDate(0, 0, -7), MaxCount: 2})
+	assert.Equal(t, nil, err)
+	expectedRefs = []*Ref{
+		&Ref{"master", RefTypeLocalBranch, outputs[5].Sha},
+		&Ref{"included_branch_2", RefTypeLocalBranch, outputs[4].Sha},
+	}
+	assert.Equal(t, expectedRefs, refs, "Refs should be correct")
+
+	// Capped, only single remote: refs on other remotes don't use up the cap,
+	// and local and remote refs share it in commit date order
+	refs, err = RecentBranchesWithOpts(&RecentBranchesOpts{
+		Since:          now.AddDate(0, 0, -7),
+		MaxCount:       3,
+		IncludeRemotes: true,
+		OnlyRemotes:    []string{"origin"},
+	})
+	assert.Equal(t, nil, err)
+	expectedRefs = []*Ref{
+		&Ref{"master", RefTypeLocalBranch, outputs[5].Sha},
+		&Ref{"origin/master", RefTypeRemoteBranch, outputs[5].Sha},
+		&Ref{"included_branch_2", RefTypeLocalBranch, outputs[4].Sha},
+	}
+	assert.Equal(t, expectedRefs, refs, "Refs should be correct")
+
+	// Capped above the number of recent refs, the date still applies
+	refs, err = RecentBranchesWithOpts(&RecentBranchesOpts{
+		Since:          now.AddDate(0, 0, -7),
+		MaxCount:       10,
+		IncludeRemotes: true,
+		OnlyRemotes:    []string{"origin"},
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, len(refs))
+}
+
+func TestRecentBranchesOnSeveralRemotes(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	now := time.Now()
+	outputs := repo.AddCommits([]*test.CommitInput{
+		{
+			CommitDate: now.AddDate(0, 0, -2),
+			Files: []*test.FileInput{
+				{Filename: "file1.txt", Size: 20},
+			},
+		},
+		{
+			NewBranch: "feature",
+			Files: []*test.FileInput{
+				{Filename: "file1.txt", Size: 25},
+			},
+		},
+	})
+
+	repo.AddRemote("origin")
+	repo.AddRemote("upstream")
+	repo.AddRemote("backup")
+
+	test.RunGitCommand(t, true, "push", "origin", "master")
+	test.RunGitCommand(t, true, "push", "upstream", "feature")
+	test.RunGitCommand(t, true, "push", "backup", "master", "feature")
+
+	since := now.AddDate(0, 0, -7)
+	refs, err := RecentBranchesWithOpts(&RecentBranchesOpts{
+		Since:          since,
+		IncludeRemotes: true,
+		OnlyRemotes:    []string{"origin", "upstream"},
+	})
+	assert.Equal(t, nil, err)
+	expectedRefs := []*Ref{
+		&Ref{"feature", RefTypeLocalBranch, outputs[1].Sha},
+		&Ref{"master", RefTypeLocalBranch, outputs[0].Sha},
+		&Ref{"origin/master", RefTypeRemoteBranch, outputs[0].Sha},
+		&Ref{"upstream/feature", RefTypeRemoteBranch, outputs[1].Sha},
+	}
+	sort.Sort(test.RefsByName(expectedRefs))
+	sort.Sort(test.RefsByName(refs))
+	assert.Equal(t, expectedRefs, refs, "Refs should be correct")
+
+	// nil is every remote
+	refs, err = RecentBranchesWithOpts(&RecentBranchesOpts{Since: since, IncludeRemotes: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 6, len(refs))
+
+	// and an empty list is none of them
+	refs, err = RecentBranchesWithOpts(&RecentBranchesOpts{
+		Since:          since,
+		IncludeRemotes: true,
+		OnlyRemotes:    []string{},
+	})
+	assert.Equal(t, nil, err)
+	expectedRefs = []*Ref{
+		&Ref{"feature", RefTypeLocalBranch, outputs[1].Sha},
+		&Ref{"master", RefTypeLocalBranch, outputs[0].Sha},
+	}
+	sort.Sort(test.RefsByName(refs))
+	assert.Equal(t, expectedRefs, refs, "Refs should be correct")
 }
 
 func TestResolveEmptyCurrentRef(t *testing.T) {
@@ -195,6 +347,75 @@ func TestResolveEmptyCurrentRef(t *testing.T) {
 	assert.NotEqual(t, nil, err)
 }
 
+func TestResolveRef(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	outputs := repo.AddCommits([]*test.CommitInput{
+		{ // 0
+			Files: []*test.FileInput{
+				{Filename: "file1.txt", Size: 20},
+			},
+			Tags: []string{"v1.0"},
+		},
+		{ // 1
+			NewBranch: "feature",
+			Files: []*test.FileInput{
+				{Filename: "file2.txt", Size: 20},
+			},
+		},
+	})
+	// v1.0 is an annotated tag, v2.0 a lightweight one
+	test.RunGitCommand(t, true, "tag", "v2.0", outputs[1].Sha)
+	tagSha := strings.TrimSpace(test.RunGitCommand(t, true, "rev-parse", "v1.0"))
+
+	ref, err := ResolveRef("v1.0")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Ref{"v1.0", RefTypeLocalTag, tagSha}, ref)
+
+	ref, err = ResolveRef("v1.0^{}")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Ref{"v1.0^{}", RefTypeOther, outputs[0].Sha}, ref)
+
+	ref, err = ResolveRef("v2.0")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Ref{"v2.0", RefTypeLocalTag, outputs[1].Sha}, ref)
+
+	ref, err = ResolveRef("feature")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Ref{"feature", RefTypeLocalBranch, outputs[1].Sha}, ref)
+
+	ref, err = ResolveRef("feature~1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Ref{"feature~1", RefTypeOther, outputs[0].Sha}, ref)
+
+	ref, err = ResolveRef(outputs[0].Sha[:7])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Ref{outputs[0].Sha[:7], RefTypeOther, outputs[0].Sha}, ref)
+
+	// a tag object named by its SHA is still a tag
+	ref, err = ResolveRef(tagSha)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Ref{tagSha, RefTypeLocalTag, tagSha}, ref)
+
+	test.RunGitCommand(t, true, "checkout", outputs[0].Sha)
+	ref, err = ResolveRef("HEAD")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Ref{"HEAD", RefTypeHEAD, outputs[0].Sha}, ref)
+
+	_, err = ResolveRef("no-such-ref")
+	assert.Equal(t, true, IsUnknownRevisionError(err))
+	assert.Equal(t, `Git can't resolve ref: "no-such-ref"`, err.Error())
+
+	_, err = ResolveRef("HEAD:file1.txt")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, false, IsUnknownRevisionError(err))
+}
+
 func TestWorkTrees(t *testing.T) {
 
 	// Only git 2.5+
@@ -261,6 +482,64 @@ func TestWorkTrees(t *testing.T) {
 	assert.Equal(t, expectedRefs, refs, "Refs should be correct")
 }
 
+func TestWorkTreesDetachedAndDeleted(t *testing.T) {
+	// Only git 2.10+ can lock worktrees
+	if !Config.IsGitVersionAtLeast("2.10.0") {
+		return
+	}
+
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	inputs := []*test.CommitInput{
+		{ // 0
+			Files: []*test.FileInput{
+				{Filename: "file1.txt", Size: 20},
+			},
+		},
+		{ // 1
+			NewBranch: "branch2",
+			Files: []*test.FileInput{
+				{Filename: "file1.txt", Size: 25},
+			},
+		},
+		{ // 2
+			NewBranch:      "branch3",
+			ParentBranches: []string{"master"}, // back on master
+			Files: []*test.FileInput{
+				{Filename: "file1.txt", Size: 30},
+			},
+		},
+	}
+	outputs := repo.AddCommits(inputs)
+	test.RunGitCommand(t, true, "checkout", "master")
+
+	// a detached checkout, as CI systems make
+	test.RunGitCommand(t, true, "worktree", "add", "--detach", "detached_wt", outputs[1].Sha)
+	// deleted without git worktree prune
+	test.RunGitCommand(t, true, "worktree", "add", "deleted_wt", "branch2")
+	os.RemoveAll(filepath.Join(repo.Path, "deleted_wt"))
+	// locked, eg on a drive that isn't mounted, so it's kept
+	test.RunGitCommand(t, true, "worktree", "add", "locked_wt", "branch3")
+	test.RunGitCommand(t, true, "worktree", "lock", "locked_wt")
+	os.RemoveAll(filepath.Join(repo.Path, "locked_wt"))
+
+	refs, err := GetAllWorkTreeHEADs(filepath.Join(repo.Path, ".git"))
+	assert.Equal(t, nil, err)
+	expectedRefs := []*Ref{
+		&Ref{"master", RefTypeLocalBranch, outputs[0].Sha},
+		&Ref{outputs[1].Sha, RefTypeOther, outputs[1].Sha},
+		&Ref{"branch3", RefTypeLocalBranch, outputs[2].Sha},
+	}
+	sort.Sort(test.RefsByName(expectedRefs))
+	sort.Sort(test.RefsByName(refs))
+	assert.Equal(t, expectedRefs, refs, "Refs should be correct")
+}
+
 func TestVersionCompare(t *testing.T) {
 	assert.Equal(t, true, IsVersionAtLeast("2.6.0", "2.6.0"))
 	assert.Equal(t, true, IsVersionAtLeast("2.6.0", "2.6"))
@@ -272,6 +551,47 @@ func TestVersionCompare(t *testing.T) {
 	assert.Equal(t, false, IsVersionAtLeast("2.5.0", "2.6"))
 	assert.Equal(t, false, IsVersionAtLeast("2.5.0", "2.5.1"))
 	assert.Equal(t, false, IsVersionAtLeast("2.5.2", "2.5.10"))
+
+	// vendor suffixes
+	assert.Equal(t, true, IsVersionAtLeast("git version 2.8.1.windows.1", "2.5.0"))
+	assert.Equal(t, true, IsVersionAtLeast("git version 2.8.1.windows.1", "2.8.1"))
+	assert.Equal(t, false, IsVersionAtLeast("git version 2.8.1.windows.1", "2.8.2"))
+	assert.Equal(t, true, IsVersionAtLeast("git version 2.7.4 (Apple Git-66)", "2.5.0"))
+	assert.Equal(t, false, IsVersionAtLeast("git version 2.7.4 (Apple Git-66)", "2.8"))
+	assert.Equal(t, true, IsVersionAtLeast("git version 2.10.1\n", "2.9"))
+
+	// release candidates come before their release
+	assert.Equal(t, false, IsVersionAtLeast("git version 2.9.0-rc1", "2.9.0"))
+	assert.Equal(t, false, IsVersionAtLeast("git version 2.9.0.rc2.windows.1", "2.9"))
+	assert.Equal(t, true, IsVersionAtLeast("git version 2.9.0-rc1", "2.8.4"))
+	assert.Equal(t, true, IsVersionAtLeast("git version 2.9.0-rc2", "2.9.0-rc1"))
+	assert.Equal(t, false, IsVersionAtLeast("git version 2.9.0-rc1", "2.9.0-rc2"))
+	assert.Equal(t, true, IsVersionAtLeast("git version 2.9.0", "2.9.0-rc2"))
+
+	assert.Equal(t, false, IsVersionAtLeast("not a version", "2"))
+}
+
+func TestParseGitVersion(t *testing.T) {
+	cases := []struct {
+		raw                 string
+		major, minor, patch int
+	}{
+		{"2.6.0", 2, 6, 0},
+		{"2.6", 2, 6, 0},
+		{"git version 1.9.5\n", 1, 9, 5},
+		{"git version 2.8.1.windows.1", 2, 8, 1},
+		{"git version 2.7.4 (Apple Git-66)", 2, 7, 4},
+		{"git version 2.9.0-rc1", 2, 9, 0},
+		{"git version 2.9.0.rc1", 2, 9, 0},
+	}
+	for _, c := range cases {
+		major, minor, patch, err := ParseGitVersion(c.raw)
+		assert.Equal(t, nil, err, c.raw)
+		assert.Equal(t, []int{c.major, c.minor, c.patch}, []int{major, minor, patch}, c.raw)
+	}
+
+	_, _, _, err := ParseGitVersion("git version unknown")
+	assert.NotEqual(t, nil, err)
 }
 
 func TestGitAndRootDirs(t *testing.T) {
@@ -283,6 +603,33 @@ func TestGitAndRootDirs(t *testing.T) {
 	assert.Equal(t, git, filepath.Join(root, ".git"))
 }
 
+func TestGitAndRootDirsInBareRepo(t *testing.T) {
+	repo := test.NewBareRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	git, root, err := GitAndRootDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := filepath.EvalSymlinks(repo.Path)
+	actual, _ := filepath.EvalSymlinks(git)
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, "", root)
+
+	assert.Equal(t, "", ObjectType("HEAD"))
+	repo.AddCommits([]*test.CommitInput{
+		{Files: []*test.FileInput{{Filename: "file1.txt", Size: 20}}},
+	})
+	assert.Equal(t, "commit", ObjectType("HEAD"))
+	assert.Equal(t, "blob", ObjectType("HEAD:file1.txt"))
+	assert.Equal(t, "", ObjectType("HEAD:missing"))
+}
+
 func TestGetTrackedFiles(t *testing.T) {
 	repo := test.NewRepo(t)
 	repo.Pushd()
@@ -378,3 +725,388 @@ func TestGetTrackedFiles(t *testing.T) {
 	assert.Equal(t, deletedlist, tracked)
 
 }
+
+func TestGetTrackedFilesWithNewlines(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	repo.AddCommits([]*test.CommitInput{
+		{
+			Files: []*test.FileInput{
+				{Filename: "file1.txt", Size: 20},
+			},
+		},
+	})
+
+	// add a file whose name has a newline straight to the index
+	sha := strings.TrimSpace(test.RunGitCommand(t, true, "hash-object", "-w", "file1.txt"))
+	test.RunGitCommand(t, true, "update-index", "--add", "--cacheinfo", "100644,"+sha+",new\nline.txt")
+	test.RunGitCommand(t, true, "commit", "-m", "add a file with a newline")
+
+	tracked, err := GetTrackedFiles("*.txt")
+	assert.Equal(t, nil, err)
+	sort.Strings(tracked)
+	assert.Equal(t, []string{"file1.txt", "new\nline.txt"}, tracked)
+
+	var streamed []string
+	files, errc := GetTrackedFilesChan("new*")
+	for file := range files {
+		streamed = append(streamed, file)
+	}
+	assert.Equal(t, nil, <-errc)
+	assert.Equal(t, []string{"new\nline.txt"}, streamed)
+}
+
+func TestUnstagedFiles(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	repo.AddCommits([]*test.CommitInput{
+		{
+			Files: []*test.FileInput{
+				{Filename: "file1.txt", Size: 20},
+				{Filename: "folder1/file2.txt", Size: 20},
+				{Filename: "folder1/file3.txt", Size: 20},
+			},
+		},
+	})
+
+	files, err := UnstagedFiles()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(files))
+
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join("folder1", "file2.txt"), []byte("changed"), 0644))
+	assert.Equal(t, nil, os.Remove("file1.txt"))
+
+	// paths are from the root, wherever we are
+	os.Chdir("folder1")
+	files, err = UnstagedFiles()
+	os.Chdir("..")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"file1.txt", "folder1/file2.txt"}, files)
+}
+
+func TestGetTrackedFilesAt(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	inputs := []*test.CommitInput{
+		{ // 0
+			Files: []*test.FileInput{
+				{Filename: "file1.txt", Size: 20},
+				{Filename: "other.dat", Size: 20},
+				{Filename: "folder1/file with space.txt", Size: 20},
+				{Filename: "folder1/naïve 日本.txt", Size: 20},
+			},
+		},
+		{ // 1
+			Files: []*test.FileInput{
+				{Filename: "file2.txt", Size: 20},
+			},
+		},
+	}
+	outputs := repo.AddCommits(inputs)
+
+	// names are unquoted, and files added later aren't there
+	tracked, err := GetTrackedFilesAt(outputs[0].Sha, "*.txt")
+	assert.Equal(t, nil, err)
+	sort.Strings(tracked)
+	assert.Equal(t, []string{"file1.txt", "folder1/file with space.txt", "folder1/naïve 日本.txt"}, tracked)
+
+	tracked, err = GetTrackedFilesAt("HEAD", "*.txt")
+	assert.Equal(t, nil, err)
+	sort.Strings(tracked)
+	assert.Equal(t, []string{"file1.txt", "file2.txt", "folder1/file with space.txt", "folder1/naïve 日本.txt"}, tracked)
+
+	cases := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"", []string{"file1.txt", "file2.txt", "folder1/file with space.txt", "folder1/naïve 日本.txt", "other.dat"}},
+		{"file?.txt", []string{"file1.txt", "file2.txt"}},
+		{"[!f]*", []string{"other.dat"}},
+		{"folder1", []string{"folder1/file with space.txt", "folder1/naïve 日本.txt"}},
+		{"*日本*", []string{"folder1/naïve 日本.txt"}},
+		{"*.jpg", nil},
+	}
+	for _, c := range cases {
+		tracked, err = GetTrackedFilesAt("HEAD", c.pattern)
+		assert.Equal(t, nil, err)
+		sort.Strings(tracked)
+		assert.Equal(t, c.expected, tracked, c.pattern)
+	}
+
+	// relative dir
+	os.Chdir("folder1")
+	tracked, err = GetTrackedFilesAt("HEAD", "*.txt")
+	assert.Equal(t, nil, err)
+	sort.Strings(tracked)
+	assert.Equal(t, []string{"file with space.txt", "naïve 日本.txt"}, tracked)
+	os.Chdir("..")
+
+	_, err = GetTrackedFilesAt("no-such-ref", "*.txt")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestCatFileBatch(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	// plain git files rather than LFS pointers
+	ioutil.WriteFile("a.txt", []byte("first file"), 0644)
+	ioutil.WriteFile("b.txt", []byte("second file, which is longer"), 0644)
+	test.RunGitCommand(t, true, "add", "a.txt", "b.txt")
+	test.RunGitCommand(t, true, "commit", "-m", "add a.txt and b.txt")
+	a := strings.TrimSpace(test.RunGitCommand(t, true, "rev-parse", "HEAD:a.txt"))
+
+	batch, err := NewCatFileBatch()
+	assert.Equal(t, nil, err)
+
+	readAll := func(name string) string {
+		r, size, err := batch.Contents(name)
+		assert.Equal(t, nil, err)
+		by, err := ioutil.ReadAll(r)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, r.Close())
+		assert.Equal(t, size, int64(len(by)))
+		return string(by)
+	}
+
+	assert.Equal(t, "first file", readAll(a))
+	assert.Equal(t, "second file, which is longer", readAll("HEAD:b.txt"))
+
+	// a missing object doesn't stop later ones being read
+	_, _, err = batch.Contents("0000000000000000000000000000000000000001")
+	assert.Equal(t, true, IsMissingObjectError(err))
+	_, _, err = batch.Contents("HEAD:no-such-file.txt")
+	assert.Equal(t, true, IsMissingObjectError(err))
+
+	// neither does closing a reader part way through
+	r, _, err := batch.Contents("HEAD:b.txt")
+	assert.Equal(t, nil, err)
+	part := make([]byte, 6)
+	_, err = io.ReadFull(r, part)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "second", string(part))
+	assert.Equal(t, nil, r.Close())
+
+	// callers in several goroutines take turns
+	var wg sync.WaitGroup
+	results := make(chan string, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "HEAD:a.txt"
+			if i%2 == 1 {
+				name = "HEAD:b.txt"
+			}
+			results <- readAll(name)
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+	counts := make(map[string]int)
+	for result := range results {
+		counts[result]++
+	}
+	assert.Equal(t, 10, counts["first file"])
+	assert.Equal(t, 10, counts["second file, which is longer"])
+
+	assert.Equal(t, nil, batch.Close())
+	_, _, err = batch.Contents(a)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestLsTreeBlobs(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	os.MkdirAll("folder1", 0755)
+	ioutil.WriteFile("small.txt", []byte("small"), 0644)
+	ioutil.WriteFile("folder1/with space.txt", []byte("a bit bigger"), 0644)
+	test.RunGitCommand(t, true, "add", "small.txt", "folder1")
+	test.RunGitCommand(t, true, "commit", "-m", "add files")
+	head := strings.TrimSpace(test.RunGitCommand(t, true, "rev-parse", "HEAD"))
+
+	// a submodule is a commit in the tree, and is left out
+	test.RunGitCommand(t, true, "update-index", "--add", "--cacheinfo", "160000,"+head+",submodule")
+	test.RunGitCommand(t, true, "commit", "-m", "add a submodule")
+
+	// names are from the root wherever we are
+	os.Chdir("folder1")
+	blobs, err := LsTreeBlobs("HEAD")
+	assert.Equal(t, nil, err)
+
+	var got []TreeBlob
+	for blob := range blobs {
+		got = append(got, blob)
+	}
+	os.Chdir("..")
+
+	assert.Equal(t, 2, len(got))
+	assert.Equal(t, "folder1/with space.txt", got[0].Filename)
+	assert.Equal(t, int64(12), got[0].Size)
+	assert.Equal(t, strings.TrimSpace(test.RunGitCommand(t, true, "rev-parse", "HEAD:folder1/with space.txt")), got[0].Sha1)
+	assert.Equal(t, "small.txt", got[1].Filename)
+	assert.Equal(t, int64(5), got[1].Size)
+	assert.Equal(t, strings.TrimSpace(test.RunGitCommand(t, true, "rev-parse", "HEAD:small.txt")), got[1].Sha1)
+
+	_, err = LsTreeBlobs("no-such-ref")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestConfigCache(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	gitConfig := func(args ...string) {
+		// not test.RunGitCommand, which clears the cache
+		cmd := exec.Command("git", append([]string{"config"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git config %v: %v %s", args, err, out)
+		}
+	}
+
+	gitConfig("lfs.cachetest", "first")
+	gitConfig("--add", "Multi.Value", "one")
+	gitConfig("--add", "multi.value", "two")
+	gitConfig("remote.UpperCase.url", "https://example.com/upper")
+	gitConfig("remote.uppercase.url", "https://example.com/lower")
+	Config.ClearCache()
+
+	assert.Equal(t, "first", Config.Find("lfs.cachetest"))
+	assert.Equal(t, "first", Config.FindLocal("lfs.cachetest"))
+	// the last value wins, and section and variable names are case insensitive
+	assert.Equal(t, "two", Config.Find("MULTI.value"))
+	// but subsections aren't
+	assert.Equal(t, "https://example.com/upper", Config.Find("remote.UpperCase.URL"))
+	assert.Equal(t, "https://example.com/lower", Config.Find("remote.uppercase.url"))
+	assert.Equal(t, "", Config.Find("lfs.nosuchkey"))
+
+	list, err := Config.List()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(list, "\nlfs.cachetest=first\n"))
+	assert.Equal(t, true, strings.Contains(list, "\nmulti.value=one\nmulti.value=two\n"))
+
+	// changes from outside are only seen once the cache is cleared
+	gitConfig("lfs.cachetest", "second")
+	assert.Equal(t, "first", Config.Find("lfs.cachetest"))
+	assert.Equal(t, "first", Config.FindLocal("lfs.cachetest"))
+	Config.ClearCache()
+	assert.Equal(t, "second", Config.Find("lfs.cachetest"))
+	assert.Equal(t, "second", Config.FindLocal("lfs.cachetest"))
+
+	// but changes made through Config are seen straight away
+	Config.SetLocal("", "lfs.cachetest", "third")
+	assert.Equal(t, "third", Config.Find("lfs.cachetest"))
+	Config.UnsetLocalKey("", "lfs.cachetest")
+	assert.Equal(t, "", Config.Find("lfs.cachetest"))
+}
+
+func BenchmarkConfigFind(b *testing.B) {
+	Config.ClearCache()
+	for i := 0; i < b.N; i++ {
+		Config.Find("lfs.fetchinclude")
+		Config.Find("lfs.fetchexclude")
+		Config.IsGitVersionAtLeast("2.2.0")
+	}
+}
+
+func TestUpdateIndexBatch(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	names := []string{"a.txt", "with space.txt", "new\nline.txt", "folder/b.txt"}
+	os.MkdirAll("folder", 0755)
+	for _, name := range names {
+		ioutil.WriteFile(name, []byte("content of "+name), 0644)
+	}
+	test.RunGitCommand(t, true, "add", "--", "a.txt", "with space.txt", "new\nline.txt", "folder")
+	test.RunGitCommand(t, true, "commit", "-m", "add files")
+
+	// rewrite them with the same content but new stat data, as checkout does
+	later := time.Now().Add(time.Hour)
+	for _, name := range names {
+		os.Remove(name)
+		ioutil.WriteFile(name, []byte("content of "+name), 0644)
+		os.Chtimes(name, later, later)
+	}
+
+	// diff-files doesn't refresh the index, so lists files with stale stat data
+	stale := test.RunGitCommand(t, true, "diff-files", "--name-only", "-z")
+	assert.Equal(t, len(names), len(strings.Split(strings.TrimRight(stale, "\x00"), "\x00")))
+
+	// run from a subdirectory to check paths are relative to it
+	os.Chdir("folder")
+	err := UpdateIndexBatch([]string{"../a.txt", "../with space.txt", "../new\nline.txt", "b.txt"})
+	os.Chdir("..")
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, "", test.RunGitCommand(t, true, "diff-files", "--name-only", "-z"))
+
+	assert.Equal(t, nil, UpdateIndexBatch(nil))
+}
+
+func TestRemoteURLs(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	test.RunGitCommand(t, true, "remote", "add", "origin", "https://example.com/read.git")
+	test.RunGitCommand(t, true, "config", "--add", "remote.origin.url", "https://mirror.example.com/read.git")
+	test.RunGitCommand(t, true, "config", "remote.origin.pushurl", "https://example.com/write.git")
+	test.RunGitCommand(t, true, "remote", "add", "upstream", "gh:upstream/repo.git")
+	test.RunGitCommand(t, true, "config", "url.https://github.com/.insteadOf", "gh:")
+	test.RunGitCommand(t, true, "config", "url.ssh://git@github.com/.pushInsteadOf", "gh:")
+
+	remotes, err := RemoteList()
+	assert.Equal(t, nil, err)
+	sort.Strings(remotes)
+	assert.Equal(t, []string{"origin", "upstream"}, remotes)
+
+	urls, err := RemoteURLs(false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string][]string{
+		"origin":   []string{"https://example.com/read.git", "https://mirror.example.com/read.git"},
+		"upstream": []string{"https://github.com/upstream/repo.git"},
+	}, urls)
+
+	urls, err = RemoteURLs(true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string][]string{
+		"origin":   []string{"https://example.com/write.git"},
+		"upstream": []string{"ssh://git@github.com/upstream/repo.git"},
+	}, urls)
+}
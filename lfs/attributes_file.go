@@ -0,0 +1,247 @@
+package lfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	attributesSpace   = "[[:space:]]"
+	lfsAttributes     = "filter=lfs diff=lfs merge=lfs -text"
+	lockableAttribute = "lockable"
+)
+
+// EscapeAttributesPattern escapes a path pattern for a line of a
+// .gitattributes file. Whitespace would end the pattern, so it's written as
+// [[:space:]], and a leading # or ! would make a comment or a negative pattern,
+// so they're escaped with a backslash.
+func EscapeAttributesPattern(pattern string) string {
+	escaped := strings.Replace(pattern, " ", attributesSpace, -1)
+	escaped = strings.Replace(escaped, "\t", attributesSpace, -1)
+	if strings.HasPrefix(escaped, "#") || strings.HasPrefix(escaped, "!") {
+		escaped = `\` + escaped
+	}
+	return escaped
+}
+
+// UnescapeAttributesPattern turns a pattern from a .gitattributes file back
+// into the one it was written for by EscapeAttributesPattern.
+func UnescapeAttributesPattern(escaped string) string {
+	if strings.HasPrefix(escaped, `\#`) || strings.HasPrefix(escaped, `\!`) {
+		escaped = escaped[1:]
+	}
+	return strings.Replace(escaped, attributesSpace, " ", -1)
+}
+
+// TrackPattern returns the pattern to write to a .gitattributes file for a
+// path given to git lfs track. A literal filename has its glob characters
+// escaped so that it matches only that file, and a directory becomes "dir/**",
+// as a pattern naming a directory doesn't match the files in it.
+func TrackPattern(arg string, literal, dir bool) string {
+	pattern := filepath.ToSlash(arg)
+	if dir {
+		pattern = path.Clean(pattern)
+	}
+
+	if literal {
+		var buf []byte
+		for i := 0; i < len(pattern); i++ {
+			switch pattern[i] {
+			case '\\', '*', '?', '[':
+				buf = append(buf, '\\')
+			}
+			buf = append(buf, pattern[i])
+		}
+		pattern = string(buf)
+	}
+
+	if !dir {
+		return pattern
+	}
+	if pattern == "." {
+		return "**"
+	}
+	return strings.TrimSuffix(pattern, "/") + "/**"
+}
+
+// AttributesPatternMatches returns whether an unescaped pattern from a
+// .gitattributes file matches filename, which is relative to the file's
+// directory with / separators. As in git, a pattern without a slash matches
+// file names at any depth, and one with a slash matches the whole path, with
+// "**/" matching any number of directories and a trailing "/**" anything
+// inside one. A pattern that matches a directory doesn't match its files.
+func AttributesPatternMatches(pattern, filename string) bool {
+	if !strings.Contains(pattern, "/") {
+		return matchAttributesComponent(pattern, path.Base(filename))
+	}
+
+	components := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	return matchAttributesComponents(components, strings.Split(filename, "/"))
+}
+
+func matchAttributesComponents(pattern, names []string) bool {
+	if len(pattern) == 0 {
+		return len(names) == 0
+	}
+
+	if pattern[0] == "**" {
+		// a trailing "/**" needs something inside the directory
+		start := 0
+		if len(pattern) == 1 {
+			start = 1
+		}
+		for i := start; i <= len(names); i++ {
+			if matchAttributesComponents(pattern[1:], names[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(names) == 0 || !matchAttributesComponent(pattern[0], names[0]) {
+		return false
+	}
+	return matchAttributesComponents(pattern[1:], names[1:])
+}
+
+// matchAttributesComponent matches one path component. path.Match escapes with
+// a backslash on every OS, as git does.
+func matchAttributesComponent(pattern, name string) bool {
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// AttributesFile is a .gitattributes file, read so that patterns can be
+// tracked and untracked without changing any other line, or the line endings
+// the file uses.
+type AttributesFile struct {
+	Path string
+
+	// lines is the file split on "\n", so a CRLF line keeps its "\r", and
+	// the last line is empty if the file ends with a line break.
+	lines []string
+	crlf  bool
+}
+
+// ReadAttributesFile reads the attributes file at path. A missing file is
+// read as an empty one, which is created when it's written.
+func ReadAttributesFile(path string) (*AttributesFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	content := string(data)
+	i := strings.Index(content, "\n")
+	return &AttributesFile{
+		Path:  path,
+		lines: strings.Split(content, "\n"),
+		crlf:  i > 0 && content[i-1] == '\r',
+	}, nil
+}
+
+// LFSPatterns returns the unescaped patterns of the lines that use the lfs
+// filter.
+func (a *AttributesFile) LFSPatterns() []string {
+	var patterns []string
+	for _, line := range a.lines {
+		if pattern, _, ok := parseLFSAttributesLine(line); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// Track adds a line for pattern using the lfs filter, marked lockable if
+// lockable is true. It returns false if the file already has one, after
+// marking it lockable if asked to.
+func (a *AttributesFile) Track(pattern string, lockable bool) bool {
+	for i, line := range a.lines {
+		p, fields, ok := parseLFSAttributesLine(line)
+		if !ok || p != pattern {
+			continue
+		}
+
+		if lockable && !hasAttributesField(fields, lockableAttribute) {
+			trimmed := strings.TrimRight(line, "\r \t")
+			a.lines[i] = trimmed + " " + lockableAttribute + line[len(strings.TrimRight(line, "\r")):]
+			return true
+		}
+		return false
+	}
+
+	newline := EscapeAttributesPattern(pattern) + " " + lfsAttributes
+	if lockable {
+		newline += " " + lockableAttribute
+	}
+	a.appendLine(newline)
+	return true
+}
+
+// Untrack removes the lines for pattern that use the lfs filter. The pattern
+// may be given as it is in the file, or unescaped. It returns false if there
+// weren't any.
+func (a *AttributesFile) Untrack(pattern string) bool {
+	kept := a.lines[:0]
+	for _, line := range a.lines {
+		p, fields, ok := parseLFSAttributesLine(line)
+		if ok && (p == pattern || fields[0] == pattern) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	removed := len(kept) < len(a.lines)
+	a.lines = kept
+	return removed
+}
+
+// Write writes the file back to its path.
+func (a *AttributesFile) Write() error {
+	return ioutil.WriteFile(a.Path, []byte(strings.Join(a.lines, "\n")), 0660)
+}
+
+func (a *AttributesFile) appendLine(line string) {
+	cr := ""
+	if a.crlf {
+		cr = "\r"
+	}
+
+	// give the last line the line break it's missing
+	last := len(a.lines) - 1
+	if len(a.lines[last]) > 0 {
+		if a.crlf && !strings.HasSuffix(a.lines[last], "\r") {
+			a.lines[last] += cr
+		}
+		a.lines = append(a.lines, "")
+		last++
+	}
+
+	a.lines[last] = line + cr
+	a.lines = append(a.lines, "")
+}
+
+// parseLFSAttributesLine returns the unescaped pattern and the fields of a
+// line that uses the lfs filter. ok is false for other lines and comments.
+func parseLFSAttributesLine(line string) (pattern string, fields []string, ok bool) {
+	fields = strings.Fields(line)
+	if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+		return "", nil, false
+	}
+	if !hasAttributesField(fields[1:], "filter=lfs") {
+		return "", nil, false
+	}
+	return UnescapeAttributesPattern(fields[0]), fields, true
+}
+
+func hasAttributesField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package lfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestAttributesPatternRoundTrip(t *testing.T) {
+	tests := map[string]string{
+		"*.jpg":              "*.jpg",
+		"foo bar/*":          "foo[[:space:]]bar/*",
+		"a  b.bin":           "a[[:space:]][[:space:]]b.bin",
+		"#hash.psd":          `\#hash.psd`,
+		"!bang.psd":          `\!bang.psd`,
+		"dir/#not-lead.bin":  "dir/#not-lead.bin",
+		"fichiers/été *.zip": "fichiers/été[[:space:]]*.zip",
+		"日本語.png":            "日本語.png",
+	}
+
+	for pattern, escaped := range tests {
+		assert.Equal(t, escaped, EscapeAttributesPattern(pattern), pattern)
+		assert.Equal(t, pattern, UnescapeAttributesPattern(escaped), escaped)
+	}
+}
+
+func TestAttributesFileTrack(t *testing.T) {
+	path := writeTestAttributesFile(t, "# comment\r\n*.txt text\r\n*.jpg  filter=lfs diff=lfs merge=lfs -text\r\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	attributes, err := ReadAttributesFile(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, attributes.Track("*.jpg", false))
+	assert.Equal(t, true, attributes.Track("#a b.psd", false))
+	assert.Equal(t, false, attributes.Track("#a b.psd", false))
+	assert.Equal(t, true, attributes.Track("été.zip", true))
+	assert.Equal(t, true, attributes.Track("*.jpg", true))
+	assert.Equal(t, false, attributes.Track("*.jpg", true))
+	assert.Equal(t, nil, attributes.Write())
+
+	assert.Equal(t, "# comment\r\n"+
+		"*.txt text\r\n"+
+		"*.jpg  filter=lfs diff=lfs merge=lfs -text lockable\r\n"+
+		`\#a[[:space:]]b.psd filter=lfs diff=lfs merge=lfs -text`+"\r\n"+
+		"été.zip filter=lfs diff=lfs merge=lfs -text lockable\r\n", readTestAttributesFile(t, path))
+
+	attributes, err = ReadAttributesFile(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"*.jpg", "#a b.psd", "été.zip"}, attributes.LFSPatterns())
+}
+
+func TestAttributesFileTrackWithoutTrailingLinebreak(t *testing.T) {
+	path := writeTestAttributesFile(t, "*.mov filter=lfs -text")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	attributes, err := ReadAttributesFile(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, attributes.Track("*.gif", false))
+	assert.Equal(t, nil, attributes.Write())
+
+	assert.Equal(t, "*.mov filter=lfs -text\n*.gif filter=lfs diff=lfs merge=lfs -text\n", readTestAttributesFile(t, path))
+}
+
+func TestAttributesFileTrackMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attributes")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".gitattributes")
+
+	attributes, err := ReadAttributesFile(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(attributes.LFSPatterns()))
+	assert.Equal(t, true, attributes.Track("a b", false))
+	assert.Equal(t, nil, attributes.Write())
+
+	assert.Equal(t, "a[[:space:]]b filter=lfs diff=lfs merge=lfs -text\n", readTestAttributesFile(t, path))
+}
+
+func TestAttributesFileUntrack(t *testing.T) {
+	path := writeTestAttributesFile(t, "*.jpg filter=lfs diff=lfs merge=lfs -text\n"+
+		"# *.png filter=lfs diff=lfs merge=lfs -text\n"+
+		"\\#a[[:space:]]b.psd filter=lfs diff=lfs merge=lfs -text\n"+
+		"*.jpg -delta\n"+
+		"*.png filter=lfs diff=lfs merge=lfs -text\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	attributes, err := ReadAttributesFile(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, attributes.Untrack("*.jpg"))
+	assert.Equal(t, true, attributes.Untrack("#a b.psd"))
+	assert.Equal(t, false, attributes.Untrack("*.gif"))
+	assert.Equal(t, nil, attributes.Write())
+
+	assert.Equal(t, "# *.png filter=lfs diff=lfs merge=lfs -text\n"+
+		"*.jpg -delta\n"+
+		"*.png filter=lfs diff=lfs merge=lfs -text\n", readTestAttributesFile(t, path))
+
+	// as written in the file
+	attributes, err = ReadAttributesFile(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, attributes.Track("a b", false))
+	assert.Equal(t, true, attributes.Untrack("a[[:space:]]b"))
+	assert.Equal(t, []string{"*.png"}, attributes.LFSPatterns())
+}
+
+func writeTestAttributesFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "attributes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, ".gitattributes")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readTestAttributesFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,101 @@
+package lfs_test // to use test.NewRepo
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/github/git-lfs/lfs"
+	"github.com/github/git-lfs/test"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+// TestAttributesPatternMatchesGit checks AttributesPatternMatches against
+// git check-attr, for the patterns git lfs track writes.
+func TestAttributesPatternMatchesGit(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	paths := []string{
+		"a.png",
+		"assets/a.png",
+		"assets/sub/b.png",
+		"assets",
+		"other/assets/c.png",
+		"png",
+		"my file[1].png",
+		"my file1.png",
+		"docs/my file[1].png",
+		"#hash.psd",
+		"x/#hash.psd",
+		"a*b.bin",
+		"axb.bin",
+		"été/photo.jpg",
+		"a/b/c/d.iso",
+	}
+
+	patterns := []string{
+		"*.png",
+		"assets",
+		lfs.TrackPattern("assets", false, true),
+		lfs.TrackPattern("assets/", false, true),
+		lfs.TrackPattern("/assets", false, true),
+		"/a.png",
+		"assets/*.png",
+		"**/*.png",
+		"a/**/d.iso",
+		"assets/**/*.png",
+		"my file[1].png",
+		lfs.TrackPattern("my file[1].png", true, false),
+		lfs.TrackPattern("docs/my file[1].png", true, false),
+		"#hash.psd",
+		lfs.TrackPattern("a*b.bin", true, false),
+		"a*b.bin",
+		lfs.TrackPattern("été", false, true),
+		"?.png",
+		"[ab].png",
+		"**",
+	}
+
+	for _, pattern := range patterns {
+		line := lfs.EscapeAttributesPattern(pattern) + " filter=lfs\n"
+		if err := ioutil.WriteFile(".gitattributes", []byte(line), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		args := append([]string{"check-attr", "-z", "filter", "--"}, paths...)
+		fields := strings.Split(test.RunGitCommand(t, true, args...), "\x00")
+		assert.Equal(t, 3*len(paths)+1, len(fields))
+		for i := 0; i+2 < len(fields); i += 3 {
+			path, value := fields[i], fields[i+2]
+			assert.Equal(t, value == "lfs", lfs.AttributesPatternMatches(pattern, path), pattern+" "+path)
+		}
+	}
+}
+
+func TestTrackPattern(t *testing.T) {
+	tests := []struct {
+		arg      string
+		literal  bool
+		dir      bool
+		expected string
+	}{
+		{"*.png", false, false, "*.png"},
+		{"assets", false, true, "assets/**"},
+		{"assets/", false, true, "assets/**"},
+		{"./assets/sub/", false, true, "assets/sub/**"},
+		{".", false, true, "**"},
+		{"/assets", false, true, "/assets/**"},
+		{"my file[1].png", true, false, `my file\[1].png`},
+		{`a*b?\c.bin`, true, false, `a\*b\?\\c.bin`},
+		{"[dir]", true, true, `\[dir]/**`},
+	}
+
+	for _, c := range tests {
+		assert.Equal(t, c.expected, lfs.TrackPattern(c.arg, c.literal, c.dir), c.arg)
+	}
+}
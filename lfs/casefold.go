@@ -0,0 +1,150 @@
+package lfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+)
+
+var (
+	caseInsensitiveOnce sync.Once
+	caseInsensitive     bool
+)
+
+// CaseInsensitiveWorkingDir returns whether the repository is on a filesystem
+// that ignores case in filenames, as is usual on Windows and Mac OS X. The
+// filesystem is probed once, in the git dir at the root of the repository.
+func CaseInsensitiveWorkingDir() bool {
+	caseInsensitiveOnce.Do(func() {
+		caseInsensitive = IsCaseInsensitiveFS(LocalGitDir)
+		tracerx.Printf("case insensitive filesystem: %v", caseInsensitive)
+	})
+	return caseInsensitive
+}
+
+// IsCaseInsensitiveFS returns whether the filesystem holding dir ignores case,
+// by creating a file with a lower case name and looking for it in upper case.
+func IsCaseInsensitiveFS(dir string) bool {
+	f, err := ioutil.TempFile(dir, "lfs-case-probe-")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	upper := filepath.Join(filepath.Dir(f.Name()), strings.ToUpper(filepath.Base(f.Name())))
+	_, err = os.Stat(upper)
+	return err == nil
+}
+
+// FindCaseCollisions returns each group of paths that differ only in case, and
+// so refer to the same file on a case insensitive filesystem. Each group is in
+// the order the paths were given; the groups are sorted by their first path.
+func FindCaseCollisions(paths []string) [][]string {
+	folded := make(map[string][]string, len(paths))
+	for _, p := range paths {
+		key := strings.ToLower(p)
+		// the same path may be listed more than once, e.g. in several commits
+		if !containsString(folded[key], p) {
+			folded[key] = append(folded[key], p)
+		}
+	}
+
+	var collisions [][]string
+	for _, group := range folded {
+		if len(group) > 1 {
+			collisions = append(collisions, group)
+		}
+	}
+	sort.Sort(caseCollisions(collisions))
+	return collisions
+}
+
+// FixCaseOnDisk renames the file at path if it exists under a name that differs
+// only in case, which is what happens to an existing file when a commit changes
+// the case of its name on a case insensitive filesystem. Returns whether it was
+// renamed.
+func FixCaseOnDisk(path string) (bool, error) {
+	return newCaseFixer().fix(path)
+}
+
+// caseFixer does FixCaseOnDisk for many files, such as for a checkout, reading
+// each directory only once rather than once for every file in it. The listings
+// are kept up to date with its own renames, so nothing else should rename the
+// files meanwhile. It's safe for concurrent use.
+type caseFixer struct {
+	mutex sync.Mutex
+	// for each dir, the name of each file by its lower case name
+	dirs map[string]map[string]string
+}
+
+func newCaseFixer() *caseFixer {
+	return &caseFixer{dirs: make(map[string]map[string]string)}
+}
+
+func (c *caseFixer) fix(path string) (bool, error) {
+	dir := filepath.Dir(path)
+	name := filepath.Base(path)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	names, ok := c.dirs[dir]
+	if !ok {
+		list, err := readDirNames(dir)
+		if err != nil {
+			return false, err
+		}
+		names = make(map[string]string, len(list))
+		for _, existing := range list {
+			names[strings.ToLower(existing)] = existing
+		}
+		c.dirs[dir] = names
+	}
+
+	key := strings.ToLower(name)
+	existing, ok := names[key]
+	if !ok || existing == name {
+		return false, nil
+	}
+
+	tracerx.Printf("Renaming %s to %s to match the case in git", existing, name)
+	if err := os.Rename(localstorage.LongPath(filepath.Join(dir, existing)), localstorage.LongPath(path)); err != nil {
+		return false, err
+	}
+	names[key] = name
+	return true, nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+	d, err := os.Open(localstorage.LongPath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer d.Close()
+	return d.Readdirnames(-1)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type caseCollisions [][]string
+
+func (c caseCollisions) Len() int           { return len(c) }
+func (c caseCollisions) Less(i, j int) bool { return c[i][0] < c[j][0] }
+func (c caseCollisions) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
@@ -0,0 +1,110 @@
+package lfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestFindCaseCollisions(t *testing.T) {
+	collisions := FindCaseCollisions([]string{
+		"textures/Texture.png",
+		"textures/other.png",
+		"Readme.md",
+		"textures/texture.png",
+		"textures/Texture.png",
+		"README.md",
+		"textures/TEXTURE.png",
+		"Textures/other.png",
+	})
+
+	assert.Equal(t, [][]string{
+		{"Readme.md", "README.md"},
+		{"textures/Texture.png", "textures/texture.png", "textures/TEXTURE.png"},
+		{"textures/other.png", "Textures/other.png"},
+	}, collisions)
+
+	assert.Equal(t, 0, len(FindCaseCollisions([]string{"a.png", "b.png", "a.png"})))
+	assert.Equal(t, 0, len(FindCaseCollisions(nil)))
+}
+
+func TestFixCaseOnDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-casefold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(dir, "Texture.png"), []byte("x"), 0644))
+
+	renamed, err := FixCaseOnDisk(filepath.Join(dir, "texture.png"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, renamed)
+	assert.Equal(t, []string{"texture.png"}, readNames(t, dir))
+
+	renamed, err = FixCaseOnDisk(filepath.Join(dir, "texture.png"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, renamed)
+
+	renamed, err = FixCaseOnDisk(filepath.Join(dir, "missing", "file.png"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, renamed)
+}
+
+func TestCaseFixerReadsEachDirOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-casefold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(dir, "A.png"), []byte("a"), 0644))
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(dir, "B.png"), []byte("b"), 0644))
+
+	fixer := newCaseFixer()
+	renamed, err := fixer.fix(filepath.Join(dir, "a.png"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, renamed)
+
+	// files that appear later aren't seen, the listing is from the first fix
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(dir, "C.png"), []byte("c"), 0644))
+	renamed, err = fixer.fix(filepath.Join(dir, "c.png"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, renamed)
+
+	renamed, err = fixer.fix(filepath.Join(dir, "b.png"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, renamed)
+
+	// and it keeps up with its own renames
+	renamed, err = fixer.fix(filepath.Join(dir, "a.png"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, renamed)
+
+	names := readNames(t, dir)
+	sort.Strings(names)
+	assert.Equal(t, []string{"C.png", "a.png", "b.png"}, names)
+}
+
+func TestIsCaseInsensitiveFSCleansUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-casefold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	IsCaseInsensitiveFS(dir)
+	assert.Equal(t, 0, len(readNames(t, dir)))
+}
+
+func readNames(t *testing.T, dir string) []string {
+	names, err := readDirNames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
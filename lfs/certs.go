@@ -1,10 +1,14 @@
 package lfs
 
 import (
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"path/filepath"
+	"strings"
 
 	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
 )
@@ -12,18 +16,48 @@ import (
 // isCertVerificationDisabledForHost returns whether SSL certificate verification
 // has been disabled for the given host, or globally
 func isCertVerificationDisabledForHost(host string) bool {
-	hostSslVerify, _ := Config.GitConfig(fmt.Sprintf("http.https://%v/.sslverify", host))
-	if hostSslVerify == "false" {
+	if sslVerify, _ := httpConfigForHost(host, "sslverify"); sslVerify == "false" {
 		return true
 	}
 
-	globalSslVerify, _ := Config.GitConfig("http.sslverify")
-	if globalSslVerify == "false" || Config.GetenvBool("GIT_SSL_NO_VERIFY", false) {
-		return true
-	}
+	return Config.GetenvBool("GIT_SSL_NO_VERIFY", false)
+}
+
+// httpConfigForHost returns the http.<url>.<key> setting for a https url on
+// host (which may be "host:port"), falling back to http.<key>.
+func httpConfigForHost(host, key string) (string, bool) {
+	return httpConfigFor("https", host, key)
+}
+
+// httpConfigFor returns the http.<url>.<key> setting for urls with the scheme
+// and host, falling back to http.<key>. When several urls match, eg with
+// different paths, the longest one wins like it does in git.
+func httpConfigFor(scheme, host, key string) (string, bool) {
+	host = strings.ToLower(host)
+	suffix := "." + strings.ToLower(key)
 
-	return false
+	var value, match string
+	for k, v := range Config.AllGitConfig() {
+		if len(k) <= len("http.")+len(suffix) || !strings.HasPrefix(k, "http.") || !strings.HasSuffix(k, suffix) {
+			continue
+		}
 
+		rawurl := k[len("http.") : len(k)-len(suffix)]
+		if len(rawurl) <= len(match) {
+			continue
+		}
+
+		u, err := url.Parse(rawurl)
+		if err != nil || u.Scheme != scheme || strings.ToLower(u.Host) != host {
+			continue
+		}
+		value, match = v, rawurl
+	}
+
+	if len(match) > 0 {
+		return value, true
+	}
+	return Config.GitConfig("http" + suffix)
 }
 
 // getRootCAsForHost returns a certificate pool for that specific host (which may
@@ -47,25 +81,19 @@ func appendRootCAsForHostFromGitconfig(pool *x509.CertPool, host string) *x509.C
 	// Accumulate certs from all these locations:
 
 	// GIT_SSL_CAINFO first
-	if cafile, ok := Config.envVars["GIT_SSL_CAINFO"]; ok {
+	if cafile := Config.Getenv("GIT_SSL_CAINFO"); len(cafile) > 0 {
 		return appendCertsFromFile(pool, cafile)
 	}
-	// http.<url>.sslcainfo
-	// we know we have simply "host" or "host:port"
-	key := fmt.Sprintf("http.https://%v/.sslcainfo", host)
-	if cafile, ok := Config.GitConfig(key); ok {
-		return appendCertsFromFile(pool, cafile)
-	}
-	// http.sslcainfo
-	if cafile, ok := Config.GitConfig("http.sslcainfo"); ok {
+	// http.<url>.sslcainfo, then http.sslcainfo
+	if cafile, ok := httpConfigForHost(host, "sslcainfo"); ok {
 		return appendCertsFromFile(pool, cafile)
 	}
 	// GIT_SSL_CAPATH
-	if cadir, ok := Config.envVars["GIT_SSL_CAPATH"]; ok {
+	if cadir := Config.Getenv("GIT_SSL_CAPATH"); len(cadir) > 0 {
 		return appendCertsFromFilesInDir(pool, cadir)
 	}
-	// http.sslcapath
-	if cadir, ok := Config.GitConfig("http.sslcapath"); ok {
+	// http.<url>.sslcapath, then http.sslcapath
+	if cadir, ok := httpConfigForHost(host, "sslcapath"); ok {
 		return appendCertsFromFilesInDir(pool, cadir)
 	}
 
@@ -73,6 +101,93 @@ func appendRootCAsForHostFromGitconfig(pool *x509.CertPool, host string) *x509.C
 
 }
 
+// clientCertFilesForHost returns the certificate and key files for the
+// client certificate to present to host, from GIT_SSL_CERT and GIT_SSL_KEY
+// or http.<url>.sslcert and http.<url>.sslkey. The key is read from the
+// certificate file if there's no key file. certFile is "" if there's no
+// client certificate.
+func clientCertFilesForHost(host string) (certFile, keyFile string) {
+	if certFile = Config.Getenv("GIT_SSL_CERT"); len(certFile) == 0 {
+		certFile, _ = httpConfigForHost(host, "sslcert")
+	}
+	if keyFile = Config.Getenv("GIT_SSL_KEY"); len(keyFile) == 0 {
+		keyFile, _ = httpConfigForHost(host, "sslkey")
+	}
+
+	if len(keyFile) == 0 {
+		keyFile = certFile
+	}
+	return certFile, keyFile
+}
+
+// loadClientCert loads a PEM client certificate and its private key. If the
+// key is encrypted, its passphrase comes from the credential helper, like it
+// does for git with http.sslCertPasswordProtected.
+func loadClientCert(certFile, keyFile string) (*tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading client certificate: %s", err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading client key: %s", err)
+	}
+
+	if block := findPrivateKeyPEMBlock(keyPEM); block != nil {
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			return nil, fmt.Errorf("Client key %s is an encrypted PKCS #8 key, which isn't supported. Convert it to a PKCS #1 key, eg with 'openssl rsa -des3'.", keyFile)
+		}
+
+		if x509.IsEncryptedPEMBlock(block) {
+			keyPEM, err = decryptClientKey(keyFile, block)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading client certificate %s: %s", certFile, err)
+	}
+	return &cert, nil
+}
+
+func findPrivateKeyPEMBlock(data []byte) *pem.Block {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return block
+		}
+	}
+}
+
+// decryptClientKey decrypts the encrypted private key block from keyFile,
+// with the passphrase the credential helper has for cert://<keyFile>.
+func decryptClientKey(keyFile string, block *pem.Block) ([]byte, error) {
+	input := Creds{"protocol": "cert", "path": keyFile}
+	creds, err := execCreds(input, "fill")
+	if err != nil {
+		return nil, fmt.Errorf("Client key %s is encrypted, and asking for its passphrase failed: %s", keyFile, err)
+	}
+	if creds == nil || len(creds["password"]) == 0 {
+		return nil, fmt.Errorf("Client key %s is encrypted, and no passphrase was given for it.", keyFile)
+	}
+
+	der, err := x509.DecryptPEMBlock(block, []byte(creds["password"]))
+	if err != nil {
+		execCreds(creds, "reject")
+		return nil, fmt.Errorf("Error decrypting client key %s: %s", keyFile, err)
+	}
+	execCreds(creds, "approve")
+
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
 func appendCertsFromFilesInDir(pool *x509.CertPool, dir string) *x509.CertPool {
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
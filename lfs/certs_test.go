@@ -1,11 +1,24 @@
 package lfs
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
 )
@@ -35,6 +48,7 @@ NpW5Hh0w4/5iIetCkJ0=
 -----END CERTIFICATE-----`
 
 func TestCertFromSSLCAInfoConfig(t *testing.T) {
+	defer setSSLTestEnv()()
 
 	tempfile, err := ioutil.TempFile("", "testcert")
 	assert.Equal(t, nil, err, "Error creating temp cert file")
@@ -77,6 +91,7 @@ func TestCertFromSSLCAInfoConfig(t *testing.T) {
 }
 
 func TestCertFromSSLCAInfoEnv(t *testing.T) {
+	defer setSSLTestEnv()()
 
 	tempfile, err := ioutil.TempFile("", "testcert")
 	assert.Equal(t, nil, err, "Error creating temp cert file")
@@ -104,6 +119,7 @@ func TestCertFromSSLCAInfoEnv(t *testing.T) {
 }
 
 func TestCertFromSSLCAPathConfig(t *testing.T) {
+	defer setSSLTestEnv()()
 
 	tempdir, err := ioutil.TempDir("", "testcertdir")
 	assert.Equal(t, nil, err, "Error creating temp cert dir")
@@ -130,6 +146,7 @@ func TestCertFromSSLCAPathConfig(t *testing.T) {
 }
 
 func TestCertFromSSLCAPathEnv(t *testing.T) {
+	defer setSSLTestEnv()()
 
 	tempdir, err := ioutil.TempDir("", "testcertdir")
 	assert.Equal(t, nil, err, "Error creating temp cert dir")
@@ -156,6 +173,7 @@ func TestCertFromSSLCAPathEnv(t *testing.T) {
 }
 
 func TestCertVerifyDisabledGlobalEnv(t *testing.T) {
+	defer setSSLTestEnv()()
 
 	assert.Equal(t, false, isCertVerificationDisabledForHost("anyhost.com"))
 
@@ -169,6 +187,7 @@ func TestCertVerifyDisabledGlobalEnv(t *testing.T) {
 }
 
 func TestCertVerifyDisabledGlobalConfig(t *testing.T) {
+	defer setSSLTestEnv()()
 
 	assert.Equal(t, false, isCertVerificationDisabledForHost("anyhost.com"))
 
@@ -182,6 +201,7 @@ func TestCertVerifyDisabledGlobalConfig(t *testing.T) {
 }
 
 func TestCertVerifyDisabledHostConfig(t *testing.T) {
+	defer setSSLTestEnv()()
 
 	assert.Equal(t, false, isCertVerificationDisabledForHost("specifichost.com"))
 	assert.Equal(t, false, isCertVerificationDisabledForHost("otherhost.com"))
@@ -195,3 +215,277 @@ func TestCertVerifyDisabledHostConfig(t *testing.T) {
 	assert.Equal(t, true, isCertVerificationDisabledForHost("specifichost.com"))
 	assert.Equal(t, false, isCertVerificationDisabledForHost("otherhost.com"))
 }
+
+func TestCertFromScopedSSLCAInfoConfig(t *testing.T) {
+	defer setSSLTestEnv()()
+	tempfile, err := ioutil.TempFile("", "testcert")
+	assert.Equal(t, nil, err, "Error creating temp cert file")
+	defer os.Remove(tempfile.Name())
+
+	_, err = tempfile.WriteString(testCert)
+	assert.Equal(t, nil, err, "Error writing temp cert file")
+	tempfile.Close()
+
+	oldGitConfig := Config.gitConfig
+	defer func() {
+		Config.gitConfig = oldGitConfig
+	}()
+
+	// urls with paths, or without the trailing slash, apply to the whole host
+	Config.gitConfig = map[string]string{
+		"http.https://git-lfs.local/org/repo.git.sslcainfo": tempfile.Name(),
+		"http.https://git-lfs.local:8443.sslcainfo":         tempfile.Name(),
+		"http.http://plain-http.local/.sslcainfo":           tempfile.Name(),
+	}
+
+	assert.NotEqual(t, (*x509.CertPool)(nil), getRootCAsForHost("git-lfs.local"))
+	assert.NotEqual(t, (*x509.CertPool)(nil), getRootCAsForHost("git-lfs.local:8443"))
+	assert.Equal(t, (*x509.CertPool)(nil), getRootCAsForHost("wronghost.com"))
+	assert.Equal(t, (*x509.CertPool)(nil), getRootCAsForHost("plain-http.local"))
+}
+
+func TestHttpConfigForHostPrefersLongestUrl(t *testing.T) {
+	oldGitConfig := Config.gitConfig
+	defer func() {
+		Config.gitConfig = oldGitConfig
+	}()
+
+	Config.gitConfig = map[string]string{
+		"http.sslcert":                                "global",
+		"http.https://git-lfs.local/.sslcert":         "host",
+		"http.https://git-lfs.local/org/repo.sslcert": "repo",
+	}
+
+	value, ok := httpConfigForHost("git-lfs.local", "sslCert")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "repo", value)
+
+	value, ok = httpConfigForHost("otherhost.com", "sslCert")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "global", value)
+
+	_, ok = httpConfigForHost("git-lfs.local", "sslkey")
+	assert.Equal(t, false, ok)
+}
+
+func TestCertVerifyDisabledScopedConfig(t *testing.T) {
+	defer setSSLTestEnv()()
+	oldGitConfig := Config.gitConfig
+	defer func() {
+		Config.gitConfig = oldGitConfig
+	}()
+	Config.gitConfig = map[string]string{
+		"http.sslverify": "false",
+		"http.https://specifichost.com/.sslverify": "true",
+	}
+
+	assert.Equal(t, false, isCertVerificationDisabledForHost("specifichost.com"))
+	assert.Equal(t, true, isCertVerificationDisabledForHost("otherhost.com"))
+}
+
+func TestHttpTransportTrustsConfiguredCA(t *testing.T) {
+	defer setSSLTestEnv()()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dir := certTestDir(t)
+	defer os.RemoveAll(dir)
+	cafile := writeTestPEM(t, dir, "ca.pem", "CERTIFICATE", srv.TLS.Certificates[0].Certificate[0])
+
+	oldGitConfig := Config.gitConfig
+	defer func() {
+		Config.gitConfig = oldGitConfig
+	}()
+
+	host := srv.Listener.Addr().String()
+	Config.gitConfig = map[string]string{}
+	assert.NotEqual(t, nil, getWithTransport(host, srv.URL))
+
+	Config.gitConfig = map[string]string{
+		fmt.Sprintf("http.https://%s/repo.git.sslcainfo", host): cafile,
+	}
+	assert.Equal(t, nil, getWithTransport(host, srv.URL))
+
+	Config.gitConfig = map[string]string{"http.sslverify": "false"}
+	assert.Equal(t, nil, getWithTransport(host, srv.URL))
+}
+
+func TestHttpTransportPresentsClientCert(t *testing.T) {
+	defer setSSLTestEnv()()
+	dir := certTestDir(t)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Equal(t, nil, err)
+	cert := newTestClientCert(t, &key.PublicKey, key)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Equal(t, nil, err)
+
+	certfile := writeTestPEM(t, dir, "client.pem", "CERTIFICATE", cert.Raw)
+	keyfile := writeTestPEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+
+	srv := newClientCertServer(cert)
+	defer srv.Close()
+	cafile := writeTestPEM(t, dir, "ca.pem", "CERTIFICATE", srv.TLS.Certificates[0].Certificate[0])
+
+	oldGitConfig := Config.gitConfig
+	defer func() {
+		Config.gitConfig = oldGitConfig
+	}()
+
+	host := srv.Listener.Addr().String()
+	Config.gitConfig = map[string]string{"http.sslcainfo": cafile}
+	assert.NotEqual(t, nil, getWithTransport(host, srv.URL))
+
+	Config.gitConfig = map[string]string{
+		"http.sslcainfo": cafile,
+		fmt.Sprintf("http.https://%s/.sslcert", host): certfile,
+		fmt.Sprintf("http.https://%s/.sslkey", host):  keyfile,
+	}
+	assert.Equal(t, nil, getWithTransport(host, srv.URL))
+
+	// the key can be in the certificate file
+	both := filepath.Join(dir, "both.pem")
+	certPEM, _ := ioutil.ReadFile(certfile)
+	keyPEM, _ := ioutil.ReadFile(keyfile)
+	assert.Equal(t, nil, ioutil.WriteFile(both, append(certPEM, keyPEM...), 0600))
+
+	Config.gitConfig = map[string]string{"http.sslcainfo": cafile}
+	Config.envVars["GIT_SSL_CERT"] = both
+	assert.Equal(t, nil, getWithTransport(host, srv.URL))
+}
+
+func TestHttpTransportDecryptsClientKey(t *testing.T) {
+	defer setSSLTestEnv()()
+	dir := certTestDir(t)
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Equal(t, nil, err)
+	cert := newTestClientCert(t, &key.PublicKey, key)
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte("s3kr1t"), x509.PEMCipherAES256)
+	assert.Equal(t, nil, err)
+
+	certfile := writeTestPEM(t, dir, "client.pem", "CERTIFICATE", cert.Raw)
+	keyfile := filepath.Join(dir, "client.key")
+	assert.Equal(t, nil, ioutil.WriteFile(keyfile, pem.EncodeToMemory(block), 0600))
+
+	srv := newClientCertServer(cert)
+	defer srv.Close()
+	cafile := writeTestPEM(t, dir, "ca.pem", "CERTIFICATE", srv.TLS.Certificates[0].Certificate[0])
+
+	oldGitConfig := Config.gitConfig
+	oldExecCreds := execCreds
+	defer func() {
+		Config.gitConfig = oldGitConfig
+		execCreds = oldExecCreds
+	}()
+
+	Config.gitConfig = map[string]string{
+		"http.sslcainfo": cafile,
+		"http.sslcert":   certfile,
+		"http.sslkey":    keyfile,
+	}
+
+	var calls []string
+	password := "s3kr1t"
+	execCreds = func(input Creds, subCommand string) (Creds, error) {
+		calls = append(calls, subCommand)
+		assert.Equal(t, "cert", input["protocol"])
+		assert.Equal(t, keyfile, input["path"])
+		return Creds{"protocol": "cert", "path": keyfile, "password": password}, nil
+	}
+
+	host := srv.Listener.Addr().String()
+	assert.Equal(t, nil, getWithTransport(host, srv.URL))
+	assert.Equal(t, []string{"fill", "approve"}, calls)
+
+	calls = nil
+	password = "wrong"
+	err = getWithTransport(host, srv.URL)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, true, strings.Contains(err.Error(), "Error decrypting client key "+keyfile))
+	assert.Equal(t, []string{"fill", "reject"}, calls)
+
+	password = ""
+	err = getWithTransport(host, srv.URL)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, true, strings.Contains(err.Error(), "no passphrase was given"))
+}
+
+// setSSLTestEnv blanks the GIT_SSL_* env vars, so that the tests don't pick
+// up the ones they're run with. It returns a func that restores them.
+func setSSLTestEnv() func() {
+	oldEnv := Config.envVars
+	Config.envVars = map[string]string{
+		"GIT_SSL_CAINFO":    "",
+		"GIT_SSL_CAPATH":    "",
+		"GIT_SSL_CERT":      "",
+		"GIT_SSL_KEY":       "",
+		"GIT_SSL_NO_VERIFY": "",
+	}
+	return func() {
+		Config.envVars = oldEnv
+	}
+}
+
+// getWithTransport makes a request with a new transport for host, so that
+// it picks up the current config.
+func getWithTransport(host, rawurl string) error {
+	tr, err := Config.newHttpTransport(host)
+	if err != nil {
+		return err
+	}
+	defer tr.CloseIdleConnections()
+
+	res, err := (&http.Client{Transport: tr}).Get(rawurl)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// newClientCertServer starts a TLS server that only accepts connections with
+// the given client certificate.
+func newClientCertServer(clientCert *x509.Certificate) *httptest.Server {
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	return srv
+}
+
+func newTestClientCert(t *testing.T, pub, priv interface{}) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "git-lfs client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	assert.Equal(t, nil, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Equal(t, nil, err)
+	return cert
+}
+
+func certTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "testcerts")
+	assert.Equal(t, nil, err)
+	return dir
+}
+
+func writeTestPEM(t *testing.T, dir, name, pemType string, der []byte) string {
+	filename := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der})
+	assert.Equal(t, nil, ioutil.WriteFile(filename, data, 0600))
+	return filename
+}
@@ -0,0 +1,139 @@
+package lfs
+
+import (
+	"os"
+	"sync"
+
+	"github.com/github/git-lfs/localstorage"
+)
+
+const defaultCheckoutWorkers = 4
+
+// CheckoutFile is a pointer to write to the working tree at Path, which is
+// relative to the current directory.
+type CheckoutFile struct {
+	*WrappedPointer
+	Path string
+}
+
+// CheckoutResult is what happened when checking out a CheckoutFile.
+type CheckoutResult struct {
+	*CheckoutFile
+	// Written is true if the file was written, so it needs refreshing in the
+	// index. A file whose object isn't local is written with its pointer, and
+	// Err is a download declined error.
+	Written bool
+	Err     error
+}
+
+// CheckoutFiles writes the content of each file read from files to the working
+// tree, with up to workers at once, and sends a result for each to the returned
+// channel, which is closed once they're all done. A file failing doesn't stop
+// the others. Files that have been changed to something other than their
+// pointer are left alone. Files scanned from a tree or the index get the mode
+// recorded there, so executables stay executable.
+func CheckoutFiles(files <-chan *CheckoutFile, workers int) <-chan *CheckoutResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make(chan *CheckoutResult, workers)
+
+	var fixer *caseFixer
+	if CaseInsensitiveWorkingDir() {
+		fixer = newCaseFixer()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for f := range files {
+				results <- checkoutFile(f, fixer)
+			}
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// checkoutFile writes f to the working tree. With a fixer, an existing file
+// whose name differs only in case is renamed first, see FixCaseOnDisk.
+func checkoutFile(f *CheckoutFile, fixer *caseFixer) *CheckoutResult {
+	result := &CheckoutResult{CheckoutFile: f}
+
+	// Check the content - either missing or still this pointer (not exist is ok)
+	filepointer, err := DecodePointerFromFile(f.Path)
+	if err != nil && !os.IsNotExist(err) {
+		if !IsNotAPointerError(err) {
+			result.Err = Errorf(err, "Problem accessing %v", f.Name)
+		}
+		// otherwise the file has non-pointer content, leave it alone
+		return result
+	}
+
+	if filepointer != nil && filepointer.Oid != f.Oid {
+		// User has probably manually reset a file to another commit
+		// while leaving it a pointer; don't mess with this
+		return result
+	}
+
+	if fixer != nil {
+		// A commit may have changed only the case of the name, keep up with it
+		if _, err := fixer.fix(f.Path); err != nil {
+			result.Err = Errorf(err, "Could not rename %v to match its case in git", f.Name)
+			return result
+		}
+	}
+
+	err = PointerSmudgeToFile(f.Path, f.Pointer, false, nil)
+	if err != nil && !IsDownloadDeclinedError(err) {
+		result.Err = Errorf(err, "Could not checkout %v: %v", f.Name, err)
+		return result
+	}
+
+	result.Written = true
+	result.Err = err
+	// a declined download matters more than the mode, so keep its error
+	if modeErr := setFileMode(f.Path, f.Oid, f.Mode); modeErr != nil && result.Err == nil {
+		result.Err = Errorf(modeErr, "Could not set the mode of %v: %v", f.Name, modeErr)
+	}
+	return result
+}
+
+// setFileMode makes path executable or not, as its mode in git says, like git
+// does: executable by whoever can read it. Other modes, including an unknown
+// one, leave the file as it is. A file hard linked to the object oid is copied
+// first, so the object's mode doesn't change with it.
+func setFileMode(path, oid, mode string) error {
+	if mode != "100755" && mode != "100644" {
+		return nil
+	}
+
+	stat, err := os.Stat(localstorage.LongPath(path))
+	if err != nil {
+		return err
+	}
+
+	perm := stat.Mode().Perm()
+	if mode == "100755" {
+		perm |= (perm & 0444) >> 2
+	} else {
+		perm &^= 0111
+	}
+
+	if perm == stat.Mode().Perm() {
+		return nil
+	}
+
+	if err := breakObjectLink(path, oid); err != nil {
+		return err
+	}
+	return os.Chmod(localstorage.LongPath(path), perm)
+}
@@ -0,0 +1,160 @@
+package lfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+// storeObject writes content as a local object and returns a pointer to it.
+func storeObject(t *testing.T, content string) *Pointer {
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+	mediafile, err := LocalMediaPath(oid)
+	if err != nil {
+		t.Fatalf("Unable to get media path: %s", err)
+	}
+	if err := ioutil.WriteFile(mediafile, []byte(content), 0644); err != nil {
+		t.Fatalf("Unable to write object: %s", err)
+	}
+	return NewPointer(oid, int64(len(content)), nil)
+}
+
+func checkoutAll(files []*CheckoutFile, workers int) map[string]*CheckoutResult {
+	in := make(chan *CheckoutFile)
+	go func() {
+		for _, f := range files {
+			in <- f
+		}
+		close(in)
+	}()
+
+	results := make(map[string]*CheckoutResult)
+	for result := range CheckoutFiles(in, workers) {
+		results[result.Name] = result
+	}
+	return results
+}
+
+func TestCheckoutFilesConcurrently(t *testing.T) {
+	_, workDir, cleanup := setupCloneTest(t, "unused")
+	defer cleanup()
+
+	var files []*CheckoutFile
+	for i := 0; i < 300; i++ {
+		name := fmt.Sprintf("dir%d/file%d.dat", i%10, i)
+		ptr := storeObject(t, fmt.Sprintf("content %d", i))
+		files = append(files, &CheckoutFile{
+			WrappedPointer: &WrappedPointer{Name: name, Pointer: ptr},
+			Path:           filepath.Join(workDir, name),
+		})
+	}
+
+	// some files already hold their pointer, as after a clone that skipped them
+	for _, f := range files[:50] {
+		os.MkdirAll(filepath.Dir(f.Path), 0755)
+		ioutil.WriteFile(f.Path, []byte(f.Pointer.Encoded()), 0644)
+	}
+
+	results := checkoutAll(files, 8)
+	assert.Equal(t, 300, len(results))
+
+	for i, f := range files {
+		result := results[f.Name]
+		assert.Equal(t, nil, result.Err)
+		assert.Equal(t, true, result.Written)
+
+		by, err := ioutil.ReadFile(f.Path)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, fmt.Sprintf("content %d", i), string(by))
+	}
+
+	// no temp files are left behind
+	for i := 0; i < 10; i++ {
+		names, err := ioutil.ReadDir(filepath.Join(workDir, fmt.Sprintf("dir%d", i)))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 30, len(names))
+	}
+}
+
+func TestCheckoutFilesLeavesChangedFilesAlone(t *testing.T) {
+	ptr, workDir, cleanup := setupCloneTest(t, "content")
+	defer cleanup()
+	other := storeObject(t, "other content")
+	missing := NewPointer("0000000000000000000000000000000000000000000000000000000000000000", 7, nil)
+
+	files := []*CheckoutFile{
+		{&WrappedPointer{Name: "edited.dat", Pointer: ptr}, filepath.Join(workDir, "edited.dat")},
+		{&WrappedPointer{Name: "reset.dat", Pointer: ptr}, filepath.Join(workDir, "reset.dat")},
+		{&WrappedPointer{Name: "missing.dat", Pointer: missing}, filepath.Join(workDir, "missing.dat")},
+	}
+	os.MkdirAll(workDir, 0755)
+	ioutil.WriteFile(files[0].Path, []byte("edited"), 0644)
+	ioutil.WriteFile(files[1].Path, []byte(other.Encoded()), 0644)
+
+	results := checkoutAll(files, 2)
+
+	edited := results["edited.dat"]
+	assert.Equal(t, false, edited.Written)
+	assert.Equal(t, nil, edited.Err)
+	by, _ := ioutil.ReadFile(files[0].Path)
+	assert.Equal(t, "edited", string(by))
+
+	reset := results["reset.dat"]
+	assert.Equal(t, false, reset.Written)
+	assert.Equal(t, nil, reset.Err)
+	by, _ = ioutil.ReadFile(files[1].Path)
+	assert.Equal(t, other.Encoded(), string(by))
+
+	// an object that isn't local is written as its pointer
+	notLocal := results["missing.dat"]
+	assert.Equal(t, true, notLocal.Written)
+	assert.Equal(t, true, IsDownloadDeclinedError(notLocal.Err))
+	by, _ = ioutil.ReadFile(files[2].Path)
+	assert.Equal(t, missing.Encoded(), string(by))
+}
+
+func TestCheckoutFilesSetsModeFromGit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no executable bit on windows")
+	}
+
+	ptr, workDir, cleanup := setupCloneTest(t, "content")
+	defer cleanup()
+
+	files := []*CheckoutFile{
+		{&WrappedPointer{Name: "new.sh", Mode: "100755", Pointer: ptr}, filepath.Join(workDir, "new.sh")},
+		{&WrappedPointer{Name: "pointer.sh", Mode: "100755", Pointer: ptr}, filepath.Join(workDir, "pointer.sh")},
+		{&WrappedPointer{Name: "plain.dat", Mode: "100644", Pointer: ptr}, filepath.Join(workDir, "plain.dat")},
+		{&WrappedPointer{Name: "unknown.dat", Pointer: ptr}, filepath.Join(workDir, "unknown.dat")},
+	}
+	os.MkdirAll(workDir, 0755)
+	ioutil.WriteFile(files[1].Path, []byte(ptr.Encoded()), 0640)
+	ioutil.WriteFile(files[2].Path, []byte(ptr.Encoded()), 0755)
+	ioutil.WriteFile(files[3].Path, []byte(ptr.Encoded()), 0750)
+
+	results := checkoutAll(files, 2)
+
+	expected := map[string]os.FileMode{
+		"pointer.sh":  0750,
+		"plain.dat":   0644,
+		"unknown.dat": 0750,
+	}
+	for _, f := range files {
+		assert.Equal(t, nil, results[f.Name].Err)
+		stat, err := os.Stat(f.Path)
+		assert.Equal(t, nil, err)
+		if f.Name == "new.sh" {
+			assert.Equal(t, true, stat.Mode()&0100 != 0, f.Name)
+			continue
+		}
+		assert.Equal(t, expected[f.Name], stat.Mode().Perm(), f.Name)
+	}
+}
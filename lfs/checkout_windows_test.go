@@ -0,0 +1,48 @@
+// +build windows
+
+package lfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestCheckoutBeyondMaxPath(t *testing.T) {
+	ptr, workDir, cleanup := setupCloneTest(t, "checked out beyond MAX_PATH")
+	defer cleanup()
+
+	deep := workDir
+	for len(deep) < 300 {
+		deep = filepath.Join(deep, strings.Repeat("d", 50))
+	}
+
+	// an existing pointer whose name differs in case, as after a commit which
+	// renamed it
+	wrongCase := filepath.Join(deep, "ASSET.DAT")
+	err := os.MkdirAll(localstorage.LongPath(deep), 0755)
+	assert.Equal(t, nil, err)
+	err = ioutil.WriteFile(localstorage.LongPath(wrongCase), []byte(ptr.Encoded()), 0644)
+	assert.Equal(t, nil, err)
+
+	path := filepath.Join(deep, "asset.dat")
+	assert.Equal(t, true, len(path) > 300)
+
+	renamed, err := FixCaseOnDisk(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, renamed)
+
+	f := &CheckoutFile{WrappedPointer: &WrappedPointer{Name: "asset.dat", Pointer: ptr}, Path: path}
+	result := checkoutFile(f, newCaseFixer())
+	assert.Equal(t, nil, result.Err)
+	assert.Equal(t, true, result.Written)
+
+	by, err := ioutil.ReadFile(localstorage.LongPath(path))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "checked out beyond MAX_PATH", string(by))
+}
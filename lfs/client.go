@@ -15,8 +15,10 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/github/git-lfs/git"
+	"github.com/github/git-lfs/trace"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
 )
 
@@ -46,7 +48,7 @@ type ObjectError struct {
 }
 
 func (e *ObjectError) Error() string {
-	return fmt.Sprintf("[%d] %s", e.Code, e.Message)
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
 }
 
 type ObjectResource struct {
@@ -114,7 +116,7 @@ func (e *ClientError) Error() string {
 // API will be used, but if the server does not implement the batch operations
 // it will fall back to the legacy API.
 func Download(oid string, size int64) (io.ReadCloser, int64, error) {
-	if !Config.BatchTransfer() {
+	if !Config.EndpointBatch(Config.Endpoint("download")) {
 		return DownloadLegacy(oid)
 	}
 
@@ -122,10 +124,10 @@ func Download(oid string, size int64) (io.ReadCloser, int64, error) {
 		&ObjectResource{Oid: oid, Size: size},
 	}
 
-	objs, err := Batch(objects, "download")
+	objs, _, err := Batch(objects, "download", nil)
 	if err != nil {
 		if IsNotImplementedError(err) {
-			git.Config.SetLocal("", "lfs.batch", "false")
+			Config.SetEndpointBatch(Config.Endpoint("download"), false)
 			return DownloadLegacy(oid)
 		}
 		return nil, 0, err
@@ -162,7 +164,7 @@ func DownloadLegacy(oid string) (io.ReadCloser, int64, error) {
 	}
 	LogTransfer("lfs.data.download", res)
 
-	return res.Body, res.ContentLength, nil
+	return throttleReadCloser(res.Body, "download"), res.ContentLength, nil
 }
 
 type byteCloser struct {
@@ -201,28 +203,77 @@ func DownloadObject(obj *ObjectResource) (io.ReadCloser, int64, error) {
 	}
 	LogTransfer("lfs.data.download", res)
 
-	return res.Body, res.ContentLength, nil
+	return throttleReadCloser(res.Body, "download"), res.ContentLength, nil
 }
 
 func (b *byteCloser) Close() error {
 	return nil
 }
 
-func Batch(objects []*ObjectResource, operation string) ([]*ObjectResource, error) {
+// Batch asks the batch API how to transfer objects, offering it the named
+// transfer adapters. It returns the objects and the adapter the server picked,
+// which is "basic" if it didn't say.
+func Batch(objects []*ObjectResource, operation string, transferAdapters []string) ([]*ObjectResource, string, error) {
 	if len(objects) == 0 {
-		return nil, nil
+		return nil, basicAdapterName, nil
 	}
 
 	o := map[string]interface{}{"objects": objects, "operation": operation}
+	if len(transferAdapters) > 0 {
+		o["transfers"] = transferAdapters
+	}
 
 	by, err := json.Marshal(o)
 	if err != nil {
-		return nil, Error(err)
+		return nil, "", Error(err)
+	}
+
+	tracerx.Printf("api: batch %d files", len(objects))
+
+	maxRetries := Config.TransferMaxRetries()
+	for retries := 0; ; retries++ {
+		res, batch, err := batchRequest(by, operation)
+		if err == nil {
+			adapter := batch.Transfer
+			if len(adapter) == 0 {
+				adapter = basicAdapterName
+			}
+			return batch.Objects, adapter, nil
+		}
+
+		if !isRetriableRequest(res, err) {
+			return nil, "", err
+		}
+
+		if retries >= maxRetries {
+			if retries > 0 {
+				return nil, "", newRetriesExhaustedError(err, retries)
+			}
+			return nil, "", err
+		}
+
+		// The request is built again for each retry, since SSH credentials in
+		// particular may have expired.
+		delay, ok := retryAfterOf(err)
+		if !ok {
+			delay = retryBackoff(retries + 1)
+		}
+		tracerx.Printf("api: batch failed, retrying in %s (%d/%d): %s", delay, retries+1, maxRetries, err)
+		time.Sleep(delay)
 	}
+}
+
+// batchResponse is the body of a batch API response.
+type batchResponse struct {
+	Transfer string            `json:"transfer,omitempty"`
+	Objects  []*ObjectResource `json:"objects"`
+}
 
+// batchRequest makes a single batch API request with the encoded objects.
+func batchRequest(by []byte, operation string) (*http.Response, *batchResponse, error) {
 	req, err := newBatchApiRequest(operation)
 	if err != nil {
-		return nil, Error(err)
+		return nil, nil, Error(err)
 	}
 
 	req.Header.Set("Content-Type", mediaType)
@@ -230,41 +281,39 @@ func Batch(objects []*ObjectResource, operation string) ([]*ObjectResource, erro
 	req.ContentLength = int64(len(by))
 	req.Body = &byteCloser{bytes.NewReader(by)}
 
-	tracerx.Printf("api: batch %d files", len(objects))
-
-	res, objs, err := doApiBatchRequest(req)
+	res, batch, err := doApiBatchRequest(req)
 
 	if err != nil {
 
 		if res == nil {
-			return nil, newRetriableError(err)
+			return nil, nil, newRetriableError(err)
 		}
 
 		if res.StatusCode == 0 {
-			return nil, newRetriableError(err)
+			return res, nil, newRetriableError(err)
 		}
 
-		if IsAuthError(err) {
-			setAuthType(req, res)
-			return Batch(objects, operation)
+		if IsAuthError(err) && setAuthType(req, res) {
+			return batchRequest(by, operation)
 		}
 
 		switch res.StatusCode {
-		case 404, 410:
+		case 404, 410, 501:
 			tracerx.Printf("api: batch not implemented: %d", res.StatusCode)
-			return nil, newNotImplementedError(nil)
+			return res, nil, newNotImplementedError(nil)
 		}
 
 		tracerx.Printf("api error: %s", err)
-		return nil, Error(err)
+		return res, nil, Error(err)
 	}
 	LogTransfer("lfs.api.batch", res)
 
 	if res.StatusCode != 200 {
-		return nil, Error(fmt.Errorf("Invalid status for %s: %d", traceHttpReq(req), res.StatusCode))
+		return res, nil, Error(fmt.Errorf("Invalid status for %s: %d", traceHttpReq(req), res.StatusCode))
 	}
 
-	return objs, nil
+	Config.SetEndpointBatch(Config.Endpoint(operation), true)
+	return res, batch, nil
 }
 
 func UploadCheck(oidPath string) (*ObjectResource, error) {
@@ -300,8 +349,10 @@ func UploadCheck(oidPath string) (*ObjectResource, error) {
 
 	if err != nil {
 		if IsAuthError(err) {
-			setAuthType(req, res)
-			return UploadCheck(oidPath)
+			if setAuthType(req, res) {
+				return UploadCheck(oidPath)
+			}
+			return nil, err
 		}
 
 		return nil, newRetriableError(err)
@@ -328,6 +379,15 @@ func UploadObject(o *ObjectResource, cb CopyCallback) error {
 		return Error(err)
 	}
 
+	for _, a := range uploadAdapters {
+		if _, ok := o.Rel(a.rel); ok {
+			if err := a.upload(o, path, cb); err != nil {
+				return err
+			}
+			return verifyUpload(o)
+		}
+	}
+
 	file, err := os.Open(path)
 	if err != nil {
 		return Error(err)
@@ -337,7 +397,7 @@ func UploadObject(o *ObjectResource, cb CopyCallback) error {
 	reader := &CallbackReader{
 		C:         cb,
 		TotalSize: o.Size,
-		Reader:    file,
+		Reader:    throttleReader(file, "upload"),
 	}
 
 	req, err := o.NewRequest("upload", "PUT")
@@ -377,16 +437,24 @@ func UploadObject(o *ObjectResource, cb CopyCallback) error {
 	io.Copy(ioutil.Discard, res.Body)
 	res.Body.Close()
 
+	return verifyUpload(o)
+}
+
+// verifyUpload asks the server to check an upload of o, if it wants to, by
+// sending its oid and size to the "verify" action. The server may not keep the
+// object until then, so the upload fails, to be retried, if it can't be
+// verified.
+func verifyUpload(o *ObjectResource) error {
 	if _, ok := o.Rel("verify"); !ok {
 		return nil
 	}
 
-	req, err = o.NewRequest("verify", "POST")
+	req, err := o.NewRequest("verify", "POST")
 	if err != nil {
 		return Error(err)
 	}
 
-	by, err := json.Marshal(o)
+	by, err := json.Marshal(&ObjectResource{Oid: o.Oid, Size: o.Size})
 	if err != nil {
 		return Error(err)
 	}
@@ -394,17 +462,29 @@ func UploadObject(o *ObjectResource, cb CopyCallback) error {
 	req.Header.Set("Content-Type", mediaType)
 	req.Header.Set("Content-Length", strconv.Itoa(len(by)))
 	req.ContentLength = int64(len(by))
-	req.Body = ioutil.NopCloser(bytes.NewReader(by))
-	res, err = doAPIRequest(req, true)
+	req.Body = &byteCloser{bytes.NewReader(by)}
+	res, err := doAPIRequest(req, true)
 	if err != nil {
-		return err
+		if res == nil || IsRetriableError(err) {
+			return newRetriableError(err)
+		}
+
+		// Server errors are usually fatal, but the object is uploaded
+		// already and only needs verifying again.
+		verifyErr := newRetriableError(Errorf(nil, "%s", err.Error()))
+		setErrorResponseContext(verifyErr, res)
+		return verifyErr
 	}
 
 	LogTransfer("lfs.data.verify", res)
 	io.Copy(ioutil.Discard, res.Body)
 	res.Body.Close()
 
-	return err
+	if res.StatusCode > 299 {
+		return newRetriableError(Errorf(nil, "Invalid status for %s: %d", traceHttpReq(req), res.StatusCode))
+	}
+
+	return nil
 }
 
 // doLegacyApiRequest runs the request to the LFS legacy API.
@@ -426,8 +506,41 @@ func doLegacyApiRequest(req *http.Request) (*http.Response, *ObjectResource, err
 	return res, obj, nil
 }
 
+// operationHeader carries the operation an LFS API request was built for,
+// whose endpoint's access and credentials it uses. A download batch request is
+// a POST too, so the method alone can't tell them apart when the upload
+// endpoint is a different server. It's never sent, see withoutOperationHeader.
+const operationHeader = "X-Git-Lfs-Operation"
+
+// setOperationForHttpRequest records the operation of an LFS API request.
+func setOperationForHttpRequest(req *http.Request, operation string) {
+	req.Header.Set(operationHeader, operation)
+}
+
+// withoutOperationHeader returns req as it's sent, which is a copy without
+// the operationHeader if it has one.
+func withoutOperationHeader(req *http.Request) *http.Request {
+	if _, ok := req.Header[operationHeader]; !ok {
+		return req
+	}
+
+	sent := new(http.Request)
+	*sent = *req
+	sent.Header = make(http.Header, len(req.Header))
+	for key, values := range req.Header {
+		if key != operationHeader {
+			sent.Header[key] = values
+		}
+	}
+	return sent
+}
+
 // getOperationForHttpRequest determines the operation type for a http.Request
 func getOperationForHttpRequest(req *http.Request) string {
+	if operation := req.Header.Get(operationHeader); len(operation) > 0 {
+		return operation
+	}
+
 	operation := "download"
 	if req.Method == "POST" || req.Method == "PUT" {
 		operation = "upload"
@@ -439,7 +552,7 @@ func getOperationForHttpRequest(req *http.Request) string {
 // 401, the repo will be marked as having private access and the request will be
 // re-run. When the repo is marked as having private access, credentials will
 // be retrieved.
-func doApiBatchRequest(req *http.Request) (*http.Response, []*ObjectResource, error) {
+func doApiBatchRequest(req *http.Request) (*http.Response, *batchResponse, error) {
 	res, err := doAPIRequest(req, Config.PrivateAccess(getOperationForHttpRequest(req)))
 
 	if err != nil {
@@ -449,14 +562,14 @@ func doApiBatchRequest(req *http.Request) (*http.Response, []*ObjectResource, er
 		return res, nil, err
 	}
 
-	var objs map[string][]*ObjectResource
-	err = decodeApiResponse(res, &objs)
+	batch := &batchResponse{}
+	err = decodeApiResponse(res, batch)
 
 	if err != nil {
 		setErrorResponseContext(err, res)
 	}
 
-	return res, objs["objects"], err
+	return res, batch, err
 }
 
 // doStorageREquest runs the request to the storage API from a link provided by
@@ -473,10 +586,11 @@ func doStorageRequest(req *http.Request) (*http.Response, error) {
 // doAPIRequest runs the request to the LFS API, without parsing the response
 // body. If the API returns a 401, the repo will be marked as having private
 // access and the request will be re-run. When the repo is marked as having
-// private access, credentials will be retrieved.
+// private access, or the LFS url has a password in it, credentials will be
+// retrieved.
 func doAPIRequest(req *http.Request, useCreds bool) (*http.Response, error) {
 	via := make([]*http.Request, 0, 4)
-	return doApiRequestWithRedirects(req, via, useCreds)
+	return doApiRequestWithRedirects(req, via, useCreds || endpointHasPassword(req))
 }
 
 // doHttpRequest runs the given HTTP request. LFS or Storage API requests should
@@ -487,6 +601,8 @@ func doHttpRequest(req *http.Request, creds Creds) (*http.Response, error) {
 		err error
 	)
 
+	waitForRateLimit()
+
 	if Config.NtlmAccess(getOperationForHttpRequest(req)) {
 		res, err = DoNTLMRequest(req, true)
 	} else {
@@ -504,8 +620,9 @@ func doHttpRequest(req *http.Request, creds Creds) (*http.Response, error) {
 
 	if err != nil {
 		if IsAuthError(err) {
-			setAuthType(req, res)
-			doHttpRequest(req, creds)
+			if setAuthType(req, res) {
+				doHttpRequest(req, creds)
+			}
 		} else {
 			err = Error(err)
 		}
@@ -539,6 +656,10 @@ func doApiRequestWithRedirects(req *http.Request, via []*http.Request, useCreds
 		return res, err
 	}
 
+	if res.StatusCode < 300 && len(req.Header.Get("Authorization")) > 0 {
+		credentialsAccepted(Config.Endpoint(getOperationForHttpRequest(req)).Url)
+	}
+
 	if res.StatusCode == 307 {
 		redirectTo := res.Header.Get("Location")
 		locurl, err := url.Parse(redirectTo)
@@ -551,6 +672,7 @@ func doApiRequestWithRedirects(req *http.Request, via []*http.Request, useCreds
 		if err != nil {
 			return res, Errorf(err, err.Error())
 		}
+		setOperationForHttpRequest(redirectedReq, getOperationForHttpRequest(req))
 
 		via = append(via, req)
 
@@ -607,6 +729,11 @@ func handleResponse(res *http.Response, creds Creds) error {
 		return newAuthError(err)
 	}
 
+	if d, ok := retryAfter(res, time.Now()); ok {
+		throttleRequests(d)
+		return newRetryLaterError(err, d)
+	}
+
 	if res.StatusCode > 499 && res.StatusCode != 501 && res.StatusCode != 509 {
 		return newFatalError(err)
 	}
@@ -677,6 +804,7 @@ func newApiRequest(method, oid string) (*http.Request, error) {
 	if err != nil {
 		return nil, err
 	}
+	setOperationForHttpRequest(req, operation)
 
 	req.Header.Set("Accept", mediaType)
 	return req, nil
@@ -721,6 +849,7 @@ func newBatchApiRequest(operation string) (*http.Request, error) {
 	if err != nil {
 		return nil, err
 	}
+	setOperationForHttpRequest(req, operation)
 
 	req.Header.Set("Accept", mediaType)
 	if res.Header != nil {
@@ -743,10 +872,16 @@ func newBatchClientRequest(method, rawurl string) (*http.Request, error) {
 	return req, nil
 }
 
+// urlCredentialsWarning warns once that the credentials in the remote's url
+// are used, rather than for every request.
+var urlCredentialsWarning sync.Once
+
 func setRequestAuthFromUrl(req *http.Request, u *url.URL) bool {
 	if !Config.NtlmAccess(getOperationForHttpRequest(req)) && u.User != nil {
 		if pass, ok := u.User.Password(); ok {
-			fmt.Fprintln(os.Stderr, "warning: current Git remote contains credentials")
+			urlCredentialsWarning.Do(func() {
+				fmt.Fprintln(os.Stderr, "warning: current Git remote contains credentials")
+			})
 			setRequestAuth(req, u.User.Username(), pass)
 			return true
 		}
@@ -755,21 +890,119 @@ func setRequestAuthFromUrl(req *http.Request, u *url.URL) bool {
 	return false
 }
 
-func setAuthType(req *http.Request, res *http.Response) {
+// endpointHasPassword returns whether the url of the LFS endpoint req is made
+// to has a password in it, which is sent from the first request rather than
+// after the server asks for credentials.
+func endpointHasPassword(req *http.Request) bool {
+	u, err := url.Parse(Config.Endpoint(getOperationForHttpRequest(req)).Url)
+	if err != nil || u.User == nil {
+		return false
+	}
+	_, ok := u.User.Password()
+	return ok
+}
+
+// maxCredentialRetries is how many times an LFS API request is retried after
+// the server rejects the credentials sent with it. Each retry fills them again,
+// after 'git credential reject' has made the helper forget the bad ones.
+const maxCredentialRetries = 1
+
+// credentialRejection tracks the credentials for an LFS endpoint that the
+// server has rejected since it last accepted any.
+type credentialRejection struct {
+	count      int    // rejections counted
+	lastFilled string // Authorization header of the last fill
+	counted    bool   // whether a rejection of the last fill has been counted
+}
+
+var (
+	credentialRejections   = make(map[string]*credentialRejection)
+	credentialRejectionsMu sync.Mutex
+)
+
+// credentialsFilled notes that 'git credential' filled credentials for the LFS
+// endpoint url, which are sent with the given Authorization header.
+func credentialsFilled(endpointUrl, authorization string) {
+	credentialRejectionsMu.Lock()
+	defer credentialRejectionsMu.Unlock()
+
+	r := credentialRejectionFor(endpointUrl)
+	r.lastFilled = authorization
+	r.counted = false
+}
+
+// credentialsRejected notes that the server rejected the credentials sent to
+// the LFS endpoint url with the given Authorization header, and returns how
+// many rejections it has counted. Rejections of the same fill, as from
+// concurrent requests, count once; those of credentials which weren't filled,
+// like ones in the url, count every time.
+func credentialsRejected(endpointUrl, authorization string) int {
+	credentialRejectionsMu.Lock()
+	defer credentialRejectionsMu.Unlock()
+
+	r := credentialRejectionFor(endpointUrl)
+	if authorization != r.lastFilled {
+		r.count++
+	} else if !r.counted {
+		r.count++
+		r.counted = true
+	}
+	return r.count
+}
+
+func credentialRejectionFor(endpointUrl string) *credentialRejection {
+	r, ok := credentialRejections[endpointUrl]
+	if !ok {
+		r = &credentialRejection{}
+		credentialRejections[endpointUrl] = r
+	}
+	return r
+}
+
+// credentialsAccepted notes that the server accepted credentials sent to the
+// LFS endpoint url, forgetting any rejections.
+func credentialsAccepted(endpointUrl string) {
+	credentialRejectionsMu.Lock()
+	defer credentialRejectionsMu.Unlock()
+	delete(credentialRejections, endpointUrl)
+}
+
+// setAuthType records the authentication the 401 response res to req asks for,
+// and returns whether to make the request again. It isn't made again once the
+// server has rejected the credentials filled for the endpoint more than
+// maxCredentialRetries times in a row, so bad credentials aren't retried
+// forever.
+func setAuthType(req *http.Request, res *http.Response) bool {
 	authType := getAuthType(res)
 	operation := getOperationForHttpRequest(req)
+	endpoint := Config.Endpoint(operation)
+
+	if authorization := req.Header.Get("Authorization"); len(authorization) > 0 {
+		if rejections := credentialsRejected(endpoint.Url, authorization); rejections > maxCredentialRetries {
+			trace.Auth.Printf("api: credentials for %s rejected %d times, giving up", RedactURLPassword(endpoint.Url), rejections)
+			return false
+		}
+	}
+
 	Config.SetAccess(operation, authType)
-	tracerx.Printf("api: http response indicates %q authentication. Resubmitting...", authType)
+	// the response from git-lfs-authenticate may be what was rejected
+	sshAuthResponses.expire(endpoint, operation)
+	trace.Auth.Printf("api: http response indicates %q authentication. Resubmitting...", authType)
+	return true
 }
 
+// getAuthType returns "ntlm" if any of the schemes the 401 response offers is
+// NTLM, as IIS offers "Negotiate" first, or "basic" otherwise.
 func getAuthType(res *http.Response) string {
-	auth := res.Header.Get("Www-Authenticate")
-	if len(auth) < 1 {
-		auth = res.Header.Get("Lfs-Authenticate")
+	auths := res.Header["Www-Authenticate"]
+	if len(auths) < 1 {
+		auths = res.Header["Lfs-Authenticate"]
 	}
 
-	if strings.HasPrefix(strings.ToLower(auth), "ntlm") {
-		return "ntlm"
+	for _, auth := range auths {
+		if strings.HasPrefix(strings.ToLower(auth), "ntlm") {
+			return "ntlm"
+		}
 	}
 
 	return "basic"
@@ -5,12 +5,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/github/git-lfs/git"
-	"github.com/github/git-lfs/vendor/_nuts/github.com/ThomsonReutersEikon/go-ntlm/ntlm"
+	"github.com/github/git-lfs/transfer"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/bgentry/go-netrc/netrc"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
 )
@@ -41,14 +43,20 @@ type FetchPruneConfig struct {
 	PruneVerifyRemoteAlways bool
 	// Name of remote to check for unpushed and verify checks
 	PruneRemoteName string
+	// Number of days for which objects read for checkout are kept by prune,
+	// whether or not they're still referenced (default 0 = not kept)
+	PruneRecentlyUsedDays int
 }
 
 type Configuration struct {
 	CurrentRemote         string
+	NoProgress            bool // don't show the progress meter, eg for --no-progress
+	SkipSpaceCheck        bool // don't check for free disk space, eg for --skip-space-check
+	SkipPushVerify        bool // don't check the server has what's pushed, eg for --no-verify
+	RecordTransfers       bool // record each object's result, eg for --json, see TransferQueue.Results
 	httpClients           map[string]*HttpClient
 	httpClientsMutex      sync.Mutex
 	redirectingHttpClient *http.Client
-	ntlmSession           ntlm.ClientSession
 	envVars               map[string]string
 	envVarsMutex          sync.Mutex
 	isTracingHttp         bool
@@ -59,6 +67,8 @@ type Configuration struct {
 	gitConfig         map[string]string
 	origConfig        map[string]string
 	remotes           []string
+	urlInsteadOf      map[string]string // url.<base>.insteadOf values to their bases
+	urlPushInsteadOf  map[string]string // url.<base>.pushInsteadOf values to their bases
 	extensions        map[string]Extension
 	fetchIncludePaths []string
 	fetchExcludePaths []string
@@ -87,6 +97,9 @@ func (c *Configuration) Getenv(key string) string {
 	}
 
 	v := os.Getenv(key)
+	if c.envVars == nil {
+		c.envVars = make(map[string]string)
+	}
 	c.envVars[key] = v
 	return v
 }
@@ -121,20 +134,27 @@ func (c *Configuration) GetenvBool(key string, def bool) bool {
 }
 
 // GitRemoteUrl returns the git clone/push url for a given remote (blank if not found)
-// the forpush argument is to cater for separate remote.name.pushurl settings
+// the forpush argument is to cater for separate remote.name.pushurl settings,
+// and url.<base>.insteadOf and pushInsteadOf rewrites are applied like git does.
 func (c *Configuration) GitRemoteUrl(remote string, forpush bool) string {
+	c.loadGitConfig()
+
 	if forpush {
 		if u, ok := c.GitConfig("remote." + remote + ".pushurl"); ok {
-			return u
+			return git.RewriteURL(u, c.urlInsteadOf)
 		}
 	}
 
 	if u, ok := c.GitConfig("remote." + remote + ".url"); ok {
-		return u
+		if forpush {
+			if rewritten := git.RewriteURL(u, c.urlPushInsteadOf); rewritten != u {
+				return rewritten
+			}
+		}
+		return git.RewriteURL(u, c.urlInsteadOf)
 	}
 
 	return ""
-
 }
 
 // Manually set an Endpoint to use instead of deriving from Git config
@@ -143,35 +163,51 @@ func (c *Configuration) SetManualEndpoint(e Endpoint) {
 }
 
 func (c *Configuration) Endpoint(operation string) Endpoint {
+	endpoint, _ := c.EndpointSource(operation)
+	return endpoint
+}
+
+// EndpointSource is like Endpoint, but also returns where the endpoint came
+// from: the config key or environment variable with its url. For an endpoint
+// guessed from a git remote's url, that's remote.<name>.url or pushurl.
+func (c *Configuration) EndpointSource(operation string) (Endpoint, string) {
 	if c.manualEndpoint != nil {
-		return *c.manualEndpoint
+		return *c.manualEndpoint, "set manually"
 	}
 
 	if operation == "upload" {
 		if url, ok := c.GitConfig("lfs.pushurl"); ok {
-			return NewEndpointWithConfig(url, c)
+			return NewEndpointWithConfig(url, c), c.configSource("lfs.pushurl")
 		}
 	}
 
 	if url, ok := c.GitConfig("lfs.url"); ok {
-		return NewEndpointWithConfig(url, c)
+		return NewEndpointWithConfig(url, c), c.configSource("lfs.url")
 	}
 
 	if len(c.CurrentRemote) > 0 && c.CurrentRemote != defaultRemote {
-		if endpoint := c.RemoteEndpoint(c.CurrentRemote, operation); len(endpoint.Url) > 0 {
-			return endpoint
+		if endpoint, source := c.RemoteEndpointSource(c.CurrentRemote, operation); len(endpoint.Url) > 0 {
+			return endpoint, source
 		}
 	}
 
-	return c.RemoteEndpoint(defaultRemote, operation)
+	return c.RemoteEndpointSource(defaultRemote, operation)
 }
 
-func (c *Configuration) ConcurrentTransfers() int {
-	if c.NtlmAccess("download") {
-		return 1
+// configSource returns the environment variable overriding key if it's set,
+// or key.
+func (c *Configuration) configSource(key string) string {
+	if name := c.GitConfigEnvVar(key); len(name) > 0 {
+		return name
 	}
+	return key
+}
 
-	uploads := 3
+// ConcurrentTransfers returns how many objects are transferred at once, set by
+// lfs.concurrenttransfers and defaulting to 3. It's capped at 64, so a typo
+// doesn't open hundreds of connections.
+func (c *Configuration) ConcurrentTransfers() int {
+	uploads := defaultConcurrentTransfers
 
 	if v, ok := c.GitConfig("lfs.concurrenttransfers"); ok {
 		n, err := strconv.Atoi(v)
@@ -180,9 +216,162 @@ func (c *Configuration) ConcurrentTransfers() int {
 		}
 	}
 
+	if uploads > maxConcurrentTransfers {
+		return maxConcurrentTransfers
+	}
 	return uploads
 }
 
+// TransferMaxConcurrent returns how many objects smaller than
+// TransferSmallFileThreshold can be transferred at once, when there are enough
+// of them. It is set by lfs.transfer.maxconcurrent, defaulting to 8, and is
+// never less than ConcurrentTransfers or more than the same hard cap.
+func (c *Configuration) TransferMaxConcurrent() int {
+	max := defaultTransferMaxConcurrent
+	if v, ok := c.GitConfig("lfs.transfer.maxconcurrent"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			max = n
+		}
+	}
+
+	if max > maxConcurrentTransfers {
+		max = maxConcurrentTransfers
+	}
+	if n := c.ConcurrentTransfers(); max < n {
+		return n
+	}
+	return max
+}
+
+// TransferSmallFileThreshold returns the size objects must be under for more
+// than ConcurrentTransfers of them to be transferred at once. It is set by
+// lfs.transfer.smallfilethreshold, eg "512k", defaulting to 1MiB.
+func (c *Configuration) TransferSmallFileThreshold() int64 {
+	if v, ok := c.GitConfig("lfs.transfer.smallfilethreshold"); ok {
+		n, err := ParseSize(v)
+		if err == nil {
+			return n
+		}
+	}
+	return defaultTransferSmallFileThreshold
+}
+
+// TransferMaxRetries returns how many times a failed batch API request, or a
+// failed transfer of an object, is retried. It is set by lfs.transfer.maxretries,
+// defaulting to 1, and 0 turns retrying off.
+func (c *Configuration) TransferMaxRetries() int {
+	if v, ok := c.GitConfig("lfs.transfer.maxretries"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultTransferMaxRetries
+}
+
+// TransferMaxRetryAfter returns the longest a Retry-After header from the
+// server is obeyed for before retrying anyway. It is set in seconds by
+// lfs.transfer.maxretryafter, defaulting to 30.
+func (c *Configuration) TransferMaxRetryAfter() time.Duration {
+	if v, ok := c.GitConfig("lfs.transfer.maxretryafter"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 30 * time.Second
+}
+
+// TransferMaxBandwidth returns the most bytes per second to transfer in
+// direction, "upload" or "download", set by lfs.transfer.maxuploadbandwidth or
+// lfs.transfer.maxdownloadbandwidth. For "" it's the most across all transfers,
+// set by lfs.transfer.maxbandwidth. Sizes like "5m" are allowed, and 0, the
+// default, is no limit.
+func (c *Configuration) TransferMaxBandwidth(direction string) int64 {
+	key := "lfs.transfer.max" + direction + "bandwidth"
+	if v, ok := c.GitConfig(key); ok {
+		n, err := ParseSize(v)
+		if err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// MultipartPartSize returns the size of the parts objects are split into for
+// a multipart upload, if the server doesn't pick one. It is set by
+// lfs.transfer.multipart.partsize, eg "16MiB", defaulting to 64MiB. A number
+// without a unit is in megabytes, as it always has been.
+func (c *Configuration) MultipartPartSize() int64 {
+	if v, ok := c.GitConfig("lfs.transfer.multipart.partsize"); ok {
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			if n > 0 {
+				return n * 1024 * 1024
+			}
+			return defaultMultipartPartSize
+		}
+
+		n, err := ParseSize(v)
+		if err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMultipartPartSize
+}
+
+// MultipartConcurrency returns how many parts of an object are uploaded at
+// once in a multipart upload, set by lfs.transfer.multipart.concurrency.
+func (c *Configuration) MultipartConcurrency() int {
+	if v, ok := c.GitConfig("lfs.transfer.multipart.concurrency"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMultipartConcurrency
+}
+
+// WalkConcurrency returns how many directories are read at once when scanning
+// the working tree, set by lfs.walkconcurrency.
+func (c *Configuration) WalkConcurrency() int {
+	if v, ok := c.GitConfig("lfs.walkconcurrency"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultWalkConcurrency
+}
+
+// CheckoutWorkers returns how many files are written to the working tree at
+// once when checking out, set by lfs.checkoutworkers.
+func (c *Configuration) CheckoutWorkers() int {
+	if v, ok := c.GitConfig("lfs.checkoutworkers"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultCheckoutWorkers
+}
+
+// MaxPendingTransfers returns the number of transfers a queue holds before
+// they start, from lfs.maxpendingtransfers. Adding more blocks until some have
+// started, so memory use is bounded however many objects are being fetched or
+// pushed. It's never less than a batch.
+func (c *Configuration) MaxPendingTransfers() int {
+	if v, ok := c.GitConfig("lfs.maxpendingtransfers"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			if n < batchSize {
+				return batchSize
+			}
+			return n
+		}
+	}
+	return defaultMaxPendingTransfers
+}
+
 func (c *Configuration) BatchTransfer() bool {
 	value, ok := c.GitConfig("lfs.batch")
 	if !ok || len(value) == 0 {
@@ -197,6 +386,97 @@ func (c *Configuration) BatchTransfer() bool {
 	return useBatch
 }
 
+// ForceProgress returns whether the progress meter should be redrawn in place
+// even when stdout isn't a terminal (see lfs.forceprogress).
+func (c *Configuration) ForceProgress() bool {
+	if v, ok := c.GitConfig("lfs.forceprogress"); ok {
+		if b, err := parseConfigBool(v); err == nil {
+			return b
+		}
+	}
+	return false
+}
+
+// CheckSpace returns whether to check there's enough free disk space before
+// downloading or checking out objects. --skip-space-check or lfs.skipspacecheck
+// turn this off.
+func (c *Configuration) CheckSpace() bool {
+	if c.SkipSpaceCheck {
+		return false
+	}
+	if v, ok := c.GitConfig("lfs.skipspacecheck"); ok {
+		if b, err := parseConfigBool(v); err == nil {
+			return !b
+		}
+	}
+	return true
+}
+
+// VerifyPush returns whether pre-push should ask the server if it has the
+// objects in the commits being pushed that remote tracking refs say it already
+// has. --no-verify or lfs.skippushverify turn this off.
+func (c *Configuration) VerifyPush() bool {
+	if c.SkipPushVerify {
+		return false
+	}
+	if v, ok := c.GitConfig("lfs.skippushverify"); ok {
+		if b, err := parseConfigBool(v); err == nil {
+			return !b
+		}
+	}
+	return true
+}
+
+// ProgressInterval returns how often a progress line is written when stdout
+// isn't a terminal. It is set in seconds by lfs.progressinterval, defaulting
+// to 10.
+func (c *Configuration) ProgressInterval() time.Duration {
+	if v, ok := c.GitConfig("lfs.progressinterval"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 10 * time.Second
+}
+
+// TempMaxAge returns how long temp files may go unmodified before they're
+// assumed to be left over from an interrupted process and removed. It is set in
+// minutes by lfs.tmpmaxage, defaulting to an hour.
+func (c *Configuration) TempMaxAge() time.Duration {
+	if v, ok := c.GitConfig("lfs.tmpmaxage"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			return time.Duration(n) * time.Minute
+		}
+	}
+	return time.Hour
+}
+
+// CheckoutMode returns how objects are written into the working tree, from
+// lfs.checkoutmode: "copy", "hardlink", or "clone" for a copy-on-write clone.
+// Where the filesystem can't link or clone the object it's copied instead. The
+// default is "clone", or "copy" if lfs.noclone is true.
+func (c *Configuration) CheckoutMode() string {
+	if value, ok := c.GitConfig("lfs.checkoutmode"); ok {
+		switch mode := strings.ToLower(value); mode {
+		case "copy", "hardlink", "clone":
+			return mode
+		}
+		tracerx.Printf("Ignoring unknown lfs.checkoutmode %q", value)
+	}
+
+	value, ok := c.GitConfig("lfs.noclone")
+	if !ok || len(value) == 0 {
+		return "clone"
+	}
+
+	if noClone, err := parseConfigBool(value); err == nil && noClone {
+		return "copy"
+	}
+	return "clone"
+}
+
 func (c *Configuration) NtlmAccess(operation string) bool {
 	return c.Access(operation) == "ntlm"
 }
@@ -267,36 +547,175 @@ func (c *Configuration) SetEndpointAccess(e Endpoint, authType string) {
 	}
 }
 
+// EndpointLocksVerify returns whether to check the locks on the server at e
+// before pushing, from lfs.<url>.locksverify, and whether that's set at all.
+// When it isn't, locks are checked if the server supports them.
+func (c *Configuration) EndpointLocksVerify(e Endpoint) (verify bool, set bool) {
+	key := fmt.Sprintf("lfs.%s.locksverify", e.Url)
+	v, ok := c.GitConfig(key)
+	if !ok || len(v) == 0 {
+		return true, false
+	}
+
+	verify, err := parseConfigBool(v)
+	if err != nil {
+		return true, false
+	}
+	return verify, true
+}
+
+// SetEndpointLocksVerify sets lfs.<url>.locksverify in .git/config, eg to stop
+// asking a server that doesn't support locks.
+func (c *Configuration) SetEndpointLocksVerify(e Endpoint, verify bool) {
+	key := fmt.Sprintf("lfs.%s.locksverify", e.Url)
+	value := strconv.FormatBool(verify)
+	tracerx.Printf("setting %s to %s", key, value)
+	git.Config.SetLocal("", key, value)
+
+	c.loading.Lock()
+	c.gitConfig[strings.ToLower(key)] = value
+	c.loading.Unlock()
+}
+
+// EndpointBatch returns whether to use the batch API with the server at e. It
+// isn't used when lfs.batch is false, or lfs.<url>.batch is. When Git LFS set
+// that itself, on finding the server doesn't support the batch API, the batch
+// API is tried again once lfs.batchprobeinterval has passed.
+func (c *Configuration) EndpointBatch(e Endpoint) bool {
+	if !c.BatchTransfer() {
+		return false
+	}
+
+	v, ok := c.GitConfig(fmt.Sprintf("lfs.%s.batch", e.Url))
+	if !ok || len(v) == 0 {
+		return true
+	}
+	if batch, err := parseConfigBool(v); err != nil || batch {
+		return true
+	}
+
+	probed, ok := c.GitConfig(fmt.Sprintf("lfs.%s.batchprobed", e.Url))
+	if !ok {
+		return false
+	}
+	n, err := strconv.ParseInt(probed, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	interval := c.BatchProbeInterval()
+	return interval > 0 && time.Since(time.Unix(n, 0)) >= interval
+}
+
+// SetEndpointBatch records in .git/config whether the server at e supports the
+// batch API. When it doesn't, lfs.<url>.batch is set to false along with
+// lfs.<url>.batchprobed, the time it was found out, see EndpointBatch. When it
+// does, those are removed if they were set.
+func (c *Configuration) SetEndpointBatch(e Endpoint, batch bool) {
+	key := fmt.Sprintf("lfs.%s.batch", e.Url)
+	probedKey := fmt.Sprintf("lfs.%s.batchprobed", e.Url)
+
+	if batch {
+		if _, ok := c.GitConfig(probedKey); !ok {
+			return
+		}
+
+		tracerx.Printf("%s supports the batch API again, unsetting %s", e.Url, key)
+		git.Config.UnsetLocalKey("", key)
+		git.Config.UnsetLocalKey("", probedKey)
+
+		c.loading.Lock()
+		delete(c.gitConfig, strings.ToLower(key))
+		delete(c.gitConfig, strings.ToLower(probedKey))
+		c.loading.Unlock()
+		return
+	}
+
+	probed := strconv.FormatInt(time.Now().Unix(), 10)
+	tracerx.Printf("setting %s to false", key)
+	git.Config.SetLocal("", key, "false")
+	git.Config.SetLocal("", probedKey, probed)
+
+	c.loading.Lock()
+	c.gitConfig[strings.ToLower(key)] = "false"
+	c.gitConfig[strings.ToLower(probedKey)] = probed
+	c.loading.Unlock()
+}
+
+// BatchProbeInterval returns how long after finding a server doesn't support
+// the batch API it's tried again. It is set in hours by
+// lfs.batchprobeinterval, defaulting to a week. 0 means it's never tried again
+// unless lfs.<url>.batch is unset.
+func (c *Configuration) BatchProbeInterval() time.Duration {
+	if v, ok := c.GitConfig("lfs.batchprobeinterval"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n >= 0 {
+			return time.Duration(n) * time.Hour
+		}
+	}
+	return 7 * 24 * time.Hour
+}
+
+// ForceLockVerify returns whether pushes that change files locked by others
+// are refused (see lfs.forcelockverify), rather than only warned about.
+func (c *Configuration) ForceLockVerify() bool {
+	if v, ok := c.GitConfig("lfs.forcelockverify"); ok && len(v) > 0 {
+		if force, err := parseConfigBool(v); err == nil {
+			return force
+		}
+	}
+	return true
+}
+
 func (c *Configuration) FetchIncludePaths() []string {
 	c.loadGitConfig()
+	if value, ok := c.envConfig("lfs.fetchinclude"); ok {
+		return splitConfigPaths(value)
+	}
 	return c.fetchIncludePaths
 }
 func (c *Configuration) FetchExcludePaths() []string {
 	c.loadGitConfig()
+	if value, ok := c.envConfig("lfs.fetchexclude"); ok {
+		return splitConfigPaths(value)
+	}
 	return c.fetchExcludePaths
 }
 
 func (c *Configuration) RemoteEndpoint(remote, operation string) Endpoint {
+	endpoint, _ := c.RemoteEndpointSource(remote, operation)
+	return endpoint
+}
+
+// RemoteEndpointSource is like RemoteEndpoint, but also returns where the
+// endpoint came from, as EndpointSource does.
+func (c *Configuration) RemoteEndpointSource(remote, operation string) (Endpoint, string) {
 	if len(remote) == 0 {
 		remote = defaultRemote
 	}
 
 	// Support separate push URL if specified and pushing
 	if operation == "upload" {
-		if url, ok := c.GitConfig("remote." + remote + ".lfspushurl"); ok {
-			return NewEndpointWithConfig(url, c)
+		key := "remote." + remote + ".lfspushurl"
+		if url, ok := c.GitConfig(key); ok {
+			return NewEndpointWithConfig(url, c), key
 		}
 	}
-	if url, ok := c.GitConfig("remote." + remote + ".lfsurl"); ok {
-		return NewEndpointWithConfig(url, c)
+	key := "remote." + remote + ".lfsurl"
+	if url, ok := c.GitConfig(key); ok {
+		return NewEndpointWithConfig(url, c), key
 	}
 
 	// finally fall back on git remote url (also supports pushurl)
 	if url := c.GitRemoteUrl(remote, operation == "upload"); url != "" {
-		return NewEndpointFromCloneURLWithConfig(url, c)
+		key := "remote." + remote + ".url"
+		if _, ok := c.GitConfig("remote." + remote + ".pushurl"); ok && operation == "upload" {
+			key = "remote." + remote + ".pushurl"
+		}
+		return NewEndpointFromCloneURLWithConfig(url, c), key
 	}
 
-	return Endpoint{}
+	return Endpoint{}, ""
 }
 
 func (c *Configuration) Remotes() []string {
@@ -318,6 +737,43 @@ func (c *Configuration) Extensions() map[string]Extension {
 	return c.extensions
 }
 
+// CustomTransfers returns the custom transfer adapters configured with
+// lfs.customtransfer.<name>.path for the direction, "upload" or "download",
+// sorted by name. See the transfer package.
+func (c *Configuration) CustomTransfers(direction string) []*transfer.Config {
+	c.loadGitConfig()
+
+	var names []string
+	for key := range c.gitConfig {
+		parts := strings.Split(key, ".")
+		if len(parts) == 4 && parts[0] == "lfs" && parts[1] == "customtransfer" && parts[3] == "path" {
+			names = append(names, parts[2])
+		}
+	}
+	sort.Strings(names)
+
+	configs := make([]*transfer.Config, 0, len(names))
+	for _, name := range names {
+		prefix := "lfs.customtransfer." + name + "."
+		config := &transfer.Config{Name: name, Concurrent: true, Direction: "both"}
+		config.Path, _ = c.GitConfig(prefix + "path")
+		config.Args, _ = c.GitConfig(prefix + "args")
+		if v, ok := c.GitConfig(prefix + "concurrent"); ok {
+			if b, err := parseConfigBool(v); err == nil {
+				config.Concurrent = b
+			}
+		}
+		if v, ok := c.GitConfig(prefix + "direction"); ok {
+			config.Direction = strings.ToLower(v)
+		}
+
+		if config.Handles(direction) {
+			configs = append(configs, config)
+		}
+	}
+	return configs
+}
+
 // GitConfigInt parses a git config value and returns it as an integer.
 func (c *Configuration) GitConfigInt(key string, def int) int {
 	s, _ := c.GitConfig(key)
@@ -333,12 +789,72 @@ func (c *Configuration) GitConfigInt(key string, def int) int {
 	return i
 }
 
+// GitConfig returns the value of the git config key. lfs.* keys can be
+// overridden by environment variables, see ConfigEnvVar.
 func (c *Configuration) GitConfig(key string) (string, bool) {
 	c.loadGitConfig()
-	value, ok := c.gitConfig[strings.ToLower(key)]
+	key = strings.ToLower(key)
+	if value, ok := c.envConfig(key); ok {
+		return value, true
+	}
+
+	value, ok := c.gitConfig[key]
 	return value, ok
 }
 
+// GitConfigEnvVar returns the environment variable the value of the git
+// config key comes from, or "" if it's not overridden.
+func (c *Configuration) GitConfigEnvVar(key string) string {
+	if _, ok := c.envConfig(key); ok {
+		return ConfigEnvVar(key)
+	}
+	return ""
+}
+
+// ConfigEnvVar returns the name of the environment variable that overrides
+// the lfs.* config key, or "" for other keys. The "lfs." prefix is replaced
+// with "GIT_LFS_", the rest is upper cased, and anything other than a letter
+// or digit becomes an underscore: lfs.fetchinclude is GIT_LFS_FETCHINCLUDE,
+// and lfs.extension.foo.clean is GIT_LFS_EXTENSION_FOO_CLEAN.
+func ConfigEnvVar(key string) string {
+	key = strings.ToLower(key)
+	if !strings.HasPrefix(key, "lfs.") {
+		return ""
+	}
+
+	return "GIT_LFS_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key[len("lfs."):])
+}
+
+// envConfig returns the value of the environment variable overriding key, if
+// it's set and not empty.
+func (c *Configuration) envConfig(key string) (string, bool) {
+	name := ConfigEnvVar(key)
+	if len(name) == 0 {
+		return "", false
+	}
+
+	value := c.Getenv(name)
+	return value, len(value) > 0
+}
+
+// findGitConfig looks up key with git config, for the few settings that are
+// needed before Config can be loaded, applying the same environment
+// overrides as GitConfig.
+func (c *Configuration) findGitConfig(key string) string {
+	if value, ok := c.envConfig(key); ok {
+		return value
+	}
+	return git.Config.Find(key)
+}
+
 func (c *Configuration) AllGitConfig() map[string]string {
 	c.loadGitConfig()
 	return c.gitConfig
@@ -390,6 +906,12 @@ func (c *Configuration) FetchPruneConfig() *FetchPruneConfig {
 		if v, ok := c.GitConfig("lfs.pruneremotetocheck"); ok {
 			c.fetchPruneConfig.PruneRemoteName = v
 		}
+		if v, ok := c.GitConfig("lfs.prunerecentlyused"); ok {
+			n, err := strconv.Atoi(v)
+			if err == nil && n >= 0 {
+				c.fetchPruneConfig.PruneRecentlyUsedDays = n
+			}
+		}
 
 	}
 	return c.fetchPruneConfig
@@ -417,14 +939,6 @@ func (c *Configuration) loadGitConfig() bool {
 	c.extensions = make(map[string]Extension)
 	uniqRemotes := make(map[string]bool)
 
-	configFiles := []string{
-		filepath.Join(LocalWorkingDir, ".lfsconfig"),
-
-		// TODO: remove .gitconfig support for Git LFS v2.0 https://github.com/github/git-lfs/issues/839
-		filepath.Join(LocalWorkingDir, ".gitconfig"),
-	}
-	c.readGitConfigFromFiles(configFiles, 0, uniqRemotes)
-
 	listOutput, err := git.Config.List()
 	if err != nil {
 		panic(fmt.Errorf("Error listing git config: %s", err))
@@ -432,6 +946,20 @@ func (c *Configuration) loadGitConfig() bool {
 
 	c.readGitConfig(listOutput, uniqRemotes, false)
 
+	var shared string
+	if len(LocalWorkingDir) == 0 && len(LocalGitDir) > 0 {
+		shared = c.readGitConfigFromCommit()
+	} else {
+		configFiles := []string{
+			filepath.Join(LocalWorkingDir, ".lfsconfig"),
+
+			// TODO: remove .gitconfig support for Git LFS v2.0 https://github.com/github/git-lfs/issues/839
+			filepath.Join(LocalWorkingDir, ".gitconfig"),
+		}
+		shared = c.readGitConfigFromFiles(configFiles, 0)
+	}
+	c.readGitConfig(c.sharedConfigOverGlobal(shared), uniqRemotes, true)
+
 	c.remotes = make([]string, 0, len(uniqRemotes))
 	for remote, isOrigin := range uniqRemotes {
 		if isOrigin {
@@ -443,7 +971,44 @@ func (c *Configuration) loadGitConfig() bool {
 	return true
 }
 
-func (c *Configuration) readGitConfigFromFiles(filenames []string, filenameIndex int, uniqRemotes map[string]bool) {
+// sharedConfigOverGlobal returns the lines of the .lfsconfig output whose keys
+// it should set. It's shared by everyone who clones the repository, so it's
+// above the global and system git config, but below the repository's own
+// config and git -c. A key it sets replaces all of the global values, so the
+// fetch include and exclude paths from them are dropped.
+func (c *Configuration) sharedConfigOverGlobal(output string) string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		pieces := strings.SplitN(line, "=", 2)
+		if len(pieces) < 2 {
+			continue
+		}
+
+		if !sharedKeyIsSafe(pieces[0]) {
+			tracerx.Printf("Ignoring %s in .lfsconfig", pieces[0])
+			if ShowConfigWarnings {
+				fmt.Fprintf(os.Stderr, "WARNING: Ignoring %q in .lfsconfig, which can only set where objects are and which to fetch.\n", pieces[0])
+			}
+			continue
+		}
+		if git.Config.IsSetInRepo(pieces[0]) {
+			continue
+		}
+
+		switch strings.ToLower(pieces[0]) {
+		case "lfs.fetchinclude":
+			c.fetchIncludePaths = nil
+		case "lfs.fetchexclude":
+			c.fetchExcludePaths = nil
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// readGitConfigFromFiles returns the config in the first of filenames that
+// exists, as git config -l lists it.
+func (c *Configuration) readGitConfigFromFiles(filenames []string, filenameIndex int) string {
 	filename := filenames[filenameIndex]
 	_, err := os.Stat(filename)
 	if err == nil {
@@ -457,21 +1022,72 @@ func (c *Configuration) readGitConfigFromFiles(filenames []string, filenameIndex
 		if err != nil {
 			panic(fmt.Errorf("Error listing git config from %s: %s", filename, err))
 		}
-		c.readGitConfig(fileOutput, uniqRemotes, true)
-		return
+		return fileOutput
 	}
 
 	if os.IsNotExist(err) {
 		newIndex := filenameIndex + 1
 		if len(filenames) > newIndex {
-			c.readGitConfigFromFiles(filenames, newIndex, uniqRemotes)
+			return c.readGitConfigFromFiles(filenames, newIndex)
 		}
-		return
+		return ""
 	}
 
 	panic(fmt.Errorf("Error listing git config from %s: %s", filename, err))
 }
 
+// lfsConfigRevs are the commits whose .lfsconfig is read when there's no
+// working tree, eg in a bare mirror. The first that exists is used, so the
+// default branch stands in for an unborn HEAD.
+var lfsConfigRevs = []string{"HEAD", "refs/remotes/origin/HEAD", "refs/heads/master"}
+
+// readGitConfigFromCommit returns the config in .lfsconfig in the tree of the
+// current commit, for repos without a working tree to read it from.
+func (c *Configuration) readGitConfigFromCommit() string {
+	for _, rev := range lfsConfigRevs {
+		if git.ObjectType(rev) != "commit" {
+			continue
+		}
+
+		if git.ObjectType(rev+":.lfsconfig") != "blob" {
+			return ""
+		}
+
+		output, err := git.Config.ListFromBlob(rev + ":.lfsconfig")
+		if err != nil {
+			tracerx.Printf("Ignoring .lfsconfig in %s: %s", rev, err)
+			return ""
+		}
+
+		tracerx.Printf("Reading .lfsconfig from %s", rev)
+		return output
+	}
+	return ""
+}
+
+// readUrlRewrite records a url.<base>.insteadOf or pushInsteadOf key, taking
+// the base from the key as git printed it, since it's case sensitive.
+func (c *Configuration) readUrlRewrite(key, value string) {
+	dot := strings.LastIndex(key, ".")
+	if dot < len("url.") {
+		return
+	}
+	base := key[len("url."):dot]
+
+	switch strings.ToLower(key[dot+1:]) {
+	case "insteadof":
+		if c.urlInsteadOf == nil {
+			c.urlInsteadOf = make(map[string]string)
+		}
+		c.urlInsteadOf[value] = base
+	case "pushinsteadof":
+		if c.urlPushInsteadOf == nil {
+			c.urlPushInsteadOf = make(map[string]string)
+		}
+		c.urlPushInsteadOf[value] = base
+	}
+}
+
 func (c *Configuration) readGitConfig(output string, uniqRemotes map[string]bool, onlySafe bool) {
 	lines := strings.Split(output, "\n")
 	uniqKeys := make(map[string]string)
@@ -482,9 +1098,11 @@ func (c *Configuration) readGitConfig(output string, uniqRemotes map[string]bool
 			continue
 		}
 
-		allowed := !onlySafe
 		key := strings.ToLower(pieces[0])
 		value := pieces[1]
+		if onlySafe && !sharedKeyIsSafe(key) {
+			continue
+		}
 
 		if origKey, ok := uniqKeys[key]; ok {
 			if ShowConfigWarnings && c.gitConfig[key] != value && strings.HasPrefix(key, gitConfigWarningPrefix) {
@@ -502,17 +1120,10 @@ func (c *Configuration) readGitConfig(output string, uniqRemotes map[string]bool
 			ext := c.extensions[name]
 			switch keyParts[3] {
 			case "clean":
-				if onlySafe {
-					continue
-				}
 				ext.Clean = value
 			case "smudge":
-				if onlySafe {
-					continue
-				}
 				ext.Smudge = value
 			case "priority":
-				allowed = true
 				p, err := strconv.Atoi(value)
 				if err == nil && p >= 0 {
 					ext.Priority = p
@@ -522,49 +1133,61 @@ func (c *Configuration) readGitConfig(output string, uniqRemotes map[string]bool
 			ext.Name = name
 			c.extensions[name] = ext
 		} else if len(keyParts) > 1 && keyParts[0] == "remote" {
-			if onlySafe && (len(keyParts) == 3 && keyParts[2] != "lfsurl") {
-				continue
-			}
-
-			allowed = true
 			remote := keyParts[1]
 			uniqRemotes[remote] = remote == "origin"
-		} else if len(keyParts) > 2 && keyParts[len(keyParts)-1] == "access" {
-			allowed = true
-		}
-
-		if !allowed && keyIsUnsafe(key) {
-			continue
+		} else if strings.HasPrefix(key, "url.") {
+			c.readUrlRewrite(pieces[0], value)
 		}
 
 		c.gitConfig[key] = value
 
 		if len(keyParts) == 2 && keyParts[0] == "lfs" && keyParts[1] == "fetchinclude" {
-			for _, inc := range strings.Split(value, ",") {
-				inc = strings.TrimSpace(inc)
-				c.fetchIncludePaths = append(c.fetchIncludePaths, inc)
-			}
+			c.fetchIncludePaths = append(c.fetchIncludePaths, splitConfigPaths(value)...)
 		} else if len(keyParts) == 2 && keyParts[0] == "lfs" && keyParts[1] == "fetchexclude" {
-			for _, ex := range strings.Split(value, ",") {
-				ex = strings.TrimSpace(ex)
-				c.fetchExcludePaths = append(c.fetchExcludePaths, ex)
-			}
+			c.fetchExcludePaths = append(c.fetchExcludePaths, splitConfigPaths(value)...)
 		}
 	}
 }
 
-func keyIsUnsafe(key string) bool {
+// splitConfigPaths splits a comma separated list of paths, like
+// lfs.fetchinclude.
+func splitConfigPaths(value string) []string {
+	paths := strings.Split(value, ",")
+	for i, path := range paths {
+		paths[i] = strings.TrimSpace(path)
+	}
+	return paths
+}
+
+// sharedKeyIsSafe returns whether .lfsconfig can set key. It's committed to
+// the repository, so it can't set credentials, or commands to run like the
+// filters of extensions, only where objects are and which to fetch.
+func sharedKeyIsSafe(key string) bool {
+	key = strings.ToLower(key)
 	for _, safe := range safeKeys {
 		if safe == key {
-			return false
+			return true
 		}
 	}
-	return true
+
+	keyParts := strings.Split(key, ".")
+	switch {
+	case len(keyParts) == 3 && keyParts[0] == "remote":
+		return keyParts[2] == "lfsurl" || keyParts[2] == "lfspushurl"
+	case len(keyParts) == 4 && keyParts[0] == "lfs" && keyParts[1] == "extension":
+		return keyParts[3] == "priority"
+	case len(keyParts) > 2 && keyParts[0] == "lfs":
+		// lfs.<url>.access
+		return keyParts[len(keyParts)-1] == "access"
+	}
+	return false
 }
 
 var safeKeys = []string{
+	"lfs.batch",
 	"lfs.fetchexclude",
 	"lfs.fetchinclude",
 	"lfs.gitprotocol",
+	"lfs.pushurl",
 	"lfs.url",
 }
@@ -0,0 +1,73 @@
+package lfs_test // to use test.NewRepo
+
+import (
+	"os"
+	"testing"
+
+	"github.com/github/git-lfs/git"
+	"github.com/github/git-lfs/lfs"
+	"github.com/github/git-lfs/test"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+// TestSharedConfigPrecedence checks that .lfsconfig is read below the local
+// git config and the environment, and that it only sets the keys it's allowed
+// to. test/test-config.sh checks it's above the global config, which can't be
+// moved from the real one here.
+func TestSharedConfigPrecedence(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+		git.Config.ClearCache()
+	}()
+
+	for _, args := range [][]string{
+		{"lfs.url", "http://shared"},
+		{"lfs.pushurl", "http://shared-push"},
+		{"lfs.fetchinclude", "shared/*"},
+		{"lfs.batch", "false"},
+		{"lfs.concurrenttransfers", "99"},
+		{"lfs.extension.foo.clean", "shared-clean %f"},
+		{"credential.helper", "shared-helper"},
+	} {
+		test.RunGitCommand(t, true, "config", "--file", ".lfsconfig", args[0], args[1])
+	}
+
+	newConfig := func() *lfs.Configuration {
+		git.Config.ClearCache()
+		return lfs.NewConfig()
+	}
+
+	config := newConfig()
+	assert.Equal(t, "http://shared", config.Endpoint("download").Url)
+	assert.Equal(t, "http://shared-push", config.Endpoint("upload").Url)
+	assert.Equal(t, []string{"shared/*"}, config.FetchIncludePaths())
+	assert.Equal(t, false, config.BatchTransfer())
+
+	// only safe keys are set
+	assert.NotEqual(t, 99, config.ConcurrentTransfers())
+	_, ok := config.GitConfig("lfs.extension.foo.clean")
+	assert.Equal(t, false, ok)
+	assert.Equal(t, "", config.Extensions()["foo"].Clean)
+	helper, _ := config.GitConfig("credential.helper")
+	assert.NotEqual(t, "shared-helper", helper)
+
+	// the local config beats .lfsconfig
+	test.RunGitCommand(t, true, "config", "lfs.url", "http://local")
+	test.RunGitCommand(t, true, "config", "lfs.fetchinclude", "local/*")
+	config = newConfig()
+	assert.Equal(t, "http://local", config.Endpoint("download").Url)
+	assert.Equal(t, "http://shared-push", config.Endpoint("upload").Url)
+	assert.Equal(t, "local/*", config.FetchIncludePaths()[len(config.FetchIncludePaths())-1])
+	for _, path := range config.FetchIncludePaths() {
+		assert.NotEqual(t, "shared/*", path)
+	}
+
+	// and the environment beats them all
+	os.Setenv("GIT_LFS_URL", "http://env")
+	defer os.Unsetenv("GIT_LFS_URL")
+	config = newConfig()
+	assert.Equal(t, "http://env", config.Endpoint("download").Url)
+}
@@ -1,7 +1,11 @@
 package lfs
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
 )
@@ -108,6 +112,30 @@ func TestEndpointSeparateClonePushUrl(t *testing.T) {
 	assert.Equal(t, "", endpoint.SshPath)
 }
 
+func TestEndpointInsteadOfRewrites(t *testing.T) {
+	config := &Configuration{gitConfig: map[string]string{}, remotes: []string{}}
+	config.readGitConfig(strings.Join([]string{
+		"remote.origin.url=gh:Foo/bar.git",
+		"url.https://Example.com/.insteadof=gh:",
+		"url.ssh://git@Example.com/.pushinsteadof=gh:",
+		"remote.other.url=gh:Foo/bar.git",
+		"remote.other.pushurl=gh:Foo/push.git",
+	}, "\n"), map[string]bool{}, false)
+
+	endpoint := config.Endpoint("download")
+	assert.Equal(t, "https://Example.com/Foo/bar.git/info/lfs", endpoint.Url)
+
+	endpoint = config.Endpoint("upload")
+	assert.Equal(t, "https://Example.com/Foo/bar.git/info/lfs", endpoint.Url)
+	assert.Equal(t, "git@Example.com", endpoint.SshUserAndHost)
+	assert.Equal(t, "Foo/bar.git", endpoint.SshPath)
+
+	// pushInsteadOf doesn't apply to pushurls
+	endpoint = config.RemoteEndpoint("other", "upload")
+	assert.Equal(t, "https://Example.com/Foo/push.git/info/lfs", endpoint.Url)
+	assert.Equal(t, "", endpoint.SshUserAndHost)
+}
+
 func TestEndpointOverriddenSeparateClonePushLfsUrl(t *testing.T) {
 	config := &Configuration{
 		gitConfig: map[string]string{
@@ -149,6 +177,34 @@ func TestEndpointGlobalSeparateLfsPush(t *testing.T) {
 	assert.Equal(t, "", endpoint.SshPath)
 }
 
+func TestEndpointSource(t *testing.T) {
+	config := &Configuration{
+		gitConfig: map[string]string{
+			"remote.origin.url":     "https://example.com/foo/bar.git",
+			"remote.origin.pushurl": "https://readwrite.com/foo/bar.git",
+			"remote.other.url":      "https://example.com/other.git",
+			"remote.other.lfsurl":   "https://examplelfs.com/other",
+		},
+		remotes: []string{},
+	}
+
+	_, source := config.EndpointSource("download")
+	assert.Equal(t, "remote.origin.url", source)
+	_, source = config.EndpointSource("upload")
+	assert.Equal(t, "remote.origin.pushurl", source)
+
+	config.CurrentRemote = "other"
+	_, source = config.EndpointSource("upload")
+	assert.Equal(t, "remote.other.lfsurl", source)
+
+	config.gitConfig["lfs.url"] = "https://lfs.com/foo/bar"
+	_, source = config.EndpointSource("download")
+	assert.Equal(t, "lfs.url", source)
+
+	_, source = (&Configuration{gitConfig: map[string]string{}}).EndpointSource("download")
+	assert.Equal(t, "", source)
+}
+
 func TestSSHEndpointOverridden(t *testing.T) {
 	config := &Configuration{
 		gitConfig: map[string]string{
@@ -191,6 +247,19 @@ func TestSSHCustomPortEndpointAddsLfsSuffix(t *testing.T) {
 	assert.Equal(t, "9000", endpoint.SshPort)
 }
 
+func TestSSHIPv6EndpointAddsLfsSuffix(t *testing.T) {
+	config := &Configuration{
+		gitConfig: map[string]string{"remote.origin.url": "ssh://git@[2001:db8::1]:2222/foo/bar.git"},
+		remotes:   []string{},
+	}
+
+	endpoint := config.Endpoint("download")
+	assert.Equal(t, "https://[2001:db8::1]/foo/bar.git/info/lfs", endpoint.Url)
+	assert.Equal(t, "git@2001:db8::1", endpoint.SshUserAndHost)
+	assert.Equal(t, "foo/bar.git", endpoint.SshPath)
+	assert.Equal(t, "2222", endpoint.SshPort)
+}
+
 func TestBareSSHEndpointAddsLfsSuffix(t *testing.T) {
 	config := &Configuration{
 		gitConfig: map[string]string{"remote.origin.url": "git@example.com:foo/bar.git"},
@@ -204,6 +273,33 @@ func TestBareSSHEndpointAddsLfsSuffix(t *testing.T) {
 	assert.Equal(t, "", endpoint.SshPort)
 }
 
+func TestBareSSHEndpoints(t *testing.T) {
+	tests := map[string]Endpoint{
+		"git@example.com:foo/bar.git": Endpoint{
+			Url: "https://example.com/foo/bar.git", SshUserAndHost: "git@example.com", SshPath: "foo/bar.git",
+		},
+		"example.com:/srv/foo.git": Endpoint{
+			Url: "https://example.com/srv/foo.git", SshUserAndHost: "example.com", SshPath: "/srv/foo.git",
+		},
+		"git@example.com:2222:foo/bar.git": Endpoint{
+			Url: "https://example.com/foo/bar.git", SshUserAndHost: "git@example.com", SshPath: "foo/bar.git", SshPort: "2222",
+		},
+		"git@[::1]:foo/bar.git": Endpoint{
+			Url: "https://[::1]/foo/bar.git", SshUserAndHost: "git@::1", SshPath: "foo/bar.git",
+		},
+		// not SSH at all
+		"C:/foo/bar.git": Endpoint{Url: "C:/foo/bar.git"},
+		"../foo:bar.git": Endpoint{Url: "../foo:bar.git"},
+	}
+
+	for rawurl, expected := range tests {
+		endpoint := NewEndpoint(rawurl)
+		if endpoint != expected {
+			t.Errorf("%s: expected %+v, got %+v", rawurl, expected, endpoint)
+		}
+	}
+}
+
 func TestSSHEndpointFromGlobalLfsUrl(t *testing.T) {
 	config := &Configuration{
 		gitConfig: map[string]string{"lfs.url": "git@example.com:foo/bar.git"},
@@ -285,6 +381,30 @@ func TestBareGitEndpointAddsLfsSuffix(t *testing.T) {
 	assert.Equal(t, "", endpoint.SshPort)
 }
 
+func TestSharedConfigKeys(t *testing.T) {
+	for key, safe := range map[string]bool{
+		"lfs.url":                        true,
+		"lfs.pushurl":                    true,
+		"lfs.fetchinclude":               true,
+		"lfs.FetchExclude":               true,
+		"lfs.batch":                      true,
+		"remote.origin.lfsurl":           true,
+		"remote.upstream.lfspushurl":     true,
+		"lfs.http://example.com/.access": true,
+		"lfs.extension.foo.priority":     true,
+		"lfs.extension.foo.clean":        false,
+		"lfs.extension.foo.smudge":       false,
+		"lfs.concurrenttransfers":        false,
+		"remote.origin.url":              false,
+		"credential.helper":              false,
+		"http.extraheader":               false,
+		"core.hookspath":                 false,
+		"filter.lfs.clean":               false,
+	} {
+		assert.Equal(t, safe, sharedKeyIsSafe(key), key)
+	}
+}
+
 func TestConcurrentTransfersSetValue(t *testing.T) {
 	config := &Configuration{
 		gitConfig: map[string]string{
@@ -336,6 +456,131 @@ func TestConcurrentTransfersNegativeValue(t *testing.T) {
 	assert.Equal(t, 3, n)
 }
 
+func TestConcurrentTransfersCapped(t *testing.T) {
+	config := &Configuration{
+		gitConfig: map[string]string{
+			"lfs.concurrenttransfers": "300",
+		},
+	}
+
+	assert.Equal(t, 64, config.ConcurrentTransfers())
+}
+
+func TestTransferMaxConcurrent(t *testing.T) {
+	tests := []struct {
+		concurrent, maxConcurrent string
+		expected                  int
+	}{
+		{"", "", 8},
+		{"", "12", 12},
+		{"", "300", 64},
+		{"10", "", 10},
+		{"10", "4", 10},
+		{"", "0", 8},
+		{"", "elephant", 8},
+	}
+
+	for _, test := range tests {
+		gitConfig := map[string]string{}
+		if len(test.concurrent) > 0 {
+			gitConfig["lfs.concurrenttransfers"] = test.concurrent
+		}
+		if len(test.maxConcurrent) > 0 {
+			gitConfig["lfs.transfer.maxconcurrent"] = test.maxConcurrent
+		}
+
+		config := &Configuration{gitConfig: gitConfig}
+		assert.Equal(t, test.expected, config.TransferMaxConcurrent(), fmt.Sprintf("%v", test))
+	}
+}
+
+func TestTransferSmallFileThreshold(t *testing.T) {
+	config := &Configuration{}
+	assert.Equal(t, int64(1024*1024), config.TransferSmallFileThreshold())
+
+	config = &Configuration{
+		gitConfig: map[string]string{"lfs.transfer.smallfilethreshold": "256k"},
+	}
+	assert.Equal(t, int64(256000), config.TransferSmallFileThreshold())
+
+	config = &Configuration{
+		gitConfig: map[string]string{"lfs.transfer.smallfilethreshold": "lots"},
+	}
+	assert.Equal(t, int64(1024*1024), config.TransferSmallFileThreshold())
+}
+
+func TestMultipartPartSize(t *testing.T) {
+	tests := map[string]int64{
+		"":      defaultMultipartPartSize,
+		"16":    16 * 1024 * 1024,
+		"16MiB": 16 * 1024 * 1024,
+		"5m":    5000000,
+		"0":     defaultMultipartPartSize,
+		"lots":  defaultMultipartPartSize,
+	}
+
+	for value, expected := range tests {
+		config := &Configuration{gitConfig: map[string]string{}}
+		if len(value) > 0 {
+			config.gitConfig["lfs.transfer.multipart.partsize"] = value
+		}
+
+		if n := config.MultipartPartSize(); n != expected {
+			t.Errorf("lfs.transfer.multipart.partsize=%q: expected %d, got %d", value, expected, n)
+		}
+	}
+}
+
+func TestTransferMaxRetries(t *testing.T) {
+	tests := map[string]int{
+		"":         1,
+		"0":        0,
+		"5":        5,
+		"-1":       1,
+		"elephant": 1,
+	}
+
+	for value, expected := range tests {
+		config := &Configuration{gitConfig: map[string]string{}}
+		if len(value) > 0 {
+			config.gitConfig["lfs.transfer.maxretries"] = value
+		}
+
+		if n := config.TransferMaxRetries(); n != expected {
+			t.Errorf("lfs.transfer.maxretries=%q: expected %d, got %d", value, expected, n)
+		}
+	}
+}
+
+func TestCustomTransfers(t *testing.T) {
+	config := &Configuration{
+		gitConfig: map[string]string{
+			"lfs.customtransfer.nfs.path":         "/usr/bin/lfs-nfs",
+			"lfs.customtransfer.nfs.args":         "--mount /mnt/nfs",
+			"lfs.customtransfer.nfs.concurrent":   "false",
+			"lfs.customtransfer.s3.path":          "lfs-s3",
+			"lfs.customtransfer.s3.direction":     "Upload",
+			"lfs.customtransfer.noprogram.args":   "--no-path",
+			"lfs.customtransfer.unused.direction": "download",
+		},
+	}
+
+	uploads := config.CustomTransfers("upload")
+	assert.Equal(t, 2, len(uploads))
+	assert.Equal(t, "nfs", uploads[0].Name)
+	assert.Equal(t, "/usr/bin/lfs-nfs", uploads[0].Path)
+	assert.Equal(t, "--mount /mnt/nfs", uploads[0].Args)
+	assert.Equal(t, false, uploads[0].Concurrent)
+	assert.Equal(t, "both", uploads[0].Direction)
+	assert.Equal(t, "s3", uploads[1].Name)
+	assert.Equal(t, true, uploads[1].Concurrent)
+	assert.Equal(t, "upload", uploads[1].Direction)
+
+	downloads := config.CustomTransfers("download")
+	assert.Equal(t, 1, len(downloads))
+	assert.Equal(t, "nfs", downloads[0].Name)
+}
+
 func TestBatch(t *testing.T) {
 	tests := map[string]bool{
 		"":         true,
@@ -366,6 +611,105 @@ func TestBatchAbsentIsTrue(t *testing.T) {
 	assert.Equal(t, true, v)
 }
 
+func TestEndpointBatch(t *testing.T) {
+	e := Endpoint{Url: "https://example.com/repo.git/info/lfs"}
+	recently := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	longAgo := strconv.FormatInt(time.Now().Add(-8*24*time.Hour).Unix(), 10)
+
+	tests := []struct {
+		config   map[string]string
+		expected bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{"lfs.batch": "false"}, false},
+		{map[string]string{"lfs.https://example.com/repo.git/info/lfs.batch": "true"}, true},
+		// set by hand, so never probed again
+		{map[string]string{"lfs.https://example.com/repo.git/info/lfs.batch": "false"}, false},
+		{map[string]string{
+			"lfs.https://example.com/repo.git/info/lfs.batch":       "false",
+			"lfs.https://example.com/repo.git/info/lfs.batchprobed": recently,
+		}, false},
+		{map[string]string{
+			"lfs.https://example.com/repo.git/info/lfs.batch":       "false",
+			"lfs.https://example.com/repo.git/info/lfs.batchprobed": longAgo,
+		}, true},
+		{map[string]string{
+			"lfs.https://example.com/repo.git/info/lfs.batch":       "false",
+			"lfs.https://example.com/repo.git/info/lfs.batchprobed": recently,
+			"lfs.batchprobeinterval":                                "1",
+		}, true},
+		{map[string]string{
+			"lfs.https://example.com/repo.git/info/lfs.batch":       "false",
+			"lfs.https://example.com/repo.git/info/lfs.batchprobed": longAgo,
+			"lfs.batchprobeinterval":                                "0",
+		}, false},
+		// other servers are unaffected
+		{map[string]string{
+			"lfs.https://other.example.com/info/lfs.batch":       "false",
+			"lfs.https://other.example.com/info/lfs.batchprobed": recently,
+		}, true},
+	}
+
+	for i, test := range tests {
+		config := &Configuration{gitConfig: test.config}
+		if actual := config.EndpointBatch(e); actual != test.expected {
+			t.Errorf("%d: %v == %v, not %v", i, test.config, actual, test.expected)
+		}
+	}
+}
+
+func TestConfigEnvVar(t *testing.T) {
+	tests := map[string]string{
+		"lfs.concurrenttransfers":     "GIT_LFS_CONCURRENTTRANSFERS",
+		"lfs.FetchInclude":            "GIT_LFS_FETCHINCLUDE",
+		"lfs.extension.foo-bar.clean": "GIT_LFS_EXTENSION_FOO_BAR_CLEAN",
+		"lfs.https://host/x.access":   "GIT_LFS_HTTPS___HOST_X_ACCESS",
+		"remote.origin.lfsurl":        "",
+		"lfsurl":                      "",
+	}
+
+	for key, expected := range tests {
+		assert.Equal(t, expected, ConfigEnvVar(key), key)
+	}
+}
+
+func TestGitConfigEnvOverrides(t *testing.T) {
+	config := &Configuration{
+		gitConfig: map[string]string{
+			"lfs.concurrenttransfers": "5",
+			"lfs.batch":               "true",
+			"lfs.fetchinclude":        "a,b",
+			"remote.origin.lfsurl":    "abc",
+		},
+		envVars: map[string]string{
+			"GIT_LFS_CONCURRENTTRANSFERS":  "16",
+			"GIT_LFS_BATCH":                "",
+			"GIT_LFS_FETCHINCLUDE":         "assets/**, docs",
+			"GIT_LFS_URL":                  "def",
+			"GIT_LFS_REMOTE_ORIGIN_LFSURL": "ignored",
+		},
+	}
+
+	assert.Equal(t, 16, config.ConcurrentTransfers())
+	assert.Equal(t, "GIT_LFS_CONCURRENTTRANSFERS", config.GitConfigEnvVar("lfs.concurrenttransfers"))
+	assert.Equal(t, []string{"assets/**", "docs"}, config.FetchIncludePaths())
+	assert.Equal(t, "def", config.Endpoint("download").Url)
+
+	// empty variables don't override
+	value, ok := config.GitConfig("lfs.batch")
+	assert.Equal(t, "true", value)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "", config.GitConfigEnvVar("lfs.batch"))
+
+	// only lfs.* keys are overridden
+	value, _ = config.GitConfig("remote.origin.lfsurl")
+	assert.Equal(t, "abc", value)
+
+	// keys that aren't in git config at all can be set
+	config.envVars["GIT_LFS_WALKCONCURRENCY"] = "2"
+	assert.Equal(t, 2, config.WalkConcurrency())
+}
+
 func TestAccessConfig(t *testing.T) {
 	type accessTest struct {
 		Access        string
@@ -530,3 +874,70 @@ func (c *Configuration) ResetConfig() {
 	}
 	c.loading.Unlock()
 }
+
+func TestCheckoutModeNoClone(t *testing.T) {
+	tests := map[string]string{
+		"":         "clone",
+		"true":     "copy",
+		"1":        "copy",
+		"false":    "clone",
+		"0":        "clone",
+		"elephant": "clone",
+	}
+
+	for value, expected := range tests {
+		config := &Configuration{
+			gitConfig: map[string]string{"lfs.noclone": value},
+		}
+
+		if actual := config.CheckoutMode(); actual != expected {
+			t.Errorf("lfs.noclone %q == %v, not %v", value, actual, expected)
+		}
+	}
+}
+
+func TestCheckoutMode(t *testing.T) {
+	tests := map[string]string{
+		"copy":     "copy",
+		"hardlink": "hardlink",
+		"HardLink": "hardlink",
+		"clone":    "clone",
+		"elephant": "copy",
+	}
+
+	for value, expected := range tests {
+		// lfs.checkoutmode wins over lfs.noclone, unless it's invalid
+		config := &Configuration{
+			gitConfig: map[string]string{"lfs.checkoutmode": value, "lfs.noclone": "true"},
+		}
+
+		if actual := config.CheckoutMode(); actual != expected {
+			t.Errorf("lfs.checkoutmode %q == %v, not %v", value, actual, expected)
+		}
+	}
+}
+
+func TestVerifyPush(t *testing.T) {
+	assert.Equal(t, true, (&Configuration{}).VerifyPush())
+
+	tests := map[string]bool{
+		"true":     false,
+		"1":        false,
+		"false":    true,
+		"elephant": true,
+	}
+
+	for value, expected := range tests {
+		config := &Configuration{
+			gitConfig: map[string]string{"lfs.skippushverify": value},
+		}
+
+		if actual := config.VerifyPush(); actual != expected {
+			t.Errorf("lfs.skippushverify %q == %v, not %v", value, actual, expected)
+		}
+	}
+
+	config := &Configuration{gitConfig: map[string]string{"lfs.skippushverify": "false"}}
+	config.SkipPushVerify = true
+	assert.Equal(t, false, config.VerifyPush())
+}
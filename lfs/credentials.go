@@ -10,7 +10,7 @@ import (
 	"os/exec"
 	"strings"
 
-	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+	"github.com/github/git-lfs/trace"
 )
 
 // getCreds gets the credentials for the given request's URL, and sets its
@@ -53,7 +53,11 @@ func getCredsForAPI(req *http.Request) (Creds, error) {
 		return nil, nil
 	}
 
-	return fillCredentials(req, credsUrl)
+	creds, err := fillCredentials(req, credsUrl)
+	if err == nil {
+		credentialsFilled(Config.Endpoint(getOperationForHttpRequest(req)).Url, req.Header.Get("Authorization"))
+	}
+	return creds, err
 }
 
 func getCredURLForAPI(req *http.Request) (*url.URL, error) {
@@ -133,7 +137,7 @@ func fillCredentials(req *http.Request, u *url.URL) (Creds, error) {
 
 	creds, err := execCreds(input, "fill")
 	if creds == nil || len(creds) < 1 {
-		errmsg := fmt.Sprintf("Git credentials for %s not found", u)
+		errmsg := fmt.Sprintf("Git credentials for %s not found", RedactURLPassword(u.String()))
 		if err != nil {
 			errmsg = errmsg + ":\n" + err.Error()
 		} else {
@@ -146,7 +150,9 @@ func fillCredentials(req *http.Request, u *url.URL) (Creds, error) {
 		return nil, err
 	}
 
-	tracerx.Printf("Filled credentials for %s", u)
+	traced := *u
+	traced.RawQuery = ""
+	trace.Auth.Printf("Filled credentials for %s", RedactURLPassword(traced.String()))
 	setRequestAuth(req, creds["username"], creds["password"])
 
 	return creds, err
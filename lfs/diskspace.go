@@ -0,0 +1,32 @@
+package lfs
+
+import (
+	"fmt"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+)
+
+// freeSpace can be replaced in tests to simulate a full disk
+var freeSpace = diskFreeSpace
+
+// CheckFreeSpace returns an error if there are fewer than needed bytes
+// available to this user on the filesystem holding path. If the free space
+// can't be determined, it is assumed to be enough.
+func CheckFreeSpace(path string, needed int64) error {
+	if needed <= 0 || !Config.CheckSpace() {
+		return nil
+	}
+
+	available, err := freeSpace(path)
+	if err != nil {
+		tracerx.Printf("Unable to check free space at %s: %s", path, err)
+		return nil
+	}
+
+	if uint64(needed) > available {
+		return newNotEnoughSpaceError(fmt.Errorf("Not enough disk space: need %s, have %s available at %s\n"+
+			"Free some space, or use --skip-space-check or set lfs.skipspacecheck to skip this check",
+			FormatSize(needed), FormatSize(int64(available)), path))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package lfs
+
+import (
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestCheckFreeSpace(t *testing.T) {
+	defer stubFreeSpace(2048)()
+
+	assert.Equal(t, nil, CheckFreeSpace("/media", 2048))
+
+	err := CheckFreeSpace("/media", 4096)
+	assert.Equal(t, true, IsNotEnoughSpaceError(err))
+	assert.Equal(t, "Not enough disk space: need 4.00 KiB, have 2.00 KiB available at /media\n"+
+		"Free some space, or use --skip-space-check or set lfs.skipspacecheck to skip this check", err.Error())
+
+	Config.SkipSpaceCheck = true
+	defer func() { Config.SkipSpaceCheck = false }()
+	assert.Equal(t, nil, CheckFreeSpace("/media", 4096))
+}
+
+func TestCheckSpaceConfig(t *testing.T) {
+	config := &Configuration{}
+	assert.Equal(t, true, config.CheckSpace())
+
+	config = &Configuration{gitConfig: map[string]string{"lfs.skipspacecheck": "true"}}
+	assert.Equal(t, false, config.CheckSpace())
+
+	config = &Configuration{gitConfig: map[string]string{"lfs.skipspacecheck": "false"}}
+	assert.Equal(t, true, config.CheckSpace())
+	config.SkipSpaceCheck = true
+	assert.Equal(t, false, config.CheckSpace())
+}
+
+func TestTransferQueueAbortsWithoutSpace(t *testing.T) {
+	defer stubFreeSpace(15)()
+
+	q := newSpaceTestQueue()
+	assert.Equal(t, true, q.reserveSpace(10))
+	assert.Equal(t, false, q.reserveSpace(10))
+	assert.Equal(t, false, q.reserveSpace(1))
+
+	// queued downloads are dropped without trying them
+	go q.transferWorker()
+	q.wait.Add(2)
+	q.transferc <- &fakeTransfer{name: "a", err: ErrInterrupted}
+	q.transferc <- &fakeTransfer{name: "b", err: ErrInterrupted}
+	close(q.transferc)
+	q.wait.Wait()
+
+	close(q.errorc)
+	var errs []error
+	for err := range q.errorc {
+		errs = append(errs, err)
+	}
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, true, IsNotEnoughSpaceError(errs[0]))
+}
+
+func TestTransferQueueChecksSpaceForEachDownload(t *testing.T) {
+	defer stubFreeSpace(5)()
+
+	q := newSpaceTestQueue()
+	go q.transferWorker()
+	q.wait.Add(1)
+	q.transferc <- &fakeTransfer{name: "a", err: ErrInterrupted}
+	close(q.transferc)
+	q.wait.Wait()
+
+	close(q.errorc)
+	err := <-q.errorc
+	assert.Equal(t, "a", TransferFailureOf(err).Oid)
+	assert.Equal(t, true, IsNotEnoughSpaceError(TransferFailureOf(err).Err))
+}
+
+func TestTransferQueueSkipSpaceCheck(t *testing.T) {
+	defer stubFreeSpace(5)()
+	Config.SkipSpaceCheck = true
+	defer func() { Config.SkipSpaceCheck = false }()
+
+	q := newSpaceTestQueue()
+	assert.Equal(t, true, q.reserveSpace(10))
+	assert.Equal(t, true, q.reserveSpace(10))
+	assert.Equal(t, 0, len(q.errorc))
+}
+
+func newSpaceTestQueue() *TransferQueue {
+	q := &TransferQueue{
+		meter:        NewProgressMeter(2, 20, false),
+		transferKind: "download",
+		transferc:    make(chan Transferable, 2),
+		errorc:       make(chan error, 2),
+	}
+	q.meter.quiet = true
+	return q
+}
+
+// stubFreeSpace makes every filesystem report available bytes free, returning
+// a func to restore it.
+func stubFreeSpace(available uint64) func() {
+	old := freeSpace
+	freeSpace = func(string) (uint64, error) { return available, nil }
+	return func() { freeSpace = old }
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
@@ -29,25 +30,87 @@ func NewEndpoint(rawurl string) Endpoint {
 	return NewEndpointWithConfig(rawurl, NewConfig())
 }
 
-// NewEndpointFromCloneURLWithConfig creates an Endpoint from a git clone URL by appending
-// "[.git]/info/lfs".
-func NewEndpointFromCloneURLWithConfig(url string, c *Configuration) Endpoint {
-	e := NewEndpointWithConfig(url, c)
+// NewEndpointFromCloneURLWithConfig creates an Endpoint from a git clone URL by
+// appending "[.git]/info/lfs" to its path, after any trailing slashes:
+//
+//   https://git-server.com/foo/bar     => https://git-server.com/foo/bar.git/info/lfs
+//   https://git-server.com/foo/bar.git => https://git-server.com/foo/bar.git/info/lfs
+//   git@git-server.com:foo/bar.git/    => https://git-server.com/foo/bar.git/info/lfs
+//
+// A remote that's a local repository, given as a file:// URL or a path, gets a
+// file:// endpoint inside that directory, as it doesn't have a .git suffix to
+// guess. A relative path is from the root of the working directory, like git
+// takes it.
+func NewEndpointFromCloneURLWithConfig(rawurl string, c *Configuration) Endpoint {
+	if dir, ok := localRemotePath(rawurl); ok {
+		return Endpoint{Url: localEndpointUrl(dir)}
+	}
+
+	e := NewEndpointWithConfig(rawurl, c)
 	if e.Url == EndpointUrlUnknown {
 		return e
 	}
 
-	// When using main remote URL for HTTP, append info/lfs
-	if path.Ext(url) == ".git" {
-		e.Url += "/info/lfs"
-	} else {
-		e.Url += ".git/info/lfs"
+	u, err := url.Parse(e.Url)
+	if err != nil {
+		return Endpoint{Url: EndpointUrlUnknown}
 	}
+
+	repoPath := strings.TrimRight(u.Path, "/")
+	if len(repoPath) > 0 && path.Ext(repoPath) != ".git" {
+		repoPath += ".git"
+	}
+	u.Path = repoPath + "/info/lfs"
+	u.RawPath = ""
+	e.Url = u.String()
 	return e
 }
 
+// localRemotePath returns the directory of a remote that's a local
+// repository: a file:// URL, or a path that isn't a URL or scp-like SSH
+// remote.
+func localRemotePath(rawurl string) (string, bool) {
+	if strings.HasPrefix(rawurl, "file://") {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return "", false
+		}
+		return u.Path, true
+	}
+
+	if strings.Contains(rawurl, "://") || bareSshUrlRegex.MatchString(rawurl) {
+		return "", false
+	}
+
+	dir := rawurl
+	if !filepath.IsAbs(dir) {
+		base := LocalWorkingDir
+		if len(base) == 0 {
+			base = LocalGitDir
+		}
+		dir = filepath.Join(base, dir)
+	}
+	return dir, true
+}
+
+func localEndpointUrl(dir string) string {
+	slashed := strings.TrimRight(filepath.ToSlash(filepath.Clean(dir)), "/")
+	if !strings.HasPrefix(slashed, "/") {
+		// a Windows drive, as in file:///C:/repo
+		slashed = "/" + slashed
+	}
+	return (&url.URL{Scheme: "file", Path: slashed + "/info/lfs"}).String()
+}
+
 // NewEndpointWithConfig initializes a new Endpoint for a given URL.
 func NewEndpointWithConfig(rawurl string, c *Configuration) Endpoint {
+	// Bare SSH URLs aren't URLs at all as far as url.Parse is concerned
+	if !strings.Contains(rawurl, "://") {
+		if match := bareSshUrlRegex.FindStringSubmatch(rawurl); match != nil {
+			return endpointFromBareSshUrl(match[1], match[2], match[3])
+		}
+	}
+
 	u, err := url.Parse(rawurl)
 	if err != nil {
 		return Endpoint{Url: EndpointUrlUnknown}
@@ -60,62 +123,76 @@ func NewEndpointWithConfig(rawurl string, c *Configuration) Endpoint {
 		return endpointFromHttpUrl(u)
 	case "git":
 		return endpointFromGitUrl(u, c)
-	case "":
-		return endpointFromBareSshUrl(u)
 	default:
 		// Just passthrough to preserve
 		return Endpoint{Url: rawurl}
 	}
 }
 
-// endpointFromBareSshUrl constructs a new endpoint from a bare SSH URL:
+var (
+	// bareSshUrlRegex matches the scp-like syntax git accepts for SSH, as long
+	// as there's no slash before the first colon:
+	//
+	//   [user@]host:path/to/repo.git
+	//   [user@][::1]:path/to/repo.git
+	bareSshUrlRegex = regexp.MustCompile(`^(?:([^@/]+)@)?(\[[^\]/]+\]|[^:/\[\]]{2,}):(.*)$`)
+
+	// sshHostRegex splits an ssh:// URL's host from its port. IPv6 literals
+	// are in brackets.
+	sshHostRegex = regexp.MustCompile(`^(\[[^\]]+\]|[^:\[\]]+)(?::(\d+))?$`)
+)
+
+// endpointFromBareSshUrl constructs a new endpoint from the parts of a bare SSH
+// URL:
 //
 //   user@host.com:path/to/repo.git
 //
-func endpointFromBareSshUrl(u *url.URL) Endpoint {
-	parts := strings.Split(u.Path, ":")
-	partsLen := len(parts)
-	if partsLen < 2 {
-		return Endpoint{Url: u.String()}
+// A port can be given before the path, as in user@host.com:2222:path/to/repo.git
+func endpointFromBareSshUrl(user, host, path string) Endpoint {
+	if parts := strings.SplitN(path, ":", 2); len(parts) == 2 && isPort(parts[0]) {
+		host = host + ":" + parts[0]
+		path = parts[1]
 	}
 
-	// Treat presence of ':' as a bare URL
-	var newPath string
-	if len(parts) > 2 { // port included; really should only ever be 3 parts
-		newPath = fmt.Sprintf("%v:%v", parts[0], strings.Join(parts[1:], "/"))
-	} else {
-		newPath = strings.Join(parts, "/")
-	}
-	newrawurl := fmt.Sprintf("ssh://%v", newPath)
-	newu, err := url.Parse(newrawurl)
-	if err != nil {
-		return Endpoint{Url: EndpointUrlUnknown}
+	u := &url.URL{Scheme: "ssh", Host: host, Path: "/" + path}
+	if len(user) > 0 {
+		u.User = url.User(user)
 	}
+	return endpointFromSshUrl(u)
+}
 
-	return endpointFromSshUrl(newu)
+func isPort(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 // endpointFromSshUrl constructs a new endpoint from an ssh:// URL
 func endpointFromSshUrl(u *url.URL) Endpoint {
 	var endpoint Endpoint
 	// Pull out port now, we need it separately for SSH
-	regex := regexp.MustCompile(`^([^\:]+)(?:\:(\d+))?$`)
-	match := regex.FindStringSubmatch(u.Host)
-	if match == nil || len(match) < 2 {
+	match := sshHostRegex.FindStringSubmatch(u.Host)
+	if match == nil {
 		endpoint.Url = EndpointUrlUnknown
 		return endpoint
 	}
 
+	// ssh takes IPv6 literals without brackets, but URLs need them
 	host := match[1]
+	sshHost := strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
 	if u.User != nil && u.User.Username() != "" {
-		endpoint.SshUserAndHost = fmt.Sprintf("%s@%s", u.User.Username(), host)
+		endpoint.SshUserAndHost = fmt.Sprintf("%s@%s", u.User.Username(), sshHost)
 	} else {
-		endpoint.SshUserAndHost = host
+		endpoint.SshUserAndHost = sshHost
 	}
 
-	if len(match) > 2 {
-		endpoint.SshPort = match[2]
-	}
+	endpoint.SshPort = match[2]
 
 	// u.Path includes a preceding '/', strip off manually
 	// rooted paths in the URL will be '//path/to/blah'
@@ -127,15 +204,19 @@ func endpointFromSshUrl(u *url.URL) Endpoint {
 	}
 
 	// Fallback URL for using HTTPS while still using SSH for git
-	// u.Host includes host & port so can't use SSH port
-	endpoint.Url = fmt.Sprintf("https://%s%s", host, u.Path)
+	// u.Host includes host & port so can't use SSH port. A rooted path only
+	// needs its slash once in the URL.
+	endpoint.Url = fmt.Sprintf("https://%s/%s", host, strings.TrimLeft(u.Path, "/"))
 
 	return endpoint
 }
 
-// Construct a new endpoint from a HTTP URL
+// Construct a new endpoint from a HTTP URL. It's passed straight through,
+// apart from trailing slashes, so that request URLs built from it and config
+// keys like lfs.<url>.access use the same form however it was written.
 func endpointFromHttpUrl(u *url.URL) Endpoint {
-	// just pass this straight through
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
 	return Endpoint{Url: u.String()}
 }
 
@@ -0,0 +1,144 @@
+package lfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/github/git-lfs/git"
+	"github.com/github/git-lfs/localstorage"
+)
+
+// LogError writes a report on err to a new file in LocalLogDir, for `git lfs
+// logs`, and returns its path. See WriteErrorReport for the contents.
+func LogError(err error, output []byte) (string, error) {
+	return LogErrors([]error{err}, output)
+}
+
+// LogErrors is like LogError, but reports on several errors in one file, like
+// the ones a transfer queue collected.
+func LogErrors(errs []error, output []byte) (string, error) {
+	if mkdirErr := localstorage.MkdirAll(LocalLogDir); mkdirErr != nil {
+		return "", mkdirErr
+	}
+
+	name := time.Now().Format("20060102T150405.999999999")
+	full := filepath.Join(LocalLogDir, name+".log")
+	file, createErr := os.Create(full)
+	if createErr != nil {
+		return "", createErr
+	}
+	defer file.Close()
+
+	writeErrorReport(file, errs, output)
+
+	// let the other users of a shared repository read it
+	localstorage.AdjustPerms(full)
+	return full, nil
+}
+
+// WriteErrorReport writes the git-lfs & git versions, the command that was
+// run, the endpoints it used without their passwords, its output so far, the
+// error with its context & stack, and the environment to w.
+func WriteErrorReport(w io.Writer, loggedError error, output []byte) {
+	writeErrorReport(w, []error{loggedError}, output)
+}
+
+// writeErrorReport never panics, as it's used while handling other errors, so
+// whatever it wrote before a problem is kept.
+func writeErrorReport(w io.Writer, errs []error, output []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(w, "\nUnable to finish the report: %v\n", r)
+		}
+	}()
+
+	// log the version
+	gitV, err := git.Config.Version()
+	if err != nil {
+		gitV = "Error getting git version: " + err.Error()
+	}
+
+	fmt.Fprintln(w, UserAgent)
+	fmt.Fprintln(w, gitV)
+
+	// log the command that was run
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "$ %s", filepath.Base(os.Args[0]))
+	if len(os.Args) > 0 {
+		fmt.Fprintf(w, " %s", strings.Join(os.Args[1:], " "))
+	}
+	fmt.Fprintln(w)
+
+	// log the endpoints, which can have credentials in them
+	if InRepo() {
+		endpoint := Config.Endpoint("download")
+		pushEndpoint := Config.Endpoint("upload")
+		if len(endpoint.Url) > 0 {
+			fmt.Fprintf(w, "Endpoint=%s\n", RedactURLPassword(endpoint.Url))
+		}
+		if len(pushEndpoint.Url) > 0 && pushEndpoint.Url != endpoint.Url {
+			fmt.Fprintf(w, "PushEndpoint=%s\n", RedactURLPassword(pushEndpoint.Url))
+		}
+	}
+
+	// log the output, and each error message and stack trace
+	w.Write(output)
+	for _, loggedError := range errs {
+		fmt.Fprintln(w)
+		writeErrorStack(w, loggedError)
+	}
+	fmt.Fprintln(w, "\nENV:")
+
+	// log the environment
+	for _, env := range Environ() {
+		fmt.Fprintln(w, env)
+	}
+}
+
+func writeErrorStack(w io.Writer, loggedError error) {
+	fmt.Fprintln(w, loggedError.Error())
+
+	if stack := ErrorStack(loggedError); stack != nil {
+		if inner := GetInnerError(loggedError); inner != nil {
+			fmt.Fprintln(w, inner)
+		}
+		for key, value := range ErrorContext(loggedError) {
+			fmt.Fprintf(w, "%s=%v\n", key, value)
+		}
+		w.Write(stack)
+	} else {
+		w.Write(Stack())
+	}
+}
+
+// recoverAsError turns a panic in the calling goroutine into an error that's
+// passed to handle, so one bad object or work item doesn't crash the whole
+// process. The error is logged with its stack first, and its message points to
+// the log. It must be deferred directly, at the top of the goroutine:
+//
+//	defer recoverAsError("transferring "+oid, func(e error) { err = e })
+func recoverAsError(what string, handle func(error)) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	err := wrappedError{
+		Message: "internal error while " + what,
+		stack:   Stack(),
+		context: map[string]interface{}{"while": what},
+		error:   fmt.Errorf("panic: %v", r),
+	}
+
+	if logFile, logErr := LogError(err, nil); logErr == nil {
+		err.Message += ", see " + logFile
+	} else {
+		err.Message += fmt.Sprintf(": %v (unable to write log: %s)", r, logErr)
+	}
+
+	handle(err)
+}
@@ -51,6 +51,7 @@ import (
 	"errors"
 	"fmt"
 	"runtime"
+	"time"
 )
 
 // IsFatalError indicates that the error is fatal and the process should exit
@@ -189,6 +190,20 @@ func IsDownloadDeclinedError(err error) bool {
 	return false
 }
 
+// IsNotEnoughSpaceError indicates there isn't enough free disk space for the
+// objects being downloaded or checked out.
+func IsNotEnoughSpaceError(err error) bool {
+	if e, ok := err.(interface {
+		NotEnoughSpaceError() bool
+	}); ok {
+		return e.NotEnoughSpaceError()
+	}
+	if e, ok := err.(errorWrapper); ok {
+		return IsNotEnoughSpaceError(e.InnerError())
+	}
+	return false
+}
+
 // IsRetriableError indicates the low level transfer had an error but the
 // caller may retry the operation.
 func IsRetriableError(err error) bool {
@@ -560,9 +575,119 @@ func newRetriableError(err error) error {
 	return retriableError{newWrappedError(err, "")}
 }
 
+// Definitions for retryAfterOf()
+
+type retryLaterError struct {
+	errorWrapper
+	after time.Duration
+}
+
+func (e retryLaterError) InnerError() error {
+	return e.errorWrapper
+}
+
+func (e retryLaterError) RetriableError() bool {
+	return true
+}
+
+func (e retryLaterError) RetryAfter() time.Duration {
+	return e.after
+}
+
+// newRetryLaterError marks err as a rate limit from the server, which asked to
+// be sent the request again after the given delay.
+func newRetryLaterError(err error, after time.Duration) error {
+	return retryLaterError{newWrappedError(err, ""), after}
+}
+
+// retryAfterOf returns how long the server asked to wait before retrying, if
+// err is a rate limit.
+func retryAfterOf(err error) (time.Duration, bool) {
+	if e, ok := err.(interface {
+		RetryAfter() time.Duration
+	}); ok {
+		return e.RetryAfter(), true
+	}
+	if e, ok := err.(errorWrapper); ok {
+		return retryAfterOf(e.InnerError())
+	}
+	return 0, false
+}
+
+// Definitions for errors a request still failed with after being retried
+
+type retriesExhaustedError struct {
+	errorWrapper
+	retries int
+}
+
+func (e retriesExhaustedError) InnerError() error {
+	return e.errorWrapper
+}
+
+func (e retriesExhaustedError) Error() string {
+	return fmt.Sprintf("%s (gave up after %d retries)", e.errorWrapper.Error(), e.retries)
+}
+
+// RetriableError is false, since the request has been retried enough already.
+func (e retriesExhaustedError) RetriableError() bool {
+	return false
+}
+
+func newRetriesExhaustedError(err error, retries int) error {
+	return retriesExhaustedError{newWrappedError(err, ""), retries}
+}
+
 // Stack returns a byte slice containing the runtime.Stack()
 func Stack() []byte {
 	stackBuf := make([]byte, 1024*1024)
 	written := runtime.Stack(stackBuf, false)
 	return stackBuf[:written]
 }
+
+// Definitions for TransferFailureOf()
+
+type transferError struct {
+	errorWrapper
+	failure *TransferFailure
+}
+
+func (e transferError) InnerError() error {
+	return e.errorWrapper
+}
+
+func (e transferError) Error() string {
+	return e.failure.String()
+}
+
+func newTransferError(err error, failure *TransferFailure) error {
+	failure.Err = err
+	return transferError{newWrappedError(err, ""), failure}
+}
+
+// TransferFailureOf returns the details of the failed transfer err came from,
+// or nil if it didn't come from a transfer.
+func TransferFailureOf(err error) *TransferFailure {
+	if e, ok := err.(transferError); ok {
+		return e.failure
+	}
+	return nil
+}
+
+// Definitions for IsNotEnoughSpaceError()
+
+type notEnoughSpaceError struct {
+	errorWrapper
+}
+
+func (e notEnoughSpaceError) InnerError() error {
+	return e.errorWrapper
+}
+
+func (e notEnoughSpaceError) NotEnoughSpaceError() bool {
+	return true
+}
+
+func newNotEnoughSpaceError(err error) error {
+	return notEnoughSpaceError{newWrappedError(err, "")}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
@@ -11,6 +12,8 @@ import (
 	"os/exec"
 	"sort"
 	"strings"
+
+	"github.com/github/git-lfs/subprocess"
 )
 
 // An Extension describes how to manipulate files during smudge and clean.
@@ -35,9 +38,11 @@ type pipeResponse struct {
 }
 
 type pipeExtResult struct {
-	name   string
-	oidIn  string
-	oidOut string
+	name    string
+	oidIn   string
+	oidOut  string
+	sizeIn  int64
+	sizeOut int64
 }
 
 type extCommand struct {
@@ -45,17 +50,75 @@ type extCommand struct {
 	out    io.WriteCloser
 	err    *bytes.Buffer
 	hasher hash.Hash
+	size   byteCounter
 	result *pipeExtResult
 }
 
-// SortExtensions sorts a map of extensions in ascending order by Priority
+// byteCounter is an io.Writer that counts the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// An ExtensionStage describes what one extension did to the content passed
+// through it.
+type ExtensionStage struct {
+	Name     string
+	Priority int
+	OidIn    string
+	SizeIn   int64
+	OidOut   string
+	SizeOut  int64
+}
+
+// DryRunExtensions runs the content from reader through the clean commands of
+// the given extensions, in priority order, and reports what each one did.
+// Nothing is written to the local storage.
+func DryRunExtensions(m map[string]Extension, reader io.Reader, fileName string) ([]*ExtensionStage, error) {
+	extensions, err := SortExtensions(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(extensions) == 0 {
+		return nil, errors.New("No extensions are configured")
+	}
+
+	response, err := pipeExtensions(&pipeRequest{"clean", reader, fileName, extensions})
+	if response.file != nil {
+		os.Remove(response.file.Name())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stages := make([]*ExtensionStage, len(response.results))
+	for i, result := range response.results {
+		stages[i] = &ExtensionStage{
+			Name:     result.name,
+			Priority: extensions[i].Priority,
+			OidIn:    result.oidIn,
+			SizeIn:   result.sizeIn,
+			OidOut:   result.oidOut,
+			SizeOut:  result.sizeOut,
+		}
+	}
+	return stages, nil
+}
+
+// SortExtensions sorts a map of extensions in ascending order by Priority. It
+// returns an error if two extensions share a priority, since there would be
+// no telling which runs first.
 func SortExtensions(m map[string]Extension) ([]Extension, error) {
 	pMap := make(map[int]Extension)
 	priorities := make([]int, 0, len(m))
 	for n, ext := range m {
 		p := ext.Priority
-		if _, exist := pMap[p]; exist {
-			err := fmt.Errorf("duplicate priority %d on %s", p, n)
+		if other, exist := pMap[p]; exist {
+			names := []string{other.Name, n}
+			sort.Strings(names)
+			err := fmt.Errorf("duplicate priority %d on %s and %s", p, names[0], names[1])
 			return nil, err
 		}
 		pMap[p] = ext
@@ -115,7 +178,7 @@ func pipeExtensions(request *pipeRequest) (response pipeResponse, err error) {
 		ec.hasher = sha256.New()
 
 		if i == last {
-			ec.cmd.Stdout = io.MultiWriter(ec.hasher, output)
+			ec.cmd.Stdout = io.MultiWriter(ec.hasher, &ec.size, output)
 			ec.out = output
 			continue
 		}
@@ -131,7 +194,7 @@ func pipeExtensions(request *pipeRequest) (response pipeResponse, err error) {
 		}
 
 		ec.cmd.Stdin = input
-		ec.cmd.Stdout = io.MultiWriter(ec.hasher, nextStdin)
+		ec.cmd.Stdout = io.MultiWriter(ec.hasher, &ec.size, nextStdin)
 		ec.out = nextStdin
 
 		input = stdout
@@ -142,12 +205,13 @@ func pipeExtensions(request *pipeRequest) (response pipeResponse, err error) {
 	}
 
 	for _, ec := range extcmds {
-		if err = ec.cmd.Start(); err != nil {
+		if err = subprocess.Start(ec.cmd); err != nil {
 			return
 		}
 	}
 
-	if _, err = io.Copy(multiWriter, request.reader); err != nil {
+	var size int64
+	if size, err = copyWithBuffer(multiWriter, request.reader); err != nil {
 		return
 	}
 	if err = pipeWriter.Close(); err != nil {
@@ -170,8 +234,11 @@ func pipeExtensions(request *pipeRequest) (response pipeResponse, err error) {
 	oid := hex.EncodeToString(hasher.Sum(nil))
 	for _, ec := range extcmds {
 		ec.result.oidIn = oid
+		ec.result.sizeIn = size
 		oid = hex.EncodeToString(ec.hasher.Sum(nil))
+		size = int64(ec.size)
 		ec.result.oidOut = oid
+		ec.result.sizeOut = size
 		response.results = append(response.results, ec.result)
 	}
 	return
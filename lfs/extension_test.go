@@ -1,6 +1,7 @@
 package lfs
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
@@ -64,3 +65,38 @@ func TestSortExtensionsDuplicatePriority(t *testing.T) {
 	assert.NotEqual(t, err, nil)
 	assert.Equal(t, len(sorted), 0)
 }
+
+func TestSortExtensionsDuplicatePriorityError(t *testing.T) {
+	m := map[string]Extension{
+		"foo": Extension{"foo", "foo-clean %f", "foo-smudge %f", 1},
+		"bar": Extension{"bar", "bar-clean %f", "bar-smudge %f", 1},
+		"baz": Extension{"baz", "baz-clean %f", "baz-smudge %f", 0},
+	}
+
+	for i := 0; i < 10; i++ {
+		_, err := SortExtensions(m)
+		assert.Equal(t, "duplicate priority 1 on bar and foo", err.Error())
+	}
+}
+
+func TestEncodeExtensionsInPriorityOrder(t *testing.T) {
+	exts := []*PointerExtension{
+		NewPointerExtension("baz", 2, "baz_oid"),
+		NewPointerExtension("foo", 0, "foo_oid"),
+		NewPointerExtension("bar", 1, "bar_oid"),
+	}
+	encoded := NewPointer("main_oid", 12345, exts).Encoded()
+
+	assert.Equal(t, `version https://git-lfs.github.com/spec/v1
+ext-0-foo sha256:foo_oid
+ext-1-bar sha256:bar_oid
+ext-2-baz sha256:baz_oid
+oid sha256:main_oid
+size 12345
+`, encoded)
+}
+
+func TestDryRunExtensionsWithoutExtensions(t *testing.T) {
+	_, err := DryRunExtensions(map[string]Extension{}, strings.NewReader("content"), "a.dat")
+	assert.Equal(t, "No extensions are configured", err.Error())
+}
@@ -0,0 +1,170 @@
+package lfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const defaultWalkConcurrency = 8
+
+// WalkError is returned by FastWalk when one or more entries couldn't be read.
+// The walk carries on past them, so Errors holds every failure, in path order.
+type WalkError struct {
+	Errors []error
+}
+
+func (e *WalkError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e.Errors[0], len(e.Errors)-1)
+}
+
+// FastWalk walks the tree under root, reading up to concurrency directories at
+// once, and returns the path of every non-directory entry for which include
+// returns true. include may be called from several goroutines at once.
+//
+// Like filepath.Walk, symlinks are reported but never followed. Unlike it,
+// anything named .git is skipped. The results are in the same order
+// filepath.Walk would visit them, regardless of how the work was scheduled.
+func FastWalk(root string, concurrency int, include func(path string, info os.FileInfo) bool) ([]string, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	w := &fastWalker{include: include, queue: []string{root}, pending: 1}
+	w.cond = sync.NewCond(&w.mu)
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			w.work()
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	sort.Sort(walkOrder(w.results))
+	if len(w.errs) > 0 {
+		sort.Sort(w.errs)
+		errs := make([]error, len(w.errs))
+		for i, e := range w.errs {
+			errs[i] = e.err
+		}
+		return w.results, &WalkError{errs}
+	}
+	return w.results, nil
+}
+
+type fastWalker struct {
+	include func(string, os.FileInfo) bool
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []string
+	pending int // directories queued or being read
+	results []string
+	errs    walkErrors
+}
+
+func (w *fastWalker) work() {
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && w.pending > 0 {
+			w.cond.Wait()
+		}
+		if w.pending == 0 {
+			w.mu.Unlock()
+			return
+		}
+		dir := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		w.mu.Unlock()
+
+		subdirs, matches, err := w.readDir(dir)
+
+		w.mu.Lock()
+		w.queue = append(w.queue, subdirs...)
+		w.pending += len(subdirs) - 1
+		w.results = append(w.results, matches...)
+		if err != nil {
+			w.errs = append(w.errs, walkErr{dir, err})
+		}
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	}
+}
+
+func (w *fastWalker) readDir(dir string) (subdirs, matches []string, err error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	for {
+		// Readdir returns entries as they are read, even when it errors partway.
+		infos, err := f.Readdir(1000)
+		for _, info := range infos {
+			if info.Name() == ".git" {
+				continue
+			}
+
+			path := filepath.Join(dir, info.Name())
+			if info.IsDir() {
+				subdirs = append(subdirs, path)
+			} else if w.include(path, info) {
+				matches = append(matches, path)
+			}
+		}
+
+		if err == io.EOF {
+			return subdirs, matches, nil
+		} else if err != nil {
+			return subdirs, matches, err
+		}
+	}
+}
+
+type walkErr struct {
+	dir string
+	err error
+}
+
+type walkErrors []walkErr
+
+func (e walkErrors) Len() int           { return len(e) }
+func (e walkErrors) Less(i, j int) bool { return walkPathLess(e[i].dir, e[j].dir) }
+func (e walkErrors) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// walkOrder sorts paths the way filepath.Walk visits them: by name within each
+// directory, with a directory's contents straight after the directory itself.
+type walkOrder []string
+
+func (p walkOrder) Len() int           { return len(p) }
+func (p walkOrder) Less(i, j int) bool { return walkPathLess(p[i], p[j]) }
+func (p walkOrder) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// walkPathLess compares paths byte by byte, except that the separator sorts
+// before everything else, so "a/b" comes before "a-b".
+func walkPathLess(a, b string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ca, cb := a[i], b[i]
+		if ca == cb {
+			continue
+		}
+		if ca == filepath.Separator {
+			return true
+		}
+		if cb == filepath.Separator {
+			return false
+		}
+		return ca < cb
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,190 @@
+package lfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestFastWalkMatchesSerialWalk(t *testing.T) {
+	root := writeWalkTree(t, []string{
+		"a.txt",
+		"a/b.txt",
+		"a/.gitattributes",
+		"a-b/c.txt",
+		"a.b/d.txt",
+		"z/y/x/w.txt",
+		"z/y/.gitattributes",
+		"empty/",
+		".git/config",
+		".git/objects/ab/cdef",
+		"sub/.git",
+		"sub/file.txt",
+	})
+	defer os.RemoveAll(root)
+
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, nil, os.Symlink(filepath.Join(root, "z"), filepath.Join(root, "link")))
+	}
+
+	all := func(string, os.FileInfo) bool { return true }
+	expected := serialWalk(t, root, all)
+
+	for _, n := range []int{1, 2, 8, 64} {
+		actual, err := FastWalk(root, n, all)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, expected, actual)
+	}
+
+	attrs, err := FastWalk(root, 4, func(path string, info os.FileInfo) bool {
+		return info.Name() == ".gitattributes"
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{
+		filepath.Join(root, "a", ".gitattributes"),
+		filepath.Join(root, "z", "y", ".gitattributes"),
+	}, attrs)
+}
+
+func TestFastWalkDoesNotFollowSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+
+	root := writeWalkTree(t, []string{"dir/file.txt"})
+	defer os.RemoveAll(root)
+	// A loop would never finish if it were followed.
+	assert.Equal(t, nil, os.Symlink(root, filepath.Join(root, "dir", "loop")))
+
+	paths, err := FastWalk(root, 4, func(string, os.FileInfo) bool { return true })
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{
+		filepath.Join(root, "dir", "file.txt"),
+		filepath.Join(root, "dir", "loop"),
+	}, paths)
+}
+
+func TestFastWalkCollectsErrors(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Getuid() == 0 {
+		t.Skip("can't make directories unreadable")
+	}
+
+	root := writeWalkTree(t, []string{"a/locked/x.txt", "b/ok.txt", "c/locked/y.txt"})
+	defer os.RemoveAll(root)
+	for _, dir := range []string{"a/locked", "c/locked"} {
+		path := filepath.Join(root, dir)
+		assert.Equal(t, nil, os.Chmod(path, 0))
+		defer os.Chmod(path, 0755)
+	}
+
+	paths, err := FastWalk(root, 4, func(string, os.FileInfo) bool { return true })
+	assert.Equal(t, []string{filepath.Join(root, "b", "ok.txt")}, paths)
+
+	walkErr, ok := err.(*WalkError)
+	if !ok {
+		t.Fatalf("expected a *WalkError, got %v", err)
+	}
+	assert.Equal(t, 2, len(walkErr.Errors))
+	assert.Equal(t, filepath.Join(root, "a", "locked"), walkErr.Errors[0].(*os.PathError).Path)
+	assert.Equal(t, filepath.Join(root, "c", "locked"), walkErr.Errors[1].(*os.PathError).Path)
+}
+
+func TestFastWalkMissingRoot(t *testing.T) {
+	paths, err := FastWalk(filepath.Join(os.TempDir(), "git-lfs-no-such-dir"), 4, func(string, os.FileInfo) bool { return true })
+	assert.Equal(t, 0, len(paths))
+	assert.NotEqual(t, nil, err)
+}
+
+func BenchmarkFastWalk(b *testing.B) {
+	root := writeBenchTree(b)
+	defer os.RemoveAll(root)
+	all := func(string, os.FileInfo) bool { return true }
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FastWalk(root, defaultWalkConcurrency, all)
+	}
+}
+
+func BenchmarkSerialWalk(b *testing.B) {
+	root := writeBenchTree(b)
+	defer os.RemoveAll(root)
+	all := func(string, os.FileInfo) bool { return true }
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		serialWalk(b, root, all)
+	}
+}
+
+// serialWalk is the filepath.Walk equivalent of FastWalk.
+func serialWalk(tb testing.TB, root string, include func(string, os.FileInfo) bool) []string {
+	var paths []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == ".git" {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && include(path, info) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return paths
+}
+
+// writeWalkTree creates a temp dir holding the given files, or directories
+// where the name ends in a slash.
+func writeWalkTree(tb testing.TB, files []string) string {
+	root, err := ioutil.TempDir("", "git-lfs-walk")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	for _, file := range files {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if file[len(file)-1] == '/' {
+			err = os.MkdirAll(path, 0755)
+		} else if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = ioutil.WriteFile(path, []byte(file), 0644)
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return root
+}
+
+// writeBenchTree creates 100k empty files spread over 1000 directories.
+func writeBenchTree(tb testing.TB) string {
+	root, err := ioutil.TempDir("", "git-lfs-walk-bench")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	for d := 0; d < 1000; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("%02d", d/100), fmt.Sprintf("%03d", d))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		for f := 0; f < 100; f++ {
+			if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%03d.dat", f)), nil, 0644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	return root
+}
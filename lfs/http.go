@@ -26,14 +26,14 @@ type transferStats struct {
 	Stop       time.Time
 }
 
-type transfer struct {
+type httpTransfer struct {
 	requestStats  *transferStats
 	responseStats *transferStats
 }
 
 var (
 	// TODO should use some locks
-	transfers           = make(map[*http.Response]*transfer)
+	transfers           = make(map[*http.Response]*httpTransfer)
 	transferBuckets     = make(map[string][]*http.Response)
 	transfersLock       sync.Mutex
 	transferBucketsLock sync.Mutex
@@ -49,11 +49,18 @@ func LogTransfer(key string, res *http.Response) {
 
 type HttpClient struct {
 	*http.Client
+	// certErr is why the client certificate for the host couldn't be loaded,
+	// which fails every request rather than sending them without it.
+	certErr error
 }
 
 func (c *HttpClient) Do(req *http.Request) (*http.Response, error) {
 	traceHttpRequest(req)
 
+	if c.certErr != nil {
+		return nil, c.certErr
+	}
+
 	crc := countingRequest(req)
 	if req.Body != nil {
 		// Only set the body if we have a body, but create the countingRequest
@@ -89,7 +96,7 @@ func (c *HttpClient) Do(req *http.Request) (*http.Response, error) {
 		// Response body size cannot be figured until it is read. Do not rely on a Content-Length
 		// header because it may not exist or be -1 in the case of chunked responses.
 		resstats := &transferStats{HeaderSize: resHeaderSize, Start: start}
-		t := &transfer{requestStats: reqstats, responseStats: resstats}
+		t := &httpTransfer{requestStats: reqstats, responseStats: resstats}
 		transfersLock.Lock()
 		transfers[res] = t
 		transfersLock.Unlock()
@@ -110,19 +117,47 @@ func (c *Configuration) HttpClient(host string) *HttpClient {
 		return client
 	}
 
+	tr, err := c.newHttpTransport(host)
+	client := &HttpClient{
+		Client:  &http.Client{Transport: tr, CheckRedirect: checkRedirect},
+		certErr: err,
+	}
+	c.httpClients[host] = client
+
+	return client
+}
+
+// ntlmHttpClient returns a client for a single NTLM handshake with host, which
+// makes its requests over one connection of its own. closeConn closes it.
+func (c *Configuration) ntlmHttpClient(host string) (client *HttpClient, closeConn func()) {
+	tr, err := c.newHttpTransport(host)
+	tr.MaxIdleConnsPerHost = 1
+
+	client = &HttpClient{
+		Client:  &http.Client{Transport: tr, CheckRedirect: checkRedirect},
+		certErr: err,
+	}
+	return client, tr.CloseIdleConnections
+}
+
+// newHttpTransport returns a transport for requests to host. The error is from
+// loading the client certificate for host, if there is one, in which case the
+// transport is returned without it.
+func (c *Configuration) newHttpTransport(host string) (*httpTransport, error) {
 	dialtime := c.GitConfigInt("lfs.dialtimeout", 30)
 	keepalivetime := c.GitConfigInt("lfs.keepalive", 1800) // 30 minutes
 	tlstime := c.GitConfigInt("lfs.tlstimeout", 30)
 
-	tr := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
+	base := &http.Transport{
+		Proxy: proxyFromGitConfigOrEnvironment,
 		Dial: (&net.Dialer{
 			Timeout:   time.Duration(dialtime) * time.Second,
 			KeepAlive: time.Duration(keepalivetime) * time.Second,
 		}).Dial,
 		TLSHandshakeTimeout: time.Duration(tlstime) * time.Second,
-		MaxIdleConnsPerHost: c.ConcurrentTransfers(),
+		MaxIdleConnsPerHost: c.TransferMaxConcurrent(),
 	}
+	tr := newHttpTransportWith(base)
 
 	tr.TLSClientConfig = &tls.Config{}
 	if isCertVerificationDisabledForHost(host) {
@@ -131,12 +166,16 @@ func (c *Configuration) HttpClient(host string) *HttpClient {
 		tr.TLSClientConfig.RootCAs = getRootCAsForHost(host)
 	}
 
-	client := &HttpClient{
-		&http.Client{Transport: tr, CheckRedirect: checkRedirect},
+	if certFile, keyFile := clientCertFilesForHost(host); len(certFile) > 0 {
+		cert, err := loadClientCert(certFile, keyFile)
+		if err != nil {
+			return tr, err
+		}
+		tracerx.Printf("Using client certificate %q", certFile)
+		tr.TLSClientConfig.Certificates = []tls.Certificate{*cert}
 	}
-	c.httpClients[host] = client
 
-	return client
+	return tr, nil
 }
 
 func checkRedirect(req *http.Request, via []*http.Request) error {
@@ -170,7 +209,7 @@ func traceHttpRequest(req *http.Request) {
 		return
 	}
 
-	dump, err := httputil.DumpRequest(req, false)
+	dump, err := httputil.DumpRequest(withoutOperationHeader(req), false)
 	if err != nil {
 		return
 	}
@@ -208,8 +247,10 @@ func traceHttpDump(direction string, dump []byte) {
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		if !Config.isDebuggingHttp && strings.HasPrefix(strings.ToLower(line), "authorization: basic") {
-			fmt.Fprintf(os.Stderr, "%s Authorization: Basic * * * * *\n", direction)
+		if !Config.isDebuggingHttp && strings.HasPrefix(strings.ToLower(line), "authorization: ") {
+			// keep the scheme, eg "Basic" or "NTLM", but not the credentials
+			scheme := strings.SplitN(line[len("authorization: "):], " ", 2)[0]
+			fmt.Fprintf(os.Stderr, "%s Authorization: %s * * * * *\n", direction, scheme)
 		} else {
 			fmt.Fprintf(os.Stderr, "%s %s\n", direction, line)
 		}
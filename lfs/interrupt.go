@@ -0,0 +1,111 @@
+package lfs
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/github/git-lfs/subprocess"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+)
+
+// ErrInterrupted is returned by transfers aborted because git-lfs was
+// interrupted.
+var ErrInterrupted = errors.New("interrupted")
+
+var (
+	interruptOnce sync.Once
+	interruptc    = make(chan struct{})
+
+	runMutex     sync.Mutex
+	runQueues    []*TransferQueue
+	runTempFiles = NewStringSet()
+)
+
+// Interrupt tells running transfer queues to stop: queued transfers are
+// dropped, and in flight ones fail with ErrInterrupted at their next read or
+// write. It's called from the signal handler, see AbortRun for the rest.
+func Interrupt() {
+	interruptOnce.Do(func() {
+		tracerx.Printf("interrupted, aborting transfers")
+		close(interruptc)
+	})
+}
+
+// Interrupted returns whether Interrupt has been called.
+func Interrupted() bool {
+	select {
+	case <-interruptc:
+		return true
+	default:
+		return false
+	}
+}
+
+// AbortRun cleans up after Interrupt, before the process exits. It waits up to
+// timeout for in flight transfers to give up, kills child processes, and
+// removes temp files created by this process. Returns the number of objects
+// transferred and the total the transfer queues were given, for reporting.
+func AbortRun(timeout time.Duration) (transferred, total int) {
+	deadline := time.Now().Add(timeout)
+	for inFlightTransfers() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	subprocess.KillAll()
+	removeRunTempFiles()
+
+	runMutex.Lock()
+	defer runMutex.Unlock()
+	for _, q := range runQueues {
+		transferred += int(atomic.LoadInt64(&q.meter.finishedFiles))
+		total += int(atomic.LoadInt64(&q.meter.estimatedFiles))
+	}
+	return transferred, total
+}
+
+func trackTransferQueue(q *TransferQueue) {
+	runMutex.Lock()
+	runQueues = append(runQueues, q)
+	runMutex.Unlock()
+}
+
+func inFlightTransfers() int32 {
+	runMutex.Lock()
+	defer runMutex.Unlock()
+
+	var n int32
+	for _, q := range runQueues {
+		n += atomic.LoadInt32(&q.inFlight)
+	}
+	return n
+}
+
+// trackTempFile remembers a temp file created by this process, so it can be
+// removed if the process is interrupted. Call untrackTempFile once it's been
+// moved into place or removed.
+func trackTempFile(path string) {
+	runMutex.Lock()
+	runTempFiles.Add(path)
+	runMutex.Unlock()
+}
+
+func untrackTempFile(path string) {
+	runMutex.Lock()
+	runTempFiles.Remove(path)
+	runMutex.Unlock()
+}
+
+func removeRunTempFiles() {
+	runMutex.Lock()
+	defer runMutex.Unlock()
+
+	for path := range runTempFiles {
+		if err := os.Remove(path); err == nil {
+			tracerx.Printf("Removed interrupted temp file %s", path)
+		}
+	}
+	runTempFiles = NewStringSet()
+}
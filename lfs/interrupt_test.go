@@ -0,0 +1,125 @@
+package lfs
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestInterruptAbortsTransfers(t *testing.T) {
+	defer resetInterrupt()
+
+	oldTempDir := TempDir
+	dir, err := ioutil.TempDir("", "lfs-interrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	TempDir = dir
+	defer func() {
+		TempDir = oldTempDir
+		checkedTempDir = ""
+		os.RemoveAll(dir)
+	}()
+
+	q := &TransferQueue{
+		meter:        NewProgressMeter(3, 30, false),
+		transferKind: "download",
+		transferc:    make(chan Transferable, 3),
+		errorc:       make(chan error, 3),
+		retriesc:     make(chan Transferable, 3),
+	}
+	q.meter.quiet = true
+	trackTransferQueue(q)
+	go q.transferWorker()
+
+	started := make(chan string)
+	q.wait.Add(3)
+	q.transferc <- &fakeTransfer{name: "done"}
+	q.transferc <- &fakeTransfer{name: "blocked", started: started}
+
+	tempPath := <-started
+	_, err = os.Stat(tempPath)
+	assert.Equal(t, nil, err)
+
+	Interrupt()
+	q.transferc <- &fakeTransfer{name: "queued"}
+	close(q.transferc)
+
+	transferred, total := AbortRun(time.Second)
+	assert.Equal(t, 1, transferred)
+	assert.Equal(t, 3, total)
+
+	q.wait.Wait()
+	close(q.errorc)
+	var errs []error
+	for err := range q.errorc {
+		errs = append(errs, err)
+	}
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, ErrInterrupted, TransferFailureOf(errs[0]).Err)
+
+	_, err = os.Stat(tempPath)
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+func resetInterrupt() {
+	interruptOnce = sync.Once{}
+	interruptc = make(chan struct{})
+	runQueues = nil
+	runTempFiles = NewStringSet()
+}
+
+// fakeTransfer writes to a temp file, calling the callback until it returns an
+// error if started is set, or once otherwise. It panics instead if panics is
+// set, or fails with err if that's set.
+type fakeTransfer struct {
+	name    string
+	path    string
+	started chan string
+	panics  bool
+	err     error
+	object  *ObjectResource
+}
+
+func (f *fakeTransfer) Transfer(cb CopyCallback) error {
+	if f.panics {
+		panic("fake transfer failure")
+	}
+	if f.err != nil {
+		return f.err
+	}
+
+	tmp, err := TempFile(f.name)
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+
+	if f.started == nil {
+		return cb(10, 10, 10)
+	}
+
+	f.started <- tmp.Name()
+	for {
+		if err := cb(10, 1, 1); err != nil {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (f *fakeTransfer) Check() (*ObjectResource, error) { return nil, nil }
+func (f *fakeTransfer) Object() *ObjectResource         { return f.object }
+func (f *fakeTransfer) Oid() string                     { return f.name }
+func (f *fakeTransfer) Size() int64                     { return 10 }
+func (f *fakeTransfer) Name() string {
+	if len(f.path) > 0 {
+		return f.path
+	}
+	return f.name
+}
+func (f *fakeTransfer) SetObject(*ObjectResource) {}
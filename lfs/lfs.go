@@ -6,8 +6,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/github/git-lfs/git"
 	"github.com/github/git-lfs/localstorage"
@@ -15,10 +18,7 @@ import (
 )
 
 const (
-	Version            = "1.1.2"
-	tempDirPerms       = 0755
-	localMediaDirPerms = 0755
-	localLogDirPerms   = 0755
+	Version = "1.1.2"
 )
 
 var (
@@ -29,35 +29,86 @@ var (
 	LocalWorkingDir    string
 	LocalGitDir        string // parent of index / config / hooks etc
 	LocalGitStorageDir string // parent of objects/lfs (may be same as LocalGitDir but may not)
+	LocalStorageDir    string // root of lfs storage, normally <LocalGitStorageDir>/lfs (see lfs.storage)
+	LocalStorageShared bool   // whether LocalStorageDir may be shared by other repos
 	LocalMediaDir      string // root of lfs objects
 	LocalObjectTempDir string // where temporarily downloading objects are stored
 	objects            *localstorage.LocalStorage
+	migrateObjectsOnce sync.Once
+	recordAccessOnce   sync.Once
+	recordAccess       bool
 	LocalLogDir        string
 	checkedTempDir     string
 )
 
 func TempFile(prefix string) (*os.File, error) {
 	if checkedTempDir != TempDir {
-		if err := os.MkdirAll(TempDir, tempDirPerms); err != nil {
+		if err := localstorage.MkdirAll(TempDir); err != nil {
 			return nil, err
 		}
 		checkedTempDir = TempDir
 	}
 
-	return ioutil.TempFile(TempDir, prefix)
+	f, err := ioutil.TempFile(localstorage.LongPath(TempDir), prefix)
+	if err == nil {
+		trackTempFile(f.Name())
+	}
+	return f, err
 }
 
 func ResetTempDir() error {
 	checkedTempDir = ""
-	return os.RemoveAll(TempDir)
+	return os.RemoveAll(localstorage.LongPath(TempDir))
 }
 
 func LocalMediaPath(oid string) (string, error) {
+	migrateLegacyObjects()
 	return objects.BuildObjectPath(oid)
 }
 
+// LocalMediaPathReadOnly returns the path an object is stored at without
+// creating any directories, for callers which only read or check existence.
+func LocalMediaPathReadOnly(oid string) string {
+	return objects.ObjectPath(oid)
+}
+
+// IngestObject moves a fully written temp file into local storage as the
+// object oid, tolerating the object already being present. The file is checked
+// to be size bytes long and, unless the caller already hashed what it wrote, to
+// hash to oid. See localstorage.IngestObject.
+func IngestObject(tempPath, oid string, size int64, hashed bool) error {
+	migrateLegacyObjects()
+	err := objects.IngestObject(tempPath, oid, size, hashed)
+	if err == nil {
+		untrackTempFile(tempPath)
+	}
+	return err
+}
+
+// migrateLegacyObjects moves any objects left in the legacy flat layout to the
+// sharded layout, the first time an object is written. Reads don't need it, so
+// commands that never write to local storage don't pay for the scan.
+func migrateLegacyObjects() {
+	migrateObjectsOnce.Do(func() {
+		if n, err := objects.MigrateLegacyObjects(); err != nil {
+			tracerx.Printf("Unable to migrate legacy objects in %s: %s", objects.RootDir, err)
+		} else if n > 0 {
+			tracerx.Printf("Migrated %d legacy objects in %s", n, objects.RootDir)
+		}
+	})
+}
+
+// SharedStorageRepos returns the git dirs of all repos known to be using the
+// local storage, when it is shared.
+func SharedStorageRepos() ([]string, error) {
+	if !LocalStorageShared {
+		return []string{LocalGitStorageDir}, nil
+	}
+	return localstorage.SharedRepos(LocalStorageDir)
+}
+
 func ObjectExistsOfSize(oid string, size int64) bool {
-	path := objects.ObjectPath(oid)
+	path := LocalMediaPathReadOnly(oid)
 	return FileExistsOfSize(path, size)
 }
 
@@ -69,21 +120,67 @@ func Environ() []string {
 		fmt.Sprintf("LocalGitDir=%s", LocalGitDir),
 		fmt.Sprintf("LocalGitStorageDir=%s", LocalGitStorageDir),
 		fmt.Sprintf("LocalMediaDir=%s", LocalMediaDir),
+	)
+	shared := ""
+	if LocalStorageShared {
+		shared = " (shared)"
+	}
+	env = append(env,
+		fmt.Sprintf("LocalStorageDir=%s%s", LocalStorageDir, shared),
 		fmt.Sprintf("TempDir=%s", TempDir),
-		fmt.Sprintf("ConcurrentTransfers=%d", Config.ConcurrentTransfers()),
-		fmt.Sprintf("BatchTransfer=%v", Config.BatchTransfer()),
+		fmt.Sprintf("ConcurrentTransfers=%d%s", Config.ConcurrentTransfers(), envSource("lfs.concurrenttransfers")),
+		fmt.Sprintf("BatchTransfer=%v%s", Config.BatchTransfer(), envSource("lfs.batch")),
 	)
 
 	for _, e := range osEnviron {
-		if !strings.Contains(e, "GIT_") {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) < 2 || !strings.HasPrefix(parts[0], "GIT_") && !proxyEnvVars[parts[0]] {
 			continue
 		}
-		env = append(env, e)
+		env = append(env, parts[0]+"="+redactEnvValue(parts[0], parts[1]))
 	}
 
 	return env
 }
 
+// proxyEnvVars are the environment variables that pick the proxy for http
+// requests, which Environ shows along with the GIT_ ones.
+var proxyEnvVars = map[string]bool{
+	"HTTPS_PROXY": true, "https_proxy": true,
+	"HTTP_PROXY": true, "http_proxy": true,
+	"ALL_PROXY": true, "all_proxy": true,
+	"NO_PROXY": true, "no_proxy": true,
+}
+
+var urlPasswordRE = regexp.MustCompile(`(^|://)([^:/@\s]*):[^@/\s]*@`)
+
+// redactEnvValue hides the credentials in an environment variable's value, so
+// that it can be shown in bug reports: the password in a url, like a proxy's,
+// or the whole value of a variable named for a password, token or secret.
+func redactEnvValue(name, value string) string {
+	upper := strings.ToUpper(name)
+	for _, secret := range []string{"PASSWORD", "TOKEN", "SECRET"} {
+		if strings.Contains(upper, secret) {
+			return "xxxxx"
+		}
+	}
+	return RedactURLPassword(value)
+}
+
+// RedactURLPassword hides the passwords of the urls in s.
+func RedactURLPassword(s string) string {
+	return urlPasswordRE.ReplaceAllString(s, "${1}${2}:xxxxx@")
+}
+
+// envSource notes the environment variable overriding the config key, for
+// showing alongside its value.
+func envSource(key string) string {
+	if name := Config.GitConfigEnvVar(key); len(name) > 0 {
+		return fmt.Sprintf(" (from %s)", name)
+	}
+	return ""
+}
+
 func InRepo() bool {
 	return LocalGitDir != ""
 }
@@ -98,22 +195,45 @@ func ResolveDirs() {
 		LocalWorkingDir = ResolveSymlinks(LocalWorkingDir)
 
 		LocalGitStorageDir = resolveGitStorageDir(LocalGitDir)
-		TempDir = filepath.Join(LocalGitDir, "lfs", "tmp") // temp files per worktree
+
+		sharedMode, err := localstorage.ParseSharedRepository(git.Config.Find("core.sharedRepository"))
+		if err != nil {
+			tracerx.Printf("Ignoring %s", err)
+		}
+		localstorage.SetSharedMode(sharedMode)
+
+		storageConfig := Config.findGitConfig("lfs.storage")
+		LocalStorageDir, TempDir = resolveStorageDirs(storageConfig, LocalGitDir, LocalGitStorageDir)
 
 		objs, err := localstorage.New(
-			filepath.Join(LocalGitStorageDir, "lfs", "objects"),
+			filepath.Join(LocalStorageDir, "objects"),
 			filepath.Join(TempDir, "objects"),
 		)
 
+		if err == nil && len(storageConfig) > 0 {
+			err = checkWritableDir(objs.RootDir)
+		}
+
 		if err != nil {
+			if len(storageConfig) > 0 {
+				panic(fmt.Sprintf("Error trying to use lfs.storage=%q (%s): %s", storageConfig, LocalStorageDir, err))
+			}
 			panic(fmt.Sprintf("Error trying to init LocalStorage: %s", err))
 		}
 
+		LocalStorageShared = isSharedStorage(storageConfig)
+		if LocalStorageShared {
+			if err := localstorage.RegisterSharedRepo(LocalStorageDir, LocalGitStorageDir); err != nil {
+				tracerx.Printf("Unable to register with shared storage %s: %s", LocalStorageDir, err)
+			}
+		}
+
 		objects = objs
+		migrateObjectsOnce = sync.Once{}
 		LocalMediaDir = objs.RootDir
 		LocalObjectTempDir = objs.TempDir
-		LocalLogDir = filepath.Join(objs.RootDir, "logs")
-		if err := os.MkdirAll(LocalLogDir, localLogDirPerms); err != nil {
+		LocalLogDir = filepath.Join(LocalStorageDir, "logs")
+		if err := localstorage.MkdirAll(LocalLogDir); err != nil {
 			panic(fmt.Errorf("Error trying to create log directory in '%s': %s", LocalLogDir, err))
 		}
 	} else {
@@ -125,11 +245,78 @@ func ResolveDirs() {
 	}
 }
 
-func ClearTempObjects() error {
+// ClearTempObjects removes temp files left behind by interrupted transfers or
+// cleans which are older than lfs.tmpmaxage. Returns the paths removed.
+func ClearTempObjects() ([]string, error) {
 	if objects == nil {
-		return nil
+		return nil, nil
+	}
+
+	maxAge := Config.TempMaxAge()
+	removed, err := objects.ClearTempObjects(maxAge)
+	if err != nil {
+		return removed, err
+	}
+
+	files, err := localstorage.ClearTempFiles(TempDir, maxAge)
+	return append(removed, files...), err
+}
+
+// QuarantineObject moves the local object oid out of the way to the "bad" dir
+// in LocalStorageDir, rather than deleting it, when it looks damaged but may be
+// worth inspecting. The objects dir may be a separate mount, in which case the
+// object is copied. Returns the new path.
+func QuarantineObject(oid string) (string, error) {
+	badDir := filepath.Join(LocalStorageDir, "bad")
+	if err := localstorage.MkdirAll(badDir); err != nil {
+		return "", err
+	}
+
+	badFile := filepath.Join(badDir, oid)
+	if err := localstorage.RenameFile(LocalMediaPathReadOnly(oid), badFile); err != nil {
+		return "", err
+	}
+	return badFile, nil
+}
+
+// VerifyLocalObjects re-hashes every object in the local storage, returning
+// how many were checked and the ones which are corrupt or empty.
+func VerifyLocalObjects() (int, []*localstorage.BadObject, error) {
+	return objects.VerifyObjects()
+}
+
+// LocalStorageUsage returns the number of objects in local storage and their
+// total size in bytes.
+func LocalStorageUsage() (int, int64, error) {
+	return objects.DiskUsage()
+}
+
+// recordObjectAccess notes that the local object oid was just read for
+// checkout, for lfs.prunerecentlyused. Nothing is recorded when that isn't set,
+// as prune only compacts the log when it is. Failing to record it only makes
+// the object look older to prune.
+func recordObjectAccess(oid string) {
+	recordAccessOnce.Do(func() {
+		recordAccess = Config.FetchPruneConfig().PruneRecentlyUsedDays > 0
+	})
+	if objects == nil || !recordAccess {
+		return
+	}
+	if err := objects.RecordAccess(oid); err != nil {
+		tracerx.Printf("Unable to record access to %s: %s", oid, err)
 	}
-	return objects.ClearTempObjects()
+}
+
+// ObjectAccessTimes returns when each local object was last read for checkout,
+// as far as is known.
+func ObjectAccessTimes() (map[string]time.Time, error) {
+	return objects.AccessTimes()
+}
+
+// CompactObjectAccessLog drops records of objects which were last read before
+// since, or which no longer exist.
+func CompactObjectAccessLog(since time.Time) error {
+	return objects.CompactAccessLog(since)
 }
 
 func ScanObjectsChan() <-chan localstorage.Object {
@@ -199,13 +386,54 @@ func resolveGitStorageDir(gitDir string) string {
 	return gitDir
 }
 
+// resolveStorageDirs returns the root of LFS storage and the temp dir to use,
+// honouring the lfs.storage setting. A relative lfs.storage is resolved
+// against the git storage dir so that all worktrees share it. Without the
+// setting, temp files are kept per worktree.
+func resolveStorageDirs(storageConfig, gitDir, gitStorageDir string) (string, string) {
+	if len(storageConfig) == 0 {
+		return filepath.Join(gitStorageDir, "lfs"), filepath.Join(gitDir, "lfs", "tmp")
+	}
+
+	storage := storageConfig
+	if !filepath.IsAbs(storage) {
+		storage = filepath.Join(gitStorageDir, storage)
+	}
+	return storage, filepath.Join(storage, "tmp")
+}
+
+// isSharedStorage returns whether a relocated storage dir may be used by more
+// than one repository. This defaults to true since lfs.storage is commonly
+// pointed at a common dir, but can be disabled with lfs.sharedstorage=false.
+func isSharedStorage(storageConfig string) bool {
+	if len(storageConfig) == 0 {
+		return false
+	}
+
+	if shared, err := parseConfigBool(Config.findGitConfig("lfs.sharedstorage")); err == nil {
+		return shared
+	}
+	return true
+}
+
+// checkWritableDir makes sure files can be created in dir, so that a
+// misconfigured storage location fails up front rather than mid-transfer.
+func checkWritableDir(dir string) error {
+	f, err := ioutil.TempFile(localstorage.LongPath(dir), "check")
+	if err != nil {
+		return fmt.Errorf("%q is not writable: %s", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
 const (
 	gitExt       = ".git"
 	gitPtrPrefix = "gitdir: "
 )
 
 func traceHttpReq(req *http.Request) string {
-	return fmt.Sprintf("%s %s", req.Method, strings.SplitN(req.URL.String(), "?", 2)[0])
+	return fmt.Sprintf("%s %s", req.Method, RedactURLPassword(strings.SplitN(req.URL.String(), "?", 2)[0]))
 }
 
 // only used in tests
@@ -2,6 +2,9 @@ package lfs_test // avoid import cycle
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 	"testing"
 
@@ -67,3 +70,55 @@ func TestAllCurrentObjectsSome(t *testing.T) {
 	assert.Equal(t, expected, actual, "Oids from disk should be the same as in commits")
 
 }
+
+func TestCustomStorageDir(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	storageDir, err := ioutil.TempDir("", "lfsStorage")
+	if err != nil {
+		t.Fatalf("Can't create temp dir for storage: %v", err)
+	}
+	defer func() {
+		test.RunGitCommand(t, true, "config", "--unset", "lfs.storage")
+		repo.Popd()
+		repo.Cleanup()
+		os.RemoveAll(storageDir)
+	}()
+
+	storageDir = lfs.ResolveSymlinks(storageDir)
+	test.RunGitCommand(t, true, "config", "lfs.storage", storageDir)
+	lfs.ResolveDirs()
+
+	assert.Equal(t, storageDir, lfs.LocalStorageDir)
+	assert.Equal(t, filepath.Join(storageDir, "objects"), lfs.LocalMediaDir)
+	assert.Equal(t, filepath.Join(storageDir, "tmp"), lfs.TempDir)
+
+	inputs := []*test.CommitInput{
+		{Files: []*test.FileInput{{Filename: "file1.txt", Size: 30}}},
+	}
+	outputs := repo.AddCommits(inputs)
+	oid := outputs[0].Files[0].Oid
+
+	mediaPath := lfs.LocalMediaPathReadOnly(oid)
+	assert.Equal(t, filepath.Join(storageDir, "objects", oid[0:2], oid[2:4], oid), mediaPath)
+	assert.Equal(t, true, lfs.FileExists(mediaPath))
+
+	defaultObjects := filepath.Join(repo.GitDir, "lfs", "objects")
+	assert.Equal(t, false, lfs.FileExists(filepath.Join(defaultObjects, oid[0:2], oid[2:4], oid)))
+}
+
+func TestRelativeCustomStorageDir(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		test.RunGitCommand(t, true, "config", "--unset", "lfs.storage")
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	test.RunGitCommand(t, true, "config", "lfs.storage", "lfs-elsewhere")
+	lfs.ResolveDirs()
+
+	assert.Equal(t, filepath.Join(lfs.LocalGitStorageDir, "lfs-elsewhere"), lfs.LocalStorageDir)
+	assert.Equal(t, true, lfs.DirExists(filepath.Join(lfs.LocalGitStorageDir, "lfs-elsewhere", "objects")))
+}
@@ -0,0 +1,117 @@
+package lfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+)
+
+// The lock cache records the locks held by the current user, so commands can
+// tell which files they've locked without asking the server. It's a JSON file
+// that's replaced in one rename on every change, so a reader never sees it half
+// written. Two commands changing it at once can lose one of the changes, which
+// the next refresh from the server (see `git lfs locks`) puts right.
+const lockCacheFile = "lockcache.json"
+
+func lockCachePath() string {
+	return filepath.Join(LocalGitStorageDir, "lfs", lockCacheFile)
+}
+
+// CachedLocks returns the locks in the cache, sorted by path. A cache that's
+// missing or can't be parsed is treated as empty.
+func CachedLocks() ([]*Lock, error) {
+	by, err := ioutil.ReadFile(lockCachePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, Error(err)
+	}
+
+	var locks []*Lock
+	if err := json.Unmarshal(by, &locks); err != nil {
+		tracerx.Printf("locks: ignoring unreadable cache %s: %s", lockCachePath(), err)
+		return nil, nil
+	}
+	return locks, nil
+}
+
+// CacheLock adds lock to the cache, replacing any lock with the same id.
+func CacheLock(lock *Lock) error {
+	locks, err := CachedLocks()
+	if err != nil {
+		return err
+	}
+	return writeLockCache(append(withoutLock(locks, lock.Id), lock))
+}
+
+// UncacheLock removes the lock with the given id from the cache.
+func UncacheLock(id string) error {
+	locks, err := CachedLocks()
+	if err != nil {
+		return err
+	}
+	return writeLockCache(withoutLock(locks, id))
+}
+
+// ReplaceCachedLocks replaces the whole cache with locks, eg as fetched from
+// the server.
+func ReplaceCachedLocks(locks []*Lock) error {
+	return writeLockCache(locks)
+}
+
+func withoutLock(locks []*Lock, id string) []*Lock {
+	kept := make([]*Lock, 0, len(locks))
+	for _, lock := range locks {
+		if lock.Id != id {
+			kept = append(kept, lock)
+		}
+	}
+	return kept
+}
+
+type locksByPath []*Lock
+
+func (a locksByPath) Len() int           { return len(a) }
+func (a locksByPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a locksByPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
+
+func writeLockCache(locks []*Lock) error {
+	if locks == nil {
+		locks = []*Lock{}
+	}
+	sort.Sort(locksByPath(locks))
+
+	by, err := json.MarshalIndent(locks, "", "  ")
+	if err != nil {
+		return Error(err)
+	}
+
+	path := lockCachePath()
+	if err := localstorage.MkdirAll(filepath.Dir(path)); err != nil {
+		return Error(err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), lockCacheFile+"-")
+	if err != nil {
+		return Error(err)
+	}
+
+	_, err = tmp.Write(by)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return Error(err)
+	}
+	return localstorage.AdjustPerms(path)
+}
@@ -0,0 +1,104 @@
+package lfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func withTempLockCache(t *testing.T, cb func()) {
+	dir, err := ioutil.TempDir("", "lfs-lockcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldDir := LocalGitStorageDir
+	LocalGitStorageDir = dir
+	defer func() { LocalGitStorageDir = oldDir }()
+
+	cb()
+}
+
+func cachedLockPaths(t *testing.T) []string {
+	locks, err := CachedLocks()
+	assert.Equal(t, nil, err)
+	paths := make([]string, 0, len(locks))
+	for _, lock := range locks {
+		paths = append(paths, lock.Path)
+	}
+	return paths
+}
+
+func TestLockCache(t *testing.T) {
+	withTempLockCache(t, func() {
+		assert.Equal(t, []string{}, cachedLockPaths(t))
+
+		assert.Equal(t, nil, CacheLock(&Lock{Id: "2", Path: "b.dat"}))
+		assert.Equal(t, nil, CacheLock(&Lock{Id: "1", Path: "a.dat", Owner: &LockOwner{Name: "me"}}))
+		assert.Equal(t, []string{"a.dat", "b.dat"}, cachedLockPaths(t))
+
+		locks, _ := CachedLocks()
+		assert.Equal(t, "me", locks[0].OwnerName())
+
+		// caching a lock again replaces it
+		assert.Equal(t, nil, CacheLock(&Lock{Id: "2", Path: "c.dat"}))
+		assert.Equal(t, []string{"a.dat", "c.dat"}, cachedLockPaths(t))
+
+		assert.Equal(t, nil, UncacheLock("1"))
+		assert.Equal(t, nil, UncacheLock("missing"))
+		assert.Equal(t, []string{"c.dat"}, cachedLockPaths(t))
+
+		assert.Equal(t, nil, ReplaceCachedLocks([]*Lock{{Id: "3", Path: "d.dat"}}))
+		assert.Equal(t, []string{"d.dat"}, cachedLockPaths(t))
+
+		assert.Equal(t, nil, ReplaceCachedLocks(nil))
+		assert.Equal(t, []string{}, cachedLockPaths(t))
+	})
+}
+
+func TestLockCacheIgnoresCorruptFile(t *testing.T) {
+	withTempLockCache(t, func() {
+		assert.Equal(t, nil, CacheLock(&Lock{Id: "1", Path: "a.dat"}))
+		assert.Equal(t, nil, ioutil.WriteFile(lockCachePath(), []byte(`[{"id":"1","pa`), 0644))
+		assert.Equal(t, []string{}, cachedLockPaths(t))
+
+		assert.Equal(t, nil, CacheLock(&Lock{Id: "2", Path: "b.dat"}))
+		assert.Equal(t, []string{"b.dat"}, cachedLockPaths(t))
+	})
+}
+
+func TestLockCacheConcurrentWriters(t *testing.T) {
+	withTempLockCache(t, func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				id := fmt.Sprintf("%d", i)
+				if err := CacheLock(&Lock{Id: id, Path: id + ".dat"}); err != nil {
+					t.Error(err)
+				}
+				if _, err := CachedLocks(); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// writers can lose each other's changes, but never leave a file that
+		// can't be read
+		by, err := ioutil.ReadFile(lockCachePath())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, len(by) > 2)
+		assert.Equal(t, true, len(cachedLockPaths(t)) > 0)
+
+		files, _ := ioutil.ReadDir(filepath.Dir(lockCachePath()))
+		assert.Equal(t, 1, len(files))
+	})
+}
@@ -0,0 +1,262 @@
+package lfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+)
+
+// Lock is a lock on a file held on the server, which stops anyone but its
+// owner from pushing changes to the file.
+type Lock struct {
+	Id       string     `json:"id"`
+	Path     string     `json:"path"`
+	Owner    *LockOwner `json:"owner,omitempty"`
+	LockedAt time.Time  `json:"locked_at"`
+}
+
+// LockOwner is the user holding a Lock.
+type LockOwner struct {
+	Name string `json:"name"`
+}
+
+// OwnerName returns the name of the lock's owner, or a placeholder if the
+// server didn't say.
+func (l *Lock) OwnerName() string {
+	if l.Owner == nil || len(l.Owner.Name) == 0 {
+		return "unknown"
+	}
+	return l.Owner.Name
+}
+
+type lockVerifyRequest struct {
+	Ref    lockVerifyRef `json:"ref"`
+	Cursor string        `json:"cursor,omitempty"`
+}
+
+type lockVerifyRef struct {
+	Name string `json:"name"`
+}
+
+type lockVerifyResponse struct {
+	Ours       []*Lock `json:"ours"`
+	Theirs     []*Lock `json:"theirs"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	Message    string  `json:"message,omitempty"`
+}
+
+// VerifyLocks asks the server which locks apply to a push to the remote ref,
+// split into those held by the current user and those held by others. Returns
+// a not implemented error if the server doesn't support locking.
+func VerifyLocks(ref string) (ours, theirs []*Lock, err error) {
+	body := &lockVerifyRequest{Ref: lockVerifyRef{Name: ref}}
+	for {
+		res, err := verifyLocksPage(body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ours = append(ours, res.Ours...)
+		theirs = append(theirs, res.Theirs...)
+		if len(res.NextCursor) == 0 {
+			return ours, theirs, nil
+		}
+		body.Cursor = res.NextCursor
+	}
+}
+
+func verifyLocksPage(body *lockVerifyRequest) (*lockVerifyResponse, error) {
+	tracerx.Printf("api: verifying locks for %s", body.Ref.Name)
+	res := &lockVerifyResponse{}
+	if err := doLocksApiRequest("POST", "verify", nil, body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+type lockRequest struct {
+	Path string `json:"path"`
+}
+
+type unlockRequest struct {
+	Force bool `json:"force"`
+}
+
+type lockResponse struct {
+	Lock    *Lock  `json:"lock"`
+	Message string `json:"message,omitempty"`
+}
+
+type lockListResponse struct {
+	Locks      []*Lock `json:"locks"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// LockFile locks path, relative to the root of the repo, on the server for
+// the current user, returning the new lock.
+func LockFile(path string) (*Lock, error) {
+	tracerx.Printf("api: locking %s", path)
+	res := &lockResponse{}
+	if err := doLocksApiRequest("POST", "", nil, &lockRequest{Path: path}, res); err != nil {
+		return nil, err
+	}
+	if res.Lock == nil {
+		return nil, Errorf(nil, "Server returned no lock for %s", path)
+	}
+	return res.Lock, nil
+}
+
+// UnlockFile releases the lock with the given id on the server. force releases
+// it even if it's held by someone else.
+func UnlockFile(id string, force bool) (*Lock, error) {
+	tracerx.Printf("api: unlocking %s", id)
+	res := &lockResponse{}
+	if err := doLocksApiRequest("POST", path.Join(id, "unlock"), nil, &unlockRequest{Force: force}, res); err != nil {
+		return nil, err
+	}
+	if res.Lock == nil {
+		return nil, Errorf(nil, "Server returned no lock for %s", id)
+	}
+	return res.Lock, nil
+}
+
+// SearchLocks returns the locks on the server matching path and id, where
+// either may be empty to match any, up to limit locks if it's more than 0.
+func SearchLocks(path, id string, limit int) ([]*Lock, error) {
+	var locks []*Lock
+	query := url.Values{}
+	if len(path) > 0 {
+		query.Set("path", path)
+	}
+	if len(id) > 0 {
+		query.Set("id", id)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	for {
+		tracerx.Printf("api: searching locks %s", query.Encode())
+		res := &lockListResponse{}
+		if err := doLocksApiRequest("GET", "", query, nil, res); err != nil {
+			return nil, err
+		}
+
+		locks = append(locks, res.Locks...)
+		if len(res.NextCursor) == 0 || (limit > 0 && len(locks) >= limit) {
+			break
+		}
+		query.Set("cursor", res.NextCursor)
+	}
+
+	if limit > 0 && len(locks) > limit {
+		locks = locks[0:limit]
+	}
+	return locks, nil
+}
+
+// doLocksApiRequest sends body as JSON to the locks API at locks/<action>,
+// decoding the response into result. Returns a not implemented error if the
+// server doesn't support locking.
+func doLocksApiRequest(method, action string, query url.Values, body, result interface{}) error {
+	req, err := newLocksApiRequest(method, action, query)
+	if err != nil {
+		return Error(err)
+	}
+
+	if body != nil {
+		by, err := json.Marshal(body)
+		if err != nil {
+			return Error(err)
+		}
+
+		req.Header.Set("Content-Type", mediaType)
+		req.Header.Set("Content-Length", strconv.Itoa(len(by)))
+		req.ContentLength = int64(len(by))
+		req.Body = &byteCloser{bytes.NewReader(by)}
+	}
+
+	res, err := doAPIRequest(req, Config.PrivateAccess("upload"))
+	if err != nil {
+		if res == nil || res.StatusCode == 0 {
+			return newRetriableError(err)
+		}
+
+		if IsAuthError(err) && setAuthType(req, res) {
+			return doLocksApiRequest(method, action, query, body, result)
+		}
+
+		switch res.StatusCode {
+		case 404, 501:
+			tracerx.Printf("api: locks not implemented: %d", res.StatusCode)
+			return newNotImplementedError(nil)
+		}
+
+		return Error(err)
+	}
+	LogTransfer("lfs.api.locks", res)
+
+	if err := decodeApiResponse(res, result); err != nil {
+		return err
+	}
+
+	if res.StatusCode != 200 && res.StatusCode != 201 {
+		return Error(fmt.Errorf("Invalid status for %s: %d", traceHttpReq(req), res.StatusCode))
+	}
+
+	return nil
+}
+
+func newLocksApiRequest(method, action string, query url.Values) (*http.Request, error) {
+	endpoint := Config.Endpoint("upload")
+
+	res, err := sshAuthenticate(endpoint, "upload", "")
+	if err != nil {
+		tracerx.Printf("ssh: locks attempted with %s.  Error: %s",
+			endpoint.SshUserAndHost, err.Error(),
+		)
+		return nil, err
+	}
+
+	if len(res.Href) > 0 {
+		endpoint.Url = res.Href
+	}
+
+	u, err := url.Parse(endpoint.Url)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, "locks", action)
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+
+	req, err := newClientRequest(method, u.String(), res.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", mediaType)
+	return req, nil
+}
+
+// LockConflicts returns the locks in theirs on any of paths, which a push
+// changing those paths would violate.
+func LockConflicts(theirs []*Lock, paths []string) []*Lock {
+	changed := NewStringSetFromSlice(paths)
+
+	var conflicts []*Lock
+	for _, lock := range theirs {
+		if changed.Contains(lock.Path) {
+			conflicts = append(conflicts, lock)
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,99 @@
+package lfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestVerifyLocks(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var cursors []string
+	mux.HandleFunc("/media/locks/verify", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(405)
+			return
+		}
+
+		body := &lockVerifyRequest{}
+		if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "refs/heads/master", body.Ref.Name)
+		cursors = append(cursors, body.Cursor)
+
+		res := &lockVerifyResponse{}
+		if body.Cursor == "" {
+			res.Ours = []*Lock{{Id: "1", Path: "mine.dat", Owner: &LockOwner{Name: "me"}}}
+			res.Theirs = []*Lock{{Id: "2", Path: "a.dat", Owner: &LockOwner{Name: "them"}}}
+			res.NextCursor = "page2"
+		} else {
+			res.Theirs = []*Lock{{Id: "3", Path: "b.dat"}}
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+		json.NewEncoder(w).Encode(res)
+	})
+
+	defer Config.ResetConfig()
+	Config.SetConfig("lfs.url", server.URL+"/media")
+
+	ours, theirs, err := VerifyLocks("refs/heads/master")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"", "page2"}, cursors)
+	assert.Equal(t, 1, len(ours))
+	assert.Equal(t, "mine.dat", ours[0].Path)
+	assert.Equal(t, 2, len(theirs))
+	assert.Equal(t, "them", theirs[0].OwnerName())
+	assert.Equal(t, "unknown", theirs[1].OwnerName())
+}
+
+func TestVerifyLocksNotImplemented(t *testing.T) {
+	for _, status := range []int{404, 501} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+
+		Config.SetConfig("lfs.url", server.URL+"/media")
+		_, _, err := VerifyLocks("refs/heads/master")
+		assert.Equal(t, true, IsNotImplementedError(err))
+
+		server.Close()
+		Config.ResetConfig()
+	}
+}
+
+func TestLockConflicts(t *testing.T) {
+	theirs := []*Lock{{Path: "a.dat"}, {Path: "dir/b.dat"}, {Path: "c.dat"}}
+
+	conflicts := LockConflicts(theirs, []string{"dir/b.dat", "a.dat.txt", "a.dat"})
+	assert.Equal(t, 2, len(conflicts))
+	assert.Equal(t, "a.dat", conflicts[0].Path)
+	assert.Equal(t, "dir/b.dat", conflicts[1].Path)
+
+	assert.Equal(t, 0, len(LockConflicts(theirs, nil)))
+}
+
+func TestEndpointLocksVerify(t *testing.T) {
+	defer Config.ResetConfig()
+	endpoint := Endpoint{Url: "https://example.com/repo.git/info/lfs"}
+
+	verify, set := Config.EndpointLocksVerify(endpoint)
+	assert.Equal(t, true, verify)
+	assert.Equal(t, false, set)
+
+	Config.SetConfig("lfs.https://example.com/repo.git/info/lfs.locksverify", "false")
+	verify, set = Config.EndpointLocksVerify(endpoint)
+	assert.Equal(t, false, verify)
+	assert.Equal(t, true, set)
+
+	assert.Equal(t, true, Config.ForceLockVerify())
+	Config.SetConfig("lfs.forcelockverify", "false")
+	assert.Equal(t, false, Config.ForceLockVerify())
+}
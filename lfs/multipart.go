@@ -0,0 +1,292 @@
+package lfs
+
+// Multipart uploads split an object into parts which are uploaded separately,
+// for servers (or the proxies in front of them) that won't take a very large
+// PUT. The batch API asks for one by giving the object a "multipart" action as
+// well as the usual "upload" one, which older clients keep using:
+//
+//  1. POST {"oid": ..., "size": ..., "part_size": ...} to the multipart href.
+//     The server replies with the part size it wants, where to upload each
+//     part, and where to complete the upload:
+//
+//     {"part_size": 67108864,
+//      "parts": [{"href": ..., "header": {...}}, ...],
+//      "complete": {"href": ..., "header": {...}}}
+//
+//  2. PUT each part's bytes to its href, keeping the ETag of each response.
+//
+//  3. POST {"oid": ..., "parts": [{"part": 1, "etag": ...}, ...]} to the
+//     complete href.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+)
+
+const (
+	defaultMultipartPartSize    = 64 * 1024 * 1024
+	defaultMultipartConcurrency = 4
+)
+
+// An uploadAdapter uploads the object stored at path some way other than a
+// single PUT to its "upload" action.
+type uploadAdapter func(o *ObjectResource, path string, cb CopyCallback) error
+
+// uploadAdapters are tried in order, using the first whose rel the batch API
+// gave the object. Objects without any of them are uploaded with a single PUT.
+var uploadAdapters = []struct {
+	rel    string
+	upload uploadAdapter
+}{
+	{"multipart", uploadMultipart},
+}
+
+type multipartUpload struct {
+	PartSize int64           `json:"part_size"`
+	Parts    []*linkRelation `json:"parts"`
+	Complete *linkRelation   `json:"complete"`
+}
+
+type multipartPart struct {
+	Part int    `json:"part"`
+	Etag string `json:"etag,omitempty"`
+}
+
+// uploadMultipart uploads the object in parts, lfs.transfer.multipart.concurrency
+// at a time. A failed part is retried by itself, up to lfs.transfer.maxretries
+// times, without starting the rest of the object again.
+func uploadMultipart(o *ObjectResource, path string, cb CopyCallback) error {
+	m, err := startMultipartUpload(o)
+	if err != nil {
+		return err
+	}
+
+	progress := newPartProgress(o.Size, len(m.Parts), cb)
+	parts := make([]multipartPart, len(m.Parts))
+	errs := make([]error, len(m.Parts))
+	sem := make(chan struct{}, Config.MultipartConcurrency())
+	var wg sync.WaitGroup
+
+	for i, rel := range m.Parts {
+		offset := int64(i) * m.PartSize
+		length := m.PartSize
+		if offset+length > o.Size {
+			length = o.Size - offset
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, rel *linkRelation) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			etag, err := uploadPartWithRetries(path, rel, i+1, offset, length, progress)
+			parts[i] = multipartPart{Part: i + 1, Etag: etag}
+			errs[i] = err
+		}(i, rel)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return completeMultipartUpload(o, m.Complete, parts)
+}
+
+// startMultipartUpload asks the server where to upload each part of o.
+func startMultipartUpload(o *ObjectResource) (*multipartUpload, error) {
+	by, err := json.Marshal(map[string]interface{}{
+		"oid":       o.Oid,
+		"size":      o.Size,
+		"part_size": Config.MultipartPartSize(),
+	})
+	if err != nil {
+		return nil, Error(err)
+	}
+
+	req, err := o.NewRequest("multipart", "POST")
+	if err != nil {
+		return nil, Error(err)
+	}
+
+	res, err := doJsonStorageRequest(req, by)
+	if err != nil {
+		return nil, newRetriableError(err)
+	}
+	LogTransfer("lfs.data.multipart", res)
+
+	m := &multipartUpload{}
+	if err := decodeApiResponse(res, m); err != nil {
+		return nil, err
+	}
+
+	if m.PartSize < 1 {
+		m.PartSize = Config.MultipartPartSize()
+	}
+
+	expected := int((o.Size + m.PartSize - 1) / m.PartSize)
+	if len(m.Parts) != expected || m.Complete == nil {
+		return nil, Errorf(nil, "Invalid multipart upload for %s: expected %d parts of %d bytes, got %d", o.Oid, expected, m.PartSize, len(m.Parts))
+	}
+
+	return m, nil
+}
+
+// uploadPartWithRetries uploads one part, retrying it if it fails in a way
+// which is safe to retry. It returns the part's ETag.
+func uploadPartWithRetries(path string, rel *linkRelation, part int, offset, length int64, progress *partProgress) (string, error) {
+	maxRetries := Config.TransferMaxRetries()
+	for retries := 0; ; retries++ {
+		etag, err := uploadPart(path, rel, offset, length, progress.callback(part-1))
+		if err == nil {
+			return etag, nil
+		}
+
+		if Interrupted() || !IsRetriableError(err) {
+			return "", err
+		}
+		if retries >= maxRetries {
+			if retries > 0 {
+				// the whole object isn't worth retrying after this
+				return "", newRetriesExhaustedError(err, retries)
+			}
+			return "", err
+		}
+
+		delay, ok := retryAfterOf(err)
+		if !ok {
+			delay = retryBackoff(retries + 1)
+		}
+		tracerx.Printf("multipart: retrying part %d in %s (%d/%d): %s", part, delay, retries+1, maxRetries, err)
+		progress.reset(part - 1)
+		time.Sleep(delay)
+	}
+}
+
+func uploadPart(path string, rel *linkRelation, offset, length int64, cb CopyCallback) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", Error(err)
+	}
+	defer file.Close()
+
+	req, err := newClientRequest("PUT", rel.Href, rel.Header)
+	if err != nil {
+		return "", Error(err)
+	}
+
+	if len(req.Header.Get("Content-Type")) == 0 {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	req.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	req.ContentLength = length
+	req.Body = ioutil.NopCloser(&CallbackReader{
+		C:         cb,
+		TotalSize: length,
+		Reader:    throttleReader(io.NewSectionReader(file, offset, length), "upload"),
+	})
+
+	res, err := doStorageRequest(req)
+	if err != nil {
+		return "", newRetriableError(err)
+	}
+	LogTransfer("lfs.data.upload", res)
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	// see UploadObject
+	if res.StatusCode == 403 {
+		return "", newRetriableError(fmt.Errorf("Invalid status for %s: %d", traceHttpReq(req), res.StatusCode))
+	}
+
+	if res.StatusCode > 299 {
+		return "", Errorf(nil, "Invalid status for %s: %d", traceHttpReq(req), res.StatusCode)
+	}
+
+	return res.Header.Get("ETag"), nil
+}
+
+// completeMultipartUpload tells the server every part of o has been uploaded.
+func completeMultipartUpload(o *ObjectResource, rel *linkRelation, parts []multipartPart) error {
+	by, err := json.Marshal(map[string]interface{}{"oid": o.Oid, "parts": parts})
+	if err != nil {
+		return Error(err)
+	}
+
+	req, err := newClientRequest("POST", rel.Href, rel.Header)
+	if err != nil {
+		return Error(err)
+	}
+
+	res, err := doJsonStorageRequest(req, by)
+	if err != nil {
+		return newRetriableError(err)
+	}
+	LogTransfer("lfs.data.multipart.complete", res)
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	return nil
+}
+
+// doJsonStorageRequest sends by as the JSON body of req.
+func doJsonStorageRequest(req *http.Request, by []byte) (*http.Response, error) {
+	req.Header.Set("Content-Type", mediaType)
+	req.Header.Set("Content-Length", strconv.Itoa(len(by)))
+	req.ContentLength = int64(len(by))
+	req.Body = ioutil.NopCloser(bytes.NewReader(by))
+
+	return doStorageRequest(req)
+}
+
+// partProgress adds up the progress of each part of an object, so the
+// progress meter sees the object's progress as if it was a single upload.
+type partProgress struct {
+	total int64
+	read  int64
+	parts []int64
+	cb    CopyCallback
+	mutex sync.Mutex
+}
+
+func newPartProgress(total int64, parts int, cb CopyCallback) *partProgress {
+	return &partProgress{total: total, parts: make([]int64, parts), cb: cb}
+}
+
+// callback returns the CopyCallback for uploading the given part.
+func (p *partProgress) callback(part int) CopyCallback {
+	return func(total, read int64, current int) error {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		p.read += read - p.parts[part]
+		p.parts[part] = read
+		if p.cb == nil {
+			return nil
+		}
+		return p.cb(p.total, p.read, current)
+	}
+}
+
+// reset forgets the progress of a part which is going to be uploaded again.
+func (p *partProgress) reset(part int) {
+	p.mutex.Lock()
+	p.read -= p.parts[part]
+	p.parts[part] = 0
+	p.mutex.Unlock()
+}
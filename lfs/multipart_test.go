@@ -0,0 +1,179 @@
+package lfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestMultipartUpload(t *testing.T) {
+	content := "abcdefghij"
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+
+	tmp := tempdir(t)
+	olddir := LocalMediaDir
+	oldDelay := retryBaseDelay
+	LocalMediaDir = tmp
+	retryBaseDelay = time.Millisecond
+	defer func() {
+		LocalMediaDir = olddir
+		retryBaseDelay = oldDelay
+		os.RemoveAll(tmp)
+	}()
+
+	oidPath, _ := LocalMediaPath(oid)
+	if err := ioutil.WriteFile(oidPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	parts := make(map[string]string)
+	puts := make(map[string]int)
+	var completed []multipartPart
+	singlePut := false
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/multipart", func(w http.ResponseWriter, r *http.Request) {
+		var start struct {
+			Oid      string `json:"oid"`
+			Size     int64  `json:"size"`
+			PartSize int64  `json:"part_size"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&start); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, oid, start.Oid)
+		assert.Equal(t, int64(10), start.Size)
+		assert.Equal(t, int64(defaultMultipartPartSize), start.PartSize)
+
+		m := &multipartUpload{PartSize: 4, Complete: &linkRelation{Href: server.URL + "/complete"}}
+		for i := 1; i <= 3; i++ {
+			m.Parts = append(m.Parts, &linkRelation{
+				Href:   fmt.Sprintf("%s/parts/%d", server.URL, i),
+				Header: map[string]string{"X-Part": strconv.Itoa(i)},
+			})
+		}
+
+		w.Header().Set("Content-Type", mediaType)
+		json.NewEncoder(w).Encode(m)
+	})
+
+	mux.HandleFunc("/parts/", func(w http.ResponseWriter, r *http.Request) {
+		part := strings.TrimPrefix(r.URL.Path, "/parts/")
+		assert.Equal(t, part, r.Header.Get("X-Part"))
+		by, _ := ioutil.ReadAll(r.Body)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		puts[part]++
+
+		// the first upload of the second part fails
+		if part == "2" && puts[part] == 1 {
+			w.WriteHeader(500)
+			return
+		}
+
+		parts[part] = string(by)
+		w.Header().Set("ETag", "etag-"+part)
+		w.WriteHeader(200)
+	})
+
+	mux.HandleFunc("/complete", func(w http.ResponseWriter, r *http.Request) {
+		var complete struct {
+			Oid   string          `json:"oid"`
+			Parts []multipartPart `json:"parts"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&complete); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, oid, complete.Oid)
+		completed = complete.Parts
+		w.WriteHeader(200)
+	})
+
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		singlePut = true
+		w.WriteHeader(200)
+	})
+
+	obj := &ObjectResource{
+		Oid:  oid,
+		Size: 10,
+		Actions: map[string]*linkRelation{
+			"upload":    &linkRelation{Href: server.URL + "/upload"},
+			"multipart": &linkRelation{Href: server.URL + "/multipart"},
+		},
+	}
+
+	var lastRead int64
+	cb := func(total, read int64, current int) error {
+		assert.Equal(t, int64(10), total)
+		if read > total {
+			t.Errorf("progress of %d is more than the object's size", read)
+		}
+		lastRead = read
+		return nil
+	}
+
+	err := UploadObject(obj, cb)
+	if err != nil {
+		if isDockerConnectionError(err) {
+			return
+		}
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, false, singlePut)
+	assert.Equal(t, "abcd", parts["1"])
+	assert.Equal(t, "efgh", parts["2"])
+	assert.Equal(t, "ij", parts["3"])
+	assert.Equal(t, 1, puts["1"])
+	assert.Equal(t, 2, puts["2"])
+	assert.Equal(t, 1, puts["3"])
+	assert.Equal(t, int64(10), lastRead)
+
+	assert.Equal(t, 3, len(completed))
+	for i, p := range completed {
+		assert.Equal(t, i+1, p.Part)
+		assert.Equal(t, fmt.Sprintf("etag-%d", i+1), p.Etag)
+	}
+}
+
+func TestMultipartUploadRejectsWrongParts(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/multipart", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", mediaType)
+		w.Write([]byte(`{"part_size": 4, "parts": [{"href": "/parts/1"}], "complete": {"href": "/complete"}}`))
+	})
+
+	obj := &ObjectResource{
+		Oid:     "abc",
+		Size:    10,
+		Actions: map[string]*linkRelation{"multipart": &linkRelation{Href: server.URL + "/multipart"}},
+	}
+
+	_, err := startMultipartUpload(obj)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.Equal(t, "Invalid multipart upload for abc: expected 3 parts of 4 bytes, got 1", err.Error())
+}
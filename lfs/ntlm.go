@@ -12,17 +12,18 @@ import (
 	"strings"
 	"sync/atomic"
 
+	"github.com/github/git-lfs/trace"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/ThomsonReutersEikon/go-ntlm/ntlm"
 )
 
-func (c *Configuration) ntlmClientSession(creds Creds) (ntlm.ClientSession, error) {
-	if c.ntlmSession != nil {
-		return c.ntlmSession, nil
-	}
+// newNtlmClientSession starts the client side of an NTLM handshake. Each
+// handshake gets a session of its own, so concurrent handshakes don't mix up
+// each other's challenges.
+func newNtlmClientSession(creds Creds) (ntlm.ClientSession, error) {
 	splits := strings.Split(creds["username"], "\\")
 
 	if len(splits) != 2 {
-		errorMessage := fmt.Sprintf("Your user name must be of the form DOMAIN\\user. It is currently %s", creds["username"], "string")
+		errorMessage := fmt.Sprintf("Your user name must be of the form DOMAIN\\user. It is currently %s", creds["username"])
 		return nil, errors.New(errorMessage)
 	}
 
@@ -33,23 +34,44 @@ func (c *Configuration) ntlmClientSession(creds Creds) (ntlm.ClientSession, erro
 	}
 
 	session.SetUserInfo(splits[1], creds["password"], strings.ToUpper(splits[0]))
-	c.ntlmSession = session
 	return session, nil
 }
 
+// DoNTLMRequest makes the request to a server which uses NTLM authentication.
+// NTLM authenticates a connection rather than a request, so the request and
+// its negotiate and challenge messages are sent over a connection of its own,
+// which isn't shared with any other request. The connection is closed with the
+// body of the response.
 func DoNTLMRequest(request *http.Request, retry bool) (*http.Response, error) {
+	client, closeConn := Config.ntlmHttpClient(request.Host)
+
+	res, err := doNTLMRequest(client, request, retry)
+	if err != nil {
+		closeConn()
+		return res, err
+	}
+
+	res.Body = &ntlmResponseBody{ReadCloser: res.Body, closeConn: closeConn}
+	return res, nil
+}
+
+func doNTLMRequest(client *HttpClient, request *http.Request, retry bool) (*http.Response, error) {
 	handReq, err := cloneRequest(request)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := Config.HttpClient(handReq.Host).Do(handReq)
+	res, err := client.Do(handReq)
 	if err != nil && res == nil {
 		return nil, err
 	}
 
 	//If the status is 401 then we need to re-authenticate, otherwise it was successful
 	if res.StatusCode == 401 {
+		// the handshake carries on over this connection, so it has to be
+		// free for the next request
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
 
 		creds, err := getCredsForAPI(request)
 		if err != nil {
@@ -61,7 +83,7 @@ func DoNTLMRequest(request *http.Request, retry bool) (*http.Response, error) {
 			return nil, err
 		}
 
-		challengeMessage, err := negotiate(negotiateReq, ntlmNegotiateMessage)
+		challengeMessage, err := negotiate(client, negotiateReq, ntlmNegotiateMessage)
 		if err != nil {
 			return nil, err
 		}
@@ -71,14 +93,17 @@ func DoNTLMRequest(request *http.Request, retry bool) (*http.Response, error) {
 			return nil, err
 		}
 
-		res, err := challenge(challengeReq, challengeMessage, creds)
+		res, err := challenge(client, challengeReq, challengeMessage, creds)
 		if err != nil {
 			return nil, err
 		}
 
 		//If the status is 401 then we need to re-authenticate
 		if res.StatusCode == 401 && retry == true {
-			return DoNTLMRequest(challengeReq, false)
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			trace.Auth.Printf("ntlm: %s rejected the handshake, retrying", request.Host)
+			return doNTLMRequest(client, challengeReq, false)
 		}
 
 		saveCredentials(creds, res)
@@ -88,9 +113,22 @@ func DoNTLMRequest(request *http.Request, retry bool) (*http.Response, error) {
 	return res, nil
 }
 
-func negotiate(request *http.Request, message string) ([]byte, error) {
-	request.Header.Add("Authorization", message)
-	res, err := Config.HttpClient(request.Host).Do(request)
+// ntlmResponseBody closes the connection of an NTLM request once the response
+// has been read.
+type ntlmResponseBody struct {
+	io.ReadCloser
+	closeConn func()
+}
+
+func (b *ntlmResponseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.closeConn()
+	return err
+}
+
+func negotiate(client *HttpClient, request *http.Request, message string) ([]byte, error) {
+	request.Header.Set("Authorization", message)
+	res, err := client.Do(request)
 
 	if res == nil && err != nil {
 		return nil, err
@@ -107,13 +145,13 @@ func negotiate(request *http.Request, message string) ([]byte, error) {
 	return ret, nil
 }
 
-func challenge(request *http.Request, challengeBytes []byte, creds Creds) (*http.Response, error) {
+func challenge(client *HttpClient, request *http.Request, challengeBytes []byte, creds Creds) (*http.Response, error) {
 	challenge, err := ntlm.ParseChallengeMessage(challengeBytes)
 	if err != nil {
 		return nil, err
 	}
 
-	session, err := Config.ntlmClientSession(creds)
+	session, err := newNtlmClientSession(creds)
 	if err != nil {
 		return nil, err
 	}
@@ -125,12 +163,19 @@ func challenge(request *http.Request, challengeBytes []byte, creds Creds) (*http
 	}
 
 	authMsg := base64.StdEncoding.EncodeToString(authenticate.Bytes())
-	request.Header.Add("Authorization", "NTLM "+authMsg)
-	return Config.HttpClient(request.Host).Do(request)
+	request.Header.Set("Authorization", "NTLM "+authMsg)
+	return client.Do(request)
 }
 
 func parseChallengeResponse(response *http.Response) ([]byte, error) {
+	// IIS sends a header for each scheme it supports, eg "Negotiate" and "NTLM"
 	header := response.Header.Get("Www-Authenticate")
+	for _, h := range response.Header["Www-Authenticate"] {
+		if strings.HasPrefix(strings.ToUpper(h), "NTLM ") {
+			header = h
+			break
+		}
+	}
 	if len(header) < 6 {
 		return nil, fmt.Errorf("Invalid NTLM challenge response: %q", header)
 	}
@@ -5,41 +5,153 @@ import (
 	"encoding/base64"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/github/git-lfs/vendor/_nuts/github.com/ThomsonReutersEikon/go-ntlm/ntlm"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
 )
 
 func TestNtlmClientSession(t *testing.T) {
-
-	//Make sure to clear ntlmSession so test order doesn't matter.
-	Config.ntlmSession = nil
-
 	creds := Creds{"username": "MOOSEDOMAIN\\canadian", "password": "MooseAntlersYeah"}
-	_, err := Config.ntlmClientSession(creds)
+	session1, err := newNtlmClientSession(creds)
 	assert.Equal(t, err, nil)
 
-	//The second call should ignore creds and give the session we just created.
-	badCreds := Creds{"username": "badusername", "password": "MooseAntlersYeah"}
-	_, err = Config.ntlmClientSession(badCreds)
+	//Each handshake gets its own session.
+	session2, err := newNtlmClientSession(creds)
 	assert.Equal(t, err, nil)
-
-	//clean up
-	Config.ntlmSession = nil
+	assert.Equal(t, false, session1 == session2)
 }
 
 func TestNtlmClientSessionBadCreds(t *testing.T) {
-
-	//Make sure to clear ntlmSession so test order doesn't matter.
-	Config.ntlmSession = nil
-
 	creds := Creds{"username": "badusername", "password": "MooseAntlersYeah"}
-	_, err := Config.ntlmClientSession(creds)
+	_, err := newNtlmClientSession(creds)
 	assert.NotEqual(t, err, nil)
+}
+
+func TestNtlmRequestHandshakesOverOneConnection(t *testing.T) {
+	var mutex sync.Mutex
+	sessions := make(map[string]ntlm.ServerSession) // by client address
+	negotiated := make(map[string]bool)             // connections that have negotiated
+	var handshakes int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// let the concurrent handshakes interleave
+		time.Sleep(5 * time.Millisecond)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "NTLM ") {
+			w.Header().Add("Www-Authenticate", "Negotiate")
+			w.Header().Add("Www-Authenticate", "NTLM")
+			w.WriteHeader(401)
+			return
+		}
+
+		msg, err := base64.StdEncoding.DecodeString(auth[5:])
+		if err != nil || len(msg) < 12 {
+			w.WriteHeader(400)
+			return
+		}
+
+		switch msg[8] {
+		case 1:
+			// connections aren't pooled, so no other request can use one
+			// authenticated for an earlier handshake
+			if negotiated[r.RemoteAddr] {
+				t.Errorf("%s negotiated twice", r.RemoteAddr)
+			}
+			negotiated[r.RemoteAddr] = true
+
+			session, _ := ntlm.CreateServerSession(ntlm.Version2, ntlm.ConnectionOrientedMode)
+			session.SetUserInfo("canadian", "MooseAntlersYeah", "MOOSEDOMAIN")
+			challenge, _ := session.GenerateChallengeMessage()
+			sessions[r.RemoteAddr] = session
+
+			w.Header().Add("Www-Authenticate", "Negotiate")
+			w.Header().Add("Www-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(challenge.Bytes()))
+			w.WriteHeader(401)
+		case 3:
+			// the handshake has to finish on the connection it started on
+			session, ok := sessions[r.RemoteAddr]
+			delete(sessions, r.RemoteAddr)
+			if !ok {
+				t.Errorf("authenticate message from %s, which didn't negotiate", r.RemoteAddr)
+				w.WriteHeader(401)
+				return
+			}
+
+			am, err := ntlm.ParseAuthenticateMessage(msg, 2)
+			if err == nil {
+				err = session.ProcessAuthenticateMessage(am)
+			}
+			if err != nil {
+				t.Errorf("bad authenticate message: %s", err)
+				w.WriteHeader(401)
+				return
+			}
+
+			handshakes++
+			w.WriteHeader(200)
+			w.Write([]byte("moose"))
+		default:
+			w.WriteHeader(400)
+		}
+	}))
+	defer server.Close()
+
+	Config.SetConfig("lfs.url", server.URL)
+	Config.SetConfig("lfs."+server.URL+".access", "ntlm")
+	oldExecCreds := execCreds
+	execCreds = func(input Creds, subCommand string) (Creds, error) {
+		return Creds{"username": "MOOSEDOMAIN\\canadian", "password": "MooseAntlersYeah"}, nil
+	}
+	defer func() {
+		execCreds = oldExecCreds
+		Config.ResetConfig()
+	}()
+
+	// twice, so a pooled connection would be reused the second time
+	for round := 0; round < 2; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				req, _ := http.NewRequest("GET", server.URL+"/objects/moose", nil)
+				res, err := DoNTLMRequest(req, true)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				by, _ := ioutil.ReadAll(res.Body)
+				res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+				assert.Equal(t, "moose", string(by))
+			}()
+		}
+		wg.Wait()
+	}
+
+	assert.Equal(t, 8, handshakes)
+}
+
+func TestNtlmAuthTypeFromAnyAuthenticateHeader(t *testing.T) {
+	res := &http.Response{Header: make(http.Header)}
+	res.Header.Add("Www-Authenticate", "Negotiate")
+	res.Header.Add("Www-Authenticate", "NTLM")
+	assert.Equal(t, "ntlm", getAuthType(res))
 
-	//clean up
-	Config.ntlmSession = nil
+	res = &http.Response{Header: make(http.Header)}
+	res.Header.Add("Www-Authenticate", "Basic realm=\"moose\"")
+	assert.Equal(t, "basic", getAuthType(res))
 }
 
 func TestNtlmCloneRequest(t *testing.T) {
@@ -11,6 +11,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/github/git-lfs/localstorage"
 )
 
 var (
@@ -24,6 +26,7 @@ var (
 	oidRE       = regexp.MustCompile(`\A[[:alnum:]]{64}`)
 	matcherRE   = regexp.MustCompile("git-media|hawser|git-lfs")
 	extRE       = regexp.MustCompile(`\Aext-\d{1}-\w+`)
+	keyRE       = regexp.MustCompile(`\A[a-z0-9.-]+\z`)
 	pointerKeys = []string{"version", "oid", "size"}
 )
 
@@ -33,6 +36,9 @@ type Pointer struct {
 	Size       int64
 	OidType    string
 	Extensions []*PointerExtension
+	// Extra holds any keys this version of Git LFS doesn't know about, sorted
+	// by key, so that they survive a decode and encode round trip.
+	Extra []*PointerKeyValue
 }
 
 // A PointerExtension is parsed from the Git LFS Pointer file.
@@ -43,6 +49,19 @@ type PointerExtension struct {
 	OidType  string
 }
 
+// A PointerKeyValue is an unrecognized key and its value from a Git LFS Pointer
+// file.
+type PointerKeyValue struct {
+	Key   string
+	Value string
+}
+
+type ByKey []*PointerKeyValue
+
+func (p ByKey) Len() int           { return len(p) }
+func (p ByKey) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p ByKey) Less(i, j int) bool { return p[i].Key < p[j].Key }
+
 type ByPriority []*PointerExtension
 
 func (p ByPriority) Len() int           { return len(p) }
@@ -50,7 +69,7 @@ func (p ByPriority) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func (p ByPriority) Less(i, j int) bool { return p[i].Priority < p[j].Priority }
 
 func NewPointer(oid string, size int64, exts []*PointerExtension) *Pointer {
-	return &Pointer{latest, oid, size, oidType, exts}
+	return &Pointer{latest, oid, size, oidType, exts, nil}
 }
 
 func NewPointerExtension(name string, priority int, oid string) *PointerExtension {
@@ -70,13 +89,25 @@ func (p *Pointer) Encoded() string {
 		return ""
 	}
 
+	lines := make([]string, 0, len(p.Extensions)+len(p.Extra)+2)
+	for _, ext := range p.Extensions {
+		lines = append(lines, fmt.Sprintf("ext-%d-%s %s:%s\n", ext.Priority, ext.Name, ext.OidType, ext.Oid))
+	}
+	lines = append(lines, fmt.Sprintf("oid %s:%s\n", p.OidType, p.Oid))
+	lines = append(lines, fmt.Sprintf("size %d\n", p.Size))
+	for _, kv := range p.Extra {
+		lines = append(lines, fmt.Sprintf("%s %s\n", kv.Key, kv.Value))
+	}
+
+	// Every key after the version is written in sorted order. Keys never
+	// contain a space, so sorting whole lines sorts them by key.
+	sort.Strings(lines)
+
 	var buffer bytes.Buffer
 	buffer.WriteString(fmt.Sprintf("version %s\n", latest))
-	for _, ext := range p.Extensions {
-		buffer.WriteString(fmt.Sprintf("ext-%d-%s %s:%s\n", ext.Priority, ext.Name, ext.OidType, ext.Oid))
+	for _, line := range lines {
+		buffer.WriteString(line)
 	}
-	buffer.WriteString(fmt.Sprintf("oid %s:%s\n", p.OidType, p.Oid))
-	buffer.WriteString(fmt.Sprintf("size %d\n", p.Size))
 	return buffer.String()
 }
 
@@ -86,14 +117,14 @@ func EncodePointer(writer io.Writer, pointer *Pointer) (int, error) {
 
 func DecodePointerFromFile(file string) (*Pointer, error) {
 	// Check size before reading
-	stat, err := os.Stat(file)
+	stat, err := os.Stat(localstorage.LongPath(file))
 	if err != nil {
 		return nil, err
 	}
 	if stat.Size() > blobSizeCutoff {
 		return nil, newNotAPointerError(nil)
 	}
-	f, err := os.OpenFile(file, os.O_RDONLY, 0644)
+	f, err := os.OpenFile(localstorage.LongPath(file), os.O_RDONLY, 0644)
 	if err != nil {
 		return nil, err
 	}
@@ -118,6 +149,30 @@ func DecodeFrom(reader io.Reader) ([]byte, *Pointer, error) {
 	return output, p, err
 }
 
+// DecodePointerFromReader reads at most blobSizeCutoff bytes from the given
+// reader and tries to decode a pointer from them. The returned reader always
+// yields the full original content, including the bytes that were read, so
+// callers can pass through anything that isn't a pointer without buffering it.
+func DecodePointerFromReader(reader io.Reader) (*Pointer, io.Reader, error) {
+	// read one extra byte to tell a pointer-sized blob from the start of a
+	// bigger one
+	buf := make([]byte, blobSizeCutoff+1)
+	n, err := io.ReadFull(reader, buf)
+	buf = buf[0:n]
+	full := io.MultiReader(bytes.NewReader(buf), reader)
+
+	switch err {
+	case nil:
+		return nil, full, newNotAPointerError(errors.New("Pointer file too big"))
+	case io.EOF, io.ErrUnexpectedEOF:
+	default:
+		return nil, full, err
+	}
+
+	p, err := decodeKV(bytes.TrimSpace(buf))
+	return p, full, err
+}
+
 func verifyVersion(version string) error {
 	if len(version) == 0 {
 		return newNotAPointerError(errors.New("Missing version"))
@@ -133,7 +188,7 @@ func verifyVersion(version string) error {
 }
 
 func decodeKV(data []byte) (*Pointer, error) {
-	kvps, exts, err := decodeKVData(data)
+	kvps, exts, extra, err := decodeKVData(data)
 	if err != nil {
 		if IsBadPointerKeyError(err) {
 			badErr := err.(badPointerKeyError)
@@ -179,7 +234,13 @@ func decodeKV(data []byte) (*Pointer, error) {
 		sort.Sort(ByPriority(extensions))
 	}
 
-	return NewPointer(oid, size, extensions), nil
+	p := NewPointer(oid, size, extensions)
+	for key, value := range extra {
+		p.Extra = append(p.Extra, &PointerKeyValue{key, value})
+	}
+	sort.Sort(ByKey(p.Extra))
+
+	return p, nil
 }
 
 func parseOid(value string) (string, error) {
@@ -229,7 +290,7 @@ func validatePointerExtensions(exts []*PointerExtension) error {
 	return nil
 }
 
-func decodeKVData(data []byte) (kvps map[string]string, exts map[string]string, err error) {
+func decodeKVData(data []byte) (kvps map[string]string, exts map[string]string, extra map[string]string, err error) {
 	kvps = make(map[string]string)
 
 	if !matcherRE.Match(data) {
@@ -240,6 +301,7 @@ func decodeKVData(data []byte) (kvps map[string]string, exts map[string]string,
 	scanner := bufio.NewScanner(bytes.NewBuffer(data))
 	line := 0
 	numKeys := len(pointerKeys)
+	lastPriority := -1
 	for scanner.Scan() {
 		text := scanner.Text()
 		if len(text) == 0 {
@@ -255,16 +317,31 @@ func decodeKVData(data []byte) (kvps map[string]string, exts map[string]string,
 		key := parts[0]
 		value := parts[1]
 
-		if numKeys <= line {
-			err = fmt.Errorf("Extra line: %s", text)
+		if line < numKeys && key == pointerKeys[line] {
+			line += 1
+			kvps[key] = value
+			continue
+		}
+
+		// the version must always come first
+		if line == 0 {
+			err = newBadPointerKeyError(pointerKeys[line], key)
 			return
 		}
 
-		if expected := pointerKeys[line]; key != expected {
+		if strings.HasPrefix(key, "ext-") {
 			if !extRE.Match([]byte(key)) {
-				err = newBadPointerKeyError(expected, key)
+				err = fmt.Errorf("Invalid extension key: %s", key)
 				return
 			}
+			// extensions are written in priority order, and extRE
+			// only allows one digit
+			priority := int(key[4] - '0')
+			if priority <= lastPriority {
+				err = fmt.Errorf("Extension out of order: %s", key)
+				return
+			}
+			lastPriority = priority
 			if exts == nil {
 				exts = make(map[string]string)
 			}
@@ -272,10 +349,36 @@ func decodeKVData(data []byte) (kvps map[string]string, exts map[string]string,
 			continue
 		}
 
-		line += 1
-		kvps[key] = value
+		if _, known := kvps[key]; known || isPointerKey(key) {
+			err = fmt.Errorf("Unexpected key: %s", text)
+			return
+		}
+
+		if !keyRE.MatchString(key) {
+			err = fmt.Errorf("Invalid key: %s", key)
+			return
+		}
+
+		if _, dup := extra[key]; dup {
+			err = fmt.Errorf("Duplicate key: %s", key)
+			return
+		}
+
+		if extra == nil {
+			extra = make(map[string]string)
+		}
+		extra[key] = value
 	}
 
 	err = scanner.Err()
 	return
 }
+
+func isPointerKey(key string) bool {
+	for _, k := range pointerKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -8,17 +8,30 @@ import (
 	"os"
 )
 
-type cleanedAsset struct {
+// A CleanedAsset is the pointer for some content and the temp file holding it,
+// ready to be ingested into the local storage.
+type CleanedAsset struct {
 	Filename string
 	*Pointer
 }
 
-func PointerClean(reader io.Reader, fileName string, fileSize int64, cb CopyCallback) (*cleanedAsset, error) {
+// PointerClean copies the content from reader to a temp file, hashing it on
+// the way, so the pointer is known without reading the temp file back. Any
+// configured extensions are given the name of the file being cleaned, which is
+// the reader's Name(), as for an *os.File. See NamedReader.
+func PointerClean(reader io.Reader, fileSize int64, cb CopyCallback) (*CleanedAsset, error) {
 	extensions, err := SortExtensions(Config.Extensions())
 	if err != nil {
 		return nil, err
 	}
 
+	var fileName string
+	if named, ok := reader.(interface {
+		Name() string
+	}); ok {
+		fileName = named.Name()
+	}
+
 	var oid string
 	var size int64
 	var tmp *os.File
@@ -53,7 +66,7 @@ func PointerClean(reader io.Reader, fileName string, fileSize int64, cb CopyCall
 	}
 
 	pointer := NewPointer(oid, size, exts)
-	return &cleanedAsset{tmp.Name(), pointer}, err
+	return &CleanedAsset{tmp.Name(), pointer}, err
 }
 
 func copyToTemp(reader io.Reader, fileSize int64, cb CopyCallback) (oid string, size int64, tmp *os.File, err error) {
@@ -88,6 +101,22 @@ func copyToTemp(reader io.Reader, fileSize int64, cb CopyCallback) (oid string,
 	return
 }
 
-func (a *cleanedAsset) Teardown() error {
+// NamedReader returns a reader of the content of the file fileName from r, for
+// PointerClean.
+func NamedReader(r io.Reader, fileName string) io.Reader {
+	return &namedReader{r, fileName}
+}
+
+type namedReader struct {
+	io.Reader
+	name string
+}
+
+func (r *namedReader) Name() string {
+	return r.name
+}
+
+func (a *CleanedAsset) Teardown() error {
+	untrackTempFile(a.Filename)
 	return os.Remove(a.Filename)
 }
@@ -0,0 +1,83 @@
+package lfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestCleanedObjectIsVerifiedOnIngest(t *testing.T) {
+	_, _, cleanup := setupCloneTest(t, "")
+	defer cleanup()
+
+	oldTempDir := TempDir
+	TempDir = objects.TempDir
+	defer func() {
+		TempDir = oldTempDir
+		checkedTempDir = ""
+	}()
+
+	cleaned, err := PointerClean(NamedReader(strings.NewReader("cleaned content"), "a.dat"), 15, nil)
+	if err != nil {
+		t.Fatalf("Unable to clean: %s", err)
+	}
+
+	// the temp file is damaged after the clean filter hashed it
+	assert.Equal(t, nil, os.Truncate(cleaned.Filename, 3))
+
+	err = IngestObject(cleaned.Filename, cleaned.Oid, cleaned.Size, true)
+	if err == nil || !strings.Contains(err.Error(), "to be 15 bytes, got 3 bytes") {
+		t.Fatalf("Expected a size mismatch, got %v", err)
+	}
+
+	_, err = os.Stat(LocalMediaPathReadOnly(cleaned.Oid))
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+// cleanBenchmarkSize is the size of the synthetic file BenchmarkPointerClean
+// reads.
+const cleanBenchmarkSize = 1024 * 1024 * 1024
+
+// syntheticReader yields a repeating byte pattern without holding it in memory.
+type syntheticReader struct{}
+
+func (syntheticReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(i)
+	}
+	return len(p), nil
+}
+
+func setupCleanBenchmark(b *testing.B) func() {
+	dir, err := ioutil.TempDir("", "lfs-clean")
+	if err != nil {
+		b.Fatalf("Unable to create temp dir: %s", err)
+	}
+
+	oldTempDir := TempDir
+	TempDir = dir
+	b.SetBytes(cleanBenchmarkSize)
+	b.ResetTimer()
+	return func() {
+		TempDir = oldTempDir
+		checkedTempDir = ""
+		os.RemoveAll(dir)
+	}
+}
+
+func BenchmarkPointerClean(b *testing.B) {
+	defer setupCleanBenchmark(b)()
+
+	for i := 0; i < b.N; i++ {
+		reader := io.LimitReader(syntheticReader{}, cleanBenchmarkSize)
+		cleaned, err := PointerClean(NamedReader(reader, "big.dat"), cleanBenchmarkSize, nil)
+		if err != nil {
+			b.Fatalf("Unable to clean: %s", err)
+		}
+		cleaned.Teardown()
+	}
+}
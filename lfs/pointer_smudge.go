@@ -4,19 +4,78 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 
-	"github.com/github/git-lfs/vendor/_nuts/github.com/cheggaaa/pb"
+	"github.com/github/git-lfs/localstorage"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
 )
 
+// cloneFileByPaths and linkFile can be replaced in tests to simulate
+// unsupported filesystems
+var (
+	cloneFileByPaths = CloneFileByPaths
+	linkFile         = os.Link
+)
+
+// PointerSmudgeToFile writes the content of ptr to filename. The content is
+// written to a temp file next to it first and renamed into place, so the file
+// never appears half written, even with several written at once. If the object
+// isn't local and download is false, the pointer itself is written instead and
+// a download declined error is returned.
 func PointerSmudgeToFile(filename string, ptr *Pointer, download bool, cb CopyCallback) error {
-	os.MkdirAll(filepath.Dir(filename), 0755)
-	file, err := os.Create(filename)
+	os.MkdirAll(localstorage.LongPath(filepath.Dir(filename)), 0755)
+	tmpName := workingTempName(filename)
+	trackTempFile(tmpName)
+	// it's renamed into place or removed by the time this returns
+	defer untrackTempFile(tmpName)
+
+	err := smudgeToTempFile(tmpName, filename, ptr, download, cb)
+	if err != nil && !IsDownloadDeclinedError(err) {
+		os.Remove(tmpName)
+		return err
+	}
+
+	// keep the mode of a file being replaced, eg if it's executable
+	if stat, statErr := os.Stat(localstorage.LongPath(filename)); statErr == nil {
+		if tmpStat, tmpErr := os.Stat(tmpName); tmpErr == nil && tmpStat.Mode() != stat.Mode() {
+			if linkErr := breakObjectLink(tmpName, ptr.Oid); linkErr != nil {
+				os.Remove(tmpName)
+				return fmt.Errorf("Could not write working directory file: %v", linkErr)
+			}
+			os.Chmod(tmpName, stat.Mode())
+		}
+	}
+
+	if renameErr := os.Rename(tmpName, localstorage.LongPath(filename)); renameErr != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("Could not write working directory file: %v", renameErr)
+	}
+	return err
+}
+
+var workingTempCount uint32
+
+// workingTempName returns a unique name for a temp file in the same directory
+// as filename, so it can be renamed over it.
+func workingTempName(filename string) string {
+	n := atomic.AddUint32(&workingTempCount, 1)
+	tmp := fmt.Sprintf(".lfs-%s-%d-%d.tmp", filepath.Base(filename), os.Getpid(), n)
+	return localstorage.LongPath(filepath.Join(filepath.Dir(filename), tmp))
+}
+
+func smudgeToTempFile(tmpName, filename string, ptr *Pointer, download bool, cb CopyCallback) error {
+	if linkObjectToFile(tmpName, ptr) {
+		if cb != nil {
+			cb(ptr.Size, ptr.Size, 0)
+		}
+		return nil
+	}
+
+	file, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return fmt.Errorf("Could not create working directory file: %v", err)
 	}
@@ -34,18 +93,70 @@ func PointerSmudgeToFile(filename string, ptr *Pointer, download bool, cb CopyCa
 	return nil
 }
 
+// linkObjectToFile tries to write the local object for ptr to filename without
+// copying it, as lfs.checkoutmode says, which is instant and takes no extra disk
+// space. A copy-on-write clone shares nothing writable with the stored object,
+// but a hard link is the stored object, so it mustn't be changed in place (see
+// breakObjectLink). Returns false if the file needs to be written normally.
+func linkObjectToFile(filename string, ptr *Pointer) bool {
+	mode := Config.CheckoutMode()
+	if len(ptr.Extensions) > 0 || ptr.Size == 0 || mode == "copy" {
+		return false
+	}
+
+	mediafile := LocalMediaPathReadOnly(ptr.Oid)
+	if !FileExistsOfSize(mediafile, ptr.Size) {
+		return false
+	}
+
+	var linked bool
+	var err error
+	if mode == "hardlink" {
+		err = linkFile(localstorage.LongPath(mediafile), filename)
+		linked = err == nil
+	} else {
+		linked, err = cloneFileByPaths(filename, mediafile)
+	}
+
+	if err != nil {
+		tracerx.Printf("Unable to %s %s to %s, copying instead: %s", mode, mediafile, filename, err)
+	}
+	if linked {
+		recordObjectAccess(ptr.Oid)
+	}
+	return linked
+}
+
+// breakObjectLink gives path, a working tree file, its own copy of its content
+// if it's hard linked to the stored object oid, so that it can be changed, even
+// just its mode, without changing the object.
+func breakObjectLink(path, oid string) error {
+	stat, err := os.Stat(localstorage.LongPath(path))
+	if err != nil {
+		return err
+	}
+
+	objStat, err := os.Stat(localstorage.LongPath(LocalMediaPathReadOnly(oid)))
+	if err != nil || !os.SameFile(stat, objStat) {
+		return nil
+	}
+
+	tracerx.Printf("Copying %s to break its hard link to object %s", path, oid)
+	return localstorage.BreakHardLink(path)
+}
+
 func PointerSmudge(writer io.Writer, ptr *Pointer, workingfile string, download bool, cb CopyCallback) error {
 	mediafile, err := LocalMediaPath(ptr.Oid)
 	if err != nil {
 		return err
 	}
 
-	stat, statErr := os.Stat(mediafile)
+	stat, statErr := os.Stat(localstorage.LongPath(mediafile))
 	if statErr == nil && stat != nil {
 		fileSize := stat.Size()
 		if fileSize == 0 || fileSize != ptr.Size {
 			tracerx.Printf("Removing %s, size %d is invalid", mediafile, fileSize)
-			os.RemoveAll(mediafile)
+			os.RemoveAll(localstorage.LongPath(mediafile))
 			stat = nil
 		}
 	}
@@ -75,12 +186,12 @@ func PointerSmudgeObject(ptr *Pointer, obj *ObjectResource, cb CopyCallback) err
 		return err
 	}
 
-	stat, statErr := os.Stat(mediafile)
+	stat, statErr := os.Stat(localstorage.LongPath(mediafile))
 	if statErr == nil && stat != nil {
 		fileSize := stat.Size()
 		if fileSize == 0 || fileSize != obj.Size {
 			tracerx.Printf("Removing %s, size %d is invalid", mediafile, fileSize)
-			os.RemoveAll(mediafile)
+			os.RemoveAll(localstorage.LongPath(mediafile))
 			stat = nil
 		}
 	}
@@ -118,7 +229,7 @@ func downloadObject(ptr *Pointer, obj *ObjectResource, mediafile string, cb Copy
 }
 
 func downloadFile(writer io.Writer, ptr *Pointer, workingfile, mediafile string, cb CopyCallback) error {
-	fmt.Fprintf(os.Stderr, "Downloading %s (%s)\n", workingfile, pb.FormatBytes(ptr.Size))
+	fmt.Fprintf(os.Stderr, "Downloading %s (%s)\n", workingfile, FormatSize(ptr.Size))
 	reader, size, err := Download(filepath.Base(mediafile), ptr.Size)
 	if reader != nil {
 		defer reader.Close()
@@ -148,16 +259,17 @@ func downloadFile(writer io.Writer, ptr *Pointer, workingfile, mediafile string,
 // filename - Absolute path to a file to write, with the filename a 64 character
 //            SHA-256 hex signature.
 // reader   - Any io.Reader
-// size     - Expected byte size of the content. Used for the progress bar in
-//            the optional CopyCallback.
+// size     - Expected byte size of the content, which is verified. Also used
+//            for the progress bar in the optional CopyCallback.
 // cb       - Optional CopyCallback object for providing download progress to
 //            external Git LFS tools.
 func bufferDownloadedFile(filename string, reader io.Reader, size int64, cb CopyCallback) error {
 	oid := filepath.Base(filename)
-	f, err := ioutil.TempFile(LocalObjectTempDir, oid+"-")
+	f, err := ioutil.TempFile(localstorage.LongPath(LocalObjectTempDir), oid+"-")
 	if err != nil {
 		return fmt.Errorf("cannot create temp file: %v", err)
 	}
+	trackTempFile(f.Name())
 
 	defer func() {
 		if err != nil {
@@ -166,13 +278,12 @@ func bufferDownloadedFile(filename string, reader io.Reader, size int64, cb Copy
 		}
 	}()
 
-	hasher := newHashingReader(reader)
-
 	// ensure we always close f. Note that this does not conflict with  the
 	// close below, as close is idempotent.
 	defer f.Close()
 	name := f.Name()
-	written, err := CopyWithCallback(f, hasher, size, cb)
+	hasher := sha256.New()
+	written, err := CopyWithCallback(io.MultiWriter(f, hasher), reader, size, cb)
 	if err != nil {
 		return fmt.Errorf("cannot write data to tempfile %q: %v", name, err)
 	}
@@ -180,38 +291,27 @@ func bufferDownloadedFile(filename string, reader io.Reader, size int64, cb Copy
 		return fmt.Errorf("can't close tempfile %q: %v", name, err)
 	}
 
-	if actual := hasher.Hash(); actual != oid {
-		return fmt.Errorf("Expected OID %s, got %s after %d bytes written", oid, actual, written)
-	}
-
-	// get the file mode from the original file and use that for the replacement
-	// file, too.
-	info, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		// no original file
-	} else if err != nil {
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != oid {
+		err = fmt.Errorf("Expected OID %s, got %s after %d bytes written", oid, actual, written)
 		return err
-	} else {
-		if err := os.Chmod(name, info.Mode()); err != nil {
-			return fmt.Errorf("can't set filemode on tempfile %q: %v", name, err)
-		}
 	}
 
-	if err := os.Rename(name, filename); err != nil {
+	// hashed as it was written, so the storage layer only checks the size
+	if err = IngestObject(name, oid, size, true); err != nil {
 		return fmt.Errorf("cannot replace %q with tempfile %q: %v", filename, name, err)
 	}
 	return nil
 }
 
 func readLocalFile(writer io.Writer, ptr *Pointer, mediafile string, workingfile string, cb CopyCallback) error {
-	reader, err := os.Open(mediafile)
+	reader, err := os.Open(localstorage.LongPath(mediafile))
 	if err != nil {
 		return Errorf(err, "Error opening media file.")
 	}
 	defer reader.Close()
 
 	if ptr.Size == 0 {
-		if stat, _ := os.Stat(mediafile); stat != nil {
+		if stat, _ := os.Stat(localstorage.LongPath(mediafile)); stat != nil {
 			ptr.Size = stat.Size()
 		}
 	}
@@ -284,30 +384,6 @@ func readLocalFile(writer io.Writer, ptr *Pointer, mediafile string, workingfile
 		return Errorf(err, "Error reading from media file: %s", err)
 	}
 
+	recordObjectAccess(ptr.Oid)
 	return nil
 }
-
-type hashingReader struct {
-	reader io.Reader
-	hasher hash.Hash
-}
-
-func newHashingReader(r io.Reader) *hashingReader {
-	return &hashingReader{r, sha256.New()}
-}
-
-func (r *hashingReader) Hash() string {
-	return hex.EncodeToString(r.hasher.Sum(nil))
-}
-
-func (r *hashingReader) Read(b []byte) (int, error) {
-	w, err := r.reader.Read(b)
-	if err == nil || err == io.EOF {
-		_, e := r.hasher.Write(b[0:w])
-		if e != nil && err == nil {
-			return w, e
-		}
-	}
-
-	return w, err
-}
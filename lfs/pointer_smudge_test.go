@@ -0,0 +1,222 @@
+package lfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+// setupCloneTest stores content as a local object in a temp storage dir and
+// returns a pointer to it, plus a dir to write working files to.
+func setupCloneTest(t *testing.T, content string) (*Pointer, string, func()) {
+	dir, err := ioutil.TempDir("", "lfs-clone")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+
+	oldObjects := objects
+	objects, err = localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+	mediafile, err := LocalMediaPath(oid)
+	if err != nil {
+		t.Fatalf("Unable to get media path: %s", err)
+	}
+	if err := ioutil.WriteFile(mediafile, []byte(content), 0644); err != nil {
+		t.Fatalf("Unable to write object: %s", err)
+	}
+
+	return NewPointer(oid, int64(len(content)), nil), filepath.Join(dir, "work"), func() {
+		objects = oldObjects
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPointerSmudgeToFileClones(t *testing.T) {
+	ptr, workDir, cleanup := setupCloneTest(t, "cloned content")
+	defer cleanup()
+
+	// skip on filesystems without copy-on-write support
+	probe := filepath.Join(workDir, "probe")
+	os.MkdirAll(workDir, 0755)
+	if ok, _ := CloneFileByPaths(probe, LocalMediaPathReadOnly(ptr.Oid)); !ok {
+		t.Skip("filesystem does not support cloning files")
+	}
+
+	filename := filepath.Join(workDir, "a.dat")
+	err := PointerSmudgeToFile(filename, ptr, false, nil)
+	assert.Equal(t, nil, err)
+
+	by, err := ioutil.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "cloned content", string(by))
+
+	// editing the working copy must not affect the object
+	err = ioutil.WriteFile(filename, []byte("edited"), 0644)
+	assert.Equal(t, nil, err)
+	by, err = ioutil.ReadFile(LocalMediaPathReadOnly(ptr.Oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "cloned content", string(by))
+}
+
+func TestPointerSmudgeToFileCloneFallback(t *testing.T) {
+	ptr, workDir, cleanup := setupCloneTest(t, "copied content")
+	defer cleanup()
+
+	called := 0
+	oldClone := cloneFileByPaths
+	cloneFileByPaths = func(dst, src string) (bool, error) {
+		called++
+		return false, syscall.EXDEV
+	}
+	defer func() { cloneFileByPaths = oldClone }()
+
+	filename := filepath.Join(workDir, "a.dat")
+	err := PointerSmudgeToFile(filename, ptr, false, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, called)
+
+	by, err := ioutil.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "copied content", string(by))
+}
+
+func TestPointerSmudgeToFileNoClone(t *testing.T) {
+	ptr, workDir, cleanup := setupCloneTest(t, "copied content")
+	defer cleanup()
+
+	oldConfig := Config
+	Config = &Configuration{gitConfig: map[string]string{"lfs.noclone": "true"}}
+	defer func() { Config = oldConfig }()
+
+	called := 0
+	oldClone := cloneFileByPaths
+	cloneFileByPaths = func(dst, src string) (bool, error) {
+		called++
+		return true, nil
+	}
+	defer func() { cloneFileByPaths = oldClone }()
+
+	filename := filepath.Join(workDir, "a.dat")
+	err := PointerSmudgeToFile(filename, ptr, false, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, called)
+
+	by, err := ioutil.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "copied content", string(by))
+}
+
+func TestPointerSmudgeToFileHardLinks(t *testing.T) {
+	ptr, workDir, cleanup := setupCloneTest(t, "linked content")
+	defer cleanup()
+
+	oldConfig := Config
+	Config = &Configuration{gitConfig: map[string]string{"lfs.checkoutmode": "hardlink"}}
+	defer func() { Config = oldConfig }()
+
+	filename := filepath.Join(workDir, "a.dat")
+	err := PointerSmudgeToFile(filename, ptr, false, nil)
+	assert.Equal(t, nil, err)
+
+	by, err := ioutil.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "linked content", string(by))
+
+	stat, err := os.Stat(filename)
+	assert.Equal(t, nil, err)
+	objStat, err := os.Stat(LocalMediaPathReadOnly(ptr.Oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, os.SameFile(stat, objStat))
+
+	// making it executable copies it first, leaving the object alone
+	err = setFileMode(filename, ptr.Oid, "100755")
+	assert.Equal(t, nil, err)
+
+	stat, err = os.Stat(filename)
+	assert.Equal(t, nil, err)
+	objStat, err = os.Stat(LocalMediaPathReadOnly(ptr.Oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, os.SameFile(stat, objStat))
+	assert.Equal(t, os.FileMode(0644), objStat.Mode().Perm())
+
+	by, err = ioutil.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "linked content", string(by))
+}
+
+func TestPointerSmudgeToFileHardLinkFallback(t *testing.T) {
+	ptr, workDir, cleanup := setupCloneTest(t, "copied content")
+	defer cleanup()
+
+	oldConfig := Config
+	Config = &Configuration{gitConfig: map[string]string{"lfs.checkoutmode": "hardlink"}}
+	defer func() { Config = oldConfig }()
+
+	called := 0
+	oldLink := linkFile
+	linkFile = func(oldname, newname string) error {
+		called++
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	defer func() { linkFile = oldLink }()
+
+	filename := filepath.Join(workDir, "a.dat")
+	err := PointerSmudgeToFile(filename, ptr, false, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, called)
+
+	by, err := ioutil.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "copied content", string(by))
+
+	stat, err := os.Stat(filename)
+	assert.Equal(t, nil, err)
+	objStat, err := os.Stat(LocalMediaPathReadOnly(ptr.Oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, os.SameFile(stat, objStat))
+}
+
+func TestBufferDownloadedFileRejectsWrongContent(t *testing.T) {
+	ptr, _, cleanup := setupCloneTest(t, "expected content")
+	defer cleanup()
+
+	oldTempDir := LocalObjectTempDir
+	LocalObjectTempDir = objects.TempDir
+	defer func() { LocalObjectTempDir = oldTempDir }()
+
+	mediafile := LocalMediaPathReadOnly(ptr.Oid)
+	os.Remove(mediafile)
+
+	err := bufferDownloadedFile(mediafile, strings.NewReader("tampered content"), ptr.Size, nil)
+	if err == nil || !strings.Contains(err.Error(), "Expected OID "+ptr.Oid) {
+		t.Fatalf("Expected a hash mismatch, got %v", err)
+	}
+
+	_, err = os.Stat(mediafile)
+	assert.Equal(t, true, os.IsNotExist(err))
+	leftover, _ := ioutil.ReadDir(objects.TempDir)
+	assert.Equal(t, 0, len(leftover))
+}
+
+func TestPointerSmudgeToFileForgetsTempFile(t *testing.T) {
+	ptr, workDir, cleanup := setupCloneTest(t, "written content")
+	defer cleanup()
+
+	before := len(runTempFiles)
+	err := PointerSmudgeToFile(filepath.Join(workDir, "a.dat"), ptr, false, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, before, len(runTempFiles))
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"io/ioutil"
 	"reflect"
 	"strings"
 	"testing"
@@ -126,32 +127,38 @@ size 12345`
 	assertEqualWithExample(t, ex, "sha256", p.Extensions[2].OidType)
 }
 
-func TestDecodeExtensionsSort(t *testing.T) {
+func TestDecodeExtraKeys(t *testing.T) {
 	ex := `version https://git-lfs.github.com/spec/v1
-ext-2-baz sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
 ext-0-foo sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff
-ext-1-bar sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb
 oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
-size 12345`
+x-checksum-crc32c 8a9136aa
+size 12345
+a.b-c value with spaces
+`
 
 	p, err := DecodePointer(bytes.NewBufferString(ex))
 	assertEqualWithExample(t, ex, nil, err)
-	assertEqualWithExample(t, ex, latest, p.Version)
 	assertEqualWithExample(t, ex, "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393", p.Oid)
 	assertEqualWithExample(t, ex, int64(12345), p.Size)
-	assertEqualWithExample(t, ex, "sha256", p.OidType)
-	assertEqualWithExample(t, ex, "foo", p.Extensions[0].Name)
-	assertEqualWithExample(t, ex, 0, p.Extensions[0].Priority)
-	assertEqualWithExample(t, ex, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", p.Extensions[0].Oid)
-	assertEqualWithExample(t, ex, "sha256", p.Extensions[0].OidType)
-	assertEqualWithExample(t, ex, "bar", p.Extensions[1].Name)
-	assertEqualWithExample(t, ex, 1, p.Extensions[1].Priority)
-	assertEqualWithExample(t, ex, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", p.Extensions[1].Oid)
-	assertEqualWithExample(t, ex, "sha256", p.Extensions[1].OidType)
-	assertEqualWithExample(t, ex, "baz", p.Extensions[2].Name)
-	assertEqualWithExample(t, ex, 2, p.Extensions[2].Priority)
-	assertEqualWithExample(t, ex, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", p.Extensions[2].Oid)
-	assertEqualWithExample(t, ex, "sha256", p.Extensions[2].OidType)
+	assertEqualWithExample(t, ex, 1, len(p.Extensions))
+	assertEqualWithExample(t, ex, 2, len(p.Extra))
+	assertEqualWithExample(t, ex, "a.b-c", p.Extra[0].Key)
+	assertEqualWithExample(t, ex, "value with spaces", p.Extra[0].Value)
+	assertEqualWithExample(t, ex, "x-checksum-crc32c", p.Extra[1].Key)
+	assertEqualWithExample(t, ex, "8a9136aa", p.Extra[1].Value)
+
+	encoded := p.Encoded()
+	assert.Equal(t, `version https://git-lfs.github.com/spec/v1
+a.b-c value with spaces
+ext-0-foo sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+x-checksum-crc32c 8a9136aa
+`, encoded)
+
+	p2, err := DecodePointer(bytes.NewBufferString(encoded))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, encoded, p2.Encoded())
 }
 
 func TestDecodePreRelease(t *testing.T) {
@@ -182,6 +189,83 @@ func TestDecodeFromEmptyReader(t *testing.T) {
 	}
 }
 
+func TestDecodePointerFromReader(t *testing.T) {
+	ex := NewPointer("4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393", 12345, nil).Encoded()
+
+	p, r, err := DecodePointerFromReader(strings.NewReader(ex))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ex, p.Encoded())
+	by, err := ioutil.ReadAll(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ex, string(by))
+}
+
+func TestDecodePointerFromReaderAtCutoff(t *testing.T) {
+	base := NewPointer("4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393", 12345, nil).Encoded()
+	padded := func(size int) string {
+		// "x-pad " and the trailing newline take up 7 bytes
+		return base + "x-pad " + strings.Repeat("a", size-len(base)-7) + "\n"
+	}
+
+	ex := padded(blobSizeCutoff)
+	assert.Equal(t, blobSizeCutoff, len(ex))
+	p, r, err := DecodePointerFromReader(strings.NewReader(ex))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ex, p.Encoded())
+	by, err := ioutil.ReadAll(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ex, string(by))
+
+	ex = padded(blobSizeCutoff + 1)
+	p, r, err = DecodePointerFromReader(strings.NewReader(ex))
+	assert.Equal(t, true, IsNotAPointerError(err))
+	assert.Equal(t, (*Pointer)(nil), p)
+	by, err = ioutil.ReadAll(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ex, string(by))
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestDecodePointerFromReaderLargeBinary(t *testing.T) {
+	data := make([]byte, 4*1024*1024)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	cr := &countingReader{r: bytes.NewReader(data)}
+	p, r, err := DecodePointerFromReader(cr)
+	assert.Equal(t, true, IsNotAPointerError(err))
+	assert.Equal(t, (*Pointer)(nil), p)
+	assert.Equal(t, blobSizeCutoff+1, cr.n)
+
+	var out bytes.Buffer
+	n, err := io.Copy(&out, r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, true, bytes.Equal(data, out.Bytes()))
+}
+
+func TestDecodePointerFromReaderNotAPointer(t *testing.T) {
+	for _, ex := range []string{"", "wat\n", "version \n"} {
+		p, r, err := DecodePointerFromReader(strings.NewReader(ex))
+		assert.Equal(t, true, IsNotAPointerError(err))
+		assert.Equal(t, (*Pointer)(nil), p)
+		by, err := ioutil.ReadAll(r)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, ex, string(by))
+	}
+}
+
 func TestDecodeInvalid(t *testing.T) {
 	examples := []string{
 		"invalid stuff",
@@ -231,12 +315,37 @@ size=fif`,
 oid=sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
 size=fif`,
 
-		// extra key
+		// extra key with invalid characters
+		`version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+Wat_wat wat`,
+
+		// extra key without a value
+		`version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+wat`,
+
+		// duplicate extra key
 		`version https://git-lfs.github.com/spec/v1
 oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
 size 12345
+wat wat
 wat wat`,
 
+		// duplicate size
+		`version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+size 12345`,
+
+		// extra key before version
+		`wat wat
+version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345`,
+
 		// keys out of order
 		`version https://git-lfs.github.com/spec/v1
 size 12345
@@ -259,6 +368,14 @@ size 12345`,
 ext-0-foo sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff
 ext-0-bar sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb
 oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345`,
+
+		// ext priorities out of order
+		`version https://git-lfs.github.com/spec/v1
+ext-2-baz sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
+ext-0-foo sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff
+ext-1-bar sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
 size 12345`,
 
 		// ext priority over 9
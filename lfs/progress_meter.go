@@ -15,24 +15,46 @@ import (
 )
 
 // ProgressMeter provides a progress bar type output for the TransferQueue. It
-// is given an estimated file count and size up front and tracks the number of
-// files and bytes transferred as well as the number of files and bytes that
-// get skipped because the transfer is unnecessary.
+// is given an estimated file count and size, up front or as the files are
+// found (see Grow), and tracks the number of files and bytes transferred as
+// well as the number of files and bytes that get skipped because the transfer
+// is unnecessary.
 type ProgressMeter struct {
 	finishedFiles     int64 // int64s must come first for struct alignment
 	skippedFiles      int64
+	erroredFiles      int64
 	transferringFiles int64
+	estimatedFiles    int64
 	estimatedBytes    int64
 	currentBytes      int64
 	skippedBytes      int64
 	started           int32
-	estimatedFiles    int
 	startTime         time.Time
 	finished          chan interface{}
 	logger            *progressLogger
-	fileIndex         map[string]int64 // Maps a file name to its transfer number
+	fileIndex         map[string]*fileProgress // Maps a file name to its GIT_LFS_PROGRESS state
 	fileIndexMutex    *sync.Mutex
+	logInterval       time.Duration // how often to log a file's progress to GIT_LFS_PROGRESS
 	dryRun            bool
+	quiet             bool // only write to the GIT_LFS_PROGRESS log
+	isTerminal        bool
+	out               io.Writer
+	rate              *rateCalculator
+	lineInterval      time.Duration // how often to write a line, if !isTerminal
+	lastLine          time.Time     // when a line was last written, if !isTerminal
+}
+
+// How often a file's progress is written to GIT_LFS_PROGRESS, at most. Its
+// start, completion and failure are always written.
+const progressLogInterval = 100 * time.Millisecond
+
+// fileProgress is the last progress of a file, for the GIT_LFS_PROGRESS log.
+type fileProgress struct {
+	index     int64 // the file's transfer number
+	direction string
+	read      int64
+	total     int64
+	logged    time.Time // when a line was last written for it
 }
 
 // NewProgressMeter creates a new ProgressMeter for the number and size of
@@ -46,12 +68,18 @@ func NewProgressMeter(estFiles int, estBytes int64, dryRun bool) *ProgressMeter
 	return &ProgressMeter{
 		logger:         logger,
 		startTime:      time.Now(),
-		fileIndex:      make(map[string]int64),
+		fileIndex:      make(map[string]*fileProgress),
 		fileIndexMutex: &sync.Mutex{},
+		logInterval:    progressLogInterval,
 		finished:       make(chan interface{}),
-		estimatedFiles: estFiles,
+		estimatedFiles: int64(estFiles),
 		estimatedBytes: estBytes,
 		dryRun:         dryRun,
+		quiet:          Config.NoProgress,
+		isTerminal:     Config.ForceProgress() || isTerminal(os.Stderr),
+		out:            os.Stderr,
+		rate:           newRateCalculator(rateWindow),
+		lineInterval:   Config.ProgressInterval(),
 	}
 }
 
@@ -61,15 +89,28 @@ func (p *ProgressMeter) Start() {
 	}
 }
 
+// Grow adds to the estimated file count and size, for when the files to be
+// transferred are still being found while the first ones transfer.
+func (p *ProgressMeter) Grow(files int, bytes int64) {
+	atomic.AddInt64(&p.estimatedFiles, int64(files))
+	atomic.AddInt64(&p.estimatedBytes, bytes)
+}
+
 // Add tells the progress meter that a transferring file is being added to the
 // TransferQueue.
 func (p *ProgressMeter) Add(name string) {
 	idx := atomic.AddInt64(&p.transferringFiles, 1)
 	p.fileIndexMutex.Lock()
-	p.fileIndex[name] = idx
+	p.fileIndex[name] = &fileProgress{index: idx}
 	p.fileIndexMutex.Unlock()
 }
 
+// StartTransfer tells the progress meter that a file of size `size` has
+// started transferring.
+func (p *ProgressMeter) StartTransfer(direction, name string, size int64) {
+	p.logBytes(direction, name, 0, size, true)
+}
+
 // Skip tells the progress meter that a file of size `size` is being skipped
 // because the transfer is unnecessary.
 func (p *ProgressMeter) Skip(size int64) {
@@ -80,38 +121,92 @@ func (p *ProgressMeter) Skip(size int64) {
 // TransferBytes increments the number of bytes transferred
 func (p *ProgressMeter) TransferBytes(direction, name string, read, total int64, current int) {
 	atomic.AddInt64(&p.currentBytes, int64(current))
-	p.logBytes(direction, name, read, total)
+	p.logBytes(direction, name, read, total, read == total)
 }
 
 // FinishTransfer increments the finished transfer count
 func (p *ProgressMeter) FinishTransfer(name string) {
 	atomic.AddInt64(&p.finishedFiles, 1)
+	if f := p.forget(name); f != nil && len(f.direction) > 0 && (f.read < f.total || f.logged.IsZero()) {
+		// The adapter didn't report the last of the bytes
+		p.logLine(f.direction, f.index, f.total, f.total, name)
+	}
+}
+
+// RetryTransfer tells the progress meter that a file will be transferred
+// again, and is added again then.
+func (p *ProgressMeter) RetryTransfer(name string) {
+	p.forget(name)
+}
+
+// FailTransfer increments the errored transfer count
+func (p *ProgressMeter) FailTransfer(name string) {
+	atomic.AddInt64(&p.erroredFiles, 1)
+	if f := p.forget(name); f != nil && len(f.direction) > 0 {
+		p.logLine(f.direction+"-failed", f.index, f.read, f.total, name)
+	}
+}
+
+func (p *ProgressMeter) forget(name string) *fileProgress {
 	p.fileIndexMutex.Lock()
+	defer p.fileIndexMutex.Unlock()
+	f := p.fileIndex[name]
 	delete(p.fileIndex, name)
-	p.fileIndexMutex.Unlock()
+	return f
 }
 
-// Finish shuts down the ProgressMeter
+// Finish shuts down the ProgressMeter, leaving a summary of the totals
 func (p *ProgressMeter) Finish() {
 	close(p.finished)
-	p.update()
 	p.logger.Close()
-	if !p.dryRun && p.estimatedBytes > 0 {
-		fmt.Fprintf(os.Stdout, "\n")
+	if p.dryRun || p.quiet || atomic.LoadInt64(&p.estimatedFiles) == 0 {
+		return
+	}
+
+	out := p.summary(time.Since(p.startTime))
+	if p.isTerminal {
+		fmt.Fprintf(p.out, "\r%s\n", padRight(out, terminalWidth()))
+	} else {
+		fmt.Fprintf(p.out, "%s\n", out)
 	}
 }
 
-func (p *ProgressMeter) logBytes(direction, name string, read, total int64) {
+// logBytes writes a file's progress to the GIT_LFS_PROGRESS log, if it's been
+// logInterval since the last line for it, or always is set.
+func (p *ProgressMeter) logBytes(direction, name string, read, total int64, always bool) {
+	now := time.Now()
 	p.fileIndexMutex.Lock()
-	idx := p.fileIndex[name]
+	f, ok := p.fileIndex[name]
+	if !ok {
+		f = &fileProgress{}
+		p.fileIndex[name] = f
+	}
+	f.direction, f.read, f.total = direction, read, total
+	if !always && now.Sub(f.logged) < p.logInterval {
+		p.fileIndexMutex.Unlock()
+		return
+	}
+	f.logged = now
+	idx := f.index
 	p.fileIndexMutex.Unlock()
-	line := fmt.Sprintf("%s %d/%d %d/%d %s\n", direction, idx, p.estimatedFiles, read, total, name)
+
+	p.logLine(direction, idx, read, total, name)
+}
+
+func (p *ProgressMeter) logLine(direction string, idx, read, total int64, name string) {
+	line := fmt.Sprintf("%s %d/%d %d/%d %s\n", direction, idx, atomic.LoadInt64(&p.estimatedFiles), read, total, name)
 	if err := p.logger.Write([]byte(line)); err != nil {
+		// Stop writing, it mustn't fail the transfers
+		fmt.Fprintf(os.Stderr, "Error writing Git LFS %s progress to %s: %s\n", direction, p.logger.log.Name(), err)
 		p.logger.Shutdown()
 	}
 }
 
 func (p *ProgressMeter) writer() {
+	defer recoverAsError("updating the progress meter", func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+	})
+
 	p.update()
 	for {
 		select {
@@ -124,34 +219,159 @@ func (p *ProgressMeter) writer() {
 }
 
 func (p *ProgressMeter) update() {
-	if p.dryRun || p.estimatedFiles == 0 {
+	p.updateAt(time.Now())
+}
+
+func (p *ProgressMeter) updateAt(now time.Time) {
+	if p.dryRun || p.quiet || atomic.LoadInt64(&p.estimatedFiles) == 0 {
 		return
 	}
 
-	width := 80 // default to 80 chars wide if ts.GetSize() fails
-	size, err := ts.GetSize()
-	if err == nil {
-		width = size.Col()
+	p.rate.Add(now, atomic.LoadInt64(&p.currentBytes))
+	out := p.progress(p.rate.Rate())
+
+	if p.isTerminal {
+		fmt.Fprintf(p.out, "\r%s", padRight(out, terminalWidth()))
+		return
+	}
+
+	// Not a terminal, eg a CI log, so \r won't rewrite the line. Write a plain
+	// line every so often instead.
+	if p.lastLine.IsZero() || now.Sub(p.lastLine) >= p.lineInterval {
+		p.lastLine = now
+		fmt.Fprintf(p.out, "%s\n", out)
+	}
+}
+
+// progress formats the current state of the transfers, eg:
+// Git LFS: (412 of 1103 files, 15 skipped) 2.40 GB / 6.50 GB, 37%, 48.00 MB/s, eta 1m32s
+func (p *ProgressMeter) progress(rate float64) string {
+	out := p.counts()
+
+	estimatedBytes := atomic.LoadInt64(&p.estimatedBytes)
+	done := atomic.LoadInt64(&p.currentBytes) + atomic.LoadInt64(&p.skippedBytes)
+	if estimatedBytes > 0 {
+		out += fmt.Sprintf(", %d%%", done*100/estimatedBytes)
+	}
+
+	if rate > 0 {
+		out += fmt.Sprintf(", %s/s", FormatSize(int64(rate)))
+		if remaining := estimatedBytes - done; remaining > 0 {
+			eta := time.Duration(float64(remaining)/rate) * time.Second
+			out += fmt.Sprintf(", eta %s", formatDuration(eta))
+		}
+	}
+
+	return out
+}
+
+// summary formats the final totals once all transfers are done, eg:
+// Git LFS: (1103 of 1103 files, 15 skipped) 6.50 GB / 6.50 GB in 2m11s
+func (p *ProgressMeter) summary(elapsed time.Duration) string {
+	return fmt.Sprintf("%s in %s", p.counts(), formatDuration(elapsed))
+}
+
+// counts formats the file and byte counts common to all progress lines.
+// Skipped and failed counts only show when > 0.
+func (p *ProgressMeter) counts() string {
+	out := fmt.Sprintf("Git LFS: (%d of %d files", atomic.LoadInt64(&p.finishedFiles), atomic.LoadInt64(&p.estimatedFiles))
+	if skipped := atomic.LoadInt64(&p.skippedFiles); skipped > 0 {
+		out += fmt.Sprintf(", %d skipped", skipped)
 	}
+	if errored := atomic.LoadInt64(&p.erroredFiles); errored > 0 {
+		out += fmt.Sprintf(", %d failed", errored)
+	}
+	out += fmt.Sprintf(") %s / %s", FormatSize(atomic.LoadInt64(&p.currentBytes)), FormatSize(atomic.LoadInt64(&p.estimatedBytes)))
+	if skippedBytes := atomic.LoadInt64(&p.skippedBytes); skippedBytes > 0 {
+		out += fmt.Sprintf(", %s skipped", FormatSize(skippedBytes))
+	}
+	return out
+}
+
+// How far back the transfer rate is averaged over
+const rateWindow = 10 * time.Second
+
+// rateCalculator smooths the transfer rate by averaging it over a sliding
+// window of recent samples, so the rate and ETA don't jump about with every
+// chunk transferred.
+type rateCalculator struct {
+	window  time.Duration
+	samples []rateSample
+}
+
+type rateSample struct {
+	at    time.Time
+	bytes int64 // total bytes transferred at this time
+}
+
+func newRateCalculator(window time.Duration) *rateCalculator {
+	return &rateCalculator{window: window}
+}
 
-	// (%d of %d files, %d skipped) %f B / %f B, %f B skipped
-	// skipped counts only show when > 0
+// Add records that bytes had been transferred in total at time at.
+func (r *rateCalculator) Add(at time.Time, bytes int64) {
+	r.samples = append(r.samples, rateSample{at, bytes})
 
-	out := fmt.Sprintf("\rGit LFS: (%d of %d files", p.finishedFiles, p.estimatedFiles)
-	if p.skippedFiles > 0 {
-		out += fmt.Sprintf(", %d skipped", p.skippedFiles)
+	// Drop samples which are out of the window, but keep the last of them so
+	// the window is always covered
+	cutoff := at.Add(-r.window)
+	drop := 0
+	for drop+1 < len(r.samples) && !r.samples[drop+1].at.After(cutoff) {
+		drop++
 	}
-	out += fmt.Sprintf(") %s / %s", formatBytes(p.currentBytes), formatBytes(p.estimatedBytes))
-	if p.skippedBytes > 0 {
-		out += fmt.Sprintf(", %s skipped", formatBytes(p.skippedBytes))
+	r.samples = r.samples[drop:]
+}
+
+// Rate returns the average number of bytes per second over the window, or 0
+// if it's not known yet.
+func (r *rateCalculator) Rate() float64 {
+	if len(r.samples) < 2 {
+		return 0
 	}
 
-	padlen := width - len(out)
-	if 0 < padlen {
-		out += strings.Repeat(" ", padlen)
+	first := r.samples[0]
+	last := r.samples[len(r.samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
 	}
+	return float64(last.bytes-first.bytes) / elapsed
+}
 
-	fmt.Fprintf(os.Stdout, out)
+// formatDuration formats d to the second in a compact form, eg 1h02m, 1m32s
+// or 45s.
+func formatDuration(d time.Duration) string {
+	secs := int64((d + time.Second/2) / time.Second)
+	switch {
+	case secs >= 3600:
+		return fmt.Sprintf("%dh%02dm", secs/3600, (secs%3600)/60)
+	case secs >= 60:
+		return fmt.Sprintf("%dm%02ds", secs/60, secs%60)
+	}
+	return fmt.Sprintf("%ds", secs)
+}
+
+func terminalWidth() int {
+	width := 80 // default to 80 chars wide if ts.GetSize() fails
+	size, err := ts.GetSize()
+	if err == nil {
+		width = size.Col()
+	}
+	return width
+}
+
+func padRight(s string, width int) string {
+	if padlen := width - len(s); padlen > 0 {
+		return s + strings.Repeat(" ", padlen)
+	}
+	return s
+}
+
+// isTerminal returns whether f is a terminal (or console) rather than a file or
+// pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
 
 // progressLogger provides a wrapper around an os.File that can either
@@ -161,13 +381,13 @@ type progressLogger struct {
 	log       *os.File
 }
 
-// Write will write to the file and perform a Sync() if writing succeeds.
+// Write will write to the file. Writes to an os.File aren't buffered, so
+// each line can be read as soon as it's written. It isn't synced, as a FIFO
+// can't be.
 func (l *progressLogger) Write(b []byte) error {
 	if l.writeData {
-		if _, err := l.log.Write(b); err != nil {
-			return err
-		}
-		return l.log.Sync()
+		_, err := l.log.Write(b)
+		return err
 	}
 	return nil
 }
@@ -213,25 +433,11 @@ func newProgressLogger() (*progressLogger, error) {
 	return &progressLogger{true, file}, nil
 }
 
-func formatBytes(i int64) string {
-	switch {
-	case i > 1099511627776:
-		return fmt.Sprintf("%#0.2f TB", float64(i)/1099511627776)
-	case i > 1073741824:
-		return fmt.Sprintf("%#0.2f GB", float64(i)/1073741824)
-	case i > 1048576:
-		return fmt.Sprintf("%#0.2f MB", float64(i)/1048576)
-	case i > 1024:
-		return fmt.Sprintf("%#0.2f KB", float64(i)/1024)
-	}
-
-	return fmt.Sprintf("%d B", i)
-}
-
 // Indeterminate progress indicator 'spinner'
 type Spinner struct {
-	stage int
-	msg   string
+	stage      int
+	msg        string
+	isTerminal bool // if not, only the finish message is written
 }
 
 var spinnerChars = []byte{'|', '/', '-', '\\'}
@@ -244,6 +450,9 @@ func (s *Spinner) Print(out io.Writer, msg string) {
 
 // Just spin the spinner one more notch & use the last message
 func (s *Spinner) Spin(out io.Writer) {
+	if !s.isTerminal {
+		return
+	}
 	s.stage = (s.stage + 1) % len(spinnerChars)
 	s.update(out, string(spinnerChars[s.stage]), s.msg)
 }
@@ -260,6 +469,10 @@ func (s *Spinner) Finish(out io.Writer, finishMsg string) {
 	} else {
 		sym = fmt.Sprintf("%c", '\u2714')
 	}
+	if !s.isTerminal {
+		fmt.Fprintf(out, "%v %v\n", sym, finishMsg)
+		return
+	}
 	s.update(out, sym, finishMsg)
 	out.Write([]byte{'\n'})
 }
@@ -267,18 +480,10 @@ func (s *Spinner) Finish(out io.Writer, finishMsg string) {
 func (s *Spinner) update(out io.Writer, prefix, msg string) {
 
 	str := fmt.Sprintf("%v %v", prefix, msg)
-
-	width := 80 // default to 80 chars wide if ts.GetSize() fails
-	size, err := ts.GetSize()
-	if err == nil {
-		width = size.Col()
-	}
-	padding := strings.Repeat(" ", width-len(str))
-
-	fmt.Fprintf(out, "\r%v%v", str, padding)
+	fmt.Fprintf(out, "\r%v", padRight(str, terminalWidth()))
 
 }
 
 func NewSpinner() *Spinner {
-	return &Spinner{}
+	return &Spinner{isTerminal: Config.ForceProgress() || isTerminal(os.Stdout)}
 }
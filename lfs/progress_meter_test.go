@@ -0,0 +1,188 @@
+package lfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestProgressMeterProgress(t *testing.T) {
+	p := &ProgressMeter{
+		estimatedFiles: 1103,
+		estimatedBytes: 7 * 1073741824,
+		finishedFiles:  412,
+		currentBytes:   2 * 1073741824,
+		skippedFiles:   15,
+		skippedBytes:   1610612736,
+	}
+
+	assert.Equal(t, "Git LFS: (412 of 1103 files, 15 skipped) 2.00 GiB / 7.00 GiB, 1.50 GiB skipped, 50%",
+		p.progress(0))
+
+	// 3.5 GB left at 32 MB/s
+	assert.Equal(t, "Git LFS: (412 of 1103 files, 15 skipped) 2.00 GiB / 7.00 GiB, 1.50 GiB skipped, 50%, 32.0 MiB/s, eta 1m52s",
+		p.progress(32*1048576))
+
+	p.erroredFiles = 2
+	assert.Equal(t, "Git LFS: (412 of 1103 files, 15 skipped, 2 failed) 2.00 GiB / 7.00 GiB, 1.50 GiB skipped in 1h02m",
+		p.summary(62*time.Minute+10*time.Second))
+}
+
+func TestProgressMeterSummary(t *testing.T) {
+	p := &ProgressMeter{
+		estimatedFiles: 1,
+		estimatedBytes: 10,
+		finishedFiles:  1,
+		currentBytes:   10,
+	}
+
+	assert.Equal(t, "Git LFS: (1 of 1 files) 10 B / 10 B in 3s", p.summary(2600*time.Millisecond))
+}
+
+func TestRateCalculatorSmoothing(t *testing.T) {
+	start := time.Unix(1000000, 0)
+	r := newRateCalculator(10 * time.Second)
+	assert.Equal(t, float64(0), r.Rate())
+
+	r.Add(start, 0)
+	assert.Equal(t, float64(0), r.Rate())
+
+	// a steady 100 B/s, with a burst in the middle
+	bytes := int64(0)
+	for i := 1; i <= 10; i++ {
+		bytes += 100
+		if i == 5 {
+			bytes += 1000
+		}
+		r.Add(start.Add(time.Duration(i)*time.Second), bytes)
+	}
+	assert.Equal(t, float64(200), r.Rate())
+
+	// the burst drops out of the window after 10s
+	for i := 11; i <= 20; i++ {
+		bytes += 100
+		r.Add(start.Add(time.Duration(i)*time.Second), bytes)
+	}
+	assert.Equal(t, float64(100), r.Rate())
+	assert.Equal(t, 11, len(r.samples))
+
+	// stalled
+	r.Add(start.Add(30*time.Second), bytes)
+	assert.Equal(t, float64(0), r.Rate())
+}
+
+func TestFormatDuration(t *testing.T) {
+	assert.Equal(t, "0s", formatDuration(0))
+	assert.Equal(t, "45s", formatDuration(45*time.Second))
+	assert.Equal(t, "1m32s", formatDuration(92*time.Second))
+	assert.Equal(t, "1h02m", formatDuration(62*time.Minute+30*time.Second))
+}
+
+func newTestProgressMeter(out *bytes.Buffer, isTerminal bool) *ProgressMeter {
+	return &ProgressMeter{
+		estimatedFiles: 5000,
+		estimatedBytes: 5000 * 1024,
+		startTime:      time.Now(),
+		finished:       make(chan interface{}),
+		logger:         &progressLogger{},
+		fileIndex:      make(map[string]*fileProgress),
+		fileIndexMutex: &sync.Mutex{},
+		logInterval:    progressLogInterval,
+		isTerminal:     isTerminal,
+		out:            out,
+		rate:           newRateCalculator(rateWindow),
+		lineInterval:   10 * time.Second,
+	}
+}
+
+// simulate 5000 small transfers over a minute, redrawing every 200ms
+func simulateProgress(p *ProgressMeter) {
+	start := time.Unix(1000000, 0)
+	for i := 0; i < 300; i++ {
+		for j := 0; j < 17 && p.finishedFiles < 5000; j++ {
+			name := fmt.Sprintf("file%d", p.finishedFiles)
+			p.Add(name)
+			p.TransferBytes("download", name, 1024, 1024, 1024)
+			p.FinishTransfer(name)
+		}
+		p.updateAt(start.Add(time.Duration(i) * 200 * time.Millisecond))
+	}
+	p.Finish()
+}
+
+func TestProgressMeterNotTerminal(t *testing.T) {
+	var out bytes.Buffer
+	simulateProgress(newTestProgressMeter(&out, false))
+
+	assert.Equal(t, -1, strings.Index(out.String(), "\r"))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	// one line every 10s over 1 minute, plus the summary
+	assert.Equal(t, 7, len(lines))
+	assert.Equal(t, true, strings.HasPrefix(lines[6], "Git LFS: (5000 of 5000 files) 4.88 MiB / 4.88 MiB in "))
+}
+
+func TestProgressMeterTerminal(t *testing.T) {
+	var out bytes.Buffer
+	simulateProgress(newTestProgressMeter(&out, true))
+
+	assert.Equal(t, 301, strings.Count(out.String(), "\r"))
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+}
+
+func TestProgressMeterQuiet(t *testing.T) {
+	var out bytes.Buffer
+	p := newTestProgressMeter(&out, false)
+	p.quiet = true
+	simulateProgress(p)
+
+	assert.Equal(t, "", out.String())
+}
+
+func TestProgressMeterLog(t *testing.T) {
+	file, err := ioutil.TempFile("", "lfs-progress")
+	assert.Equal(t, nil, err)
+	defer os.Remove(file.Name())
+
+	var out bytes.Buffer
+	p := newTestProgressMeter(&out, false)
+	p.estimatedFiles = 3
+	p.logger = &progressLogger{true, file}
+	p.logInterval = time.Hour
+
+	// only the start and completion are logged within the interval
+	p.Add("a.dat")
+	p.StartTransfer("upload", "a.dat", 300)
+	p.TransferBytes("upload", "a.dat", 100, 300, 100)
+	p.TransferBytes("upload", "a.dat", 200, 300, 100)
+	p.TransferBytes("upload", "a.dat", 300, 300, 100)
+	p.FinishTransfer("a.dat")
+
+	p.Add("b.dat")
+	p.StartTransfer("upload", "b.dat", 500)
+	p.TransferBytes("upload", "b.dat", 100, 500, 100)
+	p.FailTransfer("b.dat")
+
+	// an adapter which doesn't report its progress still completes
+	p.Add("c.dat")
+	p.StartTransfer("upload", "c.dat", 50)
+	p.FinishTransfer("c.dat")
+	p.Finish()
+
+	log, err := ioutil.ReadFile(file.Name())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{
+		"upload 1/3 0/300 a.dat",
+		"upload 1/3 300/300 a.dat",
+		"upload 2/3 0/500 b.dat",
+		"upload-failed 2/3 100/500 b.dat",
+		"upload 3/3 0/50 c.dat",
+		"upload 3/3 50/50 c.dat",
+	}, strings.Split(strings.TrimSpace(string(log)), "\n"))
+}
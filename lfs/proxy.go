@@ -0,0 +1,142 @@
+package lfs
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// proxyFromGitConfigOrEnvironment is the Proxy func of the HTTP transport. See
+// ProxyForUrl. SOCKS5 proxies are dialed by the transport itself, so they
+// aren't returned.
+func proxyFromGitConfigOrEnvironment(req *http.Request) (*url.URL, error) {
+	proxy, _, err := ProxyForUrl(req.URL)
+	if proxy != nil && isSocksProxy(proxy) {
+		return nil, err
+	}
+	return proxy, err
+}
+
+// ProxyForUrl returns the proxy to make requests to u through, or nil to
+// connect directly, along with the setting it comes from. Like git, it uses
+// http.<url>.proxy or http.proxy, then HTTPS_PROXY or HTTP_PROXY for https
+// urls and HTTP_PROXY for http urls, in either case. Hosts in NO_PROXY, and
+// localhost, aren't proxied, nor is anything if http.proxy is set to "".
+// Proxies may be http://, https:// or socks5://
+// urls, with any credentials in the url, and default to http://.
+func ProxyForUrl(u *url.URL) (proxy *url.URL, source string, err error) {
+	rawurl, source := proxyConfigForUrl(u)
+	if len(rawurl) == 0 || noProxyForUrl(u) {
+		return nil, "", nil
+	}
+
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "http://" + rawurl
+	}
+
+	proxy, err = url.Parse(rawurl)
+	if err != nil || len(proxy.Host) == 0 {
+		return nil, source, fmt.Errorf("Invalid proxy %q from %s", rawurl, source)
+	}
+
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return proxy, source, nil
+	}
+	return nil, source, fmt.Errorf("Unsupported proxy %q from %s, use a http://, https:// or socks5:// url", rawurl, source)
+}
+
+func proxyConfigForUrl(u *url.URL) (rawurl, source string) {
+	// an empty http.proxy turns the proxy off
+	if proxy, ok := httpConfigFor(u.Scheme, u.Host, "proxy"); ok {
+		return proxy, "http.proxy"
+	}
+
+	names := []string{"HTTP_PROXY", "http_proxy"}
+	if u.Scheme == "https" {
+		names = append([]string{"HTTPS_PROXY", "https_proxy"}, names...)
+	}
+
+	for _, name := range names {
+		if proxy := Config.Getenv(name); len(proxy) > 0 {
+			return proxy, name
+		}
+	}
+	return "", ""
+}
+
+// noProxyForUrl returns whether u is on localhost or matches an entry in
+// NO_PROXY, a list of hosts separated by commas or spaces. "*" matches every
+// host, "example.com" and ".example.com" match example.com and its
+// subdomains, "10.0.0.0/8" matches ips in that range, and an entry with a
+// port only matches that port.
+func noProxyForUrl(u *url.URL) bool {
+	host, port := splitUrlHostPort(u)
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return true
+	}
+
+	noProxy := Config.Getenv("NO_PROXY")
+	if len(noProxy) == 0 {
+		noProxy = Config.Getenv("no_proxy")
+	}
+
+	entries := strings.FieldsFunc(noProxy, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+
+	for _, entry := range entries {
+		if entry == "*" {
+			return true
+		}
+
+		if _, ipnet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipnet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if len(entryPort) > 0 && entryPort != port {
+			continue
+		}
+
+		entryHost = strings.ToLower(strings.Trim(entryHost, "[]"))
+		entryHost = strings.TrimPrefix(strings.TrimPrefix(entryHost, "*"), ".")
+		if len(entryHost) == 0 {
+			continue
+		}
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitUrlHostPort returns the lower case host of u, without brackets for
+// IPv6 addresses, and its port, defaulting to the one for its scheme.
+func splitUrlHostPort(u *url.URL) (host, port string) {
+	host, port = u.Host, ""
+	if h, p, err := net.SplitHostPort(u.Host); err == nil {
+		host, port = h, p
+	}
+
+	if len(port) == 0 {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "http":
+			port = "80"
+		}
+	}
+
+	return strings.ToLower(strings.Trim(host, "[]")), port
+}
@@ -0,0 +1,162 @@
+package lfs
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+)
+
+var (
+	// retryBaseDelay is how long the first retry waits. It doubles for each
+	// retry after that, up to retryMaxDelay.
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+
+	retryRand      = rand.New(rand.NewSource(time.Now().UnixNano()))
+	retryRandMutex sync.Mutex
+
+	// rateLimitUntil is when the server last asked for requests to resume,
+	// see throttleRequests.
+	rateLimitUntil time.Time
+	rateLimitMutex sync.Mutex
+)
+
+// retryBackoff returns how long to wait before the given retry, counting from
+// 1. The delay is picked at random from the upper half of the exponential
+// backoff, so many clients failing at once don't all come back together.
+func retryBackoff(retry int) time.Duration {
+	d := retryBaseDelay
+	for i := 1; i < retry && d < retryMaxDelay; i++ {
+		d *= 2
+	}
+	if d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+
+	half := int64(d / 2)
+	if half < 1 {
+		return d
+	}
+
+	retryRandMutex.Lock()
+	jitter := retryRand.Int63n(half + 1)
+	retryRandMutex.Unlock()
+
+	return time.Duration(half + jitter)
+}
+
+// retryAfter returns how long a 429 or 503 response asked to wait before
+// retrying, from its Retry-After header in either delta-seconds or HTTP-date
+// form. The wait is capped at lfs.transfer.maxretryafter. ok is false if the
+// response isn't a rate limit, or has no valid header.
+func retryAfter(res *http.Response, now time.Time) (d time.Duration, ok bool) {
+	if res == nil || (res.StatusCode != 429 && res.StatusCode != 503) {
+		return 0, false
+	}
+
+	value := strings.TrimSpace(res.Header.Get("Retry-After"))
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		d = time.Duration(secs) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		d = date.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+	} else {
+		return 0, false
+	}
+
+	if max := Config.TransferMaxRetryAfter(); d > max {
+		d = max
+	}
+	return d, true
+}
+
+// throttleRequests holds back every request made through doHttpRequest for d,
+// so one rate limited request doesn't leave the other transfer workers to trip
+// the limit again straight away.
+func throttleRequests(d time.Duration) {
+	until := time.Now().Add(d)
+
+	rateLimitMutex.Lock()
+	if until.After(rateLimitUntil) {
+		rateLimitUntil = until
+	}
+	rateLimitMutex.Unlock()
+}
+
+// waitForRateLimit waits until requests are no longer being throttled.
+func waitForRateLimit() {
+	rateLimitMutex.Lock()
+	d := rateLimitUntil.Sub(time.Now())
+	rateLimitMutex.Unlock()
+
+	if d > 0 {
+		tracerx.Printf("api: rate limited, waiting %s", d)
+		time.Sleep(d)
+	}
+}
+
+// isRetriableRequest reports whether a failed API request is safe to send
+// again: the server answered 429, 500, 502, 503 or 504, or the connection was
+// reset or timed out.
+func isRetriableRequest(res *http.Response, err error) bool {
+	if res != nil && res.StatusCode != 0 {
+		switch res.StatusCode {
+		case 429, 500, 502, 503, 504:
+			return true
+		}
+		return false
+	}
+
+	return isRetriableNetError(err)
+}
+
+// isRetriableNetError reports whether err, or an error it wraps, is a
+// connection reset or a timeout.
+func isRetriableNetError(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case syscall.Errno:
+			return e == syscall.ECONNRESET || e == syscall.ETIMEDOUT
+		case net.Error:
+			if e.Timeout() {
+				return true
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the connection was closed before a response was read
+			return true
+		}
+
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		default:
+			err = GetInnerError(err)
+		}
+	}
+	return false
+}
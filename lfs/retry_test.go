@@ -0,0 +1,249 @@
+package lfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	for retry, max := range []time.Duration{250, 500, 1000, 2000} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			d := retryBackoff(retry + 1)
+			if d < max/2 || d > max {
+				t.Errorf("retry %d: expected a delay between %s and %s, got %s", retry+1, max/2, max, d)
+			}
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		if d := retryBackoff(100); d < retryMaxDelay/2 || d > retryMaxDelay {
+			t.Errorf("expected the delay to be capped at %s, got %s", retryMaxDelay, d)
+		}
+	}
+}
+
+func TestIsRetriableRequest(t *testing.T) {
+	reset := &url.Error{Op: "Post", URL: "https://example.com", Err: &net.OpError{
+		Op: "read", Net: "tcp", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET},
+	}}
+	refused := &url.Error{Op: "Post", URL: "https://example.com", Err: &net.OpError{
+		Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED},
+	}}
+	closed := &url.Error{Op: "Post", URL: "https://example.com", Err: io.EOF}
+
+	assert.Equal(t, true, isRetriableRequest(nil, newRetriableError(reset)))
+	assert.Equal(t, true, isRetriableRequest(&http.Response{}, Error(closed)))
+	assert.Equal(t, true, isRetriableRequest(nil, timeoutError{}))
+	assert.Equal(t, false, isRetriableRequest(nil, refused))
+	assert.Equal(t, false, isRetriableRequest(nil, errors.New("welp")))
+
+	for _, status := range []int{429, 500, 502, 503, 504} {
+		assert.Equal(t, true, isRetriableRequest(&http.Response{StatusCode: status}, errors.New("welp")))
+	}
+	for _, status := range []int{401, 403, 404, 422, 501, 509} {
+		assert.Equal(t, false, isRetriableRequest(&http.Response{StatusCode: status}, errors.New("welp")))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	defer Config.ResetConfig()
+	now := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		status   int
+		header   string
+		expected time.Duration
+		ok       bool
+	}{
+		{429, "5", 5 * time.Second, true},
+		{503, " 0 ", 0, true},
+		{429, "Sun, 01 May 2016 12:00:20 GMT", 20 * time.Second, true},
+		{503, "Sunday, 01-May-16 12:00:10 GMT", 10 * time.Second, true},
+		{429, "Sun, 01 May 2016 11:59:00 GMT", 0, true},
+		{429, "3600", 30 * time.Second, true},
+		{429, "-5", 0, false},
+		{429, "soon", 0, false},
+		{429, "", 0, false},
+		{500, "5", 0, false},
+	}
+
+	for _, test := range tests {
+		res := &http.Response{StatusCode: test.status, Header: make(http.Header)}
+		if len(test.header) > 0 {
+			res.Header.Set("Retry-After", test.header)
+		}
+
+		d, ok := retryAfter(res, now)
+		if d != test.expected || ok != test.ok {
+			t.Errorf("%d with Retry-After %q: expected %s, %v, got %s, %v", test.status, test.header, test.expected, test.ok, d, ok)
+		}
+	}
+
+	Config.SetConfig("lfs.transfer.maxretryafter", "3600")
+	res := &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": []string{"3600"}}}
+	d, _ := retryAfter(res, now)
+	assert.Equal(t, time.Hour, d)
+}
+
+type timeoutError struct{}
+
+func (e timeoutError) Error() string   { return "i/o timeout" }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+func TestBatchRetriesServerErrors(t *testing.T) {
+	var calls int
+	server := newFlakyBatchServer(t, &calls, 502, 503)
+	defer server.Close()
+	defer stubRetries(server.URL, "3")()
+
+	objs, _, err := Batch([]*ObjectResource{&ObjectResource{Oid: "a", Size: 1}}, "upload", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 1, len(objs))
+	assert.Equal(t, "a", objs[0].Oid)
+}
+
+func TestBatchGivesUpAfterMaxRetries(t *testing.T) {
+	var calls int
+	server := newFlakyBatchServer(t, &calls, 502, 502, 502, 502)
+	defer server.Close()
+	defer stubRetries(server.URL, "2")()
+
+	_, _, err := Batch([]*ObjectResource{&ObjectResource{Oid: "a", Size: 1}}, "upload", nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, true, strings.HasSuffix(err.Error(), "(gave up after 2 retries)"))
+	assert.Equal(t, false, IsRetriableError(err))
+}
+
+func TestBatchDoesNotRetryClientErrors(t *testing.T) {
+	var calls int
+	server := newFlakyBatchServer(t, &calls, 422)
+	defer server.Close()
+	defer stubRetries(server.URL, "3")()
+
+	_, _, err := Batch([]*ObjectResource{&ObjectResource{Oid: "a", Size: 1}}, "upload", nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, false, strings.Contains(err.Error(), "gave up"))
+}
+
+func TestBatchHonorsRetryAfter(t *testing.T) {
+	var calls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/media/objects/batch", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(429)
+		case 2:
+			w.Header().Set("Retry-After", time.Now().Add(2*time.Second).UTC().Format(http.TimeFormat))
+			w.WriteHeader(503)
+		default:
+			w.Header().Set("Content-Type", mediaType)
+			w.WriteHeader(200)
+			w.Write([]byte(`{"objects":[{"oid":"a","size":1}]}`))
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer stubRetries(server.URL, "2")()
+
+	start := time.Now()
+	objs, _, err := Batch([]*ObjectResource{&ObjectResource{Oid: "a", Size: 1}}, "upload", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 1, len(objs))
+
+	// at least a second for the 429, and at least another for the 503
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("expected the Retry-After headers to be waited for, only took %s", elapsed)
+	}
+}
+
+func TestRateLimitThrottlesOtherRequests(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/limited", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(429)
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/limited", nil)
+	_, err := doHttpRequest(req, nil)
+	d, ok := retryAfterOf(err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, time.Second, d)
+	assert.Equal(t, true, IsRetriableError(err))
+
+	start := time.Now()
+	req, _ = http.NewRequest("GET", server.URL+"/ok", nil)
+	res, err := doHttpRequest(req, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 200, res.StatusCode)
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("expected the next request to wait for the rate limit, only took %s", elapsed)
+	}
+}
+
+// newFlakyBatchServer returns a batch API server which responds with each of
+// the failing statuses in turn, and then succeeds.
+func newFlakyBatchServer(t *testing.T, calls *int, failures ...int) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/media/objects/batch", func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if *calls <= len(failures) {
+			w.WriteHeader(failures[*calls-1])
+			return
+		}
+
+		var req struct {
+			Objects []*ObjectResource `json:"objects"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		w.Header().Set("Content-Type", mediaType)
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(map[string]interface{}{"objects": req.Objects})
+	})
+	return httptest.NewServer(mux)
+}
+
+// stubRetries points the API at url, allowing the given number of retries
+// without waiting between them. It returns a func to restore the config.
+func stubRetries(url, retries string) func() {
+	oldDelay := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	Config.SetConfig("lfs.url", url+"/media")
+	Config.SetConfig("lfs.transfer.maxretries", retries)
+
+	return func() {
+		retryBaseDelay = oldDelay
+		Config.ResetConfig()
+	}
+}
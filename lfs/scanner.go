@@ -15,6 +15,8 @@ import (
 	"time"
 
 	"github.com/github/git-lfs/git"
+	"github.com/github/git-lfs/subprocess"
+	"github.com/github/git-lfs/trace"
 	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
 )
 
@@ -51,6 +53,7 @@ type WrappedPointer struct {
 	SrcName string
 	Size    int64
 	Status  string
+	Mode    string // file mode in the tree or index, when scanned from one
 	*Pointer
 }
 
@@ -77,28 +80,10 @@ type ScanRefsOptions struct {
 	ScanMode         ScanningMode
 	RemoteName       string
 	SkipDeletedBlobs bool
-	nameMap          map[string]string
-	mutex            *sync.Mutex
-}
-
-func (o *ScanRefsOptions) GetName(sha string) (string, bool) {
-	o.mutex.Lock()
-	name, ok := o.nameMap[sha]
-	o.mutex.Unlock()
-	return name, ok
-}
-
-func (o *ScanRefsOptions) SetName(sha, name string) {
-	o.mutex.Lock()
-	o.nameMap[sha] = name
-	o.mutex.Unlock()
 }
 
 func NewScanRefsOptions() *ScanRefsOptions {
-	return &ScanRefsOptions{
-		nameMap: make(map[string]string, 0),
-		mutex:   &sync.Mutex{},
-	}
+	return &ScanRefsOptions{}
 }
 
 // ScanRefs takes a ref and returns a slice of WrappedPointer objects
@@ -135,39 +120,31 @@ func ScanRefsToChan(refLeft, refRight string, opt *ScanRefsOptions) (*PointerCha
 		tracerx.PerformanceSince("scan", start)
 	}()
 
+	// Each stage streams to the next, with the name of each blob passed along
+	// with it, so memory use doesn't grow with the size of the history
 	revs, err := revListShas(refLeft, refRight, opt)
 	if err != nil {
 		return nil, err
 	}
 
-	smallShas, err := catFileBatchCheck(revs)
-	if err != nil {
-		return nil, err
-	}
-
-	pointers, err := catFileBatch(smallShas)
+	smallBlobs, err := catFileBatchCheck(revs)
 	if err != nil {
 		return nil, err
 	}
 
-	retchan := make(chan *WrappedPointer, chanBufSize)
-	errchan := make(chan error, 1)
-	go func() {
-		for p := range pointers.Results {
-			if name, ok := opt.GetName(p.Sha1); ok {
-				p.Name = name
-			}
-			retchan <- p
-		}
-		err := pointers.Wait()
-		if err != nil {
-			errchan <- err
-		}
-		close(retchan)
-		close(errchan)
-	}()
+	return catFileBatchTree(smallBlobs)
+}
 
-	return NewPointerChannelWrapper(retchan, errchan), nil
+// ScanLeftToRemote returns the Git LFS pointers reachable from left that
+// aren't reachable from any of remoteName's refs, which are the objects a push
+// of left to it may need to upload. remoteName can be left blank to mean 'any
+// remote'. Everything reachable from left is returned if the remote has no refs
+// yet.
+func ScanLeftToRemote(left, remoteName string) ([]*WrappedPointer, error) {
+	opt := NewScanRefsOptions()
+	opt.ScanMode = ScanLeftToRemoteMode
+	opt.RemoteName = remoteName
+	return ScanRefs(left, "", opt)
 }
 
 type indexFileMap struct {
@@ -213,14 +190,18 @@ func ScanIndex() ([]*WrappedPointer, error) {
 	}
 
 	allRevsErr := make(chan error, 5) // can be multiple errors below
-	allRevsChan := make(chan string, 1)
-	allRevs := NewStringChannelWrapper(allRevsChan, allRevsErr)
+	allRevsChan := make(chan TreeBlob, 1)
+	allRevs := NewTreeBlobChannelWrapper(allRevsChan, allRevsErr)
 	go func() {
+		defer close(allRevsErr)
+		defer close(allRevsChan)
+		defer recoverAsError("scanning the index", scanPanicHandler(nil, allRevsErr))
+
 		seenRevs := make(map[string]bool, 0)
 
 		for rev := range revs.Results {
 			seenRevs[rev] = true
-			allRevsChan <- rev
+			allRevsChan <- TreeBlob{Sha1: rev}
 		}
 		err := revs.Wait()
 		if err != nil {
@@ -229,15 +210,13 @@ func ScanIndex() ([]*WrappedPointer, error) {
 
 		for rev := range cachedRevs.Results {
 			if _, ok := seenRevs[rev]; !ok {
-				allRevsChan <- rev
+				allRevsChan <- TreeBlob{Sha1: rev}
 			}
 		}
 		err = cachedRevs.Wait()
 		if err != nil {
 			allRevsErr <- err
 		}
-		close(allRevsChan)
-		close(allRevsErr)
 	}()
 
 	smallShas, err := catFileBatchCheck(allRevs)
@@ -245,7 +224,7 @@ func ScanIndex() ([]*WrappedPointer, error) {
 		return nil, err
 	}
 
-	pointerc, err := catFileBatch(smallShas)
+	pointerc, err := catFileBatchTree(smallShas)
 	if err != nil {
 		return nil, err
 	}
@@ -266,8 +245,13 @@ func ScanIndex() ([]*WrappedPointer, error) {
 }
 
 // Get additional arguments needed to limit 'git rev-list' to just the changes in revTo
-// that are also not on remoteName.
+// that are also not on remoteName, or on any remote if it's blank. If the remote
+// has no refs yet, that's everything reachable from refTo.
 func revListArgsRefVsRemote(refTo, remoteName string) []string {
+	if len(remoteName) == 0 {
+		return []string{refTo, "--not", "--remotes"}
+	}
+
 	// We need to check that the locally cached versions of remote refs are still
 	// present on the remote before we use them as a 'from' point. If the
 	// server implements garbage collection and a remote branch had been deleted
@@ -313,8 +297,8 @@ func revListArgsRefVsRemote(refTo, remoteName string) []string {
 
 // revListShas uses git rev-list to return the list of object sha1s
 // for the given ref. If all is true, ref is ignored. It returns a
-// channel from which sha1 strings can be read.
-func revListShas(refLeft, refRight string, opt *ScanRefsOptions) (*StringChannelWrapper, error) {
+// channel from which each object's sha1 and path, if it has one, can be read.
+func revListShas(refLeft, refRight string, opt *ScanRefsOptions) (*TreeBlobChannelWrapper, error) {
 	refArgs := []string{"rev-list", "--objects"}
 	switch opt.ScanMode {
 	case ScanRefsMode:
@@ -348,10 +332,14 @@ func revListShas(refLeft, refRight string, opt *ScanRefsOptions) (*StringChannel
 
 	cmd.Stdin.Close()
 
-	revs := make(chan string, chanBufSize)
+	revs := make(chan TreeBlob, chanBufSize)
 	errchan := make(chan error, 5) // may be multiple errors
 
 	go func() {
+		defer close(errchan)
+		defer close(revs)
+		defer recoverAsError("reading git rev-list output", scanPanicHandler(cmd, errchan))
+
 		scanner := bufio.NewScanner(cmd.Stdout)
 		for scanner.Scan() {
 			line := strings.TrimSpace(scanner.Text())
@@ -359,11 +347,11 @@ func revListShas(refLeft, refRight string, opt *ScanRefsOptions) (*StringChannel
 				continue
 			}
 
-			sha1 := line[0:40]
+			rev := TreeBlob{Sha1: line[0:40]}
 			if len(line) > 40 {
-				opt.SetName(sha1, line[41:len(line)])
+				rev.Filename = line[41:len(line)]
 			}
-			revs <- sha1
+			revs <- rev
 		}
 
 		stderr, _ := ioutil.ReadAll(cmd.Stderr)
@@ -379,11 +367,9 @@ func revListShas(refLeft, refRight string, opt *ScanRefsOptions) (*StringChannel
 				errchan <- fmt.Errorf("Error: ref %s is ambiguous", match[1])
 			}
 		}
-		close(revs)
-		close(errchan)
 	}()
 
-	return NewStringChannelWrapper(revs, errchan), nil
+	return NewTreeBlobChannelWrapper(revs, errchan), nil
 }
 
 // revListIndex uses git diff-index to return the list of object sha1s
@@ -407,6 +393,10 @@ func revListIndex(cache bool, indexMap *indexFileMap) (*StringChannelWrapper, er
 	errchan := make(chan error, 1)
 
 	go func() {
+		defer close(errchan)
+		defer close(revs)
+		defer recoverAsError("reading git diff-index output", scanPanicHandler(cmd, errchan))
+
 		scanner := bufio.NewScanner(cmd.Stdout)
 		for scanner.Scan() {
 			// Format is:
@@ -441,8 +431,6 @@ func revListIndex(cache bool, indexMap *indexFileMap) (*StringChannelWrapper, er
 		// 	errchan <- fmt.Errorf("Error in git diff-index: %v %v", err, string(stderr))
 		// }
 		cmd.Wait()
-		close(revs)
-		close(errchan)
 	}()
 
 	return NewStringChannelWrapper(revs, errchan), nil
@@ -451,22 +439,32 @@ func revListIndex(cache bool, indexMap *indexFileMap) (*StringChannelWrapper, er
 // catFileBatchCheck uses git cat-file --batch-check to get the type
 // and size of a git object. Any object that isn't of type blob and
 // under the blobSizeCutoff will be ignored. revs is a channel over
-// which strings containing git sha1s will be sent. It returns a channel
-// from which sha1 strings can be read.
-func catFileBatchCheck(revs *StringChannelWrapper) (*StringChannelWrapper, error) {
+// which objects will be sent. It returns a channel from which the small blobs
+// can be read.
+func catFileBatchCheck(revs *TreeBlobChannelWrapper) (*TreeBlobChannelWrapper, error) {
 	cmd, err := startCommand("git", "cat-file", "--batch-check")
 	if err != nil {
 		return nil, err
 	}
 
-	smallRevs := make(chan string, chanBufSize)
+	smallRevs := make(chan TreeBlob, chanBufSize)
 	errchan := make(chan error, 2) // up to 2 errors, one from each goroutine
 
+	// git answers in the order it's asked, so the objects written are queued
+	// for the reader to match up with each line, rather than remembering all
+	// of their names
+	pending := make(chan TreeBlob, chanBufSize)
+
 	go func() {
+		defer close(errchan)
+		defer close(smallRevs)
+		defer recoverAsError("reading git cat-file --batch-check output", scanPanicHandler(cmd, errchan))
+
 		scanner := bufio.NewScanner(cmd.Stdout)
 		for scanner.Scan() {
 			line := scanner.Text()
 			lineLen := len(line)
+			rev := <-pending
 
 			// Format is:
 			// <sha1> <type> <size>
@@ -486,23 +484,28 @@ func catFileBatchCheck(revs *StringChannelWrapper) (*StringChannelWrapper, error
 			}
 
 			if size < blobSizeCutoff {
-				smallRevs <- line[0:40]
+				smallRevs <- rev
 			}
 		}
 
+		// if git stopped early, don't leave the writer blocked
+		for range pending {
+		}
+
 		stderr, _ := ioutil.ReadAll(cmd.Stderr)
 		err := cmd.Wait()
 		if err != nil {
 			errchan <- fmt.Errorf("Error in git cat-file --batch-check: %v %v", err, string(stderr))
 		}
-		close(smallRevs)
-		close(errchan)
 	}()
 
 	go func() {
 		for r := range revs.Results {
-			cmd.Stdin.Write([]byte(r + "\n"))
+			pending <- r
+			cmd.Stdin.Write([]byte(r.Sha1 + "\n"))
 		}
+		close(pending)
+
 		err := revs.Wait()
 		if err != nil {
 			// We can share errchan with other goroutine since that won't close it
@@ -513,78 +516,46 @@ func catFileBatchCheck(revs *StringChannelWrapper) (*StringChannelWrapper, error
 		cmd.Stdin.Close()
 	}()
 
-	return NewStringChannelWrapper(smallRevs, errchan), nil
+	return NewTreeBlobChannelWrapper(smallRevs, errchan), nil
 }
 
-// catFileBatch uses git cat-file --batch to get the object contents
-// of a git object, given its sha1. The contents will be decoded into
-// a Git LFS pointer. revs is a channel over which strings containing Git SHA1s
-// will be sent. It returns a channel from which point.Pointers can be read.
-func catFileBatch(revs *StringChannelWrapper) (*PointerChannelWrapper, error) {
-	cmd, err := startCommand("git", "cat-file", "--batch")
+// catFilePointer reads the object sha from batch and decodes it as a pointer,
+// returning nil if it isn't one.
+func catFilePointer(batch *git.CatFileBatch, sha string) (*Pointer, error) {
+	r, _, err := batch.Contents(sha)
 	if err != nil {
 		return nil, err
 	}
 
-	pointers := make(chan *WrappedPointer, chanBufSize)
-	errchan := make(chan error, 5) // shared by 2 goroutines & may add more detail errors?
-
-	go func() {
-		for {
-			l, err := cmd.Stdout.ReadBytes('\n')
-			if err != nil {
-				break
-			}
-
-			// Line is formatted:
-			// <sha1> <type> <size>
-			fields := bytes.Fields(l)
-			s, _ := strconv.Atoi(string(fields[2]))
-
-			nbuf := make([]byte, s)
-			_, err = io.ReadFull(cmd.Stdout, nbuf)
-			if err != nil {
-				break // Legit errors
-			}
-
-			p, err := DecodePointer(bytes.NewBuffer(nbuf))
-			if err == nil {
-				pointers <- &WrappedPointer{
-					Sha1:    string(fields[0]),
-					Size:    p.Size,
-					Pointer: p,
-				}
-			}
-
-			_, err = cmd.Stdout.ReadBytes('\n') // Extra \n inserted by cat-file
-			if err != nil {
-				break
-			}
-		}
+	buf, readErr := ioutil.ReadAll(r)
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
 
-		stderr, _ := ioutil.ReadAll(cmd.Stderr)
-		err = cmd.Wait()
-		if err != nil {
-			errchan <- fmt.Errorf("Error in git cat-file --batch: %v %v", err, string(stderr))
-		}
-		close(pointers)
-		close(errchan)
-	}()
+	p, err := DecodePointer(bytes.NewBuffer(buf))
+	if err != nil {
+		return nil, nil
+	}
+	return p, nil
+}
 
-	go func() {
-		for r := range revs.Results {
-			cmd.Stdin.Write([]byte(r + "\n"))
+// scanPanicHandler returns a function for recoverAsError that reports a panic in
+// a goroutine producing scan results to errchan. cmd, whose output it was
+// reading, is killed as nothing is left to read the rest.
+func scanPanicHandler(cmd *wrappedCmd, errchan chan error) func(error) {
+	return func(err error) {
+		if cmd != nil {
+			cmd.Process.Kill()
 		}
-		err := revs.Wait()
-		if err != nil {
-			// We can share errchan with other goroutine since that won't close it
-			// until we close the stdin below
-			errchan <- err
+		select {
+		case errchan <- err:
+		default:
+			// errchan is full of other errors, which are enough to fail the scan
 		}
-		cmd.Stdin.Close()
-	}()
-
-	return NewPointerChannelWrapper(pointers, errchan), nil
+	}
 }
 
 type wrappedCmd struct {
@@ -613,8 +584,8 @@ func startCommand(command string, args ...string) (*wrappedCmd, error) {
 		return nil, err
 	}
 
-	tracerx.Printf("run_command: %s %s", command, strings.Join(args, " "))
-	if err := cmd.Start(); err != nil {
+	trace.Scan.Printf("run_command: %s %s", command, strings.Join(args, " "))
+	if err := subprocess.Start(cmd); err != nil {
 		return nil, err
 	}
 
@@ -630,6 +601,7 @@ func startCommand(command string, args ...string) (*wrappedCmd, error) {
 type TreeBlob struct {
 	Sha1     string
 	Filename string
+	Mode     string // eg "100755", empty from rev-list
 }
 
 // ScanTree takes a ref and returns a slice of WrappedPointer objects in the tree at that ref
@@ -661,12 +633,162 @@ func ScanTree(ref string) ([]*WrappedPointer, error) {
 	return pointers, err
 }
 
+// ScanIndexTree is like ScanTree, but for the files staged in the index rather
+// than the tree at a ref. Unlike ScanIndex it returns every Git LFS file in the
+// index, not just those changed since HEAD, so it works before the first commit.
+func ScanIndexTree() ([]*WrappedPointer, error) {
+	start := time.Now()
+	defer func() {
+		tracerx.PerformanceSince("scan-index-tree", start)
+	}()
+
+	indexShas, err := lsFilesBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	smallShas, err := catFileBatchCheck(indexShas)
+	if err != nil {
+		return nil, err
+	}
+
+	pointerc, err := catFileBatchTree(smallShas)
+	if err != nil {
+		return nil, err
+	}
+
+	pointers := make([]*WrappedPointer, 0)
+	for p := range pointerc.Results {
+		pointers = append(pointers, p)
+	}
+	err = pointerc.Wait()
+
+	return pointers, err
+}
+
+// IndexChange is a file staged in the index that's a Git LFS pointer, or was
+// one in HEAD, with its pointers before and after.
+type IndexChange struct {
+	Status  string   // A, M, D, R, C or T, as git diff-index gives it
+	Name    string   // from the root of the repository
+	SrcName string   // the name in HEAD, for R and C
+	Old     *Pointer // nil if it wasn't a pointer in HEAD
+	New     *Pointer // nil if it isn't a pointer in the index
+}
+
+// emptyTreeSha1 is the tree with nothing in it, which the index is compared
+// with before the first commit.
+const emptyTreeSha1 = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// ScanIndexChanges returns the files staged in the index that are Git LFS
+// pointers, or were in HEAD, with the pointers they replaced, so that a
+// changed pointer's old and new sizes can be shown. Before the first commit,
+// everything staged is added.
+func ScanIndexChanges() ([]*IndexChange, error) {
+	start := time.Now()
+	defer func() {
+		tracerx.PerformanceSince("scan-index-changes", start)
+	}()
+
+	base := "HEAD"
+	if _, err := git.ResolveRef("HEAD"); err != nil {
+		base = emptyTreeSha1
+	}
+
+	cmd, err := startCommand("git", "diff-index", "--cached", "-M", "-z", base)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin.Close()
+
+	var changes []*IndexChange
+	var oldShas, newShas []string
+	scanner := bufio.NewScanner(cmd.Stdout)
+	scanner.Split(scanNullLines)
+	for scanner.Scan() {
+		// Format is ":<old mode> <new mode> <old sha1> <new sha1> <status>"
+		// then the name, and for renames and copies the new name, each
+		// NUL terminated.
+		description := strings.Fields(strings.TrimPrefix(scanner.Text(), ":"))
+		if len(description) < 5 || !scanner.Scan() {
+			continue
+		}
+
+		change := &IndexChange{Status: description[4][0:1], Name: scanner.Text()}
+		if change.Status == "R" || change.Status == "C" {
+			if !scanner.Scan() {
+				break
+			}
+			change.SrcName = change.Name
+			change.Name = scanner.Text()
+		}
+		changes = append(changes, change)
+		oldShas = append(oldShas, description[2])
+		newShas = append(newShas, description[3])
+	}
+
+	stderr, _ := ioutil.ReadAll(cmd.Stderr)
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("Error in git diff-index: %v %v", err, string(stderr))
+	}
+
+	pointers, err := catFilePointers(append(oldShas, newShas...))
+	if err != nil {
+		return nil, err
+	}
+
+	lfsChanges := make([]*IndexChange, 0, len(changes))
+	for i, change := range changes {
+		change.Old = pointers[oldShas[i]]
+		change.New = pointers[newShas[i]]
+		if change.Old != nil || change.New != nil {
+			lfsChanges = append(lfsChanges, change)
+		}
+	}
+	return lfsChanges, nil
+}
+
+// catFilePointers returns the Git LFS pointers among the blobs with the given
+// sha1s, by sha1. Zero sha1s, for a side of a change that has no blob, are
+// skipped.
+func catFilePointers(shas []string) (map[string]*Pointer, error) {
+	blobs := make(chan TreeBlob, chanBufSize)
+	errchan := make(chan error)
+	close(errchan)
+	go func() {
+		defer close(blobs)
+		seen := NewStringSet()
+		for _, sha := range shas {
+			if z40.MatchString(sha) || !seen.Add(sha) {
+				continue
+			}
+			blobs <- TreeBlob{Sha1: sha}
+		}
+	}()
+
+	smallShas, err := catFileBatchCheck(NewTreeBlobChannelWrapper(blobs, errchan))
+	if err != nil {
+		return nil, err
+	}
+
+	pointerc, err := catFileBatchTree(smallShas)
+	if err != nil {
+		return nil, err
+	}
+
+	pointers := make(map[string]*Pointer)
+	for p := range pointerc.Results {
+		pointers[p.Sha1] = p.Pointer
+	}
+	return pointers, pointerc.Wait()
+}
+
 // catFileBatchTree uses git cat-file --batch to get the object contents
 // of a git object, given its sha1. The contents will be decoded into
 // a Git LFS pointer. treeblobs is a channel over which blob entries
 // will be sent. It returns a channel from which point.Pointers can be read.
 func catFileBatchTree(treeblobs *TreeBlobChannelWrapper) (*PointerChannelWrapper, error) {
-	cmd, err := startCommand("git", "cat-file", "--batch")
+	batch, err := git.NewCatFileBatch()
 	if err != nil {
 		return nil, err
 	}
@@ -675,55 +797,40 @@ func catFileBatchTree(treeblobs *TreeBlobChannelWrapper) (*PointerChannelWrapper
 	errchan := make(chan error, 10) // Multiple errors possible
 
 	go func() {
+		defer close(errchan)
+		defer close(pointers)
+		defer batch.Close()
+		defer recoverAsError("reading git cat-file --batch output", scanPanicHandler(nil, errchan))
+
 		for t := range treeblobs.Results {
-			cmd.Stdin.Write([]byte(t.Sha1 + "\n"))
-			l, err := cmd.Stdout.ReadBytes('\n')
+			p, err := catFilePointer(batch, t.Sha1)
 			if err != nil {
+				errchan <- err
 				break
 			}
-
-			// Line is formatted:
-			// <sha1> <type> <size>
-			fields := bytes.Fields(l)
-			s, _ := strconv.Atoi(string(fields[2]))
-
-			nbuf := make([]byte, s)
-			_, err = io.ReadFull(cmd.Stdout, nbuf)
-			if err != nil {
-				break // Legit errors
-			}
-
-			p, err := DecodePointer(bytes.NewBuffer(nbuf))
-			if err == nil {
+			if p != nil {
 				pointers <- &WrappedPointer{
-					Sha1:    string(fields[0]),
+					Sha1:    t.Sha1,
 					Size:    p.Size,
 					Pointer: p,
 					Name:    t.Filename,
+					Mode:    t.Mode,
 				}
 			}
+		}
 
-			_, err = cmd.Stdout.ReadBytes('\n') // Extra \n inserted by cat-file
-			if err != nil {
-				break
-			}
+		// don't leave ls-tree blocked after an error
+		for range treeblobs.Results {
 		}
 		// Deal with nested error from incoming treeblobs
-		err := treeblobs.Wait()
-		if err != nil {
+		if err := treeblobs.Wait(); err != nil {
 			errchan <- err
 		}
 
-		cmd.Stdin.Close()
-
 		// also errors from our command
-		stderr, _ := ioutil.ReadAll(cmd.Stderr)
-		err = cmd.Wait()
-		if err != nil {
-			errchan <- fmt.Errorf("Error in git cat-file: %v %v", err, string(stderr))
+		if err := batch.Close(); err != nil {
+			errchan <- err
 		}
-		close(pointers)
-		close(errchan)
 	}()
 
 	return NewPointerChannelWrapper(pointers, errchan), nil
@@ -752,19 +859,85 @@ func lsTreeBlobs(ref string) (*TreeBlobChannelWrapper, error) {
 	errchan := make(chan error, 1)
 
 	go func() {
+		defer close(errchan)
+		defer close(blobs)
+		defer recoverAsError("reading git ls-tree output", scanPanicHandler(cmd, errchan))
+
 		parseLsTree(cmd.Stdout, blobs)
 		stderr, _ := ioutil.ReadAll(cmd.Stderr)
 		err := cmd.Wait()
 		if err != nil {
 			errchan <- fmt.Errorf("Error in git ls-tree: %v %v", err, string(stderr))
 		}
-		close(blobs)
-		close(errchan)
 	}()
 
 	return NewTreeBlobChannelWrapper(blobs, errchan), nil
 }
 
+// lsFilesBlobs uses ls-files to list the blobs staged in the index, with their
+// paths from the root of the repository. The index doesn't record sizes, so
+// the blobs should go through catFileBatchCheck to drop those too big to be
+// pointers. A conflicted path is only sent once, for its first stage.
+func lsFilesBlobs() (*TreeBlobChannelWrapper, error) {
+	cmd, err := startCommand("git", "ls-files",
+		"--cached",    // everything in the index
+		"--stage",     // with the sha1 of each
+		"-z",          // null line termination
+		"--full-name", // paths from the root
+		"--", ":/")    // the whole repository, wherever we are in it
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Stdin.Close()
+
+	blobs := make(chan TreeBlob, chanBufSize)
+	errchan := make(chan error, 1)
+
+	go func() {
+		defer close(errchan)
+		defer close(blobs)
+		defer recoverAsError("reading git ls-files output", scanPanicHandler(cmd, errchan))
+
+		parseLsFilesStage(cmd.Stdout, blobs)
+		stderr, _ := ioutil.ReadAll(cmd.Stderr)
+		err := cmd.Wait()
+		if err != nil {
+			errchan <- fmt.Errorf("Error in git ls-files: %v %v", err, string(stderr))
+		}
+	}()
+
+	return NewTreeBlobChannelWrapper(blobs, errchan), nil
+}
+
+// parseLsFilesStage parses the output of ls-files --stage -z, which is
+// "<mode> <sha1> <stage>\t<path>" for each entry.
+func parseLsFilesStage(reader io.Reader, output chan TreeBlob) {
+	var lastFilename string
+	scanner := bufio.NewScanner(reader)
+	scanner.Split(scanNullLines)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(parts) < 2 {
+			continue
+		}
+
+		attrs := strings.Split(parts[0], " ")
+		// skip submodules
+		if len(attrs) < 3 || attrs[0] == "160000" {
+			continue
+		}
+
+		filename := parts[1]
+		if filename == lastFilename {
+			continue
+		}
+		lastFilename = filename
+
+		output <- TreeBlob{Sha1: attrs[1], Filename: filename, Mode: attrs[0]}
+	}
+}
+
 func parseLsTree(reader io.Reader, output chan TreeBlob) {
 	scanner := bufio.NewScanner(reader)
 	scanner.Split(scanNullLines)
@@ -792,7 +965,7 @@ func parseLsTree(reader io.Reader, output chan TreeBlob) {
 		if sz < blobSizeCutoff {
 			sha1 := attrs[2]
 			filename := parts[1]
-			output <- TreeBlob{sha1, filename}
+			output <- TreeBlob{sha1, filename, attrs[0]}
 		}
 	}
 }
@@ -893,14 +1066,16 @@ func ScanUnpushedToChan(remoteName string) (*PointerChannelWrapper, error) {
 	errchan := make(chan error, 1)
 
 	go func() {
+		defer close(errchan)
+		defer close(pchan)
+		defer recoverAsError("reading git log output", scanPanicHandler(cmd, errchan))
+
 		parseLogOutputToPointers(cmd.Stdout, LogDiffAdditions, nil, nil, pchan)
 		stderr, _ := ioutil.ReadAll(cmd.Stderr)
 		err := cmd.Wait()
 		if err != nil {
 			errchan <- fmt.Errorf("Error in git log: %v %v", err, string(stderr))
 		}
-		close(pchan)
-		close(errchan)
 	}()
 
 	return NewPointerChannelWrapper(pchan, errchan), nil
@@ -932,14 +1107,16 @@ func logPreviousSHAs(ref string, since time.Time) (*PointerChannelWrapper, error
 	// this means we pick up all previous versions that could have been checked
 	// out in the date range, not just if the commit which *introduced* them is in the range
 	go func() {
+		defer close(errchan)
+		defer close(pchan)
+		defer recoverAsError("reading git log output", scanPanicHandler(cmd, errchan))
+
 		parseLogOutputToPointers(cmd.Stdout, LogDiffDeletions, nil, nil, pchan)
 		stderr, _ := ioutil.ReadAll(cmd.Stderr)
 		err := cmd.Wait()
 		if err != nil {
 			errchan <- fmt.Errorf("Error in git log: %v %v", err, string(stderr))
 		}
-		close(pchan)
-		close(errchan)
 	}()
 
 	return NewPointerChannelWrapper(pchan, errchan), nil
@@ -997,7 +1174,7 @@ func parseLogOutputToPointers(log io.Reader, dir LogDiffDirection,
 				if err == nil {
 					results <- &WrappedPointer{Name: currentFilename, Size: p.Size, Pointer: p}
 				} else {
-					tracerx.Printf("Unable to parse pointer from log: %v", err)
+					trace.Scan.Printf("Unable to parse pointer from log: %v", err)
 				}
 			}
 			pointerData.Reset()
@@ -5,7 +5,9 @@ package lfs_test // to avoid import cycles
 // which avoids import cycles with testutils
 
 import (
+	"io/ioutil"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -165,3 +167,176 @@ func TestScanPreviousVersions(t *testing.T) {
 	assert.Equal(t, expected, pointers)
 
 }
+
+func TestScanRefsToChan(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	inputs := []*test.CommitInput{
+		{ // 0
+			Files: []*test.FileInput{
+				{Filename: "file1.dat", Size: 20},
+				{Filename: "folder/file2.dat", Size: 30},
+			},
+		},
+		{ // 1
+			Files: []*test.FileInput{
+				{Filename: "file1.dat", Size: 40},
+			},
+		},
+	}
+	outputs := repo.AddCommits(inputs)
+
+	// a blob too big to be a pointer, which is skipped
+	large := make([]byte, 2048)
+	for i := range large {
+		large[i] = 'a'
+	}
+	ioutil.WriteFile("large.bin", large, 0644)
+	test.RunGitCommand(t, true, "add", "large.bin")
+	test.RunGitCommand(t, true, "commit", "-m", "add a large blob")
+
+	scanned := func(refLeft string, opts *ScanRefsOptions) map[string]*WrappedPointer {
+		pointerchan, err := ScanRefsToChan(refLeft, "", opts)
+		assert.Equal(t, nil, err)
+
+		pointers := make(map[string]*WrappedPointer)
+		for p := range pointerchan.Results {
+			pointers[p.Oid] = p
+		}
+		assert.Equal(t, nil, pointerchan.Wait())
+		return pointers
+	}
+
+	// names come through with each pointer
+	pointers := scanned("HEAD", nil)
+	assert.Equal(t, 3, len(pointers))
+	assert.Equal(t, "file1.dat", pointers[outputs[0].Files[0].Oid].Name)
+	assert.Equal(t, "folder/file2.dat", pointers[outputs[0].Files[1].Oid].Name)
+	assert.Equal(t, "file1.dat", pointers[outputs[1].Files[0].Oid].Name)
+	assert.Equal(t, int64(40), pointers[outputs[1].Files[0].Oid].Size)
+
+	opts := NewScanRefsOptions()
+	opts.SkipDeletedBlobs = true
+	pointers = scanned("HEAD", opts)
+	assert.Equal(t, 2, len(pointers))
+	assert.Equal(t, "folder/file2.dat", pointers[outputs[0].Files[1].Oid].Name)
+	assert.Equal(t, "file1.dat", pointers[outputs[1].Files[0].Oid].Name)
+
+	pointers = scanned("", nil)
+	assert.Equal(t, 3, len(pointers))
+
+	_, err := ScanRefs("no-such-ref", "", nil)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestScanLeftToRemote(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	inputs := []*test.CommitInput{
+		{ // 0
+			Files: []*test.FileInput{
+				{Filename: "file1.dat", Size: 20},
+			},
+		},
+		{ // 1
+			Files: []*test.FileInput{
+				{Filename: "file2.dat", Size: 30},
+			},
+		},
+		{ // 2
+			Files: []*test.FileInput{
+				{Filename: "file1.dat", Size: 40},
+			},
+		},
+	}
+	outputs := repo.AddCommits(inputs)
+	repo.AddRemote("origin")
+	repo.AddRemote("upstream")
+
+	oids := func(pointers []*WrappedPointer) []string {
+		var ret []string
+		for _, p := range pointers {
+			ret = append(ret, p.Oid)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+	expected := func(files ...*Pointer) []string {
+		var ret []string
+		for _, f := range files {
+			ret = append(ret, f.Oid)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	// a remote without any refs yet has nothing
+	pointers, err := ScanLeftToRemote("master", "origin")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected(outputs[0].Files[0], outputs[1].Files[0], outputs[2].Files[0]), oids(pointers))
+
+	test.RunGitCommand(t, true, "push", "origin", outputs[1].Sha+":refs/heads/master")
+	pointers, err = ScanLeftToRemote("master", "origin")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected(outputs[2].Files[0]), oids(pointers))
+
+	// the other remote still has nothing
+	pointers, err = ScanLeftToRemote("master", "upstream")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(pointers))
+
+	// blank is any remote
+	test.RunGitCommand(t, true, "push", "upstream", outputs[0].Sha+":refs/heads/master")
+	pointers, err = ScanLeftToRemote("master", "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected(outputs[2].Files[0]), oids(pointers))
+}
+
+func TestScanIndexChanges(t *testing.T) {
+	repo := test.NewRepo(t)
+	repo.Pushd()
+	defer func() {
+		repo.Popd()
+		repo.Cleanup()
+	}()
+
+	outputs := repo.AddCommits([]*test.CommitInput{
+		{
+			Files: []*test.FileInput{
+				{Filename: "a.dat", Size: 20},
+				{Filename: "b.dat", Size: 30},
+			},
+		},
+	})
+
+	newPointer := NewPointer(strings.Repeat("a", 64), 50, nil)
+	assert.Equal(t, nil, ioutil.WriteFile("a.dat", []byte(newPointer.Encoded()), 0644))
+	assert.Equal(t, nil, ioutil.WriteFile("plain.txt", []byte("not a pointer"), 0644))
+	test.RunGitCommand(t, true, "add", "a.dat", "plain.txt")
+	test.RunGitCommand(t, true, "mv", "b.dat", "c.dat")
+
+	changes, err := ScanIndexChanges()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(changes))
+
+	assert.Equal(t, "M", changes[0].Status)
+	assert.Equal(t, "a.dat", changes[0].Name)
+	assert.Equal(t, outputs[0].Files[0].Oid, changes[0].Old.Oid)
+	assert.Equal(t, newPointer.Oid, changes[0].New.Oid)
+	assert.Equal(t, int64(50), changes[0].New.Size)
+
+	assert.Equal(t, "R", changes[1].Status)
+	assert.Equal(t, "c.dat", changes[1].Name)
+	assert.Equal(t, "b.dat", changes[1].SrcName)
+	assert.Equal(t, outputs[0].Files[1].Oid, changes[1].New.Oid)
+}
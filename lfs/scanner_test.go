@@ -265,6 +265,29 @@ func TestLsTreeParser(t *testing.T) {
 	}
 }
 
+func TestLsFilesStageParser(t *testing.T) {
+	stdout := "100644 d899f6551a51cf19763c5955c7a06a2726f018e9 0\t.gitattributes\000" +
+		"160000 5f5b9e3c9b1e7a6f2ed0ff4b2e5d0c0e6d1a1f6b 0\tsubmodule\000" +
+		"100644 1111111111111111111111111111111111111111 1\tconflict.dat\000" +
+		"100644 2222222222222222222222222222222222222222 2\tconflict.dat\000" +
+		"100644 3333333333333333333333333333333333333333 3\tconflict.dat\000" +
+		"100755 4d343e022e11a8618db494dc3c501e80c7e18197 0\tdir/PB SCN 16 Odhrán.wav\000"
+
+	blobs := make(chan TreeBlob, 10)
+	parseLsFilesStage(strings.NewReader(stdout), blobs)
+	close(blobs)
+
+	var results []TreeBlob
+	for blob := range blobs {
+		results = append(results, blob)
+	}
+
+	assert.Equal(t, 3, len(results))
+	assert.Equal(t, TreeBlob{"d899f6551a51cf19763c5955c7a06a2726f018e9", ".gitattributes", "100644"}, results[0])
+	assert.Equal(t, TreeBlob{"1111111111111111111111111111111111111111", "conflict.dat", "100644"}, results[1])
+	assert.Equal(t, TreeBlob{"4d343e022e11a8618db494dc3c501e80c7e18197", "dir/PB SCN 16 Odhrán.wav", "100755"}, results[2])
+}
+
 func BenchmarkLsTreeParser(b *testing.B) {
 	stdout := "100644 blob d899f6551a51cf19763c5955c7a06a2726f018e9      42	.gitattributes\000100644 blob 4d343e022e11a8618db494dc3c501e80c7e18197     126	PB SCN 16 Odhrán.wav"
 	blobs := make(chan TreeBlob, b.N*2)
@@ -73,6 +73,15 @@ func InstallFilters(opt InstallOptions, passThrough bool) error {
 	return filters.Install(opt)
 }
 
+// FiltersInstalled returns whether the clean and smudge filters in the git
+// config are the ones InstallFilters sets, with or without passThrough.
+func FiltersInstalled() bool {
+	clean, _ := Config.GitConfig("filter.lfs.clean")
+	smudge, _ := Config.GitConfig("filter.lfs.smudge")
+	return clean == filters.Properties["clean"] &&
+		(smudge == filters.Properties["smudge"] || smudge == passFilters.Properties["smudge"])
+}
+
 // UninstallFilters proxies into the Uninstall method on the Filters type to
 // remove all installed filters.
 func UninstallFilters() error {
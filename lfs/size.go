@@ -0,0 +1,107 @@
+package lfs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Size suffixes understood by ParseSize, with and without the "B". Decimal
+// suffixes are powers of 1000 and binary ones powers of 1024, eg "500k" is
+// 500,000 bytes and "1GiB" is 1,073,741,824.
+var sizeSuffixes = map[string]float64{
+	"":   1,
+	"b":  1,
+	"k":  1e3,
+	"kb": 1e3,
+	"m":  1e6,
+	"mb": 1e6,
+	"g":  1e9,
+	"gb": 1e9,
+	"t":  1e12,
+	"tb": 1e12,
+	"p":  1e15,
+	"pb": 1e15,
+
+	"ki":  1 << 10,
+	"kib": 1 << 10,
+	"mi":  1 << 20,
+	"mib": 1 << 20,
+	"gi":  1 << 30,
+	"gib": 1 << 30,
+	"ti":  1 << 40,
+	"tib": 1 << 40,
+	"pi":  1 << 50,
+	"pib": 1 << 50,
+}
+
+// Units used by FormatSize, each 1024 times the last.
+var sizeUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+
+// ParseSize parses a size in bytes such as "1024", "500k", "2.5MB" or "1GiB".
+// Suffixes are case insensitive and may be separated from the number by a
+// space. Fractions are allowed with a suffix, but a fractional number of bytes,
+// a negative size or an unknown suffix is an error naming the input.
+func ParseSize(s string) (int64, error) {
+	trimmed := strings.TrimSpace(s)
+	i := strings.IndexFunc(trimmed, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.' && r != '-' && r != '+'
+	})
+	if i < 0 {
+		i = len(trimmed)
+	}
+	number := trimmed[0:i]
+	suffix := strings.ToLower(strings.TrimSpace(trimmed[i:]))
+
+	multiplier, ok := sizeSuffixes[suffix]
+	if !ok {
+		return 0, fmt.Errorf("Invalid size %q: unknown unit %q", s, trimmed[i:])
+	}
+	if len(number) == 0 {
+		return 0, fmt.Errorf("Invalid size %q: no number", s)
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size %q", s)
+	}
+	if value < 0 || strings.HasPrefix(number, "-") {
+		return 0, fmt.Errorf("Invalid size %q: sizes can't be negative", s)
+	}
+
+	bytes := value * multiplier
+	if bytes != math.Floor(bytes) && multiplier == 1 {
+		return 0, fmt.Errorf("Invalid size %q: not a whole number of bytes", s)
+	}
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("Invalid size %q: too large", s)
+	}
+	return int64(math.Floor(bytes + 0.5)), nil
+}
+
+// FormatSize formats a size in bytes for output, to 3 significant figures in
+// binary units, eg "512 B", "1.50 KiB", "48.0 MiB" or "6.50 GiB".
+func FormatSize(bytes int64) string {
+	if bytes < 1024 && bytes > -1024 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value := float64(bytes)
+	unit := 0
+	// move up a unit when the value would round to 1024 or more, so it's never
+	// shown as eg "1024 KiB"
+	for math.Abs(value) >= 1023.5 && unit < len(sizeUnits)-1 {
+		value /= 1024
+		unit++
+	}
+
+	switch abs := math.Abs(value); {
+	case abs < 9.995:
+		return fmt.Sprintf("%.2f %s", value, sizeUnits[unit])
+	case abs < 99.95:
+		return fmt.Sprintf("%.1f %s", value, sizeUnits[unit])
+	}
+	return fmt.Sprintf("%.0f %s", value, sizeUnits[unit])
+}
@@ -0,0 +1,166 @@
+package lfs
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0", 0},
+		{"1", 1},
+		{"1024", 1024},
+		{"+10", 10},
+		{" 42 ", 42},
+		{"10b", 10},
+		{"10B", 10},
+		{"10 B", 10},
+		{"1.0", 1},
+
+		{"1k", 1000},
+		{"1K", 1000},
+		{"1kb", 1000},
+		{"1KB", 1000},
+		{"1 kB", 1000},
+		{"500k", 500000},
+		{"2.5MB", 2500000},
+		{"2.5 m", 2500000},
+		{"3G", 3000000000},
+		{"3gb", 3000000000},
+		{"2T", 2000000000000},
+		{"2TB", 2000000000000},
+		{"1P", 1000000000000000},
+		{"0.0001k", 0},
+
+		{"1Ki", 1024},
+		{"1KiB", 1024},
+		{"1kib", 1024},
+		{"1.5 KiB", 1536},
+		{"1Mi", 1048576},
+		{"1MiB", 1048576},
+		{"0.5MiB", 524288},
+		{"1Gi", 1073741824},
+		{"1GiB", 1073741824},
+		{"1Ti", 1099511627776},
+		{"1TiB", 1099511627776},
+		{"1PiB", 1125899906842624},
+		{".5KiB", 512},
+	}
+
+	for _, test := range tests {
+		size, err := ParseSize(test.input)
+		if err != nil {
+			t.Errorf("ParseSize(%q): %s", test.input, err)
+			continue
+		}
+		if size != test.expected {
+			t.Errorf("ParseSize(%q) = %d, expected %d", test.input, size, test.expected)
+		}
+	}
+}
+
+func TestParseSizeErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		reason string
+	}{
+		{"", "no number"},
+		{"   ", "no number"},
+		{"KB", "no number"},
+		{"-1", "negative"},
+		{"-1k", "negative"},
+		{"-0", "negative"},
+		{"1.5", "whole number"},
+		{"1.5B", "whole number"},
+		{"0.1", "whole number"},
+		{"10kbit", "unknown unit"},
+		{"10 bytes", "unknown unit"},
+		{"10x", "unknown unit"},
+		{"10 KB B", "unknown unit"},
+		{"1k2", "unknown unit"},
+		{"1.2.3", ""},
+		{"1-2", ""},
+		{"++1", ""},
+		{".", ""},
+		{"10000000000000000000", "too large"},
+		{"10000000TB", "too large"},
+		{"1e3", "unknown unit"},
+	}
+
+	for _, test := range tests {
+		size, err := ParseSize(test.input)
+		if err == nil {
+			t.Errorf("ParseSize(%q) = %d, expected an error", test.input, size)
+			continue
+		}
+		if !strings.Contains(err.Error(), `"`+test.input+`"`) {
+			t.Errorf("ParseSize(%q) error doesn't name the input: %s", test.input, err)
+		}
+		if !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("ParseSize(%q) error doesn't say %q: %s", test.input, test.reason, err)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		input    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1, "1 B"},
+		{11, "11 B"},
+		{1023, "1023 B"},
+		{1024, "1.00 KiB"},
+		{1536, "1.50 KiB"},
+		{10234, "9.99 KiB"},
+		{10235, "10.0 KiB"},
+		{102348, "99.9 KiB"},
+		{102349, "100 KiB"},
+		{524288, "512 KiB"},
+		{1048063, "1023 KiB"},
+		{1048064, "1.00 MiB"},
+		{1048576, "1.00 MiB"},
+		{5117051, "4.88 MiB"},
+		{33554432, "32.0 MiB"},
+		{1610612736, "1.50 GiB"},
+		{7516192768, "7.00 GiB"},
+		{1099511627776, "1.00 TiB"},
+		{1125899906842624, "1.00 PiB"},
+		{math.MaxInt64, "8192 PiB"},
+		{-1, "-1 B"},
+		{-1536, "-1.50 KiB"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, FormatSize(test.input))
+	}
+}
+
+func TestFormatSizeRoundTrips(t *testing.T) {
+	for _, size := range []int64{
+		0, 1, 999, 1023, 1024, 1500, 4096, 10000, 65535, 100000, 524288,
+		1000000, 1048575, 1048576, 123456789, 1 << 30, 5000000000, 1 << 40,
+		3 << 50,
+	} {
+		formatted := FormatSize(size)
+		parsed, err := ParseSize(formatted)
+		if err != nil {
+			t.Errorf("ParseSize(FormatSize(%d) = %q): %s", size, formatted, err)
+			continue
+		}
+
+		// FormatSize keeps 3 significant figures, so the result is within half
+		// a unit in the last place, at most 0.5% of the size
+		if diff := math.Abs(float64(parsed - size)); diff > float64(size)*0.005 {
+			t.Errorf("ParseSize(FormatSize(%d) = %q) = %d, off by %.0f", size, formatted, parsed, diff)
+		}
+		assert.Equal(t, formatted, FormatSize(parsed))
+	}
+}
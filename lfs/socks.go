@@ -0,0 +1,233 @@
+package lfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// httpTransport is the transport of the HTTP clients. Requests go through the
+// embedded transport, which knows about HTTP proxies itself, unless their proxy
+// is a SOCKS5 one, in which case they go through a transport of their own that
+// dials through it. Either way, requests are sent without the operationHeader.
+type httpTransport struct {
+	*http.Transport
+	socksMutex sync.Mutex
+	socks      map[string]*http.Transport
+}
+
+func newHttpTransportWith(tr *http.Transport) *httpTransport {
+	return &httpTransport{Transport: tr, socks: make(map[string]*http.Transport)}
+}
+
+func (t *httpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = withoutOperationHeader(req)
+
+	proxy, _, err := ProxyForUrl(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxy != nil && isSocksProxy(proxy) {
+		return t.socksTransport(proxy).RoundTrip(req)
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of every transport.
+func (t *httpTransport) CloseIdleConnections() {
+	t.Transport.CloseIdleConnections()
+
+	t.socksMutex.Lock()
+	defer t.socksMutex.Unlock()
+	for _, tr := range t.socks {
+		tr.CloseIdleConnections()
+	}
+}
+
+// socksTransport returns the transport for requests through the SOCKS5 proxy,
+// with the same settings as the embedded one.
+func (t *httpTransport) socksTransport(proxy *url.URL) *http.Transport {
+	t.socksMutex.Lock()
+	defer t.socksMutex.Unlock()
+
+	key := proxy.String()
+	if tr, ok := t.socks[key]; ok {
+		return tr
+	}
+
+	tr := &http.Transport{
+		Dial:                (&socks5Dialer{proxy: proxy, dial: t.Transport.Dial}).Dial,
+		TLSClientConfig:     t.Transport.TLSClientConfig,
+		TLSHandshakeTimeout: t.Transport.TLSHandshakeTimeout,
+		MaxIdleConnsPerHost: t.Transport.MaxIdleConnsPerHost,
+	}
+	t.socks[key] = tr
+	return tr
+}
+
+func isSocksProxy(proxy *url.URL) bool {
+	return proxy.Scheme == "socks5" || proxy.Scheme == "socks5h"
+}
+
+// socks5Dialer connects to addresses through a SOCKS5 proxy (RFC 1928),
+// authenticating with the username and password in its url, if there are any
+// (RFC 1929). Host names are resolved by the proxy.
+type socks5Dialer struct {
+	proxy *url.URL
+	dial  func(network, addr string) (net.Conn, error)
+}
+
+const (
+	socks5Version       = 5
+	socks5NoAuth        = 0
+	socks5PasswordAuth  = 2
+	socks5NoAcceptable  = 0xff
+	socks5Connect       = 1
+	socks5AddrIPv4      = 1
+	socks5AddrDomain    = 3
+	socks5AddrIPv6      = 4
+	socks5PasswordVer   = 1
+	socks5ReplySucceded = 0
+)
+
+var socks5Replies = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+func (d *socks5Dialer) Dial(network, addr string) (net.Conn, error) {
+	proxyAddr := d.proxy.Host
+	if _, _, err := net.SplitHostPort(proxyAddr); err != nil {
+		proxyAddr = net.JoinHostPort(proxyAddr, "1080")
+	}
+
+	conn, err := d.dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.connect(conn, addr); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 proxy %s: %s", proxyAddr, err)
+	}
+	return conn, nil
+}
+
+func (d *socks5Dialer) connect(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return fmt.Errorf("invalid port in %q", addr)
+	}
+
+	var username, password string
+	if d.proxy.User != nil {
+		username = d.proxy.User.Username()
+		password, _ = d.proxy.User.Password()
+	}
+
+	method := byte(socks5NoAuth)
+	if len(username) > 0 {
+		method = socks5PasswordAuth
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected version %d", reply[0])
+	}
+	if reply[1] == socks5NoAcceptable || reply[1] != method {
+		return errors.New("no acceptable authentication method")
+	}
+
+	if method == socks5PasswordAuth {
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("username or password too long")
+		}
+		auth := []byte{socks5PasswordVer, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("username and password rejected")
+		}
+	}
+
+	req := []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q too long", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// version, reply, reserved, address type, then the bound address and port
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != socks5ReplySucceded {
+		if int(header[1]) < len(socks5Replies) {
+			return fmt.Errorf("connect to %s failed: %s", addr, socks5Replies[header[1]])
+		}
+		return fmt.Errorf("connect to %s failed with reply %d", addr, header[1])
+	}
+
+	var skip int
+	switch header[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len
+	case socks5AddrIPv6:
+		skip = net.IPv6len
+	case socks5AddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("unknown address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
@@ -6,8 +6,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+	"github.com/github/git-lfs/subprocess"
+	"github.com/github/git-lfs/trace"
 )
 
 type sshAuthResponse struct {
@@ -15,27 +18,141 @@ type sshAuthResponse struct {
 	Href      string            `json:"href"`
 	Header    map[string]string `json:"header"`
 	ExpiresAt string            `json:"expires_at"`
+	ExpiresIn int               `json:"expires_in"`
 }
 
+// expiry returns when the response lapses, given it was received at now, or
+// the zero time if the server didn't say. Some servers send expires_in, a
+// number of seconds, instead of expires_at.
+func (r *sshAuthResponse) expiry(now time.Time) time.Time {
+	if r.ExpiresIn > 0 {
+		return now.Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	if t, err := time.Parse(time.RFC3339, r.ExpiresAt); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// sshAuthExpiryMargin is how long before it lapses a cached response is
+// renewed, so it doesn't lapse while a request is being made with it.
+const sshAuthExpiryMargin = 5 * time.Second
+
+// sshAuthResponses holds the responses from git-lfs-authenticate for the life
+// of the process, so a push with many batches doesn't start a new SSH session
+// for each one. Hits and misses are traced with GIT_TRACE_LFS_AUTH.
+var sshAuthResponses = newSshAuthCache()
+
+type sshAuthKey struct {
+	userAndHost string
+	port        string
+	path        string
+	operation   string
+	oid         string
+}
+
+type sshAuthEntry struct {
+	mutex   sync.Mutex // held while authenticating, so workers wait for one response
+	res     sshAuthResponse
+	expires time.Time
+	valid   bool
+}
+
+type sshAuthCache struct {
+	mutex   sync.Mutex
+	entries map[sshAuthKey]*sshAuthEntry
+}
+
+func newSshAuthCache() *sshAuthCache {
+	return &sshAuthCache{entries: make(map[sshAuthKey]*sshAuthEntry)}
+}
+
+// get returns the cached response for key, calling authenticate for a new one
+// if there isn't one or it's about to lapse. Failed responses aren't cached.
+func (c *sshAuthCache) get(key sshAuthKey, authenticate func() (sshAuthResponse, error)) (sshAuthResponse, error) {
+	c.mutex.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &sshAuthEntry{}
+		c.entries[key] = e
+	}
+	c.mutex.Unlock()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	if e.valid && (e.expires.IsZero() || now.Add(sshAuthExpiryMargin).Before(e.expires)) {
+		trace.Auth.Printf("ssh: cache hit for %s %s %s", key.userAndHost, key.path, key.operation)
+		return e.res, nil
+	}
+
+	if e.valid {
+		trace.Auth.Printf("ssh: cached response for %s %s %s expires at %s, renewing", key.userAndHost, key.path, key.operation, e.expires.Format(time.RFC3339))
+	} else {
+		trace.Auth.Printf("ssh: cache miss for %s %s %s", key.userAndHost, key.path, key.operation)
+	}
+
+	res, err := authenticate()
+	if err != nil {
+		e.valid = false
+		return res, err
+	}
+
+	e.res = res
+	e.expires = res.expiry(now)
+	e.valid = true
+	return res, nil
+}
+
+// expire forgets the cached responses for endpoint and operation, after the
+// API rejected one.
+func (c *sshAuthCache) expire(endpoint Endpoint, operation string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.entries {
+		if key.userAndHost == endpoint.SshUserAndHost && key.port == endpoint.SshPort &&
+			key.path == endpoint.SshPath && key.operation == operation {
+			trace.Auth.Printf("ssh: expiring cached response for %s %s %s", key.userAndHost, key.path, key.operation)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// sshAuthenticate runs git-lfs-authenticate over SSH for the endpoint, or
+// returns the response it gave earlier in this process if that hasn't lapsed.
 func sshAuthenticate(endpoint Endpoint, operation, oid string) (sshAuthResponse, error) {
+	if len(endpoint.SshUserAndHost) == 0 {
+		return sshAuthResponse{}, nil
+	}
+
+	key := sshAuthKey{
+		userAndHost: endpoint.SshUserAndHost,
+		port:        endpoint.SshPort,
+		path:        endpoint.SshPath,
+		operation:   operation,
+		oid:         oid,
+	}
+	return sshAuthResponses.get(key, func() (sshAuthResponse, error) {
+		return runSshAuthenticate(endpoint, operation, oid)
+	})
+}
+
+func runSshAuthenticate(endpoint Endpoint, operation, oid string) (sshAuthResponse, error) {
 
 	// This is only used as a fallback where the Git URL is SSH but server doesn't support a full SSH binary protocol
 	// and therefore we derive a HTTPS endpoint for binaries instead; but check authentication here via SSH
 
 	res := sshAuthResponse{}
-	if len(endpoint.SshUserAndHost) == 0 {
-		return res, nil
-	}
 
-	tracerx.Printf("ssh: %s git-lfs-authenticate %s %s %s",
+	trace.Auth.Printf("ssh: %s git-lfs-authenticate %s %s %s",
 		endpoint.SshUserAndHost, endpoint.SshPath, operation, oid)
 
-	exe, args := sshGetExeAndArgs(endpoint)
-	args = append(args,
-		"git-lfs-authenticate",
-		endpoint.SshPath,
-		operation, oid)
-
+	exe, args, err := sshAuthenticateArgs(endpoint, operation, oid)
+	if err != nil {
+		return res, err
+	}
 	cmd := exec.Command(exe, args...)
 
 	// Save stdout and stderr in separate buffers
@@ -44,7 +161,7 @@ func sshAuthenticate(endpoint Endpoint, operation, oid string) (sshAuthResponse,
 	cmd.Stderr = &errbuf
 
 	// Execute command
-	err := cmd.Start()
+	err = subprocess.Start(cmd)
 	if err == nil {
 		err = cmd.Wait()
 	}
@@ -59,17 +176,37 @@ func sshAuthenticate(endpoint Endpoint, operation, oid string) (sshAuthResponse,
 	return res, err
 }
 
+// sshAuthenticateArgs returns the command line for running git-lfs-authenticate
+// on the endpoint's SSH server.
+func sshAuthenticateArgs(endpoint Endpoint, operation, oid string) (exe string, args []string, err error) {
+	exe, args, err = sshGetExeAndArgs(endpoint)
+	return exe, append(args, "git-lfs-authenticate", endpoint.SshPath, operation, oid), err
+}
+
 // Return the executable name for ssh on this machine and the base args
 // Base args includes port settings, user/host, everything pre the command to execute
-func sshGetExeAndArgs(endpoint Endpoint) (exe string, baseargs []string) {
+// The ssh command is GIT_SSH_COMMAND, split as sh would, then GIT_SSH, which is
+// the path to a program by itself, then "ssh".
+func sshGetExeAndArgs(endpoint Endpoint) (exe string, baseargs []string, err error) {
 	if len(endpoint.SshUserAndHost) == 0 {
-		return "", nil
+		return "", nil, nil
 	}
 
 	isPlink := false
 	isTortoise := false
 
+	// GIT_SSH_COMMAND takes precedence over GIT_SSH, as it does for git, and
+	// may include arguments of its own
 	ssh := Config.Getenv("GIT_SSH")
+	cmdArgs, err := subprocess.SplitShellArgs(Config.Getenv("GIT_SSH_COMMAND"))
+	if err != nil {
+		return "", nil, Errorf(err, "Invalid GIT_SSH_COMMAND: %s", err)
+	}
+	if len(cmdArgs) > 0 {
+		ssh = cmdArgs[0]
+		cmdArgs = cmdArgs[1:]
+	}
+
 	if ssh == "" {
 		ssh = "ssh"
 	} else {
@@ -82,7 +219,8 @@ func sshGetExeAndArgs(endpoint Endpoint) (exe string, baseargs []string) {
 		isTortoise = strings.EqualFold(basessh, "tortoiseplink")
 	}
 
-	args := make([]string, 0, 4)
+	args := make([]string, 0, 4+len(cmdArgs))
+	args = append(args, cmdArgs...)
 	if isTortoise {
 		// TortoisePlink requires the -batch argument to behave like ssh/plink
 		args = append(args, "-batch")
@@ -98,5 +236,5 @@ func sshGetExeAndArgs(endpoint Endpoint) (exe string, baseargs []string) {
 	}
 	args = append(args, endpoint.SshUserAndHost)
 
-	return ssh, args
+	return ssh, args, nil
 }
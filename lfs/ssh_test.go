@@ -1,8 +1,12 @@
 package lfs
 
 import (
+	"errors"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
 )
@@ -12,7 +16,8 @@ func TestSSHGetExeAndArgsSsh(t *testing.T) {
 	endpoint.SshUserAndHost = "user@foo.com"
 	oldGITSSH := Config.Getenv("GIT_SSH")
 	Config.Setenv("GIT_SSH", "")
-	exe, args := sshGetExeAndArgs(endpoint)
+	exe, args, err := sshGetExeAndArgs(endpoint)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, "ssh", exe)
 	assert.Equal(t, []string{"user@foo.com"}, args)
 
@@ -25,7 +30,8 @@ func TestSSHGetExeAndArgsSshCustomPort(t *testing.T) {
 	endpoint.SshPort = "8888"
 	oldGITSSH := Config.Getenv("GIT_SSH")
 	Config.Setenv("GIT_SSH", "")
-	exe, args := sshGetExeAndArgs(endpoint)
+	exe, args, err := sshGetExeAndArgs(endpoint)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, "ssh", exe)
 	assert.Equal(t, []string{"-p", "8888", "user@foo.com"}, args)
 
@@ -39,7 +45,8 @@ func TestSSHGetExeAndArgsPlink(t *testing.T) {
 	// this will run on non-Windows platforms too but no biggie
 	plink := filepath.Join("Users", "joebloggs", "bin", "plink.exe")
 	Config.Setenv("GIT_SSH", plink)
-	exe, args := sshGetExeAndArgs(endpoint)
+	exe, args, err := sshGetExeAndArgs(endpoint)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, plink, exe)
 	assert.Equal(t, []string{"user@foo.com"}, args)
 
@@ -54,7 +61,8 @@ func TestSSHGetExeAndArgsPlinkCustomPort(t *testing.T) {
 	// this will run on non-Windows platforms too but no biggie
 	plink := filepath.Join("Users", "joebloggs", "bin", "plink")
 	Config.Setenv("GIT_SSH", plink)
-	exe, args := sshGetExeAndArgs(endpoint)
+	exe, args, err := sshGetExeAndArgs(endpoint)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, plink, exe)
 	assert.Equal(t, []string{"-P", "8888", "user@foo.com"}, args)
 
@@ -68,7 +76,8 @@ func TestSSHGetExeAndArgsTortoisePlink(t *testing.T) {
 	// this will run on non-Windows platforms too but no biggie
 	plink := filepath.Join("Users", "joebloggs", "bin", "tortoiseplink.exe")
 	Config.Setenv("GIT_SSH", plink)
-	exe, args := sshGetExeAndArgs(endpoint)
+	exe, args, err := sshGetExeAndArgs(endpoint)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, plink, exe)
 	assert.Equal(t, []string{"-batch", "user@foo.com"}, args)
 
@@ -83,9 +92,162 @@ func TestSSHGetExeAndArgsTortoisePlinkCustomPort(t *testing.T) {
 	// this will run on non-Windows platforms too but no biggie
 	plink := filepath.Join("Users", "joebloggs", "bin", "tortoiseplink")
 	Config.Setenv("GIT_SSH", plink)
-	exe, args := sshGetExeAndArgs(endpoint)
+	exe, args, err := sshGetExeAndArgs(endpoint)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, plink, exe)
 	assert.Equal(t, []string{"-batch", "-P", "8888", "user@foo.com"}, args)
 
 	Config.Setenv("GIT_SSH", oldGITSSH)
 }
+
+func TestSSHAuthCacheReusesResponse(t *testing.T) {
+	cache := newSshAuthCache()
+	key := sshAuthKey{userAndHost: "git@foo.com", path: "foo/bar", operation: "upload"}
+
+	var calls int32
+	authenticate := func() (sshAuthResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return sshAuthResponse{Href: "https://foo.com/foo/bar", Header: map[string]string{"Authorization": "Token abc"}}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := cache.get(key, authenticate)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, "Token abc", res.Header["Authorization"])
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls)
+
+	// another operation is authenticated separately
+	download := key
+	download.operation = "download"
+	cache.get(download, authenticate)
+	assert.Equal(t, int32(2), calls)
+
+	// a rejected response is forgotten
+	cache.expire(Endpoint{SshUserAndHost: "git@foo.com", SshPath: "foo/bar"}, "upload")
+	cache.get(key, authenticate)
+	cache.get(download, authenticate)
+	assert.Equal(t, int32(3), calls)
+}
+
+func TestSSHAuthCacheRenewsExpiringResponse(t *testing.T) {
+	cache := newSshAuthCache()
+	key := sshAuthKey{userAndHost: "git@foo.com", path: "foo/bar", operation: "download"}
+
+	tests := []struct {
+		res   sshAuthResponse
+		calls int
+	}{
+		// no expiry is cached for the whole process
+		{sshAuthResponse{}, 1},
+		{sshAuthResponse{ExpiresIn: 3600}, 1},
+		{sshAuthResponse{ExpiresAt: time.Now().Add(time.Hour).Format(time.RFC3339)}, 1},
+		// about to lapse, so renewed every time
+		{sshAuthResponse{ExpiresIn: 1}, 3},
+		{sshAuthResponse{ExpiresAt: time.Now().Add(time.Second).Format(time.RFC3339)}, 3},
+		{sshAuthResponse{ExpiresAt: "2015-01-01T00:00:00Z"}, 3},
+	}
+
+	for i, test := range tests {
+		calls := 0
+		authenticate := func() (sshAuthResponse, error) {
+			calls++
+			return test.res, nil
+		}
+
+		cache.expire(Endpoint{SshUserAndHost: "git@foo.com", SshPath: "foo/bar"}, "download")
+		for j := 0; j < 3; j++ {
+			cache.get(key, authenticate)
+		}
+		if calls != test.calls {
+			t.Errorf("%d: expected %d calls to git-lfs-authenticate, got %d", i, test.calls, calls)
+		}
+	}
+}
+
+func TestSSHAuthCacheDoesNotCacheFailures(t *testing.T) {
+	cache := newSshAuthCache()
+	key := sshAuthKey{userAndHost: "git@foo.com", path: "foo/bar", operation: "upload"}
+
+	calls := 0
+	authenticate := func() (sshAuthResponse, error) {
+		calls++
+		return sshAuthResponse{Message: "denied"}, errors.New("exit status 1")
+	}
+
+	_, err := cache.get(key, authenticate)
+	assert.Equal(t, "exit status 1", err.Error())
+	cache.get(key, authenticate)
+	assert.Equal(t, 2, calls)
+}
+
+func TestSSHAuthenticateArgs(t *testing.T) {
+	oldGITSSH := Config.Getenv("GIT_SSH")
+	oldGITSSHCommand := Config.Getenv("GIT_SSH_COMMAND")
+	defer func() {
+		Config.Setenv("GIT_SSH", oldGITSSH)
+		Config.Setenv("GIT_SSH_COMMAND", oldGITSSHCommand)
+	}()
+
+	plink := filepath.Join("Users", "joebloggs", "bin", "plink.exe")
+	tortoise := filepath.Join("Users", "joebloggs", "bin", "TortoisePlink.exe")
+	spacedPlink := filepath.Join("Program Files", "PuTTY", "plink.exe")
+
+	tests := []struct {
+		url        string
+		gitSSH     string
+		sshCommand string
+		exe        string
+		args       []string
+	}{
+		{"ssh://git@gitserver:2222/team/repo.git", "", "", "ssh",
+			[]string{"-p", "2222", "git@gitserver", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		{"ssh://git@gitserver/team/repo.git", "", "", "ssh",
+			[]string{"git@gitserver", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		{"git@gitserver:team/repo.git", "", "", "ssh",
+			[]string{"git@gitserver", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		{"ssh://gitserver:2222/team/repo.git", "", "", "ssh",
+			[]string{"-p", "2222", "gitserver", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		{"ssh://git@[fe80::1]:2222/team/repo.git", "", "", "ssh",
+			[]string{"-p", "2222", "git@fe80::1", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		{"ssh://git@gitserver:2222/team/repo.git", plink, "", plink,
+			[]string{"-P", "2222", "git@gitserver", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		{"ssh://git@gitserver:2222/team/repo.git", tortoise, "", tortoise,
+			[]string{"-batch", "-P", "2222", "git@gitserver", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		{"ssh://git@gitserver:2222/team/repo.git", "", "ssh -i key", "ssh",
+			[]string{"-i", "key", "-p", "2222", "git@gitserver", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		// GIT_SSH_COMMAND takes precedence over GIT_SSH
+		{"ssh://git@gitserver:2222/team/repo.git", "ssh", "plink -v", "plink",
+			[]string{"-v", "-P", "2222", "git@gitserver", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		{"git@work:team/repo.git", "", `ssh -i ~/.ssh/work_key -o "ProxyCommand ssh -W %h:%p bastion"`, "ssh",
+			[]string{"-i", "~/.ssh/work_key", "-o", "ProxyCommand ssh -W %h:%p bastion", "git@work", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		// GIT_SSH is a path, spaces and all
+		{"ssh://git@gitserver:2222/team/repo.git", spacedPlink, "", spacedPlink,
+			[]string{"-P", "2222", "git@gitserver", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+		{"ssh://git@gitserver:2222/team/repo.git", "", `'` + spacedPlink + `' -batch`, spacedPlink,
+			[]string{"-batch", "-P", "2222", "git@gitserver", "git-lfs-authenticate", "team/repo.git", "upload", ""}},
+	}
+
+	for _, test := range tests {
+		Config.Setenv("GIT_SSH", test.gitSSH)
+		Config.Setenv("GIT_SSH_COMMAND", test.sshCommand)
+
+		exe, args, err := sshAuthenticateArgs(NewEndpoint(test.url), "upload", "")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, test.exe, exe)
+		assert.Equal(t, test.args, args)
+	}
+
+	Config.Setenv("GIT_SSH_COMMAND", `ssh -o "ProxyCommand`)
+	_, _, err := sshAuthenticateArgs(NewEndpoint("git@work:team/repo.git"), "upload", "")
+	if err == nil {
+		t.Fatal("expected an error for an unterminated quote")
+	}
+}
@@ -0,0 +1,159 @@
+package lfs
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/github/git-lfs/trace"
+)
+
+// The most bytes a throttled reader reads at once, so that a transfer doesn't
+// use a second's worth of bandwidth in one go and then stall.
+const maxThrottledRead = 32 * 1024
+
+var (
+	// bandwidthLimiters are shared by all transfers in a direction, or all
+	// transfers for "", so that limits apply across concurrent transfers. A nil
+	// limiter means there's no limit. See transferLimiters.
+	bandwidthLimiters      map[string]*bandwidthLimiter
+	bandwidthLimitersMutex sync.Mutex
+)
+
+// bandwidthLimiter is a token bucket of bytes, refilled at rate bytes per
+// second. Taking more bytes than it holds puts it into debt, which the caller
+// waits off, so readers sharing it get the rate between them.
+type bandwidthLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	mutex  sync.Mutex
+}
+
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	rate := float64(bytesPerSecond)
+	burst := rate / 4
+	if burst < 1 {
+		burst = 1
+	}
+
+	l := &bandwidthLimiter{rate: rate, burst: burst, tokens: burst, now: time.Now}
+	l.last = l.now()
+	return l
+}
+
+// take takes n bytes from the bucket, returning how long to wait before
+// using them.
+func (l *bandwidthLimiter) take(n int) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// maxRead returns the most bytes to read at once, at most a quarter of a
+// second's worth.
+func (l *bandwidthLimiter) maxRead() int {
+	if l.burst < maxThrottledRead {
+		return int(l.burst)
+	}
+	return maxThrottledRead
+}
+
+// transferLimiters returns the limiters for transfers in direction, or none
+// if lfs.transfer.maxbandwidth and its per direction settings aren't set.
+func transferLimiters(direction string) []*bandwidthLimiter {
+	bandwidthLimitersMutex.Lock()
+	defer bandwidthLimitersMutex.Unlock()
+
+	if bandwidthLimiters == nil {
+		bandwidthLimiters = make(map[string]*bandwidthLimiter)
+	}
+
+	var limiters []*bandwidthLimiter
+	for _, d := range []string{"", direction} {
+		l, ok := bandwidthLimiters[d]
+		if !ok {
+			if max := Config.TransferMaxBandwidth(d); max > 0 {
+				trace.Transfer.Printf("limiting %s bandwidth to %s/s", bandwidthName(d), FormatSize(max))
+				l = newBandwidthLimiter(max)
+			}
+			bandwidthLimiters[d] = l
+		}
+		if l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+	return limiters
+}
+
+func bandwidthName(direction string) string {
+	if len(direction) == 0 {
+		return "transfer"
+	}
+	return direction
+}
+
+// throttleReader returns r limited to the bandwidth set for transfers in
+// direction, or r itself if there's no limit.
+func throttleReader(r io.Reader, direction string) io.Reader {
+	limiters := transferLimiters(direction)
+	if len(limiters) == 0 {
+		return r
+	}
+	return &throttledReader{Reader: r, limiters: limiters}
+}
+
+// throttleReadCloser is throttleReader for a response body.
+func throttleReadCloser(rc io.ReadCloser, direction string) io.ReadCloser {
+	limiters := transferLimiters(direction)
+	if len(limiters) == 0 {
+		return rc
+	}
+	return &throttledReadCloser{Reader: &throttledReader{Reader: rc, limiters: limiters}, Closer: rc}
+}
+
+// throttledReader waits after each read until its limiters allow the bytes it
+// read, so that progress is reported at the limited rate.
+type throttledReader struct {
+	io.Reader
+	limiters []*bandwidthLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	for _, l := range r.limiters {
+		if max := l.maxRead(); len(p) > max {
+			p = p[0:max]
+		}
+	}
+
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		var wait time.Duration
+		for _, l := range r.limiters {
+			if d := l.take(n); d > wait {
+				wait = d
+			}
+		}
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+type throttledReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,131 @@
+package lfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestBandwidthLimiterTake(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newBandwidthLimiter(1000)
+	l.now = func() time.Time { return now }
+	l.last = now
+
+	// starts with a quarter of a second's worth
+	assert.Equal(t, time.Duration(0), l.take(250))
+	assert.Equal(t, 100*time.Millisecond, l.take(100))
+
+	// the debt is paid off over time, and it refills up to the burst
+	now = now.Add(time.Second)
+	assert.Equal(t, time.Duration(0), l.take(250))
+	assert.Equal(t, 500*time.Millisecond, l.take(500))
+
+	assert.Equal(t, 250, l.maxRead())
+	assert.Equal(t, maxThrottledRead, newBandwidthLimiter(10*1000*1000).maxRead())
+}
+
+func TestThrottleReaderWithoutLimit(t *testing.T) {
+	defer setBandwidthTestConfig(map[string]string{})()
+
+	r := bytes.NewReader([]byte("abc"))
+	assert.Equal(t, true, throttleReader(r, "upload") == r)
+
+	body := ioutil.NopCloser(r)
+	assert.Equal(t, true, throttleReadCloser(body, "download") == body)
+}
+
+func TestThrottleReaderSharesAggregateLimit(t *testing.T) {
+	defer setBandwidthTestConfig(map[string]string{
+		"lfs.transfer.maxbandwidth":         "200k",
+		"lfs.transfer.maxdownloadbandwidth": "1m",
+	})()
+
+	// two 50KB uploads and a 50KB download share 200KB/s, less the 50KB
+	// burst: about half a second
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, direction := range []string{"upload", "upload", "download"} {
+		wg.Add(1)
+		go func(direction string) {
+			defer wg.Done()
+			n, err := copyWithBuffer(ioutil.Discard, throttleReader(bytes.NewReader(make([]byte, 50000)), direction))
+			assert.Equal(t, nil, err)
+			assert.Equal(t, int64(50000), n)
+		}(direction)
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected about 500ms at 200KB/s, took %s", elapsed)
+	}
+}
+
+func TestThrottleReaderReportsThrottledProgress(t *testing.T) {
+	defer setBandwidthTestConfig(map[string]string{
+		"lfs.transfer.maxuploadbandwidth": "100k",
+	})()
+
+	var times []time.Duration
+	start := time.Now()
+	reader := &CallbackReader{
+		TotalSize: 50000,
+		Reader:    throttleReader(bytes.NewReader(make([]byte, 50000)), "upload"),
+		C: func(total, read int64, current int) error {
+			times = append(times, time.Since(start))
+			return nil
+		},
+	}
+	_, err := copyWithBuffer(ioutil.Discard, reader)
+	assert.Equal(t, nil, err)
+
+	// at 100KB/s, with the first 25KB from the burst
+	if last := times[len(times)-1]; last < 200*time.Millisecond {
+		t.Errorf("expected the last progress after 250ms, got %s", last)
+	}
+
+	// downloads aren't limited
+	assert.Equal(t, 0, len(transferLimiters("download")))
+}
+
+func TestTransferMaxBandwidth(t *testing.T) {
+	config := &Configuration{
+		gitConfig: map[string]string{
+			"lfs.transfer.maxbandwidth":       "5m",
+			"lfs.transfer.maxuploadbandwidth": "512KiB",
+		},
+	}
+
+	assert.Equal(t, int64(5000000), config.TransferMaxBandwidth(""))
+	assert.Equal(t, int64(512*1024), config.TransferMaxBandwidth("upload"))
+	assert.Equal(t, int64(0), config.TransferMaxBandwidth("download"))
+
+	config = &Configuration{
+		gitConfig: map[string]string{"lfs.transfer.maxbandwidth": "fast"},
+	}
+	assert.Equal(t, int64(0), config.TransferMaxBandwidth(""))
+}
+
+// setBandwidthTestConfig sets the git config for bandwidth limits, and forgets
+// the limiters built from the last config. It returns a func that restores
+// them.
+func setBandwidthTestConfig(gitConfig map[string]string) func() {
+	oldGitConfig := Config.gitConfig
+	Config.gitConfig = gitConfig
+
+	bandwidthLimitersMutex.Lock()
+	bandwidthLimiters = nil
+	bandwidthLimitersMutex.Unlock()
+
+	return func() {
+		Config.gitConfig = oldGitConfig
+
+		bandwidthLimitersMutex.Lock()
+		bandwidthLimiters = nil
+		bandwidthLimitersMutex.Unlock()
+	}
+}
@@ -0,0 +1,111 @@
+package lfs
+
+import (
+	"github.com/github/git-lfs/transfer"
+)
+
+const basicAdapterName = "basic"
+
+// A TransferAdapter makes the transfers of a TransferQueue. The queue offers
+// the batch API the adapters configured for its direction, and the server picks
+// one of them, or "basic" if it doesn't say.
+type TransferAdapter interface {
+	Name() string
+	// Begin prepares the adapter for up to workers concurrent transfers in
+	// direction, "upload" or "download".
+	Begin(direction string, workers int) error
+	Transfer(t Transferable, cb CopyCallback) error
+	// End is called once the queue has nothing left to transfer.
+	End()
+}
+
+// basicAdapter transfers objects with the HTTP actions from the batch API, the
+// way each Transferable does by itself.
+type basicAdapter struct{}
+
+func (a *basicAdapter) Name() string                              { return basicAdapterName }
+func (a *basicAdapter) Begin(direction string, workers int) error { return nil }
+func (a *basicAdapter) End()                                      {}
+
+func (a *basicAdapter) Transfer(t Transferable, cb CopyCallback) error {
+	return t.Transfer(cb)
+}
+
+// customAdapter hands objects to an external process, see the transfer package.
+type customAdapter struct {
+	*transfer.Adapter
+	direction string
+}
+
+func newCustomAdapter(config *transfer.Config) *customAdapter {
+	return &customAdapter{Adapter: transfer.NewAdapter(config)}
+}
+
+func (a *customAdapter) Begin(direction string, workers int) error {
+	a.direction = direction
+	return a.Adapter.Begin(direction, workers)
+}
+
+func (a *customAdapter) Transfer(t Transferable, cb CopyCallback) error {
+	rel, ok := t.Object().Rel(a.direction)
+	if !ok {
+		return Errorf(nil, "No %q action for %s.", a.direction, t.Oid())
+	}
+
+	action := &transfer.Action{Href: rel.Href, Header: rel.Header}
+	progress := func(soFar int64, sinceLast int) error {
+		if cb == nil {
+			return nil
+		}
+		return cb(t.Size(), soFar, sinceLast)
+	}
+
+	if a.direction == "upload" {
+		path, err := LocalMediaPath(t.Oid())
+		if err != nil {
+			return Error(err)
+		}
+		return a.Upload(t.Oid(), t.Size(), path, action, progress)
+	}
+
+	path, err := a.Download(t.Oid(), t.Size(), action, progress)
+	if err != nil {
+		return err
+	}
+
+	// the storage layer checks the content before moving it into place
+	if err := IngestObject(path, t.Oid(), t.Size(), false); err != nil {
+		return Errorf(err, "Error storing %s from custom transfer %s: %s", t.Oid(), a.Name(), err)
+	}
+	return nil
+}
+
+// transferAdapterNames returns the names of the adapters to offer the batch API
+// for transfers in direction, or nil if there's only the basic one.
+func transferAdapterNames(direction string) []string {
+	configs := Config.CustomTransfers(direction)
+	if len(configs) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(configs)+1)
+	for _, config := range configs {
+		names = append(names, config.Name)
+	}
+	return append(names, basicAdapterName)
+}
+
+// newTransferAdapter returns the adapter with the given name for transfers in
+// direction, or nil if there isn't one.
+func newTransferAdapter(name, direction string) TransferAdapter {
+	if name == basicAdapterName {
+		return &basicAdapter{}
+	}
+
+	for _, config := range Config.CustomTransfers(direction) {
+		if config.Name == name {
+			return newCustomAdapter(config)
+		}
+	}
+	return nil
+}
@@ -1,15 +1,34 @@
 package lfs
 
 import (
+	"fmt"
+	"net/url"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/github/git-lfs/git"
-	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+	"github.com/github/git-lfs/trace"
 )
 
 const (
 	batchSize = 100
+
+	// Failed transfers are retried once unless lfs.transfer.maxretries says
+	// otherwise, see Wait
+	defaultTransferMaxRetries = 1
+
+	defaultMaxPendingTransfers = 4096
+
+	// See ConcurrentTransfers, TransferMaxConcurrent and
+	// TransferSmallFileThreshold
+	defaultConcurrentTransfers        = 3
+	defaultTransferMaxConcurrent      = 8
+	maxConcurrentTransfers            = 64
+	defaultTransferSmallFileThreshold = 1024 * 1024
+
+	// How long a transfer worker started for small objects waits for another
+	// transfer before it stops, see scaleUp
+	transferWorkerIdleTime = 2 * time.Second
 )
 
 type Transferable interface {
@@ -22,14 +41,88 @@ type Transferable interface {
 	SetObject(*ObjectResource)
 }
 
+// TransferFailure describes a failed transfer, for reporting.
+type TransferFailure struct {
+	Oid         string
+	Path        string // the best known path of the object, if any
+	Direction   string // "upload" or "download"
+	Host        string // the host the transfer or API request was made to
+	Attempt     int
+	MaxAttempts int
+	Err         error
+}
+
+// String formats the failure as, for example:
+//
+//	assets/big.bin (4f62c3, upload, attempt 2/2): connection reset
+//
+// or, for an error the batch API gave for the object, which isn't retried:
+//
+//	textures/huge.psd (oid 4f62c3…): exceeds server limit (422)
+func (f *TransferFailure) String() string {
+	name := f.Path
+	if len(name) == 0 {
+		name = f.Oid
+	}
+	shortOid := f.Oid
+	if len(shortOid) > 6 {
+		shortOid = shortOid[0:6]
+	}
+	if _, ok := f.Err.(*ObjectError); ok {
+		if shortOid != f.Oid {
+			shortOid += "…"
+		}
+		return fmt.Sprintf("%s (oid %s): %s", name, shortOid, f.Err)
+	}
+	return fmt.Sprintf("%s (%s, %s, attempt %d/%d): %s", name, shortOid, f.Direction, f.Attempt, f.MaxAttempts, f.Err)
+}
+
+// Why an object wasn't transferred, see TransferResult
+const (
+	SkippedAlreadyPresent = "already present"
+	SkippedExcluded       = "excluded by filter"
+	SkippedOutOfSpace     = "out of disk space"
+	SkippedInterrupted    = "interrupted"
+)
+
+// TransferResult is what happened to an object that was transferred or
+// skipped, see TransferQueue.Results. Those that failed are in
+// TransferQueue.Errors instead.
+type TransferResult struct {
+	Oid        string
+	Size       int64
+	Path       string
+	Duration   time.Duration // from the first attempt until it succeeded
+	Retries    int
+	SkipReason string // why it wasn't transferred, eg SkippedAlreadyPresent
+}
+
 // TransferQueue provides a queue that will allow concurrent transfers.
 type TransferQueue struct {
-	retrying      uint32
+	transferred   int64  // transfers that succeeded, first for 64 bit alignment
+	bytesDone     int64  // the size of the transfers that succeeded
+	retryPass     uint32 // how many times failed transfers have been retried
+	inFlight      int32  // transfers being made, for waiting on if interrupted
+	outOfSpace    uint32
+	pendingBytes  int64 // bytes of downloads queued but not yet finished
+	pendingCount  int32 // transfers added but not yet started, see Add
+	maxPending    int32 // the most transfers that have been pending at once
 	meter         *ProgressMeter
-	workers       int // Number of transfer workers to spawn
+	workers       int           // Number of transfer workers to spawn
+	maxWorkers    int           // the most transfer workers for small objects, see scaleUp
+	runningCount  int32         // transfer workers running
+	idleCount     int32         // transfer workers waiting for a transfer
+	idleTime      time.Duration // how long extra workers wait before stopping
+	smallSize     int64         // objects smaller than this are small
+	largeSlots    chan struct{} // a slot for each large object being transferred
+	maxRetries    int           // Number of times failed transfers are retried
 	transferKind  string
+	adapterNames  []string        // the transfer adapters offered to the batch API
+	adapter       TransferAdapter // the adapter the batch API picked, see useAdapter
+	adapterMutex  sync.Mutex
 	errors        []error
-	transferables map[string]Transferable
+	transferables map[string]Transferable // transfers waiting for a batch API response
+	transferMutex sync.Mutex
 	retries       []Transferable
 	batcher       *Batcher
 	apic          chan Transferable // Channel for processing individual API requests
@@ -37,37 +130,60 @@ type TransferQueue struct {
 	retriesc      chan Transferable // Channel for processing retries
 	errorc        chan error        // Channel for processing errors
 	watchers      []chan string
+	results       []TransferResult      // if Config.RecordTransfers
+	attempts      map[string]*attempted // by oid, if Config.RecordTransfers
+	resultsMutex  sync.Mutex
+	pending       chan struct{} // a slot for each transfer added but not started
 	errorwait     sync.WaitGroup
 	retrywait     sync.WaitGroup
 	wait          sync.WaitGroup
 }
 
-// newTransferQueue builds a TransferQueue, allowing `workers` concurrent transfers.
-func newTransferQueue(files int, size int64, dryRun bool) *TransferQueue {
+// newTransferQueue builds a TransferQueue for transferKind, "upload" or
+// "download", allowing `workers` concurrent transfers.
+func newTransferQueue(transferKind string, files int, size int64, dryRun bool) *TransferQueue {
 	q := &TransferQueue{
 		meter:         NewProgressMeter(files, size, dryRun),
+		transferKind:  transferKind,
 		apic:          make(chan Transferable, batchSize),
 		transferc:     make(chan Transferable, batchSize),
 		retriesc:      make(chan Transferable, batchSize),
 		errorc:        make(chan error),
 		workers:       Config.ConcurrentTransfers(),
+		maxWorkers:    Config.TransferMaxConcurrent(),
+		smallSize:     Config.TransferSmallFileThreshold(),
+		largeSlots:    make(chan struct{}, Config.ConcurrentTransfers()),
+		idleTime:      transferWorkerIdleTime,
+		maxRetries:    Config.TransferMaxRetries(),
 		transferables: make(map[string]Transferable),
+		pending:       make(chan struct{}, Config.MaxPendingTransfers()),
+	}
+	if Config.RecordTransfers {
+		q.attempts = make(map[string]*attempted)
 	}
 
 	q.errorwait.Add(1)
 	q.retrywait.Add(1)
 
 	q.run()
+	trackTransferQueue(q)
 
 	return q
 }
 
-// Add adds a Transferable to the transfer queue.
+// Add adds a Transferable to the transfer queue. If the queue already holds
+// lfs.maxpendingtransfers transfers which haven't started, Add blocks until one
+// does, so callers can add transfers as they find them without holding them all
+// in memory.
 func (q *TransferQueue) Add(t Transferable) {
+	q.acquire()
 	q.wait.Add(1)
-	q.transferables[t.Oid()] = t
 
 	if q.batcher != nil {
+		q.transferMutex.Lock()
+		q.transferables[t.Oid()] = t
+		q.transferMutex.Unlock()
+
 		q.batcher.Add(t)
 		return
 	}
@@ -75,9 +191,50 @@ func (q *TransferQueue) Add(t Transferable) {
 	q.apic <- t
 }
 
+// Grow adds to the totals shown by the progress meter, for callers which add
+// transfers as they find them rather than knowing the totals up front.
+func (q *TransferQueue) Grow(files int, size int64) {
+	q.meter.Grow(files, size)
+}
+
+// acquire takes a pending slot for a new transfer, waiting for one if needed.
+func (q *TransferQueue) acquire() {
+	q.pending <- struct{}{}
+
+	n := atomic.AddInt32(&q.pendingCount, 1)
+	for {
+		max := atomic.LoadInt32(&q.maxPending)
+		if n <= max || atomic.CompareAndSwapInt32(&q.maxPending, max, n) {
+			return
+		}
+	}
+}
+
+// release frees the pending slot of a transfer once it has started, or won't
+// be started at all.
+func (q *TransferQueue) release() {
+	select {
+	case <-q.pending:
+		atomic.AddInt32(&q.pendingCount, -1)
+	default:
+	}
+}
+
+// takeTransferable returns the transfer waiting for the batch API response for
+// oid, and forgets it.
+func (q *TransferQueue) takeTransferable(oid string) (Transferable, bool) {
+	q.transferMutex.Lock()
+	defer q.transferMutex.Unlock()
+
+	t, ok := q.transferables[oid]
+	delete(q.transferables, oid)
+	return t, ok
+}
+
 // Wait waits for the queue to finish processing all transfers. Once Wait is
-// called, Add will no longer add transferables to the queue. Any failed
-// transfers will be automatically retried once.
+// called, Add will no longer add transferables to the queue. Failed transfers
+// are retried up to lfs.transfer.maxretries times, backing off between each
+// pass.
 func (q *TransferQueue) Wait() {
 	if q.batcher != nil {
 		q.batcher.Exit()
@@ -86,13 +243,26 @@ func (q *TransferQueue) Wait() {
 	q.wait.Wait()
 
 	// Handle any retries
-	close(q.retriesc)
-	q.retrywait.Wait()
-	atomic.StoreUint32(&q.retrying, 1)
+	for {
+		close(q.retriesc)
+		q.retrywait.Wait()
+
+		retries := q.retries
+		if len(retries) == 0 {
+			break
+		}
+
+		q.retries = nil
+		q.retriesc = make(chan Transferable, batchSize)
+		q.retrywait.Add(1)
+		go q.retryCollector()
+
+		pass := int(atomic.AddUint32(&q.retryPass, 1))
+		delay := retryBackoff(pass)
+		trace.Transfer.Printf("retrying %d failed transfers in %s (%d/%d)", len(retries), delay, pass, q.maxRetries)
+		time.Sleep(delay)
 
-	if len(q.retries) > 0 {
-		tracerx.Printf("tq: retrying %d failed transfers", len(q.retries))
-		for _, t := range q.retries {
+		for _, t := range retries {
 			q.Add(t)
 		}
 		if q.batcher != nil {
@@ -101,7 +271,11 @@ func (q *TransferQueue) Wait() {
 		q.wait.Wait()
 	}
 
-	atomic.StoreUint32(&q.retrying, 0)
+	q.adapterMutex.Lock()
+	if q.adapter != nil {
+		q.adapter.End()
+	}
+	q.adapterMutex.Unlock()
 
 	close(q.apic)
 	close(q.transferc)
@@ -115,6 +289,61 @@ func (q *TransferQueue) Wait() {
 	q.errorwait.Wait()
 }
 
+// Results returns what happened to each object that was transferred or
+// skipped, in the order they finished, if Config.RecordTransfers was set when
+// the queue was made. Call it after Wait.
+func (q *TransferQueue) Results() []TransferResult {
+	q.resultsMutex.Lock()
+	defer q.resultsMutex.Unlock()
+	return q.results
+}
+
+// attempted is when an object was first attempted, and how many times.
+type attempted struct {
+	start time.Time
+	count int
+}
+
+// attempt notes that t is about to be transferred, for Results.
+func (q *TransferQueue) attempt(t Transferable) {
+	if q.attempts == nil {
+		return
+	}
+
+	q.resultsMutex.Lock()
+	a, ok := q.attempts[t.Oid()]
+	if !ok {
+		a = &attempted{start: time.Now()}
+		q.attempts[t.Oid()] = a
+	}
+	a.count++
+	q.resultsMutex.Unlock()
+}
+
+// record adds the result of t to Results: it was transferred, or if
+// skipReason is set, skipped.
+func (q *TransferQueue) record(t Transferable, skipReason string) {
+	if q.attempts == nil {
+		return
+	}
+
+	result := TransferResult{Oid: t.Oid(), Size: t.Size(), Path: t.Name(), SkipReason: skipReason}
+	q.resultsMutex.Lock()
+	if a, ok := q.attempts[t.Oid()]; ok {
+		result.Duration = time.Since(a.start)
+		result.Retries = a.count - 1
+		delete(q.attempts, t.Oid())
+	}
+	q.results = append(q.results, result)
+	q.resultsMutex.Unlock()
+}
+
+// skip tells the progress meter that t is being skipped, and records why.
+func (q *TransferQueue) skip(t Transferable, reason string) {
+	q.meter.Skip(t.Size())
+	q.record(t, reason)
+}
+
 // Watch returns a channel where the queue will write the OID of each transfer
 // as it completes. The channel will be closed when the queue finishes processing.
 func (q *TransferQueue) Watch() chan string {
@@ -134,8 +363,9 @@ func (q *TransferQueue) individualApiRoutine(apiWaiter chan interface{}) {
 			if q.canRetry(err) {
 				q.retry(t)
 			} else {
-				q.errorc <- err
+				q.fail(t, err)
 			}
+			q.release()
 			q.wait.Done()
 			continue
 		}
@@ -149,11 +379,19 @@ func (q *TransferQueue) individualApiRoutine(apiWaiter chan interface{}) {
 		}
 
 		if obj != nil {
+			if !q.reserveSpace(t.Size()) {
+				q.skip(t, SkippedOutOfSpace)
+				q.release()
+				q.wait.Done()
+				continue
+			}
+
 			t.SetObject(obj)
 			q.meter.Add(t.Name())
-			q.transferc <- t
+			q.enqueue(t)
 		} else {
-			q.meter.Skip(t.Size())
+			q.skip(t, SkippedAlreadyPresent)
+			q.release()
 			q.wait.Done()
 		}
 	}
@@ -163,11 +401,12 @@ func (q *TransferQueue) individualApiRoutine(apiWaiter chan interface{}) {
 // not support the batch endpoint. When this happens, the Transferables are
 // fed from the batcher into apic to be processed individually.
 func (q *TransferQueue) legacyFallback(failedBatch []Transferable) {
-	tracerx.Printf("tq: batch api not implemented, falling back to individual")
+	trace.Transfer.Printf("batch api not implemented, falling back to individual")
 
 	q.launchIndividualApiRoutines()
 
 	for _, t := range failedBatch {
+		q.takeTransferable(t.Oid())
 		q.apic <- t
 	}
 
@@ -178,6 +417,7 @@ func (q *TransferQueue) legacyFallback(failedBatch []Transferable) {
 		}
 
 		for _, t := range batch {
+			q.takeTransferable(t.Oid())
 			q.apic <- t
 		}
 	}
@@ -195,28 +435,33 @@ func (q *TransferQueue) batchApiRoutine() {
 			break
 		}
 
-		tracerx.Printf("tq: sending batch of size %d", len(batch))
+		trace.Transfer.Printf("sending batch of size %d", len(batch))
 
 		transfers := make([]*ObjectResource, 0, len(batch))
 		for _, t := range batch {
 			transfers = append(transfers, &ObjectResource{Oid: t.Oid(), Size: t.Size()})
 		}
 
-		objects, err := Batch(transfers, q.transferKind)
+		objects, adapter, err := Batch(transfers, q.transferKind, q.adapterNames)
+		if err == nil {
+			err = q.useAdapter(adapter)
+		}
 		if err != nil {
 			if IsNotImplementedError(err) {
-				git.Config.SetLocal("", "lfs.batch", "false")
+				Config.SetEndpointBatch(Config.Endpoint(q.transferKind), false)
 
 				go q.legacyFallback(batch)
 				return
 			}
 
-			if q.canRetry(err) {
-				for _, t := range batch {
+			for _, t := range batch {
+				q.takeTransferable(t.Oid())
+				if q.canRetry(err) {
 					q.retry(t)
+				} else {
+					q.fail(t, err)
 				}
-			} else {
-				q.errorc <- err
+				q.release()
 			}
 
 			q.wait.Add(-len(transfers))
@@ -225,32 +470,96 @@ func (q *TransferQueue) batchApiRoutine() {
 
 		startProgress.Do(q.meter.Start)
 
+		sizes := make(map[string]int64, len(batch))
+		for _, t := range batch {
+			sizes[t.Oid()] = t.Size()
+		}
+
+		var needed int64
+		mismatched := make(map[string]error)
 		for _, o := range objects {
 			if o.Error != nil {
-				q.errorc <- Errorf(o.Error, "[%v] %v", o.Oid, o.Error.Message)
+				continue
+			}
+			if err := checkObjectSize(o, sizes[o.Oid]); err != nil {
+				mismatched[o.Oid] = err
+				continue
+			}
+			if _, ok := o.Rel(q.transferKind); ok {
+				needed += o.Size
+			}
+		}
+
+		if !q.reserveSpace(needed) {
+			for _, o := range objects {
+				if transfer, ok := q.takeTransferable(o.Oid); ok {
+					q.skip(transfer, SkippedOutOfSpace)
+				} else {
+					q.meter.Skip(o.Size)
+				}
+				q.release()
+				q.wait.Done()
+			}
+			continue
+		}
+
+		for _, o := range objects {
+			transfer, ok := q.takeTransferable(o.Oid)
+
+			if o.Error != nil {
+				if ok {
+					q.fail(transfer, o.Error)
+				} else {
+					q.errorc <- Errorf(o.Error, "%v: %v", o.Oid, o.Error)
+				}
 				q.meter.Skip(o.Size)
+				q.release()
 				q.wait.Done()
 				continue
 			}
 
-			if _, ok := o.Rel(q.transferKind); ok {
-				// This object needs to be transferred
-				if transfer, ok := q.transferables[o.Oid]; ok {
-					transfer.SetObject(o)
-					q.meter.Add(transfer.Name())
-					q.transferc <- transfer
-				} else {
-					q.meter.Skip(transfer.Size())
-					q.wait.Done()
+			if err, mismatch := mismatched[o.Oid]; mismatch {
+				if ok {
+					q.fail(transfer, err)
 				}
+				q.meter.Skip(sizes[o.Oid])
+				q.release()
+				q.wait.Done()
+				continue
+			}
+
+			if _, needed := o.Rel(q.transferKind); needed && ok {
+				// This object needs to be transferred
+				transfer.SetObject(o)
+				q.meter.Add(transfer.Name())
+				q.enqueue(transfer)
+			} else if ok {
+				q.skip(transfer, SkippedAlreadyPresent)
+				q.release()
+				q.wait.Done()
 			} else {
 				q.meter.Skip(o.Size)
+				q.release()
 				q.wait.Done()
 			}
 		}
 	}
 }
 
+// checkObjectSize returns an error if the batch API reported a different size
+// for o than the size, from its pointer, that was asked for. A server that
+// reports a size of 0 is taken to not know it, and given the pointer's size.
+func checkObjectSize(o *ObjectResource, size int64) error {
+	if o.Size == 0 {
+		o.Size = size
+		return nil
+	}
+	if size == 0 || o.Size == size {
+		return nil
+	}
+	return fmt.Errorf("size mismatch, file may be corrupt locally: the pointer says %d bytes, the server has %d", size, o.Size)
+}
+
 // This goroutine collects errors returned from transfers
 func (q *TransferQueue) errorCollector() {
 	for err := range q.errorc {
@@ -266,31 +575,221 @@ func (q *TransferQueue) retryCollector() {
 	q.retrywait.Done()
 }
 
-func (q *TransferQueue) transferWorker() {
-	for transfer := range q.transferc {
-		cb := func(total, read int64, current int) error {
-			q.meter.TransferBytes(q.transferKind, transfer.Name(), read, total, current)
-			return nil
+// enqueue hands t to the transfer workers. If t is small and more transfers
+// are waiting than there are idle workers, another is started, up to
+// lfs.transfer.maxconcurrent of them.
+func (q *TransferQueue) enqueue(t Transferable) {
+	q.transferc <- t
+
+	if t.Size() < q.smallSize && len(q.transferc) > int(atomic.LoadInt32(&q.idleCount)) {
+		q.scaleUp()
+	}
+}
+
+// scaleUp starts another transfer worker, unless there are maxWorkers already.
+// Workers beyond lfs.concurrenttransfers stop once they've been idle for
+// idleTime, see nextTransfer.
+func (q *TransferQueue) scaleUp() {
+	for {
+		n := atomic.LoadInt32(&q.runningCount)
+		if int(n) >= q.maxWorkers {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&q.runningCount, n, n+1) {
+			trace.Transfer.Printf("starting transfer worker %d of up to %d for small objects", n+1, q.maxWorkers)
+			go q.transferWorker()
+			return
 		}
+	}
+}
 
-		if err := transfer.Transfer(cb); err != nil {
-			if q.canRetry(err) {
-				tracerx.Printf("tq: retrying object %s", transfer.Oid())
-				q.retry(transfer)
-			} else {
-				q.errorc <- err
+// scaleDown returns whether an idle transfer worker should stop, counting it
+// as stopped if so.
+func (q *TransferQueue) scaleDown() bool {
+	for {
+		n := atomic.LoadInt32(&q.runningCount)
+		if int(n) <= q.workers {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&q.runningCount, n, n-1) {
+			trace.Transfer.Printf("stopping idle transfer worker, %d left", n-1)
+			return true
+		}
+	}
+}
+
+// nextTransfer waits for a transfer for a worker, returning false when the
+// worker should stop.
+func (q *TransferQueue) nextTransfer() (Transferable, bool) {
+	atomic.AddInt32(&q.idleCount, 1)
+	defer atomic.AddInt32(&q.idleCount, -1)
+
+	if q.idleTime <= 0 {
+		t, ok := <-q.transferc
+		return t, ok
+	}
+
+	idle := time.NewTimer(q.idleTime)
+	defer idle.Stop()
+
+	for {
+		select {
+		case t, ok := <-q.transferc:
+			return t, ok
+		case <-idle.C:
+			if q.scaleDown() {
+				return nil, false
 			}
+			idle.Reset(q.idleTime)
+		}
+	}
+}
+
+func (q *TransferQueue) transferWorker() {
+	for {
+		transfer, ok := q.nextTransfer()
+		if !ok {
+			return
+		}
+		q.transferOne(transfer)
+	}
+}
+
+// transferOne transfers an object for a transfer worker. Objects of at least
+// lfs.transfer.smallfilethreshold are only transferred lfs.concurrenttransfers
+// at a time, however many workers there are.
+func (q *TransferQueue) transferOne(transfer Transferable) {
+	q.release()
+
+	if Interrupted() || atomic.LoadUint32(&q.outOfSpace) == 1 {
+		q.releaseSpace(transfer.Size())
+		if Interrupted() {
+			q.skip(transfer, SkippedInterrupted)
 		} else {
-			oid := transfer.Oid()
-			for _, c := range q.watchers {
-				c <- oid
-			}
+			q.skip(transfer, SkippedOutOfSpace)
+		}
+		q.wait.Done()
+		return
+	}
+
+	// Other processes may have filled the disk since the batch was checked
+	if q.transferKind == "download" {
+		if err := CheckFreeSpace(LocalMediaDir, transfer.Size()); err != nil {
+			q.releaseSpace(transfer.Size())
+			q.fail(transfer, err)
+			q.meter.Skip(transfer.Size())
+			q.wait.Done()
+			return
 		}
+	}
+
+	cb := func(total, read int64, current int) error {
+		if Interrupted() {
+			return ErrInterrupted
+		}
+		q.meter.TransferBytes(q.transferKind, transfer.Name(), read, total, current)
+		return nil
+	}
 
+	large := q.largeSlots != nil && transfer.Size() >= q.smallSize
+	if large {
+		q.largeSlots <- struct{}{}
+	}
+
+	atomic.AddInt32(&q.inFlight, 1)
+	q.meter.StartTransfer(q.transferKind, transfer.Name(), transfer.Size())
+	err := q.transfer(transfer, cb)
+	for tries := 0; err != nil && tries < q.maxRetries && !Interrupted(); tries++ {
+		// When rate limited, this worker waits as long as the server asked
+		// and tries again, rather than leaving it to the next retry pass.
+		d, ok := retryAfterOf(err)
+		if !ok {
+			break
+		}
+		trace.Transfer.Printf("rate limited, retrying object %s in %s", transfer.Oid(), d)
+		throttleRequests(d)
+		waitForRateLimit()
+		err = q.transfer(transfer, cb)
+	}
+	atomic.AddInt32(&q.inFlight, -1)
+	q.releaseSpace(transfer.Size())
+	if large {
+		<-q.largeSlots
+	}
+
+	if err != nil {
+		if q.canRetry(err) {
+			trace.Transfer.Printf("retrying object %s", transfer.Oid())
+			q.retry(transfer)
+			q.meter.RetryTransfer(transfer.Name())
+		} else {
+			q.fail(transfer, err)
+			q.meter.FailTransfer(transfer.Name())
+		}
+	} else {
+		oid := transfer.Oid()
+		for _, c := range q.watchers {
+			c <- oid
+		}
+		atomic.AddInt64(&q.transferred, 1)
+		atomic.AddInt64(&q.bytesDone, transfer.Size())
+		q.record(transfer, "")
 		q.meter.FinishTransfer(transfer.Name())
+	}
 
-		q.wait.Done()
+	q.wait.Done()
+}
+
+// transfer runs a single transfer with the queue's adapter, returning a panic
+// in it as an error so the rest of the queue can carry on.
+func (q *TransferQueue) transfer(t Transferable, cb CopyCallback) (err error) {
+	defer recoverAsError("transferring "+t.Oid(), func(e error) { err = e })
+	q.attempt(t)
+
+	q.adapterMutex.Lock()
+	adapter := q.adapter
+	q.adapterMutex.Unlock()
+
+	if adapter == nil {
+		// the legacy API doesn't pick one
+		adapter = &basicAdapter{}
+	}
+	return adapter.Transfer(t, cb)
+}
+
+// useAdapter starts the transfer adapter the batch API picked, the first time
+// it's picked. The server can't switch to another one part way through.
+func (q *TransferQueue) useAdapter(name string) error {
+	q.adapterMutex.Lock()
+	defer q.adapterMutex.Unlock()
+
+	if q.adapter != nil {
+		if q.adapter.Name() != name {
+			return Errorf(nil, "The server picked the %q transfer adapter, after picking %q", name, q.adapter.Name())
+		}
+		return nil
 	}
+
+	offered := name == basicAdapterName
+	for _, n := range q.adapterNames {
+		offered = offered || n == name
+	}
+
+	var adapter TransferAdapter
+	if offered {
+		adapter = newTransferAdapter(name, q.transferKind)
+	}
+	if adapter == nil {
+		return Errorf(nil, "The server picked the %q transfer adapter, which wasn't offered", name)
+	}
+
+	trace.Transfer.Printf("using the %s transfer adapter", name)
+	if err := adapter.Begin(q.transferKind, q.workers); err != nil {
+		return err
+	}
+
+	q.adapter = adapter
+	return nil
 }
 
 // launchIndividualApiRoutines first launches a single api worker. When it
@@ -311,40 +810,121 @@ func (q *TransferQueue) launchIndividualApiRoutines() {
 }
 
 // run starts the transfer queue, doing individual or batch transfers depending
-// on the Config.BatchTransfer() value. run will transfer files sequentially or
-// concurrently depending on the Config.ConcurrentTransfers() value.
+// on the Config.EndpointBatch() value for its endpoint. run will transfer files
+// sequentially or concurrently depending on the Config.ConcurrentTransfers()
+// value.
 func (q *TransferQueue) run() {
 	go q.errorCollector()
 	go q.retryCollector()
 
-	tracerx.Printf("tq: starting %d transfer workers", q.workers)
+	trace.Transfer.Printf("starting %d transfer workers", q.workers)
+	atomic.StoreInt32(&q.runningCount, int32(q.workers))
 	for i := 0; i < q.workers; i++ {
 		go q.transferWorker()
 	}
 
-	if Config.BatchTransfer() {
-		tracerx.Printf("tq: running as batched queue, batch size of %d", batchSize)
+	if Config.EndpointBatch(Config.Endpoint(q.transferKind)) {
+		trace.Transfer.Printf("running as batched queue, batch size of %d", batchSize)
 		q.batcher = NewBatcher(batchSize)
 		go q.batchApiRoutine()
 	} else {
-		tracerx.Printf("tq: running as individual queue")
+		trace.Transfer.Printf("running as individual queue")
 		q.launchIndividualApiRoutines()
 	}
 }
 
+// fail reports err, adding the details of the transfer t it happened in.
+func (q *TransferQueue) fail(t Transferable, err error) {
+	q.errorc <- newTransferError(err, &TransferFailure{
+		Oid:         t.Oid(),
+		Path:        t.Name(),
+		Direction:   q.transferKind,
+		Host:        q.transferHost(t),
+		Attempt:     int(atomic.LoadUint32(&q.retryPass)) + 1,
+		MaxAttempts: q.maxRetries + 1,
+	})
+}
+
+// transferHost returns the host t is transferred to or from, or the API host
+// if that isn't known yet.
+func (q *TransferQueue) transferHost(t Transferable) string {
+	if obj := t.Object(); obj != nil {
+		if rel, ok := obj.Rel(q.transferKind); ok {
+			if u, err := url.Parse(rel.Href); err == nil {
+				return u.Host
+			}
+		}
+	}
+
+	if u, err := url.Parse(Config.Endpoint(q.transferKind).Url); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// reserveSpace checks there's room in the media dir for size more bytes of
+// downloads on top of those already queued, and reserves it. If there isn't,
+// the error is reported once and the rest of the downloads are dropped.
+func (q *TransferQueue) reserveSpace(size int64) bool {
+	if q.transferKind != "download" {
+		return true
+	}
+	if atomic.LoadUint32(&q.outOfSpace) == 1 {
+		return false
+	}
+
+	pending := atomic.AddInt64(&q.pendingBytes, size)
+	if err := CheckFreeSpace(LocalMediaDir, pending); err != nil {
+		atomic.AddInt64(&q.pendingBytes, -size)
+		if atomic.CompareAndSwapUint32(&q.outOfSpace, 0, 1) {
+			q.errorc <- err
+		}
+		return false
+	}
+	return true
+}
+
+func (q *TransferQueue) releaseSpace(size int64) {
+	if q.transferKind == "download" {
+		atomic.AddInt64(&q.pendingBytes, -size)
+	}
+}
+
 func (q *TransferQueue) retry(t Transferable) {
 	q.retriesc <- t
 }
 
 func (q *TransferQueue) canRetry(err error) bool {
-	if !IsRetriableError(err) || atomic.LoadUint32(&q.retrying) == 1 {
+	if !IsRetriableError(err) || int(atomic.LoadUint32(&q.retryPass)) >= q.maxRetries {
 		return false
 	}
 
 	return true
 }
 
+// Transferred returns the number of objects transferred successfully.
+func (q *TransferQueue) Transferred() int {
+	return int(atomic.LoadInt64(&q.transferred))
+}
+
+// TransferredBytes returns the size of the objects transferred successfully.
+func (q *TransferQueue) TransferredBytes() int64 {
+	return atomic.LoadInt64(&q.bytesDone)
+}
+
 // Errors returns any errors encountered during transfer.
 func (q *TransferQueue) Errors() []error {
 	return q.errors
 }
+
+// Failures returns the details of each failed transfer, for the errors that
+// came from one.
+func (q *TransferQueue) Failures() []*TransferFailure {
+	var failures []*TransferFailure
+	for _, err := range q.errors {
+		if f := TransferFailureOf(err); f != nil {
+			failures = append(failures, f)
+		}
+	}
+	return failures
+}
@@ -0,0 +1,479 @@
+package lfs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestTransferFailureString(t *testing.T) {
+	f := &TransferFailure{
+		Oid:         "4f62c3a1b2",
+		Path:        "assets/big.bin",
+		Direction:   "upload",
+		Attempt:     2,
+		MaxAttempts: 2,
+		Err:         errors.New("connection reset"),
+	}
+	assert.Equal(t, "assets/big.bin (4f62c3, upload, attempt 2/2): connection reset", f.String())
+
+	f.Path = ""
+	assert.Equal(t, "4f62c3a1b2 (4f62c3, upload, attempt 2/2): connection reset", f.String())
+
+	f.Path = "textures/huge.psd"
+	f.Err = &ObjectError{Code: 422, Message: "exceeds server limit"}
+	assert.Equal(t, "textures/huge.psd (oid 4f62c3…): exceeds server limit (422)", f.String())
+}
+
+func TestTransferErrorsCarryContext(t *testing.T) {
+	const oid = "4f62c3a1b2c3d4e5"
+	object := &ObjectResource{
+		Oid: oid,
+		Actions: map[string]*linkRelation{
+			"upload": &linkRelation{Href: "https://storage.example.com/objects/" + oid},
+		},
+	}
+
+	q := &TransferQueue{
+		meter:        NewProgressMeter(1, 10, false),
+		transferKind: "upload",
+		maxRetries:   1,
+		transferc:    make(chan Transferable, 1),
+		errorc:       make(chan error, 4),
+	}
+	q.meter.quiet = true
+
+	// a failed transfer
+	go q.transferWorker()
+	q.wait.Add(1)
+	q.transferc <- &fakeTransfer{name: oid, path: "assets/big.bin", object: object, err: errors.New("connection reset")}
+	close(q.transferc)
+	q.wait.Wait()
+
+	// an error for the object from the batch API, while retrying
+	q.retryPass = 1
+	q.fail(&fakeTransfer{name: oid, path: "assets/big.bin"}, &ObjectError{Code: 404, Message: "Object does not exist"})
+
+	// a fatal error
+	q.fail(&fakeTransfer{name: oid}, newFatalError(errors.New("disk full")))
+
+	close(q.errorc)
+	for err := range q.errorc {
+		q.errors = append(q.errors, err)
+	}
+
+	assert.Equal(t, 3, len(q.errors))
+	assert.Equal(t, "assets/big.bin (4f62c3, upload, attempt 1/2): connection reset", q.errors[0].Error())
+	assert.Equal(t, "assets/big.bin (oid 4f62c3…): Object does not exist (404)", q.errors[1].Error())
+	assert.Equal(t, "4f62c3a1b2c3d4e5 (4f62c3, upload, attempt 2/2): Fatal error", q.errors[2].Error())
+	assert.Equal(t, false, IsFatalError(q.errors[0]))
+	assert.Equal(t, true, IsFatalError(q.errors[2]))
+
+	failures := q.Failures()
+	assert.Equal(t, 3, len(failures))
+	assert.Equal(t, "storage.example.com", failures[0].Host)
+	assert.Equal(t, oid, failures[0].Oid)
+	assert.Equal(t, "assets/big.bin", failures[0].Path)
+	assert.Equal(t, "upload", failures[0].Direction)
+	assert.Equal(t, "connection reset", failures[0].Err.Error())
+}
+
+func TestTransferQueueBoundsPendingTransfers(t *testing.T) {
+	defer stubFreeSpace(1 << 40)()
+
+	const total = 100000
+	const limit = 1000
+
+	q := &TransferQueue{
+		meter:         NewProgressMeter(0, 0, false),
+		workers:       4,
+		transferKind:  "download",
+		transferables: make(map[string]Transferable),
+		apic:          make(chan Transferable, batchSize),
+		transferc:     make(chan Transferable, batchSize),
+		retriesc:      make(chan Transferable, batchSize),
+		errorc:        make(chan error),
+		pending:       make(chan struct{}, limit),
+	}
+	q.meter.quiet = true
+	q.errorwait.Add(1)
+	q.retrywait.Add(1)
+	go q.errorCollector()
+	go q.retryCollector()
+	for i := 0; i < q.workers; i++ {
+		go q.transferWorker()
+		go q.individualApiRoutine(nil)
+	}
+
+	// a producer which finds objects faster than they can be transferred
+	for i := 0; i < total; i++ {
+		q.Grow(1, 10)
+		q.Add(&countedTransfer{oid: strconv.Itoa(i)})
+	}
+	q.wait.Wait()
+
+	close(q.errorc)
+	close(q.retriesc)
+	q.errorwait.Wait()
+	q.retrywait.Wait()
+
+	assert.Equal(t, 0, len(q.Errors()))
+	assert.Equal(t, int64(total), q.meter.finishedFiles)
+	assert.Equal(t, int64(total), q.meter.estimatedFiles)
+	assert.Equal(t, int64(total*10), q.meter.estimatedBytes)
+	assert.Equal(t, int32(0), q.pendingCount)
+	if q.maxPending > limit {
+		t.Errorf("Expected at most %d pending transfers, got %d", limit, q.maxPending)
+	}
+}
+
+// countedTransfer is a Transferable which needs transferring, and transfers
+// instantly.
+type countedTransfer struct {
+	oid string
+	obj *ObjectResource
+}
+
+func (c *countedTransfer) Check() (*ObjectResource, error) {
+	return &ObjectResource{Oid: c.oid, Size: 10}, nil
+}
+func (c *countedTransfer) Transfer(cb CopyCallback) error { return cb(10, 10, 10) }
+func (c *countedTransfer) Object() *ObjectResource        { return c.obj }
+func (c *countedTransfer) Oid() string                    { return c.oid }
+func (c *countedTransfer) Size() int64                    { return 10 }
+func (c *countedTransfer) Name() string                   { return c.oid }
+func (c *countedTransfer) SetObject(obj *ObjectResource)  { c.obj = obj }
+
+func TestTransferQueueRetriesFailedTransfers(t *testing.T) {
+	oldDelay := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = oldDelay }()
+
+	q := &TransferQueue{
+		meter:         NewProgressMeter(2, 20, false),
+		workers:       1,
+		maxRetries:    2,
+		transferKind:  "upload",
+		transferables: make(map[string]Transferable),
+		apic:          make(chan Transferable, batchSize),
+		transferc:     make(chan Transferable, batchSize),
+		retriesc:      make(chan Transferable, batchSize),
+		errorc:        make(chan error),
+		pending:       make(chan struct{}, batchSize),
+	}
+	q.meter.quiet = true
+	q.errorwait.Add(1)
+	q.retrywait.Add(1)
+	go q.errorCollector()
+	go q.retryCollector()
+	go q.transferWorker()
+	go q.individualApiRoutine(nil)
+
+	recovers := &flakyTransfer{oid: "recovers", failures: 2}
+	broken := &flakyTransfer{oid: "broken", failures: 3}
+	q.Add(recovers)
+	q.Add(broken)
+	q.Wait()
+
+	assert.Equal(t, 1, q.Transferred())
+	assert.Equal(t, 3, recovers.attempts)
+	assert.Equal(t, 3, broken.attempts)
+	assert.Equal(t, 1, len(q.Errors()))
+	assert.Equal(t, "broken (broken, upload, attempt 3/3): connection reset", q.Errors()[0].Error())
+}
+
+func TestTransferQueueRecordsResults(t *testing.T) {
+	oldDelay := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = oldDelay }()
+
+	q := &TransferQueue{
+		meter:         NewProgressMeter(3, 30, false),
+		workers:       1,
+		maxRetries:    2,
+		transferKind:  "upload",
+		transferables: make(map[string]Transferable),
+		apic:          make(chan Transferable, batchSize),
+		transferc:     make(chan Transferable, batchSize),
+		retriesc:      make(chan Transferable, batchSize),
+		errorc:        make(chan error),
+		pending:       make(chan struct{}, batchSize),
+		attempts:      make(map[string]*attempted),
+	}
+	q.meter.quiet = true
+	q.errorwait.Add(1)
+	q.retrywait.Add(1)
+	go q.errorCollector()
+	go q.retryCollector()
+	go q.transferWorker()
+	go q.individualApiRoutine(nil)
+
+	q.Add(&flakyTransfer{oid: "recovers", failures: 1})
+	q.Add(&presentTransfer{countedTransfer{oid: "present"}})
+	q.Add(&flakyTransfer{oid: "broken", failures: 3})
+	q.Wait()
+
+	results := make(map[string]TransferResult)
+	for _, r := range q.Results() {
+		results[r.Oid] = r
+	}
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, 1, results["recovers"].Retries)
+	assert.Equal(t, int64(10), results["recovers"].Size)
+	assert.Equal(t, "", results["recovers"].SkipReason)
+	assert.Equal(t, true, results["recovers"].Duration > 0)
+	assert.Equal(t, SkippedAlreadyPresent, results["present"].SkipReason)
+	assert.Equal(t, 1, len(q.Errors()))
+}
+
+// presentTransfer is a Transferable which the server already has.
+type presentTransfer struct {
+	countedTransfer
+}
+
+func (p *presentTransfer) Check() (*ObjectResource, error) { return nil, nil }
+
+func TestTransferWorkerWaitsWhenRateLimited(t *testing.T) {
+	q := &TransferQueue{
+		meter:        NewProgressMeter(1, 10, false),
+		maxRetries:   1,
+		transferKind: "upload",
+		transferc:    make(chan Transferable, 1),
+		retriesc:     make(chan Transferable, 1),
+		errorc:       make(chan error, 1),
+	}
+	q.meter.quiet = true
+	go q.transferWorker()
+
+	limited := &flakyTransfer{
+		oid:      "limited",
+		failures: 1,
+		err:      newRetryLaterError(errors.New("slow down"), 100*time.Millisecond),
+	}
+
+	start := time.Now()
+	q.wait.Add(1)
+	q.transferc <- limited
+	close(q.transferc)
+	q.wait.Wait()
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected the worker to wait for the rate limit, only took %s", elapsed)
+	}
+	assert.Equal(t, 1, q.Transferred())
+	assert.Equal(t, 2, limited.attempts)
+	assert.Equal(t, 0, len(q.retriesc))
+	assert.Equal(t, 0, len(q.errorc))
+}
+
+// flakyTransfer is a Transferable which fails with a retriable error the
+// given number of times before it succeeds.
+type flakyTransfer struct {
+	oid      string
+	failures int
+	attempts int
+	err      error
+	obj      *ObjectResource
+}
+
+func (f *flakyTransfer) Check() (*ObjectResource, error) {
+	return &ObjectResource{Oid: f.oid, Size: 10}, nil
+}
+func (f *flakyTransfer) Transfer(cb CopyCallback) error {
+	f.attempts++
+	if f.attempts <= f.failures {
+		if f.err != nil {
+			return f.err
+		}
+		return newRetriableError(errors.New("connection reset"))
+	}
+	return cb(10, 10, 10)
+}
+func (f *flakyTransfer) Object() *ObjectResource       { return f.obj }
+func (f *flakyTransfer) Oid() string                   { return f.oid }
+func (f *flakyTransfer) Size() int64                   { return 10 }
+func (f *flakyTransfer) Name() string                  { return f.oid }
+func (f *flakyTransfer) SetObject(obj *ObjectResource) { f.obj = obj }
+
+func TestTransferQueueScalesWorkersBySize(t *testing.T) {
+	q := &TransferQueue{
+		meter:         NewProgressMeter(0, 0, false),
+		workers:       2,
+		maxWorkers:    6,
+		smallSize:     100,
+		largeSlots:    make(chan struct{}, 2),
+		idleTime:      10 * time.Millisecond,
+		transferKind:  "upload",
+		transferables: make(map[string]Transferable),
+		apic:          make(chan Transferable, batchSize),
+		transferc:     make(chan Transferable, batchSize),
+		retriesc:      make(chan Transferable, batchSize),
+		errorc:        make(chan error),
+		pending:       make(chan struct{}, batchSize),
+	}
+	q.meter.quiet = true
+	q.errorwait.Add(1)
+	q.retrywait.Add(1)
+	go q.errorCollector()
+	go q.retryCollector()
+	atomic.StoreInt32(&q.runningCount, int32(q.workers))
+	for i := 0; i < q.workers; i++ {
+		go q.transferWorker()
+	}
+	go q.individualApiRoutine(nil)
+
+	// lots of small objects use up to maxWorkers workers
+	small := &concurrencyGauge{}
+	for i := 0; i < 30; i++ {
+		q.Grow(1, 10)
+		q.Add(&sizedTransfer{oid: "small" + strconv.Itoa(i), size: 10, gauge: small})
+	}
+	q.wait.Wait()
+	assert.Equal(t, 6, small.max)
+
+	// and the extra ones stop once they're idle
+	for i := 0; i < 100 && atomic.LoadInt32(&q.runningCount) > 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&q.runningCount))
+
+	// large objects are transferred lfs.concurrenttransfers at a time
+	large := &concurrencyGauge{}
+	for i := 0; i < 6; i++ {
+		q.Grow(1, 1000)
+		q.Add(&sizedTransfer{oid: "large" + strconv.Itoa(i), size: 1000, gauge: large})
+	}
+	q.Wait()
+
+	assert.Equal(t, 2, large.max)
+	assert.Equal(t, 0, len(q.Errors()))
+	assert.Equal(t, 36, q.Transferred())
+	assert.Equal(t, int64(36), q.meter.finishedFiles)
+	assert.Equal(t, int64(30*10+6*1000), q.meter.currentBytes)
+}
+
+// concurrencyGauge records the most transfers running at once.
+type concurrencyGauge struct {
+	mutex   sync.Mutex
+	current int
+	max     int
+}
+
+func (g *concurrencyGauge) enter() {
+	g.mutex.Lock()
+	g.current++
+	if g.current > g.max {
+		g.max = g.current
+	}
+	g.mutex.Unlock()
+}
+
+func (g *concurrencyGauge) leave() {
+	g.mutex.Lock()
+	g.current--
+	g.mutex.Unlock()
+}
+
+// sizedTransfer is a Transferable of the given size which takes a while to
+// transfer.
+type sizedTransfer struct {
+	oid   string
+	size  int64
+	gauge *concurrencyGauge
+	obj   *ObjectResource
+}
+
+func (s *sizedTransfer) Check() (*ObjectResource, error) {
+	return &ObjectResource{Oid: s.oid, Size: s.size}, nil
+}
+func (s *sizedTransfer) Transfer(cb CopyCallback) error {
+	s.gauge.enter()
+	defer s.gauge.leave()
+	time.Sleep(20 * time.Millisecond)
+	return cb(s.size, s.size, int(s.size))
+}
+func (s *sizedTransfer) Object() *ObjectResource       { return s.obj }
+func (s *sizedTransfer) Oid() string                   { return s.oid }
+func (s *sizedTransfer) Size() int64                   { return s.size }
+func (s *sizedTransfer) Name() string                  { return s.oid }
+func (s *sizedTransfer) SetObject(obj *ObjectResource) { s.obj = obj }
+
+func TestBatchObjectErrorsFailOnlyThoseObjects(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/media/objects/batch", func(w http.ResponseWriter, r *http.Request) {
+		download := map[string]*linkRelation{
+			"download": &linkRelation{Href: server.URL + "/download"},
+		}
+		objects := []*ObjectResource{
+			&ObjectResource{Oid: "ok", Size: 10, Actions: download},
+			&ObjectResource{Oid: "nosize", Size: 0, Actions: download},
+			&ObjectResource{Oid: "missing", Size: 10, Error: &ObjectError{Code: 404, Message: "Object does not exist"}},
+			&ObjectResource{Oid: "huge", Size: 10, Error: &ObjectError{Code: 422, Message: "exceeds server limit"}},
+			&ObjectResource{Oid: "broken", Size: 10, Error: &ObjectError{Code: 500, Message: "Internal error"}},
+			&ObjectResource{Oid: "corrupt", Size: 12, Actions: download},
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(map[string]interface{}{"objects": objects})
+	})
+
+	defer Config.ResetConfig()
+	Config.SetConfig("lfs.url", server.URL+"/media")
+
+	q := &TransferQueue{
+		meter:         NewProgressMeter(6, 60, false),
+		workers:       1,
+		transferKind:  "download",
+		transferables: make(map[string]Transferable),
+		batcher:       NewBatcher(batchSize),
+		apic:          make(chan Transferable, batchSize),
+		transferc:     make(chan Transferable, batchSize),
+		retriesc:      make(chan Transferable, batchSize),
+		errorc:        make(chan error),
+		pending:       make(chan struct{}, batchSize),
+	}
+	q.meter.quiet = true
+	q.errorwait.Add(1)
+	q.retrywait.Add(1)
+	go q.errorCollector()
+	go q.retryCollector()
+	go q.transferWorker()
+	go q.batchApiRoutine()
+
+	for _, oid := range []string{"ok", "nosize", "missing", "huge", "broken", "corrupt"} {
+		q.Add(&pathTransfer{countedTransfer{oid: oid}, "textures/" + oid + ".psd"})
+	}
+	q.Wait()
+
+	assert.Equal(t, 2, q.Transferred())
+
+	errs := make(map[string]string)
+	for _, err := range q.Errors() {
+		errs[TransferFailureOf(err).Oid] = err.Error()
+	}
+	assert.Equal(t, 4, len(errs))
+	assert.Equal(t, "textures/missing.psd (oid missin…): Object does not exist (404)", errs["missing"])
+	assert.Equal(t, "textures/huge.psd (oid huge): exceeds server limit (422)", errs["huge"])
+	assert.Equal(t, "textures/broken.psd (oid broken): Internal error (500)", errs["broken"])
+	assert.Equal(t, "textures/corrupt.psd (corrup, download, attempt 1/1): size mismatch, file may be corrupt locally: the pointer says 10 bytes, the server has 12", errs["corrupt"])
+}
+
+// pathTransfer is a Transferable for an object found at a path.
+type pathTransfer struct {
+	countedTransfer
+	path string
+}
+
+func (p *pathTransfer) Name() string { return p.path }
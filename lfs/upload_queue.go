@@ -1,9 +1,11 @@
 package lfs
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Uploadable describes a file that can be uploaded.
@@ -37,6 +39,54 @@ func NewUploadable(oid, filename string) (*Uploadable, error) {
 	return &Uploadable{oid: oid, OidPath: localMediaPath, Filename: filename, size: fi.Size()}, nil
 }
 
+// NewUploadablesForOids builds an Uploadable for each raw object oid, which
+// has no pointer or working tree file, as for push --object-id. Their sizes
+// come from local storage. An oid given more than once is only uploaded once.
+// If any oid isn't valid or isn't in local storage, the error lists all of
+// them, and no Uploadables are returned so that nothing is pushed.
+func NewUploadablesForOids(oids []string) ([]*Uploadable, error) {
+	uploadables := make([]*Uploadable, 0, len(oids))
+	seen := NewStringSetWithCapacity(len(oids))
+	var invalid, missing []string
+
+	for _, oid := range oids {
+		if seen.Contains(oid) {
+			continue
+		}
+		seen.Add(oid)
+
+		if len(oid) != 64 || !oidRE.MatchString(oid) {
+			invalid = append(invalid, oid)
+			continue
+		}
+
+		localMediaPath := LocalMediaPathReadOnly(oid)
+		fi, err := os.Stat(localMediaPath)
+		if os.IsNotExist(err) {
+			missing = append(missing, oid)
+			continue
+		}
+		if err != nil {
+			return nil, Errorf(err, "Error uploading object %s", oid)
+		}
+
+		uploadables = append(uploadables, &Uploadable{oid: oid, OidPath: localMediaPath, size: fi.Size()})
+	}
+
+	var problems []string
+	if len(invalid) > 0 {
+		problems = append(problems, fmt.Sprintf("Invalid object IDs:\n  %s", strings.Join(invalid, "\n  ")))
+	}
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("Objects not in local storage:\n  %s", strings.Join(missing, "\n  ")))
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "\n"))
+	}
+
+	return uploadables, nil
+}
+
 func (u *Uploadable) Check() (*ObjectResource, error) {
 	return UploadCheck(u.OidPath)
 }
@@ -72,8 +122,8 @@ func (u *Uploadable) SetObject(o *ObjectResource) {
 
 // NewUploadQueue builds an UploadQueue, allowing `workers` concurrent uploads.
 func NewUploadQueue(files int, size int64, dryRun bool) *TransferQueue {
-	q := newTransferQueue(files, size, dryRun)
-	q.transferKind = "upload"
+	q := newTransferQueue("upload", files, size, dryRun)
+	q.adapterNames = transferAdapterNames("upload")
 	return q
 }
 
@@ -98,7 +148,7 @@ func ensureFile(smudgePath, cleanPath string) error {
 		return err
 	}
 
-	cleaned, err := PointerClean(file, file.Name(), stat.Size(), nil)
+	cleaned, err := PointerClean(file, stat.Size(), nil)
 	if cleaned != nil {
 		cleaned.Teardown()
 	}
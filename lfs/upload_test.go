@@ -403,6 +403,10 @@ func TestSuccessfulUploadWithVerify(t *testing.T) {
 			t.Errorf("invalid size from request: %d", reqObj.Size)
 		}
 
+		if len(reqObj.Actions) > 0 {
+			t.Errorf("unexpected actions in request: %s", buf.String())
+		}
+
 		verifyCalled = true
 		w.WriteHeader(200)
 	})
@@ -907,6 +911,10 @@ func TestUploadVerifyError(t *testing.T) {
 		t.Fatal("should not panic")
 	}
 
+	if !IsRetriableError(err) {
+		t.Fatal("should be retried")
+	}
+
 	if err.Error() != fmt.Sprintf(defaultErrors[404], server.URL+"/verify") {
 		t.Fatalf("Unexpected error: %s", err.Error())
 	}
@@ -923,3 +931,34 @@ func TestUploadVerifyError(t *testing.T) {
 		t.Errorf("verify not called")
 	}
 }
+
+func TestNewUploadablesForOids(t *testing.T) {
+	ptr, _, cleanup := setupCloneTest(t, "content")
+	defer cleanup()
+	other := storeObject(t, "other content")
+
+	uploads, err := NewUploadablesForOids([]string{ptr.Oid, other.Oid, ptr.Oid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 2 {
+		t.Fatalf("expected 2 uploads, got %d", len(uploads))
+	}
+	if uploads[0].Oid() != ptr.Oid || uploads[0].Size() != 7 || uploads[0].Name() != "" {
+		t.Errorf("bad upload: %s %d %q", uploads[0].Oid(), uploads[0].Size(), uploads[0].Name())
+	}
+	if uploads[1].Oid() != other.Oid || uploads[1].Size() != 13 {
+		t.Errorf("bad upload: %s %d", uploads[1].Oid(), uploads[1].Size())
+	}
+
+	// nothing is returned if any are invalid or missing, and all are listed
+	missing := "0000000000000000000000000000000000000000000000000000000000000000"
+	uploads, err = NewUploadablesForOids([]string{ptr.Oid, "abc", missing, "../" + ptr.Oid[3:]})
+	if len(uploads) != 0 {
+		t.Errorf("expected no uploads, got %d", len(uploads))
+	}
+	expected := "Invalid object IDs:\n  abc\n  ../" + ptr.Oid[3:] + "\nObjects not in local storage:\n  " + missing
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
@@ -7,6 +7,10 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/github/git-lfs/localstorage"
 )
 
 type CallbackReader struct {
@@ -35,15 +39,29 @@ func (w *CallbackReader) Read(p []byte) (int, error) {
 
 	if n > 0 {
 		w.ReadSize += int64(n)
-	}
 
-	if err == nil && w.C != nil {
-		err = w.C(w.TotalSize, w.ReadSize, n)
+		// the last chunk may come with io.EOF, it still needs reporting
+		if w.C != nil {
+			if cbErr := w.C(w.TotalSize, w.ReadSize, n); cbErr != nil && err == nil {
+				err = cbErr
+			}
+		}
 	}
 
 	return n, err
 }
 
+// Object content is copied with buffers much larger than io.Copy's 32KB, which
+// are pooled to avoid allocating a new one for each transfer.
+const copyBufferSize = 512 * 1024
+
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
 func CopyWithCallback(writer io.Writer, reader io.Reader, totalSize int64, cb CopyCallback) (int64, error) {
 	if success, _ := CloneFile(writer, reader); success {
 		if cb != nil {
@@ -52,7 +70,7 @@ func CopyWithCallback(writer io.Writer, reader io.Reader, totalSize int64, cb Co
 		return totalSize, nil
 	}
 	if cb == nil {
-		return io.Copy(writer, reader)
+		return copyWithBuffer(writer, reader)
 	}
 
 	cbReader := &CallbackReader{
@@ -60,7 +78,16 @@ func CopyWithCallback(writer io.Writer, reader io.Reader, totalSize int64, cb Co
 		TotalSize: totalSize,
 		Reader:    reader,
 	}
-	return io.Copy(writer, cbReader)
+	return copyWithBuffer(writer, cbReader)
+}
+
+// copyWithBuffer copies reader to writer using a pooled buffer. Like io.Copy,
+// it uses the ReadFrom / WriteTo fast paths where they're supported.
+func copyWithBuffer(writer io.Writer, reader io.Reader) (int64, error) {
+	bufp := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(bufp)
+
+	return io.CopyBuffer(writer, reader, *bufp)
 }
 
 func CopyCallbackFile(event, filename string, index, totalFiles int) (CopyCallback, *os.File, error) {
@@ -84,15 +111,29 @@ func CopyCallbackFile(event, filename string, index, totalFiles int) (CopyCallba
 	}
 
 	var prevWritten int64
+	var logged time.Time
+	var failed bool
 
+	// Failing to write progress must not fail the command, so just warn once
 	cb := CopyCallback(func(total int64, written int64, current int) error {
-		if written != prevWritten {
-			_, err := file.Write([]byte(fmt.Sprintf("%s %d/%d %d/%d %s\n", event, index, totalFiles, written, total, filename)))
-			file.Sync()
-			prevWritten = written
-			return wrapProgressError(err, event, logPath)
+		if failed || written == prevWritten {
+			return nil
+		}
+		prevWritten = written
+
+		// Like the ProgressMeter, log the last of it, and the rest ~10 times a second
+		now := time.Now()
+		if written != total && now.Sub(logged) < progressLogInterval {
+			return nil
 		}
+		logged = now
+
+		_, err := file.Write([]byte(fmt.Sprintf("%s %d/%d %d/%d %s\n", event, index, totalFiles, written, total, filename)))
 
+		if err != nil {
+			failed = true
+			fmt.Fprintln(os.Stderr, wrapProgressError(err, event, logPath))
+		}
 		return nil
 	})
 
@@ -112,66 +153,130 @@ var localDirSet = NewStringSetFromSlice([]string{".", "./", ".\\"})
 // Return whether a given filename passes the include / exclude path filters
 // Only paths that are in includePaths and outside excludePaths are passed
 // If includePaths is empty that filter always passes and the same with excludePaths
-// Both path lists support wildcard matches
+// Both path lists support wildcard matches, and negated patterns as described
+// for filterPatternsMatch
 func FilenamePassesIncludeExcludeFilter(filename string, includePaths, excludePaths []string) bool {
 	if len(includePaths) == 0 && len(excludePaths) == 0 {
 		return true
 	}
 
+	if len(includePaths) > 0 && !filterPatternsMatch(filename, includePaths) {
+		return false
+	}
+
+	if len(excludePaths) > 0 && filterPatternsMatch(filename, excludePaths) {
+		return false
+	}
+
+	return true
+}
+
+// ValidateFilterPatterns returns an error if patterns starts with a negated
+// pattern. That can only re-include paths matched by patterns before it, so it
+// does nothing, and a list of only negated patterns matches nothing at all.
+func ValidateFilterPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if len(pattern) == 0 {
+			continue
+		}
+		if _, negated := parseFilterPattern(pattern); negated {
+			return fmt.Errorf("%q can't come first, negated patterns only re-include paths matched by the patterns before them", pattern)
+		}
+		break
+	}
+	return nil
+}
+
+// filterPatternsMatch returns whether filename matches a list of include or
+// exclude patterns. Like .gitignore patterns they're applied in order, and the
+// last one that matches wins: a pattern starting with "!" un-matches paths that
+// earlier patterns matched, and a later pattern can match them again. So
+// "assets/**, !assets/previews/**" matches everything in assets except the
+// previews. A leading "\!" matches a literal "!".
+func filterPatternsMatch(filename string, patterns []string) bool {
+	matched := false
+	for _, p := range patterns {
+		pattern, negated := parseFilterPattern(p)
+		if negated != matched {
+			// can't change the result
+			continue
+		}
+		if filterPatternMatches(pattern, filename) {
+			matched = !negated
+		}
+	}
+	return matched
+}
+
+func parseFilterPattern(pattern string) (string, bool) {
+	if strings.HasPrefix(pattern, "!") {
+		return pattern[1:], true
+	}
+	if strings.HasPrefix(pattern, "\\!") {
+		return pattern[1:], false
+	}
+	return pattern, false
+}
+
+// filterPatternMatches returns whether filename, which has / separators,
+// matches pattern with filepath.Match, or is in a directory named by pattern.
+// A pattern with a "**" component is matched a component at a time instead,
+// and the "**" matches any number of directories.
+func filterPatternMatches(pattern, filename string) bool {
+	// Special case local dir, matches all (inc subpaths)
+	if _, local := localDirSet[pattern]; local {
+		return true
+	}
+
+	if components := strings.Split(strings.TrimSuffix(filepath.ToSlash(pattern), "/"), "/"); hasDoubleStar(components) {
+		return matchPathComponents(components, strings.Split(filepath.ToSlash(filename), "/"))
+	}
+
 	// For Win32, because git reports files with / separators
 	cleanfilename := filepath.Clean(filename)
-	if len(includePaths) > 0 {
-		matched := false
-		for _, inc := range includePaths {
-			// Special case local dir, matches all (inc subpaths)
-			if _, local := localDirSet[inc]; local {
-				matched = true
-				break
-			}
-			matched, _ = filepath.Match(inc, filename)
-			if !matched && IsWindows() {
-				// Also Win32 match
-				matched, _ = filepath.Match(inc, cleanfilename)
-			}
-			if !matched {
-				// Also support matching a parent directory without a wildcard
-				if strings.HasPrefix(cleanfilename, inc+string(filepath.Separator)) {
-					matched = true
-				}
-			}
-			if matched {
-				break
-			}
+	matched, _ := filepath.Match(pattern, filename)
+	if !matched && IsWindows() {
+		// Also Win32 match
+		matched, _ = filepath.Match(pattern, cleanfilename)
+	}
+	if !matched {
+		// Also support matching a parent directory without a wildcard
+		matched = strings.HasPrefix(cleanfilename, pattern+string(filepath.Separator))
+	}
+	return matched
+}
 
-		}
-		if !matched {
-			return false
+func hasDoubleStar(components []string) bool {
+	for _, c := range components {
+		if c == "**" {
+			return true
 		}
 	}
+	return false
+}
 
-	if len(excludePaths) > 0 {
-		for _, ex := range excludePaths {
-			// Special case local dir, matches all (inc subpaths)
-			if _, local := localDirSet[ex]; local {
-				return false
-			}
-			matched, _ := filepath.Match(ex, filename)
-			if !matched && IsWindows() {
-				// Also Win32 match
-				matched, _ = filepath.Match(ex, cleanfilename)
-			}
-			if matched {
-				return false
-			}
-			// Also support matching a parent directory without a wildcard
-			if strings.HasPrefix(cleanfilename, ex+string(filepath.Separator)) {
-				return false
-			}
+func matchPathComponents(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		// all of path matched, or a directory containing it
+		return true
+	}
 
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchPathComponents(pattern[1:], path[i:]) {
+				return true
+			}
 		}
+		return false
 	}
 
-	return true
+	if len(path) == 0 {
+		return false
+	}
+	if matched, _ := filepath.Match(pattern[0], path[0]); !matched {
+		return false
+	}
+	return matchPathComponents(pattern[1:], path[1:])
 }
 
 func GetPlatform() Platform {
@@ -296,7 +401,7 @@ func IsWindows() bool {
 
 // FileOrDirExists determines if a file/dir exists, returns IsDir() results too.
 func FileOrDirExists(path string) (exists bool, isDir bool) {
-	fi, err := os.Stat(path)
+	fi, err := os.Stat(localstorage.LongPath(path))
 	if err != nil {
 		return false, false
 	} else {
@@ -318,7 +423,7 @@ func DirExists(path string) bool {
 
 // FileExistsOfSize determines if a file exists and is of a specific size.
 func FileExistsOfSize(path string, sz int64) bool {
-	fi, err := os.Stat(path)
+	fi, err := os.Stat(localstorage.LongPath(path))
 
 	if err != nil {
 		return false
@@ -0,0 +1,51 @@
+// +build darwin,cgo
+
+package lfs
+
+/*
+#include <stdlib.h>
+#include <sys/attr.h>
+#include <sys/clonefile.h>
+*/
+import "C"
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// CloneFile can't clone between open files on OS X, see CloneFileByPaths.
+func CloneFile(writer io.Writer, reader io.Reader) (bool, error) {
+	return false, nil
+}
+
+// CloneFileByPaths replaces dst with a copy-on-write clone of src using
+// clonefile(2). It returns false if the filesystem can't do this, e.g. EXDEV
+// if they're on different volumes or ENOTSUP on HFS+.
+func CloneFileByPaths(dst, src string) (bool, error) {
+	csrc := C.CString(src)
+	defer C.free(unsafe.Pointer(csrc))
+	cdst := C.CString(dst)
+	defer C.free(unsafe.Pointer(cdst))
+
+	// clonefile won't overwrite an existing file
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if ret, err := C.clonefile(csrc, cdst, C.CLONE_NOFOLLOW); ret != 0 {
+		return false, err
+	}
+
+	// clonefile copies the mode of the object, give the working copy the same
+	// permissions a newly created file would have
+	umask := syscall.Umask(0)
+	syscall.Umask(umask)
+	if err := os.Chmod(dst, os.FileMode(0666&^umask)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
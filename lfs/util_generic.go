@@ -1,4 +1,5 @@
-// +build !linux !cgo
+// +build !linux
+// +build !darwin !cgo
 
 package lfs
 
@@ -9,3 +10,7 @@ import (
 func CloneFile(writer io.Writer, reader io.Reader) (bool, error) {
 	return false, nil
 }
+
+func CloneFileByPaths(dst, src string) (bool, error) {
+	return false, nil
+}
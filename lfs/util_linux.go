@@ -1,17 +1,7 @@
-// +build linux,cgo
+// +build linux
 
 package lfs
 
-/*
-#include <sys/ioctl.h>
-
-#undef BTRFS_IOCTL_MAGIC
-#define BTRFS_IOCTL_MAGIC 0x94
-#undef BTRFS_IOC_CLONE
-#define BTRFS_IOC_CLONE _IOW (BTRFS_IOCTL_MAGIC, 9, int)
-*/
-import "C"
-
 import (
 	"io"
 	"os"
@@ -19,17 +9,41 @@ import (
 )
 
 const (
-	BtrfsIocClone = C.BTRFS_IOC_CLONE
+	// FICLONE is _IOW(0x94, 9, int). It started out as BTRFS_IOC_CLONE and is
+	// now also supported by XFS (reflink=1) and others.
+	ficlone = 0x40049409
+
+	// Kept for compatibility, this is the same ioctl
+	BtrfsIocClone = ficlone
 )
 
 func CloneFile(writer io.Writer, reader io.Reader) (bool, error) {
 	fdst, fdstFound := writer.(*os.File)
 	fsrc, fsrcFound := reader.(*os.File)
 	if fdstFound && fsrcFound {
-		if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, fdst.Fd(), BtrfsIocClone, fsrc.Fd()); err != 0 {
+		if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, fdst.Fd(), ficlone, fsrc.Fd()); err != 0 {
 			return false, err
 		}
 		return true, nil
 	}
 	return false, nil
 }
+
+// CloneFileByPaths creates (or truncates) dst as a copy-on-write clone of src.
+// It returns false if the filesystem can't do this, e.g. EXDEV if they're on
+// different filesystems or EOPNOTSUPP if cloning isn't supported at all.
+func CloneFileByPaths(dst, src string) (bool, error) {
+	fsrc, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer fsrc.Close()
+
+	fdst, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return false, err
+	}
+	defer fdst.Close()
+
+	return CloneFile(fdst, fsrc)
+}
@@ -0,0 +1,122 @@
+package localstorage
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The access log records when objects were last read for checkout, so prune
+// can keep objects that are still in use even though the commits that
+// introduced them are old. Each read appends a line of "<oid> <unix time>",
+// which is cheap, and atomic for concurrent appenders on local filesystems.
+// Nothing is synced: losing the last few entries in a crash only makes objects
+// look older. Prune compacts the log, see CompactAccessLog.
+const accessLogFile = "access.log"
+
+func (s *LocalStorage) accessLogPath() string {
+	return filepath.Join(filepath.Dir(s.RootDir), accessLogFile)
+}
+
+// RecordAccess notes that the object oid was just read. The log's permissions
+// are only adjusted for the shared mode when it's created.
+func (s *LocalStorage) RecordAccess(oid string) error {
+	path := s.accessLogPath()
+	f, err := os.OpenFile(LongPath(path), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+		if err := AdjustPerms(path); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(f, "%s %d\n", oid, time.Now().Unix())
+	return err
+}
+
+// AccessTimes returns the last recorded access time of each object in the
+// access log. Objects with no entry haven't been read since the log was
+// started, or not since it was last compacted.
+func (s *LocalStorage) AccessTimes() (map[string]time.Time, error) {
+	times := make(map[string]time.Time)
+
+	f, err := os.Open(LongPath(s.accessLogPath()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return times, nil
+		}
+		return times, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || !isOid(fields[0]) {
+			// eg a line cut short by a crash
+			continue
+		}
+
+		secs, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		t := time.Unix(secs, 0)
+		if t.After(times[fields[0]]) {
+			times[fields[0]] = t
+		}
+	}
+	return times, scanner.Err()
+}
+
+// CompactAccessLog rewrites the access log with one entry for each object that
+// still exists and was read after since, dropping everything else. Reads
+// recorded while it runs may be lost, which only makes their objects look older.
+func (s *LocalStorage) CompactAccessLog(since time.Time) error {
+	times, err := s.AccessTimes()
+	if err != nil {
+		return err
+	}
+
+	oids := make([]string, 0, len(times))
+	for oid, t := range times {
+		if t.After(since) && objectExists(filepath.Join(localObjectDir(s, oid), oid)) {
+			oids = append(oids, oid)
+		}
+	}
+	sort.Strings(oids)
+
+	path := s.accessLogPath()
+	tmp, err := ioutil.TempFile(LongPath(filepath.Dir(path)), accessLogFile+"-")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, oid := range oids {
+		fmt.Fprintf(w, "%s %d\n", oid, times[oid].Unix())
+	}
+	err = w.Flush()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), LongPath(path))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return AdjustPerms(path)
+}
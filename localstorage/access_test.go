@@ -0,0 +1,58 @@
+package localstorage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestObjectAccessLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-access")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	// no log yet
+	times, err := s.AccessTimes()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(times))
+
+	used := strings.Repeat("a", 64)
+	old := strings.Repeat("b", 64)
+	gone := strings.Repeat("c", 64)
+	for _, oid := range []string{used, old} {
+		path, err := s.BuildObjectPath(oid)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, ioutil.WriteFile(path, []byte(oid), 0644))
+	}
+
+	logPath := filepath.Join(dir, "access.log")
+	ioutil.WriteFile(logPath, []byte(old+" 1000\n"+used+" 1000\n"+gone+" 1000\nabc\n"+used[0:10]+"\n"), 0644)
+	assert.Equal(t, nil, s.RecordAccess(used))
+	assert.Equal(t, nil, s.RecordAccess(gone))
+
+	times, err = s.AccessTimes()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(times))
+	assert.Equal(t, time.Unix(1000, 0), times[old])
+	assert.Equal(t, true, time.Since(times[used]) < time.Minute)
+
+	assert.Equal(t, nil, s.CompactAccessLog(time.Now().Add(-time.Hour)))
+	times, err = s.AccessTimes()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(times))
+	_, ok := times[used]
+	assert.Equal(t, true, ok)
+}
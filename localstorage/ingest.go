@@ -0,0 +1,131 @@
+package localstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+)
+
+// Renames refused because the object is in use are tried this many times
+const maxSharingRetries = 5
+
+// Concurrency invariants for object storage, which may be shared by several
+// repositories (see lfs.storage / lfs.sharedstorage) and written to by several
+// processes at once:
+//
+//   * Objects are named by the SHA-256 of their content and are never modified
+//     once in place, so readers never need to take a lock.
+//   * Writers always write & close a uniquely named temp file first, then
+//     rename it into place. Rename is atomic, so other processes either see no
+//     object or a complete one, never a partial one. If the temp dir is on
+//     another filesystem the file is first copied next to the object, and
+//     renamed from there (see RenameFile).
+//   * Temp files have unique names, from ioutil.TempFile, so processes and
+//     goroutines writing the same object never write to the same temp file.
+//   * If the object already exists, whoever got there first wins: because the
+//     content is identical the later temp file is simply discarded. If the
+//     rename fails because the object appeared meanwhile, the object that won
+//     is verified before the failure is ignored.
+//   * Nothing is stored under a name its content doesn't hash to, whatever
+//     wrote the temp file. See IngestObject.
+
+// IngestObject moves the fully written temp file at tempPath into storage as
+// the object oid. If the object is already present, including when another
+// process stores it concurrently, the temp file is removed instead, without
+// checking it or whether it's still there.
+//
+// Otherwise the temp file must be size bytes long and hash to oid, or it's
+// removed and an error returned. Callers that computed oid from the exact bytes they wrote to
+// the temp file, like the clean filter, can pass hashed to skip reading it
+// back; its size is still checked.
+func (s *LocalStorage) IngestObject(tempPath, oid string, size int64, hashed bool) error {
+	path, err := s.BuildObjectPath(oid)
+	if err != nil {
+		return err
+	}
+
+	if objectExistsOfSize(path, size) {
+		os.Remove(LongPath(tempPath))
+		return nil
+	}
+
+	if err := verifyObject(tempPath, oid, size, hashed); err != nil {
+		os.Remove(LongPath(tempPath))
+		return err
+	}
+
+	if err := renameObject(tempPath, path); err != nil {
+		// Lost a race with another writer of the same object
+		if verifyObject(path, oid, size, false) == nil {
+			os.Remove(LongPath(tempPath))
+			return nil
+		}
+		return fmt.Errorf("Unable to move %s to %s: %s", tempPath, path, err)
+	}
+
+	// temp files are only readable by their owner
+	return AdjustPerms(path)
+}
+
+// renameObject moves tempPath to path with RenameFile, retrying for a while if
+// Windows refuses because the object is open in another process, as happens
+// when it's being read just after another writer stored it.
+func renameObject(tempPath, path string) error {
+	var err error
+	for attempt := 1; attempt <= maxSharingRetries; attempt++ {
+		err = RenameFile(tempPath, path)
+		if err == nil || !isSharingViolation(err) {
+			return err
+		}
+
+		tracerx.Printf("%s is in use, retrying rename: %s", path, err)
+		time.Sleep(time.Duration(attempt) * 10 * time.Millisecond)
+	}
+	return err
+}
+
+// verifyObject checks the file at path has the given size and, unless hashed,
+// that its content hashes to oid.
+func verifyObject(path, oid string, size int64, hashed bool) error {
+	f, err := os.Open(LongPath(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() != size {
+		return fmt.Errorf("Expected object %s to be %d bytes, got %d bytes in %s", oid, size, fi.Size(), path)
+	}
+
+	if hashed {
+		return nil
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return fmt.Errorf("Unable to verify %s: %s", path, err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != oid {
+		return fmt.Errorf("Expected OID %s, got %s in %s", oid, actual, path)
+	}
+	return nil
+}
+
+func objectExistsOfSize(path string, size int64) bool {
+	fi, err := os.Stat(LongPath(path))
+	return err == nil && fi.Mode().IsRegular() && fi.Size() == size
+}
+
+func objectExists(path string) bool {
+	fi, err := os.Stat(LongPath(path))
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,16 @@
+// +build !windows
+
+package localstorage
+
+import (
+	"os"
+	"syscall"
+)
+
+// linkCount returns how many hard links the file described by info has.
+func linkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
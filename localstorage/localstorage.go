@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"time"
 )
 
 const (
@@ -24,33 +25,52 @@ type LocalStorage struct {
 
 // Object represents a locally stored LFS object.
 type Object struct {
-	Oid  string
-	Size int64
+	Oid     string
+	Size    int64
+	ModTime time.Time
 }
 
 func New(storageDir, tempDir string) (*LocalStorage, error) {
-	if err := os.MkdirAll(storageDir, dirPerms); err != nil {
+	if err := MkdirAll(storageDir); err != nil {
 		return nil, err
 	}
 
-	if err := os.MkdirAll(tempDir, dirPerms); err != nil {
+	if err := MkdirAll(tempDir); err != nil {
 		return nil, err
 	}
 
 	return &LocalStorage{storageDir, tempDir}, nil
 }
 
+// ObjectPath returns the path to the object oid for reading. This is in the
+// sharded layout unless the object has only been stored in the legacy flat
+// layout, see MigrateLegacyObjects.
 func (s *LocalStorage) ObjectPath(oid string) string {
-	return filepath.Join(localObjectDir(s, oid), oid)
+	path := filepath.Join(localObjectDir(s, oid), oid)
+	if _, err := os.Stat(LongPath(path)); os.IsNotExist(err) {
+		legacyPath := legacyObjectPath(s, oid)
+		if _, err := os.Stat(LongPath(legacyPath)); err == nil {
+			return legacyPath
+		}
+	}
+	return path
 }
 
+// BuildObjectPath returns the path to the object oid in the sharded layout,
+// creating its dir as needed. An object stored in the legacy layout is moved
+// there first.
 func (s *LocalStorage) BuildObjectPath(oid string) (string, error) {
 	dir := localObjectDir(s, oid)
-	if err := os.MkdirAll(dir, dirPerms); err != nil {
+	if err := MkdirAll(dir); err != nil {
 		return "", fmt.Errorf("Error trying to create local storage directory in %q: %s", dir, err)
 	}
 
-	return filepath.Join(dir, oid), nil
+	path := filepath.Join(dir, oid)
+	if err := migrateLegacyObject(s, oid, path); err != nil {
+		return "", err
+	}
+
+	return path, nil
 }
 
 func localObjectDir(s *LocalStorage, oid string) string {
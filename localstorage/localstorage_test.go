@@ -0,0 +1,243 @@
+package localstorage_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestConcurrentIngestObject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-localstorage")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("shared object "), 4096)
+	sum := sha256.Sum256(content)
+	oid := hex.EncodeToString(sum[:])
+
+	// Two repos sharing the same object dir but with their own temp dirs
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp", []string{"a", "b"}[i%2]))
+		if err != nil {
+			t.Fatalf("Unable to create storage: %s", err)
+		}
+
+		f, err := ioutil.TempFile(s.TempDir, oid)
+		if err != nil {
+			t.Fatalf("Unable to create temp file: %s", err)
+		}
+		f.Write(content)
+		f.Close()
+
+		wg.Add(1)
+		go func(s *localstorage.LocalStorage, tempPath string) {
+			defer wg.Done()
+			errs <- s.IngestObject(tempPath, oid, int64(len(content)), false)
+		}(s, f.Name())
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.Equal(t, nil, err)
+	}
+
+	s, _ := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp", "a"))
+	stored, err := ioutil.ReadFile(s.ObjectPath(oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, stored))
+
+	for _, tmp := range []string{"a", "b"} {
+		leftover, _ := ioutil.ReadDir(filepath.Join(dir, "tmp", tmp))
+		assert.Equal(t, 0, len(leftover))
+	}
+}
+
+func TestIngestObjectVerifiesContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-localstorage")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	content := []byte("the right content")
+	sum := sha256.Sum256(content)
+	oid := hex.EncodeToString(sum[:])
+	wrong := []byte("the wrong content")
+	wrongSum := sha256.Sum256(wrong)
+
+	writeTemp := func(content []byte) string {
+		f, err := ioutil.TempFile(s.TempDir, oid)
+		if err != nil {
+			t.Fatalf("Unable to create temp file: %s", err)
+		}
+		f.Write(content)
+		f.Close()
+		return f.Name()
+	}
+
+	// wrong content of the right size
+	tempPath := writeTemp(wrong)
+	err = s.IngestObject(tempPath, oid, int64(len(content)), false)
+	assert.Equal(t, "Expected OID "+oid+", got "+hex.EncodeToString(wrongSum[:])+" in "+tempPath, err.Error())
+	assertNotStored(t, s, oid, tempPath)
+
+	// a truncated file is caught even when the caller hashed what it wrote
+	tempPath = writeTemp(content[0:5])
+	err = s.IngestObject(tempPath, oid, int64(len(content)), true)
+	assert.Equal(t, "Expected object "+oid+" to be 17 bytes, got 5 bytes in "+tempPath, err.Error())
+	assertNotStored(t, s, oid, tempPath)
+
+	tempPath = writeTemp(content)
+	assert.Equal(t, nil, s.IngestObject(tempPath, oid, int64(len(content)), false))
+	stored, err := ioutil.ReadFile(s.ObjectPath(oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, string(content), string(stored))
+}
+
+func assertNotStored(t *testing.T, s *localstorage.LocalStorage, oid, tempPath string) {
+	_, err := os.Stat(s.ObjectPath(oid))
+	assert.Equal(t, true, os.IsNotExist(err))
+	_, err = os.Stat(tempPath)
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+func TestIngestObjectRaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-localstorage")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	content := bytes.Repeat([]byte("raced object "), 8192)
+	sum := sha256.Sum256(content)
+	oid := hex.EncodeToString(sum[:])
+
+	for i := 0; i < 50; i++ {
+		os.Remove(s.ObjectPath(oid))
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 2)
+		for j := 0; j < 2; j++ {
+			f, err := ioutil.TempFile(s.TempDir, oid)
+			if err != nil {
+				t.Fatalf("Unable to create temp file: %s", err)
+			}
+			f.Write(content)
+			f.Close()
+
+			wg.Add(1)
+			go func(tempPath string) {
+				defer wg.Done()
+				errs <- s.IngestObject(tempPath, oid, int64(len(content)), false)
+			}(f.Name())
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			assert.Equal(t, nil, err)
+		}
+
+		stored, err := ioutil.ReadFile(s.ObjectPath(oid))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, bytes.Equal(content, stored))
+	}
+
+	leftover, _ := ioutil.ReadDir(s.TempDir)
+	assert.Equal(t, 0, len(leftover))
+}
+
+func TestIngestObjectDiscardsTempForExistingObject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-localstorage")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	content := []byte("stored object")
+	sum := sha256.Sum256(content)
+	oid := hex.EncodeToString(sum[:])
+
+	path, err := s.BuildObjectPath(oid)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, ioutil.WriteFile(path, content, 0644))
+
+	// a truncated temp file is discarded, as the object is already there
+	f, err := ioutil.TempFile(s.TempDir, oid)
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	f.Write(content[0:5])
+	f.Close()
+	assert.Equal(t, nil, s.IngestObject(f.Name(), oid, int64(len(content)), false))
+	_, err = os.Stat(f.Name())
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	// and so is one that's already gone
+	assert.Equal(t, nil, s.IngestObject(f.Name(), oid, int64(len(content)), false))
+
+	stored, err := ioutil.ReadFile(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, string(content), string(stored))
+}
+
+func TestIngestObjectReplacesDamagedObject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-localstorage")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	content := []byte("complete object")
+	sum := sha256.Sum256(content)
+	oid := hex.EncodeToString(sum[:])
+
+	path, err := s.BuildObjectPath(oid)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, ioutil.WriteFile(path, content[0:8], 0644))
+
+	f, err := ioutil.TempFile(s.TempDir, oid)
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	f.Write(content)
+	f.Close()
+
+	assert.Equal(t, nil, s.IngestObject(f.Name(), oid, int64(len(content)), false))
+	stored, err := ioutil.ReadFile(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, string(content), string(stored))
+}
@@ -0,0 +1,8 @@
+// +build !windows
+
+package localstorage
+
+// LongPath returns path unchanged; only Windows limits path length to MAX_PATH.
+func LongPath(path string) string {
+	return path
+}
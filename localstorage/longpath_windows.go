@@ -0,0 +1,31 @@
+// +build windows
+
+package localstorage
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// LongPath returns path in the \\?\ extended-length form, which lets file
+// operations go past MAX_PATH (260 characters). UNC paths become
+// \\?\UNC\server\share\... Relative paths are made absolute first, since
+// extended-length paths can't be relative, so the result should only be used
+// for file operations: display it or pass it to git as-is.
+func LongPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`) {
+		return path
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+
+	// Abs cleans the path too, which matters because extended-length paths are
+	// passed to the filesystem unparsed, so "/", "." and ".." aren't understood.
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,78 @@
+package localstorage_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestLongPath(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	cases := map[string]string{
+		`C:\foo\bar`:                `\\?\C:\foo\bar`,
+		`C:/foo/./baz/../bar`:       `\\?\C:\foo\bar`,
+		`\\server\share\foo`:        `\\?\UNC\server\share\foo`,
+		`\\?\C:\already\long`:       `\\?\C:\already\long`,
+		`\\?\UNC\server\share\long`: `\\?\UNC\server\share\long`,
+		`\\.\pipe\git-lfs`:          `\\.\pipe\git-lfs`,
+		`relative\path`:             `\\?\` + filepath.Join(wd, "relative", "path"),
+	}
+
+	for path, expected := range cases {
+		assert.Equal(t, expected, localstorage.LongPath(path))
+	}
+}
+
+func TestObjectBeyondMaxPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-longpath")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(localstorage.LongPath(dir))
+
+	deep := dir
+	for len(deep) < 300 {
+		deep = filepath.Join(deep, strings.Repeat("d", 50))
+	}
+
+	s, err := localstorage.New(filepath.Join(deep, "objects"), filepath.Join(deep, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	content := []byte("an object stored beyond MAX_PATH")
+	sum := sha256.Sum256(content)
+	oid := hex.EncodeToString(sum[:])
+
+	f, err := ioutil.TempFile(localstorage.LongPath(s.TempDir), oid)
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	f.Write(content)
+	f.Close()
+
+	assert.Equal(t, nil, s.IngestObject(f.Name(), oid, int64(len(content)), false))
+
+	path := s.ObjectPath(oid)
+	assert.Equal(t, true, len(path) > 300)
+	// Paths are still kept in the normal form, for display
+	assert.Equal(t, false, strings.HasPrefix(path, `\\?\`))
+
+	stored, err := ioutil.ReadFile(localstorage.LongPath(path))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, stored))
+
+	count, size, err := s.DiskUsage()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, int64(len(content)), size)
+}
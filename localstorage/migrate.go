@@ -0,0 +1,118 @@
+package localstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+)
+
+// Written to RootDir once all objects are known to be in the sharded layout
+const shardedMarkerFile = ".sharded"
+
+// MigrateLegacyObjects moves objects stored directly in RootDir by old versions
+// (objects/<oid>) to the sharded layout (objects/<oid[0:2]>/<oid[2:4]>/<oid>).
+// A marker file records when this has been done, so it only scans once per
+// storage dir. Returns the number of legacy objects dealt with.
+func (s *LocalStorage) MigrateLegacyObjects() (int, error) {
+	marker := filepath.Join(s.RootDir, shardedMarkerFile)
+	if _, err := os.Stat(LongPath(marker)); err == nil {
+		return 0, nil
+	}
+
+	entries, err := ioutil.ReadDir(LongPath(s.RootDir))
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, entry := range entries {
+		oid := entry.Name()
+		if !entry.Mode().IsRegular() || !isOid(oid) {
+			continue
+		}
+
+		if _, err := s.BuildObjectPath(oid); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	if err := ioutil.WriteFile(LongPath(marker), nil, 0644); err != nil {
+		return migrated, err
+	}
+	return migrated, nil
+}
+
+// migrateLegacyObject moves the object oid to path if it is stored in the
+// legacy layout. When the object is in both places, the one with the correct
+// content is kept.
+func migrateLegacyObject(s *LocalStorage, oid, path string) error {
+	legacyPath := legacyObjectPath(s, oid)
+	if _, err := os.Stat(LongPath(legacyPath)); err != nil {
+		return nil
+	}
+
+	if objectExists(path) {
+		if actual, _ := hashFile(path); actual != oid {
+			legacy, err := hashFile(legacyPath)
+			if err != nil {
+				// it may be the good copy, so leave it be
+				tracerx.Printf("Unable to read legacy object %s: %s", legacyPath, err)
+				return nil
+			}
+
+			if legacy == oid {
+				tracerx.Printf("Replacing corrupt object %s with legacy copy", path)
+				return renameLegacyObject(legacyPath, path)
+			}
+		}
+
+		tracerx.Printf("Removing duplicate legacy object %s", legacyPath)
+		os.Remove(LongPath(legacyPath))
+		return nil
+	}
+
+	tracerx.Printf("Moving legacy object %s to %s", legacyPath, path)
+	return renameLegacyObject(legacyPath, path)
+}
+
+// renameLegacyObject moves legacyPath to path, copying it if the shard dirs
+// are on another filesystem.
+func renameLegacyObject(legacyPath, path string) error {
+	if err := RenameFile(legacyPath, path); err != nil {
+		// Another process may have migrated it first
+		if objectExists(path) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func legacyObjectPath(s *LocalStorage, oid string) string {
+	return filepath.Join(s.RootDir, oid)
+}
+
+func isOid(name string) bool {
+	return len(name) == 64 && oidRE.MatchString(name)
+}
+
+// hashFile returns the SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(LongPath(path))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
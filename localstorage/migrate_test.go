@@ -0,0 +1,85 @@
+package localstorage_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestMigrateLegacyObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-migrate")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	// fabricate a legacy layout, with one object also in the sharded layout
+	oid1 := writeLegacyObject(t, s, "legacy 1")
+	oid2 := writeLegacyObject(t, s, "legacy 2")
+	oid3 := writeLegacyObject(t, s, "in both layouts")
+	path3, err := s.BuildObjectPath(oid3)
+	assert.Equal(t, nil, err)
+	writeLegacyObject(t, s, "in both layouts")
+	err = ioutil.WriteFile(path3, []byte("corrupt"), 0644)
+	assert.Equal(t, nil, err)
+
+	// reads work before migrating
+	assertObject(t, s, oid1, "legacy 1")
+	assertObject(t, s, oid2, "legacy 2")
+	assert.Equal(t, 4, len(s.AllObjects())) // oid3 is listed twice until migrated
+
+	// and after migrating just one object on write
+	path1, err := s.BuildObjectPath(oid1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, path1, s.ObjectPath(oid1))
+	assertObject(t, s, oid1, "legacy 1")
+	assertObject(t, s, oid2, "legacy 2")
+
+	n, err := s.MigrateLegacyObjects()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, n) // oid1 was already moved
+
+	for _, oid := range []string{oid1, oid2, oid3} {
+		_, err := os.Stat(filepath.Join(s.RootDir, oid))
+		assert.Equal(t, true, os.IsNotExist(err))
+		assert.Equal(t, filepath.Join(s.RootDir, oid[0:2], oid[2:4], oid), s.ObjectPath(oid))
+	}
+
+	assertObject(t, s, oid1, "legacy 1")
+	assertObject(t, s, oid2, "legacy 2")
+	// the valid legacy copy replaced the corrupt one
+	assertObject(t, s, oid3, "in both layouts")
+	assert.Equal(t, 3, len(s.AllObjects()))
+
+	// only runs once
+	writeLegacyObject(t, s, "legacy 4")
+	n, err = s.MigrateLegacyObjects()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, n)
+}
+
+func writeLegacyObject(t *testing.T, s *localstorage.LocalStorage, content string) string {
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+	if err := ioutil.WriteFile(filepath.Join(s.RootDir, oid), []byte(content), 0644); err != nil {
+		t.Fatalf("Unable to write legacy object: %s", err)
+	}
+	return oid
+}
+
+func assertObject(t *testing.T, s *localstorage.LocalStorage, oid, content string) {
+	by, err := ioutil.ReadFile(s.ObjectPath(oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, content, string(by))
+}
@@ -0,0 +1,118 @@
+package localstorage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SharedMode is how the permissions of files and dirs created in storage are
+// adjusted for core.sharedRepository, so that other users can write to a repo
+// shared with them as they can to git's own objects. The zero value leaves
+// permissions to the umask.
+type SharedMode struct {
+	perm  os.FileMode // bits added to files, or their exact permissions
+	exact bool        // whether perm replaces file permissions rather than adding to them
+}
+
+var sharedMode SharedMode
+
+// ParseSharedRepository parses a core.sharedRepository value in any of the
+// forms git accepts: a boolean, "umask", "group", "all" (or "world" or
+// "everybody"), or an octal file mode such as 0660.
+func ParseSharedRepository(value string) (SharedMode, error) {
+	switch strings.ToLower(value) {
+	case "", "umask", "false", "no", "off", "0":
+		return SharedMode{}, nil
+	case "group", "true", "yes", "on", "1":
+		return SharedMode{perm: 0660}, nil
+	case "all", "world", "everybody", "2":
+		return SharedMode{perm: 0664}, nil
+	}
+
+	perm, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || perm&^0777 != 0 {
+		return SharedMode{}, fmt.Errorf("Invalid core.sharedRepository %q", value)
+	}
+	if perm&0600 != 0600 {
+		return SharedMode{}, fmt.Errorf("core.sharedRepository %q leaves files unreadable or unwritable by their owner", value)
+	}
+	return SharedMode{perm: os.FileMode(perm), exact: true}, nil
+}
+
+// SetSharedMode sets how the permissions of files and dirs created from now on
+// are adjusted.
+func SetSharedMode(mode SharedMode) {
+	sharedMode = mode
+}
+
+// AdjustPerms gives the file or dir at path the permissions the shared mode
+// calls for, as git does for its own objects. Dirs also get execute permission
+// wherever they're readable, and the setgid bit so that new files in them
+// belong to the dir's group.
+func AdjustPerms(path string) error {
+	if sharedMode.perm == 0 {
+		return nil
+	}
+
+	info, err := os.Stat(LongPath(path))
+	if err != nil {
+		return err
+	}
+
+	mode := sharedMode.adjust(info.Mode())
+	if mode == info.Mode() {
+		return nil
+	}
+	return os.Chmod(LongPath(path), mode)
+}
+
+// adjust works out the new mode as git's calc_shared_perm does.
+func (m SharedMode) adjust(mode os.FileMode) os.FileMode {
+	perm := mode.Perm()
+	tweak := m.perm
+	if perm&0200 == 0 {
+		// read only files stay read only
+		tweak &^= 0222
+	}
+	if perm&0100 != 0 {
+		tweak |= (tweak & 0444) >> 2
+	}
+
+	if m.exact {
+		perm = tweak
+	} else {
+		perm |= tweak
+	}
+
+	if mode.IsDir() {
+		perm |= (perm & 0444) >> 2
+		return mode&^os.ModePerm | perm | os.ModeSetgid
+	}
+	return mode&^os.ModePerm | perm
+}
+
+// MkdirAll creates dir and any missing parents like os.MkdirAll, adjusting the
+// permissions of each dir it creates for the shared mode.
+func MkdirAll(dir string) error {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(LongPath(d)); err == nil || d == filepath.Dir(d) {
+			break
+		}
+		created = append(created, d)
+	}
+
+	if err := os.MkdirAll(LongPath(dir), dirPerms); err != nil {
+		return err
+	}
+
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := AdjustPerms(created[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package localstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestParseSharedRepository(t *testing.T) {
+	for value, expected := range map[string]SharedMode{
+		"":          SharedMode{},
+		"umask":     SharedMode{},
+		"false":     SharedMode{},
+		"group":     SharedMode{perm: 0660},
+		"true":      SharedMode{perm: 0660},
+		"1":         SharedMode{perm: 0660},
+		"all":       SharedMode{perm: 0664},
+		"Everybody": SharedMode{perm: 0664},
+		"2":         SharedMode{perm: 0664},
+		"0640":      SharedMode{perm: 0640, exact: true},
+		"0600":      SharedMode{perm: 0600, exact: true},
+	} {
+		mode, err := ParseSharedRepository(value)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, expected, mode)
+	}
+
+	for _, value := range []string{"sometimes", "0400", "01777", "0999"} {
+		_, err := ParseSharedRepository(value)
+		assert.NotEqual(t, nil, err)
+	}
+}
+
+func TestSharedModeAdjust(t *testing.T) {
+	group := SharedMode{perm: 0660}
+	all := SharedMode{perm: 0664}
+	exact := SharedMode{perm: 0640, exact: true}
+
+	assert.Equal(t, os.FileMode(0660), group.adjust(0600))
+	assert.Equal(t, os.FileMode(0664), all.adjust(0600))
+	assert.Equal(t, os.FileMode(0640), exact.adjust(0666))
+	assert.Equal(t, os.FileMode(0440), group.adjust(0400))
+	assert.Equal(t, os.FileMode(0770), group.adjust(0700))
+
+	assert.Equal(t, os.ModeDir|os.ModeSetgid|0775, group.adjust(os.ModeDir|0755))
+	assert.Equal(t, os.ModeDir|os.ModeSetgid|0775, all.adjust(os.ModeDir|0700))
+	assert.Equal(t, os.ModeDir|os.ModeSetgid|0750, exact.adjust(os.ModeDir|0755))
+}
+
+func TestSharedModeOnIngest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't use unix permissions")
+	}
+
+	dir, err := ioutil.TempDir("", "lfs-perms")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	SetSharedMode(SharedMode{perm: 0660})
+	defer SetSharedMode(SharedMode{})
+
+	s, err := New(filepath.Join(dir, "lfs", "objects"), filepath.Join(dir, "lfs", "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	content := []byte("shared with the group")
+	sum := sha256.Sum256(content)
+	oid := hex.EncodeToString(sum[:])
+
+	// temp files are only readable by their owner
+	f, err := ioutil.TempFile(s.TempDir, oid)
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	f.Write(content)
+	f.Close()
+	assert.Equal(t, nil, s.IngestObject(f.Name(), oid, int64(len(content)), false))
+
+	info, err := os.Stat(s.ObjectPath(oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, os.FileMode(0660), info.Mode().Perm()&0770)
+
+	for _, d := range []string{"lfs", "lfs/objects", "lfs/tmp", "lfs/objects/" + oid[0:2], "lfs/objects/" + oid[0:2] + "/" + oid[2:4]} {
+		info, err := os.Stat(filepath.Join(dir, d))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, os.FileMode(0770), info.Mode().Perm()&0770)
+		assert.Equal(t, os.ModeSetgid, info.Mode()&os.ModeSetgid)
+	}
+}
@@ -0,0 +1,100 @@
+package localstorage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
+)
+
+// rename can be replaced in tests to simulate renames across filesystems
+var rename = os.Rename
+
+// RenameFile moves oldpath to newpath. If they're on different filesystems,
+// which happens when lfs.storage or the temp dir are on another mount, the
+// file is copied to a temp file next to newpath and renamed into place from
+// there, so that newpath still appears atomically. Every move of an object
+// into, around or out of storage goes through here.
+func RenameFile(oldpath, newpath string) error {
+	err := rename(LongPath(oldpath), LongPath(newpath))
+	if err == nil || !isCrossDeviceError(err) {
+		return err
+	}
+
+	tracerx.Printf("Copying %s to %s across filesystems", oldpath, newpath)
+	if err := copyToTempAndRename(oldpath, newpath); err != nil {
+		return err
+	}
+
+	return os.Remove(LongPath(oldpath))
+}
+
+// BreakHardLink gives path a copy of its content of its own, so that it can be
+// changed without affecting any other names it's hard linked to. It's copied to
+// a temp file next to it which is then renamed over it.
+func BreakHardLink(path string) error {
+	return copyToTempAndRename(path, path)
+}
+
+func copyToTempAndRename(oldpath, newpath string) error {
+	src, err := os.Open(LongPath(oldpath))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(LongPath(filepath.Dir(newpath)), filepath.Base(newpath)+"-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	_, err = io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Chmod(info.Mode())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = rename(tmpName, LongPath(newpath))
+	}
+
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+// isCrossDeviceError returns whether err is a rename failing because the
+// source and destination are on different filesystems.
+func isCrossDeviceError(err error) bool {
+	if linkErr, ok := err.(*os.LinkError); ok {
+		err = linkErr.Err
+	}
+	return err == errCrossDevice
+}
+
+// isSharingViolation returns whether err is a rename failing because another
+// process has the destination open. That only happens on Windows.
+func isSharingViolation(err error) bool {
+	if linkErr, ok := err.(*os.LinkError); ok {
+		err = linkErr.Err
+	}
+	for _, e := range errSharingViolation {
+		if err == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,10 @@
+// +build !windows
+
+package localstorage
+
+import "syscall"
+
+var errCrossDevice error = syscall.EXDEV
+
+// renaming over an open file always succeeds
+var errSharingViolation []error
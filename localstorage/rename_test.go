@@ -0,0 +1,150 @@
+package localstorage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestRenameFileAcrossFilesystems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-rename")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	oid := "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+	tempPath := filepath.Join(s.TempDir, oid+"-1")
+	err = ioutil.WriteFile(tempPath, []byte("cross device"), 0640)
+	assert.Equal(t, nil, err)
+
+	// only the first rename, direct from the temp dir, crosses filesystems
+	calls := 0
+	rename = func(oldpath, newpath string) error {
+		calls++
+		if calls == 1 {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errCrossDevice}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	defer func() { rename = os.Rename }()
+
+	err = s.IngestObject(tempPath, oid, 12, true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, calls)
+
+	by, err := ioutil.ReadFile(s.ObjectPath(oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "cross device", string(by))
+
+	info, err := os.Stat(s.ObjectPath(oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	_, err = os.Stat(tempPath)
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	leftover, _ := ioutil.ReadDir(filepath.Dir(s.ObjectPath(oid)))
+	assert.Equal(t, 1, len(leftover))
+}
+
+func TestRenameFileOtherErrors(t *testing.T) {
+	rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EACCES}
+	}
+	defer func() { rename = os.Rename }()
+
+	err := RenameFile("a", "b")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, false, isCrossDeviceError(err))
+}
+
+func TestIngestObjectRetriesSharingViolations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-rename")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	oid := "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+	tempPath := filepath.Join(s.TempDir, oid+"-1")
+	err = ioutil.WriteFile(tempPath, []byte("in use"), 0640)
+	assert.Equal(t, nil, err)
+
+	// the object is open in another process for the first two attempts
+	oldSharingViolation := errSharingViolation
+	errSharingViolation = []error{syscall.EBUSY}
+	defer func() { errSharingViolation = oldSharingViolation }()
+	calls := 0
+	rename = func(oldpath, newpath string) error {
+		calls++
+		if calls <= 2 {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EBUSY}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	defer func() { rename = os.Rename }()
+
+	err = s.IngestObject(tempPath, oid, 6, true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, calls)
+
+	by, err := ioutil.ReadFile(s.ObjectPath(oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "in use", string(by))
+}
+
+func TestMigrateLegacyObjectsAcrossFilesystems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-rename")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	oid := "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+	err = ioutil.WriteFile(filepath.Join(s.RootDir, oid), []byte("legacy"), 0640)
+	assert.Equal(t, nil, err)
+
+	// the shard dir is on another filesystem
+	calls := 0
+	rename = func(oldpath, newpath string) error {
+		calls++
+		if calls == 1 {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errCrossDevice}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	defer func() { rename = os.Rename }()
+
+	n, err := s.MigrateLegacyObjects()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 2, calls)
+
+	assert.Equal(t, filepath.Join(s.RootDir, oid[0:2], oid[2:4], oid), s.ObjectPath(oid))
+	by, err := ioutil.ReadFile(s.ObjectPath(oid))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "legacy", string(by))
+
+	_, err = os.Stat(filepath.Join(s.RootDir, oid))
+	assert.Equal(t, true, os.IsNotExist(err))
+}
@@ -0,0 +1,12 @@
+// +build windows
+
+package localstorage
+
+import "syscall"
+
+// ERROR_NOT_SAME_DEVICE, returned by MoveFileEx without MOVEFILE_COPY_ALLOWED
+var errCrossDevice error = syscall.Errno(17)
+
+// ERROR_SHARING_VIOLATION, and ERROR_ACCESS_DENIED which MoveFileEx returns if
+// the file being replaced is open without FILE_SHARE_DELETE
+var errSharingViolation = []error{syscall.Errno(32), syscall.Errno(5)}
@@ -1,12 +1,23 @@
 package localstorage
 
 import (
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
 )
 
+const (
+	// Number of dir entries read at a time, so that huge dirs are streamed
+	// rather than read into memory all at once
+	readDirBatchSize = 1000
+)
+
+var shardRE = regexp.MustCompile(`\A[0-9a-f]{2}\z`)
+
 // AllObjects returns a slice of the the objects stored in this LocalStorage
 // object. This does not necessarily mean referenced by commits, just stored.
 // Note: reports final SHA only, extensions are ignored.
@@ -27,34 +38,87 @@ func (s *LocalStorage) ScanObjectsChan() <-chan Object {
 
 	go func() {
 		defer close(ch)
-		scanObjects(s.RootDir, ch)
+		s.WalkObjects(func(o Object) bool {
+			ch <- o
+			return true
+		})
 	}()
 
 	return ch
 }
 
-func scanObjects(dir string, ch chan<- Object) {
-	dirf, err := os.Open(dir)
+// WalkObjects calls fn for each object stored in this LocalStorage object,
+// streaming them from disk rather than listing them all first. The walk stops
+// early if fn returns false. Anything which isn't an object, such as the logs
+// dir, is skipped.
+func (s *LocalStorage) WalkObjects(fn func(Object) bool) error {
+	_, err := walkObjectDir(s.RootDir, "", fn)
+	return err
+}
+
+// DiskUsage returns the number of objects stored and their total size.
+func (s *LocalStorage) DiskUsage() (int, int64, error) {
+	var count int
+	var size int64
+	err := s.WalkObjects(func(o Object) bool {
+		count++
+		size += o.Size
+		return true
+	})
+	return count, size, err
+}
+
+// walkObjectDir walks dir in the sharded layout, ie
+// objects/<oid[0:2]>/<oid[2:4]>/<oid>, where prefix is the part of the oid
+// given by the dirs so far. Objects directly in objects/ are in the legacy
+// flat layout. Returns false if fn stopped the walk.
+func walkObjectDir(dir, prefix string, fn func(Object) bool) (bool, error) {
+	dirf, err := os.Open(LongPath(dir))
 	if err != nil {
-		return
+		if len(prefix) > 0 {
+			tracerx.Printf("Problem opening %q: %s", dir, err)
+			return true, nil
+		}
+		return false, err
 	}
 	defer dirf.Close()
 
-	direntries, err := dirf.Readdir(0)
-	if err != nil {
-		tracerx.Printf("Problem with Readdir in %q: %s", dir, err)
-		return
-	}
+	for {
+		entries, err := dirf.Readdir(readDirBatchSize)
+		for _, fi := range entries {
+			name := fi.Name()
+			path := filepath.Join(dir, name)
+
+			if fi.IsDir() {
+				if len(prefix) >= 4 || !shardRE.MatchString(name) {
+					tracerx.Printf("Skipping unexpected dir in local storage: %q", path)
+					continue
+				}
+				if cont, _ := walkObjectDir(path, prefix+name, fn); !cont {
+					return false, nil
+				}
+				continue
+			}
 
-	for _, dirfi := range direntries {
-		if dirfi.IsDir() {
-			subpath := filepath.Join(dir, dirfi.Name())
-			scanObjects(subpath, ch)
-		} else {
 			// Make sure it's really an object file & not .DS_Store etc
-			if oidRE.MatchString(dirfi.Name()) {
-				ch <- Object{dirfi.Name(), dirfi.Size()}
+			if len(prefix) == 2 || !isOid(name) || !strings.HasPrefix(name, prefix) {
+				if !strings.HasPrefix(name, ".") {
+					tracerx.Printf("Skipping unexpected file in local storage: %q", path)
+				}
+				continue
+			}
+
+			if !fn(Object{Oid: name, Size: fi.Size(), ModTime: fi.ModTime()}) {
+				return false, nil
 			}
 		}
+
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			tracerx.Printf("Problem with Readdir in %q: %s", dir, err)
+			return true, nil
+		}
 	}
 }
@@ -0,0 +1,105 @@
+package localstorage_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestWalkObjectsSkipsJunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-scan")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	var expected []string
+	var expectedSize int64
+	for i := 0; i < 50; i++ {
+		content := fmt.Sprintf("object content %d", i)
+		sum := sha256.Sum256([]byte(content))
+		oid := hex.EncodeToString(sum[:])
+		path, err := s.BuildObjectPath(oid)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, ioutil.WriteFile(path, []byte(content), 0644))
+		expected = append(expected, oid)
+		expectedSize += int64(len(content))
+	}
+	oid := expected[0]
+
+	junk := []string{
+		".DS_Store",
+		"logs/20160101T000000.123.log",
+		"logs/" + oid,
+		"tmp/" + oid + "-123",
+		oid[0:2] + "/.DS_Store",
+		oid[0:2] + "/" + oid,
+		oid[0:2] + "/" + oid[2:4] + "/notanoid",
+		oid[0:2] + "/" + oid[2:4] + "/nested/" + oid,
+		"zz/" + oid[2:4] + "/" + oid,
+		"ab/cd/" + oid,
+	}
+	for _, name := range junk {
+		path := filepath.Join(s.RootDir, name)
+		assert.Equal(t, nil, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Equal(t, nil, ioutil.WriteFile(path, []byte("junk"), 0644))
+	}
+
+	var actual []string
+	err = s.WalkObjects(func(o localstorage.Object) bool {
+		actual = append(actual, o.Oid)
+		assert.Equal(t, false, o.ModTime.IsZero())
+		return true
+	})
+	assert.Equal(t, nil, err)
+
+	sort.Strings(expected)
+	sort.Strings(actual)
+	assert.Equal(t, expected, actual)
+
+	count, size, err := s.DiskUsage()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 50, count)
+	assert.Equal(t, expectedSize, size)
+}
+
+func TestWalkObjectsStopsEarly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-scan")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%d", i)))
+		path, err := s.BuildObjectPath(hex.EncodeToString(sum[:]))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, ioutil.WriteFile(path, []byte("x"), 0644))
+	}
+
+	seen := 0
+	err = s.WalkObjects(func(o localstorage.Object) bool {
+		seen++
+		return seen < 5
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, seen)
+}
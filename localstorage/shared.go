@@ -0,0 +1,67 @@
+package localstorage
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const sharedReposFile = "repos"
+
+// RegisterSharedRepo records gitDir as a repository using the storage at
+// storageDir, so that prune can tell whether anything else might still need
+// the objects there. Each registration is a single appended line, which is
+// atomic for concurrent appenders on local filesystems.
+func RegisterSharedRepo(storageDir, gitDir string) error {
+	repos, err := SharedRepos(storageDir)
+	if err != nil {
+		return err
+	}
+
+	for _, repo := range repos {
+		if repo == gitDir {
+			return nil
+		}
+	}
+
+	path := filepath.Join(storageDir, sharedReposFile)
+	f, err := os.OpenFile(LongPath(path), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.WriteString(gitDir + "\n"); err != nil {
+		return err
+	}
+	return AdjustPerms(path)
+}
+
+// SharedRepos returns the git dirs of all repositories which have registered
+// as using the storage at storageDir, skipping any which no longer exist.
+func SharedRepos(storageDir string) ([]string, error) {
+	f, err := os.Open(LongPath(filepath.Join(storageDir, sharedReposFile)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var repos []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		repo := strings.TrimSpace(scanner.Text())
+		if len(repo) == 0 {
+			continue
+		}
+		if _, err := os.Stat(LongPath(repo)); err != nil {
+			continue
+		}
+		repos = append(repos, repo)
+	}
+
+	return repos, scanner.Err()
+}
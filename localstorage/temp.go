@@ -9,34 +9,64 @@ import (
 	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
 )
 
-func (s *LocalStorage) ClearTempObjects() error {
+// ClearTempObjects removes temp object files left behind by interrupted
+// transfers, which haven't been modified for maxAge. Files being written to by
+// a running transfer have their mtime updated constantly, so won't be removed.
+// Returns the paths removed.
+func (s *LocalStorage) ClearTempObjects(maxAge time.Duration) ([]string, error) {
 	if len(s.TempDir) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	d, err := os.Open(s.TempDir)
+	return clearTempDir(s.TempDir, func(path string, info os.FileInfo) bool {
+		return shouldDeleteTempObject(s, path, info, maxAge)
+	})
+}
+
+// ClearTempFiles removes any files directly in dir which haven't been modified
+// for maxAge, such as those left by an interrupted clean. Returns the paths
+// removed.
+func ClearTempFiles(dir string, maxAge time.Duration) ([]string, error) {
+	return clearTempDir(dir, func(path string, info os.FileInfo) bool {
+		if time.Since(info.ModTime()) > maxAge {
+			tracerx.Printf("Removing old tmp file: %s", path)
+			return true
+		}
+		return false
+	})
+}
+
+func clearTempDir(dir string, shouldDelete func(string, os.FileInfo) bool) ([]string, error) {
+	d, err := os.Open(LongPath(dir))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer d.Close()
 
+	var removed []string
 	filenames, _ := d.Readdirnames(-1)
 	for _, filename := range filenames {
-		path := filepath.Join(s.TempDir, filename)
-		if shouldDeleteTempObject(s, path) {
-			os.RemoveAll(path)
+		path := filepath.Join(dir, filename)
+		info, err := os.Lstat(LongPath(path))
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		if shouldDelete(path, info) {
+			if err := os.Remove(LongPath(path)); err == nil {
+				removed = append(removed, path)
+			}
 		}
 	}
 
-	return nil
+	return removed, nil
 }
 
-func shouldDeleteTempObject(s *LocalStorage, path string) bool {
-	info, err := os.Stat(path)
-	if err != nil {
-		return false
-	}
-
-	if info.IsDir() {
+// shouldDeleteTempObject only allows old temp files to be removed, whatever
+// their name, as a fresh one may still be in use by another process which will
+// ingest it, even if the object has been stored by someone else meanwhile.
+func shouldDeleteTempObject(s *LocalStorage, path string, info os.FileInfo, maxAge time.Duration) bool {
+	if time.Since(info.ModTime()) <= maxAge {
 		return false
 	}
 
@@ -48,16 +78,12 @@ func shouldDeleteTempObject(s *LocalStorage, path string) bool {
 		return true
 	}
 
-	fi, err := os.Stat(s.ObjectPath(oid))
+	fi, err := os.Stat(LongPath(s.ObjectPath(oid)))
 	if err == nil && !fi.IsDir() {
 		tracerx.Printf("Removing existing tmp object file: %s", path)
 		return true
 	}
 
-	if time.Since(info.ModTime()) > time.Hour {
-		tracerx.Printf("Removing old tmp object file: %s", path)
-		return true
-	}
-
-	return false
+	tracerx.Printf("Removing old tmp object file: %s", path)
+	return true
 }
@@ -0,0 +1,80 @@
+package localstorage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestClearTempObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-temp")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	oldFile := writeTempFile(t, s.TempDir, "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393-1", 2*time.Hour)
+	freshFile := writeTempFile(t, s.TempDir, "6f97bd6b83e3e6bd4e8a3e5e8c7cbb3ba8d7fbd1a4f3f72e7ac27e1bc2bbd3ff-1", time.Minute)
+
+	// may still be in use by another process, though the object is stored
+	storedOid := "5e2b1e5b0ab4ec3b3a0c4e3bb4f2c0e9f0e2c1b4d3f3e2a1c0b9a8f7e6d5c4b3"
+	path, err := s.BuildObjectPath(storedOid)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, ioutil.WriteFile(path, []byte("stored"), 0644))
+	freshStoredFile := writeTempFile(t, s.TempDir, storedOid+"-1", time.Minute)
+
+	removed, err := s.ClearTempObjects(time.Hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{oldFile}, removed)
+
+	_, err = os.Stat(oldFile)
+	assert.Equal(t, true, os.IsNotExist(err))
+	_, err = os.Stat(freshFile)
+	assert.Equal(t, nil, err)
+	_, err = os.Stat(freshStoredFile)
+	assert.Equal(t, nil, err)
+}
+
+func TestClearTempFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-temp")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldFile := writeTempFile(t, dir, "old", 3*time.Hour)
+	freshFile := writeTempFile(t, dir, "fresh", 90*time.Minute)
+	os.Mkdir(filepath.Join(dir, "objects"), 0755)
+
+	removed, err := localstorage.ClearTempFiles(dir, 2*time.Hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{oldFile}, removed)
+
+	_, err = os.Stat(freshFile)
+	assert.Equal(t, nil, err)
+	_, err = os.Stat(filepath.Join(dir, "objects"))
+	assert.Equal(t, nil, err)
+}
+
+func writeTempFile(t *testing.T, dir, name string, age time.Duration) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("temp"), 0644); err != nil {
+		t.Fatalf("Unable to write %s: %s", path, err)
+	}
+
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("Unable to set mtime on %s: %s", path, err)
+	}
+	return path
+}
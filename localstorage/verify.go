@@ -0,0 +1,55 @@
+package localstorage
+
+import "os"
+
+// A BadObject is a stored object whose content doesn't hash to its OID, or
+// which couldn't be read.
+type BadObject struct {
+	Oid  string
+	Path string
+	Size int64
+	// ActualOid is what the content hashes to, or "" if it couldn't be read.
+	ActualOid string
+	// Err is the error reading the object, if it couldn't be hashed. It may
+	// well be fine, so it shouldn't be removed.
+	Err error
+	// HardLinked is true if the object has other names, usually working tree
+	// files checked out with lfs.checkoutmode=hardlink, through which it may
+	// have been changed.
+	HardLinked bool
+}
+
+// Empty reports whether the object is an empty file, which is usually left
+// behind by an interrupted download or a full disk.
+func (o *BadObject) Empty() bool {
+	return o.Size == 0
+}
+
+// VerifyObjects re-hashes every stored object, returning how many were checked
+// and the ones whose content doesn't match their OID or can't be read. Empty
+// files are always bad, since Git LFS never stores empty objects. The store
+// isn't changed, so callers can deal with the bad objects afterwards.
+func (s *LocalStorage) VerifyObjects() (int, []*BadObject, error) {
+	var checked int
+	var bad []*BadObject
+	err := s.WalkObjects(func(o Object) bool {
+		checked++
+
+		path := s.ObjectPath(o.Oid)
+		actual, err := hashFile(path)
+		if o.Size == 0 {
+			bad = append(bad, &BadObject{Oid: o.Oid, Path: path, ActualOid: actual, Err: err})
+			return true
+		}
+
+		if err != nil || actual != o.Oid {
+			obj := &BadObject{Oid: o.Oid, Path: path, Size: o.Size, ActualOid: actual, Err: err}
+			if info, err := os.Stat(LongPath(path)); err == nil {
+				obj.HardLinked = linkCount(info) > 1
+			}
+			bad = append(bad, obj)
+		}
+		return true
+	})
+	return checked, bad, err
+}
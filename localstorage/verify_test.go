@@ -0,0 +1,117 @@
+package localstorage_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/github/git-lfs/localstorage"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestVerifyObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-verify")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	var oids []string
+	for i := 0; i < 4; i++ {
+		content := fmt.Sprintf("object content %d", i)
+		sum := sha256.Sum256([]byte(content))
+		oid := hex.EncodeToString(sum[:])
+		path, err := s.BuildObjectPath(oid)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, ioutil.WriteFile(path, []byte(content), 0644))
+		oids = append(oids, oid)
+	}
+
+	checked, bad, err := s.VerifyObjects()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, checked)
+	assert.Equal(t, 0, len(bad))
+
+	// one corrupt, one truncated to nothing
+	assert.Equal(t, nil, ioutil.WriteFile(s.ObjectPath(oids[1]), []byte("corrupt"), 0644))
+	assert.Equal(t, nil, os.Truncate(s.ObjectPath(oids[2]), 0))
+
+	sum := sha256.Sum256([]byte("corrupt"))
+	corruptOid := hex.EncodeToString(sum[:])
+
+	checked, bad, err = s.VerifyObjects()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, checked)
+	assert.Equal(t, 2, len(bad))
+
+	byOid := make(map[string]*localstorage.BadObject)
+	for _, o := range bad {
+		byOid[o.Oid] = o
+	}
+
+	corrupt := byOid[oids[1]]
+	assert.Equal(t, s.ObjectPath(oids[1]), corrupt.Path)
+	assert.Equal(t, int64(7), corrupt.Size)
+	assert.Equal(t, corruptOid, corrupt.ActualOid)
+	assert.Equal(t, false, corrupt.Empty())
+
+	empty := byOid[oids[2]]
+	assert.Equal(t, s.ObjectPath(oids[2]), empty.Path)
+	assert.Equal(t, true, empty.Empty())
+
+	assert.Equal(t, false, empty.HardLinked)
+
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, false, corrupt.HardLinked)
+
+		// a corrupt object with a working tree file linked to it
+		link := filepath.Join(dir, "linked.dat")
+		assert.Equal(t, nil, os.Link(s.ObjectPath(oids[1]), link))
+		_, bad, err = s.VerifyObjects()
+		assert.Equal(t, nil, err)
+		for _, o := range bad {
+			assert.Equal(t, o.Oid == oids[1], o.HardLinked)
+		}
+	}
+}
+
+func TestVerifyObjectsReportsUnreadableObjects(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("files can't be made unreadable")
+	}
+
+	dir, err := ioutil.TempDir("", "lfs-verify")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := localstorage.New(filepath.Join(dir, "objects"), filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	sum := sha256.Sum256([]byte("unreadable"))
+	oid := hex.EncodeToString(sum[:])
+	path, err := s.BuildObjectPath(oid)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, ioutil.WriteFile(path, []byte("unreadable"), 0000))
+
+	checked, bad, err := s.VerifyObjects()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, checked)
+	assert.Equal(t, 1, len(bad))
+	assert.Equal(t, oid, bad[0].Oid)
+	assert.Equal(t, "", bad[0].ActualOid)
+	assert.Equal(t, true, os.IsPermission(bad[0].Err))
+}
@@ -0,0 +1,80 @@
+package subprocess
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// SplitShellArgs splits a command line into its arguments the way a POSIX
+// shell would, for settings like GIT_SSH_COMMAND that git runs with sh.
+// Arguments are separated by unquoted whitespace. Single quotes keep
+// everything up to the next single quote, double quotes keep everything but a
+// backslash before $, `, " or \, and an unquoted backslash keeps the next
+// character. No variables, globs or ~ are expanded.
+func SplitShellArgs(s string) ([]string, error) {
+	var args []string
+	var arg bytes.Buffer
+	inArg := false // so "" is an empty argument rather than none
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+
+		case c == '\\':
+			inArg = true
+			if i+1 < len(s) {
+				i++
+				if s[i] != '\n' { // a line continuation
+					arg.WriteByte(s[i])
+				}
+			}
+
+		case c == '\'':
+			inArg = true
+			end := bytes.IndexByte([]byte(s[i+1:]), '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in %q", s)
+			}
+			arg.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+
+		case c == '"':
+			inArg = true
+			closed := false
+			for i++; i < len(s); i++ {
+				if s[i] == '"' {
+					closed = true
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					switch s[i+1] {
+					case '$', '`', '"', '\\':
+						i++
+					case '\n':
+						i++
+						continue
+					}
+				}
+				arg.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated double quote in %q", s)
+			}
+
+		default:
+			inArg = true
+			arg.WriteByte(c)
+		}
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
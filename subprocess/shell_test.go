@@ -0,0 +1,47 @@
+package subprocess
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitShellArgs(t *testing.T) {
+	tests := map[string][]string{
+		"":    nil,
+		"   ": nil,
+		"ssh": []string{"ssh"},
+		"ssh -i ~/.ssh/work_key -o ProxyJump=bastion": []string{"ssh", "-i", "~/.ssh/work_key", "-o", "ProxyJump=bastion"},
+		"  ssh\t-v\n": []string{"ssh", "-v"},
+		`ssh -o "ProxyCommand ssh -W %h:%p bastion"`: []string{"ssh", "-o", "ProxyCommand ssh -W %h:%p bastion"},
+		`ssh -o 'ProxyCommand ssh -W %h:%p bastion'`: []string{"ssh", "-o", "ProxyCommand ssh -W %h:%p bastion"},
+		`"C:\Program Files\PuTTY\plink.exe" -batch`:  []string{`C:\Program Files\PuTTY\plink.exe`, "-batch"},
+		`'C:\Program Files\PuTTY\plink.exe'`:         []string{`C:\Program Files\PuTTY\plink.exe`},
+		`/opt/my\ ssh/ssh -v`:                        []string{"/opt/my ssh/ssh", "-v"},
+		`ssh -i key\\name`:                           []string{"ssh", "-i", `key\name`},
+		`ssh "a \"quoted\" \$word"`:                  []string{"ssh", `a "quoted" $word`},
+		`ssh "keep \n backslash"`:                    []string{"ssh", `keep \n backslash`},
+		`ssh 'no \"escapes\"'`:                       []string{"ssh", `no \"escapes\"`},
+		`ssh -o ""`:                                  []string{"ssh", "-o", ""},
+		`ssh pre"mid"'end'`:                          []string{"ssh", "premidend"},
+		"ssh \\\n-v":                                 []string{"ssh", "-v"},
+	}
+
+	for cmd, expected := range tests {
+		args, err := SplitShellArgs(cmd)
+		if err != nil {
+			t.Errorf("%q: %s", cmd, err)
+			continue
+		}
+		if !reflect.DeepEqual(expected, args) {
+			t.Errorf("%q: expected %q, got %q", cmd, expected, args)
+		}
+	}
+}
+
+func TestSplitShellArgsUnterminatedQuotes(t *testing.T) {
+	for _, cmd := range []string{`ssh "-v`, `ssh '-v`, `ssh "-v\"`} {
+		if args, err := SplitShellArgs(cmd); err == nil {
+			t.Errorf("%q: expected an error, got %q", cmd, args)
+		}
+	}
+}
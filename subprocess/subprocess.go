@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 
 	"github.com/github/git-lfs/vendor/_nuts/github.com/rubyist/tracerx"
 )
@@ -25,6 +26,39 @@ func SimpleExec(name string, args ...string) (string, error) {
 	return strings.Trim(string(output), " \n"), nil
 }
 
+var (
+	startedMutex sync.Mutex
+	started      []*exec.Cmd
+)
+
+// Start starts cmd, remembering it so that KillAll can stop it if git-lfs is
+// interrupted. Use this for processes that can run for a while.
+func Start(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	startedMutex.Lock()
+	started = append(started, cmd)
+	startedMutex.Unlock()
+	return nil
+}
+
+// KillAll kills the processes started with Start that haven't yet exited.
+func KillAll() {
+	startedMutex.Lock()
+	defer startedMutex.Unlock()
+
+	for _, cmd := range started {
+		// os.Process knows once it has been waited on, and won't signal a
+		// reused pid.
+		if err := cmd.Process.Kill(); err == nil {
+			tracerx.Printf("Killed %s (pid %d)", strings.Join(cmd.Args, " "), cmd.Process.Pid)
+		}
+	}
+	started = nil
+}
+
 // An env for an exec.Command without GIT_TRACE
 var env []string
 var traceEnv = "GIT_TRACE="
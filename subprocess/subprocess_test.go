@@ -0,0 +1,43 @@
+package subprocess
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestKillAllReapsStartedProcesses(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sleep(1)")
+	}
+
+	finished := exec.Command("true")
+	if err := Start(finished); err != nil {
+		t.Fatal(err)
+	}
+	finished.Wait()
+
+	running := exec.Command("sleep", "60")
+	if err := Start(running); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- running.Wait() }()
+
+	KillAll()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected sleep to be killed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sleep wasn't killed")
+	}
+
+	if len(started) != 0 {
+		t.Errorf("expected started processes to be forgotten, got %d", len(started))
+	}
+}
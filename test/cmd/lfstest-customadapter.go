@@ -0,0 +1,169 @@
+package main
+
+// lfstest-customadapter is a custom transfer adapter for the integration
+// tests. It transfers objects over HTTP with the actions from the batch API,
+// the way the basic adapter does, but through the protocol described in the
+// transfer package.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type action struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+type request struct {
+	Event     string  `json:"event"`
+	Operation string  `json:"operation"`
+	Oid       string  `json:"oid"`
+	Size      int64   `json:"size"`
+	Path      string  `json:"path"`
+	Action    *action `json:"action"`
+}
+
+type transferError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type response struct {
+	Event          string         `json:"event,omitempty"`
+	Oid            string         `json:"oid,omitempty"`
+	Path           string         `json:"path,omitempty"`
+	BytesSoFar     int64          `json:"bytesSoFar,omitempty"`
+	BytesSinceLast int            `json:"bytesSinceLast,omitempty"`
+	Error          *transferError `json:"error,omitempty"`
+}
+
+var out = json.NewEncoder(os.Stdout)
+
+func main() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			fmt.Fprintf(os.Stderr, "lfstest-customadapter: invalid request %q: %s\n", scanner.Text(), err)
+			os.Exit(2)
+		}
+
+		switch req.Event {
+		case "init":
+			fmt.Fprintf(os.Stderr, "lfstest-customadapter: init %s\n", req.Operation)
+			out.Encode(&response{})
+		case "upload":
+			complete(&req, "", upload(&req))
+		case "download":
+			path, err := download(&req)
+			complete(&req, path, err)
+		case "terminate":
+			os.Exit(0)
+		default:
+			fmt.Fprintf(os.Stderr, "lfstest-customadapter: unknown event %q\n", req.Event)
+			os.Exit(2)
+		}
+	}
+}
+
+func complete(req *request, path string, err error) {
+	res := &response{Event: "complete", Oid: req.Oid, Path: path}
+	if err != nil {
+		res.Error = &transferError{Code: 1, Message: err.Error()}
+	}
+	out.Encode(res)
+}
+
+func upload(req *request) error {
+	file, err := os.Open(req.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	httpReq, err := newRequest("PUT", req.Action, &progressReader{oid: req.Oid, r: file})
+	if err != nil {
+		return err
+	}
+	httpReq.ContentLength = req.Size
+	httpReq.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	if res.StatusCode > 299 {
+		return fmt.Errorf("upload of %s failed with status %d", req.Oid, res.StatusCode)
+	}
+	return nil
+}
+
+func download(req *request) (string, error) {
+	httpReq, err := newRequest("GET", req.Action, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode > 299 {
+		return "", fmt.Errorf("download of %s failed with status %d", req.Oid, res.StatusCode)
+	}
+
+	file, err := ioutil.TempFile("", "lfstest-customadapter")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, &progressReader{oid: req.Oid, r: res.Body}); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func newRequest(method string, a *action, body io.Reader) (*http.Request, error) {
+	if a == nil || !strings.HasPrefix(a.Href, "http") {
+		return nil, fmt.Errorf("no http action to %s", strings.ToLower(method))
+	}
+
+	req, err := http.NewRequest(method, a.Href, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range a.Header {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+// progressReader sends a progress message for each read.
+type progressReader struct {
+	oid  string
+	r    io.Reader
+	read int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		out.Encode(&response{Event: "progress", Oid: r.oid, BytesSoFar: r.read, BytesSinceLast: n})
+	}
+	return n, err
+}
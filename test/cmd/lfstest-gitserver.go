@@ -18,16 +18,37 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
 	repoDir      string
 	largeObjects = newLfsStorage()
+	repoLocks    = make(map[string][]lfsLock)
+	locksMutex   sync.Mutex
+	lastLockId   int
 	server       *httptest.Server
 	serverTLS    *httptest.Server
 
+	// counts batch requests to "flakybatch*" repos, every other one of which
+	// fails with a 502.
+	flakyBatchCalls = make(map[string]int)
+	flakyBatchMutex sync.Mutex
+
+	// multipart uploads to "multipart*" repos in progress, by repo and oid
+	multipartUploads = make(map[string]*multipartState)
+	multipartMutex   sync.Mutex
+
+	// objects uploaded to "verify*" repos, kept here until they're verified.
+	// The first verify of each object in "verify-flaky*" repos fails with a
+	// 500.
+	unverifiedObjects = newLfsStorage()
+	verifyCalls       = make(map[string]int)
+	verifyMutex       sync.Mutex
+
 	// maps OIDs to content strings. Both the LFS and Storage test servers below
 	// see OIDs.
 	oidHandlers map[string]string
@@ -59,6 +80,8 @@ func main() {
 	})
 
 	mux.HandleFunc("/storage/", storageHandler)
+	mux.HandleFunc("/multipart/", multipartHandler)
+	mux.HandleFunc("/verify", verifyHandler)
 	mux.HandleFunc("/redirect307/", redirect307Handler)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(r.URL.Path, "/info/lfs") {
@@ -140,13 +163,23 @@ func lfsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
 	switch r.Method {
 	case "POST":
-		if strings.HasSuffix(r.URL.String(), "batch") {
+		if strings.HasSuffix(r.URL.Path, "/locks/verify") {
+			locksVerifyHandler(w, r, repo)
+		} else if strings.HasSuffix(r.URL.Path, "/unlock") {
+			unlockHandler(w, r, repo)
+		} else if strings.HasSuffix(r.URL.Path, "/locks") {
+			createLockHandler(w, r, repo)
+		} else if strings.HasSuffix(r.URL.String(), "batch") {
 			lfsBatchHandler(w, r, repo)
 		} else {
 			lfsPostHandler(w, r, repo)
 		}
 	case "GET":
-		lfsGetHandler(w, r, repo)
+		if strings.HasSuffix(r.URL.Path, "/locks") {
+			searchLocksHandler(w, r, repo)
+		} else {
+			lfsGetHandler(w, r, repo)
+		}
 	default:
 		w.WriteHeader(405)
 	}
@@ -264,11 +297,28 @@ func lfsBatchHandler(w http.ResponseWriter, r *http.Request, repo string) {
 		return
 	}
 
+	if repo == "batchnotimplemented" {
+		w.WriteHeader(501)
+		return
+	}
+
 	if repo == "badbatch" {
 		w.WriteHeader(203)
 		return
 	}
 
+	if strings.HasPrefix(repo, "flakybatch") {
+		flakyBatchMutex.Lock()
+		flakyBatchCalls[repo]++
+		calls := flakyBatchCalls[repo]
+		flakyBatchMutex.Unlock()
+
+		if calls%2 == 1 {
+			w.WriteHeader(502)
+			return
+		}
+	}
+
 	if repo == "netrctest" {
 		user, pass, err := extractAuth(r.Header.Get("Authorization"))
 		if err != nil || (user != "netrcuser" || pass != "netrcpass") {
@@ -279,6 +329,7 @@ func lfsBatchHandler(w http.ResponseWriter, r *http.Request, repo string) {
 
 	type batchReq struct {
 		Operation string      `json:"operation"`
+		Transfers []string    `json:"transfers"`
 		Objects   []lfsObject `json:"objects"`
 	}
 
@@ -339,6 +390,15 @@ func lfsBatchHandler(w http.ResponseWriter, r *http.Request, repo string) {
 						Header: map[string]string{},
 					},
 				}
+				if action == "upload" && strings.HasPrefix(repo, "multipart") {
+					o.Actions["multipart"] = lfsLink{Href: multipartUrl(repo, obj.Oid, "")}
+				}
+				if action == "upload" && strings.HasPrefix(repo, "verify") {
+					o.Actions["verify"] = lfsLink{
+						Href:   server.URL + "/verify?r=" + repo,
+						Header: map[string]string{"Lfs-Verify-Token": obj.Oid},
+					}
+				}
 			}
 		}
 
@@ -349,7 +409,17 @@ func lfsBatchHandler(w http.ResponseWriter, r *http.Request, repo string) {
 		res = append(res, o)
 	}
 
-	ores := map[string][]lfsObject{"objects": res}
+	ores := map[string]interface{}{"objects": res}
+
+	// "customadapter*" repos pick the lfstest-customadapter transfer adapter
+	// when the client offers it
+	if strings.HasPrefix(repo, "customadapter") {
+		for _, name := range objs.Transfers {
+			if name == "lfstest-customadapter" {
+				ores["transfer"] = name
+			}
+		}
+	}
 
 	by, err := json.Marshal(ores)
 	if err != nil {
@@ -363,6 +433,136 @@ func lfsBatchHandler(w http.ResponseWriter, r *http.Request, repo string) {
 	w.Write(by)
 }
 
+type lfsLock struct {
+	Id       string       `json:"id"`
+	Path     string       `json:"path"`
+	Owner    lfsLockOwner `json:"owner"`
+	LockedAt time.Time    `json:"locked_at"`
+}
+
+type lfsLockOwner struct {
+	Name string `json:"name"`
+}
+
+// createLockHandler locks a path for the user named in the request, or the
+// authenticated user, so tests can set up locks held by others.
+func createLockHandler(w http.ResponseWriter, r *http.Request, repo string) {
+	var lock lfsLock
+	if err := json.NewDecoder(r.Body).Decode(&lock); err != nil {
+		w.WriteHeader(422)
+		return
+	}
+	if lock.Owner.Name == "" {
+		lock.Owner.Name, _, _ = extractAuth(r.Header.Get("Authorization"))
+	}
+	lock.LockedAt = time.Now()
+
+	locksMutex.Lock()
+	for _, existing := range repoLocks[repo] {
+		if existing.Path == lock.Path {
+			locksMutex.Unlock()
+			by, _ := json.Marshal(map[string]interface{}{"lock": existing, "message": "already locked"})
+			w.WriteHeader(409)
+			w.Write(by)
+			return
+		}
+	}
+	lastLockId++
+	lock.Id = fmt.Sprintf("%d", lastLockId)
+	repoLocks[repo] = append(repoLocks[repo], lock)
+	locksMutex.Unlock()
+
+	by, _ := json.Marshal(map[string]interface{}{"lock": lock})
+	w.WriteHeader(201)
+	w.Write(by)
+}
+
+// unlockHandler releases the lock with the id in the path, if it's held by the
+// authenticated user or the request forces it.
+func unlockHandler(w http.ResponseWriter, r *http.Request, repo string) {
+	parts := strings.Split(r.URL.Path, "/")
+	id := parts[len(parts)-2]
+
+	var body struct {
+		Force bool `json:"force"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	user, _, _ := extractAuth(r.Header.Get("Authorization"))
+
+	locksMutex.Lock()
+	defer locksMutex.Unlock()
+
+	locks := repoLocks[repo]
+	for i, lock := range locks {
+		if lock.Id != id {
+			continue
+		}
+
+		if lock.Owner.Name != user && !body.Force {
+			w.WriteHeader(403)
+			return
+		}
+
+		repoLocks[repo] = append(locks[0:i:i], locks[i+1:]...)
+		by, _ := json.Marshal(map[string]interface{}{"lock": lock})
+		w.WriteHeader(200)
+		w.Write(by)
+		return
+	}
+	w.WriteHeader(404)
+}
+
+// searchLocksHandler lists the locks on repo matching the "path" and "id"
+// query params, if given.
+func searchLocksHandler(w http.ResponseWriter, r *http.Request, repo string) {
+	query := r.URL.Query()
+	path, id := query.Get("path"), query.Get("id")
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	locks := []lfsLock{}
+	locksMutex.Lock()
+	for _, lock := range repoLocks[repo] {
+		if limit > 0 && len(locks) >= limit {
+			break
+		}
+		if (path == "" || lock.Path == path) && (id == "" || lock.Id == id) {
+			locks = append(locks, lock)
+		}
+	}
+	locksMutex.Unlock()
+
+	by, _ := json.Marshal(map[string]interface{}{"locks": locks})
+	w.WriteHeader(200)
+	w.Write(by)
+}
+
+// locksVerifyHandler lists the locks on repo, split into those held by the
+// authenticated user and by others. Repos with names starting
+// "locksunsupported" don't support locking.
+func locksVerifyHandler(w http.ResponseWriter, r *http.Request, repo string) {
+	if strings.HasPrefix(repo, "locksunsupported") {
+		w.WriteHeader(501)
+		return
+	}
+
+	user, _, _ := extractAuth(r.Header.Get("Authorization"))
+	ours, theirs := []lfsLock{}, []lfsLock{}
+
+	locksMutex.Lock()
+	for _, lock := range repoLocks[repo] {
+		if lock.Owner.Name == user {
+			ours = append(ours, lock)
+		} else {
+			theirs = append(theirs, lock)
+		}
+	}
+	locksMutex.Unlock()
+
+	by, _ := json.Marshal(map[string]interface{}{"ours": ours, "theirs": theirs})
+	w.WriteHeader(200)
+	w.Write(by)
+}
+
 // handles any /storage/{oid} requests
 func storageHandler(w http.ResponseWriter, r *http.Request) {
 	repo := r.URL.Query().Get("r")
@@ -412,7 +612,11 @@ func storageHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		largeObjects.Set(repo, oid, buf.Bytes())
+		if strings.HasPrefix(repo, "verify") {
+			unverifiedObjects.Set(repo, oid, buf.Bytes())
+		} else {
+			largeObjects.Set(repo, oid, buf.Bytes())
+		}
 
 	case "GET":
 		parts := strings.Split(r.URL.Path, "/")
@@ -429,6 +633,152 @@ func storageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handles POST /verify requests for "verify*" repos, which only keep uploaded
+// objects once the client has sent back just their oid and size.
+func verifyHandler(w http.ResponseWriter, r *http.Request) {
+	repo := r.URL.Query().Get("r")
+	if r.Method != "POST" {
+		w.WriteHeader(405)
+		return
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(422)
+		return
+	}
+
+	oid, _ := body["oid"].(string)
+	size, _ := body["size"].(float64)
+	log.Printf("verify %s repo: %s\n", oid, repo)
+
+	if len(body) != 2 || r.Header.Get("Lfs-Verify-Token") != oid {
+		w.WriteHeader(422)
+		return
+	}
+
+	if strings.HasPrefix(repo, "verify-flaky") {
+		verifyMutex.Lock()
+		verifyCalls[repo+":"+oid]++
+		calls := verifyCalls[repo+":"+oid]
+		verifyMutex.Unlock()
+
+		if calls == 1 {
+			w.WriteHeader(500)
+			return
+		}
+	}
+
+	by, ok := unverifiedObjects.Get(repo, oid)
+	if !ok || int64(len(by)) != int64(size) {
+		w.WriteHeader(404)
+		return
+	}
+
+	largeObjects.Set(repo, oid, by)
+	unverifiedObjects.Delete(repo, oid)
+	w.WriteHeader(200)
+}
+
+const multipartPartSize = 1024
+
+type multipartState struct {
+	parts  [][]byte
+	failed bool
+}
+
+func multipartUrl(repo, oid, part string) string {
+	u := server.URL + "/multipart/" + oid
+	if len(part) > 0 {
+		u += "/" + part
+	}
+	return u + "?r=" + repo
+}
+
+// handles multipart uploads to "multipart*" repos. POST /multipart/{oid} starts
+// one, PUT /multipart/{oid}/{part} uploads a part and POST
+// /multipart/{oid}/complete finishes it. Parts are 1KB, and the first upload of
+// the second part of each object fails.
+func multipartHandler(w http.ResponseWriter, r *http.Request) {
+	repo := r.URL.Query().Get("r")
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/multipart/"), "/")
+	oid := parts[0]
+	key := repo + ":" + oid
+
+	multipartMutex.Lock()
+	defer multipartMutex.Unlock()
+
+	switch {
+	case r.Method == "POST" && len(parts) == 1:
+		var start struct {
+			Size int64 `json:"size"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&start); err != nil {
+			log.Fatal(err)
+		}
+
+		n := int((start.Size + multipartPartSize - 1) / multipartPartSize)
+		multipartUploads[key] = &multipartState{parts: make([][]byte, n)}
+
+		links := make([]lfsLink, n)
+		for i := range links {
+			links[i] = lfsLink{Href: multipartUrl(repo, oid, strconv.Itoa(i+1))}
+		}
+		by, _ := json.Marshal(map[string]interface{}{
+			"part_size": multipartPartSize,
+			"parts":     links,
+			"complete":  lfsLink{Href: multipartUrl(repo, oid, "complete")},
+		})
+
+		log.Printf("multipart %s: %d parts\n", oid, n)
+		w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+		w.WriteHeader(200)
+		w.Write(by)
+
+	case r.Method == "PUT" && len(parts) == 2:
+		upload := multipartUploads[key]
+		i, err := strconv.Atoi(parts[1])
+		if upload == nil || err != nil || i < 1 || i > len(upload.parts) {
+			w.WriteHeader(404)
+			return
+		}
+
+		by, _ := ioutil.ReadAll(r.Body)
+		if i == 2 && !upload.failed {
+			upload.failed = true
+			log.Printf("multipart %s: failing part %d\n", oid, i)
+			w.WriteHeader(500)
+			return
+		}
+
+		upload.parts[i-1] = by
+		w.Header().Set("ETag", fmt.Sprintf("%s-%d", oid[0:7], i))
+		w.WriteHeader(200)
+
+	case r.Method == "POST" && len(parts) == 2 && parts[1] == "complete":
+		upload := multipartUploads[key]
+		if upload == nil {
+			w.WriteHeader(404)
+			return
+		}
+
+		by := bytes.Join(upload.parts, nil)
+		hash := sha256.Sum256(by)
+		if hex.EncodeToString(hash[:]) != oid {
+			log.Printf("multipart %s: content doesn't match\n", oid)
+			w.WriteHeader(422)
+			return
+		}
+
+		delete(multipartUploads, key)
+		largeObjects.Set(repo, oid, by)
+		w.WriteHeader(200)
+
+	default:
+		w.WriteHeader(405)
+	}
+}
+
 func gitHandler(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		io.Copy(ioutil.Discard, r.Body)
@@ -614,7 +964,13 @@ func skipIfBadAuth(w http.ResponseWriter, r *http.Request) bool {
 		log.Printf("auth attempt against: %q", r.URL.Path)
 	}
 
-	w.WriteHeader(403)
+	// "rejectwith401*" repos reject bad credentials like GitHub does, asking
+	// for them again
+	if strings.Contains(r.URL.Path, "/rejectwith401") {
+		w.WriteHeader(401)
+	} else {
+		w.WriteHeader(403)
+	}
 	log.Printf("Bad auth: %q\n", auth)
 	return true
 }
@@ -248,7 +248,8 @@ func callBatchApi(op string, objs []TestObject) ([]*lfs.ObjectResource, error) {
 	for _, o := range objs {
 		apiobjs = append(apiobjs, &lfs.ObjectResource{Oid: o.Oid, Size: o.Size})
 	}
-	return lfs.Batch(apiobjs, op)
+	objects, _, err := lfs.Batch(apiobjs, op, nil)
+	return objects, err
 }
 
 // Combine 2 slices into one by "randomly" interleaving
@@ -0,0 +1,411 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LfsServer is an in-memory LFS API server for tests, serving the batch API at
+// its URL, object storage under /storage/ & verification at /verify. Object
+// responses, authentication & faults can be scripted, and every request is
+// recorded. Use NewLfsServer to create one & Close to stop it.
+type LfsServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	objects  map[string][]byte
+	scripts  map[string]*ObjectScript
+	auth     LfsServerAuth
+	faults   []*LfsServerFault
+	requests []*RecordedRequest
+	closing  chan struct{}
+}
+
+// ObjectScript overrides how the batch endpoint responds for one object. The
+// zero value behaves like a real server: downloads get a download action if
+// the object is stored & a 404 error otherwise, and uploads get upload &
+// verify actions unless it's already stored.
+type ObjectScript struct {
+	// Error code & message to return for the object instead of actions
+	ErrorCode    int
+	ErrorMessage string
+	// Missing makes the object look absent from storage to the batch endpoint
+	Missing bool
+	// Actions, if set, replaces the actions returned, eg []string{"upload"}
+	// for an upload without verification or an empty slice for none
+	Actions []string
+	// Header is added to the headers of every action returned
+	Header map[string]string
+}
+
+// LfsServerAuthMode is how an LfsServer authenticates requests.
+type LfsServerAuthMode int
+
+const (
+	// Accept every request
+	AuthNone = LfsServerAuthMode(iota)
+	// Require Basic auth with Username & Password on API requests
+	AuthBasic = LfsServerAuthMode(iota)
+	// Require "Authorization: Bearer <Token>" on API requests, which actions
+	// pass on to storage requests
+	AuthToken = LfsServerAuthMode(iota)
+)
+
+// LfsServerAuth sets up authentication of API (batch & verify) requests.
+// Storage requests are only authenticated in AuthToken mode, with the header
+// the actions carry.
+type LfsServerAuth struct {
+	Mode     LfsServerAuthMode
+	Username string
+	Password string
+	Token    string
+	// Challenges is how many API requests get a 401 with a Basic challenge
+	// whatever their credentials, before they're checked
+	Challenges int
+}
+
+// LfsServerFault makes the next Count requests whose path starts with Path (or
+// any request if it's empty) stall or fail. Faults are matched in the order
+// they were added.
+type LfsServerFault struct {
+	Path  string
+	Count int
+	// Stall delays the response, until the server is closed at the latest
+	Stall time.Duration
+	// Status fails the request with this status code, after any stall
+	Status int
+	// RetryAfter is sent in a Retry-After header, in whole seconds
+	RetryAfter time.Duration
+}
+
+// RecordedRequest is a request received by an LfsServer.
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+type lfsServerObject struct {
+	Oid     string                    `json:"oid"`
+	Size    int64                     `json:"size"`
+	Actions map[string]*lfsServerLink `json:"actions,omitempty"`
+	Error   *lfsServerObjectError     `json:"error,omitempty"`
+}
+
+type lfsServerLink struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type lfsServerObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewLfsServer starts a new LfsServer with no objects.
+func NewLfsServer() *LfsServer {
+	s := &LfsServer{
+		objects: make(map[string][]byte),
+		scripts: make(map[string]*ObjectScript),
+		closing: make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Close stops the server, ending any stalled requests.
+func (s *LfsServer) Close() {
+	close(s.closing)
+	s.Server.Close()
+}
+
+// AddObject stores content, returning its oid.
+func (s *LfsServer) AddObject(content string) string {
+	oid := lfsServerOid([]byte(content))
+	s.SetObject(oid, content)
+	return oid
+}
+
+// SetObject stores content as the object oid, whether or not oid is its real
+// SHA-256.
+func (s *LfsServer) SetObject(oid, content string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.objects[oid] = []byte(content)
+}
+
+// Object returns the stored content of the object oid, if any.
+func (s *LfsServer) Object(oid string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	content, ok := s.objects[oid]
+	return string(content), ok
+}
+
+// Script sets how the batch endpoint responds for the object oid.
+func (s *LfsServer) Script(oid string, script ObjectScript) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scripts[oid] = &script
+}
+
+// SetAuth sets how requests are authenticated.
+func (s *LfsServer) SetAuth(auth LfsServerAuth) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.auth = auth
+}
+
+// AddFault adds a fault to inject into matching requests.
+func (s *LfsServer) AddFault(fault LfsServerFault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// Requests returns the requests received so far, in order.
+func (s *LfsServer) Requests() []*RecordedRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*RecordedRequest(nil), s.requests...)
+}
+
+func (s *LfsServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+
+	s.mutex.Lock()
+	s.requests = append(s.requests, &RecordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header,
+		Body:   body,
+	})
+	fault := s.takeFault(r.URL.Path)
+	s.mutex.Unlock()
+
+	if fault != nil {
+		if fault.Stall > 0 {
+			select {
+			case <-time.After(fault.Stall):
+			case <-s.closing:
+				return
+			}
+		}
+		if fault.Status != 0 {
+			if fault.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(fault.RetryAfter/time.Second)))
+			}
+			w.WriteHeader(fault.Status)
+			return
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/storage/"):
+		s.handleStorage(w, r, strings.TrimPrefix(r.URL.Path, "/storage/"), body)
+	case r.URL.Path == "/objects/batch" && r.Method == "POST":
+		if s.authenticated(w, r) {
+			s.handleBatch(w, r, body)
+		}
+	case r.URL.Path == "/verify" && r.Method == "POST":
+		if s.authenticated(w, r) {
+			s.handleVerify(w, body)
+		}
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+// takeFault returns the first fault for path, using up one of its requests.
+// The caller must hold the mutex.
+func (s *LfsServer) takeFault(path string) *LfsServerFault {
+	for i, fault := range s.faults {
+		if !strings.HasPrefix(path, fault.Path) {
+			continue
+		}
+		fault.Count--
+		if fault.Count <= 0 {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		}
+		return fault
+	}
+	return nil
+}
+
+// authenticated checks the credentials of an API request, responding with a
+// 401 or 403 & returning false if they're missing or wrong.
+func (s *LfsServer) authenticated(w http.ResponseWriter, r *http.Request) bool {
+	s.mutex.Lock()
+	auth := s.auth
+	if s.auth.Challenges > 0 {
+		s.auth.Challenges--
+	}
+	s.mutex.Unlock()
+
+	header := r.Header.Get("Authorization")
+	ok := true
+	switch auth.Mode {
+	case AuthBasic:
+		expected := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		ok = header == "Basic "+expected
+	case AuthToken:
+		ok = header == "Bearer "+auth.Token
+	}
+
+	switch {
+	case auth.Challenges > 0 || (!ok && header == ""):
+		w.Header().Set("Lfs-Authenticate", `Basic realm="LfsServer"`)
+		w.Header().Set("Www-Authenticate", `Basic realm="LfsServer"`)
+		w.WriteHeader(401)
+		return false
+	case !ok:
+		w.WriteHeader(403)
+		return false
+	}
+	return true
+}
+
+func (s *LfsServer) handleBatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req struct {
+		Operation string             `json:"operation"`
+		Objects   []*lfsServerObject `json:"objects"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.writeJSON(w, 422, map[string]string{"message": err.Error()})
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	objects := make([]*lfsServerObject, 0, len(req.Objects))
+	for _, obj := range req.Objects {
+		script := s.scripts[obj.Oid]
+		if script == nil {
+			script = &ObjectScript{}
+		}
+		_, stored := s.objects[obj.Oid]
+		stored = stored && !script.Missing
+
+		res := &lfsServerObject{Oid: obj.Oid, Size: obj.Size}
+		objects = append(objects, res)
+		if script.ErrorCode != 0 {
+			res.Error = &lfsServerObjectError{Code: script.ErrorCode, Message: script.ErrorMessage}
+			continue
+		}
+
+		actions := script.Actions
+		if actions == nil {
+			switch {
+			case req.Operation == "download" && stored:
+				actions = []string{"download"}
+			case req.Operation == "download":
+				res.Error = &lfsServerObjectError{Code: 404, Message: fmt.Sprintf("Object %v does not exist", obj.Oid)}
+			case !stored:
+				actions = []string{"upload", "verify"}
+			}
+		}
+
+		for _, action := range actions {
+			if res.Actions == nil {
+				res.Actions = make(map[string]*lfsServerLink)
+			}
+			res.Actions[action] = s.link(action, obj.Oid, script.Header)
+		}
+	}
+
+	s.writeJSON(w, 200, map[string]interface{}{"objects": objects})
+}
+
+// link returns an action for the object oid. The caller must hold the mutex.
+func (s *LfsServer) link(action, oid string, header map[string]string) *lfsServerLink {
+	link := &lfsServerLink{Href: s.URL + "/storage/" + oid, Header: make(map[string]string)}
+	if action == "verify" {
+		link.Href = s.URL + "/verify"
+	}
+	if s.auth.Mode == AuthToken {
+		link.Header["Authorization"] = "Bearer " + s.auth.Token
+	}
+	for key, value := range header {
+		link.Header[key] = value
+	}
+	return link
+}
+
+func (s *LfsServer) handleStorage(w http.ResponseWriter, r *http.Request, oid string, body []byte) {
+	s.mutex.Lock()
+	auth := s.auth
+	s.mutex.Unlock()
+	if auth.Mode == AuthToken && r.Header.Get("Authorization") != "Bearer "+auth.Token {
+		w.WriteHeader(403)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		content, ok := s.Object(oid)
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		// handles Range requests
+		http.ServeContent(w, r, oid, time.Time{}, strings.NewReader(content))
+	case "PUT":
+		if lfsServerOid(body) != oid {
+			w.WriteHeader(422)
+			return
+		}
+		s.SetObject(oid, string(body))
+		w.WriteHeader(200)
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+func (s *LfsServer) handleVerify(w http.ResponseWriter, body []byte) {
+	var obj lfsServerObject
+	if err := json.Unmarshal(body, &obj); err != nil {
+		s.writeJSON(w, 422, map[string]string{"message": err.Error()})
+		return
+	}
+
+	content, ok := s.Object(obj.Oid)
+	switch {
+	case !ok:
+		s.writeJSON(w, 404, map[string]string{"message": "Object " + obj.Oid + " does not exist"})
+	case int64(len(content)) != obj.Size:
+		s.writeJSON(w, 422, map[string]string{"message": fmt.Sprintf("Object %v is %d bytes, not %d", obj.Oid, len(content), obj.Size)})
+	default:
+		w.WriteHeader(200)
+	}
+}
+
+func (s *LfsServer) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	by, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+	w.WriteHeader(status)
+	w.Write(by)
+}
+
+func lfsServerOid(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
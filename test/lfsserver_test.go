@@ -0,0 +1,263 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type batchTestObject struct {
+	Oid     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions map[string]struct {
+		Href   string            `json:"href"`
+		Header map[string]string `json:"header"`
+	} `json:"actions"`
+	Error *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+func postBatch(t *testing.T, server *LfsServer, operation string, header map[string]string, oids ...string) (*http.Response, map[string]*batchTestObject) {
+	objects := make([]map[string]interface{}, 0, len(oids))
+	for _, oid := range oids {
+		objects = append(objects, map[string]interface{}{"oid": oid, "size": 3})
+	}
+	body, _ := json.Marshal(map[string]interface{}{"operation": operation, "objects": objects})
+
+	req, _ := http.NewRequest("POST", server.URL+"/objects/batch", strings.NewReader(string(body)))
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("batch request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	var batch struct {
+		Objects []*batchTestObject `json:"objects"`
+	}
+	json.NewDecoder(res.Body).Decode(&batch)
+	byOid := make(map[string]*batchTestObject)
+	for _, obj := range batch.Objects {
+		byOid[obj.Oid] = obj
+	}
+	return res, byOid
+}
+
+func actionNames(obj *batchTestObject) string {
+	names := make([]string, 0, len(obj.Actions))
+	for _, name := range []string{"download", "upload", "verify"} {
+		if _, ok := obj.Actions[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func TestLfsServerBatch(t *testing.T) {
+	server := NewLfsServer()
+	defer server.Close()
+
+	stored := server.AddObject("foo")
+	hidden := server.AddObject("bar")
+	failing := server.AddObject("baz")
+	missing := strings.Repeat("0", 64)
+	server.Script(hidden, ObjectScript{Missing: true})
+	server.Script(failing, ObjectScript{ErrorCode: 410, ErrorMessage: "gone"})
+
+	_, objs := postBatch(t, server, "download", nil, stored, hidden, failing, missing)
+	if actions := actionNames(objs[stored]); actions != "download" || objs[stored].Error != nil {
+		t.Errorf("stored: expected a download action, got %q, %v", actions, objs[stored].Error)
+	}
+	for oid, code := range map[string]int{hidden: 404, failing: 410, missing: 404} {
+		if objs[oid].Error == nil || objs[oid].Error.Code != code || len(objs[oid].Actions) > 0 {
+			t.Errorf("%v: expected only a %d error, got %+v", oid, code, objs[oid])
+		}
+	}
+
+	server.Script(missing, ObjectScript{Actions: []string{"upload"}, Header: map[string]string{"X-Test": "1"}})
+	_, objs = postBatch(t, server, "upload", nil, stored, hidden, missing)
+	for oid, expected := range map[string]string{stored: "", hidden: "upload,verify", missing: "upload"} {
+		if actions := actionNames(objs[oid]); actions != expected {
+			t.Errorf("%v: expected actions %q, got %q", oid, expected, actions)
+		}
+	}
+	if header := objs[missing].Actions["upload"].Header["X-Test"]; header != "1" {
+		t.Errorf("expected the scripted header, got %q", header)
+	}
+}
+
+func TestLfsServerStorage(t *testing.T) {
+	server := NewLfsServer()
+	defer server.Close()
+	oid := server.AddObject("0123456789")
+
+	req, _ := http.NewRequest("GET", server.URL+"/storage/"+oid, nil)
+	req.Header.Set("Range", "bytes=4-")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("storage request failed: %v", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 206 || string(body) != "456789" {
+		t.Errorf("expected a 206 with the range, got %d %q", res.StatusCode, body)
+	}
+
+	upload := func(oid, content string) int {
+		req, _ := http.NewRequest("PUT", server.URL+"/storage/"+oid, strings.NewReader(content))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("storage request failed: %v", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	newOid := lfsServerOid([]byte("new"))
+	if status := upload(newOid, "wrong"); status != 422 {
+		t.Errorf("expected a 422 for the wrong content, got %d", status)
+	}
+	if status := upload(newOid, "new"); status != 200 {
+		t.Errorf("expected a 200 for the right content, got %d", status)
+	}
+	if content, ok := server.Object(newOid); !ok || content != "new" {
+		t.Errorf("expected the upload to be stored, got %q", content)
+	}
+
+	verify := func(oid string, size int) int {
+		body, _ := json.Marshal(map[string]interface{}{"oid": oid, "size": size})
+		res, err := http.Post(server.URL+"/verify", "application/vnd.git-lfs+json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatalf("verify request failed: %v", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if status := verify(newOid, 3); status != 200 {
+		t.Errorf("expected verify to pass, got %d", status)
+	}
+	if status := verify(newOid, 4); status != 422 {
+		t.Errorf("expected verify of the wrong size to fail, got %d", status)
+	}
+	if status := verify(strings.Repeat("0", 64), 3); status != 404 {
+		t.Errorf("expected verify of a missing object to fail, got %d", status)
+	}
+}
+
+func TestLfsServerBasicAuth(t *testing.T) {
+	server := NewLfsServer()
+	defer server.Close()
+	server.SetAuth(LfsServerAuth{Mode: AuthBasic, Username: "user", Password: "pass", Challenges: 1})
+
+	good := map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}
+	for i, test := range []struct {
+		header map[string]string
+		status int
+	}{
+		{good, 401}, // challenged whatever the credentials
+		{nil, 401},
+		{map[string]string{"Authorization": "Basic dXNlcjp3cm9uZw=="}, 403},
+		{good, 200},
+	} {
+		res, _ := postBatch(t, server, "download", test.header)
+		if res.StatusCode != test.status {
+			t.Errorf("request %d: expected %d, got %d", i, test.status, res.StatusCode)
+		}
+		if res.StatusCode == 401 && res.Header.Get("Lfs-Authenticate") == "" {
+			t.Errorf("request %d: expected a challenge", i)
+		}
+	}
+}
+
+func TestLfsServerTokenAuth(t *testing.T) {
+	server := NewLfsServer()
+	defer server.Close()
+	server.SetAuth(LfsServerAuth{Mode: AuthToken, Token: "secret"})
+	oid := server.AddObject("foo")
+
+	if res, _ := postBatch(t, server, "download", map[string]string{"Authorization": "Bearer wrong"}, oid); res.StatusCode != 403 {
+		t.Errorf("expected a 403 for the wrong token, got %d", res.StatusCode)
+	}
+
+	_, objs := postBatch(t, server, "download", map[string]string{"Authorization": "Bearer secret"}, oid)
+	action := objs[oid].Actions["download"]
+	for header, status := range map[string]int{"": 403, action.Header["Authorization"]: 200} {
+		req, _ := http.NewRequest("GET", action.Href, nil)
+		req.Header.Set("Authorization", header)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("storage request failed: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != status {
+			t.Errorf("storage with %q: expected %d, got %d", header, status, res.StatusCode)
+		}
+	}
+}
+
+func TestLfsServerFaults(t *testing.T) {
+	server := NewLfsServer()
+	defer server.Close()
+	oid := server.AddObject("foo")
+	server.AddFault(LfsServerFault{Path: "/objects/", Count: 2, Status: 429, RetryAfter: 3 * time.Second})
+	server.AddFault(LfsServerFault{Path: "/storage/", Count: 1, Stall: 50 * time.Millisecond})
+
+	for i, status := range []int{429, 429, 200} {
+		res, _ := postBatch(t, server, "download", nil, oid)
+		if res.StatusCode != status {
+			t.Errorf("request %d: expected %d, got %d", i, status, res.StatusCode)
+		}
+		if retryAfter := res.Header.Get("Retry-After"); status == 429 && retryAfter != "3" {
+			t.Errorf("request %d: expected Retry-After 3, got %q", i, retryAfter)
+		}
+	}
+
+	start := time.Now()
+	res, err := http.Get(server.URL + "/storage/" + oid)
+	if err != nil {
+		t.Fatalf("storage request failed: %v", err)
+	}
+	res.Body.Close()
+	if elapsed := time.Since(start); res.StatusCode != 200 || elapsed < 50*time.Millisecond {
+		t.Errorf("expected a stalled 200, got %d after %v", res.StatusCode, elapsed)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 4 {
+		t.Fatalf("expected 4 recorded requests, got %d", len(requests))
+	}
+	if r := requests[0]; r.Method != "POST" || r.Path != "/objects/batch" || !strings.Contains(string(r.Body), oid) {
+		t.Errorf("unexpected first request %v %v %q", r.Method, r.Path, r.Body)
+	}
+	if r := requests[3]; r.Method != "GET" || r.Path != "/storage/"+oid {
+		t.Errorf("unexpected last request %v %v", r.Method, r.Path)
+	}
+}
+
+func TestLfsServerCloseEndsStalls(t *testing.T) {
+	server := NewLfsServer()
+	server.AddFault(LfsServerFault{Count: 1, Stall: time.Hour})
+
+	done := make(chan struct{})
+	go func() {
+		if res, err := http.Get(server.URL + "/storage/x"); err == nil {
+			res.Body.Close()
+		}
+		close(done)
+	}()
+
+	for len(server.Requests()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	server.Close()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("stalled request wasn't ended by Close")
+	}
+}
@@ -54,3 +54,57 @@ begin_test "batch error handling"
 )
 end_test
 
+
+begin_test "batch error handling: retry server errors"
+(
+  set -e
+
+  # The server fails every other batch request for "flakybatch*" repos with a
+  # 502 status.
+  reponame="flakybatch"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" flaky
+
+  git lfs track "*.dat"
+  contents="flaky"
+  contents_oid=$(calc_oid "$contents")
+  printf "$contents" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "api: batch failed, retrying in .* (1/1)" push.log
+  assert_server_object "$reponame" "$contents_oid"
+)
+end_test
+
+begin_test "batch error handling: retries turned off"
+(
+  set -e
+
+  reponame="flakybatch-noretries"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" noretries
+
+  git lfs track "*.dat"
+  contents="no retries"
+  contents_oid=$(calc_oid "$contents")
+  printf "$contents" > a.dat
+  printf "also no retries" > b.dat
+  git add .gitattributes a.dat b.dat
+  git commit -m "add a.dat and b.dat"
+
+  git config lfs.transfer.maxretries 0
+  set +e
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  res="${PIPESTATUS[0]}"
+  set -e
+
+  [ "$res" != "0" ]
+  grep "502" push.log
+  [ "0" = "$(grep -c "retrying" push.log)" ]
+  # the failed batch fails both objects, but they're logged together
+  [ "1" = "$(grep -c "Errors logged to" push.log)" ]
+  refute_server_object "$reponame" "$contents_oid"
+)
+end_test
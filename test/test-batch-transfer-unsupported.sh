@@ -59,3 +59,74 @@ begin_test "batch transfer unsupported on server"
   set -e
 )
 end_test
+
+begin_test "batch transfer unsupported: remembered for the endpoint"
+(
+  set -e
+
+  reponame="batchnotimplemented" # the server returns 501 from the batch API
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" notimplemented
+
+  git lfs track "*.dat"
+  printf "a" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "api: batch not implemented: 501" push.log
+  assert_server_object "$reponame" "$(calc_oid "a")"
+
+  endpoint="$GITSERVER/$reponame.git/info/lfs"
+  [ "false" = "$(git config "lfs.$endpoint.batch")" ]
+  git config "lfs.$endpoint.batchprobed"
+  [ -z "$(git config lfs.batch)" ]
+
+  # the next transfer goes straight to the legacy API
+  printf "b" > b.dat
+  git add b.dat
+  git commit -m "add b.dat"
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "running as individual queue" push.log
+  [ "0" = "$(grep -c "api: batch not implemented" push.log)" ]
+  assert_server_object "$reponame" "$(calc_oid "b")"
+
+  # and the batch API is tried again once lfs.batchprobeinterval has passed
+  git config "lfs.$endpoint.batchprobed" "$(( $(date +%s) - 2 * 3600 ))"
+  git config lfs.batchprobeinterval 1
+  printf "c" > c.dat
+  git add c.dat
+  git commit -m "add c.dat"
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "api: batch not implemented: 501" push.log
+  assert_server_object "$reponame" "$(calc_oid "c")"
+  probed="$(git config "lfs.$endpoint.batchprobed")"
+  [ "$probed" -gt "$(( $(date +%s) - 600 ))" ]
+)
+end_test
+
+begin_test "batch transfer unsupported: forgotten once the server supports it"
+(
+  set -e
+
+  reponame="batch-transfer-upgraded"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" upgraded
+
+  endpoint="$GITSERVER/$reponame.git/info/lfs"
+  git config "lfs.$endpoint.batch" false
+  git config "lfs.$endpoint.batchprobed" "$(( $(date +%s) - 8 * 24 * 3600 ))"
+
+  git lfs track "*.dat"
+  printf "upgraded" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "running as batched queue" push.log
+  assert_server_object "$reponame" "$(calc_oid "upgraded")"
+
+  [ -z "$(git config "lfs.$endpoint.batch")" ]
+  [ -z "$(git config "lfs.$endpoint.batchprobed")" ]
+)
+end_test
@@ -47,6 +47,8 @@ begin_test "checkout"
   [ "$contents" = "$(cat file3.dat)" ]
   [ "$contents" = "$(cat folder1/nested.dat)" ]
   [ "$contents" = "$(cat folder2/nested.dat)" ]
+  # the index has been refreshed, so git doesn't need to check the files again
+  [ -z "$(git diff-files --name-only)" ]
 
   # Remove again
   rm -rf file1.dat file2.dat file3.dat folder1/nested.dat folder2/nested.dat
@@ -119,3 +121,221 @@ begin_test "checkout: outside git repository"
   grep "Not in a git repository" checkout.log
 )
 end_test
+
+begin_test "checkout: case collisions"
+(
+  set -e
+
+  reponame="checkout-case-collisions"
+  git init "$reponame"
+  cd "$reponame"
+
+  touch case-probe
+  if [ ! -e CASE-PROBE ]; then
+    echo "Passes because the filesystem is case sensitive."
+    exit 0
+  fi
+  rm case-probe
+
+  git lfs track "*.dat"
+
+  upper="upper case"
+  lower="lower case"
+  upper_blob="$(printf "$upper" | git lfs clean | git hash-object -w --stdin)"
+  lower_blob="$(printf "$lower" | git lfs clean | git hash-object -w --stdin)"
+
+  # Both names can only be in the index, not the working tree
+  git add .gitattributes
+  git update-index --add --cacheinfo 100644 "$upper_blob" Texture.dat
+  git update-index --add --cacheinfo 100644 "$lower_blob" texture.dat
+  git commit -m "add colliding files"
+
+  rm -f Texture.dat texture.dat
+  git lfs checkout 2>&1 | tee checkout.log
+  grep "differ only in case" checkout.log
+  grep "    Texture.dat, texture.dat" checkout.log
+  [ "$upper" = "$(cat Texture.dat)" ]
+  ls | grep -x "Texture.dat"
+)
+end_test
+
+begin_test "checkout: many files with several workers"
+(
+  set -e
+
+  reponame="checkout-many-files"
+  git init "$reponame"
+  cd "$reponame"
+
+  git lfs track "*.dat"
+  for i in $(seq 1 300); do
+    mkdir -p "dir$((i % 10))"
+    printf "content $i" > "dir$((i % 10))/file$i.dat"
+  done
+  git add .gitattributes dir*
+  git commit -m "add 300 files" | tail -1
+
+  git config lfs.checkoutworkers 8
+  rm -rf dir*
+  git lfs checkout
+
+  for i in $(seq 1 300); do
+    [ "content $i" = "$(cat "dir$((i % 10))/file$i.dat")" ]
+  done
+  [ "300" = "$(ls dir*/ | grep -c "\.dat$")" ]
+  [ -z "$(git status --porcelain)" ]
+
+  # content that isn't local is reported in path order, whatever order the
+  # workers finish in
+  rm -rf dir* .git/lfs/objects
+  git lfs checkout 2> checkout.log
+  grep "Skipped checkout for" checkout.log > skipped.log
+  [ "300" = "$(wc -l < skipped.log | tr -d ' ')" ]
+  sort -c skipped.log
+)
+end_test
+
+begin_test "checkout: paths are relative to the current directory"
+(
+  set -e
+
+  reponame="checkout-anchored-paths"
+  git init "$reponame"
+  cd "$reponame"
+
+  git lfs track "*.dat"
+  mkdir folder
+  printf "top" > a.dat
+  printf "nested" > folder/a.dat
+  git add .gitattributes a.dat folder
+  git commit -m "add a.dat twice"
+
+  rm a.dat folder/a.dat
+  git lfs checkout a.dat
+  [ "top" = "$(cat a.dat)" ]
+  [ ! -e folder/a.dat ]
+
+  cd folder
+  git lfs checkout a.dat
+  [ "nested" = "$(cat a.dat)" ]
+)
+end_test
+
+begin_test "checkout: files excluded from fetching"
+(
+  set -e
+
+  reponame="checkout-fetch-excluded"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  mkdir ps4 xbox
+  printf "ps4" > ps4/a.dat
+  printf "xbox" > xbox/b.dat
+  printf "other" > c.dat
+  git add .gitattributes ps4 xbox c.dat
+  git commit -m "add files"
+  git push origin master
+
+  cd ..
+  GIT_LFS_SKIP_SMUDGE=1 clone_repo "$reponame" "$reponame-clone"
+
+  git config lfs.fetchinclude "ps4,c.dat"
+  git lfs fetch
+  refute_local_object "$(calc_oid "xbox")"
+
+  # xbox/b.dat is excluded, so it's left as a pointer without complaint
+  git lfs checkout 2>&1 | tee checkout.log
+  [ "${PIPESTATUS[0]}" = "0" ]
+  [ "0" = "$(grep -c "Skipped checkout" checkout.log)" ]
+  [ "ps4" = "$(cat ps4/a.dat)" ]
+  [ "other" = "$(cat c.dat)" ]
+  [ "$(pointer "$(calc_oid "xbox")" 4)" = "$(cat xbox/b.dat)" ]
+
+  # without the config, it's skipped with an error as it's not local
+  git config --unset lfs.fetchinclude
+  rm xbox/b.dat
+  git lfs checkout 2>&1 | tee checkout.log
+  grep "Skipped checkout for xbox/b.dat, content not local" checkout.log
+)
+end_test
+
+begin_test "checkout: from the index, keeping executable files' mode"
+(
+  set -e
+
+  reponame="checkout-index-mode"
+  git init "$reponame"
+  cd "$reponame"
+
+  git lfs track "*.dat"
+  printf "script" > run.dat
+  chmod +x run.dat
+  printf "plain" > plain.dat
+  git add .gitattributes run.dat plain.dat
+  git commit -m "add an executable"
+
+  # staged, but not yet committed
+  printf "staged" > staged.dat
+  git add staged.dat
+
+  rm run.dat plain.dat staged.dat
+  git lfs checkout
+  [ "script" = "$(cat run.dat)" ]
+  [ -x run.dat ]
+  [ "plain" = "$(cat plain.dat)" ]
+  [ ! -x plain.dat ]
+  [ "staged" = "$(cat staged.dat)" ]
+
+  # the index is refreshed, so nothing shows as changed
+  [ "A  staged.dat" = "$(git status --porcelain)" ]
+
+  # a file that's been edited is left alone
+  printf "edited" > plain.dat
+  git lfs checkout "*.dat"
+  [ "edited" = "$(cat plain.dat)" ]
+)
+end_test
+
+begin_test "checkout: hardlink mode"
+(
+  set -e
+
+  reponame="checkout-hardlink"
+  git init "$reponame"
+  cd "$reponame"
+  git config lfs.checkoutmode hardlink
+
+  git lfs track "*.dat"
+  printf "linked" > linked.dat
+  printf "script" > run.dat
+  chmod +x run.dat
+  git add .gitattributes linked.dat run.dat
+  git commit -m "add objects"
+
+  linked_oid="$(calc_oid "linked")"
+  linked_object=".git/lfs/objects/${linked_oid:0:2}/${linked_oid:2:2}/$linked_oid"
+
+  rm linked.dat run.dat
+  git lfs checkout
+  [ "linked" = "$(cat linked.dat)" ]
+  [ "script" = "$(cat run.dat)" ]
+  [ -x run.dat ]
+  [ "" = "$(git status --porcelain)" ]
+
+  # linked.dat is the object, but run.dat is copied so the object's mode
+  # doesn't change with it
+  [ linked.dat -ef "$linked_object" ]
+  script_oid="$(calc_oid "script")"
+  script_object=".git/lfs/objects/${script_oid:0:2}/${script_oid:2:2}/$script_oid"
+  [ ! run.dat -ef "$script_object" ]
+  [ ! -x "$script_object" ]
+
+  # editing a linked file in place changes the object, which fsck finds
+  printf " and edited" >> linked.dat
+  git lfs fsck --dry-run 2>&1 | tee fsck.log
+  grep "Object linked.dat ($linked_oid) is corrupt" fsck.log
+  grep "hard linked to other files" fsck.log
+)
+end_test
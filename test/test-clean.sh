@@ -56,3 +56,23 @@ This is my test pointer.  There are many like it, but this one is mine.\n" | git
   [ "$(pointer c2f909f6961bf85a92e2942ef3ed80c938a3d0ebaee6e72940692581052333be 586)" = "$(cat clean.log)" ]
 )
 end_test
+
+begin_test "clean with core.sharedRepository"
+(
+  set -e
+  mkdir shared
+  cd shared
+  git init --shared=group
+
+  echo "whatever" | git lfs clean | tee clean.log
+  [ "$(pointer cd293be6cea034bd45a0352775a219ef5dc7825ce55d1f7dae9762d80ce64411 9)" = "$(cat clean.log)" ]
+
+  # objects and the dirs they're in are writable by the group, and dirs keep
+  # new files in the group
+  object=".git/lfs/objects/cd/29/cd293be6cea034bd45a0352775a219ef5dc7825ce55d1f7dae9762d80ce64411"
+  [ "$(ls -l "$object" | cut -c 1-10)" = "-rw-rw----" ]
+  for dir in .git/lfs .git/lfs/objects .git/lfs/objects/cd .git/lfs/objects/cd/29 .git/lfs/tmp .git/lfs/logs; do
+    [ "$(ls -ld "$dir" | cut -c 5-7)" = "rws" ]
+  done
+)
+end_test
@@ -17,7 +17,7 @@ begin_test "commit, delete, then push"
   git add deleted.dat .gitattributes
   git commit -m "add deleted file"
 
-  git lfs push origin master --dry-run | grep "push ee31ef227442936872744b50d3297385c08b40ffc7baeaf34a39e6d81d6cd9ee => deleted.dat"
+  git lfs push origin master --dry-run | grep "would upload ee31ef2274 (8 B) deleted.dat"
 
   assert_pointer "master" "deleted.dat" "$deleted_oid" 8
 
@@ -27,15 +27,15 @@ begin_test "commit, delete, then push"
   git commit -m "add file"
 
   git lfs push origin master --dry-run | tee dryrun.log
-  grep "push ee31ef227442936872744b50d3297385c08b40ffc7baeaf34a39e6d81d6cd9ee => deleted.dat" dryrun.log
-  grep "push 3428719b7688c78a0cc8ba4b9e80b4e464c815fbccfd4b20695a15ffcefc22af => added.dat" dryrun.log
+  grep "would upload ee31ef2274 (8 B) deleted.dat" dryrun.log
+  grep "would upload 3428719b76 (6 B) added.dat" dryrun.log
 
   git rm deleted.dat
   git commit -m "did not need deleted.dat after all"
 
   git lfs push origin master --dry-run 2>&1 | tee dryrun.log
-  grep "push ee31ef227442936872744b50d3297385c08b40ffc7baeaf34a39e6d81d6cd9ee => deleted.dat" dryrun.log
-  grep "push 3428719b7688c78a0cc8ba4b9e80b4e464c815fbccfd4b20695a15ffcefc22af => added.dat" dryrun.log
+  grep "would upload ee31ef2274 (8 B) deleted.dat" dryrun.log
+  grep "would upload 3428719b76 (6 B) added.dat" dryrun.log
 
   git log
   git push origin master 2>&1 > push.log || {
@@ -149,3 +149,74 @@ begin_test "extension config (with gitconfig)"
   [ "$expected2" = "$(git lfs ext)" ]
 )
 end_test
+
+begin_test "config from environment"
+(
+  set -e
+  reponame="config-from-env"
+  mkdir $reponame
+  cd $reponame
+  git init
+  git remote add origin "$GITSERVER/$reponame"
+
+  git config --file=.lfsconfig lfs.url http://lfsconfig-file
+  git lfs env | tee env.log
+  grep "Endpoint=http://lfsconfig-file (auth=none)" env.log
+
+  # .lfsconfig beats global git config, which still sets what it doesn't
+  git config --global lfs.url http://global-lfsconfig
+  git config --global lfs.concurrenttransfers 5
+  git lfs env | tee env.log
+  grep "Endpoint=http://lfsconfig-file (auth=none)" env.log
+  grep "ConcurrentTransfers=5" env.log
+
+  # local git config beats global
+  git config lfs.url http://local-lfsconfig
+  git config lfs.concurrenttransfers 7
+  git lfs env | tee env.log
+  grep "Endpoint=http://local-lfsconfig (auth=none)" env.log
+  grep "ConcurrentTransfers=7$" env.log
+
+  # the environment beats them all
+  GIT_LFS_URL=http://env-lfsconfig GIT_LFS_CONCURRENTTRANSFERS=16 GIT_LFS_BATCH=false \
+    git lfs env | tee env.log
+  grep -A1 "Endpoint=http://env-lfsconfig (auth=none)$" env.log | grep "  Source=GIT_LFS_URL$"
+  grep "ConcurrentTransfers=16 (from GIT_LFS_CONCURRENTTRANSFERS)" env.log
+  grep "BatchTransfer=false (from GIT_LFS_BATCH)" env.log
+
+  # but not when empty
+  GIT_LFS_CONCURRENTTRANSFERS= git lfs env | tee env.log
+  grep "ConcurrentTransfers=7$" env.log
+
+  git config --global --unset lfs.url
+  git config --global --unset lfs.concurrenttransfers
+)
+end_test
+
+begin_test "lfsconfig only sets safe keys"
+(
+  set -e
+  reponame="lfsconfig-safe-keys"
+  mkdir $reponame
+  cd $reponame
+  git init
+  git remote add origin "$GITSERVER/$reponame"
+
+  git config --file=.lfsconfig lfs.url http://lfsconfig-file
+  git config --file=.lfsconfig lfs.pushurl http://lfsconfig-push
+  git config --file=.lfsconfig lfs.concurrenttransfers 99
+  git config --file=.lfsconfig lfs.extension.foo.clean "foo-clean %f"
+  git config --file=.lfsconfig credential.helper "store"
+  git config --file=.lfsconfig remote.origin.url http://elsewhere
+
+  git lfs env 2>&1 | tee env.log
+  grep -A1 "^Endpoint=http://lfsconfig-file (auth=none)$" env.log | grep "^  Source=lfs.url$"
+  grep -A1 "^PushEndpoint=http://lfsconfig-push (auth=none)$" env.log | grep "^  Source=lfs.pushurl$"
+  grep "ConcurrentTransfers=3" env.log
+  grep 'WARNING: Ignoring "lfs.concurrenttransfers" in .lfsconfig' env.log
+  grep 'WARNING: Ignoring "lfs.extension.foo.clean" in .lfsconfig' env.log
+  grep 'WARNING: Ignoring "credential.helper" in .lfsconfig' env.log
+  grep 'WARNING: Ignoring "remote.origin.url" in .lfsconfig' env.log
+  [ "0" = "$(git lfs ext | grep -c "foo-clean")" ]
+)
+end_test
@@ -209,3 +209,95 @@ begin_test "credentials from netrc with bad password"
   [ "0" = "$(grep -c "(1 of 1 files)" push.log)" ]
 )
 end_test
+
+begin_test "credentials from askpass without a credential helper"
+(
+  set -e
+
+  reponame="$(basename "$0" ".sh")-askpass"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" askpass
+
+  cat > "$TRASHDIR/askpass.sh" <<ASKPASS
+#!/bin/sh
+echo "\$1" >> "$TRASHDIR/askpass.log"
+case "\$1" in
+  Username*) echo "user" ;;
+  Password*) echo "pass" ;;
+esac
+ASKPASS
+  chmod +x "$TRASHDIR/askpass.sh"
+
+  git lfs track "*.dat"
+  printf "askpass" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  # an empty credential.helper clears the lfstest helper from the global config
+  GIT_ASKPASS="$TRASHDIR/askpass.sh" GIT_TRACE=1 git -c credential.helper= lfs push origin master 2>&1 | tee push.log
+  grep "(1 of 1 files)" push.log
+  assert_server_object "$reponame" "$(calc_oid "askpass")"
+
+  grep "Username for '$GITSERVER" "$TRASHDIR/askpass.log"
+  grep "Password for '.*user@" "$TRASHDIR/askpass.log"
+  [ "0" = "$(grep -c "user:pass\|password=pass" push.log)" ]
+)
+end_test
+
+begin_test "credentials rejected with 401 aren't retried forever"
+(
+  set -e
+
+  reponame="rejectwith401-credentials"
+  setup_remote_repo "$reponame"
+
+  printf "path:wrong" > "$CREDSDIR/127.0.0.1--$reponame"
+
+  clone_repo "$reponame" rejected
+  git config credential.useHttpPath true
+
+  git lfs track "*.dat"
+  printf "rejected" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  set +e
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  res="${PIPESTATUS[0]}"
+  set -e
+
+  [ "$res" != "0" ]
+  grep "credentials for $GITSERVER/$reponame.git/info/lfs rejected 2 times, giving up" push.log
+  grep "Authorization error" push.log
+  refute_server_object "$reponame" "$(calc_oid "rejected")"
+)
+end_test
+
+begin_test "credentials in the remote url"
+(
+  set -e
+
+  reponame="$(basename "$0" ".sh")-url"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" url-creds
+
+  # "p%61ss" is "pass", percent-encoded
+  git remote set-url origin "$(echo "$GITSERVER" | sed "s|://|://user:p%61ss@|")/$reponame"
+
+  git lfs track "*.dat"
+  printf "url creds" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  GIT_TRACE=1 git lfs push origin master 2>&1 | tee push.log
+  grep "(1 of 1 files)" push.log
+  [ "1" = "$(grep -c "warning: current Git remote contains credentials" push.log)" ]
+  [ "0" = "$(grep -c "HTTP: 401" push.log)" ]
+  [ "0" = "$(grep -c "p%61ss" push.log)" ]
+  assert_server_object "$reponame" "$(calc_oid "url creds")"
+
+  git lfs env 2>&1 | tee env.log
+  grep "Endpoint=$(echo "$GITSERVER" | sed "s|://|://user:xxxxx@|")/$reponame.git/info/lfs" env.log
+  [ "0" = "$(grep -c "p%61ss" env.log)" ]
+)
+end_test
@@ -0,0 +1,94 @@
+#!/usr/bin/env bash
+
+. "test/testlib.sh"
+
+begin_test "custom transfers: push and pull with a custom adapter"
+(
+  set -e
+
+  # The server picks lfstest-customadapter for "customadapter*" repos when the
+  # client offers it.
+  reponame="customadapter-push-pull"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git config lfs.customtransfer.lfstest-customadapter.path lfstest-customadapter
+  git config lfs.customtransfer.lfstest-customadapter.concurrent false
+
+  git lfs track "*.dat"
+  printf "custom a" > a.dat
+  printf "custom b" > b.dat
+  git add .gitattributes a.dat b.dat
+  git commit -m "add objects"
+
+  a_oid="$(calc_oid "custom a")"
+  b_oid="$(calc_oid "custom b")"
+
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "using the lfstest-customadapter transfer adapter" push.log
+  grep "lfstest-customadapter: init upload" push.log
+  grep "(2 of 2 files)" push.log
+  [ "1" = "$(grep -c "custom transfer lfstest-customadapter: starting" push.log)" ]
+
+  assert_server_object "$reponame" "$a_oid"
+  assert_server_object "$reponame" "$b_oid"
+
+  cd ..
+  GIT_LFS_SKIP_SMUDGE=1 clone_repo "$reponame" "$reponame-clone"
+  git config lfs.customtransfer.lfstest-customadapter.path lfstest-customadapter
+
+  GIT_TRACE=1 git lfs pull 2>&1 | tee pull.log
+  grep "using the lfstest-customadapter transfer adapter" pull.log
+  grep "lfstest-customadapter: init download" pull.log
+
+  assert_local_object "$a_oid" 8
+  assert_local_object "$b_oid" 8
+  [ "custom a" = "$(cat a.dat)" ]
+  [ "custom b" = "$(cat b.dat)" ]
+)
+end_test
+
+begin_test "custom transfers: the server uses basic if not offered the adapter"
+(
+  set -e
+
+  reponame="customadapter-not-offered"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "basic" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "using the basic transfer adapter" push.log
+  [ "0" = "$(grep -c "lfstest-customadapter" push.log)" ]
+
+  assert_server_object "$reponame" "$(calc_oid "basic")"
+)
+end_test
+
+begin_test "custom transfers: direction limits the adapter"
+(
+  set -e
+
+  reponame="customadapter-direction"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git config lfs.customtransfer.lfstest-customadapter.path lfstest-customadapter
+  git config lfs.customtransfer.lfstest-customadapter.direction download
+
+  git lfs track "*.dat"
+  printf "direction" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "using the basic transfer adapter" push.log
+  [ "0" = "$(grep -c "lfstest-customadapter: init" push.log)" ]
+
+  assert_server_object "$reponame" "$(calc_oid "direction")"
+)
+end_test
@@ -0,0 +1,165 @@
+#!/usr/bin/env bash
+
+. "test/testlib.sh"
+
+begin_test "exit codes: usage errors"
+(
+  set -e
+
+  reponame="exit-codes-usage"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  git add .gitattributes
+  git commit -m "track *.dat"
+
+  set +e
+  git lfs fetch "not a remote" 2> fetch.log
+  res=$?
+  set -e
+  cat fetch.log
+  [ "$res" = "2" ]
+  grep "Invalid remote name" fetch.log
+
+  set +e
+  git lfs fetch origin no-such-ref 2> fetch.log
+  res=$?
+  set -e
+  cat fetch.log
+  [ "$res" = "2" ]
+  grep "Invalid ref argument \"no-such-ref\": no such branch, tag or commit" fetch.log
+
+  set +e
+  git lfs push --no-such-flag origin master 2> push.log
+  res=$?
+  set -e
+  cat push.log
+  [ "$res" = "2" ]
+
+  set +e
+  git lfs fetch --all --recent 2> fetch.log
+  res=$?
+  set -e
+  cat fetch.log
+  [ "$res" = "2" ]
+  grep "Cannot combine --all" fetch.log
+)
+end_test
+
+begin_test "exit codes: fetch"
+(
+  set -e
+
+  reponame="exit-codes-fetch"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "a" > a.dat
+  printf "b" > b.dat
+  git add .gitattributes a.dat b.dat
+  git commit -m "add a.dat and b.dat"
+  git push origin master
+
+  a_oid="$(calc_oid "a")"
+  b_oid="$(calc_oid "b")"
+  assert_server_object "$reponame" "$a_oid"
+  assert_server_object "$reponame" "$b_oid"
+
+  rm -rf .git/lfs/objects
+  git lfs fetch origin master
+  assert_local_object "$a_oid" 1
+  assert_local_object "$b_oid" 1
+
+  # one of two objects missing from the server is a partial failure
+  rm -rf .git/lfs/objects
+  delete_server_object "$reponame" "$b_oid"
+
+  set +e
+  git lfs fetch origin master 2> fetch.log
+  res=$?
+  set -e
+  cat fetch.log
+  [ "$res" = "3" ]
+  grep "1 of 2 objects failed to download" fetch.log
+  assert_local_object "$a_oid" 1
+  refute_local_object "$b_oid"
+
+  # and both missing is a complete failure
+  rm -rf .git/lfs/objects
+  delete_server_object "$reponame" "$a_oid"
+
+  set +e
+  git lfs fetch origin master 2> fetch.log
+  res=$?
+  set -e
+  cat fetch.log
+  [ "$res" = "1" ]
+  grep "2 of 2 objects failed to download" fetch.log
+)
+end_test
+
+begin_test "exit codes: push"
+(
+  set -e
+
+  reponame="exit-codes-push"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "good" > good.dat
+  printf "status-storage-403" > bad.dat
+  git add .gitattributes good.dat bad.dat
+  git commit -m "add good.dat and bad.dat"
+
+  set +e
+  git lfs push origin master 2> push.log
+  res=$?
+  set -e
+  cat push.log
+  [ "$res" = "3" ]
+  grep "1 of 2 objects failed to upload" push.log
+  assert_server_object "$reponame" "$(calc_oid "good")"
+  refute_server_object "$reponame" "$(calc_oid "status-storage-403")"
+
+  # only the bad object is left to push, and it fails again
+  set +e
+  git lfs push origin master 2> push.log
+  res=$?
+  set -e
+  cat push.log
+  [ "$res" = "1" ]
+  grep "1 of 1 objects failed to upload" push.log
+)
+end_test
+
+begin_test "exit codes: fsck"
+(
+  set -e
+
+  reponame="exit-codes-fsck"
+  git init "$reponame"
+  cd "$reponame"
+
+  git lfs track "*.dat"
+  printf "a" > a.dat
+  printf "b" > b.dat
+  git add .gitattributes a.dat b.dat
+  git commit -m "add a.dat and b.dat"
+
+  git lfs fsck
+
+  a_oid="$(calc_oid "a")"
+  echo "CORRUPTION" >> ".git/lfs/objects/${a_oid:0:2}/${a_oid:2:2}/$a_oid"
+
+  set +e
+  git lfs fsck 2> fsck.log
+  res=$?
+  set -e
+  cat fsck.log
+  [ "$res" = "1" ]
+  grep "Git LFS fsck failed: 1 of 2 objects are missing, corrupt or unreadable" fsck.log
+)
+end_test
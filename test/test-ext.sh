@@ -60,3 +60,55 @@ begin_test "ext"
   [ "$actual" = "$expected" ]
 )
 end_test
+
+begin_test "ext test"
+(
+  set -e
+
+  mkdir ext-test
+  cd ext-test
+  git init
+
+  git config lfs.extension.upper.clean "tr a-z A-Z"
+  git config lfs.extension.upper.smudge "tr A-Z a-z"
+  git config lfs.extension.upper.priority 0
+
+  git config lfs.extension.twice.clean "sed p"
+  git config lfs.extension.twice.smudge "sed n;p"
+  git config lfs.extension.twice.priority 1
+
+  printf "abc\n" > a.dat
+  git lfs ext test a.dat | tee test.log
+
+  expected="Extension: upper
+    priority = 0
+    in = $(calc_oid "abc
+") (4 bytes)
+    out = $(calc_oid "ABC
+") (4 bytes)
+Extension: twice
+    priority = 1
+    in = $(calc_oid "ABC
+") (4 bytes)
+    out = $(calc_oid "ABC
+ABC
+") (8 bytes)"
+  [ "$expected" = "$(cat test.log)" ]
+
+  # nothing is stored
+  [ -z "$(find .git/lfs/objects .git/lfs/tmp -type f ! -name .sharded)" ]
+
+  git config lfs.extension.twice.priority 0
+  set +e
+  git lfs ext test a.dat 2>&1 | tee test.log
+  [ "${PIPESTATUS[0]}" = "1" ] || exit 1
+  set -e
+  grep "duplicate priority 0 on twice and upper" test.log
+
+  set +e
+  git lfs ext test 2>&1 | tee test.log
+  [ "${PIPESTATUS[0]}" = "2" ] || exit 1
+  set -e
+  grep "Usage: git lfs ext test <file>" test.log
+)
+end_test
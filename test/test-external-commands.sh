@@ -0,0 +1,87 @@
+#!/usr/bin/env bash
+
+. "test/testlib.sh"
+
+# install_external_command writes a git-lfs-<name> script to a new dir, which
+# is put first in PATH.
+install_external_command() {
+  local name="$1"
+  local script="$2"
+
+  mkdir -p "$TRASHDIR/external-bin"
+  printf "#!/bin/sh\n%s\n" "$script" > "$TRASHDIR/external-bin/git-lfs-$name"
+  chmod +x "$TRASHDIR/external-bin/git-lfs-$name"
+}
+
+begin_test "external commands: dispatch"
+(
+  set -e
+
+  install_external_command "audit" 'echo "audit: $# args: $*"; echo "env: $AUDIT_VAR"; cat'
+  export PATH="$TRASHDIR/external-bin:$PATH"
+
+  git init external-dispatch
+  cd external-dispatch
+
+  out="$(echo "from stdin" | AUDIT_VAR=set git lfs audit --verbose "two words" 2>&1)"
+  echo "$out"
+  [ "$(printf 'audit: 2 args: --verbose two words\nenv: set\nfrom stdin')" = "$out" ]
+)
+end_test
+
+begin_test "external commands: exit codes"
+(
+  set -e
+
+  install_external_command "fail" 'echo "failing" >&2; exit 42'
+  export PATH="$TRASHDIR/external-bin:$PATH"
+
+  set +e
+  git lfs fail 2> fail.log
+  res=$?
+  set -e
+
+  cat fail.log
+  [ "$res" = "42" ]
+  grep "failing" fail.log
+)
+end_test
+
+begin_test "external commands: built in commands win"
+(
+  set -e
+
+  install_external_command "version" 'echo "external version"'
+  export PATH="$TRASHDIR/external-bin:$PATH"
+
+  git lfs version | grep "git-lfs/"
+  [ "$(git lfs version | grep -c "external version")" = "0" ]
+)
+end_test
+
+begin_test "external commands: listed in help"
+(
+  set -e
+
+  install_external_command "audit" 'true'
+  install_external_command "report" 'true'
+  export PATH="$TRASHDIR/external-bin:$PATH"
+
+  git init external-help
+  cd external-help
+
+  git lfs help 2> help.log
+  grep "External commands found in PATH:" help.log
+  grep -x "    audit" help.log
+  grep -x "    report" help.log
+
+  set +e
+  git lfs no-such-command 2> unknown.log
+  res=$?
+  set -e
+
+  cat unknown.log
+  [ "$res" = "2" ]
+  grep 'unknown command "no-such-command"' unknown.log
+)
+end_test
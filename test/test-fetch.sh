@@ -547,3 +547,183 @@ begin_test "fetch with invalid remote"
   grep "Invalid remote name" fetch.log
 )
 end_test
+
+begin_test "fetch in bare repo with .lfsconfig"
+(
+  set -e
+
+  reponame="fetch-bare-lfsconfig"
+  storename="$reponame-store"
+  setup_remote_repo "$reponame"
+  setup_remote_repo "$storename"
+  clone_repo "$reponame" "$reponame"
+
+  git config --file=.lfsconfig lfs.url "$GITSERVER/$storename.git/info/lfs"
+  git lfs track "*.dat"
+  contents="bare"
+  contents_oid=$(calc_oid "$contents")
+  printf "$contents" > a.dat
+  git add .lfsconfig .gitattributes a.dat
+  git commit -m "add a.dat with a separate lfs server"
+  git push origin master
+
+  # the objects are only on the server named in .lfsconfig
+  assert_server_object "$storename" "$contents_oid"
+  refute_server_object "$reponame" "$contents_oid"
+
+  cd ..
+  git clone --bare "$GITSERVER/$reponame" "$reponame-bare"
+  cd "$reponame-bare"
+
+  git lfs env | tee env.log
+  grep "Endpoint=$GITSERVER/$storename.git/info/lfs" env.log
+
+  git lfs fetch 2>&1 | tee fetch.log
+  grep "(1 of 1 files)" fetch.log
+  assert_local_object "$contents_oid" 4
+)
+end_test
+
+begin_test "fetch with negated exclude patterns"
+(
+  set -e
+
+  reponame="fetch-negated-patterns"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  mkdir -p assets/big assets/previews/small
+  printf "big" > assets/big/texture.dat
+  printf "preview" > assets/previews/texture.dat
+  printf "small preview" > assets/previews/small/texture.dat
+  printf "other" > other.dat
+  git add .gitattributes assets other.dat
+  git commit -m "add assets"
+  git push origin master
+
+  big_oid="$(calc_oid "big")"
+  preview_oid="$(calc_oid "preview")"
+  small_oid="$(calc_oid "small preview")"
+  other_oid="$(calc_oid "other")"
+
+  # the previews are re-included from the excluded assets dir
+  rm -rf .git/lfs/objects
+  git lfs fetch -X "assets/**,!assets/previews/**" origin master
+  refute_local_object "$big_oid"
+  assert_local_object "$preview_oid" 7
+  assert_local_object "$small_oid" 13
+  assert_local_object "$other_oid" 5
+
+  # and the same from config
+  rm -rf .git/lfs/objects
+  git config lfs.fetchexclude "assets/**, !assets/previews/**"
+  git lfs fetch origin master
+  refute_local_object "$big_oid"
+  assert_local_object "$preview_oid" 7
+  assert_local_object "$small_oid" 13
+  assert_local_object "$other_oid" 5
+
+  # a negated pattern can't come first, since it would do nothing
+  set +e
+  git lfs fetch -X "!assets/previews/**" origin master 2> fetch.log
+  res=$?
+  set -e
+  cat fetch.log
+  [ "$res" = "2" ]
+  grep "Invalid --exclude: \"!assets/previews/\*\*\" can't come first" fetch.log
+
+  git config lfs.fetchexclude "!assets/previews/**"
+  set +e
+  git lfs fetch origin master 2> fetch.log
+  res=$?
+  set -e
+  cat fetch.log
+  [ "$res" = "1" ]
+  grep "Invalid lfs.fetchexclude" fetch.log
+)
+end_test
+
+begin_test "fetch --json"
+(
+  set -e
+
+  reponame="fetch-json"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "a" > a.dat
+  printf "b" > b.dat
+  mkdir skip
+  printf "c" > skip/c.dat
+  printf "d" > d.dat
+  git add .gitattributes a.dat b.dat skip d.dat
+  git commit -m "add files"
+  git push origin master
+
+  a_oid="$(calc_oid "a")"
+  b_oid="$(calc_oid "b")"
+  c_oid="$(calc_oid "c")"
+  d_oid="$(calc_oid "d")"
+
+  # a.dat is already here, skip/c.dat is excluded and d.dat is missing from
+  # the server
+  rm .git/lfs/objects/${b_oid:0:2}/${b_oid:2:2}/$b_oid
+  rm .git/lfs/objects/${d_oid:0:2}/${d_oid:2:2}/$d_oid
+  delete_server_object "$reponame" "$d_oid"
+
+  set +e
+  git lfs fetch --json -X "skip" origin master > fetch.json 2> fetch.log
+  res=$?
+  set -e
+  cat fetch.json
+  cat fetch.log
+  [ "$res" = "3" ]
+  [ "1" = "$(wc -l < fetch.json | tr -d ' ')" ]
+  grep "{\"oid\":\"$b_oid\",\"size\":1,\"path\":\"b.dat\",\"duration\":[0-9.e-]*,\"retries\":0}" fetch.json
+  grep "{\"oid\":\"$a_oid\",\"size\":1,\"path\":\"a.dat\",\"reason\":\"already present\"}" fetch.json
+  grep "{\"oid\":\"$c_oid\",\"size\":1,\"path\":\"skip/c.dat\",\"reason\":\"excluded by filter\"}" fetch.json
+  grep "\"errors\":\[{\"oid\":\"$d_oid\",\"path\":\"d.dat\",\"error\":" fetch.json
+  grep "Fetching master" fetch.log
+  grep "1 of 2 objects failed to download" fetch.log
+  assert_local_object "$b_oid" 1
+)
+end_test
+
+begin_test "fetch --recent downloads objects shared by refs once"
+(
+  set -e
+
+  reponame="fetch-recent-shared"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "shared" > shared.dat
+  git add .gitattributes shared.dat
+  git commit -m "add shared.dat"
+  git checkout -q -b other
+  printf "other" > other.dat
+  git add other.dat
+  git commit -m "add other.dat"
+  git checkout -q master
+  git push origin master other
+
+  shared_oid="$(calc_oid "shared")"
+  rm -rf .git/lfs/objects
+
+  git config lfs.fetchrecentrefsdays 7
+  git config lfs.fetchrecentcommitsdays 0
+  GIT_TRACE=1 git lfs fetch --recent origin 2>&1 | tee fetch.log
+  [ "${PIPESTATUS[0]}" = "0" ]
+  grep "Fetching other" fetch.log
+  [ "1" = "$(grep -c "fetch shared.dat \[$shared_oid\]" fetch.log)" ]
+  grep "Fetched 2 objects (11 B), 0 already present (0 B)" fetch.log
+  assert_local_object "$shared_oid" 6
+  assert_local_object "$(calc_oid "other")" 5
+
+  git lfs fetch --recent origin 2>&1 | tee fetch.log
+  grep "Fetched 0 objects (0 B), 2 already present (11 B)" fetch.log
+)
+end_test
@@ -104,6 +104,70 @@ begin_test "fsck dry run"
 )
 end_test
 
+begin_test "fsck missing, empty and unreferenced objects"
+(
+  set -e
+
+  reponame="fsck-missing-empty"
+  git init $reponame
+  cd $reponame
+
+  git lfs track "*.dat"
+  printf "a" > a.dat
+  printf "b" > b.dat
+  printf "c" > c.dat
+  git add .gitattributes *.dat
+  git commit -m "first commit"
+
+  aOid=$(calc_oid "a")
+  bOid=$(calc_oid "b")
+  cOid=$(calc_oid "c")
+  dOid=$(calc_oid "d")
+
+  # an object which isn't in HEAD, but is corrupt
+  mkdir -p .git/lfs/objects/${dOid:0:2}/${dOid:2:2}
+  printf "not d" > .git/lfs/objects/${dOid:0:2}/${dOid:2:2}/$dOid
+
+  # remove the working copies too, or git could clean them again
+  rm a.dat b.dat
+  rm .git/lfs/objects/${aOid:0:2}/${aOid:2:2}/$aOid
+  : > .git/lfs/objects/${bOid:0:2}/${bOid:2:2}/$bOid
+
+  # missing objects come first, then bad ones by OID
+  expected="$(printf 'Object a.dat (%s) is missing
+Object %s is corrupt
+Object b.dat (%s) is empty' "$aOid" "$dOid" "$bOid")"
+
+  set +e
+  git lfs fsck --dry-run > fsck.log 2> fsck-err.log
+  res=$?
+  set -e
+  [ "$res" = "1" ]
+  [ "$expected" = "$(cat fsck.log)" ]
+  grep "Git LFS fsck failed: 3 of 4 objects are missing, corrupt or unreadable" fsck-err.log
+
+  set +e
+  git lfs fsck --json --dry-run > fsck.json
+  res=$?
+  set -e
+  [ "$res" = "1" ]
+  grep "\"checked\":4" fsck.json
+  grep "{\"oid\":\"$aOid\",\"name\":\"a.dat\",\"problem\":\"missing\"}" fsck.json
+  grep "{\"oid\":\"$bOid\",\"name\":\"b.dat\",\"problem\":\"empty\"," fsck.json
+  grep "{\"oid\":\"$dOid\",\"problem\":\"corrupt\",\"actual_oid\":\"$(calc_oid "not d")\"}" fsck.json
+
+  # bad objects are deleted when not quarantined
+  set +e
+  git lfs fsck --quarantine=false > fsck.log
+  set -e
+  grep "  deleted" fsck.log
+  [ ! -e .git/lfs/objects/${bOid:0:2}/${bOid:2:2}/$bOid ]
+  [ ! -e .git/lfs/objects/${dOid:0:2}/${dOid:2:2}/$dOid ]
+  [ ! -e .git/lfs/bad ]
+  [ -e .git/lfs/objects/${cOid:0:2}/${cOid:2:2}/$cOid ]
+)
+end_test
+
 begin_test "fsck: outside git repository"
 (
   set +e
@@ -31,7 +31,7 @@ begin_test "install with old settings"
   res=$?
   set -e
 
-  [ "$res" = 2 ]
+  [ "$res" = 1 ]
 
   cat install.log
   grep -E "(clean|smudge) attribute should be" install.log
@@ -0,0 +1,126 @@
+#!/usr/bin/env bash
+
+. "test/testlib.sh"
+
+begin_test "lock and unlock"
+(
+  set -e
+
+  reponame="lock_and_unlock"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  mkdir dir
+  echo "a" > dir/a.dat
+
+  git lfs lock dir/a.dat | tee lock.log
+  grep "Locked dir/a.dat" lock.log
+  grep "\"path\": \"dir/a.dat\"" .git/lfs/lockcache.json
+
+  # paths are relative to the current dir
+  cd dir
+  git lfs locks --path a.dat | tee locks.log
+  grep "dir/a.dat	user	ID:" locks.log
+
+  set +e
+  git lfs lock a.dat 2>&1 | tee lock.log
+  res=${PIPESTATUS[0]}
+  set -e
+  if [ "0" -eq "$res" ]; then
+    echo "locking an already locked file should fail"
+    exit 1
+  fi
+  cd ..
+
+  git lfs unlock dir/a.dat | tee unlock.log
+  grep "Unlocked dir/a.dat" unlock.log
+  [ "0" = "$(grep -c "dir/a.dat" .git/lfs/lockcache.json)" ]
+  [ "" = "$(git lfs locks)" ]
+)
+end_test
+
+begin_test "unlock by id"
+(
+  set -e
+
+  reponame="unlock_by_id"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  create_server_lock "$reponame" "a.dat" "other"
+  id=$(git lfs locks --path a.dat | sed -e 's/.*ID://')
+
+  set +e
+  git lfs unlock --id="$id" 2>&1 | tee unlock.log
+  res=${PIPESTATUS[0]}
+  set -e
+  if [ "0" -eq "$res" ]; then
+    echo "unlocking someone else's lock should fail without --force"
+    exit 1
+  fi
+
+  git lfs unlock --id="$id" --force | tee unlock.log
+  grep "Unlocked $id" unlock.log
+  [ "" = "$(git lfs locks)" ]
+)
+end_test
+
+begin_test "locks refreshes the cache"
+(
+  set -e
+
+  reponame="locks_refresh_cache"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  echo "initial" > initial.txt
+  git add initial.txt
+  git commit -m "initial"
+  git push origin master
+
+  create_server_lock "$reponame" "mine.dat"
+  create_server_lock "$reponame" "theirs.dat" "other"
+  [ ! -e .git/lfs/lockcache.json ]
+
+  git lfs locks | tee locks.log
+  grep "mine.dat	user" locks.log
+  grep "theirs.dat	other" locks.log
+
+  # only our own locks are cached
+  grep "mine.dat" .git/lfs/lockcache.json
+  [ "0" = "$(grep -c "theirs.dat" .git/lfs/lockcache.json)" ]
+)
+end_test
+
+begin_test "locks --local"
+(
+  set -e
+
+  reponame="locks_local"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs lock a.dat
+  git lfs lock b.dat
+
+  # nothing listens here, so any request would fail
+  git config lfs.url "http://127.0.0.1:1/$reponame.git/info/lfs"
+
+  git lfs locks --local | tee locks.log
+  [ "2" = "$(wc -l < locks.log | tr -d ' ')" ]
+  grep "a.dat	user	ID:" locks.log
+  grep "b.dat	user	ID:" locks.log
+
+  git lfs locks --local --path b.dat | tee locks.log
+  [ "1" = "$(wc -l < locks.log | tr -d ' ')" ]
+
+  set +e
+  git lfs locks 2>&1 | tee locks.log
+  res=${PIPESTATUS[0]}
+  set -e
+  if [ "0" -eq "$res" ]; then
+    echo "locks should fail with the server unreachable"
+    exit 1
+  fi
+)
+end_test
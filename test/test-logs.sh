@@ -16,14 +16,68 @@ begin_test "logs"
   boomtownExit=$?
   set -e
 
-  [ "$boomtownExit" = "2" ]
+  [ "$boomtownExit" = "1" ]
 
-  logname=`ls .git/lfs/objects/logs`
-  logfile=".git/lfs/objects/logs/$logname"
+  logname=`ls .git/lfs/logs`
+  logfile=".git/lfs/logs/$logname"
   cat "$logfile"
   echo "... grep ..."
   grep "$ git-lfs logs boomtown" "$logfile"
 
   [ "$(cat "$logfile")" = "$(git lfs logs last)" ]
+  [ "$logname" = "$(git lfs logs)" ]
+
+  git lfs logs clear
+  [ -z "$(git lfs logs)" ]
+  [ "No logs to show" = "$(git lfs logs last)" ]
+)
+end_test
+
+begin_test "logs: with lfs.storage"
+(
+  set -e
+
+  mkdir logs-storage
+  cd logs-storage
+  git init
+  git config lfs.storage "$TRASHDIR/logs-storage-dir"
+
+  set +e
+  git lfs logs boomtown
+  set -e
+
+  [ ! -d .git/lfs/logs ]
+  logname=`ls "$TRASHDIR/logs-storage-dir/logs"`
+  [ "$logname" = "$(git lfs logs)" ]
+)
+end_test
+
+begin_test "logs: transfer errors"
+(
+  set -e
+
+  reponame="logs-transfer-errors"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "hi" > good.dat
+  printf "status-storage-422" > bad.dat
+  git add .gitattributes good.dat bad.dat
+  git commit -m "add files"
+
+  set +e
+  git lfs push origin master 2>&1 | tee push.log
+  res="${PIPESTATUS[0]}"
+  set -e
+  [ "$res" != "0" ]
+
+  grep "Errors logged to" push.log
+  [ "1" = "$(git lfs logs | wc -l | tr -d ' ')" ]
+
+  git lfs logs last | tee last.log
+  grep "$ git-lfs push origin master" last.log
+  grep "Endpoint=$GITSERVER/$reponame.git/info/lfs" last.log
+  grep "$(calc_oid "status-storage-422")" last.log
 )
 end_test
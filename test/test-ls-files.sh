@@ -52,17 +52,20 @@ begin_test "ls-files: with zero files"
   git lfs track "*.dat"
   git add .gitattributes
 
+  [ "$(git lfs ls-files)" = "" ]
+
   set +e
-  git lfs ls-files 2> ls-files.log
+  git lfs ls-files HEAD 2> ls-files.log
   res=$?
   set -e
 
   cat ls-files.log
-  [ "$res" = "2" ]
+  [ "$res" = "1" ]
   grep "Git can't resolve ref:" ls-files.log
 
   git commit -m "initial commit"
   [ "$(git lfs ls-files)" = "" ]
+  [ "$(git lfs ls-files HEAD)" = "" ]
 )
 end_test
 
@@ -109,3 +112,48 @@ a1fff0ffefb9eace7230c24e50731f0a91c62f9cefdfe77121c2f607125dffae * two.tgz")"
   [ "$expected" = "$(git lfs ls-files --long)" ]
 )
 end_test
+
+begin_test "ls-files: index, refs, sizes and missing objects"
+(
+  set -e
+
+  mkdir lsIndexRepo
+  cd lsIndexRepo
+  git init
+
+  git lfs track "*.dat" | grep "Tracking \*.dat"
+  mkdir -p dir/sub
+  printf "a" > a.dat
+  printf "in dir" > dir/b.dat
+  printf "not lfs" > dir/c.txt
+  git add .gitattributes a.dat dir
+  git commit -m "initial commit"
+
+  aOid="$(calc_oid "a")"
+  bOid="$(calc_oid "in dir")"
+  dOid="$(calc_oid "staged")"
+
+  # staged files are listed by default, but not for a ref
+  printf "staged" > dir/sub/d.dat
+  git add dir/sub/d.dat
+
+  expected="$(printf "%s * a.dat\n%s * dir/b.dat\n%s * dir/sub/d.dat" "${aOid:0:10}" "${bOid:0:10}" "${dOid:0:10}")"
+  [ "$expected" = "$(git lfs ls-files)" ]
+
+  expected="$(printf "%s * a.dat\n%s * dir/b.dat" "${aOid:0:10}" "${bOid:0:10}")"
+  [ "$expected" = "$(git lfs ls-files HEAD)" ]
+
+  expected="$(printf "%s * a.dat (1 B)\n%s * dir/b.dat (6 B)\n%s * dir/sub/d.dat (6 B)" "${aOid:0:10}" "${bOid:0:10}" "${dOid:0:10}")"
+  [ "$expected" = "$(git lfs ls-files --size)" ]
+
+  # paths are relative to the current dir
+  pushd dir/sub
+    expected="$(printf "%s * ../../a.dat\n%s * ../b.dat\n%s * d.dat" "${aOid:0:10}" "${bOid:0:10}" "${dOid:0:10}")"
+    [ "$expected" = "$(git lfs ls-files)" ]
+  popd
+
+  # the marker shows whether the object is stored locally
+  rm ".git/lfs/objects/${aOid:0:2}/${aOid:2:2}/$aOid"
+  [ "${aOid:0:10} - a.dat" = "$(git lfs ls-files HEAD | grep a.dat)" ]
+)
+end_test
@@ -461,6 +461,50 @@ begin_test "pre-push unfetched deleted remote branch & server GC"
 )
 end_test
 
+begin_test "pre-push force pushed remote branch & server GC"
+(
+  # like the test above, but the branch was force pushed away on the remote,
+  # while the local cache of it still has the commits being pushed back, so
+  # only checking with the server finds that their objects need uploading
+  set -e
+
+  reponame="$(basename "$0" ".sh")-server-force-pushed-gc"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "base" > base.dat
+  git add .gitattributes base.dat
+  git commit -m "add base.dat"
+  base="$(git rev-parse HEAD)"
+
+  git checkout -b feature
+  printf "feature" > feature.dat
+  git add feature.dat
+  git commit -m "add feature.dat"
+  feature="$(git rev-parse HEAD)"
+  git push origin master feature
+  assert_server_object "$reponame" "$(calc_oid "feature")"
+
+  # someone else force pushes feature back to master, and the server GCs the
+  # object only the old feature had, but we haven't fetched since
+  git push -f origin master:feature
+  git update-ref refs/remotes/origin/feature "$feature"
+  delete_server_object "$reponame" "$(calc_oid "feature")"
+  refute_server_object "$reponame" "$(calc_oid "feature")"
+
+  # without verifying, the cached ref says the server has it already
+  echo "refs/heads/feature $feature refs/heads/feature $base" |
+    git -c lfs.skippushverify=true lfs pre-push origin "$GITSERVER/$reponame" 2>&1 |
+    tee push.log
+  refute_server_object "$reponame" "$(calc_oid "feature")"
+
+  git push origin feature 2>&1 | tee push.log
+  grep "(1 of 1 files)" push.log
+  assert_server_object "$reponame" "$(calc_oid "feature")"
+)
+end_test
+
 begin_test "pre-push delete branch"
 (
   set -e
@@ -515,4 +559,85 @@ begin_test "pre-push delete branch"
 
 
 )
-end_test
\ No newline at end of file
+end_test
+begin_test "pre-push with locks"
+(
+  set -e
+
+  reponame="$(basename "$0" ".sh")-locks"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  echo "a" > a.dat
+  echo "b" > b.dat
+  git add .gitattributes a.dat b.dat
+  git commit -m "add a.dat & b.dat"
+  git push origin master 2>&1 | tee push.log
+  grep "(2 of 2 files)" push.log
+
+  create_server_lock "$reponame" "a.dat" "other"
+  create_server_lock "$reponame" "b.dat"
+
+  # changing a file we've locked is fine
+  echo "b2" > b.dat
+  git add b.dat
+  git commit -m "change b.dat"
+  git push origin master 2>&1 | tee push.log
+  grep "(1 of 1 files)" push.log
+  [ "0" = "$(grep -c "locked by others" push.log)" ]
+
+  # changing a file someone else has locked isn't
+  echo "a2" > a.dat
+  git add a.dat
+  git commit -m "change a.dat"
+  set +e
+  git push origin master 2>&1 | tee push.log
+  res=${PIPESTATUS[0]}
+  set -e
+  if [ "0" -eq "$res" ]; then
+    echo "push should fail when changing a file locked by others"
+    exit 1
+  fi
+  grep "Unable to push 1 file(s) locked by others:" push.log
+  grep "\* a.dat - other" push.log
+  grep "Cannot update locked files." push.log
+  [ "$(git rev-parse master)" != "$(git rev-parse origin/master)" ]
+
+  # unless lock verification is only a warning
+  git config lfs.forcelockverify false
+  git push origin master 2>&1 | tee push.log
+  grep "warning: pushing 1 file(s) locked by others:" push.log
+  grep "\* a.dat - other" push.log
+  [ "$(git rev-parse master)" = "$(git rev-parse origin/master)" ]
+)
+end_test
+
+begin_test "pre-push with locks unsupported"
+(
+  set -e
+
+  reponame="locksunsupported"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  echo "a" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "(1 of 1 files)" push.log
+  grep "api: verifying locks" push.log
+  [ "0" = "$(grep -c "warning" push.log)" ]
+
+  # remembered for the endpoint, so it's not asked again
+  [ "false" = "$(git config "lfs.$GITSERVER/$reponame.git/info/lfs.locksverify")" ]
+
+  echo "a2" > a.dat
+  git add a.dat
+  git commit -m "change a.dat"
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "(1 of 1 files)" push.log
+  [ "0" = "$(grep -c "api: verifying locks" push.log)" ]
+)
+end_test
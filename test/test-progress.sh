@@ -0,0 +1,164 @@
+#!/usr/bin/env bash
+
+. "test/testlib.sh"
+
+# check_progress_lines asserts that the lines for direction in a
+# GIT_LFS_PROGRESS file are well formed, that bytes only go up for each file,
+# and that each file ends with all its bytes transferred. Lines for other
+# directions, eg from the clean filter run by git, are ignored.
+# $ check_progress_lines "download" 3 "path/to/progress.log"
+check_progress_lines() {
+  local direction="$1"
+  local numfiles="$2"
+  local logfile="$3"
+
+  awk -v direction="$direction" -v numfiles="$numfiles" '
+    $1 != direction { next }
+    {
+      split($2, files, "/"); split($3, bytes, "/")
+      if (files[2] != numfiles) { print "bad total files: " $0; exit 1 }
+      if (bytes[1] + 0 < last[$4] + 0) { print "bytes went backwards: " $0; exit 1 }
+      last[$4] = bytes[1]; total[$4] = bytes[2]
+    }
+    END {
+      n = 0
+      for (name in last) {
+        n++
+        if (last[name] != total[name]) { print "incomplete: " name; exit 1 }
+      }
+      if (n != numfiles) { print "expected " numfiles " files, got " n; exit 1 }
+    }' "$logfile"
+}
+
+begin_test "progress file for fetch and checkout"
+(
+  set -e
+
+  reponame="$(basename "$0" ".sh")"
+  setup_remote_repo "$reponame"
+
+  clone_repo "$reponame" repo
+
+  git lfs track "*.dat" 2>&1 | tee track.log
+  grep "Tracking \*.dat" track.log
+
+  printf "a" > a.dat
+  printf "bbbbbbbbbb" > b.dat
+  mkdir dir
+  printf "cccccccccccccccccccc" > "dir/c c.dat"
+  git add .gitattributes a.dat b.dat dir
+  git commit -m "add files"
+  git push origin master
+
+  cd ..
+  GIT_LFS_SKIP_SMUDGE=1 clone_repo "$reponame" clone
+
+  progressfile="$TRASHDIR/progress/not/yet/created/fetch.log"
+  GIT_LFS_PROGRESS="$progressfile" git lfs fetch 2>&1 | tee fetch.log
+  [ -f "$progressfile" ]
+  cat "$progressfile"
+  check_progress_lines "download" 3 "$progressfile"
+  grep "download [0-9]/3 20/20 dir/c c.dat" "$progressfile"
+
+  progressfile="$TRASHDIR/progress/checkout.log"
+  GIT_LFS_PROGRESS="$progressfile" git lfs checkout 2>&1 | tee checkout.log
+  cat "$progressfile"
+  check_progress_lines "checkout" 3 "$progressfile"
+  [ "bbbbbbbbbb" = "$(cat b.dat)" ]
+)
+end_test
+
+begin_test "progress to a fifo"
+(
+  set -e
+
+  reponame="progress-fifo"
+  setup_remote_repo "$reponame"
+
+  clone_repo "$reponame" repo-fifo
+
+  git lfs track "*.dat"
+  printf "a" > a.dat
+  printf "bbbbbbbbbb" > b.dat
+  git add .gitattributes a.dat b.dat
+  git commit -m "add files"
+  git push origin master
+
+  cd ..
+  GIT_LFS_SKIP_SMUDGE=1 clone_repo "$reponame" clone-fifo
+
+  fifo="$TRASHDIR/progress.fifo"
+  mkfifo "$fifo"
+  # hold the fifo open so the reader doesn't stop at the first close
+  exec 3<>"$fifo"
+  cat "$fifo" > fifo.log 3>&- &
+  GIT_LFS_PROGRESS="$fifo" git lfs fetch 2>&1 | tee fetch.log
+  [ "${PIPESTATUS[0]}" = "0" ]
+  exec 3>&-
+  wait
+
+  cat fifo.log
+  [ "0" = "$(grep -c "Error writing" fetch.log)" ]
+  check_progress_lines "download" 2 fifo.log
+  grep "download [0-9]/2 0/10 b.dat" fifo.log
+)
+end_test
+
+begin_test "progress file errors don't fail commands"
+(
+  set -e
+
+  reponame="progress-errors"
+  setup_remote_repo "$reponame"
+
+  clone_repo "$reponame" repo-errors
+
+  git lfs track "*.dat"
+  printf "a" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+  git push origin master
+
+  cd ..
+  GIT_LFS_SKIP_SMUDGE=1 clone_repo "$reponame" clone-errors
+
+  # a dir can't be opened for writing
+  mkdir -p "$TRASHDIR/progress-dir"
+  GIT_LFS_PROGRESS="$TRASHDIR/progress-dir" git lfs pull 2>&1 | tee pull.log
+  [ "${PIPESTATUS[0]}" = "0" ]
+  grep "progress" pull.log
+  [ "a" = "$(cat a.dat)" ]
+)
+end_test
+
+begin_test "progress output when not a terminal"
+(
+  set -e
+
+  reponame="progress-pipe"
+  setup_remote_repo "$reponame"
+
+  clone_repo "$reponame" repo-pipe
+
+  git lfs track "*.dat"
+  for i in $(seq 1 20); do
+    printf "content $i" > "file$i.dat"
+  done
+  git add .gitattributes *.dat
+  git commit -m "add files"
+  git push origin master 2>&1 | tee push.log
+
+  # no carriage returns, just a line or two and the summary
+  [ "0" = "$(grep -c $'\r' push.log)" ]
+  [ "$(grep -c "Git LFS:" push.log)" -le 3 ]
+  grep "Git LFS: (20 of 20 files)" push.log
+
+  cd ..
+  GIT_LFS_SKIP_SMUDGE=1 clone_repo "$reponame" clone-pipe
+
+  progressfile="$TRASHDIR/progress/no-progress.log"
+  GIT_LFS_PROGRESS="$progressfile" git lfs fetch --no-progress 2>&1 | tee fetch.log
+  [ "0" = "$(grep -c "Git LFS:" fetch.log)" ]
+  check_progress_lines "download" 20 "$progressfile"
+)
+end_test
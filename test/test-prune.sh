@@ -72,14 +72,22 @@ begin_test "prune unreferenced and old"
   git lfs prune --dry-run --verbose 2>&1 | tee prune.log
 
   grep "5 local objects, 3 retained" prune.log
-  grep "2 files would be pruned" prune.log
+  # the deleted branch was never pushed, so it's unknown whether its objects
+  # are on the remote
+  grep "Keeping 1 files not known to be pushed" prune.log
+  grep "1 files would be pruned" prune.log
   grep "$oid_oldandpushed" prune.log
   grep "$oid_unreferenced" prune.log
 
+  git lfs prune --dry-run --verbose --force 2>&1 | tee prune.log
+  grep "2 files would be pruned (52 B)" prune.log
+
   assert_local_object "$oid_oldandpushed" "${#content_oldandpushed}"
   assert_local_object "$oid_unreferenced" "${#content_unreferenced}"
   git lfs prune
   refute_local_object "$oid_oldandpushed" "${#content_oldandpushed}"
+  assert_local_object "$oid_unreferenced" "${#content_unreferenced}"
+  git lfs prune --force
   refute_local_object "$oid_unreferenced" "${#content_unreferenced}"
   assert_local_object "$oid_retain1" "${#content_retain1}"
   assert_local_object "$oid_retain2" "${#content_retain2}"
@@ -537,4 +545,119 @@ begin_test "prune verify"
   refute_local_object "$oid_commit3"
 
 )
-end_test
\ No newline at end of file
+end_test
+begin_test "prune damaged objects"
+(
+  set -e
+
+  reponame="prune_damaged"
+  setup_remote_repo "remote_$reponame"
+
+  clone_repo "remote_$reponame" "clone_$reponame"
+
+  git lfs track "*.dat" 2>&1 | tee track.log
+  grep "Tracking \*.dat" track.log
+
+  content_truncated="Truncated by an interrupted transfer"
+  content_oversized="Somehow bigger than it should be"
+  content_ok="Intact content"
+  oid_truncated=$(calc_oid "$content_truncated")
+  oid_oversized=$(calc_oid "$content_oversized")
+  oid_ok=$(calc_oid "$content_ok")
+
+  echo "[
+  {
+    \"Files\":[
+      {\"Filename\":\"truncated.dat\",\"Size\":${#content_truncated}, \"Data\":\"$content_truncated\"},
+      {\"Filename\":\"oversized.dat\",\"Size\":${#content_oversized}, \"Data\":\"$content_oversized\"},
+      {\"Filename\":\"ok.dat\",\"Size\":${#content_ok}, \"Data\":\"$content_ok\"}]
+  }
+  ]" | lfstest-testutils addcommits
+
+  git push origin master
+
+  truncated_path=".git/lfs/objects/${oid_truncated:0:2}/${oid_truncated:2:2}/$oid_truncated"
+  oversized_path=".git/lfs/objects/${oid_oversized:0:2}/${oid_oversized:2:2}/$oid_oversized"
+  printf "Truncated" > "$truncated_path"
+  printf "extra" >> "$oversized_path"
+
+  git lfs prune --dry-run 2>&1 | tee prune.log
+  grep "2 damaged files would be removed" prune.log
+  [ -f "$truncated_path" ]
+  [ -f "$oversized_path" ]
+
+  git lfs prune --verbose 2>&1 | tee prune.log
+  grep "Removed 2 damaged files" prune.log
+  grep "$oid_truncated is incomplete" prune.log
+  grep "$oid_oversized is" prune.log
+
+  refute_local_object "$oid_truncated"
+  refute_local_object "$oid_oversized"
+  assert_local_object "$oid_ok" "${#content_ok}"
+  [ -f ".git/lfs/bad/$oid_oversized" ]
+)
+end_test
+
+begin_test "prune keep recently used"
+(
+  set -e
+
+  reponame="prune_recently_used"
+  setup_remote_repo "remote_$reponame"
+
+  clone_repo "remote_$reponame" "clone_$reponame"
+
+  git lfs track "*.dat" 2>&1 | tee track.log
+  grep "Tracking \*.dat" track.log
+
+  content_used="Old but checked out again"
+  content_unused="Old and never looked at again"
+  content_current="Current content"
+  oid_used=$(calc_oid "$content_used")
+  oid_unused=$(calc_oid "$content_unused")
+  oid_current=$(calc_oid "$content_current")
+
+  echo "[
+  {
+    \"CommitDate\":\"$(get_date -40d)\",
+    \"Files\":[
+      {\"Filename\":\"used.dat\",\"Size\":${#content_used}, \"Data\":\"$content_used\"},
+      {\"Filename\":\"unused.dat\",\"Size\":${#content_unused}, \"Data\":\"$content_unused\"}]
+  },
+  {
+    \"CommitDate\":\"$(get_date -30d)\",
+    \"Files\":[
+      {\"Filename\":\"used.dat\",\"Size\":${#content_current}, \"Data\":\"$content_current\"},
+      {\"Filename\":\"unused.dat\",\"Size\":${#content_current}, \"Data\":\"$content_current\"}]
+  }
+  ]" | lfstest-testutils addcommits
+
+  git push origin master
+
+  git config lfs.fetchrecentrefsdays 0
+  git config lfs.fetchrecentcommitsdays 0
+
+  # checkouts aren't logged unless they're used
+  [ ! -e .git/lfs/access.log ]
+
+  # look at the old version of used.dat again
+  git config lfs.prunerecentlyused 7
+  git checkout HEAD^ -- used.dat
+  [ "$content_used" = "$(cat used.dat)" ]
+  git checkout HEAD -- used.dat
+  grep "$oid_used" .git/lfs/access.log
+
+  git lfs prune --verbose 2>&1 | tee prune.log
+  grep "Pruning 1 files" prune.log
+  refute_local_object "$oid_unused"
+  assert_local_object "$oid_used" "${#content_used}"
+  assert_local_object "$oid_current" "${#content_current}"
+
+  # missing access data is treated as old
+  rm .git/lfs/access.log
+  git lfs prune --verbose 2>&1 | tee prune.log
+  grep "Pruning 1 files" prune.log
+  refute_local_object "$oid_used"
+  assert_local_object "$oid_current" "${#content_current}"
+)
+end_test
@@ -126,3 +126,98 @@ begin_test "pull: outside git repository"
   grep "Not in a git repository" pull.log
 )
 end_test
+
+begin_test "pull --json"
+(
+  set -e
+
+  reponame="pull-json"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "a" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+  git push origin master
+
+  cd ..
+  GIT_LFS_SKIP_SMUDGE=1 clone_repo "$reponame" "$reponame-clone"
+
+  git lfs pull --json > pull.json 2> pull.log
+  cat pull.json
+  [ "1" = "$(wc -l < pull.json | tr -d ' ')" ]
+  grep "{\"transferred\":\[{\"oid\":\"$(calc_oid "a")\",\"size\":1,\"path\":\"a.dat\"," pull.json
+  [ "a" = "$(cat a.dat)" ]
+)
+end_test
+
+begin_test "pull with include and exclude flags"
+(
+  set -e
+
+  reponame="pull-include-exclude"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat" "*.psd"
+  mkdir -p textures/ps4 textures/xbox
+  printf "ps4" > textures/ps4/a.dat
+  printf "ps4 source" > textures/ps4/b.psd
+  printf "xbox" > textures/xbox/c.dat
+  git add .gitattributes textures
+  git commit -m "add textures"
+  git push origin master
+
+  cd ..
+  GIT_LFS_SKIP_SMUDGE=1 clone_repo "$reponame" "$reponame-clone"
+
+  # the flags override the config, and excluded objects aren't requested
+  git config lfs.fetchinclude "textures/xbox"
+  GIT_TRACE=1 git lfs pull -I "textures/ps4/**" -X "textures/ps4/*.psd" 2>&1 | tee pull.log
+  [ "${PIPESTATUS[0]}" = "0" ]
+  grep "sending batch of size 1" pull.log
+  [ "0" = "$(grep -c "Skipped checkout" pull.log)" ]
+  [ "ps4" = "$(cat textures/ps4/a.dat)" ]
+  assert_local_object "$(calc_oid "ps4")" 3
+  refute_local_object "$(calc_oid "ps4 source")"
+  refute_local_object "$(calc_oid "xbox")"
+  [ "$(pointer "$(calc_oid "xbox")" 4)" = "$(cat textures/xbox/c.dat)" ]
+)
+end_test
+
+begin_test "pull: checks out local and downloaded files from one scan"
+(
+  set -e
+
+  reponame="pull-one-scan"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "local" > local.dat
+  printf "remote" > remote.dat
+  cp remote.dat copy.dat
+  printf "excluded" > excluded.dat
+  git add .gitattributes *.dat
+  git commit -m "add files"
+  git push origin master
+
+  cd ..
+  GIT_LFS_SKIP_SMUDGE=1 clone_repo "$reponame" "$reponame-clone"
+  git lfs fetch -I "local.dat"
+  assert_local_object "$(calc_oid "local")" 5
+
+  GIT_TRACE="$(pwd)/trace.log" GIT_TRACE_PERFORMANCE=1 git lfs pull -X "excluded.dat" 2>&1 | tee pull.log
+  [ "${PIPESTATUS[0]}" = "0" ]
+  [ "1" = "$(grep -c "performance scan" trace.log)" ]
+  [ "0" = "$(grep -c "Skipped checkout" pull.log)" ]
+  grep "Checked out 3 files, downloaded 1 objects (6 B), 1 files excluded" pull.log
+
+  [ "local" = "$(cat local.dat)" ]
+  [ "remote" = "$(cat remote.dat)" ]
+  [ "remote" = "$(cat copy.dat)" ]
+  [ "$(pointer "$(calc_oid "excluded")" 8)" = "$(cat excluded.dat)" ]
+  [ -z "$(git status --porcelain -uno)" ]
+)
+end_test
@@ -108,3 +108,39 @@ begin_test "push: upload file with api 500"
   push_fail_test "status-batch-500"
 )
 end_test
+
+begin_test "push: api errors name each object and the rest are pushed"
+(
+  set -e
+
+  reponame="$(basename "$0" ".sh")-mixed"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "good" > good.dat
+  printf "status-batch-422" > huge.dat
+  printf "status-batch-500" > broken.dat
+  git add .gitattributes good.dat huge.dat broken.dat
+  git commit -m "mixed"
+
+  set +e
+  git push origin master 2>&1 | tee push.log
+  res="${PIPESTATUS[0]}"
+  set -e
+
+  if [ "$res" = "0" ]; then
+    echo "push successful?"
+    exit 1
+  fi
+
+  huge_oid="$(calc_oid "status-batch-422")"
+  broken_oid="$(calc_oid "status-batch-500")"
+  grep "huge.dat (oid ${huge_oid:0:6}…): welp (422)" push.log
+  grep "broken.dat (oid ${broken_oid:0:6}…): welp (500)" push.log
+  grep "2 of 3 objects failed to upload" push.log
+
+  assert_server_object "$reponame" "$(calc_oid "good")"
+  refute_server_object "$reponame" "$huge_oid"
+)
+end_test
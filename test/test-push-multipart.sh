@@ -0,0 +1,37 @@
+#!/usr/bin/env bash
+
+. "test/testlib.sh"
+
+begin_test "push: multipart upload"
+(
+  set -e
+
+  # The server asks for multipart uploads to "multipart*" repos, in 1KB parts,
+  # and fails the first upload of the second part of each object.
+  reponame="multipart-push"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" repo
+
+  git lfs track "*.dat"
+  head -c 3000 /dev/urandom > big.dat
+  printf "small" > small.dat
+  git add .gitattributes big.dat small.dat
+  git commit -m "add objects"
+
+  big_oid="$(shasum -a 256 big.dat | cut -f 1 -d " ")"
+  small_oid="$(calc_oid "small")"
+
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "multipart: retrying part 2 " push.log
+  [ "1" = "$(grep -c "multipart: retrying" push.log)" ]
+  grep "(2 of 2 files)" push.log
+
+  assert_server_object "$reponame" "$big_oid"
+  assert_server_object "$reponame" "$small_oid"
+
+  cd ..
+  clone_repo "$reponame" clone
+  cmp big.dat ../repo/big.dat
+  [ "small" = "$(cat small.dat)" ]
+)
+end_test
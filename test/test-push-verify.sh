@@ -0,0 +1,56 @@
+#!/usr/bin/env bash
+
+. "test/testlib.sh"
+
+begin_test "push: verify action"
+(
+  set -e
+
+  # The server adds a verify action to uploads to "verify*" repos, and only
+  # keeps objects once they're verified.
+  reponame="verify-push"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  contents="verified"
+  contents_oid="$(calc_oid "$contents")"
+  printf "$contents" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "HTTP: POST $GITSERVER/verify$" push.log
+  grep "(1 of 1 files)" push.log
+
+  assert_server_object "$reponame" "$contents_oid"
+
+  cd ..
+  clone_repo "$reponame" clone
+  [ "$contents" = "$(cat a.dat)" ]
+)
+end_test
+
+begin_test "push: failed verify is retried"
+(
+  set -e
+
+  # The first verify of each object in "verify-flaky*" repos fails with a 500.
+  reponame="verify-flaky-push"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  contents="verified after a retry"
+  contents_oid="$(calc_oid "$contents")"
+  printf "$contents" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+  grep "retrying object $contents_oid" push.log
+  grep "(1 of 1 files)" push.log
+
+  assert_server_object "$reponame" "$contents_oid"
+)
+end_test
@@ -16,8 +16,8 @@ begin_test "push"
   git commit -m "add a.dat"
 
   git lfs push --dry-run origin master 2>&1 | tee push.log
-  grep "push 4c48d2a6991c9895bcddcf027e1e4907280bcf21975492b1afbade396d6a3340 => a.dat" push.log
-  [ $(grep -c "push" push.log) -eq 1 ]
+  grep "would upload 4c48d2a699 (7 B) a.dat" push.log
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 1 ]
 
   git lfs push origin master 2>&1 | tee push.log
   grep "(1 of 1 files)" push.log
@@ -28,16 +28,16 @@ begin_test "push"
   git commit -m "add b.dat"
 
   git lfs push --dry-run origin push-b 2>&1 | tee push.log
-  grep "push 4c48d2a6991c9895bcddcf027e1e4907280bcf21975492b1afbade396d6a3340 => a.dat" push.log
-  grep "push 82be50ad35070a4ef3467a0a650c52d5b637035e7ad02c36652e59d01ba282b7 => b.dat" push.log
-  [ $(grep -c "push" < push.log) -eq 2 ]
+  grep "already on server 4c48d2a699 (7 B) a.dat" push.log
+  grep "would upload 82be50ad35 (7 B) b.dat" push.log
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 2 ]
 
   # simulate remote ref
   mkdir -p .git/refs/remotes/origin
   git rev-parse HEAD > .git/refs/remotes/origin/HEAD
 
   git lfs push --dry-run origin push-b 2>&1 | tee push.log
-  [ $(grep -c "push" push.log) -eq 0 ]
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 0 ]
 
   rm -rf .git/refs/remotes
 
@@ -123,13 +123,13 @@ begin_test "push --all (no ref args)"
   push_all_setup "everything"
 
   git lfs push --dry-run --all origin 2>&1 | tee push.log
-  grep "push $oid1 => file1.dat" push.log
-  grep "push $oid2 => file1.dat" push.log
-  grep "push $oid3 => file1.dat" push.log
-  grep "push $oid4 => file1.dat" push.log
-  grep "push $oid5 => file1.dat" push.log
-  grep "push $extraoid => file2.dat" push.log
-  [ $(grep -c "push" < push.log) -eq 6 ]
+  grep "would upload ${oid1:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid2:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid3:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid4:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid5:0:10} (.*) file1.dat" push.log
+  grep "would upload ${extraoid:0:10} (.*) file2.dat" push.log
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 6 ]
 
   git push --all origin 2>&1 | tee push.log
   grep "5 files" push.log # should be 6?
@@ -151,15 +151,15 @@ begin_test "push --all (no ref args)"
   refute_server_object "$reponame-$suffix-2" "$extraoid"
   rm ".git/lfs/objects/${oid1:0:2}/${oid1:2:2}/$oid1"
 
-  # dry run doesn't change
+  # the second remote has oid1 already
   git lfs push --dry-run --all origin 2>&1 | tee push.log
-  grep "push $oid1 => file1.dat" push.log
-  grep "push $oid2 => file1.dat" push.log
-  grep "push $oid3 => file1.dat" push.log
-  grep "push $oid4 => file1.dat" push.log
-  grep "push $oid5 => file1.dat" push.log
-  grep "push $extraoid => file2.dat" push.log
-  [ $(grep -c "push" push.log) -eq 6 ]
+  grep "already on server ${oid1:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid2:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid3:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid4:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid5:0:10} (.*) file1.dat" push.log
+  grep "would upload ${extraoid:0:10} (.*) file2.dat" push.log
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 6 ]
 
   git push --all origin 2>&1 | tee push.log
   grep "5 files, 1 skipped" push.log # should be 5?
@@ -178,10 +178,10 @@ begin_test "push --all (1 ref arg)"
   push_all_setup "ref"
 
   git lfs push --dry-run --all origin branch 2>&1 | tee push.log
-  grep "push $oid1 => file1.dat" push.log
-  grep "push $oid2 => file1.dat" push.log
-  grep "push $oid3 => file1.dat" push.log
-  [ $(grep -c "push" < push.log) -eq 3 ]
+  grep "would upload ${oid1:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid2:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid3:0:10} (.*) file1.dat" push.log
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 3 ]
 
   git lfs push --all origin branch 2>&1 | tee push.log
   grep "3 files" push.log
@@ -203,12 +203,12 @@ begin_test "push --all (1 ref arg)"
   refute_server_object "$reponame-$suffix-2" "$extraoid"
   rm ".git/lfs/objects/${oid1:0:2}/${oid1:2:2}/$oid1"
 
-  # dry run doesn't change
+  # the second remote has oid1 already
   git lfs push --dry-run --all origin branch 2>&1 | tee push.log
-  grep "push $oid1 => file1.dat" push.log
-  grep "push $oid2 => file1.dat" push.log
-  grep "push $oid3 => file1.dat" push.log
-  [ $(grep -c "push" push.log) -eq 3 ]
+  grep "already on server ${oid1:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid2:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid3:0:10} (.*) file1.dat" push.log
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 3 ]
 
   git push --all origin branch 2>&1 | tee push.log
   grep "5 files, 1 skipped" push.log # should be 5?
@@ -227,11 +227,11 @@ begin_test "push --all (multiple ref args)"
   push_all_setup "multiple-refs"
 
   git lfs push --dry-run --all origin branch tag 2>&1 | tee push.log
-  grep "push $oid1 => file1.dat" push.log
-  grep "push $oid2 => file1.dat" push.log
-  grep "push $oid3 => file1.dat" push.log
-  grep "push $oid4 => file1.dat" push.log
-  [ $(grep -c "push" push.log) -eq 4 ]
+  grep "would upload ${oid1:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid2:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid3:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid4:0:10} (.*) file1.dat" push.log
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 4 ]
 
   git lfs push --all origin branch tag 2>&1 | tee push.log
   grep "4 files" push.log
@@ -253,13 +253,13 @@ begin_test "push --all (multiple ref args)"
   refute_server_object "$reponame-$suffix-2" "$extraoid"
   rm ".git/lfs/objects/${oid1:0:2}/${oid1:2:2}/$oid1"
 
-  # dry run doesn't change
+  # the second remote has oid1 already
   git lfs push --dry-run --all origin branch tag 2>&1 | tee push.log
-  grep "push $oid1 => file1.dat" push.log
-  grep "push $oid2 => file1.dat" push.log
-  grep "push $oid3 => file1.dat" push.log
-  grep "push $oid4 => file1.dat" push.log
-  [ $(grep -c "push" push.log) -eq 3 ]
+  grep "already on server ${oid1:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid2:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid3:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid4:0:10} (.*) file1.dat" push.log
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 4 ]
 
   git push --all origin branch tag 2>&1 | tee push.log
   grep "5 files, 1 skipped" push.log # should be 5?
@@ -278,12 +278,12 @@ begin_test "push --all (ref with deleted files)"
   push_all_setup "ref-with-deleted"
 
   git lfs push --dry-run --all origin master 2>&1 | tee push.log
-  grep "push $oid1 => file1.dat" push.log
-  grep "push $oid2 => file1.dat" push.log
-  grep "push $oid4 => file1.dat" push.log
-  grep "push $oid5 => file1.dat" push.log
-  grep "push $extraoid => file2.dat" push.log
-  [ $(grep -c "push" push.log) -eq 5 ]
+  grep "would upload ${oid1:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid2:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid4:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid5:0:10} (.*) file1.dat" push.log
+  grep "would upload ${extraoid:0:10} (.*) file2.dat" push.log
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 5 ]
 
   git lfs push --all origin master 2>&1 | tee push.log
   grep "5 files" push.log
@@ -305,14 +305,14 @@ begin_test "push --all (ref with deleted files)"
   refute_server_object "$reponame-$suffix-2" "$extraoid"
   rm ".git/lfs/objects/${oid1:0:2}/${oid1:2:2}/$oid1"
 
-  # dry run doesn't change
+  # the second remote has oid1 already
   git lfs push --dry-run --all origin master 2>&1 | tee push.log
-  grep "push $oid1 => file1.dat" push.log
-  grep "push $oid2 => file1.dat" push.log
-  grep "push $oid4 => file1.dat" push.log
-  grep "push $oid5 => file1.dat" push.log
-  grep "push $extraoid => file2.dat" push.log
-  [ $(grep -c "push" push.log) -eq 5 ]
+  grep "already on server ${oid1:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid2:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid4:0:10} (.*) file1.dat" push.log
+  grep "would upload ${oid5:0:10} (.*) file1.dat" push.log
+  grep "would upload ${extraoid:0:10} (.*) file2.dat" push.log
+  [ $(grep -c "^would upload\|^already on server" push.log) -eq 5 ]
 
   git push --all origin master 2>&1 | tee push.log
   grep "5 files, 1 skipped" push.log # should be 5?
@@ -354,6 +354,90 @@ begin_test "push object id(s)"
 )
 end_test
 
+begin_test "push object id(s) missing locally"
+(
+  set -e
+
+  reponame="$(basename "$0" ".sh")-object-id-missing"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "present" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  present="$(calc_oid "present")"
+  missing="$(calc_oid "missing")"
+
+  set +e
+  git lfs push --object-id origin "$present" "$missing" "abc" 2>&1 | tee push.log
+  res="${PIPESTATUS[0]}"
+  set -e
+  [ "$res" = "1" ]
+  grep "Invalid object IDs:" push.log
+  grep "  abc" push.log
+  grep "Objects not in local storage:" push.log
+  grep "  $missing" push.log
+  # nothing is pushed if any are missing
+  refute_server_object "$reponame" "$present"
+
+  git lfs push --object-id origin "$present" 2>&1 | tee push.log
+  grep "(1 of 1 files)" push.log
+  assert_server_object "$reponame" "$present"
+)
+end_test
+
+begin_test "push --dry-run"
+(
+  set -e
+
+  reponame="$(basename "$0" ".sh")-dry-run"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "pushed" > pushed.dat
+  git add .gitattributes pushed.dat
+  git commit -m "add pushed.dat"
+  git lfs push --object-id origin "$(calc_oid "pushed")"
+
+  printf "new file" > new.dat
+  git add new.dat
+  git commit -m "add new.dat"
+
+  git lfs push --dry-run origin master 2>&1 | tee push.log
+  [ "${PIPESTATUS[0]}" = "0" ]
+  grep "would upload $(calc_oid "new file" | cut -c 1-10) (8 B) new.dat" push.log
+  grep "already on server $(calc_oid "pushed" | cut -c 1-10) (6 B) pushed.dat" push.log
+  grep "Would upload 1 objects (8 B), 1 already on server (6 B)" push.log
+  refute_server_object "$reponame" "$(calc_oid "new file")"
+
+  # without asking the server, everything is listed to upload
+  GIT_TRACE=1 git lfs push --dry-run=local origin master 2>&1 | tee push.log
+  [ "${PIPESTATUS[0]}" = "0" ]
+  grep "would upload $(calc_oid "pushed" | cut -c 1-10) (6 B) pushed.dat" push.log
+  grep "Would upload 2 objects (14 B), without checking the server" push.log
+  [ "0" = "$(grep -c "HTTP: POST" push.log)" ]
+
+  set +e
+  git lfs push --dry-run=nowhere origin master 2>&1 | tee push.log
+  res="${PIPESTATUS[0]}"
+  set -e
+  [ "$res" = "2" ]
+  grep "Invalid --dry-run mode \"nowhere\"" push.log
+
+  # the values of the old bool flag
+  git lfs push --dry-run=true origin master 2>&1 | tee push.log
+  grep "Would upload 1 objects (8 B), 1 already on server (6 B)" push.log
+  refute_server_object "$reponame" "$(calc_oid "new file")"
+
+  git lfs push --dry-run=false origin master 2>&1 | tee push.log
+  grep "(1 of 2 files, 1 skipped)" push.log
+  assert_server_object "$reponame" "$(calc_oid "new file")"
+)
+end_test
+
 begin_test "push modified files"
 (
   set -e
@@ -484,3 +568,65 @@ begin_test "push ambiguous branch name"
 )
 end_test
 
+
+begin_test "push with a bandwidth limit"
+(
+  set -e
+
+  reponame="push-bandwidth-limit"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  contents="$(head -c 60000 /dev/zero | tr '\0' 'x')"
+  printf "$contents" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  git config lfs.transfer.maxuploadbandwidth 100k
+  GIT_TRACE=1 git push origin master 2>&1 | tee push.log
+
+  grep "limiting upload bandwidth to 97.7 KiB/s" push.log
+  [ "0" = "$(grep -c "limiting transfer bandwidth" push.log)" ]
+  assert_server_object "$reponame" "$(calc_oid "$contents")"
+)
+end_test
+
+begin_test "push --json"
+(
+  set -e
+
+  reponame="push-json"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  printf "good" > good.dat
+  printf "status-storage-403" > bad.dat
+  git add .gitattributes good.dat bad.dat
+  git commit -m "add good.dat and bad.dat"
+
+  good_oid="$(calc_oid "good")"
+  bad_oid="$(calc_oid "status-storage-403")"
+
+  set +e
+  git lfs push --json origin master > push.json 2> push.log
+  res=$?
+  set -e
+  cat push.json
+  cat push.log
+  [ "$res" = "3" ]
+  [ "1" = "$(wc -l < push.json | tr -d ' ')" ]
+  grep "\"transferred\":\[{\"oid\":\"$good_oid\",\"size\":4,\"path\":\"good.dat\",\"duration\":[0-9.e-]*,\"retries\":0}\]" push.json
+  grep "\"skipped\":\[\]" push.json
+  grep "\"errors\":\[{\"oid\":\"$bad_oid\",\"path\":\"bad.dat\",\"error\":" push.json
+  grep "1 of 2 objects failed to upload" push.log
+
+  # the server already has good.dat now
+  git lfs push --json --object-id origin "$good_oid" > push.json 2> push.log
+  cat push.json
+  grep "\"transferred\":\[\]" push.json
+  grep "\"skipped\":\[{\"oid\":\"$good_oid\",\"size\":4,\"reason\":\"already present\"}\]" push.json
+  grep "\"errors\":\[\]" push.json
+)
+end_test
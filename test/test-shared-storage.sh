@@ -0,0 +1,86 @@
+#!/usr/bin/env bash
+
+. "test/testlib.sh"
+
+begin_test "shared storage: clones share downloaded objects"
+(
+  set -e
+
+  reponame="shared-storage"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  contents="shared"
+  contents_oid="$(calc_oid "$contents")"
+  printf "$contents" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+  git push origin master
+
+  shared="$TRASHDIR/shared-lfs"
+  cd "$TRASHDIR"
+  git clone --config "lfs.storage=$shared" "$GITSERVER/$reponame" clone1
+  [ "$contents" = "$(cat clone1/a.dat)" ]
+  [ -f "$shared/objects/${contents_oid:0:2}/${contents_oid:2:2}/$contents_oid" ]
+  [ ! -d clone1/.git/lfs/objects ]
+
+  # the second clone finds the object already downloaded
+  delete_server_object "$reponame" "$contents_oid"
+  git clone --config "lfs.storage=$shared" "$GITSERVER/$reponame" clone2
+  [ "$contents" = "$(cat clone2/a.dat)" ]
+
+  cd clone2
+  git lfs env | tee env.log
+  grep "LocalStorageDir=$(native_path_escaped "$shared") (shared)$" env.log
+  grep "LocalMediaDir=$(native_path_escaped "$shared/objects")$" env.log
+  grep "TempDir=$(native_path_escaped "$shared/tmp")$" env.log
+)
+end_test
+
+begin_test "shared storage: prune needs --force-shared"
+(
+  set -e
+
+  reponame="shared-storage-prune"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+  git config lfs.storage "$TRASHDIR/$reponame-lfs"
+
+  git lfs track "*.dat"
+  printf "old" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+  printf "new" > a.dat
+  git add a.dat
+  git commit -m "update a.dat"
+  git push origin master
+
+  # no other repository has registered, but it may still use the storage
+  git lfs prune 2>&1 | tee prune.log
+  if [ "0" -eq "${PIPESTATUS[0]}" ]; then
+    echo >&2 "expected prune to fail"
+    exit 1
+  fi
+  grep "LFS storage .* is shared, and may be used by other repositories" prune.log
+  grep "use --force-shared to prune anyway" prune.log
+  assert_local_object "$(calc_oid "old")" 3
+
+  git lfs fetch --prune 2>&1 | tee fetch.log
+  grep "Not pruning" fetch.log
+  assert_local_object "$(calc_oid "old")" 3
+
+  git lfs prune --force-shared
+  refute_local_object "$(calc_oid "old")"
+  assert_local_object "$(calc_oid "new")" 3
+
+  # unless it isn't shared
+  printf "newer" > a.dat
+  git add a.dat
+  git commit -m "update a.dat again"
+  git push origin master
+  git config lfs.sharedstorage false
+  git lfs prune
+  refute_local_object "$(calc_oid "new")"
+)
+end_test
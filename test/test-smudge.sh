@@ -64,6 +64,11 @@ begin_test "smudge with invalid pointer"
   [ "wat" = "$(echo "wat" | git lfs smudge)" ]
   [ "not a git-lfs file" = "$(echo "not a git-lfs file" | git lfs smudge)" ]
   [ "version " = "$(echo "version " | git lfs smudge)" ]
+
+  # larger than any pointer, passed through untouched
+  head -c 3000000 /dev/urandom > big.bin
+  git lfs smudge < big.bin > big.out
+  cmp big.bin big.out
 )
 end_test
 
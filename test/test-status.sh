@@ -32,7 +32,8 @@ Git LFS objects to be committed:
 
 Git LFS objects not staged for commit:
 
-	file1.dat"
+	file1.dat
+	file3.dat"
 
   [ "$expected" = "$(git lfs status)" ]
 )
@@ -59,14 +60,108 @@ begin_test "status --porcelain"
 
   echo "file3 other data" > file3.dat
 
-  expected=" M file1.dat 10
-A  file2.dat 11
-A  file3.dat 11"
+  expected="staged	A	-	11	file2.dat
+staged	A	-	11	file3.dat
+unstaged	M	10	11	file1.dat
+unstaged	M	11	17	file3.dat"
 
   [ "$expected" = "$(git lfs status --porcelain)" ]
 )
 end_test
 
+begin_test "status: changed pointers, deletes and renames"
+(
+  set -e
+
+  mkdir repo-3
+  cd repo-3
+  git init
+  git lfs track "*.dat"
+  printf "small" > a.dat
+  printf "renamed content" > b.dat
+  printf "deleted" > c.dat
+  printf "gone from the working tree" > d.dat
+  git add .
+  git commit -m "initial"
+
+  printf "bigger content" > a.dat
+  git add a.dat
+  git mv b.dat moved.dat
+  git rm -q c.dat
+  rm d.dat
+
+  expected="On branch master
+
+Git LFS objects to be committed:
+
+	a.dat (5 B -> 14 B)
+	c.dat (deleted)
+	b.dat -> moved.dat (15 B)
+
+Git LFS objects not staged for commit:
+
+	d.dat (deleted)"
+
+  [ "$expected" = "$(git lfs status)" ]
+
+  expected="staged	M	5	14	a.dat
+staged	D	7	-	c.dat
+staged	R	15	15	moved.dat	b.dat
+unstaged	D	26	-	d.dat"
+
+  [ "$expected" = "$(git lfs status --porcelain)" ]
+
+  # touched but unchanged files aren't listed
+  touch a.dat moved.dat
+  [ "$expected" = "$(git lfs status --porcelain)" ]
+)
+end_test
+
+begin_test "status: unpushed objects, from a subdirectory"
+(
+  set -e
+
+  reponame="status-unpushed"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  mkdir -p dir/sub
+  printf "pushed" > dir/pushed.dat
+  git add .gitattributes dir
+  git commit -m "pushed"
+  git push origin master
+
+  printf "not pushed" > dir/sub/unpushed.dat
+  git add dir
+  git commit -m "not pushed"
+  printf "staged" > top.dat
+  git add top.dat
+  printf "changed" > dir/pushed.dat
+
+  cd dir
+  expected="On branch master
+Git LFS objects to be pushed to origin:
+
+	sub/unpushed.dat (10 B)
+
+Git LFS objects to be committed:
+
+	../top.dat (6 B)
+
+Git LFS objects not staged for commit:
+
+	pushed.dat"
+
+  [ "$expected" = "$(git lfs status)" ]
+
+  expected="unpushed	-	-	10	sub/unpushed.dat
+staged	A	-	6	../top.dat
+unstaged	M	6	7	pushed.dat"
+
+  [ "$expected" = "$(git lfs status --porcelain)" ]
+)
+end_test
 
 begin_test "status: outside git repository"
 (
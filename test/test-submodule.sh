@@ -46,6 +46,7 @@ begin_test "submodule env"
   grep "LocalGitDir=$(native_path_escaped "$TRASHDIR/repo/.git$")" env.log
   grep "LocalGitStorageDir=$(native_path_escaped "$TRASHDIR/repo/.git$")" env.log
   grep "LocalMediaDir=$(native_path_escaped "$TRASHDIR/repo/.git/lfs/objects$")" env.log
+  grep "LocalStorageDir=$(native_path_escaped "$TRASHDIR/repo/.git/lfs$")" env.log
   grep "TempDir=$(native_path_escaped "$TRASHDIR/repo/.git/lfs/tmp$")" env.log
 
   cd .git
@@ -58,6 +59,7 @@ begin_test "submodule env"
   grep "LocalGitDir=$(native_path_escaped "$TRASHDIR/repo/.git$")" env.log
   grep "LocalGitStorageDir=$(native_path_escaped "$TRASHDIR/repo/.git$")" env.log
   grep "LocalMediaDir=$(native_path_escaped "$TRASHDIR/repo/.git/lfs/objects$")" env.log
+  grep "LocalStorageDir=$(native_path_escaped "$TRASHDIR/repo/.git/lfs$")" env.log
   grep "TempDir=$(native_path_escaped "$TRASHDIR/repo/.git/lfs/tmp$")" env.log
 
   cd ../sub
@@ -69,6 +71,7 @@ begin_test "submodule env"
   grep "LocalGitDir=$(native_path_escaped "$TRASHDIR/repo/.git/modules/sub$")" env.log
   grep "LocalGitStorageDir=$(native_path_escaped "$TRASHDIR/repo/.git/modules/sub$")" env.log
   grep "LocalMediaDir=$(native_path_escaped "$TRASHDIR/repo/.git/modules/sub/lfs/objects$")" env.log
+  grep "LocalStorageDir=$(native_path_escaped "$TRASHDIR/repo/.git/modules/sub/lfs$")" env.log
   grep "TempDir=$(native_path_escaped "$TRASHDIR/repo/.git/modules/sub/lfs/tmp$")" env.log
 
   cd dir
@@ -80,6 +83,7 @@ begin_test "submodule env"
   grep "LocalGitDir=$(native_path_escaped "$TRASHDIR/repo/.git/modules/sub$")" env.log
   grep "LocalGitStorageDir=$(native_path_escaped "$TRASHDIR/repo/.git/modules/sub$")" env.log
   grep "LocalMediaDir=$(native_path_escaped "$TRASHDIR/repo/.git/modules/sub/lfs/objects$")" env.log
+  grep "LocalStorageDir=$(native_path_escaped "$TRASHDIR/repo/.git/modules/sub/lfs$")" env.log
   grep "TempDir=$(native_path_escaped "$TRASHDIR/repo/.git/modules/sub/lfs/tmp$")" env.log
 )
 end_test
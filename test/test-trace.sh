@@ -0,0 +1,33 @@
+#!/usr/bin/env bash
+
+. "test/testlib.sh"
+
+begin_test "trace categories"
+(
+  set -e
+
+  reponame="trace-categories"
+  setup_remote_repo "$reponame"
+  clone_repo "$reponame" "$reponame"
+
+  git lfs track "*.dat"
+  echo "a" > a.dat
+  git add .gitattributes a.dat
+  git commit -m "add a.dat"
+
+  unset GIT_TRACE
+  GIT_TRACE_LFS_TRANSFER="$TRASHDIR/transfer.log" git push origin master 2>&1 | tee push.log
+  grep "(1 of 1 files)" push.log
+  [ "0" = "$(grep -c "lfs.transfer" push.log)" ]
+
+  grep -E "^[0-9:.]+ lfs.transfer g[0-9]+: starting [0-9]+ transfer workers$" "$TRASHDIR/transfer.log"
+  [ "0" = "$(grep -c "lfs.scan" "$TRASHDIR/transfer.log")" ]
+
+  echo "b" > b.dat
+  git add b.dat
+  git commit -m "add b.dat"
+  GIT_TRACE=1 GIT_TRACE_LFS_TRANSFER=0 git push origin master 2>&1 | tee push.log
+  grep "lfs.scan g[0-9]*: run_command" push.log
+  [ "0" = "$(grep -c "lfs.transfer" push.log)" ]
+)
+end_test
@@ -220,3 +220,119 @@ begin_test "track in symlinked dir"
   }
 )
 end_test
+
+begin_test "track escaped patterns"
+(
+  set -e
+
+  git init track-escaped
+  cd track-escaped
+
+  git lfs track "foo bar/*" "#hash.psd" "été.zip" | tee track.log
+  grep "Tracking foo bar/\*" track.log
+  grep "Tracking #hash.psd" track.log
+  grep "Tracking été.zip" track.log
+
+  grep -F "foo[[:space:]]bar/* filter=lfs" .gitattributes
+  grep -F '\#hash.psd filter=lfs' .gitattributes
+  grep -F "été.zip filter=lfs" .gitattributes
+
+  git lfs track | grep "foo bar/\* (.gitattributes)"
+  git lfs track | grep "#hash.psd (.gitattributes)"
+
+  # tracking them again doesn't add more lines
+  [ "foo bar/* already supported" = "$(git lfs track "foo bar/*")" ]
+  [ "#hash.psd already supported" = "$(git lfs track "#hash.psd")" ]
+  [ "été.zip already supported" = "$(git lfs track "été.zip")" ]
+  [ "3" = "$(grep -c "filter=lfs" .gitattributes)" ]
+
+  printf "#hash" > "#hash.psd"
+  git add "#hash.psd"
+  git commit -m "add #hash.psd"
+  assert_pointer "master" "#hash.psd" "$(calc_oid "#hash")" 5
+)
+end_test
+
+begin_test "track keeps CRLF line endings"
+(
+  set -e
+
+  git init track-crlf
+  cd track-crlf
+
+  printf "# images\r\n*.jpg filter=lfs diff=lfs merge=lfs -text\r\n" > .gitattributes
+  git lfs track "*.jpg" | grep "already supported"
+  git lfs track "*.gif"
+
+  printf "# images\r\n*.jpg filter=lfs diff=lfs merge=lfs -text\r\n*.gif filter=lfs diff=lfs merge=lfs -text\r\n" > expected
+  cmp expected .gitattributes
+)
+end_test
+
+begin_test "track --lockable"
+(
+  set -e
+
+  git init track-lockable
+  cd track-lockable
+
+  git lfs track --lockable "*.psd" | grep "Tracking \*.psd"
+  grep "^\*.psd filter=lfs diff=lfs merge=lfs -text lockable$" .gitattributes
+
+  git lfs track "*.jpg"
+  git lfs track --lockable "*.jpg" | grep "Marking \*.jpg lockable"
+  git lfs track --lockable "*.jpg" | grep "\*.jpg already supported"
+  grep "^\*.jpg filter=lfs diff=lfs merge=lfs -text lockable$" .gitattributes
+  [ "2" = "$(grep -c "filter=lfs" .gitattributes)" ]
+)
+end_test
+
+begin_test "track --filename"
+(
+  set -e
+
+  git init track-filename
+  cd track-filename
+
+  git lfs track --filename "my file[1].png" | grep -F 'Tracking my file\[1].png'
+  grep -F 'my[[:space:]]file\[1].png filter=lfs' .gitattributes
+  git lfs track --filename "my file[1].png" | grep "already supported"
+
+  printf "literal" > "my file[1].png"
+  printf "glob" > "my file1.png"
+  git add "my file[1].png" "my file1.png" .gitattributes
+  git commit -m "add files"
+
+  # assert_pointer would grep for the name as a regex
+  git cat-file -p "HEAD:my file[1].png" | grep "oid sha256:$(calc_oid "literal")"
+  [ "glob" = "$(git cat-file -p "HEAD:my file1.png")" ]
+)
+end_test
+
+begin_test "track directory expands to its files"
+(
+  set -e
+
+  git init track-dir-files
+  cd track-dir-files
+
+  mkdir -p assets/sub
+  printf "a" > assets/a.bin
+  printf "b" > assets/sub/b.bin
+  printf "c" > other.bin
+  git add .
+  git commit -m "add files"
+
+  git lfs track "assets" | tee track.log
+  grep "Tracking assets/\*\*, as assets is a directory" track.log
+  grep "^assets/\*\* filter=lfs" .gitattributes
+  git lfs track "assets/" | grep "assets/\*\* already supported"
+
+  # the files the pattern matches are marked modified, and only them
+  git add .gitattributes assets other.bin
+  git commit -m "track assets"
+  assert_pointer "master" "assets/a.bin" "$(calc_oid "a")" 1
+  assert_pointer "master" "assets/sub/b.bin" "$(calc_oid "b")" 1
+  [ "c" = "$(git cat-file -p "HEAD:other.bin")" ]
+)
+end_test
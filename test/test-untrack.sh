@@ -50,3 +50,21 @@ begin_test "untrack outside git repo"
   fi
 )
 end_test
+
+begin_test "untrack escaped patterns"
+(
+  set -e
+
+  git init untrack-escaped
+  cd untrack-escaped
+
+  printf "# keep me\r\n*.jpg -delta\r\n" > .gitattributes
+  git lfs track "foo bar/*" "#hash.psd" "*.jpg"
+
+  git lfs untrack "foo bar/*" | grep "Untracking foo bar/\*"
+  git lfs untrack "#hash.psd" | grep "Untracking #hash.psd"
+
+  printf "# keep me\r\n*.jpg -delta\r\n*.jpg filter=lfs diff=lfs merge=lfs -text\r\n" > expected
+  cmp expected .gitattributes
+)
+end_test
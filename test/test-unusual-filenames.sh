@@ -23,7 +23,7 @@ begin_test "push unusually named files"
   git add -- .gitattributes *.dat
   git commit -m "add files"
 
-  git push origin master | tee push.log
+  git push origin master 2>&1 | tee push.log
   grep "Git LFS: (1 of 1 files)" push.log
 )
 end_test
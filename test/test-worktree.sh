@@ -24,6 +24,7 @@ LocalWorkingDir=$(native_path_escaped "$TRASHDIR/$reponame")
 LocalGitDir=$(native_path_escaped "$TRASHDIR/$reponame/.git")
 LocalGitStorageDir=$(native_path_escaped "$TRASHDIR/$reponame/.git")
 LocalMediaDir=$(native_path_escaped "$TRASHDIR/$reponame/.git/lfs/objects")
+LocalStorageDir=$(native_path_escaped "$TRASHDIR/$reponame/.git/lfs")
 TempDir=$(native_path_escaped "$TRASHDIR/$reponame/.git/lfs/tmp")
 ConcurrentTransfers=3
 BatchTransfer=true
@@ -45,6 +46,7 @@ LocalWorkingDir=$(native_path_escaped "$TRASHDIR/$worktreename")
 LocalGitDir=$(native_path_escaped "$TRASHDIR/$reponame/.git/worktrees/$worktreename")
 LocalGitStorageDir=$(native_path_escaped "$TRASHDIR/$reponame/.git")
 LocalMediaDir=$(native_path_escaped "$TRASHDIR/$reponame/.git/lfs/objects")
+LocalStorageDir=$(native_path_escaped "$TRASHDIR/$reponame/.git/lfs")
 TempDir=$(native_path_escaped "$TRASHDIR/$reponame/.git/worktrees/$worktreename/lfs/tmp")
 ConcurrentTransfers=3
 BatchTransfer=true
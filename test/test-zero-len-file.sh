@@ -38,7 +38,7 @@ begin_test "push zero len file"
 
   assert_pointer "master" "full.dat" "$contents_oid" 4
 
-  git push origin master | tee push.log
+  git push origin master 2>&1 | tee push.log
   grep "Git LFS: (1 of 1 files)" push.log
 )
 end_test
@@ -92,6 +92,24 @@ delete_server_object() {
   grep "200 OK" http.log
 }
 
+# create_server_lock locks a path on the git lfs server for the given owner,
+# which defaults to the authenticated user. HTTP log is written to http.log.
+#
+#   $ create_server_lock reponame path/to/file.dat [owner]
+create_server_lock() {
+  local reponame="$1"
+  local path="$2"
+  local owner="$3"
+  curl -v "$GITSERVER/$reponame.git/info/lfs/locks" \
+    -u "user:pass" \
+    -o http.json \
+    -H "Accept: application/vnd.git-lfs+json" \
+    -d "{\"path\":\"$path\",\"owner\":{\"name\":\"$owner\"}}" 2>&1 |
+    tee http.log
+
+  grep "201 Created" http.log
+}
+
 # check that the object does exist in the git lfs server. HTTP log is written
 # to http.log. JSON output is written to http.json.
 assert_server_object() {
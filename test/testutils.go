@@ -9,6 +9,7 @@ package test
 // as if the test was in the same package (as usual)
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,7 +17,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/github/git-lfs/git"
@@ -45,6 +48,14 @@ type RepoCallback interface {
 	// Errorf reports error and continues
 	Errorf(format string, args ...interface{})
 }
+
+// Optional extension of RepoCallback (testing.T compatible), for tests that
+// need something the platform can't do
+type repoSkipper interface {
+	// Skipf reports why the test was skipped and stops it
+	Skipf(format string, args ...interface{})
+}
+
 type Repo struct {
 	// Path to the repo, working copy if non-bare
 	Path string
@@ -52,6 +63,8 @@ type Repo struct {
 	GitDir string
 	// Paths to remotes
 	Remotes map[string]*Repo
+	// Repos added as submodules, by path
+	Submodules map[string]*Repo
 	// Settings used to create this repo
 	Settings *RepoCreateSettings
 	// Previous dir for pushd
@@ -113,6 +126,10 @@ func (r *Repo) Cleanup() {
 		remote.Cleanup()
 	}
 	r.Remotes = nil
+	for _, submodule := range r.Submodules {
+		submodule.Cleanup()
+	}
+	r.Submodules = nil
 }
 
 // NewRepo creates a new git repo in a new temp dir
@@ -120,6 +137,11 @@ func NewRepo(callback RepoCallback) *Repo {
 	return NewCustomRepo(callback, &RepoCreateSettings{RepoType: RepoTypeNormal})
 }
 
+// NewBareRepo creates a new bare git repo in a new temp dir
+func NewBareRepo(callback RepoCallback) *Repo {
+	return NewCustomRepo(callback, &RepoCreateSettings{RepoType: RepoTypeBare})
+}
+
 // NewCustomRepo creates a new git repo in a new temp dir with more control over settings
 func NewCustomRepo(callback RepoCallback, settings *RepoCreateSettings) *Repo {
 	ret := &Repo{
@@ -170,14 +192,148 @@ func WrapRepo(c RepoCallback, path string) *Repo {
 	return &Repo{Path: path, callback: c, Settings: &RepoCreateSettings{RepoType: RepoTypeNormal}}
 }
 
+// Clone clones this repo into a new repo with a working copy in a new temp dir,
+// which the caller must clean up
+func (r *Repo) Clone() *Repo {
+	path, err := ioutil.TempDir("", "lfsRepo")
+	if err != nil {
+		r.callback.Fatalf("Can't create temp dir for git repo: %v", err)
+	}
+	clone := &Repo{
+		Path:     path,
+		GitDir:   filepath.Join(path, ".git"),
+		Settings: &RepoCreateSettings{RepoType: RepoTypeNormal},
+		Remotes:  make(map[string]*Repo),
+		callback: r.callback}
+
+	RunGitCommand(r.callback, true, "clone", "--quiet", r.GitDir, path)
+	clone.RunGitCommand(true, "config", "user.name", "Git LFS Tests")
+	clone.RunGitCommand(true, "config", "user.email", "git-lfs@example.com")
+	return clone
+}
+
+// CloneBare clones this repo into a new bare repo in a new temp dir, which the
+// caller must clean up
+func (r *Repo) CloneBare() *Repo {
+	path, err := ioutil.TempDir("", "lfsRepo")
+	if err != nil {
+		r.callback.Fatalf("Can't create temp dir for git repo: %v", err)
+	}
+	clone := &Repo{
+		Path:     path,
+		GitDir:   path,
+		Settings: &RepoCreateSettings{RepoType: RepoTypeBare},
+		Remotes:  make(map[string]*Repo),
+		callback: r.callback}
+
+	RunGitCommand(r.callback, true, "clone", "--bare", "--quiet", r.GitDir, path)
+	return clone
+}
+
+// RunGitCommand runs a git command in this repo, whatever the current dir or
+// repo type - returns combined output
+func (r *Repo) RunGitCommand(failureCheck bool, args ...string) string {
+	combined, stdout, stderr, err := runGit(r.Path, nil, args)
+	if failureCheck && err != nil {
+		failGitCommand(r.callback, r.Path, args, combined, stdout, stderr, err)
+	}
+	return combined
+}
+
 // Simplistic fire & forget running of git command - returns combined output
 func RunGitCommand(callback RepoCallback, failureCheck bool, args ...string) string {
-	outp, err := exec.Command("git", args...).CombinedOutput()
+	combined, stdout, stderr, err := runGit("", nil, args)
 	if failureCheck && err != nil {
-		callback.Fatalf("Error running git command 'git %v': %v %v", strings.Join(args, " "), err, string(outp))
+		failGitCommand(callback, "", args, combined, stdout, stderr, err)
 	}
-	return string(outp)
+	return combined
+}
+
+// RunGitCommandEnv runs a git command with env added to the environment,
+// returning its stdout & stderr separately
+func RunGitCommandEnv(callback RepoCallback, env map[string]string, expectSuccess bool, args ...string) (string, string) {
+	combined, stdout, stderr, err := runGit("", env, args)
+	if expectSuccess && err != nil {
+		failGitCommand(callback, "", args, combined, stdout, stderr, err)
+	}
+	return stdout, stderr
+}
+
+// ExpectGitFailure runs a git command with env added to the environment, failing
+// unless the command fails with stderrContains in its stderr, which is returned
+func ExpectGitFailure(callback RepoCallback, env map[string]string, stderrContains string, args ...string) string {
+	combined, _, stderr, err := runGit("", env, args)
+	if err == nil {
+		callback.Fatalf("Expected git command 'git %v' to fail, but it succeeded: %v", strings.Join(args, " "), combined)
+	} else if !strings.Contains(stderr, stderrContains) {
+		callback.Fatalf("Expected git command 'git %v' to fail with %q, got: %v", strings.Join(args, " "), stderrContains, stderr)
+	}
+	return stderr
+}
+
+// Optional extension of RepoCallback (testing.T compatible) to log details
+type repoLogger interface {
+	// Logf records text in the test log
+	Logf(format string, args ...interface{})
+}
+
+// runGit runs git in dir, or the current dir if it's empty, with env added to
+// the environment. Returns stdout & stderr interleaved as well as separately
+func runGit(dir string, env map[string]string, args []string) (combined, stdout, stderr string, err error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+
+	all := &lockedBuffer{}
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&outBuf, all)
+	cmd.Stderr = io.MultiWriter(&errBuf, all)
+	err = cmd.Run()
+
+	// the command may have changed the config that this process has cached
+	git.Config.ClearCache()
+
+	return all.String(), outBuf.String(), errBuf.String(), err
+}
+
+// failGitCommand fails the test for a git command that failed unexpectedly,
+// logging its stdout & stderr in full if the callback can
+func failGitCommand(callback RepoCallback, dir string, args []string, combined, stdout, stderr string, err error) {
+	where := ""
+	if dir != "" {
+		where = " in " + dir
+	}
+
+	if logger, ok := callback.(repoLogger); ok {
+		logger.Logf("git %v%v failed: %v\nstdout:\n%v\nstderr:\n%v", strings.Join(args, " "), where, err, stdout, stderr)
+		callback.Fatalf("Error running git command 'git %v'%v: %v", strings.Join(args, " "), where, err)
+		return
+	}
+	callback.Fatalf("Error running git command 'git %v'%v: %v %v", strings.Join(args, " "), where, err, combined)
+}
+
+// lockedBuffer is a bytes.Buffer that's safe to write to from the goroutines
+// exec uses to copy stdout & stderr
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
 
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
 }
 
 // Input data for a single file in a commit
@@ -190,6 +346,15 @@ type FileInput struct {
 	DataReader io.Reader
 	// Input data (optional, if provided will be source of data)
 	Data string
+	// Mode of file (optional, eg 0755 for an executable)
+	Mode os.FileMode
+	// Oid of a ready-made pointer to commit, of Size, instead of generating &
+	// storing data (optional). Use WriteObject to store content for it
+	PointerOid string
+	// Target of a symlink to create instead of a file (optional, Size & data are
+	// ignored). Skips the test if symlinks can't be created, as on Windows
+	// without extra privileges
+	SymlinkTo string
 }
 
 // Input for defining commits for test repo
@@ -218,32 +383,24 @@ type CommitOutput struct {
 	Files   []*lfs.Pointer
 }
 
-func commitAtDate(atDate time.Time, committerName, committerEmail, msg string) error {
+func commitAtDate(callback RepoCallback, atDate time.Time, committerName, committerEmail, msg string) {
 	var args []string
 	if committerName != "" && committerEmail != "" {
 		args = append(args, "-c", fmt.Sprintf("user.name=%v", committerName))
 		args = append(args, "-c", fmt.Sprintf("user.email=%v", committerEmail))
 	}
 	args = append(args, "commit", "--allow-empty", "-m", msg)
-	cmd := exec.Command("git", args...)
-	env := os.Environ()
 	// set GIT_COMMITTER_DATE environment var e.g. "Fri Jun 21 20:26:41 2013 +0900"
-	if atDate.IsZero() {
-		env = append(env, "GIT_COMMITTER_DATE=")
-	} else {
-		env = append(env, fmt.Sprintf("GIT_COMMITTER_DATE=%v", git.FormatGitDate(atDate)))
-	}
-	cmd.Env = env
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("%v %v", err, string(out))
+	env := map[string]string{"GIT_COMMITTER_DATE": ""}
+	if !atDate.IsZero() {
+		env["GIT_COMMITTER_DATE"] = git.FormatGitDate(atDate)
 	}
-	return nil
+	RunGitCommandEnv(callback, env, true, args...)
 }
 
 func (repo *Repo) AddCommits(inputs []*CommitInput) []*CommitOutput {
 	if repo.Settings.RepoType == RepoTypeBare {
-		repo.callback.Fatalf("Cannot use AddCommits on a bare repo; clone it & push changes instead")
+		return repo.addCommitsViaClone(inputs)
 	}
 
 	// Change to repo working dir
@@ -282,34 +439,17 @@ func (repo *Repo) AddCommits(inputs []*CommitInput) []*CommitOutput {
 		}
 		// Any files to write?
 		for _, infile := range input.Files {
-			inputData := infile.DataReader
-			if inputData == nil && infile.Data != "" {
-				inputData = strings.NewReader(infile.Data)
-			}
-			if inputData == nil {
-				// Different data for each file but deterministic
-				inputData = NewPlaceholderDataReader(seedSequence.Int63(), infile.Size)
-			}
-			cleaned, err := lfs.PointerClean(inputData, infile.Filename, infile.Size, nil)
-			if err != nil {
-				repo.callback.Errorf("Error creating pointer file: %v", err)
+			if infile.SymlinkTo != "" {
+				repo.addSymlink(infile)
 				continue
 			}
-			// this only created the temp file, move to final location
-			tmpfile := cleaned.Filename
-			mediafile, err := lfs.LocalMediaPath(cleaned.Oid)
-			if err != nil {
-				repo.callback.Errorf("Unable to get local media path: %v", err)
+
+			pointer := repo.cleanFileInput(infile, seedSequence)
+			if pointer == nil {
 				continue
 			}
-			if _, err := os.Stat(mediafile); err != nil {
-				if err := os.Rename(tmpfile, mediafile); err != nil {
-					repo.callback.Errorf("Unable to move %s to %s: %v", tmpfile, mediafile, err)
-					continue
-				}
-			}
 
-			output.Files = append(output.Files, cleaned.Pointer)
+			output.Files = append(output.Files, pointer)
 			// Write pointer to local filename for adding (not using clean filter)
 			os.MkdirAll(filepath.Dir(infile.Filename), 0755)
 			f, err := os.Create(infile.Filename)
@@ -317,22 +457,26 @@ func (repo *Repo) AddCommits(inputs []*CommitInput) []*CommitOutput {
 				repo.callback.Errorf("Error creating pointer file: %v", err)
 				continue
 			}
-			_, err = cleaned.Pointer.Encode(f)
+			_, err = pointer.Encode(f)
 			if err != nil {
 				f.Close()
 				repo.callback.Errorf("Error encoding pointer file: %v", err)
 				continue
 			}
 			f.Close() // early close in a loop, don't defer
+			if infile.Mode != 0 {
+				os.Chmod(infile.Filename, infile.Mode)
+			}
 			RunGitCommand(repo.callback, true, "add", infile.Filename)
+			if infile.Mode&0111 != 0 {
+				// for filesystems without an executable bit
+				RunGitCommand(repo.callback, true, "update-index", "--chmod=+x", infile.Filename)
+			}
 
 		}
 		// Now commit
-		err = commitAtDate(input.CommitDate, input.CommitterName, input.CommitterEmail,
+		commitAtDate(repo.callback, input.CommitDate, input.CommitterName, input.CommitterEmail,
 			fmt.Sprintf("Test commit %d", i))
-		if err != nil {
-			repo.callback.Fatalf("Error committing: %v", err)
-		}
 
 		commit, err := git.GetCommitSummary("HEAD")
 		if err != nil {
@@ -359,6 +503,61 @@ func (repo *Repo) AddCommits(inputs []*CommitInput) []*CommitOutput {
 	return outputs
 }
 
+// cleanFileInput returns the pointer for a file AddCommits will commit, storing
+// its generated content unless it's a ready-made pointer. Returns nil on error
+func (repo *Repo) cleanFileInput(infile *FileInput, seedSequence rand.Source) *lfs.Pointer {
+	if infile.PointerOid != "" {
+		return lfs.NewPointer(infile.PointerOid, infile.Size, nil)
+	}
+
+	inputData := infile.DataReader
+	if inputData == nil && infile.Data != "" {
+		inputData = strings.NewReader(infile.Data)
+	}
+	if inputData == nil {
+		// Different data for each file but deterministic
+		inputData = NewPlaceholderDataReader(seedSequence.Int63(), infile.Size)
+	}
+	cleaned, err := lfs.PointerClean(lfs.NamedReader(inputData, infile.Filename), infile.Size, nil)
+	if err != nil {
+		repo.callback.Errorf("Error creating pointer file: %v", err)
+		return nil
+	}
+	// this only created the temp file, move to final location
+	if err := lfs.IngestObject(cleaned.Filename, cleaned.Oid, cleaned.Size, true); err != nil {
+		repo.callback.Errorf("Unable to store object %s: %v", cleaned.Oid, err)
+		return nil
+	}
+	return cleaned.Pointer
+}
+
+// addSymlink creates & stages a symlink for AddCommits, skipping the test if it
+// can't be created
+func (repo *Repo) addSymlink(infile *FileInput) {
+	os.MkdirAll(filepath.Dir(infile.Filename), 0755)
+	os.Remove(infile.Filename)
+	if err := os.Symlink(infile.SymlinkTo, infile.Filename); err != nil {
+		if skipper, ok := repo.callback.(repoSkipper); ok && runtime.GOOS == "windows" {
+			skipper.Skipf("Can't create symlink %v: %v", infile.Filename, err)
+		}
+		repo.callback.Fatalf("Can't create symlink %v: %v", infile.Filename, err)
+	}
+	RunGitCommand(repo.callback, true, "add", infile.Filename)
+}
+
+// addCommitsViaClone adds commits to a bare repo by making them in a temporary
+// clone and pushing all branches & tags back. LFS objects are stored as for
+// AddCommits, in the current LocalMediaDir.
+func (repo *Repo) addCommitsViaClone(inputs []*CommitInput) []*CommitOutput {
+	clone := repo.Clone()
+	defer clone.Cleanup()
+
+	outputs := clone.AddCommits(inputs)
+	clone.RunGitCommand(true, "push", "--quiet", "origin", "--all")
+	clone.RunGitCommand(true, "push", "--quiet", "origin", "--tags")
+	return outputs
+}
+
 // Add a new remote (generate a path for it to live in, will be cleaned up)
 func (r *Repo) AddRemote(name string) *Repo {
 	if _, exists := r.Remotes[name]; exists {
@@ -366,10 +565,65 @@ func (r *Repo) AddRemote(name string) *Repo {
 	}
 	remote := NewCustomRepo(r.callback, &RepoCreateSettings{RepoTypeBare})
 	r.Remotes[name] = remote
-	RunGitCommand(r.callback, true, "remote", "add", name, remote.Path)
+	r.RunGitCommand(true, "remote", "add", name, remote.Path)
 	return remote
 }
 
+// AddSubmodule adds other as a submodule at path & commits it, returning the
+// SHA recorded in the gitlink. other is cleaned up along with this repo
+func (r *Repo) AddSubmodule(path string, other *Repo) string {
+	if r.Submodules == nil {
+		r.Submodules = make(map[string]*Repo)
+	}
+	if _, exists := r.Submodules[path]; exists {
+		r.callback.Fatalf("Submodule %v already exists", path)
+	}
+	r.Submodules[path] = other
+
+	// newer gits refuse local submodules unless the file protocol is allowed
+	r.RunGitCommand(true, "-c", "protocol.file.allow=always", "submodule", "--quiet", "add", other.Path, path)
+	r.RunGitCommand(true, "commit", "--quiet", "-m", "Add submodule "+path)
+	return strings.TrimSpace(r.RunGitCommand(true, "rev-parse", "HEAD:"+path))
+}
+
+// UpdateSubmodules initialises & checks out all submodules, eg in a clone of a
+// repo with submodules added by AddSubmodule
+func (r *Repo) UpdateSubmodules() {
+	r.RunGitCommand(true, "-c", "protocol.file.allow=always", "submodule", "--quiet", "update", "--init", "--recursive")
+}
+
+// TrackPatterns adds LFS tracking for patterns to .gitattributes & commits it
+func (r *Repo) TrackPatterns(patterns ...string) {
+	if r.Settings.RepoType == RepoTypeBare {
+		r.callback.Fatalf("Cannot use TrackPatterns on a bare repo")
+	}
+
+	path := filepath.Join(r.Path, ".gitattributes")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		r.callback.Fatalf("Can't open %v: %v", path, err)
+	}
+	for _, pattern := range patterns {
+		fmt.Fprintf(f, "%s filter=lfs diff=lfs merge=lfs -text\n", pattern)
+	}
+	f.Close()
+
+	r.RunGitCommand(true, "add", ".gitattributes")
+	r.RunGitCommand(true, "commit", "--quiet", "-m", "Track "+strings.Join(patterns, " "))
+}
+
+// WriteObject stores content as the LFS object oid in this repo's local storage,
+// whether or not oid is its real SHA-256
+func (r *Repo) WriteObject(oid string, content string) {
+	dir := filepath.Join(r.GitDir, "lfs", "objects", oid[0:2], oid[2:4])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		r.callback.Fatalf("Can't create object dir %v: %v", dir, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, oid), []byte(content), 0644); err != nil {
+		r.callback.Fatalf("Can't write object %v: %v", oid, err)
+	}
+}
+
 // Just a psuedo-random stream of bytes (not cryptographic)
 // Calls RNG a bit less often than using rand.Source directly
 type PlaceholderDataReader struct {
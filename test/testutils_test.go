@@ -0,0 +1,229 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/git-lfs/lfs"
+)
+
+func TestBareRepoWithHistory(t *testing.T) {
+	repo := NewBareRepo(t)
+	defer repo.Cleanup()
+	repo.Pushd()
+	defer repo.Popd()
+
+	now := time.Now()
+	outputs := repo.AddCommits([]*CommitInput{
+		{
+			CommitDate: now.AddDate(0, 0, -1),
+			Files:      []*FileInput{{Filename: "file1.txt", Size: 20}},
+		},
+		{
+			CommitDate: now,
+			NewBranch:  "branch2",
+			Files:      []*FileInput{{Filename: "file2.txt", Size: 30}},
+			Tags:       []string{"v1.0"},
+		},
+	})
+
+	expectRev(t, repo, "--is-bare-repository", "true")
+	expectRev(t, repo, "master", outputs[0].Sha)
+	expectRev(t, repo, "branch2", outputs[1].Sha)
+	expectRev(t, repo, "branch2^", outputs[0].Sha)
+	expectRev(t, repo, "v1.0^{commit}", outputs[1].Sha)
+
+	clone := repo.CloneBare()
+	defer clone.Cleanup()
+	expectRev(t, clone, "--is-bare-repository", "true")
+	expectRev(t, clone, "branch2", outputs[1].Sha)
+
+	remote := clone.AddRemote("mirror")
+	clone.RunGitCommand(true, "push", "--quiet", "--mirror", "mirror")
+	expectRev(t, remote, "master", outputs[0].Sha)
+}
+
+func expectRev(t *testing.T, repo *Repo, rev, expected string) {
+	actual := strings.TrimSpace(repo.RunGitCommand(true, "rev-parse", rev))
+	if actual != expected {
+		t.Errorf("rev-parse %v in %v: expected %v, got %v", rev, repo.Path, expected, actual)
+	}
+}
+
+func TestFileModesAndSymlinks(t *testing.T) {
+	repo := NewRepo(t)
+	defer repo.Cleanup()
+	repo.Pushd()
+	defer repo.Popd()
+
+	repo.AddCommits([]*CommitInput{
+		{
+			Files: []*FileInput{
+				{Filename: "plain.dat", Size: 10},
+				{Filename: "bin/tool.dat", Size: 20, Mode: 0755},
+				{Filename: "link.dat", SymlinkTo: "plain.dat"},
+			},
+		},
+	})
+
+	modes := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(repo.RunGitCommand(true, "ls-tree", "-r", "HEAD")), "\n") {
+		// <mode> <type> <sha>\t<path>
+		fields := strings.Fields(line)
+		modes[fields[len(fields)-1]] = fields[0]
+	}
+
+	for path, expected := range map[string]string{
+		"plain.dat":    "100644",
+		"bin/tool.dat": "100755",
+		"link.dat":     "120000",
+	} {
+		if modes[path] != expected {
+			t.Errorf("%v: expected mode %v, got %q", path, expected, modes[path])
+		}
+	}
+
+	if target := repo.RunGitCommand(true, "cat-file", "blob", "HEAD:link.dat"); target != "plain.dat" {
+		t.Errorf("link.dat: expected target plain.dat, got %q", target)
+	}
+}
+
+func TestSubmodules(t *testing.T) {
+	sub := NewRepo(t)
+	sub.Pushd()
+	outputs := sub.AddCommits([]*CommitInput{
+		{Files: []*FileInput{{Filename: "sub.dat", Size: 10}}},
+	})
+	sub.Popd()
+
+	super := NewRepo(t)
+	defer super.Cleanup()
+	super.Pushd()
+	super.AddCommits([]*CommitInput{
+		{Files: []*FileInput{{Filename: "super.dat", Size: 20}}},
+	})
+	super.Popd()
+
+	sha := super.AddSubmodule("lib/sub", sub)
+	if sha != outputs[0].Sha {
+		t.Errorf("expected gitlink %v, got %v", outputs[0].Sha, sha)
+	}
+
+	status := super.RunGitCommand(true, "submodule", "status")
+	if expected := " " + sha + " lib/sub"; !strings.HasPrefix(status, expected) {
+		t.Errorf("expected submodule status %q, got %q", expected, status)
+	}
+
+	clone := super.Clone()
+	defer clone.Cleanup()
+	clone.UpdateSubmodules()
+	if status := clone.RunGitCommand(true, "submodule", "status"); status != super.RunGitCommand(true, "submodule", "status") {
+		t.Errorf("expected the clone's submodule to be checked out, got %q", status)
+	}
+}
+
+func TestLfsFixtures(t *testing.T) {
+	repo := NewRepo(t)
+	defer repo.Cleanup()
+	repo.Pushd()
+	defer repo.Popd()
+
+	present := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	missing := "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9"
+	repo.TrackPatterns("*.bin", "*.dat")
+	repo.WriteObject(present, "foo")
+	outputs := repo.AddCommits([]*CommitInput{
+		{
+			Files: []*FileInput{
+				{Filename: "present.bin", PointerOid: present, Size: 3},
+				{Filename: "missing.bin", PointerOid: missing, Size: 3},
+			},
+		},
+	})
+
+	if len(outputs[0].Files) != 2 || outputs[0].Files[0].Oid != present {
+		t.Errorf("expected pointers for both files, got %v", outputs[0].Files)
+	}
+
+	attrs := repo.RunGitCommand(true, "show", "HEAD:.gitattributes")
+	if expected := "*.bin filter=lfs diff=lfs merge=lfs -text\n*.dat filter=lfs diff=lfs merge=lfs -text\n"; attrs != expected {
+		t.Errorf("expected .gitattributes %q, got %q", expected, attrs)
+	}
+
+	for _, oid := range []string{present, missing} {
+		name := "present.bin"
+		if oid == missing {
+			name = "missing.bin"
+		}
+		blob := repo.RunGitCommand(true, "cat-file", "blob", "HEAD:"+name)
+		if expected := lfs.NewPointer(oid, 3, nil).Encoded(); blob != expected {
+			t.Errorf("%v: expected pointer %q, got %q", name, expected, blob)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(repo.GitDir, "lfs", "objects", "2c", "26", present))
+	if err != nil || string(content) != "foo" {
+		t.Errorf("expected stored object foo, got %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.GitDir, "lfs", "objects", "fc", "de", missing)); !os.IsNotExist(err) {
+		t.Errorf("expected no object for %v, got %v", missing, err)
+	}
+}
+
+// recordingCallback records what's reported to it instead of failing
+type recordingCallback struct {
+	fatals []string
+	logs   []string
+}
+
+func (c *recordingCallback) Fatalf(format string, args ...interface{}) {
+	c.fatals = append(c.fatals, fmt.Sprintf(format, args...))
+}
+func (c *recordingCallback) Errorf(format string, args ...interface{}) {
+	c.fatals = append(c.fatals, fmt.Sprintf(format, args...))
+}
+func (c *recordingCallback) Logf(format string, args ...interface{}) {
+	c.logs = append(c.logs, fmt.Sprintf(format, args...))
+}
+
+func TestRunGitCommandEnv(t *testing.T) {
+	env := map[string]string{"GIT_AUTHOR_NAME": "Env Test", "GIT_AUTHOR_EMAIL": "env@example.com"}
+	stdout, stderr := RunGitCommandEnv(t, env, true, "var", "GIT_AUTHOR_IDENT")
+	if !strings.HasPrefix(stdout, "Env Test <env@example.com>") || stderr != "" {
+		t.Errorf("expected the ident from the environment, got %q, %q", stdout, stderr)
+	}
+
+	var callback recordingCallback
+	stdout, stderr = RunGitCommandEnv(&callback, nil, true, "rev-parse", "--verify", "no-such-ref")
+	if !strings.Contains(stderr, "fatal") || len(callback.fatals) != 1 {
+		t.Fatalf("expected a fatal error with stderr, got %q, %v", stderr, callback.fatals)
+	}
+	if len(callback.logs) != 1 || !strings.Contains(callback.logs[0], "stderr:\n"+stderr) {
+		t.Errorf("expected stderr to be logged, got %v", callback.logs)
+	}
+
+	callback = recordingCallback{}
+	RunGitCommand(&callback, true, "rev-parse", "--verify", "no-such-ref")
+	if len(callback.fatals) != 1 || len(callback.logs) != 1 || !strings.Contains(callback.logs[0], "fatal") {
+		t.Errorf("expected RunGitCommand to log stderr & fail, got %v, %v", callback.logs, callback.fatals)
+	}
+}
+
+func TestExpectGitFailure(t *testing.T) {
+	stderr := ExpectGitFailure(t, nil, "unknown revision", "log", "no-such-ref")
+	if !strings.Contains(stderr, "no-such-ref") {
+		t.Errorf("expected stderr to be returned, got %q", stderr)
+	}
+
+	var callback recordingCallback
+	ExpectGitFailure(&callback, nil, "anything", "--version")
+	ExpectGitFailure(&callback, map[string]string{"LANG": "C"}, "not in the output", "log", "no-such-ref")
+	if len(callback.fatals) != 2 {
+		t.Errorf("expected a success & the wrong stderr to fail, got %v", callback.fatals)
+	}
+}
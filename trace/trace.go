@@ -0,0 +1,182 @@
+// Package trace writes debug output by category, following git's GIT_TRACE
+// conventions.
+//
+// Each Category is traced when its own variable, GIT_TRACE_LFS_<NAME>, is set,
+// or otherwise when GIT_TRACE is. Values are interpreted as git does:
+//     unset, empty, 0 or "false": no output
+//     1, 2 or "true":             stderr
+//     3 - 9:                      that file descriptor
+//     an absolute path:           appended to that file
+//
+// Lines are prefixed with the time, the category and the id of the goroutine
+// writing them, and all categories share one lock, so lines from concurrent
+// workers never interleave.
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Scan traces scanning refs, trees and the working copy for pointers.
+	Scan = NewCategory("scan")
+	// Transfer traces the transfer queue and its workers.
+	Transfer = NewCategory("transfer")
+	// Auth traces finding credentials and authenticating with the API.
+	Auth = NewCategory("auth")
+)
+
+var (
+	mutex      sync.Mutex // guards categories, outputs and every write
+	categories []*Category
+	outputs    = make(map[string]*output)
+)
+
+// Category is a kind of trace output that can be enabled on its own.
+type Category struct {
+	Name    string
+	enabled int32 // read atomically, so a disabled Printf is cheap
+	out     *output
+}
+
+type output struct {
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewCategory returns a category traced by GIT_TRACE_LFS_<NAME>, configured
+// from the environment.
+func NewCategory(name string) *Category {
+	c := &Category{Name: name}
+
+	mutex.Lock()
+	categories = append(categories, c)
+	c.configure()
+	mutex.Unlock()
+
+	return c
+}
+
+// EnvVar returns the environment variable that enables this category.
+func (c *Category) EnvVar() string {
+	return "GIT_TRACE_LFS_" + strings.ToUpper(c.Name)
+}
+
+// Enabled returns whether trace output for this category is written. Use it to
+// skip building expensive messages.
+func (c *Category) Enabled() bool {
+	return atomic.LoadInt32(&c.enabled) == 1
+}
+
+// Printf writes a line of trace output for this category, if it's enabled.
+func (c *Category) Printf(format string, args ...interface{}) {
+	if !c.Enabled() {
+		return
+	}
+
+	line := fmt.Sprintf("%s lfs.%s g%d: %s\n",
+		time.Now().Format("15:04:05.000000"), c.Name, goroutineId(),
+		fmt.Sprintf(format, args...))
+
+	mutex.Lock()
+	if c.out != nil {
+		io.WriteString(c.out.w, line)
+	}
+	mutex.Unlock()
+}
+
+// Reconfigure reads the environment again for every category, eg after tests
+// change it. Files opened for earlier settings are closed.
+func Reconfigure() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for target, out := range outputs {
+		if out != nil && out.closer != nil {
+			out.closer.Close()
+		}
+		delete(outputs, target)
+	}
+
+	for _, c := range categories {
+		c.configure()
+	}
+}
+
+// configure sets up c from the environment. mutex must be held.
+func (c *Category) configure() {
+	target := os.Getenv(c.EnvVar())
+	if len(target) == 0 {
+		target = os.Getenv("GIT_TRACE")
+	}
+
+	c.out = outputFor(target)
+	if c.out == nil {
+		atomic.StoreInt32(&c.enabled, 0)
+	} else {
+		atomic.StoreInt32(&c.enabled, 1)
+	}
+}
+
+// outputFor returns where output for the trace setting goes, shared between
+// categories with the same setting, or nil if it's disabled. mutex must be
+// held.
+func outputFor(target string) *output {
+	switch strings.ToLower(target) {
+	case "", "0", "false":
+		return nil
+	case "1", "2", "true":
+		return &output{w: os.Stderr}
+	}
+
+	if out, ok := outputs[target]; ok {
+		return out
+	}
+
+	var out *output
+	if fd, err := strconv.Atoi(target); err == nil {
+		if fd < 3 || fd > 9 {
+			fmt.Fprintf(os.Stderr, "trace: invalid file descriptor %d, not tracing\n", fd)
+		} else {
+			out = &output{w: os.NewFile(uintptr(fd), "trace")}
+		}
+	} else if filepath.IsAbs(target) {
+		if f, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666); err != nil {
+			fmt.Fprintf(os.Stderr, "trace: could not open %q for tracing: %s\n", target, err)
+		} else {
+			out = &output{w: f, closer: f}
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "trace: %q is not an absolute path, not tracing\n", target)
+	}
+
+	// remembered even if it's nil, so a bad setting is only reported once
+	outputs[target] = out
+	return out
+}
+
+var goroutinePrefix = []byte("goroutine ")
+
+// goroutineId returns the id of the calling goroutine, from the header of its
+// stack trace, or 0 if it can't be found.
+func goroutineId() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, goroutinePrefix)
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
@@ -0,0 +1,128 @@
+package trace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// withEnv runs cb with the given trace variables set and all others unset,
+// returning what was traced to a temp file named by the value "FILE".
+func withEnv(t *testing.T, env map[string]string, cb func()) string {
+	dir, err := ioutil.TempDir("", "lfs-trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trace.log")
+
+	vars := []string{"GIT_TRACE", Scan.EnvVar(), Transfer.EnvVar(), Auth.EnvVar()}
+	saved := make(map[string]string)
+	for _, name := range vars {
+		saved[name] = os.Getenv(name)
+		value := env[name]
+		if value == "FILE" {
+			value = path
+		}
+		os.Setenv(name, value)
+	}
+	defer func() {
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+		Reconfigure()
+	}()
+
+	Reconfigure()
+	cb()
+	Reconfigure() // closes the file
+
+	by, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(by)
+}
+
+func traceAll() {
+	Scan.Printf("scanning %s", "HEAD")
+	Transfer.Printf("starting %d workers", 3)
+	Auth.Printf("filled credentials")
+}
+
+func TestCategoryFiltering(t *testing.T) {
+	out := withEnv(t, map[string]string{Scan.EnvVar(): "FILE", Auth.EnvVar(): "FILE"}, traceAll)
+
+	expected := regexp.MustCompile(`^\d\d:\d\d:\d\d\.\d{6} lfs\.scan g\d+: scanning HEAD\n` +
+		`\d\d:\d\d:\d\d\.\d{6} lfs\.auth g\d+: filled credentials\n$`)
+	if !expected.MatchString(out) {
+		t.Errorf("unexpected trace output:\n%s", out)
+	}
+	if Scan.Enabled() || Transfer.Enabled() || Auth.Enabled() {
+		t.Errorf("expected tracing to be disabled once the env is reset")
+	}
+}
+
+func TestGitTraceEnablesAllCategories(t *testing.T) {
+	out := withEnv(t, map[string]string{"GIT_TRACE": "FILE"}, traceAll)
+	for _, expected := range []string{"lfs.scan", "lfs.transfer", "lfs.auth"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %s output, got:\n%s", expected, out)
+		}
+	}
+
+	// a category's own variable wins
+	out = withEnv(t, map[string]string{"GIT_TRACE": "FILE", Transfer.EnvVar(): "0"}, traceAll)
+	if strings.Contains(out, "lfs.transfer") || !strings.Contains(out, "lfs.scan") {
+		t.Errorf("expected transfer output to be disabled, got:\n%s", out)
+	}
+}
+
+func TestDisabledValues(t *testing.T) {
+	for _, value := range []string{"", "0", "false", "relative/path"} {
+		withEnv(t, map[string]string{"GIT_TRACE": value}, func() {
+			if Scan.Enabled() {
+				t.Errorf("expected GIT_TRACE=%q to disable tracing", value)
+			}
+		})
+	}
+}
+
+func TestConcurrentLinesDontInterleave(t *testing.T) {
+	long := strings.Repeat("x", 4096)
+	out := withEnv(t, map[string]string{"GIT_TRACE": "FILE"}, func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					Transfer.Printf("%s", long)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 400 {
+		t.Fatalf("expected 400 lines, got %d", len(lines))
+	}
+
+	ids := make(map[string]bool)
+	line := regexp.MustCompile(`^\S+ lfs\.transfer (g\d+): (x+)$`)
+	for _, l := range lines {
+		m := line.FindStringSubmatch(l)
+		if m == nil || m[2] != long {
+			t.Fatalf("malformed line: %.80q", l)
+		}
+		ids[m[1]] = true
+	}
+	if len(ids) != 8 {
+		t.Errorf("expected lines from 8 goroutines, got %d", len(ids))
+	}
+}
@@ -0,0 +1,375 @@
+// Package transfer runs custom transfer adapters: external processes which
+// move objects to and from somewhere Git LFS can't reach over HTTP by itself.
+//
+// An adapter is configured in git config, and offered to the batch API along
+// with the built-in "basic" adapter. It is only used if the server picks it.
+//
+//	lfs.customtransfer.<name>.path       the program to run
+//	lfs.customtransfer.<name>.args       arguments for it, split on spaces
+//	lfs.customtransfer.<name>.concurrent whether to run one process for each
+//	                                     transfer worker (default true), or a
+//	                                     single process for the lot
+//	lfs.customtransfer.<name>.direction  "upload", "download" or "both" (the
+//	                                     default)
+//
+// Git LFS talks to each process with one JSON message per line, on the
+// process's stdin and stdout. Anything it writes to stderr is passed through.
+// The messages are:
+//
+// init, sent once when the process starts. The process replies with an empty
+// object, or one holding an error if it can't run:
+//
+//	> {"event": "init", "operation": "upload", "concurrent": true, "concurrenttransfers": 3}
+//	< {}
+//	< {"error": {"code": 32, "message": "Some init failure message"}}
+//
+// upload, to upload the object stored at path using the action the batch API
+// gave it:
+//
+//	> {"event": "upload", "oid": "bf3e3e2a...", "size": 346232, "path": "/path/to/file.bin",
+//	   "action": {"href": "nfs://server/path", "header": {"key": "value"}}}
+//
+// download, to download an object. The process writes it to a file of its
+// choosing, which Git LFS checks and moves into place:
+//
+//	> {"event": "download", "oid": "22ab5f63...", "size": 21245,
+//	   "action": {"href": "nfs://server/path", "header": {"key": "value"}}}
+//
+// progress, any number of which the process may send while transferring an
+// object. bytesSoFar is the total transferred so far, and bytesSinceLast how
+// many bytes that is on from the last progress message:
+//
+//	< {"event": "progress", "oid": "22ab5f63...", "bytesSoFar": 1234, "bytesSinceLast": 64}
+//
+// complete, which the process sends once it has finished with an object.
+// Downloads give the path of the file. A failed transfer gives an error
+// instead, and the process carries on with the next one:
+//
+//	< {"event": "complete", "oid": "bf3e3e2a..."}
+//	< {"event": "complete", "oid": "22ab5f63...", "path": "/tmp/22ab5f63"}
+//	< {"event": "complete", "oid": "22ab5f63...", "error": {"code": 2, "message": "Explain what happened"}}
+//
+// terminate, sent when there's nothing left to transfer. The process should
+// exit without replying:
+//
+//	> {"event": "terminate"}
+//
+// A process is only sent one object at a time, and always gets a complete
+// message back for it before the next one. Git LFS runs several processes to
+// transfer objects concurrently.
+package transfer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/github/git-lfs/subprocess"
+	"github.com/github/git-lfs/trace"
+)
+
+// Config describes a custom adapter, from lfs.customtransfer.<name>.*.
+type Config struct {
+	Name       string
+	Path       string
+	Args       string
+	Concurrent bool
+	Direction  string // "upload", "download" or "both"
+}
+
+// Handles reports whether the adapter transfers objects in the given
+// direction, "upload" or "download".
+func (c *Config) Handles(direction string) bool {
+	return c.Direction == "both" || c.Direction == direction
+}
+
+// Action is where the batch API said to transfer an object to or from.
+type Action struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+// Error is an error reported by an adapter process.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.Code, e.Message)
+}
+
+// ProgressCallback is called with each progress message for an object.
+type ProgressCallback func(bytesSoFar int64, bytesSinceLast int) error
+
+type request struct {
+	Event               string  `json:"event"`
+	Operation           string  `json:"operation,omitempty"`
+	Concurrent          bool    `json:"concurrent,omitempty"`
+	ConcurrentTransfers int     `json:"concurrenttransfers,omitempty"`
+	Oid                 string  `json:"oid,omitempty"`
+	Size                int64   `json:"size,omitempty"`
+	Path                string  `json:"path,omitempty"`
+	Action              *Action `json:"action,omitempty"`
+}
+
+type response struct {
+	Event          string `json:"event"`
+	Oid            string `json:"oid"`
+	Path           string `json:"path"`
+	BytesSoFar     int64  `json:"bytesSoFar"`
+	BytesSinceLast int    `json:"bytesSinceLast"`
+	Error          *Error `json:"error"`
+}
+
+// Adapter transfers objects through the processes of a custom adapter.
+type Adapter struct {
+	config    *Config
+	operation string
+	workers   int
+	procs     chan *process // the processes not transferring an object
+	mutex     sync.Mutex
+	started   []*process
+}
+
+// NewAdapter returns an Adapter for the configured custom adapter. No process
+// is started until Begin is called.
+func NewAdapter(config *Config) *Adapter {
+	return &Adapter{config: config}
+}
+
+// Name returns the name of the adapter, as offered to the batch API.
+func (a *Adapter) Name() string {
+	return a.config.Name
+}
+
+// Begin starts the processes for transferring objects in the direction of
+// operation, one for each of workers if the adapter is concurrent.
+func (a *Adapter) Begin(operation string, workers int) error {
+	a.operation = operation
+	a.workers = workers
+
+	n := 1
+	if a.config.Concurrent {
+		n = workers
+	}
+
+	a.procs = make(chan *process, n)
+	for i := 0; i < n; i++ {
+		p, err := a.start()
+		if err != nil {
+			a.End()
+			return err
+		}
+		a.procs <- p
+	}
+	return nil
+}
+
+// Upload uploads the object stored at path.
+func (a *Adapter) Upload(oid string, size int64, path string, action *Action, cb ProgressCallback) error {
+	_, err := a.transfer(&request{Event: "upload", Oid: oid, Size: size, Path: path, Action: action}, cb)
+	return err
+}
+
+// Download downloads an object, returning the path of the file the process
+// wrote it to.
+func (a *Adapter) Download(oid string, size int64, action *Action, cb ProgressCallback) (string, error) {
+	res, err := a.transfer(&request{Event: "download", Oid: oid, Size: size, Action: action}, cb)
+	if err != nil {
+		return "", err
+	}
+	if len(res.Path) == 0 {
+		return "", fmt.Errorf("custom transfer %s: no path for downloaded object %s", a.config.Name, oid)
+	}
+	return res.Path, nil
+}
+
+// End tells every process to exit, and waits for them to.
+func (a *Adapter) End() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, p := range a.started {
+		p.terminate()
+	}
+	a.started = nil
+}
+
+// transfer sends req to the next free process, passing progress messages to
+// cb until the object is complete.
+func (a *Adapter) transfer(req *request, cb ProgressCallback) (*response, error) {
+	p := <-a.procs
+
+	res, err := p.transfer(req, cb)
+	if err != nil {
+		// the process can't be relied on after this
+		trace.Transfer.Printf("custom transfer %s: restarting process after %s", a.config.Name, err)
+		p.kill()
+		if np, startErr := a.start(); startErr == nil {
+			p = np
+		} else {
+			p = &process{err: startErr}
+		}
+	}
+
+	a.procs <- p
+
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != nil {
+		return res, res.Error
+	}
+	return res, nil
+}
+
+// start runs a new process, and initialises it.
+func (a *Adapter) start() (*process, error) {
+	cmd := subprocess.ExecCommand(a.config.Path, strings.Fields(a.config.Args)...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	trace.Transfer.Printf("custom transfer %s: starting %s %s", a.config.Name, a.config.Path, a.config.Args)
+	if err := subprocess.Start(cmd); err != nil {
+		return nil, fmt.Errorf("custom transfer %s: %s", a.config.Name, err)
+	}
+
+	p := &process{name: a.config.Name, cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}
+
+	a.mutex.Lock()
+	a.started = append(a.started, p)
+	a.mutex.Unlock()
+
+	res, err := p.exchange(&request{
+		Event:               "init",
+		Operation:           a.operation,
+		Concurrent:          a.config.Concurrent,
+		ConcurrentTransfers: a.workers,
+	})
+	if err == nil && res.Error != nil {
+		err = res.Error
+	}
+	if err != nil {
+		p.kill()
+		return nil, fmt.Errorf("custom transfer %s: failed to initialise: %s", a.config.Name, err)
+	}
+
+	return p, nil
+}
+
+type process struct {
+	name   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	err    error // why the process couldn't be started, if it wasn't
+	exited bool
+}
+
+func (p *process) transfer(req *request, cb ProgressCallback) (*response, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	if err := p.send(req); err != nil {
+		return nil, err
+	}
+
+	for {
+		res, err := p.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		if res.Oid != req.Oid {
+			return nil, fmt.Errorf("custom transfer %s: got %q for %s while transferring %s", p.name, res.Event, res.Oid, req.Oid)
+		}
+
+		switch res.Event {
+		case "progress":
+			if cb != nil {
+				if err := cb(res.BytesSoFar, res.BytesSinceLast); err != nil {
+					return nil, err
+				}
+			}
+		case "complete":
+			return res, nil
+		default:
+			return nil, fmt.Errorf("custom transfer %s: unexpected event %q", p.name, res.Event)
+		}
+	}
+}
+
+// exchange sends req, and returns the next message from the process.
+func (p *process) exchange(req *request) (*response, error) {
+	if err := p.send(req); err != nil {
+		return nil, err
+	}
+	return p.receive()
+}
+
+func (p *process) send(req *request) error {
+	by, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	trace.Transfer.Printf("custom transfer %s: > %s", p.name, by)
+	by = append(by, '\n')
+	if _, err := p.stdin.Write(by); err != nil {
+		return fmt.Errorf("custom transfer %s: %s", p.name, err)
+	}
+	return nil
+}
+
+func (p *process) receive() (*response, error) {
+	line, err := p.stdout.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("custom transfer %s: process exited unexpectedly", p.name)
+		}
+		return nil, fmt.Errorf("custom transfer %s: %s", p.name, err)
+	}
+
+	trace.Transfer.Printf("custom transfer %s: < %s", p.name, strings.TrimSpace(string(line)))
+	res := &response{}
+	if err := json.Unmarshal(line, res); err != nil {
+		return nil, fmt.Errorf("custom transfer %s: invalid message %q: %s", p.name, strings.TrimSpace(string(line)), err)
+	}
+	return res, nil
+}
+
+// terminate asks the process to exit, and waits for it.
+func (p *process) terminate() {
+	if p.err != nil || p.exited {
+		return
+	}
+	p.send(&request{Event: "terminate"})
+	p.stdin.Close()
+	p.cmd.Wait()
+	p.exited = true
+}
+
+func (p *process) kill() {
+	if p.err != nil || p.exited {
+		return
+	}
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	p.exited = true
+}
@@ -0,0 +1,157 @@
+package transfer_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/github/git-lfs/transfer"
+	"github.com/github/git-lfs/vendor/_nuts/github.com/technoweenie/assert"
+)
+
+func TestCustomAdapterUpload(t *testing.T) {
+	a := newTestAdapter(t, true, "")
+	assert.Equal(t, nil, a.Begin("upload", 2))
+	defer a.End()
+
+	var progress []int64
+	var mutex sync.Mutex
+	cb := func(soFar int64, sinceLast int) error {
+		mutex.Lock()
+		progress = append(progress, soFar)
+		mutex.Unlock()
+		return nil
+	}
+
+	action := &transfer.Action{Href: "nfs://server/abc", Header: map[string]string{"Key": "value"}}
+	assert.Equal(t, nil, a.Upload("abc", 10, "/path/to/abc", action, cb))
+	assert.Equal(t, []int64{5, 10}, progress)
+
+	err := a.Upload("fail", 10, "/path/to/fail", action, nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.Equal(t, "Error 2: cannot upload fail", err.Error())
+
+	// a process which dies is replaced
+	if err := a.Upload("crash", 10, "/path/to/crash", action, nil); err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.Equal(t, nil, a.Upload("after-crash", 10, "/path/to/after", action, nil))
+}
+
+func TestCustomAdapterDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs-custom-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newTestAdapter(t, false, dir)
+	assert.Equal(t, nil, a.Begin("download", 3))
+
+	var wg sync.WaitGroup
+	paths := make([]string, 4)
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path, err := a.Download(fmt.Sprintf("oid%d", i), 10, &transfer.Action{Href: "nfs://server"}, nil)
+			assert.Equal(t, nil, err)
+			paths[i] = path
+		}(i)
+	}
+	wg.Wait()
+	a.End()
+
+	for i, path := range paths {
+		by, err := ioutil.ReadFile(path)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, fmt.Sprintf("content of oid%d", i), string(by))
+	}
+}
+
+func TestCustomAdapterInitFailure(t *testing.T) {
+	a := newTestAdapter(t, true, "")
+	err := a.Begin("refuse", 2)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.Equal(t, "custom transfer test: failed to initialise: Error 32: refusing to refuse", err.Error())
+}
+
+func newTestAdapter(t *testing.T, concurrent bool, dir string) *transfer.Adapter {
+	args := "-test.run=TestHelperAdapter -- adapter"
+	if len(dir) > 0 {
+		args += " " + dir
+	}
+
+	return transfer.NewAdapter(&transfer.Config{
+		Name:       "test",
+		Path:       os.Args[0],
+		Args:       args,
+		Concurrent: concurrent,
+		Direction:  "both",
+	})
+}
+
+// TestHelperAdapter isn't a real test. It's run by the tests above as a custom
+// adapter process.
+func TestHelperAdapter(t *testing.T) {
+	var args []string
+	for i, arg := range os.Args {
+		if arg == "--" {
+			args = os.Args[i+1:]
+			break
+		}
+	}
+	if len(args) == 0 || args[0] != "adapter" {
+		return
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			os.Exit(2)
+		}
+
+		oid, _ := req["oid"].(string)
+		switch req["event"] {
+		case "init":
+			if req["operation"] == "refuse" {
+				out.Encode(map[string]interface{}{"error": map[string]interface{}{"code": 32, "message": "refusing to refuse"}})
+			} else {
+				out.Encode(map[string]interface{}{})
+			}
+		case "upload":
+			switch oid {
+			case "fail":
+				out.Encode(map[string]interface{}{"event": "complete", "oid": oid, "error": map[string]interface{}{"code": 2, "message": "cannot upload fail"}})
+				continue
+			case "crash":
+				os.Exit(1)
+			}
+			out.Encode(map[string]interface{}{"event": "progress", "oid": oid, "bytesSoFar": 5, "bytesSinceLast": 5})
+			out.Encode(map[string]interface{}{"event": "progress", "oid": oid, "bytesSoFar": 10, "bytesSinceLast": 5})
+			out.Encode(map[string]interface{}{"event": "complete", "oid": oid})
+		case "download":
+			path := filepath.Join(args[1], oid)
+			ioutil.WriteFile(path, []byte("content of "+oid), 0644)
+			out.Encode(map[string]interface{}{"event": "complete", "oid": oid, "path": path})
+		case "terminate":
+			os.Exit(0)
+		default:
+			fmt.Fprintln(os.Stderr, "unknown event: "+strings.TrimSpace(scanner.Text()))
+			os.Exit(3)
+		}
+	}
+	os.Exit(0)
+}